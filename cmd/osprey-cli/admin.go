@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bharatmehan/osprey/pkg/client"
+)
+
+const adminUsage = `Usage: admin <subcommand>
+
+Subcommands:
+  bgsave                     Write a snapshot in the background
+  walrotate                  Start a new WAL file and print the old one's name
+  config get <pattern>       Show settings matching a glob pattern
+  config set <name> <value>  Change a setting at runtime
+  client list                List connected clients
+  client kill <id>           Close a client's connection
+  readonly on|off            Refuse or accept writes
+  shutdown [save|nosave]     Shut the server down
+`
+
+// handleAdmin runs the admin subcommands, which need an admin connection:
+// a loopback one, or -auth with the server's admin_password
+func handleAdmin(c *client.Client, args []string) {
+	usage := func() {
+		fmt.Fprint(os.Stderr, adminUsage)
+		os.Exit(1)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	sub, rest := strings.ToLower(args[0]), args[1:]
+	switch {
+	case sub == "bgsave" && len(rest) == 0:
+		printOK(c.BgSave())
+	case sub == "walrotate" && len(rest) == 0:
+		handleWAL(c.WALRotate)
+	case sub == "config" && len(rest) == 2 && strings.ToLower(rest[0]) == "get":
+		handleConfigGet(c, rest[1])
+	case sub == "config" && len(rest) == 3 && strings.ToLower(rest[0]) == "set":
+		printOK(c.ConfigSet(rest[1], rest[2]))
+	case sub == "client" && len(rest) == 1 && strings.ToLower(rest[0]) == "list":
+		handleClients(c)
+	case sub == "client" && len(rest) == 2 && strings.ToLower(rest[0]) == "kill":
+		id, err := strconv.ParseUint(rest[1], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid client id: %s\n", rest[1])
+			os.Exit(1)
+		}
+		resp, err := c.ClientKill(id)
+		if err == nil && !resp.Success && resp.Error == "" {
+			fmt.Fprintf(os.Stderr, "No client with id %d\n", id)
+			os.Exit(1)
+		}
+		printOK(resp, err)
+	case sub == "readonly" && len(rest) == 1:
+		switch strings.ToLower(rest[0]) {
+		case "on":
+			printOK(c.ReadOnly(true))
+		case "off":
+			printOK(c.ReadOnly(false))
+		default:
+			usage()
+		}
+	case sub == "shutdown":
+		handleShutdown(c, rest)
+	default:
+		usage()
+	}
+}
+
+func handleConfigGet(c *client.Client, pattern string) {
+	settings, err := c.ConfigGet(pattern)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s = %s\n", name, settings[name])
+	}
+}
+
+// printOK prints OK for a successful reply, or the error
+func printOK(resp *client.Response, err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if resp.Success {
+		fmt.Println("OK")
+	} else {
+		fmt.Printf("ERR %s\n", resp.Error)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bharatmehan/osprey/pkg/client"
+	"github.com/bharatmehan/osprey/pkg/command"
+)
+
+// cliCommand is a command as osprey-cli offers it. Most come straight from
+// the server's command table; commands the table doesn't cover, or that
+// the CLI takes different arguments for, are listed in cliOverrides.
+type cliCommand struct {
+	name    string
+	summary string
+	usage   string
+	own     bool // takes CLI-specific arguments, which the table can't check
+	hidden  bool // not offered from the CLI
+}
+
+// cliOverrides are the commands that differ from their server command,
+// plus those only the CLI has
+var cliOverrides = map[string]cliCommand{
+	"get":      {usage: "get <key>"},
+	"set":      {usage: "set <key> <value> [EX <ms>] [PXAT <ms>] [--ttl <dur>] [--expire-at <time>] [NX|XX] [VER <n>] [NOSYNC]", own: true},
+	"append":   {usage: "append <key> <value>", own: true},
+	"setrange": {usage: "setrange <key> <offset> <value>", own: true},
+	"cas":      {usage: "cas <key> <expected> <value>", own: true},
+	"hset":     {usage: "hset <key> <field> <value> [field value...]", own: true},
+	"expire":   {usage: "expire <key> <ttl_ms> | --ttl <dur> | --expire-at <time>", own: true},
+	"scan":     {usage: "scan [pattern]", own: true},
+	"latency":  {usage: "latency [history <event> | reset [event...]]", own: true},
+	"publish":  {usage: "publish <channel> <message>", own: true},
+	"stats":    {usage: "stats [reset|verbose]"},
+	"shutdown": {usage: "shutdown [save|nosave]"},
+
+	// Connection setup is done with flags, SYNC streams files, and a
+	// subscription lasts as long as the CLI runs
+	"hello":       {hidden: true},
+	"auth":        {hidden: true},
+	"sync":        {hidden: true},
+	"unsubscribe": {hidden: true},
+
+	"admin":      {summary: "Run an administrative action, see help admin", usage: "admin <subcommand> [args...]", own: true},
+	"clients":    {summary: "List connected clients", usage: "clients", own: true},
+	"completion": {summary: "Print a shell completion script", usage: "completion bash|zsh|fish", own: true},
+	"help":       {summary: "Show usage for all commands or one", usage: "help [command]", own: true},
+}
+
+// cliCommands returns the commands osprey-cli offers, sorted by name.
+// Server commands with a payload are only offered if the CLI handles them.
+func cliCommands() []cliCommand {
+	var cmds []cliCommand
+	for _, spec := range command.Specs() {
+		name := strings.ToLower(spec.Name)
+		cmd, overridden := cliOverrides[name]
+		if cmd.hidden || (spec.Has(command.Payload) && !overridden) {
+			continue
+		}
+		cmd.name, cmd.summary = name, spec.Summary
+		if cmd.usage == "" {
+			words := strings.Fields(spec.Usage)
+			for i, w := range words {
+				if w == spec.Name {
+					words[i] = name
+				}
+			}
+			cmd.usage = strings.Join(words, " ")
+		}
+		cmds = append(cmds, cmd)
+	}
+	for name, cmd := range cliOverrides {
+		if _, ok := command.Lookup(name); !ok {
+			cmd.name = name
+			cmds = append(cmds, cmd)
+		}
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].name < cmds[j].name })
+	return cmds
+}
+
+// lookupCLI returns the command osprey-cli offers as name
+func lookupCLI(name string) (cliCommand, bool) {
+	for _, cmd := range cliCommands() {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return cliCommand{}, false
+}
+
+// checkArgs validates args against the command table before connecting,
+// for commands that take the same arguments as their server command
+func checkArgs(name string, args []string) {
+	cmd, ok := lookupCLI(name)
+	if !ok || cmd.own {
+		return
+	}
+	if err := command.Check(append([]string{name}, args...)); err != nil {
+		if !errors.Is(err, command.ErrWrongArgs) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", cmd.usage)
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	cmds := cliCommands()
+	width := 0
+	for _, cmd := range cmds {
+		width = max(width, len(cmd.usage))
+	}
+	width = min(width, 48)
+
+	fmt.Println("Usage: osprey-cli [options] <command> [args...]")
+	fmt.Println("\nCommands:")
+	for _, cmd := range cmds {
+		if len(cmd.usage) > width {
+			fmt.Printf("  %s\n  %-*s  %s\n", cmd.usage, width, "", cmd.summary)
+		} else {
+			fmt.Printf("  %-*s  %s\n", width, cmd.usage, cmd.summary)
+		}
+	}
+	fmt.Println("\nOptions:")
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.PrintDefaults()
+}
+
+func handleHelp(args []string) {
+	if len(args) == 0 {
+		printUsage()
+		return
+	}
+	cmd, ok := lookupCLI(strings.ToLower(args[0]))
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
+		os.Exit(1)
+	}
+	if cmd.name == "admin" {
+		fmt.Print(adminUsage)
+		return
+	}
+	fmt.Printf("Usage: %s\n\n%s\n", cmd.usage, cmd.summary)
+}
+
+// handleRaw sends a command the CLI has no handler for and prints the
+// reply as it arrives
+func handleRaw(c *client.Client, name string, args []string) {
+	lines, err := c.DoLines(append([]string{strings.ToUpper(name)}, args...)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	if strings.HasPrefix(lines[0], "ERR ") {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// handleCompletion prints a completion script for shell, completing
+// command names and options. Load it with, for example:
+//
+//	source <(osprey-cli completion bash)
+func handleCompletion(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: completion bash|zsh|fish\n")
+		os.Exit(1)
+	}
+
+	cmds := cliCommands()
+	switch args[0] {
+	case "bash":
+		bashCompletion(cmds)
+	case "zsh":
+		zshCompletion(cmds)
+	case "fish":
+		fishCompletion(cmds)
+	default:
+		fmt.Fprintf(os.Stderr, "Unsupported shell: %s (want bash, zsh or fish)\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// isBoolFlag reports whether f takes no value
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func bashCompletion(cmds []cliCommand) {
+	var names, flags, valueFlags []string
+	for _, cmd := range cmds {
+		names = append(names, cmd.name)
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, "-"+f.Name)
+		if !isBoolFlag(f) {
+			valueFlags = append(valueFlags, "-"+f.Name)
+		}
+	})
+
+	fmt.Printf(`# bash completion for osprey-cli
+_osprey_cli() {
+    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+    local commands="%s"
+    case " %s " in *" $prev "*) return ;; esac
+    if [[ $cur == -* ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+        return
+    fi
+    local w
+    for w in "${COMP_WORDS[@]:1:COMP_CWORD-1}"; do
+        case " $commands " in *" $w "*) return ;; esac
+    done
+    COMPREPLY=($(compgen -W "$commands" -- "$cur"))
+}
+complete -o default -F _osprey_cli osprey-cli
+`, strings.Join(names, " "), strings.Join(valueFlags, " "), strings.Join(flags, " "))
+}
+
+// zshQuote escapes s for a single-quoted zsh word
+func zshQuote(s string) string {
+	return strings.ReplaceAll(s, "'", `'\''`)
+}
+
+func zshCompletion(cmds []cliCommand) {
+	fmt.Println("#compdef osprey-cli")
+	fmt.Println("_osprey_cli() {")
+	fmt.Println("  local -a commands")
+	fmt.Println("  commands=(")
+	for _, cmd := range cmds {
+		fmt.Printf("    '%s:%s'\n", cmd.name, zshQuote(cmd.summary))
+	}
+	fmt.Println("  )")
+	fmt.Println("  _arguments -s \\")
+	flag.VisitAll(func(f *flag.Flag) {
+		usage := strings.NewReplacer("[", `\[`, "]", `\]`).Replace(zshQuote(f.Usage))
+		if isBoolFlag(f) {
+			fmt.Printf("    '-%s[%s]' \\\n", f.Name, usage)
+		} else {
+			fmt.Printf("    '-%s[%s]:%s:' \\\n", f.Name, usage, f.Name)
+		}
+	})
+	fmt.Println("    '1:command:->command' \\")
+	fmt.Println("    '*::argument:_default'")
+	fmt.Println("  case $state in")
+	fmt.Println("    command) _describe 'command' commands ;;")
+	fmt.Println("  esac")
+	fmt.Println("}")
+	fmt.Println(`_osprey_cli "$@"`)
+}
+
+// fishQuote escapes s for a single-quoted fish string
+func fishQuote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s)
+}
+
+func fishCompletion(cmds []cliCommand) {
+	fmt.Println("# fish completion for osprey-cli")
+	for _, cmd := range cmds {
+		fmt.Printf("complete -c osprey-cli -n __fish_use_subcommand -f -a %s -d '%s'\n", cmd.name, fishQuote(cmd.summary))
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		value := " -r"
+		if isBoolFlag(f) {
+			value = ""
+		}
+		fmt.Printf("complete -c osprey-cli -o %s%s -d '%s'\n", f.Name, value, fishQuote(f.Usage))
+	})
+}
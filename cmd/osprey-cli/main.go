@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bharatmehan/osprey/pkg/client"
 )
@@ -16,49 +21,106 @@ func main() {
 		address = flag.String("addr", "localhost:7070", "Server address")
 		output  = flag.String("out", "", "Output file for binary values")
 		input   = flag.String("in", "", "Input file for binary values (use '-' for stdin)")
+		hexOut  = flag.Bool("hex", false, "Print values as a hex dump")
+		auth    = flag.String("auth", "", "Admin password, proven with AUTHCHALLENGE and AUTHHMAC before the command")
+		jsonOut = flag.Bool("pretty-json", false, "Pretty-print JSON values")
+		trace   = flag.String("trace", "", "Trace ID to attach to the command")
 	)
 	flag.Parse()
 
+	format := formatRaw
+	switch {
+	case *hexOut && *jsonOut:
+		fmt.Fprintf(os.Stderr, "-hex and -pretty-json are mutually exclusive\n")
+		os.Exit(1)
+	case *hexOut:
+		format = formatHex
+	case *jsonOut:
+		format = formatJSON
+	}
+
 	if len(flag.Args()) == 0 {
-		fmt.Println("Usage: osprey-cli [options] <command> [args...]")
-		fmt.Println("\nCommands:")
-		fmt.Println("  ping")
-		fmt.Println("  get <key>")
-		fmt.Println("  set <key> <value> [EX <ms>] [PXAT <ms>] [NX|XX] [VER <n>]")
-		fmt.Println("  del <key>")
-		fmt.Println("  exists <key>")
-		fmt.Println("  expire <key> <ttl_ms>")
-		fmt.Println("  ttl <key>")
-		fmt.Println("  incr <key> [delta]")
-		fmt.Println("  decr <key> [delta]")
-		fmt.Println("  mget <key1> <key2> ...")
-		fmt.Println("  stats")
-		fmt.Println("\nOptions:")
-		fmt.Println("  -addr string    Server address (default \"localhost:7070\")")
-		fmt.Println("  -in string      Input file for binary values (use '-' for stdin)")
-		fmt.Println("  -out string     Output file for binary values")
-		os.Exit(1)
-	}
-
-	c, err := client.New(*address)
+		printUsage()
+		os.Exit(1)
+	}
+
+	cmd := strings.ToLower(flag.Args()[0])
+	args := flag.Args()[1:]
+
+	// These don't need a server
+	switch cmd {
+	case "help":
+		handleHelp(args)
+		return
+	case "completion":
+		handleCompletion(args)
+		return
+	}
+	checkArgs(cmd, args)
+
+	opts := []client.Option{client.WithWarnings()}
+	if *trace != "" {
+		opts = append(opts, client.WithTracing())
+	}
+	c, err := client.New(*address, opts...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to connect: %v\n", err)
 		os.Exit(1)
 	}
 	defer c.Close()
+	c.SetTraceID(*trace)
 
-	cmd := strings.ToLower(flag.Args()[0])
-	args := flag.Args()[1:]
+	if *auth != "" {
+		resp, err := c.AuthHMAC("", *auth)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !resp.Success {
+			fmt.Fprintf(os.Stderr, "ERR %s\n", resp.Error)
+			os.Exit(1)
+		}
+	}
 
 	switch cmd {
 	case "ping":
 		handlePing(c)
 	case "get":
-		handleGet(c, args, *output)
+		handleGet("get", c.Get, args, *output, format)
+	case "getmeta":
+		handleGetMeta(c, args)
 	case "set":
 		handleSet(c, args, *input)
+	case "append":
+		handleAppend(c, args, *input)
+	case "cas":
+		handleCAS(c, args, *input)
+	case "getrange":
+		if len(args) != 3 {
+			fmt.Fprintf(os.Stderr, "Usage: getrange <key> <start> <end>\n")
+			os.Exit(1)
+		}
+		start, err1 := strconv.ParseInt(args[1], 10, 64)
+		end, err2 := strconv.ParseInt(args[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			fmt.Fprintf(os.Stderr, "Invalid range: %s %s\n", args[1], args[2])
+			os.Exit(1)
+		}
+		getrange := func(key string) (*client.Response, error) { return c.GetRange(key, start, end) }
+		handleGet("getrange", getrange, args[:1], *output, format)
+	case "setrange":
+		handleSetRange(c, args, *input)
 	case "del":
 		handleDel(c, args)
+	case "getdel":
+		handleGet("getdel", c.GetDel, args, *output, format)
+	case "getex":
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "Usage: getex <key> [EX <ms>|PERSIST]\n")
+			os.Exit(1)
+		}
+		getex := func(key string) (*client.Response, error) { return c.GetEx(key, args[1:]...) }
+		handleGet("getex", getex, args[:1], *output, format)
 	case "exists":
 		handleExists(c, args)
 	case "expire":
@@ -70,12 +132,48 @@ func main() {
 	case "decr":
 		handleDecr(c, args)
 	case "mget":
-		handleMGet(c, args, *output)
+		handleMGet(c, args, format)
+	case "hset":
+		handleHSet(c, args)
+	case "hmget":
+		handleHMGet(c, args, format)
+	case "hgetall":
+		handleHGetAll(c, args, format)
+	case "publish":
+		handlePublish(c, args, *input)
+	case "subscribe":
+		handleSubscribe(c, args, format)
 	case "stats":
-		handleStats(c)
+		handleStats(c, args)
+	case "info":
+		handleInfo(c, args)
+	case "keys":
+		handleKeys(c, args)
+	case "randomkey":
+		handleRandomKey(c)
+	case "scan":
+		handleScan(c, args)
+	case "keytemp":
+		handleKeyTemp(c, args)
+	case "latency":
+		handleLatency(c, args)
+	case "clients":
+		handleClients(c)
+	case "admin":
+		handleAdmin(c, args)
+	case "shutdown":
+		handleShutdown(c, args)
+	case "walrotate":
+		handleWAL(c.WALRotate)
+	case "walsync":
+		handleWAL(c.WALSync)
 	default:
-		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", cmd)
-		os.Exit(1)
+		// Server commands the CLI has no handler for are sent as they are
+		if _, ok := lookupCLI(cmd); !ok {
+			fmt.Fprintf(os.Stderr, "Unknown command: %s\n", cmd)
+			os.Exit(1)
+		}
+		handleRaw(c, cmd, args)
 	}
 }
 
@@ -87,13 +185,13 @@ func handlePing(c *client.Client) {
 	fmt.Println("PONG")
 }
 
-func handleGet(c *client.Client, args []string, outputFile string) {
+func handleGet(name string, get func(string) (*client.Response, error), args []string, outputFile string, format valueFormat) {
 	if len(args) != 1 {
-		fmt.Fprintf(os.Stderr, "Usage: get <key>\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s <key>\n", name)
 		os.Exit(1)
 	}
 
-	resp, err := c.Get(args[0])
+	resp, err := get(args[0])
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -114,11 +212,36 @@ func handleGet(c *client.Client, args []string, outputFile string) {
 		}
 		fmt.Printf("Value written to %s\n", outputFile)
 	} else {
-		os.Stdout.Write(resp.Value)
-		fmt.Println()
+		printValue(resp.Value, format)
 	}
 }
 
+func handleGetMeta(c *client.Client, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: getmeta <key>\n")
+		os.Exit(1)
+	}
+
+	resp, err := c.GetMeta(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !resp.Success {
+		fmt.Println("NOT_FOUND")
+		return
+	}
+
+	fmt.Printf("type=%s\n", resp.ValueType)
+	fmt.Printf("size=%d\n", resp.Size)
+	fmt.Printf("version=%d\n", resp.Version)
+	fmt.Printf("expiry_ms=%d\n", resp.ExpiryMs)
+	fmt.Printf("ttl_ms=%d\n", resp.TTL)
+	fmt.Printf("created_ms=%d\n", resp.CreatedMs)
+	fmt.Printf("updated_ms=%d\n", resp.UpdatedMs)
+}
+
 func handleSet(c *client.Client, args []string, inputFile string) {
 	if len(args) < 2 {
 		fmt.Fprintf(os.Stderr, "Usage: set <key> <value> [options...]\n")
@@ -146,6 +269,12 @@ func handleSet(c *client.Client, args []string, inputFile string) {
 		options = args[2:]
 	}
 
+	options, err := translateExpiryFlags(options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
 	resp, err := c.Set(key, value, options...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -154,12 +283,117 @@ func handleSet(c *client.Client, args []string, inputFile string) {
 
 	if resp.Success {
 		fmt.Printf("OK %d\n", resp.Version)
+		printWarnings(resp)
+	} else {
+		fmt.Printf("ERR %s\n", resp.Error)
+		os.Exit(1)
+	}
+}
+
+func handleAppend(c *client.Client, args []string, inputFile string) {
+	if (inputFile == "" && len(args) != 2) || (inputFile != "" && len(args) != 1) {
+		fmt.Fprintf(os.Stderr, "Usage: append <key> <value>\n")
+		os.Exit(1)
+	}
+
+	var value []byte
+	if inputFile != "" {
+		value = readInput(inputFile)
+	} else {
+		value = []byte(args[1])
+	}
+
+	printLength(c.Append(args[0], value))
+}
+
+func handleCAS(c *client.Client, args []string, inputFile string) {
+	if (inputFile == "" && len(args) != 3) || (inputFile != "" && len(args) != 2) {
+		fmt.Fprintf(os.Stderr, "Usage: cas <key> <expected> <value>\n")
+		os.Exit(1)
+	}
+
+	var value []byte
+	if inputFile != "" {
+		value = readInput(inputFile)
+	} else {
+		value = []byte(args[2])
+	}
+
+	resp, err := c.CompareAndSwap(args[0], []byte(args[1]), value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if resp.Success {
+		fmt.Printf("OK %d\n", resp.Version)
+		printWarnings(resp)
 	} else {
 		fmt.Printf("ERR %s\n", resp.Error)
 		os.Exit(1)
 	}
 }
 
+func handleSetRange(c *client.Client, args []string, inputFile string) {
+	if (inputFile == "" && len(args) != 3) || (inputFile != "" && len(args) != 2) {
+		fmt.Fprintf(os.Stderr, "Usage: setrange <key> <offset> <value>\n")
+		os.Exit(1)
+	}
+	offset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid offset: %s\n", args[1])
+		os.Exit(1)
+	}
+
+	var value []byte
+	if inputFile != "" {
+		value = readInput(inputFile)
+	} else {
+		value = []byte(args[2])
+	}
+
+	printLength(c.SetRange(args[0], offset, value))
+}
+
+// readInput reads a value from a file, or from stdin for "-"
+func readInput(inputFile string) []byte {
+	var value []byte
+	var err error
+	if inputFile == "-" {
+		value, err = io.ReadAll(os.Stdin)
+	} else {
+		value, err = os.ReadFile(inputFile)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read input: %v\n", err)
+		os.Exit(1)
+	}
+	return value
+}
+
+// printLength prints an APPEND or SETRANGE reply: the new version and length
+func printLength(resp *client.Response, err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if resp.Success {
+		fmt.Printf("OK %d %d\n", resp.Version, resp.Integer)
+		printWarnings(resp)
+	} else {
+		fmt.Printf("ERR %s\n", resp.Error)
+		os.Exit(1)
+	}
+}
+
+// printWarnings reports the soft limits a write crossed
+func printWarnings(resp *client.Response) {
+	if len(resp.Warnings) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: soft limit crossed: %s\n", strings.Join(resp.Warnings, ", "))
+	}
+}
+
 func handleDel(c *client.Client, args []string) {
 	if len(args) != 1 {
 		fmt.Fprintf(os.Stderr, "Usage: del <key>\n")
@@ -199,12 +433,18 @@ func handleExists(c *client.Client, args []string) {
 }
 
 func handleExpire(c *client.Client, args []string) {
-	if len(args) != 2 {
-		fmt.Fprintf(os.Stderr, "Usage: expire <key> <ttl_ms>\n")
+	if len(args) < 2 || len(args) > 3 {
+		fmt.Fprintf(os.Stderr, "Usage: expire <key> <ttl_ms> | --ttl <dur> | --expire-at <time>\n")
 		os.Exit(1)
 	}
 
-	ttl, err := strconv.ParseInt(args[1], 10, 64)
+	var ttl int64
+	var err error
+	if len(args) == 2 {
+		ttl, err = strconv.ParseInt(args[1], 10, 64)
+	} else {
+		ttl, err = relativeTTL(args[1:])
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Invalid TTL: %v\n", err)
 		os.Exit(1)
@@ -298,7 +538,7 @@ func handleDecr(c *client.Client, args []string) {
 	}
 }
 
-func handleMGet(c *client.Client, args []string, outputFile string) {
+func handleMGet(c *client.Client, args []string, format valueFormat) {
 	if len(args) == 0 {
 		fmt.Fprintf(os.Stderr, "Usage: mget <key1> <key2> ...\n")
 		os.Exit(1)
@@ -313,23 +553,450 @@ func handleMGet(c *client.Client, args []string, outputFile string) {
 	for i, resp := range responses {
 		if resp.Success {
 			fmt.Printf("VALUE %s %d %d %d\n", args[i], len(resp.Value), resp.Version, resp.ExpiryMs)
-			os.Stdout.Write(resp.Value)
-			fmt.Println()
+			printValue(resp.Value, format)
 		} else {
 			fmt.Printf("NOT_FOUND %s\n", args[i])
 		}
 	}
 }
 
-func handleStats(c *client.Client) {
-	stats, err := c.Stats()
+func handleHSet(c *client.Client, args []string) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: hset <key> <field> <value> [field value...]\n")
+		os.Exit(1)
+	}
+
+	fields := make(map[string][]byte)
+	for i := 1; i < len(args); i += 2 {
+		fields[args[i]] = []byte(args[i+1])
+	}
+	printLength(c.HSet(args[0], fields))
+}
+
+func handleHMGet(c *client.Client, args []string, format valueFormat) {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: hmget <key> <field> [field...]\n")
+		os.Exit(1)
+	}
+
+	responses, err := c.HMGet(args[0], args[1:]...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for i, resp := range responses {
+		if resp.Success {
+			fmt.Printf("VALUE %s %d %d %d\n", args[i+1], len(resp.Value), resp.Version, resp.ExpiryMs)
+			printValue(resp.Value, format)
+		} else {
+			fmt.Printf("NOT_FOUND %s\n", args[i+1])
+		}
+	}
+}
+
+func handleHGetAll(c *client.Client, args []string, format valueFormat) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: hgetall <key>\n")
+		os.Exit(1)
+	}
+
+	fields, err := c.HGetAll(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("VALUE %s %d\n", name, len(fields[name]))
+		printValue(fields[name], format)
+	}
+}
+
+func handlePublish(c *client.Client, args []string, inputFile string) {
+	if (inputFile == "" && len(args) != 2) || (inputFile != "" && len(args) != 1) {
+		fmt.Fprintf(os.Stderr, "Usage: publish <channel> <message>\n")
+		os.Exit(1)
+	}
+
+	var message []byte
+	if inputFile != "" {
+		message = readInput(inputFile)
+	} else {
+		message = []byte(args[1])
+	}
+
+	receivers, err := c.Publish(args[0], message)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(receivers)
+}
+
+// handleSubscribe prints the messages published to channels until the
+// connection closes or the CLI is interrupted
+func handleSubscribe(c *client.Client, args []string, format valueFormat) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: subscribe <channel> [channel...]\n")
+		os.Exit(1)
+	}
+
+	sub, err := c.Subscribe(args...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+	for msg := range sub.Messages() {
+		fmt.Printf("MESSAGE %s %d\n", msg.Channel, len(msg.Payload))
+		printValue(msg.Payload, format)
+	}
+	if err := sub.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
 
-	for key, value := range stats {
-		fmt.Printf("%s=%s\n", key, value)
+func handleStats(c *client.Client, args []string) {
+	if len(args) == 1 && strings.ToLower(args[0]) == "reset" {
+		resp, err := c.StatsReset()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !resp.Success {
+			fmt.Printf("ERR %s\n", resp.Error)
+			os.Exit(1)
+		}
+		fmt.Printf("stats_epoch=%d\n", resp.Integer)
+		return
+	}
+	verbose := len(args) == 1 && strings.ToLower(args[0]) == "verbose"
+	if len(args) != 0 && !verbose {
+		fmt.Fprintf(os.Stderr, "Usage: stats [reset|verbose]\n")
+		os.Exit(1)
+	}
+
+	sections, err := c.StatsSections(verbose)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, section := range sections {
+		fmt.Printf("# %s\n", section.Name)
+		for _, f := range section.Fields {
+			if f.Help != "" {
+				fmt.Printf("%s=%s # %s\n", f.Name, f.Value, f.Help)
+			} else {
+				fmt.Printf("%s=%s\n", f.Name, f.Value)
+			}
+		}
 	}
 	fmt.Println("END")
 }
+
+func handleInfo(c *client.Client, args []string) {
+	if len(args) > 1 {
+		fmt.Fprintf(os.Stderr, "Usage: info [section]\n")
+		os.Exit(1)
+	}
+	section := ""
+	if len(args) == 1 {
+		section = args[0]
+	}
+
+	sections, err := c.Info(section)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, s := range sections {
+		fmt.Printf("# %s\n", s.Name)
+		for _, f := range s.Fields {
+			fmt.Printf("%s=%s\n", f.Name, f.Value)
+		}
+	}
+}
+
+func handleClients(c *client.Client) {
+	clients, err := c.ClientList()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, info := range clients {
+		fmt.Printf("%d\t%s\t%s\t%s\tage=%v idle=%v in=%d out=%d cmd=%s\n",
+			info.ID, info.Addr, orDash(info.Name), orDash(info.Lib), info.Age, info.Idle, info.In, info.Out, info.Cmd)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func handleLatency(c *client.Client, args []string) {
+	var err error
+	switch {
+	case len(args) == 0:
+		var events []client.LatencyEvent
+		if events, err = c.LatencyLatest(); err == nil {
+			for _, e := range events {
+				fmt.Printf("%-8s %s latest=%v max=%v\n", e.Event, e.Time.Format(time.RFC3339), e.Latest, e.Max)
+			}
+		}
+	case strings.ToLower(args[0]) == "history" && len(args) == 2:
+		var samples []client.LatencySample
+		if samples, err = c.LatencyHistory(args[1]); err == nil {
+			for _, s := range samples {
+				fmt.Printf("%s %v\n", s.Time.Format(time.RFC3339), s.Latency)
+			}
+		}
+	case strings.ToLower(args[0]) == "reset":
+		var resp *client.Response
+		if resp, err = c.LatencyReset(args[1:]...); err == nil && !resp.Success {
+			fmt.Printf("ERR %s\n", resp.Error)
+			os.Exit(1)
+		} else if err == nil {
+			fmt.Printf("RESET %d\n", resp.Integer)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Usage: latency [history <event> | reset [event...]]\n")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func handleKeys(c *client.Client, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: keys <pattern>\n")
+		os.Exit(1)
+	}
+
+	keys, truncated, err := c.Keys(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, key := range keys {
+		fmt.Println(key)
+	}
+	if truncated {
+		fmt.Fprintf(os.Stderr, "(truncated at %d keys)\n", len(keys))
+	}
+}
+
+func handleRandomKey(c *client.Client) {
+	key, found, err := c.RandomKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if found {
+		fmt.Println(key)
+	} else {
+		fmt.Println("NOT_FOUND")
+	}
+}
+
+func handleScan(c *client.Client, args []string) {
+	if len(args) > 1 {
+		fmt.Fprintf(os.Stderr, "Usage: scan [pattern]\n")
+		os.Exit(1)
+	}
+	pattern := "*"
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+
+	for cursor := "0"; ; {
+		keys, next, err := c.Scan(cursor, pattern, 1000)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		if next == "0" {
+			return
+		}
+		cursor = next
+	}
+}
+
+func handleKeyTemp(c *client.Client, args []string) {
+	if len(args) > 1 {
+		fmt.Fprintf(os.Stderr, "Usage: keytemp [samples]\n")
+		os.Exit(1)
+	}
+
+	samples := 5
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid sample count: %v\n", err)
+			os.Exit(1)
+		}
+		samples = n
+	}
+
+	buckets, err := c.KeyTemp(samples)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, b := range buckets {
+		fmt.Printf("%-5s keys=%d hits=%d samples=%s\n", b.Name, b.Keys, b.Hits, strings.Join(b.Samples, ","))
+	}
+}
+
+func handleShutdown(c *client.Client, args []string) {
+	if len(args) > 1 {
+		fmt.Fprintf(os.Stderr, "Usage: shutdown [save|nosave]\n")
+		os.Exit(1)
+	}
+
+	var mode []string
+	if len(args) == 1 {
+		mode = []string{strings.ToUpper(args[0])}
+	}
+
+	resp, err := c.Shutdown(mode...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if resp.Success {
+		fmt.Println("OK")
+	} else {
+		fmt.Printf("ERR %s\n", resp.Error)
+		os.Exit(1)
+	}
+}
+
+// handleWAL runs WALROTATE or WALSYNC and prints the WAL file name
+func handleWAL(run func() (*client.Response, error)) {
+	resp, err := run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if resp.Success {
+		fmt.Println(string(resp.Value))
+	} else {
+		fmt.Printf("ERR %s\n", resp.Error)
+		os.Exit(1)
+	}
+}
+
+// translateExpiryFlags rewrites the human-friendly --ttl <duration> and
+// --expire-at <RFC 3339 time> options into the protocol's EX and PXAT
+// millisecond arguments. Other options are passed through unchanged.
+func translateExpiryFlags(options []string) ([]string, error) {
+	var out []string
+	for i := 0; i < len(options); i++ {
+		switch options[i] {
+		case "--ttl", "-ttl":
+			if i+1 >= len(options) {
+				return nil, fmt.Errorf("--ttl requires a duration")
+			}
+			d, err := time.ParseDuration(options[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid --ttl %q: %v", options[i+1], err)
+			}
+			if d <= 0 {
+				return nil, fmt.Errorf("--ttl must be positive")
+			}
+			out = append(out, "EX", strconv.FormatInt(d.Milliseconds(), 10))
+			i++
+		case "--expire-at", "-expire-at":
+			if i+1 >= len(options) {
+				return nil, fmt.Errorf("--expire-at requires a time")
+			}
+			t, err := time.Parse(time.RFC3339, options[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid --expire-at %q: %v", options[i+1], err)
+			}
+			out = append(out, "PXAT", strconv.FormatInt(t.UnixMilli(), 10))
+			i++
+		default:
+			out = append(out, options[i])
+		}
+	}
+	return out, nil
+}
+
+// relativeTTL converts a --ttl or --expire-at option pair into the
+// millisecond TTL EXPIRE expects
+func relativeTTL(options []string) (int64, error) {
+	opts, err := translateExpiryFlags(options)
+	if err != nil {
+		return 0, err
+	}
+	if len(opts) != 2 {
+		return 0, fmt.Errorf("expected --ttl <dur> or --expire-at <time>")
+	}
+
+	ms, _ := strconv.ParseInt(opts[1], 10, 64)
+	switch opts[0] {
+	case "EX":
+		return ms, nil
+	case "PXAT":
+		ttl := ms - time.Now().UnixMilli()
+		if ttl <= 0 {
+			return 0, fmt.Errorf("expiry time %s is in the past", options[1])
+		}
+		return ttl, nil
+	default:
+		return 0, fmt.Errorf("expected --ttl <dur> or --expire-at <time>")
+	}
+}
+
+// valueFormat selects how get/mget print values
+type valueFormat int
+
+const (
+	formatRaw valueFormat = iota
+	formatHex
+	formatJSON
+)
+
+// printValue writes a value to stdout in the requested format. Values that
+// aren't valid JSON are hex-dumped in JSON mode rather than printed raw.
+func printValue(value []byte, format valueFormat) {
+	switch format {
+	case formatHex:
+		fmt.Print(hex.Dump(value))
+	case formatJSON:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, value, "", "  "); err != nil {
+			fmt.Fprintf(os.Stderr, "Value is not valid JSON: %v\n", err)
+			fmt.Print(hex.Dump(value))
+			return
+		}
+		buf.WriteTo(os.Stdout)
+		fmt.Println()
+	default:
+		os.Stdout.Write(value)
+		fmt.Println()
+	}
+}
@@ -0,0 +1,132 @@
+// Command osprey-compact shrinks a stopped server's data directory to a
+// single snapshot and an empty WAL, for example before copying it to
+// another host. It refuses to run while a server holds the directory.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/storage"
+)
+
+func main() {
+	var (
+		configPath = flag.String("config", "osprey.toml", "Path to configuration file")
+		instance   = flag.String("instance", "", "Compact the named [instances.<name>] section of the config file")
+		verbose    = flag.Bool("v", false, "Log recovery and snapshot progress")
+	)
+	flag.Parse()
+
+	var cfg *config.Config
+	var err error
+	if *instance != "" {
+		cfg, err = config.LoadInstance(*configPath, *instance)
+	} else {
+		cfg, err = config.LoadConfig(*configPath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	before, err := diskUsage(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", cfg.DataDir, err)
+		os.Exit(1)
+	}
+	if before == 0 {
+		fmt.Printf("No WAL or snapshot files in %s\n", cfg.DataDir)
+		return
+	}
+
+	keys, err := compact(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to compact %s: %v\n", cfg.DataDir, err)
+		os.Exit(1)
+	}
+
+	after, err := diskUsage(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", cfg.DataDir, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Compacted %s: %d keys\n", cfg.DataDir, keys)
+	fmt.Printf("Before: %s\n", formatBytes(before))
+	fmt.Printf("After:  %s\n", formatBytes(after))
+	fmt.Printf("Saved:  %s\n", formatBytes(before-after))
+}
+
+// compact recovers the store and writes it back as one snapshot, returning
+// the number of keys
+func compact(cfg *config.Config) (int, error) {
+	// Keep the background snapshot worker out of the way
+	cfg.EnableSnapshot = false
+
+	ps, err := storage.NewPersistentStore(cfg)
+	if err != nil {
+		return 0, err
+	}
+	keys := ps.Len()
+	if err := ps.Compact(); err != nil {
+		ps.Close()
+		return 0, err
+	}
+	return keys, ps.Close()
+}
+
+// diskUsage sums the sizes of the WAL and snapshot files
+func diskUsage(cfg *config.Config) (int64, error) {
+	dirs := []string{cfg.WALDirectory()}
+	if snapDir := cfg.SnapshotDirectory(); snapDir != cfg.WALDirectory() {
+		dirs = append(dirs, snapDir)
+	}
+
+	var total int64
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !strings.HasSuffix(name, ".oswal") && !strings.HasSuffix(name, ".osnap") {
+				continue
+			}
+			info, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				return 0, err
+			}
+			total += info.Size()
+		}
+	}
+	return total, nil
+}
+
+// formatBytes formats n with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit || m <= -unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,223 @@
+// Command osprey-dump reads osprey snapshot files offline. By default it
+// writes the live keys of a snapshot as SET commands in the wire protocol,
+// usable as a warmup_file. With -verify it checks the snapshot instead and
+// exits non-zero on any problem, for backup validation pipelines.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/storage"
+	"github.com/bharatmehan/osprey/pkg/client"
+)
+
+func main() {
+	var (
+		verify = flag.Bool("verify", false, "Check record CRCs and the entry count instead of dumping")
+		addr   = flag.String("addr", "", "With -verify, compare sampled keys against this server")
+		sample = flag.Int("sample", 100, "Keys to compare with -addr")
+		output = flag.String("out", "", "Write the dump to this file instead of stdout")
+	)
+	var f filter
+	flag.StringVar(&f.prefix, "prefix", "", "Only dump keys starting with this prefix")
+	flag.DurationVar(&f.minTTL, "min-ttl", 0, "Only dump keys with at least this long left to live")
+	flag.IntVar(&f.minSize, "min-size", 0, "Only dump values of at least this many bytes")
+	flag.IntVar(&f.maxSize, "max-size", 0, "Only dump values of at most this many bytes")
+	flag.DurationVar(&f.olderThan, "older-than", 0, "Only dump keys last written longer ago than this")
+	flag.DurationVar(&f.newerThan, "newer-than", 0, "Only dump keys last written within this long")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: osprey-dump [options] <snapshot>\n\nOptions:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+
+	if *verify {
+		if f.active() {
+			fmt.Fprintf(os.Stderr, "Filters only apply to dumps, not -verify\n")
+			os.Exit(2)
+		}
+		if err := runVerify(path, *addr, *sample); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := runDump(path, *output, &f); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		os.Exit(1)
+	}
+}
+
+// sampledEntry is a snapshot entry picked for comparison with a server
+type sampledEntry struct {
+	key   string
+	entry *storage.Entry
+}
+
+// runVerify checks the snapshot at path and, if addr is set, compares up
+// to n randomly sampled keys with the server there
+func runVerify(path, addr string, n int) error {
+	var samples []sampledEntry
+	seen := 0
+	info, err := storage.VerifySnapshot(path, func(key string, entry *storage.Entry) {
+		if addr == "" || entry.IsExpired() || storage.IsReserved(key) {
+			return
+		}
+		// Reservoir sampling keeps the picks uniform across the file
+		seen++
+		if len(samples) < n {
+			samples = append(samples, sampledEntry{key, entry})
+		} else if j := rand.Intn(seen); j < n {
+			samples[j] = sampledEntry{key, entry}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: OK, %d entries, record version %d\n", path, info.Count, info.Version)
+
+	if addr == "" {
+		return nil
+	}
+	return compare(addr, samples)
+}
+
+// compare reads the sampled keys from the server at addr. Keys updated
+// after the snapshot (a higher version on the server) are skipped; keys
+// missing or holding a different value at the same version are mismatches.
+// Hashes and sets are compared by version alone.
+func compare(addr string, samples []sampledEntry) error {
+	c, err := client.New(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	matched, updated, mismatched := 0, 0, 0
+	for _, s := range samples {
+		get, name := c.Get, "GET"
+		if s.entry.Type() != storage.TypeString {
+			get, name = c.GetMeta, "GETMETA"
+		}
+		resp, err := get(s.key)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", name, s.key, err)
+		}
+		switch {
+		case !resp.Success && s.entry.IsExpired():
+			// Expired while we were comparing
+			matched++
+		case !resp.Success:
+			fmt.Printf("MISSING %s\n", s.key)
+			mismatched++
+		case resp.Version > s.entry.Version:
+			updated++
+		case resp.Version != s.entry.Version || (s.entry.Type() == storage.TypeString && !bytes.Equal(resp.Value, s.entry.Value)):
+			fmt.Printf("MISMATCH %s: snapshot version %d, server version %d\n", s.key, s.entry.Version, resp.Version)
+			mismatched++
+		default:
+			matched++
+		}
+	}
+
+	fmt.Printf("Compared %d keys with %s: %d match, %d updated since, %d mismatched\n",
+		len(samples), addr, matched, updated, mismatched)
+	if mismatched > 0 {
+		return fmt.Errorf("%d of %d sampled keys differ from %s", mismatched, len(samples), addr)
+	}
+	return nil
+}
+
+// filter selects the keys a dump writes. Zero fields don't filter.
+type filter struct {
+	prefix    string
+	minTTL    time.Duration
+	minSize   int
+	maxSize   int
+	olderThan time.Duration
+	newerThan time.Duration
+}
+
+// active reports whether any field filters
+func (f *filter) active() bool {
+	return *f != filter{}
+}
+
+// match reports whether an entry passes the filter at nowMs. Keys without
+// an expiry pass any -min-ttl; entries written before timestamps were kept
+// count as last written at the epoch.
+func (f *filter) match(key string, entry *storage.Entry, nowMs int64) bool {
+	switch {
+	case !strings.HasPrefix(key, f.prefix):
+		return false
+	case f.minTTL > 0 && entry.ExpiryMs > 0 && entry.ExpiryMs-nowMs < f.minTTL.Milliseconds():
+		return false
+	case int(entry.SizeBytes) < f.minSize:
+		return false
+	case f.maxSize > 0 && int(entry.SizeBytes) > f.maxSize:
+		return false
+	case f.olderThan > 0 && nowMs-entry.UpdatedMs <= f.olderThan.Milliseconds():
+		return false
+	case f.newerThan > 0 && nowMs-entry.UpdatedMs > f.newerThan.Milliseconds():
+		return false
+	}
+	return true
+}
+
+// runDump writes the live keys of the snapshot at path that pass f as SET
+// commands, and reports how many were written to stderr
+func runDump(path, output string, f *filter) error {
+	var out io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	w := bufio.NewWriter(out)
+
+	var werr error
+	written, skipped := 0, 0
+	now := time.Now().UnixMilli()
+	_, err := storage.VerifySnapshot(path, func(key string, entry *storage.Entry) {
+		if werr != nil || entry.IsExpired() || storage.IsReserved(key) {
+			return
+		}
+		if !f.match(key, entry, now) {
+			skipped++
+			return
+		}
+		werr = storage.WriteCommands(w, key, entry)
+		written++
+	})
+	if err != nil {
+		return err
+	}
+	if werr != nil {
+		return werr
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if f.active() {
+		fmt.Fprintf(os.Stderr, "Dumped %d keys, %d filtered out\n", written, skipped)
+	}
+	return nil
+}
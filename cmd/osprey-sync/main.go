@@ -0,0 +1,211 @@
+// Command osprey-sync copies the keys that differ between two live
+// servers from one to the other, for repairing a drifted copy or seeding
+// staging from production. Keys are listed with SCAN and compared with
+// GETMETA, so only differing values are transferred.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/bharatmehan/osprey/pkg/client"
+)
+
+// stats counts what a sync did
+type stats struct {
+	scanned, same, missing, differed, deleted, failed int
+}
+
+// syncer copies differing keys from src to dst
+type syncer struct {
+	src, dst *client.Client
+	dryRun   bool
+	verbose  bool
+	stats    stats
+}
+
+func main() {
+	var (
+		from    = flag.String("from", "", "Address of the server to copy from")
+		to      = flag.String("to", "", "Address of the server to copy to")
+		match   = flag.String("match", "*", "Only sync keys matching this glob pattern")
+		count   = flag.Int("count", 500, "Keys listed per SCAN")
+		del     = flag.Bool("delete", false, "Delete matching keys on -to that -from doesn't have")
+		dryRun  = flag.Bool("dry-run", false, "Report differences without writing")
+		verbose = flag.Bool("v", false, "Print every key copied or deleted")
+	)
+	flag.Parse()
+
+	if *from == "" || *to == "" {
+		fmt.Fprintf(os.Stderr, "Usage: osprey-sync -from <addr> -to <addr> [options]\n\nOptions:\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	src, err := client.New(*from)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to %s: %v\n", *from, err)
+		os.Exit(1)
+	}
+	defer src.Close()
+	dst, err := client.New(*to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to %s: %v\n", *to, err)
+		os.Exit(1)
+	}
+	defer dst.Close()
+
+	s := &syncer{src: src, dst: dst, dryRun: *dryRun, verbose: *verbose}
+	err = scan(src, *match, *count, s.syncKey)
+	if err == nil && *del {
+		err = scan(dst, *match, *count, s.deleteKey)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Sync failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	copied, deleted := "copied", "deleted"
+	if *dryRun {
+		copied, deleted = "would copy", "would delete"
+	}
+	st := s.stats
+	fmt.Printf("Scanned %d keys: %d same, %s %d (%d missing, %d differed)",
+		st.scanned, st.same, copied, st.missing+st.differed, st.missing, st.differed)
+	if *del {
+		fmt.Printf(", %s %d", deleted, st.deleted)
+	}
+	fmt.Println()
+	if st.failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d keys failed\n", st.failed)
+		os.Exit(1)
+	}
+}
+
+// scan calls fn for every key matching pattern on c
+func scan(c *client.Client, pattern string, count int, fn func(key string) error) error {
+	cursor := "0"
+	for {
+		keys, next, err := c.Scan(cursor, pattern, count)
+		if err != nil {
+			return fmt.Errorf("SCAN on %s: %w", c.Address(), err)
+		}
+		for _, key := range keys {
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+		if next == "0" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// syncKey copies key to dst unless it already holds the same value and
+// expiry. Equal versions and sizes are taken as equal values, which holds
+// for copies made with SYNC; otherwise the values are compared.
+func (s *syncer) syncKey(key string) error {
+	s.stats.scanned++
+	sm, err := s.src.GetMeta(key)
+	if err != nil {
+		return err
+	}
+	if !sm.Success {
+		// Deleted or expired since the SCAN
+		return nil
+	}
+	dm, err := s.dst.GetMeta(key)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !dm.Success:
+		s.stats.missing++
+	case sm.ExpiryMs != dm.ExpiryMs || sm.Size != dm.Size:
+		s.stats.differed++
+	case sm.Version == dm.Version:
+		s.stats.same++
+		return nil
+	default:
+		same, err := s.sameValue(key)
+		if err != nil {
+			return err
+		}
+		if same {
+			s.stats.same++
+			return nil
+		}
+		s.stats.differed++
+	}
+	return s.copyKey(key)
+}
+
+// sameValue reports whether src and dst hold the same value for key
+func (s *syncer) sameValue(key string) (bool, error) {
+	a, err := s.src.Get(key)
+	if err != nil {
+		return false, err
+	}
+	b, err := s.dst.Get(key)
+	if err != nil {
+		return false, err
+	}
+	return a.Success == b.Success && bytes.Equal(a.Value, b.Value), nil
+}
+
+// copyKey writes src's value of key to dst, keeping its expiry
+func (s *syncer) copyKey(key string) error {
+	if s.verbose {
+		fmt.Printf("COPY %s\n", key)
+	}
+	if s.dryRun {
+		return nil
+	}
+
+	resp, err := s.src.Get(key)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return nil
+	}
+	var options []string
+	if resp.ExpiryMs > 0 {
+		options = append(options, "PXAT", strconv.FormatInt(resp.ExpiryMs, 10))
+	}
+	set, err := s.dst.Set(key, resp.Value, options...)
+	if err != nil {
+		return err
+	}
+	if !set.Success {
+		fmt.Fprintf(os.Stderr, "SET %s: %s\n", key, set.Error)
+		s.stats.failed++
+	}
+	return nil
+}
+
+// deleteKey deletes key from dst if src doesn't have it
+func (s *syncer) deleteKey(key string) error {
+	exists, err := s.src.Exists(key)
+	if err != nil {
+		return err
+	}
+	if exists.Success {
+		return nil
+	}
+
+	s.stats.deleted++
+	if s.verbose {
+		fmt.Printf("DEL %s\n", key)
+	}
+	if s.dryRun {
+		return nil
+	}
+	_, err = s.dst.Del(key)
+	return err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/daemon"
+	"github.com/bharatmehan/osprey/internal/server"
+	"github.com/bharatmehan/osprey/internal/storage"
+)
+
+const (
+	// How long the old process waits for the new one to load its
+	// configuration and report in
+	handoverTimeout = 30 * time.Second
+
+	// How long a new process waits for the old one to release data_dir
+	takeoverWait = time.Minute
+)
+
+// inheritedListener returns the socket passed by systemd or a handover
+// parent, if any
+func inheritedListener() (net.Listener, error) {
+	listeners, err := daemon.Listeners()
+	if err != nil || len(listeners) == 0 {
+		return nil, err
+	}
+
+	for _, extra := range listeners[1:] {
+		log.Printf("Ignoring extra inherited socket %s", extra.Addr())
+		extra.Close()
+	}
+	log.Printf("Using inherited socket %s", listeners[0].Addr())
+	return listeners[0], nil
+}
+
+// handOver starts a new copy of the server that inherits the listening
+// socket, and waits for it to report in. On success the caller shuts down,
+// which releases data_dir to the new process; connections arriving in
+// the meantime queue on the shared socket.
+func handOver(srv *server.Server, pidFile string) error {
+	f, err := srv.ListenerFile()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h, err := daemon.StartHandover([]*os.File{f})
+	if err != nil {
+		return err
+	}
+	log.Printf("Handing over to pid %d", h.Process.Pid)
+
+	if err := h.Wait(handoverTimeout); err != nil {
+		// Don't let a slow child take over after we carry on serving
+		h.Process.Kill()
+		return err
+	}
+
+	if _, err := daemon.Notify(fmt.Sprintf("MAINPID=%d", h.Process.Pid)); err != nil {
+		log.Printf("Failed to notify service manager: %v", err)
+	}
+	if pidFile != "" {
+		daemon.RemovePIDFile(pidFile)
+	}
+	return nil
+}
+
+// openServer creates the server, waiting up to wait for another process
+// to release data_dir. With a handoff the dataset is read from it, see
+// server.NewFromHandoff.
+func openServer(cfg *config.Config, wait time.Duration, handoff io.Reader) (*server.Server, error) {
+	deadline := time.Now().Add(wait)
+	logged := false
+	for {
+		srv, err := server.NewFromHandoff(cfg, handoff)
+		if !errors.Is(err, storage.ErrLocked) || time.Now().After(deadline) {
+			return srv, err
+		}
+		if !logged {
+			log.Printf("Waiting up to %v for the running process to release %s", wait, cfg.DataDir)
+			logged = true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -1,63 +1,317 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime/debug"
 	"syscall"
+	"time"
 
+	"github.com/bharatmehan/osprey/internal/backup"
 	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/daemon"
 	"github.com/bharatmehan/osprey/internal/logging"
 	"github.com/bharatmehan/osprey/internal/server"
+	"github.com/bharatmehan/osprey/internal/storage"
 )
 
 func main() {
-	var configPath string
+	var (
+		configPath string
+		pidFile    string
+		detach     bool
+		instance   string
+		all        bool
+		svcName    string
+		restore    bool
+		bootstrap  string
+		takeover   bool
+		upgrade    bool
+		migrate    bool
+	)
 	flag.StringVar(&configPath, "config", "osprey.toml", "Path to configuration file")
+	flag.StringVar(&pidFile, "pidfile", "", "Write the process id to this file")
+	flag.BoolVar(&detach, "daemon", false, "Detach from the terminal and run in the background")
+	flag.StringVar(&instance, "instance", "", "Run the named [instances.<name>] section of the config file")
+	flag.BoolVar(&all, "all", false, "Run every instance in the config file under a supervisor")
+	flag.StringVar(&svcName, "service-name", "osprey", "Service name when run by the Windows service manager")
+	flag.BoolVar(&restore, "restore", false, "Restore an empty data_dir from the newest [backup] set before starting")
+	flag.StringVar(&bootstrap, "bootstrap-from", "", "Seed an empty data_dir from a node (host:port) or bucket (s3://bucket/prefix) before starting")
+	flag.BoolVar(&takeover, "takeover", false, "Bind now and wait for the running process to release data_dir (use with reuse_port)")
+	flag.BoolVar(&upgrade, "upgrade", false, "Take over from the running process through its upgrade socket, receiving its dataset instead of recovering")
+	flag.BoolVar(&migrate, "migrate-data", false, "Upgrade data_dir to this build's on-disk format and exit")
 	flag.Parse()
 
-	cfg, err := config.LoadConfig(configPath)
+	if all && instance != "" {
+		log.Fatalf("-all and -instance are mutually exclusive")
+	}
+	if restore && bootstrap != "" {
+		log.Fatalf("-restore and -bootstrap-from are mutually exclusive")
+	}
+	if takeover && (restore || bootstrap != "") {
+		log.Fatalf("-takeover can't be combined with -restore or -bootstrap-from")
+	}
+	if upgrade && (all || takeover || restore || bootstrap != "") {
+		log.Fatalf("-upgrade can't be combined with -all, -takeover, -restore or -bootstrap-from")
+	}
+	if migrate && (all || detach || takeover || upgrade || restore || bootstrap != "") {
+		log.Fatalf("-migrate-data can't be combined with -all, -daemon, -takeover, -upgrade, -restore or -bootstrap-from")
+	}
+
+	if detach && !daemon.IsChild() {
+		if err := daemon.Detach(); err != nil {
+			log.Fatalf("Failed to daemonize: %v", err)
+		}
+		return
+	}
+
+	if all {
+		if err := superviseInstances(configPath, pidFile); err != nil {
+			log.Fatalf("Supervisor error: %v", err)
+		}
+		return
+	}
+
+	var cfg *config.Config
+	var err error
+	if instance != "" {
+		cfg, err = config.LoadInstance(configPath, instance)
+	} else {
+		cfg, err = config.LoadConfig(configPath)
+	}
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	if migrate {
+		from, err := storage.MigrateData(cfg)
+		if err != nil {
+			log.Fatalf("Failed to migrate %s: %v", cfg.DataDir, err)
+		}
+		if from == storage.DataFormat {
+			log.Printf("%s is already at data format %d", cfg.DataDir, storage.DataFormat)
+		} else {
+			log.Printf("Migrated %s from data format %d to %d", cfg.DataDir, from, storage.DataFormat)
+		}
+		return
+	}
+
 	// Initialize logging
 	logPath := cfg.LogFile
 	if logPath == "" {
 		// Default to data/logs/osprey.log if not specified
 		logPath = filepath.Join(cfg.DataDir, "logs", "osprey.log")
 	}
-	
+
 	if err := logging.InitLogger(logPath, cfg.LogLevel); err != nil {
 		log.Fatalf("Failed to initialize logging: %v", err)
 	}
 	defer logging.CloseLogger()
 
-	log.Printf("Starting Osprey server with config: %s", configPath)
+	if instance != "" {
+		log.Printf("Starting Osprey instance %s with config: %s", instance, configPath)
+	} else {
+		log.Printf("Starting Osprey server with config: %s", configPath)
+	}
 	log.Printf("Log file: %s", logPath)
 
-	srv, err := server.New(cfg)
+	applyRuntimeTuning(cfg)
+
+	// A process started by a handover inherits its parent's arguments but
+	// not the empty data_dir that -restore and -bootstrap-from need
+	handover := daemon.IsHandover()
+
+	listener, err := inheritedListener()
+	if err != nil {
+		log.Fatalf("Failed to use inherited socket: %v", err)
+	}
+	// Without a process to upgrade from, start as usual
+	var handoff io.Reader
+	if listener == nil && upgrade {
+		conn, l, err := dialUpgrade(cfg)
+		if err != nil {
+			log.Printf("No running process to upgrade from, starting normally: %v", err)
+		} else {
+			defer conn.Close()
+			listener, handoff = l, conn
+		}
+	}
+	if listener == nil && takeover {
+		// Bind before waiting so new connections queue for this process
+		if listener, err = server.Listen(cfg); err != nil {
+			log.Fatalf("Failed to listen: %v", err)
+		}
+	}
+
+	if restore && !handover {
+		if err := restoreBackup(cfg); err != nil {
+			log.Fatalf("Failed to restore backup: %v", err)
+		}
+	}
+	if bootstrap != "" && !handover {
+		// SYNC is an admin command; nodes of one deployment are expected
+		// to share admin_password
+		if err := backup.Bootstrap(context.Background(), cfg, bootstrap, cfg.AdminPassword); err != nil {
+			log.Fatalf("Failed to bootstrap from %s: %v", bootstrap, err)
+		}
+	}
+
+	var wait time.Duration
+	if handover {
+		if err := daemon.HandoverReady(); err != nil {
+			log.Fatalf("Failed to report handover readiness: %v", err)
+		}
+		wait = takeoverWait
+	} else if takeover || handoff != nil {
+		wait = takeoverWait
+	}
+
+	srv, err := openServer(cfg, wait, handoff)
+	if err != nil && handoff != nil && !errors.Is(err, storage.ErrLocked) {
+		// The previous process has let go of data_dir, which holds
+		// everything it had
+		log.Printf("Upgrade handoff failed, recovering from disk: %v", err)
+		srv, err = openServer(cfg, wait, nil)
+	}
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
 
+	// Written once the data directory is ours: during a takeover the old
+	// process keeps its pid file until it lets go
+	if pidFile != "" {
+		if err := daemon.WritePIDFile(pidFile); err != nil {
+			log.Fatalf("Failed to write pid file: %v", err)
+		}
+		defer daemon.RemovePIDFile(pidFile)
+	}
+
 	go func() {
-		if err := srv.Start(); err != nil {
+		var err error
+		if listener != nil {
+			err = srv.Serve(listener)
+		} else {
+			err = srv.Start()
+		}
+		if err != nil {
 			log.Fatalf("Server error: %v", err)
 		}
 	}()
 
-	fmt.Printf("Osprey server started on %s\n", cfg.ListenAddr)
+	<-srv.Ready()
+	fmt.Printf("Osprey server started on %s\n", srv.GetAddress())
+
+	if _, err := daemon.Notify("READY=1"); err != nil {
+		log.Printf("Failed to notify service manager: %v", err)
+	}
+
+	svcStop, svcDone, err := startService(svcName)
+	if err != nil {
+		log.Printf("Failed to start Windows service handler: %v", err)
+	}
+	defer svcDone()
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+
+	reopenChan := make(chan os.Signal, 1)
+	if sigs := daemon.ReopenSignals(); len(sigs) > 0 {
+		signal.Notify(reopenChan, sigs...)
+	}
+
+	// The supervisor restarts instances that exit, so it can't hand over
+	handoverChan := make(chan os.Signal, 1)
+	var upgradeListener *net.UnixListener
+	var upgrades <-chan *net.UnixConn
+	if os.Getenv(supervisedEnv) == "" {
+		if sigs := daemon.HandoverSignals(); len(sigs) > 0 {
+			signal.Notify(handoverChan, sigs...)
+		}
+		if upgradeListener, upgrades, err = acceptUpgrades(cfg); err != nil {
+			log.Printf("Failed to listen for upgrades: %v", err)
+		} else if upgradeListener != nil {
+			defer upgradeListener.Close()
+		}
+	}
+	handedOver, upgraded := false, false
+
+	for running := true; running; {
+		select {
+		case <-reopenChan:
+			if err := logging.Reopen(); err != nil {
+				log.Printf("Failed to reopen log file: %v", err)
+			} else {
+				log.Printf("Reopened log file %s", logPath)
+			}
+		case <-handoverChan:
+			if err := handOver(srv, pidFile); err != nil {
+				log.Printf("Handover failed, still serving: %v", err)
+				continue
+			}
+			handedOver = true
+			running = false
+		case conn := <-upgrades:
+			if err := handOff(srv, upgradeListener, conn, pidFile); err != nil {
+				log.Printf("Upgrade failed, still serving: %v", err)
+				continue
+			}
+			handedOver, upgraded = true, true
+			running = false
+		case <-sigChan:
+			running = false
+		case <-srv.ShutdownRequested():
+			running = false
+		case <-svcStop:
+			running = false
+		}
+	}
+
+	// After a handover the new process is the service's main process
+	if !handedOver {
+		daemon.Notify("STOPPING=1")
+	}
+
+	// An upgrade has already shut the server down
+	if upgraded {
+		return
+	}
 
 	fmt.Println("\nShutting down...")
 	if err := srv.Shutdown(); err != nil {
 		log.Printf("Error during shutdown: %v", err)
 	}
 }
+
+// restoreBackup fills cfg's data directory from the newest backup set
+func restoreBackup(cfg *config.Config) error {
+	archiver, err := backup.NewFromConfig(cfg.Backup)
+	if err != nil {
+		return err
+	}
+	return archiver.Restore(context.Background(), cfg)
+}
+
+// applyRuntimeTuning applies gc_percent and memory_limit_bytes, which
+// override GOGC and GOMEMLIMIT from the environment when set
+func applyRuntimeTuning(cfg *config.Config) {
+	if cfg.GCPercent != 0 {
+		debug.SetGCPercent(cfg.GCPercent)
+		log.Printf("GC percent set to %d", cfg.GCPercent)
+	}
+	if cfg.MemoryLimitBytes > 0 {
+		debug.SetMemoryLimit(cfg.MemoryLimitBytes)
+		log.Printf("Soft memory limit set to %d bytes", cfg.MemoryLimitBytes)
+	}
+	if cfg.GCPercent < 0 && debug.SetMemoryLimit(-1) == math.MaxInt64 {
+		log.Printf("Warning: gc_percent = -1 without a memory limit; the heap will grow until the process is killed")
+	}
+}
@@ -0,0 +1,68 @@
+//go:build windows
+
+package main
+
+import (
+	"log"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// startService connects to the Windows service control manager when the
+// process was started as a service. The returned channel is closed when the
+// manager asks the service to stop; call done once shutdown has finished so
+// the service reports Stopped. Outside the service manager the channel is
+// nil and done is a no-op.
+func startService(name string) (stop <-chan struct{}, done func(), err error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return nil, func() {}, err
+	}
+	if !isService {
+		return nil, func() {}, nil
+	}
+
+	h := &serviceHandler{
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go func() {
+		if err := svc.Run(name, h); err != nil {
+			log.Printf("Windows service %s failed: %v", name, err)
+		}
+	}()
+
+	return h.stop, func() { close(h.stopped) }, nil
+}
+
+// serviceHandler translates service control requests into a shutdown
+type serviceHandler struct {
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// Execute implements svc.Handler
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown
+
+	status <- svc.Status{State: svc.Running, Accepts: accepted}
+
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				close(h.stop)
+				<-h.stopped
+				return false, 0
+			}
+		case <-h.stopped:
+			// The server stopped on its own, e.g. after a SHUTDOWN command
+			return false, 0
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/daemon"
+)
+
+// supervisedEnv marks instance processes started by the supervisor
+const supervisedEnv = "OSPREY_SUPERVISED"
+
+const (
+	restartBackoffMin = time.Second
+	restartBackoffMax = 30 * time.Second
+
+	// A child that ran at least this long is considered healthy, so its
+	// next crash restarts it without accumulated backoff
+	restartResetAfter = time.Minute
+)
+
+// supervisor runs every instance in a config file as a child process
+type supervisor struct {
+	configPath string
+	exe        string
+
+	mu       sync.Mutex
+	stopping bool
+	children map[string]*os.Process
+	wg       sync.WaitGroup
+}
+
+// superviseInstances starts all instances defined in configPath, restarts
+// any that exit unexpectedly, and stops them all on SIGINT/SIGTERM.
+// SIGUSR1 is forwarded so every instance reopens its log file.
+func superviseInstances(configPath, pidFile string) error {
+	if err := config.ValidateInstances(configPath); err != nil {
+		return err
+	}
+
+	names, err := config.InstanceNames(configPath)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no [instances.<name>] sections in %s", configPath)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	if pidFile != "" {
+		if err := daemon.WritePIDFile(pidFile); err != nil {
+			return err
+		}
+		defer daemon.RemovePIDFile(pidFile)
+	}
+
+	s := &supervisor{
+		configPath: configPath,
+		exe:        exe,
+		children:   make(map[string]*os.Process),
+	}
+
+	for _, name := range names {
+		s.wg.Add(1)
+		go s.run(name)
+	}
+
+	log.Printf("Supervising %d instances: %s", len(names), strings.Join(names, ", "))
+	daemon.Notify("READY=1")
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	forwardChan := make(chan os.Signal, 1)
+	if sigs := daemon.ReopenSignals(); len(sigs) > 0 {
+		signal.Notify(forwardChan, sigs...)
+	}
+
+	for running := true; running; {
+		select {
+		case sig := <-forwardChan:
+			s.signalAll(sig)
+		case <-sigChan:
+			running = false
+		}
+	}
+
+	daemon.Notify("STOPPING=1")
+	log.Println("Stopping instances...")
+
+	s.mu.Lock()
+	s.stopping = true
+	s.mu.Unlock()
+	s.signalAll(syscall.SIGTERM)
+
+	s.wg.Wait()
+	return nil
+}
+
+// run keeps one instance running until the supervisor stops
+func (s *supervisor) run(name string) {
+	defer s.wg.Done()
+
+	backoff := restartBackoffMin
+	for {
+		cmd := exec.Command(s.exe, "-config", s.configPath, "-instance", name)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = childEnv()
+
+		s.mu.Lock()
+		if s.stopping {
+			s.mu.Unlock()
+			return
+		}
+		started := time.Now()
+		err := cmd.Start()
+		if err == nil {
+			s.children[name] = cmd.Process
+		}
+		s.mu.Unlock()
+
+		if err == nil {
+			err = cmd.Wait()
+		}
+
+		s.mu.Lock()
+		delete(s.children, name)
+		stopping := s.stopping
+		s.mu.Unlock()
+		if stopping {
+			return
+		}
+
+		if time.Since(started) >= restartResetAfter {
+			backoff = restartBackoffMin
+		}
+		log.Printf("Instance %s exited (%v), restarting in %v", name, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, restartBackoffMax)
+	}
+}
+
+// signalAll sends sig to every running child
+func (s *supervisor) signalAll(sig os.Signal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, p := range s.children {
+		if err := p.Signal(sig); err != nil {
+			log.Printf("Failed to signal instance %s: %v", name, err)
+		}
+	}
+}
+
+// childEnv is the supervisor's environment minus the variables that
+// belong to the supervisor itself: children must not report readiness to
+// systemd on its behalf. supervisedEnv is added so they know they are
+// supervised.
+func childEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "NOTIFY_SOCKET=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return append(env, supervisedEnv+"=1")
+}
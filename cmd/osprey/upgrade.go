@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/daemon"
+	"github.com/bharatmehan/osprey/internal/server"
+)
+
+// upgradeSocket is the Unix socket in data_dir that a process started
+// with -upgrade connects to
+const upgradeSocket = "upgrade.sock"
+
+// acceptUpgrades listens on the upgrade socket and delivers connections
+// from processes started with -upgrade. The listener is nil if upgrades
+// are not supported on this platform.
+func acceptUpgrades(cfg *config.Config) (*net.UnixListener, <-chan *net.UnixConn, error) {
+	l, err := daemon.ListenUpgrade(filepath.Join(cfg.DataDir, upgradeSocket))
+	if err != nil || l == nil {
+		return nil, nil, err
+	}
+
+	conns := make(chan *net.UnixConn)
+	go func() {
+		for {
+			conn, err := l.AcceptUnix()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	return l, conns, nil
+}
+
+// handOff hands the listening socket and the dataset to the process at
+// the other end of conn. Once the socket is passed this process stops
+// serving whether or not the dataset gets across: the new process then
+// recovers from disk instead. An error means the upgrade didn't start
+// and this process carries on.
+func handOff(srv *server.Server, l *net.UnixListener, conn *net.UnixConn, pidFile string) error {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(handoverTimeout))
+	var pid int
+	if _, err := fmt.Fscanf(conn, "%d\n", &pid); err != nil {
+		return fmt.Errorf("reading pid: %w", err)
+	}
+
+	f, err := srv.ListenerFile()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := daemon.SendListener(conn, f); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	log.Printf("Upgrading to pid %d", pid)
+
+	// The new process listens on the socket once it has data_dir
+	l.Close()
+	if _, err := daemon.Notify(fmt.Sprintf("MAINPID=%d", pid)); err != nil {
+		log.Printf("Failed to notify service manager: %v", err)
+	}
+	if pidFile != "" {
+		daemon.RemovePIDFile(pidFile)
+	}
+
+	if err := srv.HandOff(conn); err != nil {
+		log.Printf("Failed to send the dataset, pid %d will recover from disk: %v", pid, err)
+	}
+	return nil
+}
+
+// dialUpgrade connects to the upgrade socket of the running process and
+// receives its listening socket. The dataset follows on the connection.
+func dialUpgrade(cfg *config.Config) (*net.UnixConn, net.Listener, error) {
+	path := filepath.Join(cfg.DataDir, upgradeSocket)
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(handoverTimeout))
+	if _, err := fmt.Fprintf(conn, "%d\n", os.Getpid()); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	listener, err := daemon.ReceiveListener(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	log.Printf("Using socket %s from the running process", listener.Addr())
+	return conn, listener, nil
+}
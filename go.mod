@@ -5,6 +5,8 @@ go 1.21
 require (
 	github.com/BurntSushi/toml v1.3.2
 	github.com/stretchr/testify v1.8.4
+	golang.org/x/sys v0.20.0
+	golang.org/x/text v0.15.0
 )
 
 require (
@@ -0,0 +1,337 @@
+// Package backup copies snapshots and WALs to object storage and restores
+// a data directory from them.
+//
+// Each snapshot becomes a backup set under the configured prefix:
+//
+//	<prefix><created>-snap-00000003/snap-00000003.osnap
+//	<prefix><created>-snap-00000003/wal-00000007.oswal
+//	<prefix><created>-snap-00000003/MANIFEST.json
+//
+// MANIFEST.json is uploaded last, so a set without one is incomplete and
+// is never restored.
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+)
+
+const manifestName = "MANIFEST.json"
+
+// uploadTimeout bounds a whole backup set upload
+const uploadTimeout = 10 * time.Minute
+
+// ErrNotFound is returned by ObjectStore.Get for a missing key
+var ErrNotFound = errors.New("object not found")
+
+// ErrNoBackup is returned by Restore when the bucket holds no complete set
+var ErrNoBackup = errors.New("no backup found")
+
+// ObjectStore is the minimal object storage API backups need
+type ObjectStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// manifest mirrors the fields of storage.Manifest that restore needs
+type manifest struct {
+	Snap      string `json:"snap"`
+	NextWAL   string `json:"next_wal"`
+	CreatedMs int64  `json:"created_ms"`
+}
+
+// Archiver uploads snapshots to an ObjectStore and prunes old backup sets.
+// It implements storage.SnapshotArchiver.
+type Archiver struct {
+	store  ObjectStore
+	prefix string
+	retain int
+}
+
+// NewArchiver creates an archiver writing under prefix and keeping the
+// newest retain backup sets (all of them when retain <= 0)
+func NewArchiver(store ObjectStore, prefix string, retain int) *Archiver {
+	return &Archiver{store: store, prefix: prefix, retain: retain}
+}
+
+// NewFromConfig creates an archiver for the S3 bucket in cfg
+func NewFromConfig(cfg config.BackupConfig) (*Archiver, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("backup bucket not configured")
+	}
+
+	s3 := &S3{
+		Endpoint:     cfg.Endpoint,
+		Region:       cfg.Region,
+		Bucket:       cfg.Bucket,
+		AccessKey:    cfg.AccessKey,
+		SecretKey:    cfg.SecretKey,
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		PathStyle:    cfg.PathStyle,
+	}
+	if s3.AccessKey == "" {
+		s3.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if s3.SecretKey == "" {
+		s3.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	return NewArchiver(s3, cfg.Prefix, cfg.Retain), nil
+}
+
+// ArchiveSnapshot uploads one backup set, then prunes sets beyond the
+// retention count
+func (a *Archiver) ArchiveSnapshot(manifestPath, snapPath string, walPaths []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	set := a.prefix + setName(m)
+	start := time.Now()
+
+	files := append([]string{snapPath}, walPaths...)
+	for _, file := range files {
+		if err := a.putFile(ctx, set+"/"+filepath.Base(file), file); err != nil {
+			return err
+		}
+	}
+	if err := a.store.Put(ctx, set+"/"+manifestName, strings.NewReader(string(data)), int64(len(data))); err != nil {
+		return fmt.Errorf("upload %s: %w", manifestName, err)
+	}
+
+	log.Printf("Uploaded backup set %s (%d files) in %v", set, len(files)+1, time.Since(start))
+
+	return a.prune(ctx)
+}
+
+// putFile uploads the file at path as key
+func (a *Archiver) putFile(ctx context.Context, key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := a.store.Put(ctx, key, f, info.Size()); err != nil {
+		return fmt.Errorf("upload %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// setName names a backup set so that sets sort by creation time even when
+// snapshot numbering restarts
+func setName(m manifest) string {
+	created := time.UnixMilli(m.CreatedMs).UTC().Format("20060102T150405.000Z")
+	return created + "-" + strings.TrimSuffix(m.Snap, filepath.Ext(m.Snap))
+}
+
+// sets groups the keys under the prefix by backup set, oldest first
+func (a *Archiver) sets(ctx context.Context) ([]string, map[string][]string, error) {
+	keys, err := a.store.List(ctx, a.prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	members := make(map[string][]string)
+	for _, key := range keys {
+		rest := strings.TrimPrefix(key, a.prefix)
+		i := strings.Index(rest, "/")
+		if i <= 0 {
+			continue // not ours
+		}
+		members[rest[:i]] = append(members[rest[:i]], key)
+	}
+
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, members, nil
+}
+
+// prune deletes all but the newest retain backup sets
+func (a *Archiver) prune(ctx context.Context) error {
+	if a.retain <= 0 {
+		return nil
+	}
+
+	names, members, err := a.sets(ctx)
+	if err != nil {
+		return err
+	}
+	if len(names) <= a.retain {
+		return nil
+	}
+
+	for _, name := range names[:len(names)-a.retain] {
+		// Manifest first, so a half-deleted set reads as incomplete
+		keys := members[name]
+		sort.Slice(keys, func(i, j int) bool {
+			return path.Base(keys[i]) == manifestName && path.Base(keys[j]) != manifestName
+		})
+		for _, key := range keys {
+			if err := a.store.Delete(ctx, key); err != nil {
+				return fmt.Errorf("delete %s: %w", key, err)
+			}
+		}
+		log.Printf("Removed backup set %s%s", a.prefix, name)
+	}
+	return nil
+}
+
+// Restore downloads the newest complete backup set into the data, WAL and
+// snapshot directories of cfg. It refuses to overwrite an existing store.
+func (a *Archiver) Restore(ctx context.Context, cfg *config.Config) error {
+	if err := checkEmpty(cfg); err != nil {
+		return err
+	}
+
+	names, members, err := a.sets(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		set := a.prefix + names[i]
+		if !contains(members[names[i]], set+"/"+manifestName) {
+			continue
+		}
+		return a.restoreSet(ctx, set, cfg)
+	}
+	return ErrNoBackup
+}
+
+// restoreSet downloads one backup set. The manifest is written last so an
+// interrupted restore leaves no MANIFEST.json behind.
+func (a *Archiver) restoreSet(ctx context.Context, set string, cfg *config.Config) error {
+	data, err := a.getAll(ctx, set+"/"+manifestName)
+	if err != nil {
+		return err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("invalid manifest in %s: %w", set, err)
+	}
+
+	for _, dir := range []string{cfg.DataDir, cfg.WALDirectory(), cfg.SnapshotDirectory()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := a.getFile(ctx, set+"/"+m.Snap, filepath.Join(cfg.SnapshotDirectory(), m.Snap)); err != nil {
+		return err
+	}
+	if m.NextWAL != "" {
+		if err := a.getFile(ctx, set+"/"+m.NextWAL, filepath.Join(cfg.WALDirectory(), m.NextWAL)); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(cfg.DataDir, manifestName), data, 0644); err != nil {
+		return err
+	}
+
+	log.Printf("Restored backup set %s into %s", set, cfg.DataDir)
+	return nil
+}
+
+// getAll downloads a small object into memory
+func (a *Archiver) getAll(ctx context.Context, key string) ([]byte, error) {
+	r, err := a.store.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", key, err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// getFile downloads key to dest via a temporary file
+func (a *Archiver) getFile(ctx context.Context, key, dest string) error {
+	r, err := a.store.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+	defer r.Close()
+
+	if err := copyToFile(dest, r); err != nil {
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+	return nil
+}
+
+// copyToFile writes r to dest via a temporary file, so dest only appears
+// once it is complete
+func copyToFile(dest string, r io.Reader) error {
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// checkEmpty fails if cfg already points at a store with data
+func checkEmpty(cfg *config.Config) error {
+	if _, err := os.Stat(filepath.Join(cfg.DataDir, manifestName)); err == nil {
+		return fmt.Errorf("%s already contains %s", cfg.DataDir, manifestName)
+	}
+	wals, err := filepath.Glob(filepath.Join(cfg.WALDirectory(), "wal-*.oswal"))
+	if err != nil {
+		return err
+	}
+	if len(wals) > 0 {
+		return fmt.Errorf("%s already contains WAL files", cfg.WALDirectory())
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is an in-memory ObjectStore
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: make(map[string][]byte)}
+}
+
+func (m *memStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = data
+	return nil
+}
+
+func (m *memStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memStore) List(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for k := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *memStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func testConfig(dir string) *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = dir
+	cfg.EnableSnapshot = false
+	return cfg
+}
+
+func TestArchiver_ArchiveAndRestore(t *testing.T) {
+	objects := newMemStore()
+	archiver := NewArchiver(objects, "osprey/", 1)
+
+	ps, err := storage.NewPersistentStore(testConfig(t.TempDir()))
+	require.NoError(t, err)
+	ps.SetArchiver(archiver)
+
+	_, err = ps.Set("a", []byte("1"), storage.SetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, ps.Snapshot())
+
+	_, err = ps.Set("b", []byte("2"), storage.SetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, ps.Snapshot())
+	require.NoError(t, ps.Close())
+
+	// Retention keeps only the newest set
+	names, members, err := archiver.sets(context.Background())
+	require.NoError(t, err)
+	require.Len(t, names, 1)
+	assert.Len(t, members[names[0]], 3) // snapshot, WAL, manifest
+
+	cfg := testConfig(filepath.Join(t.TempDir(), "data"))
+	cfg.WALDir = filepath.Join(t.TempDir(), "wal")
+	require.NoError(t, archiver.Restore(context.Background(), cfg))
+
+	restored, err := storage.NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		entry, err := restored.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(entry.Value))
+	}
+
+	// A second restore into the same directory is refused
+	assert.Error(t, archiver.Restore(context.Background(), cfg))
+}
+
+func TestArchiver_RestoreSkipsIncompleteSet(t *testing.T) {
+	objects := newMemStore()
+	archiver := NewArchiver(objects, "osprey/", 0)
+
+	err := archiver.Restore(context.Background(), testConfig(t.TempDir()))
+	assert.ErrorIs(t, err, ErrNoBackup)
+
+	// A set whose manifest never arrived is ignored
+	objects.Put(context.Background(), "osprey/20260101T000000.000Z-snap-00000001/snap-00000001.osnap", strings.NewReader("x"), 1)
+	err = archiver.Restore(context.Background(), testConfig(t.TempDir()))
+	assert.ErrorIs(t, err, ErrNoBackup)
+}
+
+func TestBootstrap_FromBucketURL(t *testing.T) {
+	srv := httptest.NewServer(&fakeS3{objects: make(map[string][]byte)})
+	defer srv.Close()
+
+	cfg := testConfig(t.TempDir())
+	cfg.Backup = config.BackupConfig{
+		Endpoint:  srv.URL,
+		Region:    "us-east-1",
+		Bucket:    "bucket",
+		Prefix:    "nodes/a/",
+		AccessKey: "ak",
+		SecretKey: "sk",
+		PathStyle: true,
+	}
+	archiver, err := NewFromConfig(cfg.Backup)
+	require.NoError(t, err)
+
+	ps, err := storage.NewPersistentStore(cfg)
+	require.NoError(t, err)
+	ps.SetArchiver(archiver)
+	_, err = ps.Set("k", []byte("v"), storage.SetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, ps.Snapshot())
+	require.NoError(t, ps.Close())
+
+	// The URL's bucket and prefix replace those in the config
+	target := testConfig(t.TempDir())
+	target.Backup = cfg.Backup
+	target.Backup.Prefix = "elsewhere/"
+	require.NoError(t, Bootstrap(context.Background(), target, "s3://bucket/nodes/a/", ""))
+
+	restored, err := storage.NewPersistentStore(target)
+	require.NoError(t, err)
+	defer restored.Close()
+	entry, err := restored.Get("k")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v"), entry.Value)
+}
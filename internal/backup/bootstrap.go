@@ -0,0 +1,126 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/pkg/client"
+)
+
+// Bootstrap seeds cfg's empty data directory from source, which is either
+// an s3://bucket/prefix URL or the host:port of a running node. Buckets are
+// reached with the endpoint, region and credentials from cfg.Backup; nodes
+// are asked for a fresh snapshot with SYNC, authenticating with password
+// when it is set.
+func Bootstrap(ctx context.Context, cfg *config.Config, source, password string) error {
+	if strings.HasPrefix(source, "s3://") {
+		return bootstrapFromBucket(ctx, cfg, source)
+	}
+	return bootstrapFromNode(cfg, source, password)
+}
+
+// bootstrapFromBucket restores the newest backup set under an s3:// URL
+func bootstrapFromBucket(ctx context.Context, cfg *config.Config, source string) error {
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid bucket URL: %s", source)
+	}
+
+	bcfg := cfg.Backup
+	bcfg.Bucket = u.Host
+	bcfg.Prefix = strings.TrimPrefix(u.Path, "/")
+
+	archiver, err := NewFromConfig(bcfg)
+	if err != nil {
+		return err
+	}
+	return archiver.Restore(ctx, cfg)
+}
+
+// bootstrapFromNode copies a snapshot from another node over SYNC
+func bootstrapFromNode(cfg *config.Config, addr, password string) error {
+	if err := checkEmpty(cfg); err != nil {
+		return err
+	}
+
+	c, err := client.New(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if password != "" {
+		resp, err := c.AuthHMAC("", password)
+		if err != nil {
+			return err
+		}
+		if !resp.Success {
+			return fmt.Errorf("AUTH failed: %s", resp.Error)
+		}
+	}
+
+	for _, dir := range []string{cfg.DataDir, cfg.WALDirectory(), cfg.SnapshotDirectory()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	// Hold the manifest back until every other file is in place
+	var manifest []byte
+	var written []string
+	err = c.Sync(func(name string, size int64, r io.Reader) error {
+		if name == manifestName {
+			data, err := io.ReadAll(r)
+			manifest = data
+			return err
+		}
+
+		dest, err := syncDest(cfg, name)
+		if err != nil {
+			return err
+		}
+		if err := copyToFile(dest, r); err != nil {
+			return err
+		}
+		written = append(written, dest)
+		return nil
+	})
+	if err == nil && manifest == nil {
+		err = fmt.Errorf("%s did not send %s", addr, manifestName)
+	}
+	if err != nil {
+		for _, path := range written {
+			os.Remove(path)
+		}
+		return fmt.Errorf("SYNC from %s: %w", addr, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(cfg.DataDir, manifestName), manifest, 0644); err != nil {
+		return err
+	}
+
+	log.Printf("Bootstrapped %s from %s", cfg.DataDir, addr)
+	return nil
+}
+
+// syncDest maps a file name sent by SYNC to its place under cfg
+func syncDest(cfg *config.Config, name string) (string, error) {
+	if name != filepath.Base(name) {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	switch {
+	case strings.HasPrefix(name, "snap-"):
+		return filepath.Join(cfg.SnapshotDirectory(), name), nil
+	case strings.HasPrefix(name, "wal-"):
+		return filepath.Join(cfg.WALDirectory(), name), nil
+	default:
+		return "", fmt.Errorf("unexpected file %q", name)
+	}
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"runtime"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -11,56 +13,342 @@ type Config struct {
 	// Network
 	ListenAddr string `toml:"listen_addr"`
 	MaxClients int    `toml:"max_clients"`
+	ReusePort  bool   `toml:"reuse_port"` // SO_REUSEPORT, so a replacement process can bind alongside
+
+	// PROXY protocol (v1 or v2) from load balancers. When enabled, clients
+	// connecting from ProxyTrusted, which must not be empty, must send a
+	// PROXY header, and the address in it is used as the client's.
+	ProxyProtocol bool     `toml:"proxy_protocol"`
+	ProxyTrusted  []string `toml:"proxy_trusted"` // CIDRs or IP addresses
+
+	// Connections from DenyCIDRs, or from outside AllowCIDRs unless it is
+	// empty, are closed as soon as they are accepted. CIDRs or IP
+	// addresses; both can be changed with CONFIG SET.
+	AllowCIDRs []string `toml:"allow_cidrs"`
+	DenyCIDRs  []string `toml:"deny_cidrs"`
+
+	// Slow clients. Connections that haven't sent a complete command
+	// HandshakeTimeoutMs after connecting, or that take longer than
+	// CommandTimeoutMs to send the rest of a command once it has started,
+	// are closed. Command lines longer than MaxLineBytes are refused and
+	// the connection closed. 0 disables each.
+	HandshakeTimeoutMs int `toml:"handshake_timeout_ms"`
+	CommandTimeoutMs   int `toml:"command_timeout_ms"`
+	MaxLineBytes       int `toml:"max_line_bytes"`
+
+	// Pipelining. Replies to pipelined commands are buffered and sent
+	// together, but never for more than MaxPipeline commands or past
+	// OutputBufferBytes, which bounds what one connection holds. 0 sends
+	// each reply as soon as it's ready.
+	MaxPipeline       int `toml:"max_pipeline"`
+	OutputBufferBytes int `toml:"output_buffer_bytes"`
 
 	// Limits
 	MaxKeyBytes   int `toml:"max_key_bytes"`
 	MaxValueBytes int `toml:"max_value_bytes"`
 
+	// Soft limits (0 disables each). Writes past them still succeed, but
+	// are logged and, on connections that sent HELLO WARNINGS ON, flagged
+	// in the reply, so clients notice before the hard limits refuse them.
+	SoftMaxKeyBytes   int `toml:"soft_max_key_bytes"`
+	SoftMaxValueBytes int `toml:"soft_max_value_bytes"`
+
+	// TTL policy (0 disables each). Writes without an expiry get
+	// DefaultTTLMs; expiries further out than MaxTTLMs, including none at
+	// all, are cut to MaxTTLMs or, with MaxTTLPolicy "reject", refused.
+	DefaultTTLMs int64  `toml:"default_ttl_ms"`
+	MaxTTLMs     int64  `toml:"max_ttl_ms"`
+	MaxTTLPolicy string `toml:"max_ttl_policy"` // "clamp" (default) or "reject"
+
+	// Rewrites every key to a canonical form before it is used: a comma
+	// separated list of "lowercase" and "nfc" ("" keeps keys as sent)
+	KeyNormalization string `toml:"key_normalization"`
+
+	// Concurrency model: "global" runs every command on its connection
+	// goroutine under the store lock; "keyqueue" routes single-key
+	// mutations through per-key serialized worker queues.
+	ConcurrencyModel string `toml:"concurrency_model"`
+	KeyQueueWorkers  int    `toml:"keyqueue_workers"`
+	KeyQueueDepth    int    `toml:"keyqueue_depth"`
+
 	// Persistence
 	DataDir         string `toml:"data_dir"`
+	WALDir          string `toml:"wal_dir"`      // defaults to data_dir
+	SnapshotDir     string `toml:"snapshot_dir"` // defaults to data_dir
 	WALMaxBytes     int64  `toml:"wal_max_bytes"`
 	SyncPolicy      string `toml:"sync_policy"`
 	BatchFsyncMs    int    `toml:"batch_fsync_ms"`
 	BatchFsyncBytes int64  `toml:"batch_fsync_bytes"`
 
+	// Keys under these prefixes are loaded in a first recovery pass, the
+	// rest in a second. With ServeDuringLoad, reads of the priority keys
+	// are served while the second pass runs.
+	PriorityPrefixes []string `toml:"priority_prefixes"`
+	ServeDuringLoad  bool     `toml:"serve_during_load"`
+
+	// DIGEST keeps the digests of keys under these prefixes up to date,
+	// as it does for the whole keyspace; others are computed on request
+	DigestPrefixes []string `toml:"digest_prefixes"`
+
+	// Value storage: "heap" allocates each value separately; "arena"
+	// (experimental) packs values into ArenaSlabBytes slabs, cutting the
+	// number of heap objects for multi-GB datasets
+	ValueStorage   string `toml:"value_storage"`
+	ArenaSlabBytes int    `toml:"arena_slab_bytes"`
+
 	// Snapshot
 	EnableSnapshot     bool `toml:"enable_snapshot"`
 	SnapshotPauseMaxMs int  `toml:"snapshot_pause_max_ms"`
 	BusyWarnMs         int  `toml:"busy_warn_ms"`
+	// What writes get while a snapshot pauses them: "reject" replies
+	// ERR BUSY; "queue" holds up to SnapshotQueueMax of them for at most
+	// SnapshotQueueTimeoutMs each, then applies them once the pause ends
+	SnapshotWriteMode      string `toml:"snapshot_write_mode"`
+	SnapshotQueueMax       int    `toml:"snapshot_queue_max"`
+	SnapshotQueueTimeoutMs int    `toml:"snapshot_queue_timeout_ms"`
+	// Also write each snapshot in this older data format, so the data can
+	// be loaded by the release before a format change. 0 disables.
+	SnapshotCompatFormat int `toml:"snapshot_compat_format"`
+
+	// Off-node copies of snapshots and WALs
+	Backup BackupConfig `toml:"backup"`
 
 	// Expiry
 	SweepIntervalMs int `toml:"sweep_interval_ms"`
 	SweepBatch      int `toml:"sweep_batch"`
 
-	// Metrics
-	MetricsEnable bool `toml:"metrics_enable"`
+	// Key map compaction (interval 0 disables). Go maps keep their memory
+	// after deletes, so the map is rebuilt once it has held DefragMinKeys
+	// keys and fewer than DefragMinRatio of them remain. Maps with more
+	// than DefragMaxKeys live keys are skipped to bound the copy pause.
+	DefragIntervalMs int     `toml:"defrag_interval_ms"`
+	DefragMinRatio   float64 `toml:"defrag_min_ratio"`
+	DefragMinKeys    int     `toml:"defrag_min_keys"`
+	DefragMaxKeys    int     `toml:"defrag_max_keys"`
+
+	// Go runtime tuning, applied at startup. GCPercent sets GOGC (0 keeps
+	// the environment's or Go's default, -1 turns the collector off) and
+	// MemoryLimitBytes sets the soft memory limit GOMEMLIMIT (0 keeps the
+	// environment's). Once memory use passes MemoryEvictRatio of the
+	// limit, the least recently used keys are evicted (0 disables), and
+	// past MemoryWarnRatio writes are warned about like soft limits.
+	GCPercent        int     `toml:"gc_percent"`
+	MemoryLimitBytes int64   `toml:"memory_limit_bytes"`
+	MemoryEvictRatio float64 `toml:"memory_evict_ratio"`
+	MemoryWarnRatio  float64 `toml:"memory_warn_ratio"`
+
+	// How long SET ... IDEMP <token> remembers a token, so a retried SET
+	// with the same token is acknowledged without being applied again
+	IdempotencyWindowMs int `toml:"idempotency_window_ms"`
+
+	// Write commands replayed into an empty store at startup, before
+	// clients are accepted ("" disables)
+	WarmupFile string `toml:"warmup_file"`
+
+	// Metrics. The Prometheus endpoint is served on metrics_addr when it is
+	// set and metrics_enable is on.
+	MetricsEnable bool   `toml:"metrics_enable"`
+	MetricsAddr   string `toml:"metrics_addr"`
+
+	// Admin commands (SHUTDOWN). Empty restricts them to loopback clients;
+	// otherwise clients must AUTH with this password first.
+	AdminPassword string `toml:"admin_password"`
+
+	// How passwords are proven: "password" accepts them sent with AUTH or
+	// as an HMAC of an AUTHCHALLENGE nonce; "hmac" only accepts the HMAC,
+	// so passwords never cross the network
+	AuthMode string `toml:"auth_mode"`
+
+	// Tenants. A connection that sends AUTH <name> <password> for one of
+	// these users only sees the keys under the user's namespace. Once any
+	// user is configured, connections must AUTH before using keys unless
+	// they are admins.
+	Users []User `toml:"user"`
+
+	// Start with writes refused with ERR READONLY, as after READONLY ON
+	ReadOnly bool `toml:"read_only"`
+
+	// Write rate limiting (0 disables)
+	WriteRateLimit int `toml:"write_rate_limit"` // writes per second per connection
+	WriteRateBurst int `toml:"write_rate_burst"`
+
+	// Priority-based load shedding (0 disables). Connections choose their
+	// class with HELLO PRIORITY; high-priority connections are never shed.
+	PriorityShedLowInflight    int `toml:"priority_shed_low_inflight"`
+	PriorityShedNormalInflight int `toml:"priority_shed_normal_inflight"`
+
+	// Bandwidth caps per connection by priority class, in bytes per
+	// second read and written together (0 disables)
+	BandwidthLowBytesPerSec    int `toml:"bandwidth_low_bytes_per_sec"`
+	BandwidthNormalBytesPerSec int `toml:"bandwidth_normal_bytes_per_sec"`
+	BandwidthHighBytesPerSec   int `toml:"bandwidth_high_bytes_per_sec"`
+
+	// Overload protection (0 disables each threshold). While overloaded,
+	// ShedFraction of requests get ERR BUSY with a retry-after hint.
+	ShedInflightThreshold int     `toml:"shed_inflight_threshold"`
+	ShedWALBacklogBytes   int64   `toml:"shed_wal_backlog_bytes"`
+	ShedFraction          float64 `toml:"shed_fraction"`
+	ShedRetryAfterMs      int     `toml:"shed_retry_after_ms"`
+
+	// Per-prefix overrides
+	PrefixRules []PrefixRule `toml:"prefix_rule"`
+
+	// How often keys with a refresh_url are checked for refresh-ahead
+	RefreshIntervalMs int `toml:"refresh_interval_ms"`
+
+	// Most events kept for prefix rules with notify set; the oldest are
+	// dropped beyond this
+	NotifyQueueMax int `toml:"notify_queue_max"`
+
+	// Most published messages waiting to be written to one subscriber;
+	// a subscriber further behind is disconnected
+	PubsubQueueMax int `toml:"pubsub_queue_max"`
+
+	// Most keys one KEYS reply lists; 0 disables KEYS
+	KeysMax int `toml:"keys_max"`
+
+	// Key temperature reporting
+	TemperatureHotMs  int `toml:"temperature_hot_ms"`
+	TemperatureWarmMs int `toml:"temperature_warm_ms"`
+
+	// GET <key> EARLY reports keys within soft_expire_window_ms of expiring
+	// as soft-expired with soft_expire_probability, so one client refreshes
+	// them before they expire for everyone
+	SoftExpireWindowMs    int     `toml:"soft_expire_window_ms"`
+	SoftExpireProbability float64 `toml:"soft_expire_probability"`
 
 	// Logging
 	LogLevel           string `toml:"log_level"`
 	LogFile            string `toml:"log_file"`
 	SlowlogThresholdMs int    `toml:"slowlog_threshold_ms"`
+
+	// Commands, fsyncs and expiry sweeps slower than this are kept for
+	// the LATENCY command (0 disables)
+	LatencyMonitorThresholdMs int `toml:"latency_monitor_threshold_ms"`
+}
+
+// PrefixRule overrides settings for keys starting with Prefix.
+// Zero-valued fields inherit the global setting.
+type PrefixRule struct {
+	Prefix         string `toml:"prefix"`
+	WriteRateLimit int    `toml:"write_rate_limit"` // writes per second, shared by all connections
+	WriteRateBurst int    `toml:"write_rate_burst"`
+	MaxValueBytes  int    `toml:"max_value_bytes"` // may be larger or smaller than the global limit
+	DefaultTTLMs   int64  `toml:"default_ttl_ms"`
+	MaxTTLMs       int64  `toml:"max_ttl_ms"`
+	MaxTTLPolicy   string `toml:"max_ttl_policy"`
+
+	// Refresh-ahead: keys that have been read since they were written are
+	// reloaded from RefreshURL ({key} is replaced by the escaped key) when
+	// they are within RefreshAheadMs of expiring, and stored again with
+	// RefreshTTLMs or, if that is zero, the TTL they were last written with
+	RefreshURL     string `toml:"refresh_url"`
+	RefreshAheadMs int    `toml:"refresh_ahead_ms"`
+	RefreshTTLMs   int    `toml:"refresh_ttl_ms"`
+
+	// Queue expiry and delete events for these keys until a client
+	// acknowledges them (EVENTS)
+	Notify bool `toml:"notify"`
+
+	// Log writes to these keys without waiting for an fsync, as SET ...
+	// NOSYNC does, for cache data that doesn't need to survive a crash
+	NoSync bool `toml:"no_sync"`
+
+	// Keep these keys in memory only: writes to them are never logged to
+	// the WAL or written to snapshots, so they are gone after a restart
+	Ephemeral bool `toml:"ephemeral"`
+
+	// TOUCH gives these keys this TTL again, so keys that are kept touched
+	// don't expire. 0 leaves TTLs alone.
+	SlidingTTLMs int64 `toml:"sliding_ttl_ms"`
+}
+
+// User is a tenant whose keys are kept under Namespace, which is
+// prepended to every key it sends and removed from keys in replies
+type User struct {
+	Name      string `toml:"name"`
+	Password  string `toml:"password"`
+	Namespace string `toml:"namespace"` // defaults to Name + ":"
+}
+
+// BackupConfig describes the S3-compatible bucket that completed snapshots
+// and the WALs needed to replay after them are uploaded to.
+type BackupConfig struct {
+	Enable    bool   `toml:"enable"`
+	Endpoint  string `toml:"endpoint"` // e.g. https://s3.us-east-1.amazonaws.com
+	Region    string `toml:"region"`
+	Bucket    string `toml:"bucket"`
+	Prefix    string `toml:"prefix"`
+	AccessKey string `toml:"access_key"` // defaults to $AWS_ACCESS_KEY_ID
+	SecretKey string `toml:"secret_key"` // defaults to $AWS_SECRET_ACCESS_KEY
+	PathStyle bool   `toml:"path_style"` // bucket in the path rather than the host name
+	Retain    int    `toml:"retain"`     // backup sets to keep; 0 keeps all
 }
 
 func DefaultConfig() *Config {
 	return &Config{
 		ListenAddr:         "0.0.0.0:7070",
 		MaxClients:         10000,
+		HandshakeTimeoutMs: 10 * 1000,
+		CommandTimeoutMs:   60 * 1000,
+		MaxLineBytes:       1024 * 1024, // 1 MiB
+		MaxPipeline:        128,
+		OutputBufferBytes:  64 * 1024, // 64 KiB
 		MaxKeyBytes:        256,
 		MaxValueBytes:      16 * 1024 * 1024, // 16 MiB
+		MaxTTLPolicy:       "clamp",
+		ConcurrencyModel:   "global",
+		KeyQueueWorkers:    runtime.NumCPU(),
+		KeyQueueDepth:      1024,
 		DataDir:            "./data",
 		WALMaxBytes:        256 * 1024 * 1024, // 256 MiB
 		SyncPolicy:         "batch",
 		BatchFsyncMs:       100,
 		BatchFsyncBytes:    1024 * 1024, // 1 MiB
+		ValueStorage:       "heap",
+		ArenaSlabBytes:     16 * 1024 * 1024, // 16 MiB
 		EnableSnapshot:     true,
 		SnapshotPauseMaxMs: 500,
 		BusyWarnMs:         50,
-		SweepIntervalMs:    200,
-		SweepBatch:         1000,
-		MetricsEnable:      true,
+
+		AuthMode: "password",
+
+		SnapshotWriteMode:      "reject",
+		SnapshotQueueMax:       1000,
+		SnapshotQueueTimeoutMs: 1000,
+
+		Backup: BackupConfig{
+			Endpoint: "https://s3.amazonaws.com",
+			Region:   "us-east-1",
+			Retain:   7,
+		},
+		SweepIntervalMs:   200,
+		SweepBatch:        1000,
+		DefragIntervalMs:  10 * 1000,
+		DefragMinRatio:    0.25,
+		DefragMinKeys:     100000,
+		DefragMaxKeys:     1000000,
+		MemoryEvictRatio:  0.9,
+		MetricsEnable:     true,
+		ShedFraction:      0.5,
+		ShedRetryAfterMs:  50,
+		TemperatureHotMs:  60 * 1000,      // 1 minute
+		TemperatureWarmMs: 60 * 60 * 1000, // 1 hour
+
+		RefreshIntervalMs:   1000,
+		NotifyQueueMax:      10000,
+		PubsubQueueMax:      1000,
+		KeysMax:             1000,
+		IdempotencyWindowMs: 5 * 60 * 1000, // 5 minutes
+
+		SoftExpireWindowMs:    5000,
+		SoftExpireProbability: 0.1,
+
 		LogLevel:           "INFO",
 		LogFile:            "",
 		SlowlogThresholdMs: 50,
+
+		LatencyMonitorThresholdMs: 100,
 	}
 }
 
@@ -87,10 +375,131 @@ func (c *Config) SweepInterval() time.Duration {
 	return time.Duration(c.SweepIntervalMs) * time.Millisecond
 }
 
+func (c *Config) DefragInterval() time.Duration {
+	return time.Duration(c.DefragIntervalMs) * time.Millisecond
+}
+
 func (c *Config) BusyWarnDuration() time.Duration {
 	return time.Duration(c.BusyWarnMs) * time.Millisecond
 }
 
+func (c *Config) HandshakeTimeout() time.Duration {
+	return time.Duration(c.HandshakeTimeoutMs) * time.Millisecond
+}
+
+func (c *Config) CommandTimeout() time.Duration {
+	return time.Duration(c.CommandTimeoutMs) * time.Millisecond
+}
+
+func (c *Config) SnapshotQueueTimeout() time.Duration {
+	return time.Duration(c.SnapshotQueueTimeoutMs) * time.Millisecond
+}
+
 func (c *Config) SlowlogThreshold() time.Duration {
 	return time.Duration(c.SlowlogThresholdMs) * time.Millisecond
 }
+
+func (c *Config) RefreshInterval() time.Duration {
+	return time.Duration(c.RefreshIntervalMs) * time.Millisecond
+}
+
+func (c *Config) LatencyMonitorThreshold() time.Duration {
+	return time.Duration(c.LatencyMonitorThresholdMs) * time.Millisecond
+}
+
+// WALDirectory returns the directory holding WAL files
+func (c *Config) WALDirectory() string {
+	if c.WALDir != "" {
+		return c.WALDir
+	}
+	return c.DataDir
+}
+
+// SnapshotDirectory returns the directory holding snapshot files
+func (c *Config) SnapshotDirectory() string {
+	if c.SnapshotDir != "" {
+		return c.SnapshotDir
+	}
+	return c.DataDir
+}
+
+// RuleFor returns the prefix rule with the longest prefix matching key, or nil
+func (c *Config) RuleFor(key string) *PrefixRule {
+	return c.RuleWith(key, func(*PrefixRule) bool { return true })
+}
+
+// RuleWith returns the prefix rule with the longest prefix matching key
+// among those that has accepts, or nil. Settings a longer rule leaves at
+// zero are thereby inherited from shorter rules before the global one.
+func (c *Config) RuleWith(key string, has func(*PrefixRule) bool) *PrefixRule {
+	var best *PrefixRule
+	for i := range c.PrefixRules {
+		rule := &c.PrefixRules[i]
+		if strings.HasPrefix(key, rule.Prefix) && (best == nil || len(rule.Prefix) > len(best.Prefix)) && has(rule) {
+			best = rule
+		}
+	}
+	return best
+}
+
+// MaxValueBytesFor returns the value size limit that applies to key: that
+// of the longest matching prefix rule that sets one, or the global limit
+func (c *Config) MaxValueBytesFor(key string) int {
+	if rule := c.RuleWith(key, func(r *PrefixRule) bool { return r.MaxValueBytes > 0 }); rule != nil {
+		return rule.MaxValueBytes
+	}
+	return c.MaxValueBytes
+}
+
+// LargestMaxValueBytes returns the largest value size limit of any key
+func (c *Config) LargestMaxValueBytes() int {
+	limit := c.MaxValueBytes
+	for _, rule := range c.PrefixRules {
+		limit = max(limit, rule.MaxValueBytes)
+	}
+	return limit
+}
+
+// NoSyncFor reports whether writes to key skip the WAL fsync
+func (c *Config) NoSyncFor(key string) bool {
+	rule := c.RuleFor(key)
+	return rule != nil && rule.NoSync
+}
+
+// EphemeralFor reports whether key is kept in memory only
+func (c *Config) EphemeralFor(key string) bool {
+	rule := c.RuleFor(key)
+	return rule != nil && rule.Ephemeral
+}
+
+// SlidingTTLFor returns the TTL TOUCH gives key, 0 for none
+func (c *Config) SlidingTTLFor(key string) int64 {
+	if rule := c.RuleFor(key); rule != nil {
+		return rule.SlidingTTLMs
+	}
+	return 0
+}
+
+// DefaultTTLFor returns the TTL given to writes of key without an expiry,
+// 0 for none
+func (c *Config) DefaultTTLFor(key string) int64 {
+	if rule := c.RuleFor(key); rule != nil && rule.DefaultTTLMs > 0 {
+		return rule.DefaultTTLMs
+	}
+	return c.DefaultTTLMs
+}
+
+// MaxTTLFor returns the longest TTL key may have, 0 for no limit, and
+// whether longer ones are rejected rather than clamped
+func (c *Config) MaxTTLFor(key string) (maxMs int64, reject bool) {
+	maxMs, policy := c.MaxTTLMs, c.MaxTTLPolicy
+	if rule := c.RuleFor(key); rule != nil {
+		if rule.MaxTTLMs > 0 {
+			maxMs = rule.MaxTTLMs
+		}
+		if rule.MaxTTLPolicy != "" {
+			policy = rule.MaxTTLPolicy
+		}
+	}
+	return maxMs, policy == "reject"
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+)
+
+// instanceFile is the part of a config file that defines named instances.
+// Each [instances.<name>] table overrides the top-level settings.
+type instanceFile struct {
+	Instances map[string]toml.Primitive `toml:"instances"`
+}
+
+// InstanceNames returns the instances defined in the config file at path,
+// sorted by name. A missing file or one without [instances.*] tables has none.
+func InstanceNames(path string) ([]string, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var file instanceFile
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(file.Instances))
+	for name := range file.Instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// LoadInstance loads the top-level settings from path and applies the
+// overrides of [instances.<name>]. An instance that doesn't set data_dir
+// gets its own subdirectory, <data_dir>/<name>, so instances never share
+// persistence files by accident.
+func LoadInstance(path, name string) (*Config, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file instanceFile
+	md, err := toml.DecodeFile(path, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	prim, ok := file.Instances[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown instance: %s", name)
+	}
+
+	baseDataDir := cfg.DataDir
+	cfg.DataDir = ""
+	if err := md.PrimitiveDecode(prim, cfg); err != nil {
+		return nil, fmt.Errorf("instance %s: %w", name, err)
+	}
+	if cfg.DataDir == "" {
+		cfg.DataDir = filepath.Join(baseDataDir, name)
+	}
+
+	return cfg, nil
+}
+
+// ValidateInstances loads every instance in path and checks that no two
+// share a listen address, data/WAL/snapshot directory, log file or backup
+// location
+func ValidateInstances(path string) error {
+	names, err := InstanceNames(path)
+	if err != nil {
+		return err
+	}
+
+	addrs := make(map[string]string)
+	dirs := make(map[string]string)
+	logs := make(map[string]string)
+	backups := make(map[string]string)
+	for _, name := range names {
+		cfg, err := LoadInstance(path, name)
+		if err != nil {
+			return err
+		}
+
+		if other, ok := addrs[cfg.ListenAddr]; ok {
+			return fmt.Errorf("instances %s and %s share listen_addr %s", other, name, cfg.ListenAddr)
+		}
+		addrs[cfg.ListenAddr] = name
+
+		for _, d := range []struct{ setting, dir string }{
+			{"data_dir", cfg.DataDir},
+			{"wal_dir", cfg.WALDirectory()},
+			{"snapshot_dir", cfg.SnapshotDirectory()},
+		} {
+			dir := filepath.Clean(d.dir)
+			if other, ok := dirs[dir]; ok && other != name {
+				return fmt.Errorf("instances %s and %s share %s %s", other, name, d.setting, d.dir)
+			}
+			dirs[dir] = name
+		}
+
+		if cfg.LogFile != "" {
+			if other, ok := logs[cfg.LogFile]; ok {
+				return fmt.Errorf("instances %s and %s share log_file %s", other, name, cfg.LogFile)
+			}
+			logs[cfg.LogFile] = name
+		}
+
+		if cfg.Backup.Enable {
+			loc := cfg.Backup.Endpoint + "/" + cfg.Backup.Bucket + "/" + cfg.Backup.Prefix
+			if other, ok := backups[loc]; ok {
+				return fmt.Errorf("instances %s and %s share backup prefix %s", other, name, loc)
+			}
+			backups[loc] = name
+		}
+	}
+	return nil
+}
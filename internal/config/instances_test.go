@@ -0,0 +1,95 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const instancesTOML = `
+max_value_bytes = 1024
+data_dir = "/var/lib/osprey"
+
+[[prefix_rule]]
+prefix = "base:"
+write_rate_limit = 10
+
+[instances.sessions]
+listen_addr = "127.0.0.1:7071"
+
+[instances.blobs]
+listen_addr = "127.0.0.1:7072"
+data_dir = "/mnt/bulk/blobs"
+max_value_bytes = 4096
+
+[[instances.blobs.prefix_rule]]
+prefix = "blob:"
+max_value_bytes = 8192
+`
+
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "osprey.toml")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func TestLoadInstance(t *testing.T) {
+	path := writeConfig(t, instancesTOML)
+
+	names, err := InstanceNames(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"blobs", "sessions"}, names)
+
+	// Inherits top-level settings and gets its own data dir
+	sessions, err := LoadInstance(path, "sessions")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:7071", sessions.ListenAddr)
+	assert.Equal(t, 1024, sessions.MaxValueBytes)
+	assert.Equal(t, filepath.Join("/var/lib/osprey", "sessions"), sessions.DataDir)
+	require.Len(t, sessions.PrefixRules, 1)
+	assert.Equal(t, "base:", sessions.PrefixRules[0].Prefix)
+
+	// Overrides replace top-level settings
+	blobs, err := LoadInstance(path, "blobs")
+	require.NoError(t, err)
+	assert.Equal(t, "/mnt/bulk/blobs", blobs.DataDir)
+	assert.Equal(t, 4096, blobs.MaxValueBytes)
+	require.Len(t, blobs.PrefixRules, 1)
+	assert.Equal(t, "blob:", blobs.PrefixRules[0].Prefix)
+
+	_, err = LoadInstance(path, "missing")
+	assert.Error(t, err)
+
+	assert.NoError(t, ValidateInstances(path))
+}
+
+func TestValidateInstances_Conflict(t *testing.T) {
+	path := writeConfig(t, `
+[instances.a]
+listen_addr = "127.0.0.1:7071"
+
+[instances.b]
+listen_addr = "127.0.0.1:7071"
+`)
+
+	err := ValidateInstances(path)
+	assert.ErrorContains(t, err, "share listen_addr")
+}
+
+func TestValidateInstances_SharedWALDir(t *testing.T) {
+	path := writeConfig(t, `
+wal_dir = "/fast/osprey-wal"
+
+[instances.a]
+listen_addr = "127.0.0.1:7071"
+
+[instances.b]
+listen_addr = "127.0.0.1:7072"
+`)
+
+	err := ValidateInstances(path)
+	assert.ErrorContains(t, err, "share wal_dir")
+}
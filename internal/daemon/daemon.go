@@ -0,0 +1,79 @@
+// Package daemon provides the process-management glue osprey needs to run
+// under traditional init systems and systemd: pid files, readiness
+// notification and detaching from the controlling terminal.
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// childEnv marks a process started by Detach
+const childEnv = "OSPREY_DAEMONIZED"
+
+// ErrAlreadyRunning is returned when a pid file names a live process
+var ErrAlreadyRunning = errors.New("another instance is already running")
+
+// WritePIDFile records the current process id at path. A pid file left
+// behind by a dead process is replaced; one naming a live process is not.
+func WritePIDFile(path string) error {
+	if data, err := os.ReadFile(path); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && pid != os.Getpid() && processAlive(pid) {
+			return fmt.Errorf("%w (pid %d in %s)", ErrAlreadyRunning, pid, path)
+		}
+	}
+
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// RemovePIDFile deletes the pid file if it still belongs to this process
+func RemovePIDFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// Notify sends a state string such as "READY=1" or "STOPPING=1" to the
+// service manager named by $NOTIFY_SOCKET. It reports false without error
+// when not running under systemd.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// Abstract namespace sockets are written with a leading '@'
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// IsChild reports whether this process was started by Detach
+func IsChild() bool {
+	return os.Getenv(childEnv) == "1"
+}
@@ -0,0 +1,28 @@
+//go:build !unix
+
+package daemon
+
+import (
+	"errors"
+	"os"
+)
+
+// Detach is not supported on this platform
+func Detach() error {
+	return errors.New("daemon mode is not supported on this platform")
+}
+
+// ReopenSignals returns nil: there is no log-reopen signal on this platform
+func ReopenSignals() []os.Signal {
+	return nil
+}
+
+// processAlive reports whether pid names a running process
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,140 @@
+package daemon
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "osprey.pid")
+
+	require.NoError(t, WritePIDFile(path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(data))
+
+	require.NoError(t, RemovePIDFile(path))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// Removing a missing pid file is not an error
+	assert.NoError(t, RemovePIDFile(path))
+}
+
+func TestPIDFile_Stale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "osprey.pid")
+
+	// A pid that can't be running is replaced
+	require.NoError(t, os.WriteFile(path, []byte("999999999\n"), 0644))
+	require.NoError(t, WritePIDFile(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(data))
+}
+
+func TestPIDFile_OtherOwner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "osprey.pid")
+	require.NoError(t, os.WriteFile(path, []byte("999999999\n"), 0644))
+
+	// Someone else's pid file is left alone
+	require.NoError(t, RemovePIDFile(path))
+	_, err := os.Stat(path)
+	assert.NoError(t, err)
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err := Notify("READY=1")
+	require.NoError(t, err)
+	assert.False(t, sent)
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	sent, err = Notify("READY=1")
+	require.NoError(t, err)
+	assert.True(t, sent)
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+}
+
+func TestListeners_NotForUs(t *testing.T) {
+	t.Setenv("LISTEN_FDS", "")
+	listeners, err := Listeners()
+	require.NoError(t, err)
+	assert.Nil(t, listeners)
+
+	// Sockets meant for another process are left alone
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv(handoverEnv, "")
+	listeners, err = Listeners()
+	require.NoError(t, err)
+	assert.Nil(t, listeners)
+	assert.Equal(t, "1", os.Getenv("LISTEN_FDS"))
+}
+
+func TestSendListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upgrade.sock")
+	ul, err := ListenUpgrade(path)
+	require.NoError(t, err)
+	if ul == nil {
+		t.Skip("upgrades are not supported on this platform")
+	}
+	defer ul.Close()
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcp.Close()
+	f, err := tcp.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	sent := make(chan error, 1)
+	go func() {
+		conn, err := ul.AcceptUnix()
+		if err != nil {
+			sent <- err
+			return
+		}
+		defer conn.Close()
+		sent <- SendListener(conn, f)
+	}()
+
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	require.NoError(t, err)
+	defer conn.Close()
+	received, err := ReceiveListener(conn)
+	require.NoError(t, err)
+	defer received.Close()
+	require.NoError(t, <-sent)
+	assert.Equal(t, tcp.Addr().String(), received.Addr().String())
+
+	// Connections to the socket reach the received listener
+	tcp.Close()
+	go func() {
+		if c, err := net.Dial("tcp", received.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := received.Accept()
+	require.NoError(t, err)
+	c.Close()
+}
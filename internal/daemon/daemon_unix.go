@@ -0,0 +1,48 @@
+//go:build unix
+
+package daemon
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Detach re-executes the current binary in a new session with stdio
+// redirected to /dev/null. The caller (the parent) should exit once
+// Detach returns successfully; the child sees IsChild() == true.
+func Detach() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), childEnv+"=1")
+	cmd.Stdin = devNull
+	cmd.Stdout = devNull
+	cmd.Stderr = devNull
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// ReopenSignals returns the signals that ask the server to reopen its log file
+func ReopenSignals() []os.Signal {
+	return []os.Signal{syscall.SIGUSR1}
+}
+
+// processAlive reports whether pid names a running process
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,38 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// handoverEnv marks a process started by StartHandover. Its value is the
+// descriptor the child reports readiness on.
+const handoverEnv = "OSPREY_HANDOVER"
+
+// ErrHandoverExited is returned by Handover.Wait when the new process
+// exits before it is ready to take over
+var ErrHandoverExited = errors.New("new process exited during handover")
+
+// IsHandover reports whether this process was started by StartHandover
+// and should wait for its parent to release the data directory
+func IsHandover() bool {
+	return os.Getenv(handoverEnv) != ""
+}
+
+// inheritableEnv returns the environment minus the variables describing
+// sockets and handovers meant for this process only
+func inheritableEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		switch {
+		case strings.HasPrefix(kv, "LISTEN_PID="),
+			strings.HasPrefix(kv, "LISTEN_FDS="),
+			strings.HasPrefix(kv, "LISTEN_FDNAMES="),
+			strings.HasPrefix(kv, handoverEnv+"="):
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
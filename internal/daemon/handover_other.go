@@ -0,0 +1,42 @@
+//go:build !unix
+
+package daemon
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// Listeners returns nil: socket inheritance is not supported on this
+// platform
+func Listeners() ([]net.Listener, error) {
+	return nil, nil
+}
+
+// Handover is a new copy of this process that was handed the listening
+// sockets
+type Handover struct {
+	Process *os.Process
+}
+
+// StartHandover is not supported on this platform
+func StartHandover(files []*os.File) (*Handover, error) {
+	return nil, errors.New("handover is not supported on this platform")
+}
+
+// Wait is not supported on this platform
+func (h *Handover) Wait(timeout time.Duration) error {
+	return errors.New("handover is not supported on this platform")
+}
+
+// HandoverReady is not supported on this platform
+func HandoverReady() error {
+	return errors.New("handover is not supported on this platform")
+}
+
+// HandoverSignals returns nil: there is no handover signal on this platform
+func HandoverSignals() []os.Signal {
+	return nil
+}
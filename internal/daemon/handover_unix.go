@@ -0,0 +1,141 @@
+//go:build unix
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// listenFdsStart is the first inherited descriptor, per sd_listen_fds(3)
+const listenFdsStart = 3
+
+// Listeners returns the listening sockets passed by systemd socket
+// activation or by a handover parent, in order, or nil if there are none.
+// The LISTEN_* variables are cleared so child processes don't see them.
+func Listeners() ([]net.Listener, error) {
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil, nil
+	}
+	// systemd names the receiving process; a handover parent can't know
+	// the child's pid in advance and sets handoverEnv instead
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) && !IsHandover() {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	var listeners []net.Listener
+	for fd := listenFdsStart; fd < listenFdsStart+nfds; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "listener-"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("inherited descriptor %d: %w", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Handover is a new copy of this process that was handed the listening
+// sockets
+type Handover struct {
+	Process *os.Process
+	ready   *os.File // read end of the readiness pipe
+	exited  chan struct{}
+}
+
+// StartHandover starts a new copy of the running binary with the same
+// arguments. files become its listening sockets (see Listeners). The
+// caller should Wait for the child to get ready, then shut down and
+// release the data directory for it.
+func StartHandover(files []*os.File) (*Handover, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyW.Close()
+
+	readyFd := listenFdsStart + len(files)
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(inheritableEnv(),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		handoverEnv+"="+strconv.Itoa(readyFd))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(append([]*os.File{}, files...), readyW)
+
+	if err := cmd.Start(); err != nil {
+		readyR.Close()
+		return nil, err
+	}
+
+	h := &Handover{Process: cmd.Process, ready: readyR, exited: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(h.exited)
+	}()
+	return h, nil
+}
+
+// Wait blocks until the child reports it is ready to take over, exits,
+// or timeout passes
+func (h *Handover) Wait(timeout time.Duration) error {
+	defer h.ready.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := h.ready.Read(buf); err != nil {
+			result <- ErrHandoverExited
+			return
+		}
+		result <- nil
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-h.exited:
+		return ErrHandoverExited
+	case <-time.After(timeout):
+		return fmt.Errorf("new process not ready after %v", timeout)
+	}
+}
+
+// HandoverReady tells the parent that this process has loaded its
+// configuration and sockets and is waiting for the data directory
+func HandoverReady() error {
+	fd, err := strconv.Atoi(os.Getenv(handoverEnv))
+	if err != nil {
+		return fmt.Errorf("invalid %s", handoverEnv)
+	}
+	f := os.NewFile(uintptr(fd), "handover")
+	defer f.Close()
+
+	_, err = f.Write([]byte{1})
+	return err
+}
+
+// HandoverSignals returns the signals that start a handover
+func HandoverSignals() []os.Signal {
+	return []os.Signal{syscall.SIGUSR2}
+}
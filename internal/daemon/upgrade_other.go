@@ -0,0 +1,25 @@
+//go:build !unix
+
+package daemon
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// ListenUpgrade returns nil: passing sockets to another process is not
+// supported on this platform
+func ListenUpgrade(path string) (*net.UnixListener, error) {
+	return nil, nil
+}
+
+// SendListener is not supported on this platform
+func SendListener(conn *net.UnixConn, f *os.File) error {
+	return errors.New("upgrade is not supported on this platform")
+}
+
+// ReceiveListener is not supported on this platform
+func ReceiveListener(conn *net.UnixConn) (net.Listener, error) {
+	return nil, errors.New("upgrade is not supported on this platform")
+}
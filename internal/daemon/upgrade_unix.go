@@ -0,0 +1,61 @@
+//go:build unix
+
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// ListenUpgrade listens on the Unix socket at path for a new process
+// asking to take over, replacing a socket left behind by a process that
+// didn't exit cleanly
+func ListenUpgrade(path string) (*net.UnixListener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+}
+
+// SendListener passes the listening socket f to the process at the other
+// end of conn, which takes it with ReceiveListener
+func SendListener(conn *net.UnixConn, f *os.File) error {
+	_, _, err := conn.WriteMsgUnix([]byte{1}, syscall.UnixRights(int(f.Fd())), nil)
+	return err
+}
+
+// ReceiveListener takes the listening socket sent with SendListener
+func ReceiveListener(conn *net.UnixConn) (net.Listener, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 {
+		return nil, errors.New("no listening socket received")
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) != 1 {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return nil, fmt.Errorf("received %d descriptors, expected 1", len(fds))
+	}
+
+	syscall.CloseOnExec(fds[0])
+	f := os.NewFile(uintptr(fds[0]), "listener")
+	defer f.Close()
+	return net.FileListener(f)
+}
@@ -0,0 +1,50 @@
+// Package keynorm rewrites keys to a canonical form (key_normalization),
+// so producers that disagree on case or Unicode composition still read
+// and write the same key.
+package keynorm
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization steps
+const (
+	NFC       = "nfc"       // Unicode canonical composition
+	Lowercase = "lowercase" // Unicode lower case
+)
+
+// Func returns a key's canonical form
+type Func func(key string) string
+
+// Parse returns the normalizer for a key_normalization setting, a comma
+// separated list of steps. Steps run NFC first, whatever their order in
+// the list, so the result doesn't depend on how the setting was written.
+// Parse returns nil for an empty setting.
+func Parse(policy string) (Func, error) {
+	var nfc, lower bool
+	for _, step := range strings.Split(policy, ",") {
+		switch strings.ToLower(strings.TrimSpace(step)) {
+		case "":
+		case NFC:
+			nfc = true
+		case Lowercase:
+			lower = true
+		default:
+			return nil, fmt.Errorf("unknown key_normalization step: %s", step)
+		}
+	}
+
+	switch {
+	case nfc && lower:
+		return func(key string) string { return strings.ToLower(norm.NFC.String(key)) }, nil
+	case nfc:
+		return norm.NFC.String, nil
+	case lower:
+		return strings.ToLower, nil
+	default:
+		return nil, nil
+	}
+}
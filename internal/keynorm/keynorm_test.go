@@ -0,0 +1,31 @@
+package keynorm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	normalize, err := Parse("")
+	require.NoError(t, err)
+	assert.Nil(t, normalize)
+
+	normalize, err = Parse("lowercase")
+	require.NoError(t, err)
+	assert.Equal(t, "user:ada", normalize("User:ADA"))
+
+	// "e" followed by a combining acute accent composes to "é"
+	normalize, err = Parse("nfc")
+	require.NoError(t, err)
+	assert.Equal(t, "caf\u00e9", normalize("cafe\u0301"))
+	assert.Equal(t, "Caf\u00e9", normalize("Caf\u00e9"))
+
+	normalize, err = Parse(" Lowercase, nfc ")
+	require.NoError(t, err)
+	assert.Equal(t, "caf\u00e9", normalize("CAFE\u0301"))
+
+	_, err = Parse("lowercase,upper")
+	assert.Error(t, err)
+}
@@ -0,0 +1,172 @@
+// Package latency records latency spikes of server operations, so slow
+// commands, fsyncs and expiry sweeps can be inspected after an incident
+// with the LATENCY command.
+package latency
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Events recorded by the server
+const (
+	EventCommand = "command" // a command's execution, including any WAL write
+	EventFsync   = "fsync"   // an fsync of the WAL
+	EventSweep   = "sweep"   // one pass of the expiry sweeper
+)
+
+// HistoryLen is the number of spikes kept per event
+const HistoryLen = 160
+
+// Sample is one latency spike. Spikes within the same second are merged
+// into one sample holding the largest latency.
+type Sample struct {
+	Time      time.Time
+	LatencyMs int64
+}
+
+// Latest summarizes an event's spikes
+type Latest struct {
+	Event     string
+	Time      time.Time // of the latest spike
+	LatencyMs int64     // of the latest spike
+	MaxMs     int64     // largest spike since the event was last reset
+}
+
+type series struct {
+	samples []Sample // ring buffer of up to HistoryLen samples
+	next    int
+	maxMs   int64
+}
+
+// Monitor records operations that take longer than a threshold. A nil
+// Monitor, or one with a zero threshold, records nothing.
+type Monitor struct {
+	threshold time.Duration
+
+	mu     sync.Mutex
+	events map[string]*series
+}
+
+// NewMonitor returns a Monitor recording operations slower than threshold
+func NewMonitor(threshold time.Duration) *Monitor {
+	return &Monitor{
+		threshold: threshold,
+		events:    make(map[string]*series),
+	}
+}
+
+// Threshold returns the spike threshold, zero when monitoring is off
+func (m *Monitor) Threshold() time.Duration {
+	if m == nil {
+		return 0
+	}
+	return m.threshold
+}
+
+// Record notes that event took d, keeping it if it was a spike
+func (m *Monitor) Record(event string, d time.Duration) {
+	if m == nil || m.threshold <= 0 || d < m.threshold {
+		return
+	}
+	m.record(event, d, time.Now())
+}
+
+func (m *Monitor) record(event string, d time.Duration, now time.Time) {
+	now = now.Truncate(time.Second)
+	ms := d.Milliseconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.events[event]
+	if s == nil {
+		s = &series{}
+		m.events[event] = s
+	}
+	if ms > s.maxMs {
+		s.maxMs = ms
+	}
+
+	if len(s.samples) > 0 {
+		last := &s.samples[(s.next-1+len(s.samples))%len(s.samples)]
+		if last.Time.Equal(now) {
+			if ms > last.LatencyMs {
+				last.LatencyMs = ms
+			}
+			return
+		}
+	}
+
+	sample := Sample{Time: now, LatencyMs: ms}
+	if len(s.samples) < HistoryLen {
+		s.samples = append(s.samples, sample)
+		s.next = len(s.samples) % HistoryLen
+		return
+	}
+	s.samples[s.next] = sample
+	s.next = (s.next + 1) % HistoryLen
+}
+
+// Latest returns the latest and largest spike of every event with spikes,
+// sorted by event name
+func (m *Monitor) Latest() []Latest {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latest := make([]Latest, 0, len(m.events))
+	for event, s := range m.events {
+		last := s.samples[(s.next-1+len(s.samples))%len(s.samples)]
+		latest = append(latest, Latest{Event: event, Time: last.Time, LatencyMs: last.LatencyMs, MaxMs: s.maxMs})
+	}
+	sort.Slice(latest, func(i, j int) bool { return latest[i].Event < latest[j].Event })
+	return latest
+}
+
+// History returns event's spikes, oldest first
+func (m *Monitor) History(event string) []Sample {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.events[event]
+	if s == nil {
+		return nil
+	}
+	history := make([]Sample, 0, len(s.samples))
+	if len(s.samples) == HistoryLen {
+		history = append(history, s.samples[s.next:]...)
+		return append(history, s.samples[:s.next]...)
+	}
+	return append(history, s.samples...)
+}
+
+// Reset discards the spikes of the given events, or of every event if none
+// are given, and returns how many events were reset
+func (m *Monitor) Reset(events ...string) int {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(events) == 0 {
+		n := len(m.events)
+		m.events = make(map[string]*series)
+		return n
+	}
+	n := 0
+	for _, event := range events {
+		if _, ok := m.events[event]; ok {
+			delete(m.events, event)
+			n++
+		}
+	}
+	return n
+}
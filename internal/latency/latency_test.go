@@ -0,0 +1,73 @@
+package latency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonitor_Record(t *testing.T) {
+	m := NewMonitor(10 * time.Millisecond)
+
+	// Below the threshold
+	m.Record(EventCommand, 5*time.Millisecond)
+	assert.Empty(t, m.Latest())
+
+	// Spikes in the same second are merged, keeping the largest
+	m.Record(EventCommand, 20*time.Millisecond)
+	m.Record(EventCommand, 50*time.Millisecond)
+	m.Record(EventCommand, 30*time.Millisecond)
+	m.Record(EventFsync, 15*time.Millisecond)
+
+	latest := m.Latest()
+	require.Len(t, latest, 2)
+	assert.Equal(t, EventCommand, latest[0].Event)
+	assert.Equal(t, int64(50), latest[0].LatencyMs)
+	assert.Equal(t, int64(50), latest[0].MaxMs)
+	assert.Equal(t, EventFsync, latest[1].Event)
+
+	history := m.History(EventCommand)
+	require.Len(t, history, 1)
+	assert.Equal(t, int64(50), history[0].LatencyMs)
+	assert.Nil(t, m.History(EventSweep))
+}
+
+func TestMonitor_HistoryWraps(t *testing.T) {
+	m := NewMonitor(time.Millisecond)
+
+	// One spike per second, more than the ring holds
+	base := time.Now().Truncate(time.Second)
+	for i := 0; i < HistoryLen+10; i++ {
+		m.record(EventSweep, time.Duration(i)*time.Millisecond, base.Add(time.Duration(i)*time.Second))
+	}
+
+	history := m.History(EventSweep)
+	require.Len(t, history, HistoryLen)
+	assert.Equal(t, int64(10), history[0].LatencyMs)
+	assert.Equal(t, int64(HistoryLen+9), history[HistoryLen-1].LatencyMs)
+	assert.Equal(t, int64(HistoryLen+9), m.Latest()[0].LatencyMs)
+}
+
+func TestMonitor_Reset(t *testing.T) {
+	m := NewMonitor(time.Millisecond)
+	m.Record(EventCommand, time.Second)
+	m.Record(EventFsync, time.Second)
+	m.Record(EventSweep, time.Second)
+
+	assert.Equal(t, 1, m.Reset(EventFsync, "unknown"))
+	assert.Len(t, m.Latest(), 2)
+	assert.Equal(t, 2, m.Reset())
+	assert.Empty(t, m.Latest())
+}
+
+func TestMonitor_Disabled(t *testing.T) {
+	var nilMonitor *Monitor
+	nilMonitor.Record(EventCommand, time.Hour)
+	assert.Nil(t, nilMonitor.Latest())
+
+	m := NewMonitor(0)
+	m.Record(EventCommand, time.Hour)
+	assert.Empty(t, m.Latest())
+}
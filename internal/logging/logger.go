@@ -13,6 +13,8 @@ var (
 	logger *log.Logger
 	// Log file handle
 	logFile *os.File
+	// Path of the log file, kept for Reopen
+	logFilePath string
 )
 
 // InitLogger initializes the logger with the given configuration
@@ -36,6 +38,7 @@ func InitLogger(logPath string, logLevel string) error {
 	}
 
 	logFile = file
+	logFilePath = logPath
 
 	// Create multi-writer to write to both file and stderr
 	multiWriter := io.MultiWriter(os.Stderr, file)
@@ -50,6 +53,31 @@ func InitLogger(logPath string, logLevel string) error {
 	return nil
 }
 
+// Reopen closes and reopens the log file at the same path, so external
+// log rotation (mv osprey.log osprey.log.1; kill -USR1) takes effect
+func Reopen() error {
+	if logFilePath == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen log file: %w", err)
+	}
+
+	// SetOutput waits for in-flight writes, so the old file is idle after it
+	multiWriter := io.MultiWriter(os.Stderr, file)
+	logger.SetOutput(multiWriter)
+	log.SetOutput(multiWriter)
+
+	old := logFile
+	logFile = file
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
 // CloseLogger closes the log file if open
 func CloseLogger() {
 	if logFile != nil {
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+// hdr is a High Dynamic Range histogram: values are counted in buckets
+// whose width grows with the value, so every value up to highest is
+// recorded with sigFigs significant decimal digits of precision in a fixed
+// amount of memory. Recording is lock-free.
+type hdr struct {
+	highest int64
+
+	subBucketHalfCountMagnitude uint
+	subBucketHalfCount          int64
+	subBucketMask               int64
+
+	counts []int64
+	total  int64
+	sum    int64
+	max    int64
+}
+
+func newHDR(highest int64, sigFigs int) *hdr {
+	largestSingleUnit := 2 * int64(math.Pow10(sigFigs))
+	subBucketCountMagnitude := uint(math.Ceil(math.Log2(float64(largestSingleUnit))))
+	subBucketCount := int64(1) << subBucketCountMagnitude
+
+	// Each bucket covers twice the range of the one before it
+	buckets := 1
+	for smallestUntrackable := subBucketCount; smallestUntrackable <= highest; smallestUntrackable <<= 1 {
+		buckets++
+		if smallestUntrackable > math.MaxInt64/2 {
+			break
+		}
+	}
+
+	h := &hdr{
+		highest:                     highest,
+		subBucketHalfCountMagnitude: subBucketCountMagnitude - 1,
+		subBucketHalfCount:          subBucketCount / 2,
+		subBucketMask:               subBucketCount - 1,
+	}
+	h.counts = make([]int64, int64(buckets+1)*h.subBucketHalfCount)
+	return h
+}
+
+// index returns the position of v's bucket in counts
+func (h *hdr) index(v int64) int {
+	pow2Ceiling := 64 - bits.LeadingZeros64(uint64(v|h.subBucketMask))
+	bucket := pow2Ceiling - int(h.subBucketHalfCountMagnitude) - 1
+	subBucket := v >> uint(bucket)
+	return int(int64(bucket+1)<<h.subBucketHalfCountMagnitude + subBucket - h.subBucketHalfCount)
+}
+
+// valueAt returns the highest value counted at position i of counts
+func (h *hdr) valueAt(i int) int64 {
+	bucket := (i >> h.subBucketHalfCountMagnitude) - 1
+	subBucket := int64(i)&(h.subBucketHalfCount-1) + h.subBucketHalfCount
+	if bucket < 0 {
+		subBucket -= h.subBucketHalfCount
+		bucket = 0
+	}
+	lowest := subBucket << uint(bucket)
+	return lowest + (int64(1) << uint(bucket)) - 1
+}
+
+func (h *hdr) record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	if v > h.highest {
+		v = h.highest
+	}
+	atomic.AddInt64(&h.counts[h.index(v)], 1)
+	atomic.AddInt64(&h.total, 1)
+	atomic.AddInt64(&h.sum, v)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if v <= max || atomic.CompareAndSwapInt64(&h.max, max, v) {
+			return
+		}
+	}
+}
+
+// quantiles returns the value at each quantile q (0 to 1), accurate to
+// the histogram's precision
+func (h *hdr) quantiles(qs ...float64) []int64 {
+	total := atomic.LoadInt64(&h.total)
+	values := make([]int64, len(qs))
+	if total == 0 {
+		return values
+	}
+	max := atomic.LoadInt64(&h.max)
+
+	for n, q := range qs {
+		target := int64(math.Ceil(q * float64(total)))
+		if target < 1 {
+			target = 1
+		}
+		var seen int64
+		values[n] = max
+		for i := range h.counts {
+			seen += atomic.LoadInt64(&h.counts[i])
+			if seen >= target {
+				// Never report more than was actually recorded
+				if v := h.valueAt(i); v < max {
+					values[n] = v
+				}
+				break
+			}
+		}
+	}
+	return values
+}
+
+func (h *hdr) reset() {
+	for i := range h.counts {
+		atomic.StoreInt64(&h.counts[i], 0)
+	}
+	atomic.StoreInt64(&h.total, 0)
+	atomic.StoreInt64(&h.sum, 0)
+	atomic.StoreInt64(&h.max, 0)
+}
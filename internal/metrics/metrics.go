@@ -0,0 +1,357 @@
+// Package metrics is the registry of counters, gauges and histograms that
+// STATS, INFO and the Prometheus endpoint all report from, so every
+// surface shows the same numbers.
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram range and precision: latencies from 1µs to one hour, with 3
+// significant digits
+const (
+	histogramHighestUs = int64(time.Hour / time.Microsecond)
+	histogramSigFigs   = 3
+)
+
+// Quantiles reported for every histogram, and the suffixes of the
+// matching STATS fields
+var (
+	Quantiles        = []float64{0.5, 0.9, 0.99, 0.999}
+	quantileSuffixes = []string{"p50", "p90", "p99", "p999"}
+)
+
+// Desc names and documents a metric. Section groups it in INFO output.
+type Desc struct {
+	Name    string
+	Section string
+	Help    string
+}
+
+// Counter is a count that only goes up until the registry is reset
+type Counter struct {
+	Desc
+	v uint64
+}
+
+// Inc adds one
+func (c *Counter) Inc() { atomic.AddUint64(&c.v, 1) }
+
+// Add adds n
+func (c *Counter) Add(n uint64) { atomic.AddUint64(&c.v, n) }
+
+// Value returns the current count
+func (c *Counter) Value() uint64 { return atomic.LoadUint64(&c.v) }
+
+// Gauge is a value that can go up and down, either set directly or read
+// from a function when reported
+type Gauge struct {
+	Desc
+	v  int64
+	fn func() int64
+}
+
+// Set sets the gauge
+func (g *Gauge) Set(v int64) { atomic.StoreInt64(&g.v, v) }
+
+// Value returns the gauge's current value
+func (g *Gauge) Value() int64 {
+	if g.fn != nil {
+		return g.fn()
+	}
+	return atomic.LoadInt64(&g.v)
+}
+
+// Histogram records a distribution of durations with microsecond
+// resolution and reports its quantiles
+type Histogram struct {
+	Desc
+	h *hdr
+}
+
+// Observe records one duration
+func (h *Histogram) Observe(d time.Duration) { h.h.record(d.Microseconds()) }
+
+// Quantiles returns the duration at each quantile q (0 to 1)
+func (h *Histogram) Quantiles(qs ...float64) []time.Duration {
+	values := h.h.quantiles(qs...)
+	durations := make([]time.Duration, len(values))
+	for i, v := range values {
+		durations[i] = time.Duration(v) * time.Microsecond
+	}
+	return durations
+}
+
+// Count returns the number of durations recorded
+func (h *Histogram) Count() uint64 { return uint64(atomic.LoadInt64(&h.h.total)) }
+
+// Sum returns the total of the durations recorded
+func (h *Histogram) Sum() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.h.sum)) * time.Microsecond
+}
+
+// Max returns the largest duration recorded
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.h.max)) * time.Microsecond
+}
+
+// text is a value that isn't a number, such as the current WAL file. It
+// appears in STATS and INFO but not in Prometheus output.
+type text struct {
+	Desc
+	fn func() string
+}
+
+// family is a counter or gauge with one value per value of a label, such
+// as one per user. It only appears in Prometheus output.
+type family struct {
+	Desc
+	kind  string // "counter" or "gauge"
+	label string
+	fn    func() map[string]int64
+}
+
+// Registry holds a server's metrics. Counters and histograms start from
+// zero in a new epoch when the registry is reset; the epoch is reported as
+// stats_epoch.
+type Registry struct {
+	mu      sync.RWMutex
+	metrics []interface{} // *Counter, *Gauge, *Histogram, *text or *family, in registration order
+	names   map[string]bool
+	epoch   int64
+	pending map[string]uint64 // restored counts for counters not registered yet
+}
+
+// NewRegistry returns a registry in an epoch starting now
+func NewRegistry() *Registry {
+	r := &Registry{
+		names: make(map[string]bool),
+		epoch: time.Now().UnixMilli(),
+	}
+	r.GaugeFunc("stats_epoch", "server", "When the counters last started from zero, in Unix milliseconds", r.Epoch)
+	return r
+}
+
+func (r *Registry) register(name string, m interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registerLocked(name, m)
+}
+
+func (r *Registry) registerLocked(name string, m interface{}) {
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Counter registers a counter
+func (r *Registry) Counter(name, section, help string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := &Counter{Desc: Desc{name, section, help}, v: r.pending[name]}
+	delete(r.pending, name)
+	r.registerLocked(name, c)
+	return c
+}
+
+// Gauge registers a gauge that is set directly
+func (r *Registry) Gauge(name, section, help string) *Gauge {
+	g := &Gauge{Desc: Desc{name, section, help}}
+	r.register(name, g)
+	return g
+}
+
+// GaugeFunc registers a gauge whose value is read from fn when reported
+func (r *Registry) GaugeFunc(name, section, help string, fn func() int64) {
+	r.register(name, &Gauge{Desc: Desc{name, section, help}, fn: fn})
+}
+
+// CounterFamily registers counters labelled with label, read from fn when
+// reported. They are not zeroed by Reset.
+func (r *Registry) CounterFamily(name, section, help, label string, fn func() map[string]int64) {
+	r.register(name, &family{Desc: Desc{name, section, help}, kind: "counter", label: label, fn: fn})
+}
+
+// GaugeFamily registers gauges labelled with label, read from fn when
+// reported
+func (r *Registry) GaugeFamily(name, section, help, label string, fn func() map[string]int64) {
+	r.register(name, &family{Desc: Desc{name, section, help}, kind: "gauge", label: label, fn: fn})
+}
+
+// Histogram registers a latency histogram
+func (r *Registry) Histogram(name, section, help string) *Histogram {
+	h := &Histogram{Desc: Desc{name, section, help}, h: newHDR(histogramHighestUs, histogramSigFigs)}
+	r.register(name, h)
+	return h
+}
+
+// Text registers a non-numeric value read from fn when reported
+func (r *Registry) Text(name, section, help string, fn func() string) {
+	r.register(name, &text{Desc: Desc{name, section, help}, fn: fn})
+}
+
+// Epoch returns when the counters last started from zero
+func (r *Registry) Epoch() int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.epoch
+}
+
+// Counters returns the current value of every counter by name
+func (r *Registry) Counters() map[string]uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	counts := make(map[string]uint64)
+	for _, m := range r.metrics {
+		if c, ok := m.(*Counter); ok {
+			counts[c.Name] = c.Value()
+		}
+	}
+	return counts
+}
+
+// Restore continues the epoch and counts saved by an earlier run.
+// Counters registered later pick up their saved counts.
+func (r *Registry) Restore(epoch int64, counts map[string]uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.epoch = epoch
+	r.pending = make(map[string]uint64)
+	for name, v := range counts {
+		r.pending[name] = v
+	}
+	for _, m := range r.metrics {
+		if c, ok := m.(*Counter); ok {
+			atomic.StoreUint64(&c.v, r.pending[c.Name])
+			delete(r.pending, c.Name)
+		}
+	}
+}
+
+// Reset zeroes every counter and histogram and starts a new epoch, which
+// is returned. Epochs always increase.
+func (r *Registry) Reset() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	epoch := time.Now().UnixMilli()
+	if epoch <= r.epoch {
+		epoch = r.epoch + 1
+	}
+	r.epoch = epoch
+	r.pending = nil
+
+	for _, m := range r.metrics {
+		switch m := m.(type) {
+		case *Counter:
+			atomic.StoreUint64(&m.v, 0)
+		case *Histogram:
+			m.h.reset()
+		}
+	}
+	return epoch
+}
+
+// Value is one reported field. Histograms are reported as several fields.
+type Value struct {
+	Name    string
+	Section string
+	Value   string
+	Help    string
+}
+
+// Values returns every metric's current value, in registration order.
+// Histograms become <name>_p50_us, _p90_us, _p99_us, _p999_us, _max_us
+// and _count fields.
+func (r *Registry) Values() []Value {
+	r.mu.RLock()
+	metrics := append([]interface{}(nil), r.metrics...)
+	r.mu.RUnlock()
+
+	values := make([]Value, 0, len(metrics))
+	for _, m := range metrics {
+		values = appendValues(values, m)
+	}
+	return values
+}
+
+// appendValues appends the fields reporting m
+func appendValues(values []Value, m interface{}) []Value {
+	switch m := m.(type) {
+	case *Counter:
+		values = append(values, Value{m.Name, m.Section, strconv.FormatUint(m.Value(), 10), m.Help})
+	case *Gauge:
+		values = append(values, Value{m.Name, m.Section, strconv.FormatInt(m.Value(), 10), m.Help})
+	case *text:
+		values = append(values, Value{m.Name, m.Section, m.fn(), m.Help})
+	case *Histogram:
+		for i, q := range m.Quantiles(Quantiles...) {
+			values = append(values, Value{m.Name + "_" + quantileSuffixes[i] + "_us", m.Section, strconv.FormatInt(q.Microseconds(), 10), m.Help})
+		}
+		values = append(values,
+			Value{m.Name + "_max_us", m.Section, strconv.FormatInt(m.Max().Microseconds(), 10), m.Help},
+			Value{m.Name + "_count", m.Section, strconv.FormatUint(m.Count(), 10), m.Help})
+	}
+	return values
+}
+
+// Section is the fields of one section, as reported by Grouped
+type Section struct {
+	Name   string
+	Values []Value
+}
+
+// Grouped returns every metric's current value grouped by section, with
+// the sections and the metrics in each sorted by name, so the output only
+// changes when metrics are added. A histogram's fields stay together in
+// the order Values reports them.
+func (r *Registry) Grouped() []Section {
+	r.mu.RLock()
+	metrics := append([]interface{}(nil), r.metrics...)
+	r.mu.RUnlock()
+
+	sort.SliceStable(metrics, func(i, j int) bool {
+		a, b := describe(metrics[i]), describe(metrics[j])
+		if a.Section != b.Section {
+			return a.Section < b.Section
+		}
+		return a.Name < b.Name
+	})
+
+	var sections []Section
+	for _, m := range metrics {
+		if _, ok := m.(*family); ok {
+			continue
+		}
+		name := describe(m).Section
+		if len(sections) == 0 || sections[len(sections)-1].Name != name {
+			sections = append(sections, Section{Name: name})
+		}
+		last := &sections[len(sections)-1]
+		last.Values = appendValues(last.Values, m)
+	}
+	return sections
+}
+
+func describe(m interface{}) Desc {
+	switch m := m.(type) {
+	case *Counter:
+		return m.Desc
+	case *Gauge:
+		return m.Desc
+	case *Histogram:
+		return m.Desc
+	case *text:
+		return m.Desc
+	case *family:
+		return m.Desc
+	}
+	return Desc{}
+}
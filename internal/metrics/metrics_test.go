@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram_Quantiles(t *testing.T) {
+	r := NewRegistry()
+	h := r.Histogram("op_latency", "ops", "")
+
+	// 1µs through 100ms, evenly spread
+	for us := 1; us <= 100000; us++ {
+		h.Observe(time.Duration(us) * time.Microsecond)
+	}
+
+	want := []time.Duration{50 * time.Millisecond, 90 * time.Millisecond, 99 * time.Millisecond, 99900 * time.Microsecond}
+	for i, got := range h.Quantiles(Quantiles...) {
+		// Within the 3 significant digits the histogram keeps
+		assert.InEpsilon(t, want[i].Seconds(), got.Seconds(), 0.001, "q=%g", Quantiles[i])
+	}
+	assert.Equal(t, uint64(100000), h.Count())
+	assert.Equal(t, 100*time.Millisecond, h.Max())
+	assert.Equal(t, time.Duration(100000*100001/2)*time.Microsecond, h.Sum())
+
+	// Durations past the range are clamped rather than dropped
+	h.Observe(2 * time.Hour)
+	assert.Equal(t, time.Hour, h.Max())
+}
+
+func TestHistogram_SmallValuesExact(t *testing.T) {
+	h := NewRegistry().Histogram("op_latency", "ops", "")
+	for _, us := range []int{3, 3, 7, 12} {
+		h.Observe(time.Duration(us) * time.Microsecond)
+	}
+	assert.Equal(t, []time.Duration{3 * time.Microsecond, 12 * time.Microsecond}, h.Quantiles(0.5, 1))
+}
+
+func TestRegistry_RestoreAndReset(t *testing.T) {
+	r := NewRegistry()
+	gets := r.Counter("cmd_get", "commands", "")
+	gets.Add(5)
+
+	r.Restore(1000, map[string]uint64{"cmd_get": 40, "shed_total": 2})
+	assert.Equal(t, int64(1000), r.Epoch())
+	assert.Equal(t, uint64(40), gets.Value())
+
+	// Counters registered after the restore pick up their saved counts
+	shed := r.Counter("shed_total", "clients", "")
+	assert.Equal(t, uint64(2), shed.Value())
+	assert.Equal(t, map[string]uint64{"cmd_get": 40, "shed_total": 2}, r.Counters())
+
+	h := r.Histogram("op_latency", "ops", "")
+	h.Observe(time.Millisecond)
+	epoch := r.Reset()
+	assert.Greater(t, epoch, int64(1000))
+	assert.Equal(t, uint64(0), gets.Value())
+	assert.Equal(t, uint64(0), h.Count())
+	assert.Greater(t, r.Reset(), epoch)
+
+	assert.Panics(t, func() { r.Counter("cmd_get", "commands", "") })
+}
+
+func TestRegistry_Values(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("cmd_get", "commands", "").Inc()
+	r.Gauge("keys", "keyspace", "").Set(3)
+	r.Text("wal_current", "persistence", "", func() string { return "wal-1.oswal" })
+	r.Histogram("cmd_latency", "commands", "").Observe(250 * time.Microsecond)
+
+	values := make(map[string]string)
+	var names []string
+	for _, v := range r.Values() {
+		values[v.Name] = v.Value
+		names = append(names, v.Name)
+	}
+	assert.Equal(t, []string{
+		"stats_epoch", "cmd_get", "keys", "wal_current",
+		"cmd_latency_p50_us", "cmd_latency_p90_us", "cmd_latency_p99_us", "cmd_latency_p999_us",
+		"cmd_latency_max_us", "cmd_latency_count",
+	}, names)
+	assert.Equal(t, "1", values["cmd_get"])
+	assert.Equal(t, "3", values["keys"])
+	assert.Equal(t, "wal-1.oswal", values["wal_current"])
+	assert.Equal(t, "250", values["cmd_latency_p99_us"])
+	assert.Equal(t, "1", values["cmd_latency_count"])
+
+}
+
+func TestRegistry_Grouped(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("cmd_set", "commands", "SET commands").Inc()
+	r.Gauge("keys", "keyspace", "Live keys").Set(3)
+	r.Histogram("cmd_latency", "commands", "Command latency")
+	r.Counter("cmd_get", "commands", "GET commands")
+	r.GaugeFamily("tenant_keys", "tenants", "Keys per user", "user", func() map[string]int64 { return nil })
+
+	var sections []string
+	var names []string
+	for _, section := range r.Grouped() {
+		sections = append(sections, section.Name)
+		for _, v := range section.Values {
+			names = append(names, v.Name)
+		}
+	}
+	assert.Equal(t, []string{"commands", "keyspace", "server"}, sections)
+	assert.Equal(t, []string{
+		"cmd_get",
+		"cmd_latency_p50_us", "cmd_latency_p90_us", "cmd_latency_p99_us", "cmd_latency_p999_us",
+		"cmd_latency_max_us", "cmd_latency_count",
+		"cmd_set", "keys", "stats_epoch",
+	}, names)
+
+	set := r.Grouped()[0].Values[7]
+	assert.Equal(t, Value{"cmd_set", "commands", "1", "SET commands"}, set)
+}
+
+func TestRegistry_Prometheus(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("cmd_get", "commands", "GET commands").Add(2)
+	r.Counter("shed_total", "clients", "Shed requests")
+	r.Gauge("keys", "keyspace", "Live keys").Set(3)
+	r.Text("wal_current", "persistence", "", func() string { return "wal-1.oswal" })
+	r.Histogram("cmd_latency", "commands", "Command latency").Observe(2 * time.Millisecond)
+	r.GaugeFamily("tenant_keys", "tenants", "Keys per user", "user", func() map[string]int64 {
+		return map[string]int64{"bob": 1, "alice": 4}
+	})
+	r.CounterFamily("tenant_commands", "tenants", "Commands per user", "user", func() map[string]int64 {
+		return map[string]int64{"alice": 9}
+	})
+
+	rec := httptest.NewRecorder()
+	r.Handler("osprey").ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, 200, rec.Code)
+	body := rec.Body.String()
+
+	assert.Contains(t, body, "# HELP osprey_cmd_get_total GET commands\n# TYPE osprey_cmd_get_total counter\nosprey_cmd_get_total 2\n")
+	assert.Contains(t, body, "osprey_shed_total 0\n")
+	assert.Contains(t, body, "# TYPE osprey_keys gauge\nosprey_keys 3\n")
+	assert.Contains(t, body, "# TYPE osprey_cmd_latency_seconds summary\n")
+	assert.Contains(t, body, "osprey_cmd_latency_seconds{quantile=\"0.99\"} 0.002\n")
+	assert.Contains(t, body, "osprey_cmd_latency_seconds_count 1\n")
+	assert.Contains(t, body, "# TYPE osprey_tenant_keys gauge\nosprey_tenant_keys{user=\"alice\"} 4\nosprey_tenant_keys{user=\"bob\"} 1\n")
+	assert.Contains(t, body, "# TYPE osprey_tenant_commands_total counter\nosprey_tenant_commands_total{user=\"alice\"} 9\n")
+	assert.NotContains(t, body, "wal_current")
+	assert.False(t, strings.Contains(body, "_total_total"))
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WritePrometheus writes every numeric metric in the Prometheus text
+// exposition format, with names prefixed by namespace_. Counters get a
+// _total suffix if they don't have one; histograms become summaries in
+// seconds.
+func (r *Registry) WritePrometheus(w io.Writer, namespace string) error {
+	r.mu.RLock()
+	metrics := append([]interface{}(nil), r.metrics...)
+	r.mu.RUnlock()
+
+	var b strings.Builder
+	for _, m := range metrics {
+		switch m := m.(type) {
+		case *Counter:
+			name := namespace + "_" + m.Name
+			if !strings.HasSuffix(name, "_total") {
+				name += "_total"
+			}
+			writeHeader(&b, name, m.Help, "counter")
+			fmt.Fprintf(&b, "%s %d\n", name, m.Value())
+		case *Gauge:
+			name := namespace + "_" + m.Name
+			writeHeader(&b, name, m.Help, "gauge")
+			fmt.Fprintf(&b, "%s %d\n", name, m.Value())
+		case *Histogram:
+			name := namespace + "_" + m.Name + "_seconds"
+			writeHeader(&b, name, m.Help, "summary")
+			for i, v := range m.Quantiles(Quantiles...) {
+				fmt.Fprintf(&b, "%s{quantile=\"%g\"} %g\n", name, Quantiles[i], v.Seconds())
+			}
+			fmt.Fprintf(&b, "%s_sum %g\n", name, m.Sum().Seconds())
+			fmt.Fprintf(&b, "%s_count %d\n", name, m.Count())
+		case *family:
+			name := namespace + "_" + m.Name
+			if m.kind == "counter" && !strings.HasSuffix(name, "_total") {
+				name += "_total"
+			}
+			writeHeader(&b, name, m.Help, m.kind)
+			values := m.fn()
+			labels := make([]string, 0, len(values))
+			for label := range values {
+				labels = append(labels, label)
+			}
+			sort.Strings(labels)
+			for _, label := range labels {
+				fmt.Fprintf(&b, "%s{%s=%s} %d\n", name, m.label, strconv.Quote(label), values[label])
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHeader(b *strings.Builder, name, help, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, kind)
+}
+
+// Handler serves the registry for Prometheus to scrape
+func (r *Registry) Handler(namespace string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WritePrometheus(w, namespace)
+	})
+}
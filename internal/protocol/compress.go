@@ -0,0 +1,74 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CompressionDeflate is the only payload compression currently supported.
+// Compressed payloads are marked by the upper-cased algorithm name followed
+// by the uncompressed length, e.g. "VALUE 812 3 0 DEFLATE 40960".
+const CompressionDeflate = "deflate"
+
+// NegotiateCompression picks the first algorithm in a comma-separated
+// offer that the server supports, or "" if there is none
+func NegotiateCompression(offer string) string {
+	for _, algo := range strings.Split(offer, ",") {
+		if strings.ToLower(strings.TrimSpace(algo)) == CompressionDeflate {
+			return CompressionDeflate
+		}
+	}
+	return ""
+}
+
+// IsCompression reports whether token names a supported algorithm
+func IsCompression(token string) bool {
+	return strings.ToLower(token) == CompressionDeflate
+}
+
+// Compress compresses data with algo. ok is false when compression would
+// not make the payload smaller, in which case it should be sent as is.
+func Compress(algo string, data []byte) (compressed []byte, ok bool) {
+	if algo != CompressionDeflate {
+		return nil, false
+	}
+
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, false
+	}
+	if err := fw.Close(); err != nil {
+		return nil, false
+	}
+	if buf.Len() >= len(data) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// Decompress inflates a payload that must expand to exactly rawLen bytes.
+// Output is capped at rawLen so a hostile payload can't expand further.
+func Decompress(algo string, data []byte, rawLen int) ([]byte, error) {
+	if !IsCompression(algo) {
+		return nil, fmt.Errorf("unsupported compression: %s", algo)
+	}
+
+	fr := flate.NewReader(bytes.NewReader(data))
+	defer fr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(fr, int64(rawLen)+1))
+	if err != nil {
+		return nil, ErrInvalidPayload
+	}
+	if len(out) != rawLen {
+		return nil, ErrInvalidPayload
+	}
+	return out, nil
+}
@@ -9,10 +9,20 @@ import (
 	"strings"
 )
 
+// MaxTraceIDLen bounds the trace ID a command can be prefixed with
+const MaxTraceIDLen = 128
+
+// maxChunkPrealloc caps the buffer reserved up front for a chunked upload,
+// so a bogus total length can't allocate memory before data arrives
+const maxChunkPrealloc = 1024 * 1024
+
 var (
 	ErrInvalidCommand = errors.New("invalid command")
 	ErrInvalidArgs    = errors.New("invalid arguments")
 	ErrInvalidPayload = errors.New("invalid payload")
+	ErrInvalidTraceID = errors.New("invalid trace ID")
+	ErrLineTooLong    = errors.New("command line too long")
+	ErrValueTooLarge  = errors.New("value too large")
 )
 
 // Command represents a parsed command
@@ -20,11 +30,17 @@ type Command struct {
 	Name    string
 	Args    []string
 	Payload []byte
+
+	// Opaque ID from an @<id> prefix, e.g. "@req-42 GET key", that the
+	// server logs with the command; "" when there was none
+	TraceID string
 }
 
 // Parser handles protocol parsing
 type Parser struct {
-	reader *bufio.Reader
+	reader        *bufio.Reader
+	maxLineBytes  int // 0 for no limit
+	maxValueBytes int // inflated size of compressed payloads; 0 for no limit
 }
 
 // NewParser creates a new protocol parser
@@ -34,10 +50,44 @@ func NewParser(r io.Reader) *Parser {
 	}
 }
 
+// SetMaxLineBytes limits command lines, and the CHUNK lines of chunked
+// payloads, to n bytes including the line ending. Longer lines fail with
+// ErrLineTooLong, after which the input is not at a command boundary.
+// 0 removes the limit.
+func (p *Parser) SetMaxLineBytes(n int) {
+	p.maxLineBytes = n
+}
+
+// SetMaxValueBytes limits the size compressed payloads may inflate to.
+// Payloads declaring a larger size fail with ErrValueTooLarge without
+// being inflated. 0 removes the limit.
+func (p *Parser) SetMaxValueBytes(n int) {
+	p.maxValueBytes = n
+}
+
+// readLine reads up to and including the next \n, within maxLineBytes
+func (p *Parser) readLine() (string, error) {
+	if p.maxLineBytes <= 0 {
+		return p.reader.ReadString('\n')
+	}
+
+	var line []byte
+	for {
+		frag, err := p.reader.ReadSlice('\n')
+		if len(line)+len(frag) > p.maxLineBytes {
+			return "", ErrLineTooLong
+		}
+		line = append(line, frag...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
 // ParseCommand parses a single command from the input
 func (p *Parser) ParseCommand() (*Command, error) {
 	// Read command line
-	line, err := p.reader.ReadString('\n')
+	line, err := p.readLine()
 	if err != nil {
 		return nil, err
 	}
@@ -56,9 +106,22 @@ func (p *Parser) ParseCommand() (*Command, error) {
 		return nil, ErrInvalidCommand
 	}
 
+	var traceID string
+	if strings.HasPrefix(parts[0], "@") {
+		traceID = parts[0][1:]
+		parts = parts[1:]
+		if traceID == "" || len(traceID) > MaxTraceIDLen {
+			return nil, ErrInvalidTraceID
+		}
+		if len(parts) == 0 {
+			return nil, ErrInvalidCommand
+		}
+	}
+
 	cmd := &Command{
-		Name: strings.ToUpper(parts[0]),
-		Args: parts[1:],
+		Name:    strings.ToUpper(parts[0]),
+		Args:    parts[1:],
+		TraceID: traceID,
 	}
 
 	// Check if command requires payload
@@ -76,9 +139,9 @@ func (p *Parser) ParseCommand() (*Command, error) {
 // requiresPayload checks if the command requires a payload
 func (cmd *Command) requiresPayload() bool {
 	switch cmd.Name {
-	case "SET":
+	case "SET", "APPEND", "SETRANGE", "CAS", "PUBLISH":
 		return true
-	case "MSET":
+	case "MSET", "CHECKSET", "HSET":
 		return true
 	default:
 		return false
@@ -88,30 +151,117 @@ func (cmd *Command) requiresPayload() bool {
 // readPayload reads the payload for commands that require it
 func (p *Parser) readPayload(cmd *Command) ([]byte, error) {
 	switch cmd.Name {
-	case "SET":
-		return p.readSinglePayload(cmd)
+	case "SET", "APPEND", "PUBLISH":
+		return p.readSinglePayload(cmd, 1)
+	case "SETRANGE":
+		return p.readSinglePayload(cmd, 2)
+	case "CAS":
+		return p.readCASPayload(cmd)
 	case "MSET":
-		return p.readMultiPayload(cmd)
+		entries, err := MSetEntries(cmd.Args)
+		if err != nil {
+			return nil, err
+		}
+		lengths := make([]int, len(entries))
+		for i, e := range entries {
+			lengths[i] = e.Length
+		}
+		return p.readMultiPayload(lengths)
+	case "CHECKSET":
+		writes, err := CheckSetWrites(cmd.Args)
+		if err != nil {
+			return nil, err
+		}
+		lengths, err := pairLengths(writes)
+		if err != nil {
+			return nil, err
+		}
+		return p.readMultiPayload(lengths)
+	case "HSET":
+		if len(cmd.Args) < 3 {
+			return nil, ErrInvalidArgs
+		}
+		lengths, err := pairLengths(cmd.Args[1:])
+		if err != nil {
+			return nil, err
+		}
+		return p.readMultiPayload(lengths)
 	default:
 		return nil, nil
 	}
 }
 
-// readSinglePayload reads a single payload for SET command
-func (p *Parser) readSinglePayload(cmd *Command) ([]byte, error) {
-	if len(cmd.Args) < 2 {
+// readSinglePayload reads a single payload for SET, APPEND, SETRANGE and
+// PUBLISH, whose length is args[lenArg] and whose options follow it
+func (p *Parser) readSinglePayload(cmd *Command, lenArg int) ([]byte, error) {
+	if len(cmd.Args) <= lenArg {
 		return nil, ErrInvalidArgs
 	}
 
-	// Parse length from args[1]
-	length, err := strconv.Atoi(cmd.Args[1])
+	length, err := strconv.Atoi(cmd.Args[lenArg])
 	if err != nil || length < 0 {
 		return nil, ErrInvalidArgs
 	}
 
+	// A DEFLATE <rawlen> option means the payload is compressed and
+	// inflates to rawlen bytes
+	algo, rawLen := "", 0
+	for i := lenArg + 1; i < len(cmd.Args); i++ {
+		if IsCompression(cmd.Args[i]) {
+			if i+1 >= len(cmd.Args) {
+				return nil, ErrInvalidArgs
+			}
+			rawLen, err = strconv.Atoi(cmd.Args[i+1])
+			if err != nil || rawLen < 0 {
+				return nil, ErrInvalidArgs
+			}
+			algo = cmd.Args[i]
+			cmd.Args = append(cmd.Args[:i], cmd.Args[i+2:]...)
+			break
+		}
+	}
+
+	payload, err := p.readSingleBody(cmd, length, lenArg+1)
+	if err != nil {
+		return nil, err
+	}
+	if algo != "" {
+		if p.maxValueBytes > 0 && rawLen > p.maxValueBytes {
+			return nil, ErrValueTooLarge
+		}
+		return Decompress(algo, payload, rawLen)
+	}
+	return payload, nil
+}
+
+// readCASPayload reads the payload of CAS <key> <expected_len> <new_len>:
+// the expected value followed by the new one
+func (p *Parser) readCASPayload(cmd *Command) ([]byte, error) {
+	if len(cmd.Args) < 3 {
+		return nil, ErrInvalidArgs
+	}
+	expectedLen, err1 := strconv.Atoi(cmd.Args[1])
+	newLen, err2 := strconv.Atoi(cmd.Args[2])
+	if err1 != nil || err2 != nil || expectedLen < 0 || newLen < 0 {
+		return nil, ErrInvalidArgs
+	}
+	return p.readSingleBody(cmd, expectedLen+newLen, 3)
+}
+
+// readSingleBody reads a SET payload of length bytes, either inline or
+// as CHUNK frames. Options start at args[from].
+func (p *Parser) readSingleBody(cmd *Command, length, from int) ([]byte, error) {
+	// A CHUNKED option means the payload follows as CHUNK frames
+	for i := from; i < len(cmd.Args); i++ {
+		if strings.ToUpper(cmd.Args[i]) == "CHUNKED" {
+			cmd.Args = append(cmd.Args[:i], cmd.Args[i+1:]...)
+			return p.readChunkedPayload(length)
+		}
+	}
+
 	// Read the payload
 	payload := make([]byte, length)
-	_, err = io.ReadFull(p.reader, payload)
+	_, err := io.ReadFull(p.reader, payload)
 	if err != nil {
 		return nil, err
 	}
@@ -130,25 +280,136 @@ func (p *Parser) readSinglePayload(cmd *Command) ([]byte, error) {
 	return payload, nil
 }
 
-// readMultiPayload reads multiple payloads for MSET command
-func (p *Parser) readMultiPayload(cmd *Command) ([]byte, error) {
-	// MSET format: MSET k1 len1 k2 len2 ...
-	// Followed by concatenated payloads
+// readChunkedPayload reads CHUNK frames until total bytes have arrived.
+// Each frame is "CHUNK <offset> <len>\r\n<data>\r\n" and offsets must be
+// contiguous, starting at zero.
+func (p *Parser) readChunkedPayload(total int) ([]byte, error) {
+	payload := make([]byte, 0, min(total, maxChunkPrealloc))
+
+	for len(payload) < total {
+		line, err := p.readLine()
+		if err != nil {
+			return nil, err
+		}
 
-	if len(cmd.Args)%2 != 0 {
+		parts := strings.Fields(line)
+		if len(parts) != 3 || strings.ToUpper(parts[0]) != "CHUNK" {
+			return nil, ErrInvalidPayload
+		}
+
+		offset, err := strconv.Atoi(parts[1])
+		if err != nil || offset != len(payload) {
+			return nil, ErrInvalidPayload
+		}
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n <= 0 || n > total-offset {
+			return nil, ErrInvalidPayload
+		}
+
+		payload = append(payload, make([]byte, n)...)
+		if _, err := io.ReadFull(p.reader, payload[offset:]); err != nil {
+			return nil, err
+		}
+
+		crlf := make([]byte, 2)
+		if _, err := io.ReadFull(p.reader, crlf); err != nil {
+			return nil, err
+		}
+		if crlf[0] != '\r' || crlf[1] != '\n' {
+			return nil, ErrInvalidPayload
+		}
+	}
+
+	return payload, nil
+}
+
+// CheckSetWrites returns the key/length pairs of a CHECKSET command's
+// args: CHECKSET <n> k1 ver1 ... kn vern k1 len1 k2 len2 ...
+func CheckSetWrites(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, ErrInvalidArgs
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 || n > (len(args)-1)/2 {
 		return nil, ErrInvalidArgs
 	}
+	return args[1+2*n:], nil
+}
 
-	totalLength := 0
-	lengths := []int{}
+// MSetEntry is one key of an MSET command:
+// MSET k1 len1 [EX <ms>] [NX] k2 len2 [EX <ms>] [NX] ...
+type MSetEntry struct {
+	Index    int // of the key in the command's args
+	Length   int
+	ExpiryMs int64 // 0 if the key has no EX
+	NX       bool
+}
+
+// MSetEntries parses the args of an MSET command. Options are recognised
+// after a key's length, so a key named EX or NX must come first.
+func MSetEntries(args []string) ([]MSetEntry, error) {
+	var entries []MSetEntry
+	for i := 0; i < len(args); {
+		if i+1 >= len(args) {
+			return nil, ErrInvalidArgs
+		}
+		length, err := strconv.Atoi(args[i+1])
+		if err != nil || length < 0 {
+			return nil, ErrInvalidArgs
+		}
+		entry := MSetEntry{Index: i, Length: length}
+		i += 2
+
+	options:
+		for i < len(args) {
+			switch strings.ToUpper(args[i]) {
+			case "EX":
+				if i+1 >= len(args) {
+					return nil, ErrInvalidArgs
+				}
+				ms, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || ms <= 0 {
+					return nil, ErrInvalidArgs
+				}
+				entry.ExpiryMs = ms
+				i += 2
+			case "NX":
+				entry.NX = true
+				i++
+			default:
+				break options
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil, ErrInvalidArgs
+	}
+	return entries, nil
+}
+
+// pairLengths returns the lengths of key/length pairs: k1 len1 k2 len2 ...
+func pairLengths(args []string) ([]int, error) {
+	if len(args)%2 != 0 {
+		return nil, ErrInvalidArgs
+	}
 
-	// Parse all lengths
-	for i := 1; i < len(cmd.Args); i += 2 {
-		length, err := strconv.Atoi(cmd.Args[i])
+	var lengths []int
+	for i := 1; i < len(args); i += 2 {
+		length, err := strconv.Atoi(args[i])
 		if err != nil || length < 0 {
 			return nil, ErrInvalidArgs
 		}
 		lengths = append(lengths, length)
+	}
+	return lengths, nil
+}
+
+// readMultiPayload reads the concatenated payloads of MSET, CHECKSET and HSET,
+// with the given lengths
+func (p *Parser) readMultiPayload(lengths []int) ([]byte, error) {
+	totalLength := 0
+	for _, length := range lengths {
 		totalLength += length
 	}
 
@@ -187,9 +448,41 @@ func WriteOK(w io.Writer) error {
 	return err
 }
 
-// WriteOKWithVersion writes an OK response with version
-func WriteOKWithVersion(w io.Writer, version uint64) error {
-	_, err := fmt.Fprintf(w, "OK %d\r\n", version)
+// WriteOKWithVersion writes an OK response with version. Flags such as
+// WarnFlag's are appended.
+func WriteOKWithVersion(w io.Writer, version uint64, flags ...string) error {
+	_, err := fmt.Fprintf(w, "OK %d%s\r\n", version, valueFlags(flags))
+	return err
+}
+
+// WriteAppended writes an APPEND or SETRANGE response: the new version
+// and length, then any flags. HSET replies the same way with the number
+// of fields added.
+func WriteAppended(w io.Writer, version uint64, length int, flags ...string) error {
+	_, err := fmt.Fprintf(w, "OK %d %d%s\r\n", version, length, valueFlags(flags))
+	return err
+}
+
+// WarnPrefix starts the flag on a write reply naming the soft limits the
+// write crossed, see WarnFlag
+const WarnPrefix = "WARN="
+
+// WarnFlag formats soft limit warnings as a reply flag, for example
+// WARN=value_size,memory
+func WarnFlag(reasons []string) string {
+	return WarnPrefix + strings.Join(reasons, ",")
+}
+
+// WriteMessage writes a message published to a channel to one of its
+// subscribers: MESSAGE <channel> <len>\r\n<payload>\r\n
+func WriteMessage(w io.Writer, channel string, payload []byte) error {
+	if _, err := fmt.Fprintf(w, "MESSAGE %s %d\r\n", channel, len(payload)); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte("\r\n"))
 	return err
 }
 
@@ -205,9 +498,22 @@ func WriteNotFound(w io.Writer) error {
 	return err
 }
 
-// WriteValue writes a VALUE response with payload
-func WriteValue(w io.Writer, length int, version uint64, expiryMs int64, value []byte) error {
-	_, err := fmt.Fprintf(w, "VALUE %d %d %d\r\n", length, version, expiryMs)
+// ValueSoftExpired flags a VALUE response for a key that should be
+// refreshed early, see GET EARLY
+const ValueSoftExpired = "SOFTEXP"
+
+// valueFlags formats flags to follow the fields of a VALUE or OK line
+func valueFlags(flags []string) string {
+	if len(flags) == 0 {
+		return ""
+	}
+	return " " + strings.Join(flags, " ")
+}
+
+// WriteValue writes a VALUE response with payload. Flags such as
+// ValueSoftExpired are appended to the VALUE line.
+func WriteValue(w io.Writer, length int, version uint64, expiryMs int64, value []byte, flags ...string) error {
+	_, err := fmt.Fprintf(w, "VALUE %d %d %d%s\r\n", length, version, expiryMs, valueFlags(flags))
 	if err != nil {
 		return err
 	}
@@ -221,6 +527,70 @@ func WriteValue(w io.Writer, length int, version uint64, expiryMs int64, value [
 	return err
 }
 
+// WriteValueCompressed writes a VALUE response whose payload was
+// compressed with algo from rawLen bytes
+func WriteValueCompressed(w io.Writer, algo string, rawLen int, version uint64, expiryMs int64, compressed []byte, flags ...string) error {
+	_, err := fmt.Fprintf(w, "VALUE %d %d %d %s %d%s\r\n", len(compressed), version, expiryMs, strings.ToUpper(algo), rawLen, valueFlags(flags))
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(compressed); err != nil {
+		return err
+	}
+
+	_, err = w.Write([]byte("\r\n"))
+	return err
+}
+
+// WriteValueChunked writes a VALUE response whose payload is split into
+// CHUNK frames of at most chunkSize bytes. The writer is flushed after
+// every frame so large values don't sit in the connection buffer.
+func WriteValueChunked(w io.Writer, version uint64, expiryMs int64, value []byte, chunkSize int, flags ...string) error {
+	if _, err := fmt.Fprintf(w, "VALUE %d %d %d CHUNKED%s\r\n", len(value), version, expiryMs, valueFlags(flags)); err != nil {
+		return err
+	}
+
+	for offset := 0; offset < len(value); offset += chunkSize {
+		end := min(offset+chunkSize, len(value))
+		if _, err := fmt.Fprintf(w, "CHUNK %d %d\r\n", offset, end-offset); err != nil {
+			return err
+		}
+		if _, err := w.Write(value[offset:end]); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte("\r\n")); err != nil {
+			return err
+		}
+		if f, ok := w.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// WriteFile writes one FILE frame of a SYNC response, copying size bytes
+// from r
+func WriteFile(w io.Writer, name string, size int64, r io.Reader) error {
+	if _, err := fmt.Fprintf(w, "FILE %s %d\r\n", name, size); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(w, r, size); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte("\r\n"))
+	return err
+}
+
+// WriteMeta writes a META response
+func WriteMeta(w io.Writer, size int, version uint64, expiryMs, ttl, createdMs, updatedMs int64, valueType string) error {
+	_, err := fmt.Fprintf(w, "META %d %d %d %d %d %d %s\r\n", size, version, expiryMs, ttl, createdMs, updatedMs, valueType)
+	return err
+}
+
 // WriteDeleted writes a DELETED response
 func WriteDeleted(w io.Writer, deleted bool) error {
 	val := 0
@@ -241,12 +611,33 @@ func WriteExists(w io.Writer, exists bool) error {
 	return err
 }
 
+// WriteExistsFlags writes an EXISTS response with one 0 or 1 for each key,
+// such as "EXISTS 101"
+func WriteExistsFlags(w io.Writer, exists []bool) error {
+	flags := make([]byte, len(exists))
+	for i, e := range exists {
+		flags[i] = '0'
+		if e {
+			flags[i] = '1'
+		}
+	}
+	_, err := fmt.Fprintf(w, "EXISTS %s\r\n", flags)
+	return err
+}
+
 // WriteTTL writes a TTL response
 func WriteTTL(w io.Writer, ttl int64) error {
 	_, err := fmt.Fprintf(w, "%d\r\n", ttl)
 	return err
 }
 
+// WriteFloat writes a FLOAT response with a number formatted by the
+// caller (for INCRBYFLOAT)
+func WriteFloat(w io.Writer, value string) error {
+	_, err := fmt.Fprintf(w, "FLOAT %s\r\n", value)
+	return err
+}
+
 // WriteInteger writes an integer response (for INCR/DECR)
 func WriteInteger(w io.Writer, value int64) error {
 	_, err := fmt.Fprintf(w, "%d\r\n", value)
@@ -2,6 +2,8 @@ package protocol
 
 import (
 	"bytes"
+	"fmt"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -117,6 +119,15 @@ func TestParser_ParseCommand_SET(t *testing.T) {
 				Payload: []byte(""),
 			},
 		},
+		{
+			name:  "SET chunked",
+			input: "SET key1 11 CHUNKED EX 1000\r\nCHUNK 0 6\r\nhello \r\nCHUNK 6 5\r\nworld\r\n",
+			expected: &Command{
+				Name:    "SET",
+				Args:    []string{"key1", "11", "EX", "1000"},
+				Payload: []byte("hello world"),
+			},
+		},
 		{
 			name:  "SET with binary data",
 			input: "SET key1 4\r\n\x00\x01\x02\x03\r\n",
@@ -156,6 +167,82 @@ func TestParser_ParseCommand_MSET(t *testing.T) {
 	assert.Equal(t, expected.Payload, cmd.Payload)
 }
 
+func TestParser_ParseCommand_MSETOptions(t *testing.T) {
+	parser := NewParser(strings.NewReader("MSET NX 2 EX 5000 b 3 nx c 1\r\nhibarz\r\n"))
+	cmd, err := parser.ParseCommand()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hibarz"), cmd.Payload)
+
+	entries, err := MSetEntries(cmd.Args)
+	require.NoError(t, err)
+	assert.Equal(t, []MSetEntry{
+		{Index: 0, Length: 2, ExpiryMs: 5000},
+		{Index: 4, Length: 3, NX: true},
+		{Index: 7, Length: 1},
+	}, entries)
+
+	for _, args := range [][]string{{"a", "1", "EX"}, {"a", "1", "EX", "0"}, {"a", "1", "NX", "b"}} {
+		_, err := MSetEntries(args)
+		assert.ErrorIs(t, err, ErrInvalidArgs, args)
+	}
+}
+
+func TestParser_ParseCommand_APPEND(t *testing.T) {
+	parser := NewParser(strings.NewReader("APPEND log 6\r\nline 1\r\n"))
+	cmd, err := parser.ParseCommand()
+	require.NoError(t, err)
+	assert.Equal(t, "APPEND", cmd.Name)
+	assert.Equal(t, []string{"log", "6"}, cmd.Args)
+	assert.Equal(t, []byte("line 1"), cmd.Payload)
+}
+
+func TestParser_ParseCommand_PUBLISH(t *testing.T) {
+	parser := NewParser(strings.NewReader("PUBLISH news 5\r\nhello\r\n"))
+	cmd, err := parser.ParseCommand()
+	require.NoError(t, err)
+	assert.Equal(t, "PUBLISH", cmd.Name)
+	assert.Equal(t, []string{"news", "5"}, cmd.Args)
+	assert.Equal(t, []byte("hello"), cmd.Payload)
+}
+
+func TestParser_ParseCommand_SETRANGE(t *testing.T) {
+	parser := NewParser(strings.NewReader("SETRANGE blob 4 3\r\nabc\r\nSETRANGE blob 0 2 CHUNKED\r\nCHUNK 0 2\r\nxy\r\n"))
+	cmd, err := parser.ParseCommand()
+	require.NoError(t, err)
+	assert.Equal(t, "SETRANGE", cmd.Name)
+	assert.Equal(t, []string{"blob", "4", "3"}, cmd.Args)
+	assert.Equal(t, []byte("abc"), cmd.Payload)
+
+	cmd, err = parser.ParseCommand()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"blob", "0", "2"}, cmd.Args)
+	assert.Equal(t, []byte("xy"), cmd.Payload)
+}
+
+func TestParser_ParseCommand_CHECKSET(t *testing.T) {
+	input := "CHECKSET 2 a 3 b 0 a 5 b 3\r\nhellobar\r\n"
+
+	parser := NewParser(strings.NewReader(input))
+	cmd, err := parser.ParseCommand()
+	require.NoError(t, err)
+	assert.Equal(t, "CHECKSET", cmd.Name)
+	assert.Equal(t, []string{"2", "a", "3", "b", "0", "a", "5", "b", "3"}, cmd.Args)
+	assert.Equal(t, []byte("hellobar"), cmd.Payload)
+
+	writes, err := CheckSetWrites(cmd.Args)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "5", "b", "3"}, writes)
+}
+
+func TestParser_ParseCommand_CAS(t *testing.T) {
+	parser := NewParser(strings.NewReader("CAS lock 4 3\r\nfreeown\r\n"))
+	cmd, err := parser.ParseCommand()
+	require.NoError(t, err)
+	assert.Equal(t, "CAS", cmd.Name)
+	assert.Equal(t, []string{"lock", "4", "3"}, cmd.Args)
+	assert.Equal(t, []byte("freeown"), cmd.Payload)
+}
+
 func TestParser_ParseCommand_Errors(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -181,6 +268,22 @@ func TestParser_ParseCommand_Errors(t *testing.T) {
 			name:  "MSET with odd number of args",
 			input: "MSET key1 5 key2\r\nhello\r\n",
 		},
+		{
+			name:  "CAS missing new length",
+			input: "CAS key1 5\r\nhello\r\n",
+		},
+		{
+			name:  "CHECKSET with fewer checks than counted",
+			input: "CHECKSET 3 a 1 b 1\r\n\r\n",
+		},
+		{
+			name:  "Empty trace ID",
+			input: "@ GET key1\r\n",
+		},
+		{
+			name:  "Trace ID without command",
+			input: "@req-1\r\n",
+		},
 	}
 
 	for _, tt := range tests {
@@ -192,6 +295,28 @@ func TestParser_ParseCommand_Errors(t *testing.T) {
 	}
 }
 
+func TestParser_ParseCommand_TraceID(t *testing.T) {
+	parser := NewParser(strings.NewReader("@req-42 get key1\r\n@req-43 SET key1 5\r\nhello\r\nGET key1\r\n"))
+
+	cmd, err := parser.ParseCommand()
+	require.NoError(t, err)
+	assert.Equal(t, &Command{Name: "GET", Args: []string{"key1"}, TraceID: "req-42"}, cmd)
+
+	// The prefix doesn't get in the way of reading the payload
+	cmd, err = parser.ParseCommand()
+	require.NoError(t, err)
+	assert.Equal(t, "SET", cmd.Name)
+	assert.Equal(t, "req-43", cmd.TraceID)
+	assert.Equal(t, []byte("hello"), cmd.Payload)
+
+	cmd, err = parser.ParseCommand()
+	require.NoError(t, err)
+	assert.Empty(t, cmd.TraceID)
+
+	_, err = NewParser(strings.NewReader("@" + strings.Repeat("x", MaxTraceIDLen+1) + " PING\r\n")).ParseCommand()
+	assert.ErrorIs(t, err, ErrInvalidTraceID)
+}
+
 func TestParser_CaseInsensitive(t *testing.T) {
 	tests := []string{
 		"ping\r\n",
@@ -232,6 +357,33 @@ func TestResponseWriters(t *testing.T) {
 			},
 			expected: "OK 42\r\n",
 		},
+		{
+			name: "WriteAppended",
+			writer: func() ([]byte, error) {
+				var buf bytes.Buffer
+				err := WriteAppended(&buf, 3, 11)
+				return buf.Bytes(), err
+			},
+			expected: "OK 3 11\r\n",
+		},
+		{
+			name: "WriteMessage",
+			writer: func() ([]byte, error) {
+				var buf bytes.Buffer
+				err := WriteMessage(&buf, "news", []byte("a\r\nb"))
+				return buf.Bytes(), err
+			},
+			expected: "MESSAGE news 4\r\na\r\nb\r\n",
+		},
+		{
+			name: "WriteAppended with warning",
+			writer: func() ([]byte, error) {
+				var buf bytes.Buffer
+				err := WriteAppended(&buf, 3, 11, WarnFlag([]string{"value_size", "memory"}))
+				return buf.Bytes(), err
+			},
+			expected: "OK 3 11 WARN=value_size,memory\r\n",
+		},
 		{
 			name: "WritePong",
 			writer: func() ([]byte, error) {
@@ -286,6 +438,15 @@ func TestResponseWriters(t *testing.T) {
 			},
 			expected: "EXISTS 1\r\n",
 		},
+		{
+			name: "WriteExistsFlags",
+			writer: func() ([]byte, error) {
+				var buf bytes.Buffer
+				err := WriteExistsFlags(&buf, []bool{true, false, true})
+				return buf.Bytes(), err
+			},
+			expected: "EXISTS 101\r\n",
+		},
 		{
 			name: "WriteTTL",
 			writer: func() ([]byte, error) {
@@ -315,6 +476,69 @@ func TestResponseWriters(t *testing.T) {
 	}
 }
 
+func TestParser_ParseCommand_ChunkedErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"Gap in offsets", "SET key1 4 CHUNKED\r\nCHUNK 0 2\r\nab\r\nCHUNK 3 1\r\nd\r\n"},
+		{"Frame past total", "SET key1 4 CHUNKED\r\nCHUNK 0 5\r\nabcde\r\n"},
+		{"Not a frame", "SET key1 4 CHUNKED\r\nabcd\r\n"},
+		{"Oversized second frame", "SET key1 4 CHUNKED\r\nCHUNK 0 2\r\nab\r\nCHUNK 2 9223372036854775807\r\ncd\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewParser(strings.NewReader(tt.input))
+			_, err := parser.ParseCommand()
+			assert.Equal(t, ErrInvalidPayload, err)
+		})
+	}
+}
+
+func TestParser_MaxLineBytes(t *testing.T) {
+	// Lines longer than the bufio buffer are read in fragments
+	long := "GET " + strings.Repeat("k", 5000) + "\r\n"
+
+	parser := NewParser(strings.NewReader(long))
+	parser.SetMaxLineBytes(len(long))
+	cmd, err := parser.ParseCommand()
+	require.NoError(t, err)
+	assert.Len(t, cmd.Args[0], 5000)
+
+	parser = NewParser(strings.NewReader(long))
+	parser.SetMaxLineBytes(len(long) - 1)
+	_, err = parser.ParseCommand()
+	assert.Equal(t, ErrLineTooLong, err)
+
+	parser = NewParser(strings.NewReader("SET k 4 CHUNKED\r\nCHUNK 0 4" + strings.Repeat(" ", 64) + "\r\nabcd\r\n"))
+	parser.SetMaxLineBytes(32)
+	_, err = parser.ParseCommand()
+	assert.Equal(t, ErrLineTooLong, err)
+}
+
+func TestWriteValueChunked(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteValueChunked(&buf, 42, -1, []byte("hello world"), 4)
+	require.NoError(t, err)
+
+	expected := "VALUE 11 42 -1 CHUNKED\r\n" +
+		"CHUNK 0 4\r\nhell\r\n" +
+		"CHUNK 4 4\r\no wo\r\n" +
+		"CHUNK 8 3\r\nrld\r\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestWriteValue_Flags(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteValue(&buf, 5, 3, 1700000000000, []byte("hello"), ValueSoftExpired))
+	assert.Equal(t, "VALUE 5 3 1700000000000 SOFTEXP\r\nhello\r\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, WriteValueChunked(&buf, 3, 0, []byte("hello"), 4, ValueSoftExpired))
+	assert.True(t, strings.HasPrefix(buf.String(), "VALUE 5 3 0 CHUNKED SOFTEXP\r\n"))
+}
+
 func TestWriteValue(t *testing.T) {
 	var buf bytes.Buffer
 	value := []byte("hello world")
@@ -324,3 +548,51 @@ func TestWriteValue(t *testing.T) {
 	expected := "VALUE 11 42 1234567890\r\nhello world\r\n"
 	assert.Equal(t, expected, buf.String())
 }
+
+func TestWriteFile(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteFile(&buf, "snap-00000001.osnap", 5, strings.NewReader("hello world"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "FILE snap-00000001.osnap 5\r\nhello\r\n", buf.String())
+}
+
+func TestParser_ParseCommand_Compressed(t *testing.T) {
+	value := bytes.Repeat([]byte("osprey "), 100)
+	compressed, ok := Compress(CompressionDeflate, value)
+	require.True(t, ok)
+
+	input := fmt.Sprintf("SET key1 %d EX 1000 DEFLATE %d\r\n%s\r\n", len(compressed), len(value), compressed)
+	cmd, err := NewParser(strings.NewReader(input)).ParseCommand()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key1", strconv.Itoa(len(compressed)), "EX", "1000"}, cmd.Args)
+	assert.Equal(t, value, cmd.Payload)
+
+	// The raw length must match exactly, which also caps what a payload
+	// may inflate to
+	input = fmt.Sprintf("SET key1 %d DEFLATE %d\r\n%s\r\n", len(compressed), len(value)-1, compressed)
+	_, err = NewParser(strings.NewReader(input)).ParseCommand()
+	assert.Equal(t, ErrInvalidPayload, err)
+
+	// Claimed sizes past max_value_bytes are rejected before inflating,
+	// and the connection stays at a command boundary
+	input = fmt.Sprintf("SET key1 %d DEFLATE %d\r\n%s\r\nPING\r\n", len(compressed), len(value), compressed)
+	parser := NewParser(strings.NewReader(input))
+	parser.SetMaxValueBytes(len(value) - 1)
+	_, err = parser.ParseCommand()
+	assert.Equal(t, ErrValueTooLarge, err)
+	cmd, err = parser.ParseCommand()
+	require.NoError(t, err)
+	assert.Equal(t, "PING", cmd.Name)
+
+	// Incompressible data is left alone
+	_, ok = Compress(CompressionDeflate, []byte("abc"))
+	assert.False(t, ok)
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	assert.Equal(t, CompressionDeflate, NegotiateCompression("lz4,deflate"))
+	assert.Equal(t, CompressionDeflate, NegotiateCompression("DEFLATE"))
+	assert.Equal(t, "", NegotiateCompression("lz4"))
+	assert.Equal(t, "", NegotiateCompression("none"))
+}
@@ -0,0 +1,167 @@
+// Package proxyproto reads the PROXY protocol header (versions 1 and 2)
+// that load balancers such as HAProxy prepend to a connection to pass on
+// the original client address.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// v1MaxLen is the longest v1 header allowed by the spec, CRLF included
+const v1MaxLen = 107
+
+// v2Signature starts every version 2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrNoHeader is returned when a connection doesn't start with a PROXY
+// header
+var ErrNoHeader = errors.New("missing PROXY protocol header")
+
+// ReadHeader consumes a PROXY header from r and returns the source address
+// it carries. The address is nil when the header says the connection was
+// not proxied (v1 UNKNOWN, v2 LOCAL or an unsupported address family), in
+// which case the peer address of the connection should be used.
+func ReadHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, v2Signature) {
+		return readV2(r)
+	}
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readV1(r)
+	}
+	return nil, ErrNoHeader
+}
+
+// readV1 parses "PROXY TCP4|TCP6|UNKNOWN <src> <dst> <sport> <dport>\r\n"
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("PROXY v1 header too long")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY v1 header: %q", strings.TrimSpace(string(line)))
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid PROXY v1 source address: %s", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY v1 source port: %s", fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 parses the binary header: signature, version/command, family,
+// length, then addresses and optional TLVs, which are skipped
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	version, command := hdr[12]>>4, hdr[12]&0x0f
+	if version != 2 {
+		return nil, fmt.Errorf("unsupported PROXY version %d", version)
+	}
+	family := hdr[13]
+	length := int(binary.BigEndian.Uint16(hdr[14:16]))
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case 0x0: // LOCAL: health checks from the proxy itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", command)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, fmt.Errorf("short PROXY v2 IPv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, fmt.Errorf("short PROXY v2 IPv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// Trusted is a set of networks allowed to send PROXY headers
+type Trusted []*net.IPNet
+
+// ParseTrusted parses CIDRs or bare IP addresses
+func ParseTrusted(entries []string) (Trusted, error) {
+	var nets Trusted
+	for _, entry := range entries {
+		if _, n, err := net.ParseCIDR(entry); err == nil {
+			nets = append(nets, n)
+			continue
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid proxy_trusted entry: %s", entry)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
+
+// Contains reports whether addr is in one of the networks. An empty set
+// contains no address.
+func (t Trusted) Contains(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range t {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// v2Header builds a version 2 header with the given command, family and
+// address block
+func v2Header(command, family byte, addrs []byte) string {
+	hdr := append([]byte{}, v2Signature...)
+	hdr = append(hdr, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(hdr[14:], uint16(len(addrs)))
+	return string(append(hdr, addrs...))
+}
+
+func TestReadHeader(t *testing.T) {
+	ipv4 := []byte{203, 0, 113, 7, 10, 0, 0, 1, 0x30, 0x39, 0x1b, 0x9e}
+	ipv6 := make([]byte, 36)
+	copy(ipv6, net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(ipv6[32:], 4242)
+
+	tests := []struct {
+		name   string
+		header string
+		addr   string // "" for no address
+	}{
+		{"v1 TCP4", "PROXY TCP4 203.0.113.7 10.0.0.1 12345 7070\r\n", "203.0.113.7:12345"},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::1 2001:db8::2 4242 7070\r\n", "[2001:db8::1]:4242"},
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\n", ""},
+		{"v2 IPv4", v2Header(0x1, 0x11, ipv4), "203.0.113.7:12345"},
+		{"v2 IPv6", v2Header(0x1, 0x21, ipv6), "[2001:db8::1]:4242"},
+		{"v2 IPv4 with TLVs", v2Header(0x1, 0x11, append(ipv4, 0x04, 0x00, 0x01, 0xff)), "203.0.113.7:12345"},
+		{"v2 LOCAL", v2Header(0x0, 0x00, nil), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.header + "PING\r\n"))
+			addr, err := ReadHeader(r)
+			require.NoError(t, err)
+			if tt.addr == "" {
+				assert.Nil(t, addr)
+			} else {
+				require.NotNil(t, addr)
+				assert.Equal(t, tt.addr, addr.String())
+			}
+
+			// The header is consumed and nothing more
+			rest, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, "PING\r\n", string(rest))
+		})
+	}
+}
+
+func TestReadHeader_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"v1 bad family", "PROXY UDP4 203.0.113.7 10.0.0.1 1 2\r\n"},
+		{"v1 family mismatch", "PROXY TCP4 2001:db8::1 10.0.0.1 1 2\r\n"},
+		{"v1 bad port", "PROXY TCP4 203.0.113.7 10.0.0.1 99999 2\r\n"},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n"},
+		{"v2 short addresses", v2Header(0x1, 0x11, []byte{1, 2, 3})},
+		{"v2 bad command", v2Header(0x5, 0x11, make([]byte, 12))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadHeader(bufio.NewReader(strings.NewReader(tt.header)))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := ReadHeader(bufio.NewReader(strings.NewReader("PING\r\nPING\r\nPING\r\n")))
+	assert.Equal(t, ErrNoHeader, err)
+}
+
+func TestTrusted(t *testing.T) {
+	trusted, err := ParseTrusted([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"})
+	require.NoError(t, err)
+
+	assert.True(t, trusted.Contains(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1}))
+	assert.True(t, trusted.Contains(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}))
+	assert.False(t, trusted.Contains(&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1}))
+	assert.True(t, trusted.Contains(&net.TCPAddr{IP: net.ParseIP("2001:db8::5"), Port: 1}))
+
+	// No list trusts no one
+	assert.False(t, Trusted(nil).Contains(&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1}))
+
+	_, err = ParseTrusted([]string{"not-an-ip"})
+	assert.Error(t, err)
+}
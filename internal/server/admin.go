@@ -0,0 +1,370 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+	"github.com/bharatmehan/osprey/pkg/command"
+	"github.com/bharatmehan/osprey/pkg/extension"
+)
+
+// isAdminCommand reports whether a command requires admin rights
+func isAdminCommand(cmd *protocol.Command) bool {
+	switch cmd.Name {
+	case "STATS":
+		return len(cmd.Args) > 0 && strings.ToUpper(cmd.Args[0]) == "RESET"
+	case "CLIENT":
+		return len(cmd.Args) > 0 && strings.ToUpper(cmd.Args[0]) == "KILL"
+	}
+	if spec, ok := command.Lookup(cmd.Name); ok {
+		return spec.Has(command.Admin)
+	}
+	ext, ok := extension.Lookup(cmd.Name)
+	return ok && ext.Admin
+}
+
+// isAdmin reports whether a connection may run admin commands. Without an
+// admin_password only loopback clients are admins; with one, the
+// connection must have sent a matching AUTH. Connections authenticated as
+// a user never are. The loopback check uses the socket's peer, not an
+// address from a PROXY header, which the sender chooses.
+func (s *Server) isAdmin(cc *clientConn) bool {
+	if cc.admin {
+		return true
+	}
+	if s.config.AdminPassword != "" || cc.user != "" {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(cc.conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleAuth handles the AUTH command: AUTH <password> for admin_password,
+// or AUTH <user> <password> for a configured user
+func (s *Server) handleAuth(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 1 && len(cmd.Args) != 2 {
+		protocol.WriteError(w, "BADREQ", "usage: AUTH [user] <password>")
+		return
+	}
+	if s.config.AuthMode == "hmac" {
+		protocol.WriteError(w, "NOAUTH", "passwords are only accepted with AUTHCHALLENGE and AUTHHMAC")
+		return
+	}
+
+	user, password := "", cmd.Args[0]
+	if len(cmd.Args) == 2 {
+		user, password = cmd.Args[0], cmd.Args[1]
+	}
+	key, ok := s.authKey(user, w)
+	if !ok {
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(key)) != 1 {
+		writeAuthFailed(user, w)
+		return
+	}
+	s.grant(cc, user)
+	protocol.WriteOK(w)
+}
+
+// handleAuthChallenge handles the AUTHCHALLENGE command, which replies
+// CHALLENGE <nonce> with a random hex nonce for the next AUTHHMAC
+func (s *Server) handleAuthChallenge(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 0 {
+		protocol.WriteError(w, "BADREQ", "AUTHCHALLENGE takes no arguments")
+		return
+	}
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		protocol.WriteError(w, "INTERNAL", err.Error())
+		return
+	}
+	cc.challenge = hex.EncodeToString(nonce)
+	fmt.Fprintf(w, "CHALLENGE %s\r\n", cc.challenge)
+}
+
+// handleAuthHMAC handles the AUTHHMAC command: AUTHHMAC [user] <mac>,
+// where mac is the hex HMAC-SHA256 of the AUTHCHALLENGE nonce keyed with
+// admin_password or the user's password. Each nonce can be used once.
+func (s *Server) handleAuthHMAC(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 1 && len(cmd.Args) != 2 {
+		protocol.WriteError(w, "BADREQ", "usage: AUTHHMAC [user] <mac>")
+		return
+	}
+	challenge := cc.challenge
+	cc.challenge = ""
+	if challenge == "" {
+		protocol.WriteError(w, "NOAUTH", "send AUTHCHALLENGE first")
+		return
+	}
+
+	user, mac := "", cmd.Args[0]
+	if len(cmd.Args) == 2 {
+		user, mac = cmd.Args[0], cmd.Args[1]
+	}
+	key, ok := s.authKey(user, w)
+	if !ok {
+		return
+	}
+	got, err := hex.DecodeString(mac)
+	if err != nil || !hmac.Equal(got, authMAC(key, challenge)) {
+		writeAuthFailed(user, w)
+		return
+	}
+	s.grant(cc, user)
+	protocol.WriteOK(w)
+}
+
+// authMAC returns the HMAC-SHA256 of challenge keyed with password, as
+// AUTHHMAC expects it
+func authMAC(password, challenge string) []byte {
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write([]byte(challenge))
+	return mac.Sum(nil)
+}
+
+// authKey returns the password of user, or of admin_password if user is
+// empty. If there is none it writes the error and returns false.
+func (s *Server) authKey(user string, w io.Writer) (string, bool) {
+	if user == "" {
+		if s.config.AdminPassword == "" {
+			protocol.WriteError(w, "BADREQ", "no admin_password is configured")
+			return "", false
+		}
+		return s.config.AdminPassword, true
+	}
+	t, ok := s.tenants[user]
+	if !ok {
+		writeAuthFailed(user, w)
+		return "", false
+	}
+	return t.password, true
+}
+
+func writeAuthFailed(user string, w io.Writer) {
+	if user == "" {
+		protocol.WriteError(w, "NOAUTH", "invalid password")
+	} else {
+		protocol.WriteError(w, "NOAUTH", "invalid user or password")
+	}
+}
+
+// grant authenticates a connection as an admin, or as user, scoping its
+// keys to the user's namespace
+func (s *Server) grant(cc *clientConn, user string) {
+	cc.info.Lock()
+	defer cc.info.Unlock()
+	if user == "" {
+		cc.admin = true
+		cc.user, cc.namespace = "", ""
+		return
+	}
+	cc.admin = false
+	cc.user, cc.namespace = user, s.tenants[user].namespace
+}
+
+// handleShutdown handles the SHUTDOWN command: SHUTDOWN [NOSAVE|SAVE].
+// SAVE writes a final snapshot first and aborts the shutdown if that
+// fails. The reply is flushed before the shutdown is requested so the
+// client sees it before its connection is closed.
+func (s *Server) handleShutdown(cmd *protocol.Command, w io.Writer) {
+	save := false
+	switch {
+	case len(cmd.Args) == 0:
+	case len(cmd.Args) == 1 && strings.ToUpper(cmd.Args[0]) == "SAVE":
+		save = true
+	case len(cmd.Args) == 1 && strings.ToUpper(cmd.Args[0]) == "NOSAVE":
+	default:
+		protocol.WriteError(w, "BADREQ", "usage: SHUTDOWN [NOSAVE|SAVE]")
+		return
+	}
+
+	if save {
+		if err := s.store.Snapshot(); err != nil {
+			protocol.WriteError(w, "INTERNAL", "snapshot failed: "+err.Error())
+			return
+		}
+	}
+
+	log.Printf("SHUTDOWN requested by client (save=%v)", save)
+	protocol.WriteOK(w)
+	if f, ok := w.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+
+	s.shutdownOnce.Do(func() { close(s.shutdownRequested) })
+}
+
+// resetStats zeroes every STATS counter and histogram and replies with
+// the new stats_epoch
+func (s *Server) resetStats(w io.Writer) {
+	epoch := s.store.ResetStats()
+
+	log.Printf("STATS RESET, new stats_epoch %d", epoch)
+	protocol.WriteInteger(w, epoch)
+}
+
+// handleSync handles the SYNC command, which streams a fresh snapshot to
+// seed another node:
+//
+//	SYNC <count>
+//	FILE <name> <size>\r\n<data>\r\n   (count times, MANIFEST.json last)
+//	END
+//
+// Errors after the header can't be reported in-band, so the connection is
+// closed instead and the receiver sees a short read.
+func (s *Server) handleSync(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 0 {
+		protocol.WriteError(w, "BADREQ", "SYNC takes no arguments")
+		return
+	}
+
+	started := false
+	err := s.store.WithSnapshot(func(manifestPath, snapPath string, walPaths []string) error {
+		files := append(append([]string{snapPath}, walPaths...), manifestPath)
+		if _, err := fmt.Fprintf(w, "SYNC %d\r\n", len(files)); err != nil {
+			return err
+		}
+		started = true
+
+		for _, path := range files {
+			if err := writeSyncFile(w, path); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, "END\r\n")
+		return err
+	})
+
+	switch {
+	case err == nil:
+		log.Printf("SYNC sent snapshot to %s", cc.remoteAddr())
+	case started:
+		log.Printf("SYNC to %s failed: %v", cc.remoteAddr(), err)
+		cc.conn.Close()
+	default:
+		protocol.WriteError(w, "INTERNAL", "snapshot failed: "+err.Error())
+	}
+}
+
+// writeSyncFile sends one file as a FILE frame
+func writeSyncFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return protocol.WriteFile(w, filepath.Base(path), info.Size(), f)
+}
+
+// handleWALRotate handles the WALROTATE command, which fsyncs the current
+// WAL file and starts a new one. It replies with the old file's name, so
+// backup tools know which WAL files are complete and can be copied.
+func (s *Server) handleWALRotate(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 0 {
+		protocol.WriteError(w, "BADREQ", "WALROTATE takes no arguments")
+		return
+	}
+
+	name, err := s.store.RotateWAL()
+	if err != nil {
+		protocol.WriteError(w, "INTERNAL", "WAL rotation failed: "+err.Error())
+		return
+	}
+	log.Printf("WALROTATE closed %s", name)
+	protocol.WriteValue(w, len(name), 0, 0, []byte(name))
+}
+
+// handleWALSync handles the WALSYNC command, which fsyncs the current WAL
+// file whatever the sync policy and replies with its name
+func (s *Server) handleWALSync(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 0 {
+		protocol.WriteError(w, "BADREQ", "WALSYNC takes no arguments")
+		return
+	}
+
+	name, err := s.store.SyncWAL()
+	if err != nil {
+		protocol.WriteError(w, "INTERNAL", "WAL sync failed: "+err.Error())
+		return
+	}
+	protocol.WriteValue(w, len(name), 0, 0, []byte(name))
+}
+
+// handleBgSave handles the BGSAVE command, which starts writing a
+// snapshot and replies OK without waiting for it. Only one runs at a time.
+func (s *Server) handleBgSave(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 0 {
+		protocol.WriteError(w, "BADREQ", "BGSAVE takes no arguments")
+		return
+	}
+	if !s.bgsaving.CompareAndSwap(false, true) {
+		protocol.WriteError(w, "BUSY", "snapshot already in progress")
+		return
+	}
+
+	s.shutdownWg.Add(1)
+	go func() {
+		defer s.shutdownWg.Done()
+		defer s.bgsaving.Store(false)
+
+		start := time.Now()
+		if err := s.store.Snapshot(); err != nil {
+			log.Printf("BGSAVE failed: %v", err)
+			return
+		}
+		log.Printf("BGSAVE completed in %v", time.Since(start))
+	}()
+	protocol.WriteOK(w)
+}
+
+// handleReadOnly handles READONLY ON|OFF, which makes the server refuse
+// or accept writes again. Writes already running are not affected.
+func (s *Server) handleReadOnly(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 1 {
+		protocol.WriteError(w, "BADREQ", "usage: READONLY ON|OFF")
+		return
+	}
+
+	switch strings.ToUpper(cmd.Args[0]) {
+	case "ON":
+		s.readOnly.Store(true)
+	case "OFF":
+		s.readOnly.Store(false)
+	default:
+		protocol.WriteError(w, "BADREQ", "usage: READONLY ON|OFF")
+		return
+	}
+	log.Printf("READONLY %s", strings.ToUpper(cmd.Args[0]))
+	protocol.WriteOK(w)
+}
+
+// ShutdownRequested returns a channel that is closed when a client issues
+// SHUTDOWN. The caller is expected to run the same graceful Shutdown used
+// for SIGTERM.
+func (s *Server) ShutdownRequested() <-chan struct{} {
+	return s.shutdownRequested
+}
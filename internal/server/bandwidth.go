@@ -0,0 +1,70 @@
+package server
+
+import "time"
+
+// meteredConn reads and writes a client connection, counting the bytes
+// for CLIENT LIST and STATS and holding the connection to the bandwidth
+// cap of its priority class
+type meteredConn struct {
+	s  *Server
+	cc *clientConn
+}
+
+func (m *meteredConn) Read(p []byte) (int, error) {
+	n, err := m.cc.conn.Read(p)
+	if n > 0 {
+		m.cc.bytesIn.Add(uint64(n))
+		m.s.netInputBytes.Add(uint64(n))
+		m.s.throttleBandwidth(m.cc, n)
+	}
+	return n, err
+}
+
+func (m *meteredConn) Write(p []byte) (int, error) {
+	m.s.throttleBandwidth(m.cc, len(p))
+	n, err := m.cc.conn.Write(p)
+	m.cc.bytesOut.Add(uint64(n))
+	m.s.netOutputBytes.Add(uint64(n))
+	return n, err
+}
+
+// bandwidthCap returns the bytes per second a connection of the given
+// priority may read and write, or 0 if it is unlimited
+func (s *Server) bandwidthCap(priority priorityClass) int {
+	switch priority {
+	case priorityLow:
+		return s.config.BandwidthLowBytesPerSec
+	case priorityHigh:
+		return s.config.BandwidthHighBytesPerSec
+	default:
+		return s.config.BandwidthNormalBytesPerSec
+	}
+}
+
+// throttleBandwidth charges n bytes just read, or about to be written,
+// against the connection's cap, sleeping while the connection is over it.
+// The cap follows the connection's class as HELLO PRIORITY changes it.
+func (s *Server) throttleBandwidth(cc *clientConn, n int) {
+	if limit := s.bandwidthCap(cc.priority); limit != cc.bandwidthCap {
+		cc.bandwidthCap = limit
+		cc.bandwidth = nil
+		if limit > 0 {
+			cc.bandwidth = newTokenBucket(limit, 0)
+		}
+	}
+	if cc.bandwidth == nil {
+		return
+	}
+
+	wait := cc.bandwidth.ReserveN(n)
+	if wait <= 0 {
+		return
+	}
+	s.bandwidthThrottled.Inc()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-s.shutdown:
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+)
+
+// maxClientNameLen bounds names set with HELLO NAME/LIB and CLIENT SETNAME
+const maxClientNameLen = 128
+
+// validClientName reports whether s can be used as a client or library
+// name: printable ASCII without spaces, so CLIENT LIST stays parseable
+func validClientName(s string) bool {
+	if s == "" || len(s) > maxClientNameLen {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// touch records that the connection just sent a command
+func (cc *clientConn) touch(cmd *protocol.Command, now time.Time) {
+	cc.info.Lock()
+	cc.lastCmd = cmd.Name
+	cc.lastTrace = cmd.TraceID
+	cc.lastUsed = now
+	cc.info.Unlock()
+}
+
+// remoteAddr returns the client's address, as reported by a proxy if the
+// connection came through one
+func (cc *clientConn) remoteAddr() net.Addr {
+	cc.info.Lock()
+	defer cc.info.Unlock()
+	return cc.remote
+}
+
+func (cc *clientConn) setRemoteAddr(addr net.Addr) {
+	cc.info.Lock()
+	cc.remote = addr
+	cc.info.Unlock()
+}
+
+// authUser returns the configured user the connection authenticated as,
+// for other goroutines
+func (cc *clientConn) authUser() string {
+	cc.info.Lock()
+	defer cc.info.Unlock()
+	return cc.user
+}
+
+// identity returns the client and library names the connection reported
+func (cc *clientConn) identity() (name, lib string) {
+	cc.info.Lock()
+	defer cc.info.Unlock()
+	return cc.name, cc.lib
+}
+
+func (cc *clientConn) setIdentity(name, lib string) {
+	cc.info.Lock()
+	cc.name = name
+	cc.lib = lib
+	cc.info.Unlock()
+}
+
+// describe formats the connection as one CLIENT LIST line
+func (cc *clientConn) describe(now time.Time) string {
+	cc.info.Lock()
+	defer cc.info.Unlock()
+
+	name, lib, trace := cc.name, cc.lib, cc.lastTrace
+	if name == "" {
+		name = "-"
+	}
+	if lib == "" {
+		lib = "-"
+	}
+	if trace == "" {
+		trace = "-"
+	}
+	return fmt.Sprintf("id=%d addr=%s name=%s lib=%s age=%d idle=%d in=%d out=%d cmd=%s trace=%s",
+		cc.id, cc.remote, name, lib,
+		int64(now.Sub(cc.createdAt).Seconds()), int64(now.Sub(cc.lastUsed).Seconds()),
+		cc.bytesIn.Load(), cc.bytesOut.Load(), cc.lastCmd, trace)
+}
+
+// handleClient handles the CLIENT command:
+// CLIENT SETNAME <name> | CLIENT GETNAME | CLIENT LIST | CLIENT KILL <id>
+// KILL is an admin command; it closes the connection with that id.
+func (s *Server) handleClient(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) == 0 {
+		protocol.WriteError(w, "BADREQ", "CLIENT requires a subcommand")
+		return
+	}
+
+	switch sub := strings.ToUpper(cmd.Args[0]); sub {
+	case "SETNAME":
+		if len(cmd.Args) != 2 {
+			protocol.WriteError(w, "BADREQ", "CLIENT SETNAME requires 1 argument")
+			return
+		}
+		if !validClientName(cmd.Args[1]) {
+			protocol.WriteError(w, "BADREQ", "invalid client name")
+			return
+		}
+		_, lib := cc.identity()
+		cc.setIdentity(cmd.Args[1], lib)
+		protocol.WriteOK(w)
+
+	case "GETNAME":
+		if len(cmd.Args) != 1 {
+			protocol.WriteError(w, "BADREQ", "CLIENT GETNAME takes no arguments")
+			return
+		}
+		name, _ := cc.identity()
+		if name == "" {
+			protocol.WriteNotFound(w)
+			return
+		}
+		protocol.WriteValue(w, len(name), 0, 0, []byte(name))
+
+	case "LIST":
+		if len(cmd.Args) != 1 {
+			protocol.WriteError(w, "BADREQ", "CLIENT LIST takes no arguments")
+			return
+		}
+		s.writeClientList(w, cc.user)
+
+	case "KILL":
+		if len(cmd.Args) != 2 {
+			protocol.WriteError(w, "BADREQ", "CLIENT KILL requires 1 argument")
+			return
+		}
+		id, err := strconv.ParseUint(cmd.Args[1], 10, 64)
+		if err != nil {
+			protocol.WriteError(w, "BADREQ", "invalid client id")
+			return
+		}
+		if !s.killClient(id) {
+			protocol.WriteNotFound(w)
+			return
+		}
+		protocol.WriteOK(w)
+
+	default:
+		protocol.WriteError(w, "BADREQ", fmt.Sprintf("unknown CLIENT subcommand: %s", sub))
+	}
+}
+
+// killClient closes the connection with id and reports whether there was
+// one. Its goroutine sees the closed connection and cleans up as if the
+// client had disconnected.
+func (s *Server) killClient(id uint64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for conn, cc := range s.connections {
+		if cc.id == id {
+			log.Printf("CLIENT KILL %d (%s)", id, cc.remoteAddr())
+			conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// writeClientList writes one line per connection, oldest first, then END.
+// A user only sees its own connections.
+func (s *Server) writeClientList(w io.Writer, user string) {
+	s.mu.RLock()
+	clients := make([]*clientConn, 0, len(s.connections))
+	for _, cc := range s.connections {
+		if user == "" || cc.authUser() == user {
+			clients = append(clients, cc)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+
+	now := time.Now()
+	for _, cc := range clients {
+		fmt.Fprintf(w, "%s\r\n", cc.describe(now))
+	}
+	fmt.Fprintf(w, "END\r\n")
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+)
+
+// handleDigest handles DIGEST [prefix], replying with the digest of the
+// keys under prefix, or of every key, and how many there are:
+//
+//	DIGEST <sum as 16 hex digits> <keys>
+//
+// Two nodes holding the same keys and values reply the same, so copies can
+// be compared without reading every key over the network.
+func (s *Server) handleDigest(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) > 1 {
+		protocol.WriteError(w, "BADREQ", "usage: DIGEST [prefix]")
+		return
+	}
+
+	var prefix string
+	if len(cmd.Args) == 1 {
+		prefix = cmd.Args[0]
+	}
+	d := s.store.Digest(prefix)
+	fmt.Fprintf(w, "DIGEST %016x %d\r\n", d.Sum, d.Keys)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+)
+
+// Events returned by one EVENTS READ, by default and at most
+const (
+	defaultEventsCount = 100
+	maxEventsCount     = 1000
+)
+
+// handleEvents handles the EVENTS command, which drains the queue of
+// expiry and delete events for prefix rules with notify set:
+//
+//	EVENTS READ <after_seq> [count] → EVENT <seq> <type> <time_ms> <key> ... END
+//	EVENTS ACK <seq>                → OK, dropping the events up to seq
+func (s *Server) handleEvents(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) == 0 {
+		protocol.WriteError(w, "BADREQ", "EVENTS requires a subcommand")
+		return
+	}
+
+	switch sub := strings.ToUpper(cmd.Args[0]); sub {
+	case "READ":
+		if len(cmd.Args) < 2 || len(cmd.Args) > 3 {
+			protocol.WriteError(w, "BADREQ", "usage: EVENTS READ <after_seq> [count]")
+			return
+		}
+		after, err := strconv.ParseUint(cmd.Args[1], 10, 64)
+		if err != nil {
+			protocol.WriteError(w, "BADREQ", "invalid sequence number")
+			return
+		}
+		count := defaultEventsCount
+		if len(cmd.Args) == 3 {
+			count, err = strconv.Atoi(cmd.Args[2])
+			if err != nil || count <= 0 || count > maxEventsCount {
+				protocol.WriteError(w, "BADREQ", "invalid count")
+				return
+			}
+		}
+		for _, event := range s.store.Events(after, count) {
+			fmt.Fprintf(w, "EVENT %d %s %d %s\r\n", event.Seq, event.Type, event.TimeMs, event.Key)
+		}
+		fmt.Fprintf(w, "END\r\n")
+
+	case "ACK":
+		if len(cmd.Args) != 2 {
+			protocol.WriteError(w, "BADREQ", "usage: EVENTS ACK <seq>")
+			return
+		}
+		seq, err := strconv.ParseUint(cmd.Args[1], 10, 64)
+		if err != nil {
+			protocol.WriteError(w, "BADREQ", "invalid sequence number")
+			return
+		}
+		if err := s.store.AckEvents(seq); err != nil {
+			protocol.WriteError(w, "INTERNAL", err.Error())
+			return
+		}
+		protocol.WriteOK(w)
+
+	default:
+		protocol.WriteError(w, "BADREQ", fmt.Sprintf("unknown EVENTS subcommand: %s", sub))
+	}
+}
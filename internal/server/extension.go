@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+	"github.com/bharatmehan/osprey/internal/storage"
+	"github.com/bharatmehan/osprey/pkg/command"
+	"github.com/bharatmehan/osprey/pkg/extension"
+)
+
+// checkExtensions refuses registered commands that share a name with a
+// built-in one
+func checkExtensions() error {
+	for _, spec := range command.Specs() {
+		if _, ok := extension.Lookup(spec.Name); ok {
+			return fmt.Errorf("extension command %s conflicts with a built-in command", spec.Name)
+		}
+	}
+	return nil
+}
+
+// extensionStore gives extension commands access to the store
+type extensionStore struct {
+	store *storage.PersistentStore
+}
+
+func (es extensionStore) Get(key string) ([]byte, uint64, error) {
+	entry, err := es.store.Get(es.store.NormalizeKey(key))
+	if err == storage.ErrKeyNotFound {
+		return nil, 0, extension.ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return entry.Value, entry.Version, nil
+}
+
+func (es extensionStore) Set(key string, value []byte, ttl time.Duration) (uint64, error) {
+	key = es.store.NormalizeKey(key)
+	if storage.IsReserved(key) {
+		return 0, storage.ErrReservedKey
+	}
+	return es.store.Set(key, value, storage.SetOptions{ExpiryMs: ttl.Milliseconds()})
+}
+
+func (es extensionStore) Delete(key string) bool {
+	key = es.store.NormalizeKey(key)
+	if storage.IsReserved(key) {
+		return false
+	}
+	return es.store.Delete(key)
+}
+
+// extensionReply writes an extension command's response
+type extensionReply struct {
+	w io.Writer
+}
+
+func (r extensionReply) OK()                        { protocol.WriteOK(r.w) }
+func (r extensionReply) Error(code, message string) { protocol.WriteError(r.w, code, message) }
+func (r extensionReply) Integer(n int64)            { protocol.WriteInteger(r.w, n) }
+func (r extensionReply) Value(v []byte)             { protocol.WriteValue(r.w, len(v), 0, 0, v) }
+func (r extensionReply) NotFound()                  { protocol.WriteNotFound(r.w) }
+
+// extensionRequest describes cmd to extensions
+func extensionRequest(cc *clientConn, cmd *protocol.Command) *extension.Request {
+	name, _ := cc.identity()
+	return &extension.Request{
+		Name:    cmd.Name,
+		Args:    cmd.Args,
+		Payload: cmd.Payload,
+		TraceID: cmd.TraceID,
+		Client:  name,
+	}
+}
+
+// intercept runs the registered interceptors over cmd, applying any
+// changes they make. It replies and returns false if one rejects it.
+func (s *Server) intercept(cc *clientConn, cmd *protocol.Command, w io.Writer) bool {
+	if !extension.Intercepting() {
+		return true
+	}
+
+	req := extensionRequest(cc, cmd)
+	if err := extension.Run(req); err != nil {
+		var rejected *extension.Error
+		if errors.As(err, &rejected) {
+			protocol.WriteError(w, rejected.Code, rejected.Message)
+		} else {
+			protocol.WriteError(w, "BADREQ", err.Error())
+		}
+		return false
+	}
+	cmd.Args, cmd.Payload = req.Args, req.Payload
+	return true
+}
+
+// handleExtension runs a registered command, or replies that the command
+// is unknown
+func (s *Server) handleExtension(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	ext, ok := extension.Lookup(cmd.Name)
+	if !ok {
+		protocol.WriteError(w, "BADREQ", "unknown command")
+		return
+	}
+	ext.Handler(extensionStore{s.store}, extensionRequest(cc, cmd), extensionReply{w})
+}
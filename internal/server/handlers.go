@@ -1,10 +1,16 @@
 package server
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bharatmehan/osprey/internal/protocol"
 	"github.com/bharatmehan/osprey/internal/storage"
@@ -15,9 +21,117 @@ func (s *Server) handlePing(w io.Writer) {
 	protocol.WritePong(w)
 }
 
-// handleGet handles the GET command
-func (s *Server) handleGet(cmd *protocol.Command, w io.Writer) {
-	if len(cmd.Args) != 1 {
+// defaultCompressMin is the smallest value compressed for a connection
+// that negotiated compression without COMPRESSMIN
+const defaultCompressMin = 1024
+
+// handleHello handles the HELLO command:
+// HELLO [PRIORITY <class>] [CHUNKSIZE <bytes>] [NAME <name>] [LIB <lib>]
+// [COMPRESS <algo>[,<algo>...]] [COMPRESSMIN <bytes>] [TRACE ON|OFF]
+// [WARNINGS ON|OFF]
+// With COMPRESS the reply names the algorithm chosen: OK COMPRESS <algo|none>
+func (s *Server) handleHello(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	priority := cc.priority
+	chunkSize := cc.chunkSize
+	name, lib := cc.identity()
+	compression, compressMin := cc.compression, cc.compressMin
+	tracing := cc.tracing
+	warnings := cc.warnings
+	negotiated := false
+
+	for i := 0; i < len(cmd.Args); i += 2 {
+		if i+1 >= len(cmd.Args) {
+			protocol.WriteError(w, "BADREQ", fmt.Sprintf("%s requires value", strings.ToUpper(cmd.Args[i])))
+			return
+		}
+
+		opt, value := strings.ToUpper(cmd.Args[i]), cmd.Args[i+1]
+		switch opt {
+		case "PRIORITY":
+			p, ok := parsePriority(value)
+			if !ok {
+				protocol.WriteError(w, "BADREQ", "priority must be low, normal or high")
+				return
+			}
+			priority = p
+		case "CHUNKSIZE":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				protocol.WriteError(w, "BADREQ", "invalid chunk size")
+				return
+			}
+			chunkSize = n
+		case "NAME":
+			if !validClientName(value) {
+				protocol.WriteError(w, "BADREQ", "invalid client name")
+				return
+			}
+			name = value
+		case "LIB":
+			if !validClientName(value) {
+				protocol.WriteError(w, "BADREQ", "invalid library name")
+				return
+			}
+			lib = value
+		case "COMPRESS":
+			compression = protocol.NegotiateCompression(value)
+			negotiated = true
+		case "COMPRESSMIN":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				protocol.WriteError(w, "BADREQ", "invalid compression threshold")
+				return
+			}
+			compressMin = n
+		case "TRACE":
+			switch strings.ToUpper(value) {
+			case "ON":
+				tracing = true
+			case "OFF":
+				tracing = false
+			default:
+				protocol.WriteError(w, "BADREQ", "trace must be on or off")
+				return
+			}
+		case "WARNINGS":
+			switch strings.ToUpper(value) {
+			case "ON":
+				warnings = true
+			case "OFF":
+				warnings = false
+			default:
+				protocol.WriteError(w, "BADREQ", "warnings must be on or off")
+				return
+			}
+		default:
+			protocol.WriteError(w, "BADREQ", fmt.Sprintf("unknown option: %s", opt))
+			return
+		}
+	}
+
+	cc.priority = priority
+	cc.chunkSize = chunkSize
+	cc.setIdentity(name, lib)
+	cc.compression = compression
+	cc.compressMin = compressMin
+	cc.tracing = tracing
+	cc.warnings = warnings
+
+	if !negotiated {
+		protocol.WriteOK(w)
+		return
+	}
+	if compression == "" {
+		compression = "none"
+	}
+	fmt.Fprintf(w, "OK COMPRESS %s\r\n", compression)
+}
+
+// handleGet handles the GET command: GET <key> [EARLY]. With EARLY, a key
+// close to expiring may be flagged as soft-expired, see softExpired.
+func (s *Server) handleGet(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	early := len(cmd.Args) == 2 && strings.ToUpper(cmd.Args[1]) == "EARLY"
+	if len(cmd.Args) != 1 && !early {
 		protocol.WriteError(w, "BADREQ", "GET requires 1 argument")
 		return
 	}
@@ -27,19 +141,161 @@ func (s *Server) handleGet(cmd *protocol.Command, w io.Writer) {
 	if err != nil {
 		if err == storage.ErrKeyNotFound {
 			protocol.WriteNotFound(w)
+		} else if err == storage.ErrWrongType {
+			protocol.WriteError(w, "TYPE", err.Error())
+		} else if err == storage.ErrKeyInvalid {
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		} else {
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+
+	var flags []string
+	if early && s.softExpired(entry, time.Now().UnixMilli()) {
+		flags = append(flags, protocol.ValueSoftExpired)
+	}
+
+	writeEntry(cc, w, entry, flags...)
+}
+
+// handleGetDel handles the GETDEL command, replying like GET with the
+// value of a key it deletes
+func (s *Server) handleGetDel(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 1 {
+		protocol.WriteError(w, "BADREQ", "GETDEL requires 1 argument")
+		return
+	}
+
+	entry, err := s.store.GetDel(cmd.Args[0])
+	if err != nil {
+		if err == storage.ErrKeyNotFound {
+			protocol.WriteNotFound(w)
+		} else if err == storage.ErrWrongType {
+			protocol.WriteError(w, "TYPE", err.Error())
+		} else if err == storage.ErrKeyInvalid {
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		} else {
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+
+	writeEntry(cc, w, entry)
+}
+
+// handleGetEx handles GETEX <key> [EX <ms>|PERSIST], replying like GET
+// with the value of a key whose TTL it sets or removes in the same step
+func (s *Server) handleGetEx(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) < 1 || len(cmd.Args) > 3 {
+		protocol.WriteError(w, "BADREQ", "usage: GETEX <key> [EX <ms>|PERSIST]")
+		return
+	}
+
+	key := cmd.Args[0]
+	var entry *storage.Entry
+	var err error
+	switch {
+	case len(cmd.Args) == 1:
+		entry, err = s.store.Get(key)
+	case len(cmd.Args) == 2 && strings.ToUpper(cmd.Args[1]) == "PERSIST":
+		entry, err = s.store.GetEx(key, -1)
+	case len(cmd.Args) == 3 && strings.ToUpper(cmd.Args[1]) == "EX":
+		ttlMs, perr := strconv.ParseInt(cmd.Args[2], 10, 64)
+		if perr != nil || ttlMs < 0 {
+			protocol.WriteError(w, "BADREQ", "invalid TTL")
+			return
+		}
+		entry, err = s.store.GetEx(key, ttlMs)
+	default:
+		protocol.WriteError(w, "BADREQ", "usage: GETEX <key> [EX <ms>|PERSIST]")
+		return
+	}
+	if err != nil {
+		if err == storage.ErrKeyNotFound {
+			protocol.WriteNotFound(w)
+		} else if err == storage.ErrWrongType {
+			protocol.WriteError(w, "TYPE", err.Error())
 		} else if err == storage.ErrKeyInvalid {
 			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		} else if err == storage.ErrTTLTooLong {
+			protocol.WriteError(w, "BADREQ", "TTL exceeds max_ttl_ms")
 		} else {
 			protocol.WriteError(w, "INTERNAL", err.Error())
 		}
 		return
 	}
 
-	protocol.WriteValue(w, len(entry.Value), entry.Version, entry.ExpiryMs, entry.Value)
+	writeEntry(cc, w, entry)
+}
+
+// writeEntry writes entry as a VALUE reply, chunked or compressed as the
+// connection negotiated
+func writeEntry(cc *clientConn, w io.Writer, entry *storage.Entry, flags ...string) {
+	if cc.chunkSize > 0 && len(entry.Value) > cc.chunkSize {
+		protocol.WriteValueChunked(w, entry.Version, entry.ExpiryMs, entry.Value, cc.chunkSize, flags...)
+		return
+	}
+
+	if compressed, ok := cc.compress(entry.Value); ok {
+		protocol.WriteValueCompressed(w, cc.compression, len(entry.Value), entry.Version, entry.ExpiryMs, compressed, flags...)
+		return
+	}
+
+	protocol.WriteValue(w, len(entry.Value), entry.Version, entry.ExpiryMs, entry.Value, flags...)
 }
 
+// softExpired decides whether to tell a client to refresh an entry early:
+// entries within soft_expire_window_ms of expiring are soft-expired for
+// soft_expire_probability of reads, so under load one reader refreshes
+// the value while the rest keep getting it from the cache
+func (s *Server) softExpired(entry *storage.Entry, nowMs int64) bool {
+	if entry.ExpiryMs <= 0 || s.config.SoftExpireWindowMs <= 0 {
+		return false
+	}
+	if entry.ExpiryMs-nowMs > int64(s.config.SoftExpireWindowMs) {
+		return false
+	}
+	return rand.Float64() < s.config.SoftExpireProbability
+}
+
+// compress compresses a value for the connection if it negotiated
+// compression, the value is large enough, and compressing saves space
+func (cc *clientConn) compress(value []byte) ([]byte, bool) {
+	if cc.compression == "" || len(value) < cc.compressMin {
+		return nil, false
+	}
+	return protocol.Compress(cc.compression, value)
+}
+
+// handleGetMeta handles the GETMETA command
+func (s *Server) handleGetMeta(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 1 {
+		protocol.WriteError(w, "BADREQ", "GETMETA requires 1 argument")
+		return
+	}
+
+	entry, err := s.store.GetMeta(cmd.Args[0])
+	if err != nil {
+		if err == storage.ErrKeyNotFound {
+			protocol.WriteNotFound(w)
+		} else if err == storage.ErrKeyInvalid {
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		} else {
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+
+	protocol.WriteMeta(w, int(entry.SizeBytes), entry.Version, entry.ExpiryMs, entry.TTL(),
+		entry.CreatedMs, entry.UpdatedMs, entry.Type())
+}
+
+// maxIdempTokenBytes bounds SET ... IDEMP tokens
+const maxIdempTokenBytes = 128
+
 // handleSet handles the SET command
-func (s *Server) handleSet(cmd *protocol.Command, w io.Writer) {
+func (s *Server) handleSet(cc *clientConn, cmd *protocol.Command, w io.Writer) {
 	if len(cmd.Args) < 2 {
 		protocol.WriteError(w, "BADREQ", "SET requires at least 2 arguments")
 		return
@@ -49,6 +305,7 @@ func (s *Server) handleSet(cmd *protocol.Command, w io.Writer) {
 
 	// Parse options
 	opts := storage.SetOptions{}
+	var token string
 	i := 2 // Start after key and length
 
 	for i < len(cmd.Args) {
@@ -88,6 +345,10 @@ func (s *Server) handleSet(cmd *protocol.Command, w io.Writer) {
 			opts.XX = true
 			i++
 
+		case "NOSYNC":
+			opts.NoSync = true
+			i++
+
 		case "VER":
 			if i+1 >= len(cmd.Args) {
 				protocol.WriteError(w, "BADREQ", "VER requires value")
@@ -102,6 +363,18 @@ func (s *Server) handleSet(cmd *protocol.Command, w io.Writer) {
 			opts.Version = ver
 			i += 2
 
+		case "IDEMP":
+			if i+1 >= len(cmd.Args) {
+				protocol.WriteError(w, "BADREQ", "IDEMP requires token")
+				return
+			}
+			token = cmd.Args[i+1]
+			if len(token) > maxIdempTokenBytes {
+				protocol.WriteError(w, "BADREQ", "IDEMP token too long")
+				return
+			}
+			i += 2
+
 		default:
 			protocol.WriteError(w, "BADREQ", fmt.Sprintf("unknown option: %s", arg))
 			return
@@ -114,8 +387,19 @@ func (s *Server) handleSet(cmd *protocol.Command, w io.Writer) {
 		return
 	}
 
-	// Set the value
-	version, err := s.store.Set(key, cmd.Payload, opts)
+	// Set the value. A retry carrying a token that was already applied
+	// gets the same reply as the original.
+	var version uint64
+	var err error
+	if token != "" {
+		var replayed bool
+		version, replayed, err = s.store.SetIdempotent(key, cmd.Payload, opts, token)
+		if replayed {
+			s.idempReplayedTotal.Inc()
+		}
+	} else {
+		version, err = s.store.Set(key, cmd.Payload, opts)
+	}
 	if err != nil {
 		switch err {
 		case storage.ErrKeyExists:
@@ -130,13 +414,156 @@ func (s *Server) handleSet(cmd *protocol.Command, w io.Writer) {
 			protocol.WriteError(w, "TOOLARGE", "value too large")
 		case storage.ErrKeyInvalid:
 			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		case storage.ErrTokenReused:
+			protocol.WriteError(w, "BADREQ", "IDEMP token already used for another key")
+		case storage.ErrTTLTooLong:
+			protocol.WriteError(w, "BADREQ", "TTL exceeds max_ttl_ms")
 		default:
 			protocol.WriteError(w, "INTERNAL", err.Error())
 		}
 		return
 	}
 
-	protocol.WriteOKWithVersion(w, version)
+	protocol.WriteOKWithVersion(w, version, warnFlags(cc, s.checkSoftLimits(key, len(cmd.Payload)))...)
+}
+
+// handleCAS handles CAS <key> <expected_len> <new_len>, whose payload is
+// the expected value followed by the new one
+func (s *Server) handleCAS(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 3 {
+		protocol.WriteError(w, "BADREQ", "CAS requires 3 arguments")
+		return
+	}
+
+	key := cmd.Args[0]
+	expectedLen, _ := strconv.Atoi(cmd.Args[1])
+	expected, value := cmd.Payload[:expectedLen], cmd.Payload[expectedLen:]
+
+	version, err := s.store.CompareAndSwap(key, expected, value)
+	if err != nil {
+		switch err {
+		case storage.ErrKeyNotFound:
+			protocol.WriteError(w, "NEXISTS", "key does not exist")
+		case storage.ErrValueMismatch:
+			protocol.WriteError(w, "MISMATCH", "current value differs")
+		case storage.ErrKeyTooLarge:
+			protocol.WriteError(w, "TOOLARGE", "key too large")
+		case storage.ErrValueTooLarge:
+			protocol.WriteError(w, "TOOLARGE", "value too large")
+		case storage.ErrWrongType:
+			protocol.WriteError(w, "TYPE", err.Error())
+		case storage.ErrKeyInvalid:
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		case storage.ErrTTLTooLong:
+			protocol.WriteError(w, "BADREQ", "TTL exceeds max_ttl_ms")
+		default:
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+
+	protocol.WriteOKWithVersion(w, version, warnFlags(cc, s.checkSoftLimits(key, len(value)))...)
+}
+
+// handleAppend handles APPEND <key> <len>, replying with the new version
+// and length of the value
+func (s *Server) handleAppend(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 2 {
+		protocol.WriteError(w, "BADREQ", "usage: APPEND <key> <len>")
+		return
+	}
+
+	key := cmd.Args[0]
+	length, version, err := s.store.Append(key, cmd.Payload)
+	if err != nil {
+		switch err {
+		case storage.ErrKeyTooLarge:
+			protocol.WriteError(w, "TOOLARGE", "key too large")
+		case storage.ErrValueTooLarge:
+			protocol.WriteError(w, "TOOLARGE", "value too large")
+		case storage.ErrWrongType:
+			protocol.WriteError(w, "TYPE", err.Error())
+		case storage.ErrKeyInvalid:
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		case storage.ErrTTLTooLong:
+			protocol.WriteError(w, "BADREQ", "TTL exceeds max_ttl_ms")
+		default:
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+
+	protocol.WriteAppended(w, version, length, warnFlags(cc, s.checkSoftLimits(key, length))...)
+}
+
+// handleGetRange handles GETRANGE <key> <start> <end>, replying like GET
+// with the bytes from start to end inclusive. Negative offsets count back
+// from the end of the value.
+func (s *Server) handleGetRange(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 3 {
+		protocol.WriteError(w, "BADREQ", "usage: GETRANGE <key> <start> <end>")
+		return
+	}
+	start, err1 := strconv.ParseInt(cmd.Args[1], 10, 64)
+	end, err2 := strconv.ParseInt(cmd.Args[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		protocol.WriteError(w, "BADREQ", "invalid range")
+		return
+	}
+
+	entry, err := s.store.GetRange(cmd.Args[0], start, end)
+	if err != nil {
+		if err == storage.ErrKeyNotFound {
+			protocol.WriteNotFound(w)
+		} else if err == storage.ErrWrongType {
+			protocol.WriteError(w, "TYPE", err.Error())
+		} else if err == storage.ErrKeyInvalid {
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		} else {
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+
+	writeEntry(cc, w, entry)
+}
+
+// handleSetRange handles SETRANGE <key> <offset> <len>, overwriting the
+// value from offset with the payload and replying like APPEND
+func (s *Server) handleSetRange(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 3 {
+		protocol.WriteError(w, "BADREQ", "usage: SETRANGE <key> <offset> <len>")
+		return
+	}
+	offset, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+	if err != nil {
+		protocol.WriteError(w, "BADREQ", "invalid offset")
+		return
+	}
+
+	key := cmd.Args[0]
+	length, version, err := s.store.SetRange(key, offset, cmd.Payload)
+	if err != nil {
+		switch err {
+		case storage.ErrKeyTooLarge:
+			protocol.WriteError(w, "TOOLARGE", "key too large")
+		case storage.ErrValueTooLarge:
+			protocol.WriteError(w, "TOOLARGE", "value too large")
+		case storage.ErrOutOfRange:
+			protocol.WriteError(w, "BADREQ", "offset out of range")
+		case storage.ErrWrongType:
+			protocol.WriteError(w, "TYPE", err.Error())
+		case storage.ErrKeyInvalid:
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		case storage.ErrTTLTooLong:
+			protocol.WriteError(w, "BADREQ", "TTL exceeds max_ttl_ms")
+		default:
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+
+	protocol.WriteAppended(w, version, length, warnFlags(cc, s.checkSoftLimits(key, length))...)
 }
 
 // handleDel handles the DEL command
@@ -151,6 +578,26 @@ func (s *Server) handleDel(cmd *protocol.Command, w io.Writer) {
 	protocol.WriteDeleted(w, deleted)
 }
 
+// handleMDel handles the MDEL command, replying with how many of the keys
+// were deleted
+func (s *Server) handleMDel(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) == 0 {
+		protocol.WriteError(w, "BADREQ", "MDEL requires at least 1 argument")
+		return
+	}
+
+	n, err := s.store.DeleteMany(cmd.Args...)
+	if err != nil {
+		if err == storage.ErrKeyInvalid {
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		} else {
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+	protocol.WriteInteger(w, int64(n))
+}
+
 // handleExists handles the EXISTS command
 func (s *Server) handleExists(cmd *protocol.Command, w io.Writer) {
 	if len(cmd.Args) != 1 {
@@ -163,6 +610,17 @@ func (s *Server) handleExists(cmd *protocol.Command, w io.Writer) {
 	protocol.WriteExists(w, exists)
 }
 
+// handleMExists handles the MEXISTS command, replying with a flag for
+// each key
+func (s *Server) handleMExists(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) == 0 {
+		protocol.WriteError(w, "BADREQ", "MEXISTS requires at least 1 argument")
+		return
+	}
+
+	protocol.WriteExistsFlags(w, s.store.ExistsMany(cmd.Args...))
+}
+
 // handleExpire handles the EXPIRE command
 func (s *Server) handleExpire(cmd *protocol.Command, w io.Writer) {
 	if len(cmd.Args) != 2 {
@@ -183,6 +641,8 @@ func (s *Server) handleExpire(cmd *protocol.Command, w io.Writer) {
 			protocol.WriteNotFound(w)
 		} else if err == storage.ErrKeyInvalid {
 			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		} else if err == storage.ErrTTLTooLong {
+			protocol.WriteError(w, "BADREQ", "TTL exceeds max_ttl_ms")
 		} else {
 			protocol.WriteError(w, "INTERNAL", err.Error())
 		}
@@ -227,8 +687,12 @@ func (s *Server) handleIncr(cmd *protocol.Command, w io.Writer, sign int64) {
 	if err != nil {
 		if err == storage.ErrNotInteger {
 			protocol.WriteError(w, "TYPE", "value is not an integer")
+		} else if err == storage.ErrWrongType {
+			protocol.WriteError(w, "TYPE", err.Error())
 		} else if err == storage.ErrKeyInvalid {
 			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		} else if err == storage.ErrTTLTooLong {
+			protocol.WriteError(w, "BADREQ", "TTL exceeds max_ttl_ms")
 		} else {
 			protocol.WriteError(w, "INTERNAL", err.Error())
 		}
@@ -238,28 +702,56 @@ func (s *Server) handleIncr(cmd *protocol.Command, w io.Writer, sign int64) {
 	protocol.WriteInteger(w, newVal)
 }
 
-// handleStats handles the STATS command
-func (s *Server) handleStats(cmd *protocol.Command, w io.Writer) {
-	stats := s.store.GetStats()
+// handleIncrByFloat handles the INCRBYFLOAT command:
+// INCRBYFLOAT <key> <delta> → FLOAT <new value>
+func (s *Server) handleIncrByFloat(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 2 {
+		protocol.WriteError(w, "BADREQ", "INCRBYFLOAT requires 2 arguments")
+		return
+	}
 
-	// Add server-level stats
-	stats["clients"] = strconv.Itoa(int(s.clientCount))
+	delta, err := strconv.ParseFloat(cmd.Args[1], 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		protocol.WriteError(w, "BADREQ", "invalid delta")
+		return
+	}
 
-	// Add WAL stats
-	walStats := s.store.GetWALStats()
-	for k, v := range walStats {
-		stats[k] = v
+	newVal, err := s.store.IncrFloat(cmd.Args[0], delta)
+	if err != nil {
+		switch err {
+		case storage.ErrNotFloat, storage.ErrWrongType:
+			protocol.WriteError(w, "TYPE", err.Error())
+		case storage.ErrFloatOverflow, storage.ErrKeyInvalid, storage.ErrTTLTooLong:
+			protocol.WriteError(w, "BADREQ", err.Error())
+		default:
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
 	}
 
-	// Write stats
-	for k, v := range stats {
-		fmt.Fprintf(w, "%s=%s\r\n", k, v)
+	protocol.WriteFloat(w, storage.FormatFloat(newVal))
+}
+
+// handleStats handles the STATS command: STATS [RESET]
+func (s *Server) handleStats(cmd *protocol.Command, w io.Writer) {
+	verbose := false
+	switch {
+	case len(cmd.Args) == 0:
+	case len(cmd.Args) == 1 && strings.ToUpper(cmd.Args[0]) == "RESET":
+		s.resetStats(w)
+		return
+	case len(cmd.Args) == 1 && strings.ToUpper(cmd.Args[0]) == "VERBOSE":
+		verbose = true
+	default:
+		protocol.WriteError(w, "BADREQ", "usage: STATS [RESET|VERBOSE]")
+		return
 	}
-	fmt.Fprintf(w, "END\r\n")
+
+	writeSections(w, s.store.Metrics().Grouped(), verbose)
 }
 
 // handleMGet handles the MGET command
-func (s *Server) handleMGet(cmd *protocol.Command, w io.Writer) {
+func (s *Server) handleMGet(cc *clientConn, cmd *protocol.Command, w io.Writer) {
 	if len(cmd.Args) == 0 {
 		protocol.WriteError(w, "BADREQ", "MGET requires at least 1 argument")
 		return
@@ -268,8 +760,8 @@ func (s *Server) handleMGet(cmd *protocol.Command, w io.Writer) {
 	for _, key := range cmd.Args {
 		entry, err := s.store.Get(key)
 		if err != nil {
-			if err == storage.ErrKeyNotFound {
-				fmt.Fprintf(w, "NOT_FOUND %s\r\n", key)
+			if err == storage.ErrKeyNotFound || err == storage.ErrWrongType {
+				fmt.Fprintf(w, "NOT_FOUND %s\r\n", cc.clientKey(key))
 			} else if err == storage.ErrKeyInvalid {
 				protocol.WriteError(w, "BADREQ", "key contains invalid characters")
 				return
@@ -280,57 +772,308 @@ func (s *Server) handleMGet(cmd *protocol.Command, w io.Writer) {
 			continue
 		}
 
-		fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", key, len(entry.Value), entry.Version, entry.ExpiryMs)
+		if compressed, ok := cc.compress(entry.Value); ok {
+			fmt.Fprintf(w, "VALUE %s %d %d %d %s %d\r\n", cc.clientKey(key), len(compressed), entry.Version, entry.ExpiryMs,
+				strings.ToUpper(cc.compression), len(entry.Value))
+			w.Write(compressed)
+			w.Write([]byte("\r\n"))
+			continue
+		}
+
+		fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", cc.clientKey(key), len(entry.Value), entry.Version, entry.ExpiryMs)
 		w.Write(entry.Value)
 		w.Write([]byte("\r\n"))
 	}
 }
 
-// handleMSet handles the MSET command
-func (s *Server) handleMSet(cmd *protocol.Command, w io.Writer) {
-	// MSET k1 len1 k2 len2 ...
-	if len(cmd.Args) == 0 || len(cmd.Args)%2 != 0 {
-		protocol.WriteError(w, "BADREQ", "MSET requires even number of arguments")
+// handleMSet handles the MSET command:
+// MSET k1 len1 [EX <ms>] [NX] k2 len2 [EX <ms>] [NX] ... followed by the
+// concatenated values. The reply is OK with the number of keys written;
+// NX keys that already exist are skipped.
+func (s *Server) handleMSet(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	entries, err := protocol.MSetEntries(cmd.Args)
+	if err != nil {
+		protocol.WriteError(w, "BADREQ", "usage: MSET <key> <len> [EX <ms>] [NX] ...")
 		return
 	}
 
-	// Parse keys and lengths
-	var keys []string
-	var lengths []int
+	var writes []storage.KeyValue
 	offset := 0
-
-	for i := 0; i < len(cmd.Args); i += 2 {
-		key := cmd.Args[i]
-		length, err := strconv.Atoi(cmd.Args[i+1])
-		if err != nil || length < 0 {
+	for _, e := range entries {
+		if offset+e.Length > len(cmd.Payload) {
 			protocol.WriteError(w, "BADREQ", "invalid length")
 			return
 		}
+		writes = append(writes, storage.KeyValue{
+			Key:     cmd.Args[e.Index],
+			Value:   cmd.Payload[offset : offset+e.Length],
+			Options: storage.SetOptions{ExpiryMs: e.ExpiryMs, NX: e.NX},
+		})
+		offset += e.Length
+	}
 
-		keys = append(keys, key)
-		lengths = append(lengths, length)
+	// All or nothing: a key that can't be written fails the whole MSET
+	versions, err := s.store.SetMany(writes)
+	if err != nil {
+		if err == storage.ErrKeyTooLarge || err == storage.ErrValueTooLarge {
+			protocol.WriteError(w, "TOOLARGE", err.Error())
+		} else if err == storage.ErrKeyInvalid || err == storage.ErrTTLTooLong {
+			protocol.WriteError(w, "BADREQ", err.Error())
+		} else {
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
 	}
 
-	// Set each key-value pair
+	// Collect the soft limits crossed
 	count := 0
-	for i, key := range keys {
-		length := lengths[i]
-		value := cmd.Payload[offset : offset+length]
-		offset += length
+	var reasons []string
+	for i, write := range writes {
+		if versions[i] == 0 {
+			continue
+		}
+		count++
+		for _, reason := range s.checkSoftLimits(write.Key, len(write.Value)) {
+			if !slices.Contains(reasons, reason) {
+				reasons = append(reasons, reason)
+			}
+		}
+	}
+
+	reply := append([]string{"OK", strconv.Itoa(count)}, warnFlags(cc, reasons)...)
+	fmt.Fprintf(w, "%s\r\n", strings.Join(reply, " "))
+}
+
+// handleCheckSet handles the CHECKSET command:
+// CHECKSET <n> k1 ver1 ... kn vern k1 len1 k2 len2 ... followed by the
+// concatenated values, as for MSET. The writes are applied only if every
+// key is at its expected version (0 for a key that must not exist), and
+// the reply is OK with the new version of each write.
+func (s *Server) handleCheckSet(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	writeArgs, err := protocol.CheckSetWrites(cmd.Args)
+	if err != nil || len(writeArgs)%2 != 0 {
+		protocol.WriteError(w, "BADREQ", "usage: CHECKSET <n> <key> <version>... <key> <len>...")
+		return
+	}
 
-		_, err := s.store.Set(key, value, storage.SetOptions{})
+	var checks []storage.VersionCheck
+	for i := 1; i < len(cmd.Args)-len(writeArgs); i += 2 {
+		version, err := strconv.ParseUint(cmd.Args[i+1], 10, 64)
 		if err != nil {
-			if err == storage.ErrKeyTooLarge || err == storage.ErrValueTooLarge {
-				protocol.WriteError(w, "TOOLARGE", err.Error())
-			} else if err == storage.ErrKeyInvalid {
-				protocol.WriteError(w, "BADREQ", "key contains invalid characters")
-			} else {
-				protocol.WriteError(w, "INTERNAL", err.Error())
+			protocol.WriteError(w, "BADREQ", "invalid version")
+			return
+		}
+		checks = append(checks, storage.VersionCheck{Key: cmd.Args[i], Version: version})
+	}
+
+	var writes []storage.KeyValue
+	offset := 0
+	for i := 0; i < len(writeArgs); i += 2 {
+		length, err := strconv.Atoi(writeArgs[i+1])
+		if err != nil || length < 0 || offset+length > len(cmd.Payload) {
+			protocol.WriteError(w, "BADREQ", "invalid length")
+			return
+		}
+		writes = append(writes, storage.KeyValue{Key: writeArgs[i], Value: cmd.Payload[offset : offset+length]})
+		offset += length
+	}
+
+	versions, err := s.store.CheckSet(checks, writes)
+	if err != nil {
+		var failed *storage.CheckFailedError
+		switch {
+		case errors.As(err, &failed):
+			protocol.WriteError(w, "VER", fmt.Sprintf("version mismatch for %s", cc.clientKey(failed.Key)))
+		case err == storage.ErrKeyTooLarge || err == storage.ErrValueTooLarge:
+			protocol.WriteError(w, "TOOLARGE", err.Error())
+		case err == storage.ErrKeyInvalid || err == storage.ErrTTLTooLong:
+			protocol.WriteError(w, "BADREQ", err.Error())
+		default:
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+
+	fmt.Fprintf(w, "OK")
+	for _, version := range versions {
+		fmt.Fprintf(w, " %d", version)
+	}
+	fmt.Fprintf(w, "\r\n")
+}
+
+// handleSwapPrefix handles the SWAPPREFIX command: SWAPPREFIX <a> <b>
+// atomically renames keys under prefix a to prefix b and the other way
+// round, replying OK with the number of keys moved
+func (s *Server) handleSwapPrefix(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 2 {
+		protocol.WriteError(w, "BADREQ", "usage: SWAPPREFIX <a> <b>")
+		return
+	}
+
+	moved, err := s.store.SwapPrefix(cmd.Args[0], cmd.Args[1])
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrKeyTooLarge):
+			protocol.WriteError(w, "TOOLARGE", err.Error())
+		case errors.Is(err, storage.ErrPrefixOverlap), errors.Is(err, storage.ErrKeyInvalid):
+			protocol.WriteError(w, "BADREQ", err.Error())
+		default:
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+
+	fmt.Fprintf(w, "OK %d\r\n", moved)
+}
+
+// handleKeys handles the KEYS command, listing up to keys_max keys that
+// match a glob pattern:
+//
+//	KEYS <pattern> → KEY <key> ... [TRUNCATED] END
+func (s *Server) handleKeys(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 1 {
+		protocol.WriteError(w, "BADREQ", "usage: KEYS <pattern>")
+		return
+	}
+	if s.config.KeysMax <= 0 {
+		protocol.WriteError(w, "NOPERM", "KEYS is disabled by keys_max")
+		return
+	}
+
+	keys, more := s.store.Keys(cmd.Args[0], s.config.KeysMax)
+	for _, key := range keys {
+		fmt.Fprintf(w, "KEY %s\r\n", cc.clientKey(key))
+	}
+	if more {
+		fmt.Fprintf(w, "TRUNCATED\r\n")
+	}
+	fmt.Fprintf(w, "END\r\n")
+}
+
+// handleDBSize handles DBSIZE, replying with the number of live keys
+func (s *Server) handleDBSize(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 0 {
+		protocol.WriteError(w, "BADREQ", "usage: DBSIZE")
+		return
+	}
+
+	protocol.WriteInteger(w, s.store.DBSize())
+}
+
+// handleTouch handles the TOUCH command, replying with how many of the
+// keys exist
+func (s *Server) handleTouch(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) == 0 {
+		protocol.WriteError(w, "BADREQ", "TOUCH requires at least 1 argument")
+		return
+	}
+
+	n, err := s.store.Touch(cmd.Args...)
+	if err != nil {
+		if err == storage.ErrKeyInvalid {
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		} else {
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+	protocol.WriteInteger(w, int64(n))
+}
+
+// handleRandomKey handles RANDOMKEY, replying like KEYS with one live key
+// picked uniformly at random, or with no KEY line when there are none
+func (s *Server) handleRandomKey(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 0 {
+		protocol.WriteError(w, "BADREQ", "usage: RANDOMKEY")
+		return
+	}
+
+	if key, err := s.store.RandomKey(); err == nil {
+		fmt.Fprintf(w, "KEY %s\r\n", key)
+	}
+	fmt.Fprintf(w, "END\r\n")
+}
+
+// maxScanCount bounds SCAN ... COUNT
+const maxScanCount = 10000
+
+// handleScan handles SCAN <cursor> [MATCH <pattern>] [COUNT <n>], listing
+// keys in order from cursor. Cursors are the hex-encoded last key returned,
+// or 0 to start and once the iteration is done.
+func (s *Server) handleScan(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) < 1 {
+		protocol.WriteError(w, "BADREQ", "usage: SCAN <cursor> [MATCH <pattern>] [COUNT <n>]")
+		return
+	}
+
+	var cursor string
+	if cmd.Args[0] != "0" {
+		b, err := hex.DecodeString(cmd.Args[0])
+		if err != nil || len(b) == 0 {
+			protocol.WriteError(w, "BADREQ", "invalid cursor")
+			return
+		}
+		cursor = string(b)
+	}
+
+	pattern, count := "*", 100
+	for i := 1; i < len(cmd.Args); i += 2 {
+		if i+1 >= len(cmd.Args) {
+			protocol.WriteError(w, "BADREQ", cmd.Args[i]+" requires value")
+			return
+		}
+		switch strings.ToUpper(cmd.Args[i]) {
+		case "MATCH":
+			pattern = cmd.Args[i+1]
+		case "COUNT":
+			n, err := strconv.Atoi(cmd.Args[i+1])
+			if err != nil || n < 1 || n > maxScanCount {
+				protocol.WriteError(w, "BADREQ", "invalid count")
+				return
 			}
+			count = n
+		default:
+			protocol.WriteError(w, "BADREQ", "unknown option: "+cmd.Args[i])
 			return
 		}
-		count++
 	}
 
-	fmt.Fprintf(w, "OK %d\r\n", count)
+	keys, next := s.store.Scan(cursor, pattern, count)
+	for _, key := range keys {
+		fmt.Fprintf(w, "KEY %s\r\n", cc.clientKey(key))
+	}
+	if next == "" {
+		fmt.Fprintf(w, "CURSOR 0\r\n")
+	} else {
+		fmt.Fprintf(w, "CURSOR %s\r\n", hex.EncodeToString([]byte(next)))
+	}
+	fmt.Fprintf(w, "END\r\n")
+}
+
+// handleKeyTemp handles the KEYTEMP command
+func (s *Server) handleKeyTemp(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) > 1 {
+		protocol.WriteError(w, "BADREQ", "KEYTEMP accepts at most 1 argument")
+		return
+	}
+
+	samples := 5
+	if len(cmd.Args) == 1 {
+		n, err := strconv.Atoi(cmd.Args[0])
+		if err != nil || n < 0 || n > 100 {
+			protocol.WriteError(w, "BADREQ", "invalid sample count")
+			return
+		}
+		samples = n
+	}
+
+	// BUCKET <name> <keys> <hits> [sample...]
+	for _, b := range s.store.KeyTemperature(samples) {
+		fmt.Fprintf(w, "BUCKET %s %d %d", b.Name, b.Keys, b.Hits)
+		for _, key := range b.Samples {
+			fmt.Fprintf(w, " %s", key)
+		}
+		fmt.Fprintf(w, "\r\n")
+	}
+	fmt.Fprintf(w, "END\r\n")
 }
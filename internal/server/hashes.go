@@ -0,0 +1,178 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+	"github.com/bharatmehan/osprey/internal/storage"
+)
+
+// handleHSet handles HSET <key> <field> <len> [<field> <len>...], whose
+// payload is the concatenated values. The reply is OK with the hash's new
+// version and the number of fields added.
+func (s *Server) handleHSet(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) < 3 || len(cmd.Args)%2 != 1 {
+		protocol.WriteError(w, "BADREQ", "usage: HSET <key> <field> <len> [<field> <len>...]")
+		return
+	}
+
+	key := cmd.Args[0]
+	var fields []storage.HashField
+	offset := 0
+	for i := 1; i < len(cmd.Args); i += 2 {
+		length, err := strconv.Atoi(cmd.Args[i+1])
+		if err != nil || length < 0 || offset+length > len(cmd.Payload) {
+			protocol.WriteError(w, "BADREQ", "invalid length")
+			return
+		}
+		fields = append(fields, storage.HashField{Name: cmd.Args[i], Value: cmd.Payload[offset : offset+length]})
+		offset += length
+	}
+
+	added, version, err := s.store.HSet(key, fields)
+	if err != nil {
+		switch err {
+		case storage.ErrKeyTooLarge:
+			protocol.WriteError(w, "TOOLARGE", "key too large")
+		case storage.ErrValueTooLarge:
+			protocol.WriteError(w, "TOOLARGE", "value too large")
+		case storage.ErrWrongType:
+			protocol.WriteError(w, "TYPE", err.Error())
+		case storage.ErrKeyInvalid:
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		case storage.ErrTTLTooLong:
+			protocol.WriteError(w, "BADREQ", "TTL exceeds max_ttl_ms")
+		default:
+			protocol.WriteError(w, "BADREQ", err.Error())
+		}
+		return
+	}
+
+	protocol.WriteAppended(w, version, added, warnFlags(cc, s.checkSoftLimits(key, len(cmd.Payload)))...)
+}
+
+// handleHDel handles HDEL <key> <field>..., replying with the number of
+// fields removed
+func (s *Server) handleHDel(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) < 2 {
+		protocol.WriteError(w, "BADREQ", "usage: HDEL <key> <field>...")
+		return
+	}
+
+	removed, _, err := s.store.HDel(cmd.Args[0], cmd.Args[1:]...)
+	if err != nil {
+		if err == storage.ErrWrongType {
+			protocol.WriteError(w, "TYPE", err.Error())
+		} else if err == storage.ErrKeyInvalid {
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		} else {
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+
+	protocol.WriteInteger(w, int64(removed))
+}
+
+// getHash looks up the hash for HGET, HMGET and HGETALL, writing the
+// error reply if there is none. A missing key is reported by the caller.
+func (s *Server) getHash(key string, w io.Writer) (*storage.Entry, bool) {
+	entry, err := s.store.GetHash(key)
+	switch err {
+	case nil, storage.ErrKeyNotFound:
+		return entry, true
+	case storage.ErrWrongType:
+		protocol.WriteError(w, "TYPE", err.Error())
+	case storage.ErrKeyInvalid:
+		protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+	default:
+		protocol.WriteError(w, "INTERNAL", err.Error())
+	}
+	return nil, false
+}
+
+// handleHGet handles HGET <key> <field>, replying like GET with the
+// field's value and the hash's version and expiry
+func (s *Server) handleHGet(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 2 {
+		protocol.WriteError(w, "BADREQ", "HGET requires 2 arguments")
+		return
+	}
+
+	entry, ok := s.getHash(cmd.Args[0], w)
+	if !ok {
+		return
+	}
+	if entry == nil {
+		protocol.WriteNotFound(w)
+		return
+	}
+	value, exists := entry.Hash[cmd.Args[1]]
+	if !exists {
+		protocol.WriteNotFound(w)
+		return
+	}
+
+	protocol.WriteValue(w, len(value), entry.Version, entry.ExpiryMs, value)
+}
+
+// handleHMGet handles HMGET <key> <field>..., replying like MGET with a
+// VALUE <field> or NOT_FOUND <field> line for each field
+func (s *Server) handleHMGet(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) < 2 {
+		protocol.WriteError(w, "BADREQ", "usage: HMGET <key> <field>...")
+		return
+	}
+
+	entry, ok := s.getHash(cmd.Args[0], w)
+	if !ok {
+		return
+	}
+	for _, name := range cmd.Args[1:] {
+		var value []byte
+		exists := false
+		if entry != nil {
+			value, exists = entry.Hash[name]
+		}
+		if !exists {
+			fmt.Fprintf(w, "NOT_FOUND %s\r\n", name)
+			continue
+		}
+		writeField(w, name, value, entry)
+	}
+}
+
+// handleHGetAll handles HGETALL <key>, replying with a VALUE line for each
+// field sorted by name, followed by END. A missing key has no fields.
+func (s *Server) handleHGetAll(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 1 {
+		protocol.WriteError(w, "BADREQ", "HGETALL requires 1 argument")
+		return
+	}
+
+	entry, ok := s.getHash(cmd.Args[0], w)
+	if !ok {
+		return
+	}
+	if entry != nil {
+		names := make([]string, 0, len(entry.Hash))
+		for name := range entry.Hash {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			writeField(w, name, entry.Hash[name], entry)
+		}
+	}
+	fmt.Fprintf(w, "END\r\n")
+}
+
+// writeField writes a field of a hash as an MGET-style VALUE line
+func writeField(w io.Writer, name string, value []byte, entry *storage.Entry) {
+	fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", name, len(value), entry.Version, entry.ExpiryMs)
+	w.Write(value)
+	w.Write([]byte("\r\n"))
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"io"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+	"github.com/bharatmehan/osprey/internal/storage"
+)
+
+// writeSketchError writes the reply for an error from PFADD, PFCOUNT or
+// PFMERGE. The store names the key in errors about one of several keys.
+func writeSketchError(w io.Writer, err error) {
+	switch {
+	case errors.Is(err, storage.ErrWrongType), errors.Is(err, storage.ErrNotSketch):
+		protocol.WriteError(w, "TYPE", err.Error())
+	case errors.Is(err, storage.ErrValueTooLarge):
+		protocol.WriteError(w, "TOOLARGE", "value too large")
+	case errors.Is(err, storage.ErrKeyInvalid):
+		protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+	case errors.Is(err, storage.ErrTTLTooLong):
+		protocol.WriteError(w, "BADREQ", "TTL exceeds max_ttl_ms")
+	default:
+		protocol.WriteError(w, "INTERNAL", err.Error())
+	}
+}
+
+// handlePFAdd handles PFADD <key> [element...], replying OK with the
+// sketch's version and 1 if the sketch changed or 0 if it didn't
+func (s *Server) handlePFAdd(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) < 1 {
+		protocol.WriteError(w, "BADREQ", "usage: PFADD <key> [element...]")
+		return
+	}
+
+	key := cmd.Args[0]
+	changed, version, err := s.store.PFAdd(key, cmd.Args[1:]...)
+	if err != nil {
+		writeSketchError(w, err)
+		return
+	}
+
+	n := 0
+	if changed {
+		n = 1
+	}
+	size := 0
+	if entry, err := s.store.GetMeta(key); err == nil {
+		size = int(entry.SizeBytes)
+	}
+	protocol.WriteAppended(w, version, n, warnFlags(cc, s.checkSoftLimits(key, size))...)
+}
+
+// handlePFCount handles PFCOUNT <key>..., replying with the estimated
+// number of distinct elements across the sketches
+func (s *Server) handlePFCount(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) < 1 {
+		protocol.WriteError(w, "BADREQ", "usage: PFCOUNT <key>...")
+		return
+	}
+
+	count, err := s.store.PFCount(cmd.Args...)
+	if err != nil {
+		writeSketchError(w, err)
+		return
+	}
+
+	protocol.WriteInteger(w, int64(count))
+}
+
+// handlePFMerge handles PFMERGE <dest> <source>..., replying OK with the
+// version of the merged sketch
+func (s *Server) handlePFMerge(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) < 2 {
+		protocol.WriteError(w, "BADREQ", "usage: PFMERGE <dest> <source>...")
+		return
+	}
+
+	version, err := s.store.PFMerge(cmd.Args[0], cmd.Args[1:]...)
+	if err != nil {
+		writeSketchError(w, err)
+		return
+	}
+
+	protocol.WriteOKWithVersion(w, version)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ipFilter is the allow_cidrs and deny_cidrs in effect. It is replaced
+// as a whole by CONFIG SET, so the accept loop reads it without locking.
+type ipFilter struct {
+	allow, deny           []*net.IPNet
+	allowCIDRs, denyCIDRs []string // as configured, for CONFIG GET
+}
+
+// newIPFilter parses the allow and deny lists of CIDRs or bare addresses
+func newIPFilter(allowCIDRs, denyCIDRs []string) (*ipFilter, error) {
+	allow, err := parseNetworks("allow_cidrs", allowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseNetworks("deny_cidrs", denyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return &ipFilter{allow: allow, deny: deny, allowCIDRs: allowCIDRs, denyCIDRs: denyCIDRs}, nil
+}
+
+// parseNetworks parses the CIDRs or IP addresses of setting
+func parseNetworks(setting string, entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		if _, n, err := net.ParseCIDR(entry); err == nil {
+			nets = append(nets, n)
+			continue
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid %s entry: %s", setting, entry)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
+
+// allows reports whether a connection from addr may be served: it is not
+// in a denied network and, if any are listed, in an allowed one. Addresses
+// that aren't IPs, such as Unix sockets, are only checked against the
+// allow list being empty.
+func (f *ipFilter) allows(addr net.Addr) bool {
+	var ip net.IP
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		return len(f.allow) == 0
+	}
+
+	for _, n := range f.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, n := range f.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitCIDRs splits a CONFIG SET value of comma-separated entries.
+// "none" clears the list, as an empty value can't be sent.
+func splitCIDRs(value string) []string {
+	if strings.EqualFold(value, "none") {
+		return nil
+	}
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
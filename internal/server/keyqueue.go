@@ -0,0 +1,71 @@
+package server
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// keyQueue executes mutations on a fixed pool of workers. Each key hashes to
+// one worker, so mutations to the same key run in arrival order while
+// different keys proceed concurrently.
+type keyQueue struct {
+	workers []chan *queuedCommand
+	wg      sync.WaitGroup
+	pending int64
+}
+
+// queuedCommand is a unit of work waiting on a worker
+type queuedCommand struct {
+	run  func()
+	done chan struct{}
+}
+
+// newKeyQueue starts the worker pool
+func newKeyQueue(workers, depth int) *keyQueue {
+	q := &keyQueue{
+		workers: make([]chan *queuedCommand, workers),
+	}
+
+	for i := range q.workers {
+		ch := make(chan *queuedCommand, depth)
+		q.workers[i] = ch
+
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for qc := range ch {
+				qc.run()
+				atomic.AddInt64(&q.pending, -1)
+				close(qc.done)
+			}
+		}()
+	}
+
+	return q
+}
+
+// Do runs fn on the worker owning key and waits for it to finish
+func (q *keyQueue) Do(key string, fn func()) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	worker := q.workers[h.Sum32()%uint32(len(q.workers))]
+
+	qc := &queuedCommand{run: fn, done: make(chan struct{})}
+	atomic.AddInt64(&q.pending, 1)
+	worker <- qc
+	<-qc.done
+}
+
+// Pending returns the number of queued or running commands
+func (q *keyQueue) Pending() int64 {
+	return atomic.LoadInt64(&q.pending)
+}
+
+// Close stops the workers once queued commands have drained
+func (q *keyQueue) Close() {
+	for _, ch := range q.workers {
+		close(ch)
+	}
+	q.wg.Wait()
+}
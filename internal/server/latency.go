@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+)
+
+// handleLatency handles the LATENCY command:
+// LATENCY LATEST | LATENCY HISTORY <event> | LATENCY RESET [event...]
+func (s *Server) handleLatency(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) == 0 {
+		protocol.WriteError(w, "BADREQ", "LATENCY requires a subcommand")
+		return
+	}
+	monitor := s.store.Latency()
+
+	switch sub := strings.ToUpper(cmd.Args[0]); sub {
+	case "LATEST":
+		if len(cmd.Args) != 1 {
+			protocol.WriteError(w, "BADREQ", "LATENCY LATEST takes no arguments")
+			return
+		}
+		// EVENT <name> <unix_time> <latest_ms> <max_ms>
+		for _, l := range monitor.Latest() {
+			fmt.Fprintf(w, "EVENT %s %d %d %d\r\n", l.Event, l.Time.Unix(), l.LatencyMs, l.MaxMs)
+		}
+		fmt.Fprintf(w, "END\r\n")
+
+	case "HISTORY":
+		if len(cmd.Args) != 2 {
+			protocol.WriteError(w, "BADREQ", "LATENCY HISTORY requires 1 argument")
+			return
+		}
+		// SAMPLE <unix_time> <latency_ms>
+		for _, sample := range monitor.History(strings.ToLower(cmd.Args[1])) {
+			fmt.Fprintf(w, "SAMPLE %d %d\r\n", sample.Time.Unix(), sample.LatencyMs)
+		}
+		fmt.Fprintf(w, "END\r\n")
+
+	case "RESET":
+		events := make([]string, len(cmd.Args)-1)
+		for i, event := range cmd.Args[1:] {
+			events[i] = strings.ToLower(event)
+		}
+		protocol.WriteInteger(w, int64(monitor.Reset(events...)))
+
+	default:
+		protocol.WriteError(w, "BADREQ", fmt.Sprintf("unknown LATENCY subcommand: %s", sub))
+	}
+}
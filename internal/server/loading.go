@@ -0,0 +1,36 @@
+package server
+
+import "github.com/bharatmehan/osprey/internal/protocol"
+
+// loadingCommands are served whatever keys have been loaded
+var loadingCommands = map[string]bool{
+	"PING": true, "HELLO": true, "AUTH": true, "SHUTDOWN": true, "READONLY": true,
+	"CONFIG": true, "STATS": true, "INFO": true, "LATENCY": true, "CLIENT": true,
+	"SUBSCRIBE": true, "UNSUBSCRIBE": true, "PUBLISH": true,
+}
+
+// loadingReads are the reads served during loading if all their keys are
+// under priority_prefixes
+var loadingReads = map[string]bool{
+	"GET": true, "GETMETA": true, "GETRANGE": true, "EXISTS": true, "TTL": true, "MGET": true, "MEXISTS": true,
+	"HGET": true, "HMGET": true, "HGETALL": true, "SMEMBERS": true, "SISMEMBER": true, "SCARD": true,
+	"ZRANGE": true, "ZRANGEBYSCORE": true, "PFCOUNT": true,
+}
+
+// servesWhileLoading reports whether cmd can run while the store loads
+// the keys outside priority_prefixes. Writes, and anything that lists or
+// counts keys, wait for the load to finish.
+func (s *Server) servesWhileLoading(cmd *protocol.Command) bool {
+	if loadingCommands[cmd.Name] {
+		return true
+	}
+	if !loadingReads[cmd.Name] {
+		return false
+	}
+	for _, i := range keyArgs(cmd) {
+		if !s.store.IsPriorityKey(cmd.Args[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/bharatmehan/osprey/internal/metrics"
+	"github.com/bharatmehan/osprey/internal/protocol"
+)
+
+// metricsNamespace prefixes every metric name in Prometheus output
+const metricsNamespace = "osprey"
+
+// registerMetrics registers the server's own metrics in the store's
+// registry, next to the store's
+func (s *Server) registerMetrics() {
+	r := s.store.Metrics()
+	r.GaugeFunc("clients", "clients", "Connected clients", func() int64 {
+		return int64(atomic.LoadInt32(&s.clientCount))
+	})
+	s.throttledTotal = r.Counter("throttled_total", "clients", "Writes delayed by write rate limits")
+	s.netInputBytes = r.Counter("net_input_bytes_total", "clients", "Bytes read from clients")
+	s.netOutputBytes = r.Counter("net_output_bytes_total", "clients", "Bytes written to clients")
+	s.bandwidthThrottled = r.Counter("bandwidth_throttled_total", "clients", "Reads and writes delayed by bandwidth caps")
+	s.connectionsDeniedTotal = r.Counter("connections_denied_total", "clients", "Connections closed on accept by allow_cidrs or deny_cidrs")
+	s.handshakeTimeoutsTotal = r.Counter("handshake_timeouts_total", "clients", "Connections closed for sending no command within handshake_timeout_ms")
+	s.commandTimeoutsTotal = r.Counter("command_timeouts_total", "clients", "Connections closed for sending a command slower than command_timeout_ms")
+	r.GaugeFunc("pubsub_channels", "clients", "Channels with at least one subscriber", s.pubsub.count)
+	s.pubsubMessagesTotal = r.Counter("pubsub_messages_total", "clients", "Published messages queued for subscribers, once per subscriber")
+	s.pubsubDroppedTotal = r.Counter("pubsub_dropped_total", "clients", "Subscribers disconnected with more than pubsub_queue_max messages waiting")
+	s.shedLowTotal = r.Counter("shed_low_total", "clients", "Low-priority requests shed under load")
+	s.shedNormalTotal = r.Counter("shed_normal_total", "clients", "Normal-priority requests shed under load")
+	s.shedOverloadTotal = r.Counter("shed_overload_total", "clients", "Requests rejected with BUSY under overload")
+	s.refreshTotal = r.Counter("refresh_total", "keyspace", "Keys reloaded from a refresh_url before expiring")
+	s.refreshErrorsTotal = r.Counter("refresh_errors_total", "keyspace", "Failed refresh_url reloads")
+	s.idempReplayedTotal = r.Counter("idemp_replayed_total", "commands", "Retried SETs acknowledged from their IDEMP token")
+	s.snapshotQueuedTotal = r.Counter("snapshot_queued_total", "persistence", "Writes held until a snapshot pause ended")
+	s.snapshotQueueTimeouts = r.Counter("snapshot_queue_timeouts_total", "persistence", "Writes rejected with BUSY after waiting out snapshot_queue_timeout_ms")
+	s.softLimitWarnings = r.Counter("soft_limit_warnings_total", "keyspace", "Writes past soft_max_key_bytes, soft_max_value_bytes or memory_warn_ratio")
+	s.cmdLatency = r.Histogram("cmd_latency", "commands", "Command latency, from parsing to the flushed reply")
+	if len(s.tenants) > 0 {
+		s.registerTenantMetrics(r)
+	}
+	if s.keyQueue != nil {
+		r.GaugeFunc("keyqueue_pending", "commands", "Commands waiting in the per-key queues", s.keyQueue.Pending)
+	}
+}
+
+// handleInfo handles the INFO command: INFO [section]. It reports the same
+// fields as STATS, or only those of one section.
+func (s *Server) handleInfo(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) > 1 {
+		protocol.WriteError(w, "BADREQ", "usage: INFO [section]")
+		return
+	}
+
+	sections := s.store.Metrics().Grouped()
+	if len(cmd.Args) == 1 {
+		name := strings.ToLower(cmd.Args[0])
+		var found []metrics.Section
+		for _, section := range sections {
+			if section.Name == name {
+				found = append(found, section)
+			}
+		}
+		if len(found) == 0 {
+			protocol.WriteError(w, "BADREQ", fmt.Sprintf("unknown section %s", cmd.Args[0]))
+			return
+		}
+		sections = found
+	}
+
+	writeSections(w, sections, false)
+}
+
+// writeSections writes the STATS and INFO reply, with a description after
+// each field if verbose:
+//
+//	# <section>
+//	<name>=<value>[ # <description>]
+//	...
+//	END
+func writeSections(w io.Writer, sections []metrics.Section, verbose bool) {
+	for _, section := range sections {
+		fmt.Fprintf(w, "# %s\r\n", section.Name)
+		for _, v := range section.Values {
+			if verbose && v.Help != "" {
+				fmt.Fprintf(w, "%s=%s # %s\r\n", v.Name, v.Value, v.Help)
+			} else {
+				fmt.Fprintf(w, "%s=%s\r\n", v.Name, v.Value)
+			}
+		}
+	}
+	fmt.Fprintf(w, "END\r\n")
+}
+
+// startMetrics serves the Prometheus endpoint on metrics_addr, if set. A
+// port that can't be bound is logged rather than stopping the server, as
+// happens briefly during a handover while the old process still holds it.
+func (s *Server) startMetrics() {
+	if !s.config.MetricsEnable || s.config.MetricsAddr == "" {
+		return
+	}
+
+	listener, err := net.Listen("tcp", s.config.MetricsAddr)
+	if err != nil {
+		log.Printf("Metrics endpoint disabled: %v", err)
+		return
+	}
+
+	s.metricsListener = listener
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.store.Metrics().Handler(metricsNamespace))
+	s.metricsServer = &http.Server{Handler: mux}
+
+	go func() {
+		if err := s.metricsServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Metrics endpoint error: %v", err)
+		}
+	}()
+	log.Printf("Serving metrics on http://%s/metrics", listener.Addr())
+}
+
+// MetricsAddress returns the address the Prometheus endpoint listens on,
+// or "" if it isn't being served
+func (s *Server) MetricsAddress() string {
+	if s.metricsListener == nil {
+		return ""
+	}
+	return s.metricsListener.Addr().String()
+}
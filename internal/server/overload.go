@@ -0,0 +1,32 @@
+package server
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// overloaded reports whether in-flight commands or the WAL fsync backlog
+// exceed their configured thresholds
+func (s *Server) overloaded() bool {
+	if limit := s.config.ShedInflightThreshold; limit > 0 && atomic.LoadInt64(&s.inflight) > int64(limit) {
+		return true
+	}
+	if limit := s.config.ShedWALBacklogBytes; limit > 0 && s.store.WALBacklogBytes() > limit {
+		return true
+	}
+	return false
+}
+
+// shouldShedOverload decides whether to reject a command while overloaded.
+// Only shed_fraction of requests are rejected so the remaining traffic keeps
+// acceptable latency instead of everyone timing out.
+func (s *Server) shouldShedOverload(priority priorityClass) bool {
+	if priority == priorityHigh || !s.overloaded() {
+		return false
+	}
+	if rand.Float64() >= s.config.ShedFraction {
+		return false
+	}
+	s.shedOverloadTotal.Inc()
+	return true
+}
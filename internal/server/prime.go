@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+	"github.com/bharatmehan/osprey/pkg/client"
+)
+
+// handleExportHot handles EXPORTHOT <count>, replying with the count most
+// accessed keys as write commands in the wire protocol, sent as one VALUE.
+// PRIMEFROM on another node replays them to warm its cache.
+func (s *Server) handleExportHot(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 1 {
+		protocol.WriteError(w, "BADREQ", "EXPORTHOT requires 1 argument")
+		return
+	}
+	count, err := strconv.Atoi(cmd.Args[0])
+	if err != nil || count <= 0 {
+		protocol.WriteError(w, "BADREQ", "count must be a positive integer")
+		return
+	}
+
+	var buf bytes.Buffer
+	n, err := s.store.ExportHot(&buf, count)
+	if err != nil {
+		protocol.WriteError(w, "INTERNAL", err.Error())
+		return
+	}
+	log.Printf("EXPORTHOT sent %d keys to %s", n, cc.remoteAddr())
+	protocol.WriteValue(w, buf.Len(), 0, 0, buf.Bytes())
+}
+
+// handlePrimeFrom handles PRIMEFROM <addr> <count>, which fetches the
+// count most accessed keys of the node at addr with EXPORTHOT and writes
+// them here, so a new node doesn't start with a cold cache. It
+// authenticates with admin_password, which the nodes are expected to
+// share, and only runs on an empty store so primed values never replace
+// newer writes. The reply is OK with the number of keys written.
+func (s *Server) handlePrimeFrom(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 2 {
+		protocol.WriteError(w, "BADREQ", "PRIMEFROM requires 2 arguments")
+		return
+	}
+	addr := cmd.Args[0]
+	count, err := strconv.Atoi(cmd.Args[1])
+	if err != nil || count <= 0 {
+		protocol.WriteError(w, "BADREQ", "count must be a positive integer")
+		return
+	}
+	if n := s.store.Len(); n > 0 {
+		protocol.WriteError(w, "BUSY", fmt.Sprintf("store already has %d keys", n))
+		return
+	}
+
+	start := time.Now()
+	data, err := fetchHot(addr, count, s.config.AdminPassword)
+	if err != nil {
+		protocol.WriteError(w, "INTERNAL", fmt.Sprintf("EXPORTHOT from %s: %v", addr, err))
+		return
+	}
+	if _, err := s.replay(bytes.NewReader(data)); err != nil {
+		protocol.WriteError(w, "INTERNAL", "priming failed: "+err.Error())
+		return
+	}
+
+	keys := s.store.Len()
+	log.Printf("Primed %d keys from %s in %v", keys, addr, time.Since(start))
+	fmt.Fprintf(w, "OK %d\r\n", keys)
+}
+
+// fetchHot asks the node at addr for its count most accessed keys,
+// authenticating with password when it is set
+func fetchHot(addr string, count int, password string) ([]byte, error) {
+	c, err := client.New(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if password != "" {
+		resp, err := c.AuthHMAC("", password)
+		if err != nil {
+			return nil, err
+		}
+		if !resp.Success {
+			return nil, fmt.Errorf("AUTH failed: %s", resp.Error)
+		}
+	}
+	return c.ExportHot(count)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// priorityClass orders connections for load shedding
+type priorityClass int
+
+const (
+	priorityLow priorityClass = iota
+	priorityNormal
+	priorityHigh
+)
+
+// parsePriority parses a priority class name
+func parsePriority(name string) (priorityClass, bool) {
+	switch strings.ToLower(name) {
+	case "low":
+		return priorityLow, true
+	case "normal":
+		return priorityNormal, true
+	case "high":
+		return priorityHigh, true
+	default:
+		return priorityNormal, false
+	}
+}
+
+// String returns the priority class name
+func (p priorityClass) String() string {
+	switch p {
+	case priorityLow:
+		return "low"
+	case priorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+// shouldShed reports whether a command from a connection of the given
+// priority should be rejected because more commands than the class limit
+// are in flight (including this one). High-priority connections are
+// never shed.
+func (s *Server) shouldShed(priority priorityClass) bool {
+	inflight := atomic.LoadInt64(&s.inflight)
+
+	switch priority {
+	case priorityLow:
+		limit := s.config.PriorityShedLowInflight
+		if limit > 0 && inflight > int64(limit) {
+			s.shedLowTotal.Inc()
+			return true
+		}
+	case priorityNormal:
+		limit := s.config.PriorityShedNormalInflight
+		if limit > 0 && inflight > int64(limit) {
+			s.shedNormalTotal.Inc()
+			return true
+		}
+	}
+
+	return false
+}
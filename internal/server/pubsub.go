@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+)
+
+// message is a published message on its way to a subscriber
+type message struct {
+	channel string
+	payload []byte
+}
+
+// pubsub is the registry of channel subscriptions. Messages aren't stored:
+// PUBLISH hands each one to the connections subscribed at that moment.
+type pubsub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*clientConn]struct{}
+}
+
+func newPubsub() *pubsub {
+	return &pubsub{channels: make(map[string]map[*clientConn]struct{})}
+}
+
+// subscribe adds cc to the subscribers of channel
+func (p *pubsub) subscribe(channel string, cc *clientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subs := p.channels[channel]
+	if subs == nil {
+		subs = make(map[*clientConn]struct{})
+		p.channels[channel] = subs
+	}
+	subs[cc] = struct{}{}
+}
+
+// unsubscribe removes cc from the subscribers of channel
+func (p *pubsub) unsubscribe(channel string, cc *clientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subs := p.channels[channel]
+	delete(subs, cc)
+	if len(subs) == 0 {
+		delete(p.channels, channel)
+	}
+}
+
+// subscribers returns the connections subscribed to channel
+func (p *pubsub) subscribers(channel string) []*clientConn {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	subs := make([]*clientConn, 0, len(p.channels[channel]))
+	for cc := range p.channels[channel] {
+		subs = append(subs, cc)
+	}
+	return subs
+}
+
+// count returns the number of channels with subscribers
+func (p *pubsub) count() int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return int64(len(p.channels))
+}
+
+// subscribedCommands are the commands a connection may send while it is
+// subscribed, as anything else would have its reply mixed in with
+// messages
+var subscribedCommands = map[string]bool{
+	"SUBSCRIBE": true, "UNSUBSCRIBE": true, "PING": true,
+}
+
+// handleSubscribe handles SUBSCRIBE <channel>..., replying OK with the
+// number of channels the connection is subscribed to. From then on
+// messages published to them are written to the connection as MESSAGE
+// frames, between the replies to its commands.
+func (s *Server) handleSubscribe(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) == 0 {
+		protocol.WriteError(w, "BADREQ", "usage: SUBSCRIBE <channel> [channel...]")
+		return
+	}
+
+	if cc.messages == nil {
+		cc.channels = make(map[string]bool)
+		cc.messages = make(chan message, max(s.config.PubsubQueueMax, 1))
+		cc.closed = make(chan struct{})
+		go s.deliver(cc)
+	}
+	for _, channel := range cmd.Args {
+		if !cc.channels[channel] {
+			cc.channels[channel] = true
+			s.pubsub.subscribe(channel, cc)
+		}
+	}
+
+	fmt.Fprintf(w, "OK %d\r\n", len(cc.channels))
+}
+
+// handleUnsubscribe handles UNSUBSCRIBE [channel...], replying OK with the
+// number of channels the connection is still subscribed to. Without
+// channels it unsubscribes from all of them.
+func (s *Server) handleUnsubscribe(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	channels := cmd.Args
+	if len(channels) == 0 {
+		for channel := range cc.channels {
+			channels = append(channels, channel)
+		}
+	}
+	for _, channel := range channels {
+		if cc.channels[channel] {
+			delete(cc.channels, channel)
+			s.pubsub.unsubscribe(channel, cc)
+		}
+	}
+
+	fmt.Fprintf(w, "OK %d\r\n", len(cc.channels))
+}
+
+// handlePublish handles PUBLISH <channel> <len>\r\n<payload>, replying with
+// the number of subscribers the message was queued for
+func (s *Server) handlePublish(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 2 {
+		protocol.WriteError(w, "BADREQ", "usage: PUBLISH <channel> <len>")
+		return
+	}
+	if len(cmd.Payload) > s.config.MaxValueBytes {
+		protocol.WriteError(w, "TOOLARGE", "value too large")
+		return
+	}
+
+	msg := message{channel: cmd.Args[0], payload: cmd.Payload}
+	receivers := 0
+	for _, cc := range s.pubsub.subscribers(msg.channel) {
+		select {
+		case cc.messages <- msg:
+			receivers++
+		default:
+			s.dropSubscriber(cc)
+		}
+	}
+	s.pubsubMessagesTotal.Add(uint64(receivers))
+
+	protocol.WriteInteger(w, int64(receivers))
+}
+
+// dropSubscriber closes the connection of a subscriber whose queue is
+// full. Dropping its messages instead would leave it missing some without
+// knowing; a closed connection tells it to resubscribe.
+func (s *Server) dropSubscriber(cc *clientConn) {
+	if !cc.dropped.CompareAndSwap(false, true) {
+		return
+	}
+	s.pubsubDroppedTotal.Inc()
+	log.Printf("Closed connection from %s: more than pubsub_queue_max (%d) messages waiting", cc.remoteAddr(), s.config.PubsubQueueMax)
+	cc.conn.Close()
+}
+
+// deliver writes the messages queued for a subscribed connection until it
+// closes, taking the connection's writer between replies to its commands
+func (s *Server) deliver(cc *clientConn) {
+	for {
+		select {
+		case msg := <-cc.messages:
+			cc.out.Lock()
+			s.writeMessage(cc, msg)
+			for queued := len(cc.messages); queued > 0; queued-- {
+				s.writeMessage(cc, <-cc.messages)
+			}
+			cc.writer.Flush()
+			cc.out.Unlock()
+		case <-cc.closed:
+			return
+		}
+	}
+}
+
+// writeMessage writes msg to a subscriber, unless it has unsubscribed from
+// the channel since the message was queued. The caller holds cc.out, which
+// the connection holds while it runs commands, so the channels can't
+// change underneath it.
+func (s *Server) writeMessage(cc *clientConn, msg message) {
+	if cc.channels[msg.channel] {
+		protocol.WriteMessage(cc.writer, cc.clientKey(msg.channel), msg.payload)
+	}
+}
+
+// unsubscribeAll removes a closing connection from its channels and stops
+// its delivery
+func (s *Server) unsubscribeAll(cc *clientConn) {
+	if cc.messages == nil {
+		return
+	}
+	for channel := range cc.channels {
+		s.pubsub.unsubscribe(channel, cc)
+	}
+	close(cc.closed)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errWriteThrottled = errors.New("write rate exceeded")
+	errWriteOverBurst = errors.New("write has more keys than the rate limit burst")
+)
+
+// tokenBucket is a simple token-bucket rate limiter
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a bucket refilling at rate tokens/sec.
+// A burst of zero defaults to one second worth of tokens.
+func newTokenBucket(rate, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens accrued since the last call. The caller holds mu.
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// fits reports whether n tokens can ever be available at once
+func (b *tokenBucket) fits(n int) bool {
+	return float64(n) <= b.burst
+}
+
+// AllowN takes n tokens if available
+func (b *tokenBucket) AllowN(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// allowAll takes n[i] tokens from each of buckets[i] if every bucket has
+// enough, and none otherwise. Buckets are locked in the order given, so
+// callers sharing buckets must pass them in the same order.
+func allowAll(buckets []*tokenBucket, n []int) bool {
+	for _, b := range buckets {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+	}
+
+	for i, b := range buckets {
+		b.refill()
+		if b.tokens < float64(n[i]) {
+			return false
+		}
+	}
+	for i, b := range buckets {
+		b.tokens -= float64(n[i])
+	}
+	return true
+}
+
+// ReserveN takes n tokens, going into debt if there aren't enough, and
+// returns how long the caller should wait for the debt to be repaid
+func (b *tokenBucket) ReserveN(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/storage"
+)
+
+// refreshTimeout bounds each request to a refresh_url loader
+const refreshTimeout = 5 * time.Second
+
+// errNotLoaded is returned by a loader that no longer has the key. The
+// key is left to expire.
+var errNotLoaded = errors.New("not found by loader")
+
+// refreshing reports whether any prefix rule has a refresh_url
+func (s *Server) refreshing() bool {
+	for _, rule := range s.config.PrefixRules {
+		if rule.RefreshURL != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshAhead reloads keys with a refresh_url shortly before they expire,
+// every refresh_interval_ms until Shutdown
+func (s *Server) refreshAhead() {
+	defer s.shutdownWg.Done()
+	// Refreshes are writes, which wait for the load to finish
+	s.store.WaitLoaded()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(s.config.RefreshInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+			s.refreshExpiring(ctx)
+		}
+	}
+}
+
+// refreshAheadMs returns how long before expiry keys under rule are
+// reloaded, by default twice refresh_interval_ms so every key gets at
+// least one attempt
+func (s *Server) refreshAheadMs(rule *config.PrefixRule) int64 {
+	if rule.RefreshAheadMs > 0 {
+		return int64(rule.RefreshAheadMs)
+	}
+	return 2 * int64(s.config.RefreshIntervalMs)
+}
+
+// refreshExpiring reloads every key that is due for a refresh. Keys that
+// haven't been read since they were last written are cold and left to
+// expire.
+func (s *Server) refreshExpiring(ctx context.Context) {
+	var horizon int64
+	for i := range s.config.PrefixRules {
+		rule := &s.config.PrefixRules[i]
+		if rule.RefreshURL != "" && s.refreshAheadMs(rule) > horizon {
+			horizon = s.refreshAheadMs(rule)
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	for key, entry := range s.store.Expiring(now + horizon) {
+		rule := s.config.RuleFor(key)
+		if rule == nil || rule.RefreshURL == "" {
+			continue
+		}
+		if entry.ExpiryMs > now+s.refreshAheadMs(rule) || entry.AccessCount() == 0 {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.refreshKey(ctx, key, entry, rule); err != nil && ctx.Err() == nil {
+			s.refreshErrorsTotal.Inc()
+			log.Printf("Refresh of %s failed: %v", key, err)
+		}
+	}
+}
+
+// refreshKey loads key's value and stores it with a new TTL, unless a
+// client has written the key since entry was read
+func (s *Server) refreshKey(ctx context.Context, key string, entry *storage.Entry, rule *config.PrefixRule) error {
+	ttlMs := int64(rule.RefreshTTLMs)
+	if ttlMs <= 0 {
+		ttlMs = entry.ExpiryMs - entry.UpdatedMs
+	}
+	if ttlMs <= 0 {
+		return nil
+	}
+
+	value, err := s.load(ctx, rule.RefreshURL, key)
+	if errors.Is(err, errNotLoaded) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.store.Set(key, value, storage.SetOptions{
+		ExpiryMs:     ttlMs,
+		CheckVersion: true,
+		Version:      entry.Version,
+	})
+	switch {
+	case err == nil:
+		s.refreshTotal.Inc()
+	case errors.Is(err, storage.ErrVersionMismatch):
+		// Written by a client meanwhile, which is fresher than the loader
+	default:
+		return err
+	}
+	return nil
+}
+
+// load fetches key's value from a refresh_url loader. A 200 response's
+// body is the value and a 404 means the loader no longer has the key.
+func (s *Server) load(ctx context.Context, loader, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+
+	target := strings.ReplaceAll(loader, "{key}", url.PathEscape(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errNotLoaded
+	default:
+		return nil, fmt.Errorf("loader returned %s", resp.Status)
+	}
+
+	limit := s.config.MaxValueBytesFor(key)
+	value, err := io.ReadAll(io.LimitReader(resp.Body, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > limit {
+		return nil, storage.ErrValueTooLarge
+	}
+	return value, nil
+}
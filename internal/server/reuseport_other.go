@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package server
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePort fails: SO_REUSEPORT is not available on this platform
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("reuse_port is not supported on this platform")
+}
@@ -2,18 +2,30 @@ package server
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/bharatmehan/osprey/internal/backup"
 	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/latency"
+	"github.com/bharatmehan/osprey/internal/metrics"
 	"github.com/bharatmehan/osprey/internal/protocol"
+	"github.com/bharatmehan/osprey/internal/proxyproto"
 	"github.com/bharatmehan/osprey/internal/storage"
+	"github.com/bharatmehan/osprey/pkg/command"
+	"github.com/bharatmehan/osprey/pkg/extension"
 )
 
 // Server represents the Osprey server
@@ -23,37 +35,282 @@ type Server struct {
 	listener net.Listener
 
 	// Connection management
-	mu          sync.RWMutex
-	connections map[net.Conn]struct{}
-	clientCount int32
+	mu           sync.RWMutex
+	connections  map[net.Conn]*clientConn
+	clientCount  int32
+	nextClientID uint64
+
+	// Write rate limiting
+	prefixLimiters map[string]*tokenBucket
+	throttledTotal *metrics.Counter
+
+	// Bytes read from and written to clients, and reads and writes held
+	// back by bandwidth caps
+	netInputBytes      *metrics.Counter
+	netOutputBytes     *metrics.Counter
+	bandwidthThrottled *metrics.Counter
+
+	// Load shedding
+	inflight          int64
+	shedLowTotal      *metrics.Counter
+	shedNormalTotal   *metrics.Counter
+	shedOverloadTotal *metrics.Counter
+
+	// Command latency, and the Prometheus endpoint when metrics_addr is set
+	cmdLatency      *metrics.Histogram
+	metricsServer   *http.Server
+	metricsListener net.Listener
+
+	// Keys reloaded from a refresh_url before expiring, and failed reloads
+	refreshTotal       *metrics.Counter
+	refreshErrorsTotal *metrics.Counter
+
+	// SETs acknowledged from their IDEMP token without being applied again
+	idempReplayedTotal *metrics.Counter
+
+	// Writes that crossed a soft limit, and when each limit was last logged
+	softLimitWarnings *metrics.Counter
+	softLimits        *softLimits
+
+	// Settings that can change at runtime, with READONLY and CONFIG SET
+	readOnly   atomic.Bool
+	maxClients atomic.Int32
+
+	// Set while a BGSAVE snapshot is being written
+	bgsaving atomic.Bool
+
+	// Writes held until a snapshot pause ends, with snapshot_write_mode
+	// = "queue", and those that gave up waiting
+	snapshotQueued        atomic.Int64
+	snapshotQueuedTotal   *metrics.Counter
+	snapshotQueueTimeouts *metrics.Counter
+
+	// Sources allowed to send PROXY headers, when proxy_protocol is on
+	proxyTrusted proxyproto.Trusted
+
+	// allow_cidrs and deny_cidrs, see ipfilter.go, and the connections
+	// they closed
+	ipFilter               atomic.Pointer[ipFilter]
+	connectionsDeniedTotal *metrics.Counter
+
+	// Connections closed for sending no command in handshake_timeout_ms,
+	// or too slowly within command_timeout_ms
+	handshakeTimeoutsTotal *metrics.Counter
+	commandTimeoutsTotal   *metrics.Counter
+
+	// Configured users by name, see tenants.go
+	tenants map[string]*tenant
+
+	// Channel subscriptions, see pubsub.go, with the messages queued for
+	// subscribers and the subscribers closed for falling behind
+	pubsub              *pubsub
+	pubsubMessagesTotal *metrics.Counter
+	pubsubDroppedTotal  *metrics.Counter
+
+	// Per-key command queue, nil unless concurrency_model = "keyqueue"
+	keyQueue *keyQueue
 
 	// Shutdown handling
 	shutdown   chan struct{}
 	shutdownWg sync.WaitGroup
+
+	// Closed once the listener is accepting connections
+	ready chan struct{}
+
+	// Closed when a client sends SHUTDOWN
+	shutdownRequested chan struct{}
+	shutdownOnce      sync.Once
+}
+
+// clientConn holds per-connection state
+type clientConn struct {
+	conn         net.Conn
+	priority     priorityClass
+	writeLimiter *tokenBucket // nil when unlimited
+	chunkSize    int          // values larger than this are sent CHUNKED; 0 disables
+	admin        bool         // authenticated with admin_password
+	user         string       // authenticated as this configured user; written under info
+	namespace    string       // the user's namespace, prepended to its keys
+	challenge    string       // nonce from AUTHCHALLENGE, for the next AUTHHMAC
+	compression  string       // negotiated payload compression; "" when off
+	compressMin  int          // values shorter than this are sent uncompressed
+	tracing      bool         // HELLO TRACE ON: commands may carry @<id> trace prefixes
+	warnings     bool         // HELLO WARNINGS ON: write replies flag soft limits crossed
+
+	// Reported by CLIENT LIST, which reads them from other goroutines
+	id        uint64
+	createdAt time.Time
+	info      sync.Mutex
+	remote    net.Addr  // the client's address, from a PROXY header if one was sent
+	name      string    // set with HELLO NAME or CLIENT SETNAME
+	lib       string    // set with HELLO LIB
+	lastCmd   string    // name of the most recent command
+	lastTrace string    // trace ID of the most recent command, if it had one
+	lastUsed  time.Time // when the most recent command arrived
+	bytesIn   atomic.Uint64
+	bytesOut  atomic.Uint64
+
+	// The bandwidth cap of the connection's priority class, and the bucket
+	// enforcing it; nil when unlimited
+	bandwidthCap int
+	bandwidth    *tokenBucket
+
+	// The connection's reply writer, shared with the delivery of messages
+	// once it subscribes; out is held while either writes to it
+	writer *bufio.Writer
+	out    sync.Mutex
+
+	// Set by the first SUBSCRIBE, see pubsub.go: the channels subscribed
+	// to, messages waiting to be written, closed when the connection
+	// closes, and set once the connection is closed for falling behind
+	channels map[string]bool
+	messages chan message
+	closed   chan struct{}
+	dropped  atomic.Bool
 }
 
 // New creates a new server instance
 func New(cfg *config.Config) (*Server, error) {
-	store, err := storage.NewPersistentStore(cfg)
+	return NewFromHandoff(cfg, nil)
+}
+
+// NewFromHandoff creates a server whose dataset is read from handoff,
+// written by the HandOff of the process being upgraded, instead of being
+// recovered from data_dir. A nil handoff recovers as usual.
+func NewFromHandoff(cfg *config.Config, handoff io.Reader) (*Server, error) {
+	if err := checkExtensions(); err != nil {
+		return nil, err
+	}
+
+	store, err := storage.NewPersistentStoreFrom(cfg, handoff)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create store: %w", err)
 	}
 
-	return &Server{
-		config:      cfg,
-		store:       store,
-		connections: make(map[net.Conn]struct{}),
-		shutdown:    make(chan struct{}),
-	}, nil
+	if cfg.Backup.Enable {
+		archiver, err := backup.NewFromConfig(cfg.Backup)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		store.SetArchiver(archiver)
+	}
+
+	if cfg.ProxyProtocol && len(cfg.ProxyTrusted) == 0 {
+		store.Close()
+		return nil, fmt.Errorf("proxy_protocol requires proxy_trusted")
+	}
+	proxyTrusted, err := proxyproto.ParseTrusted(cfg.ProxyTrusted)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	filter, err := newIPFilter(cfg.AllowCIDRs, cfg.DenyCIDRs)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	tenants, err := parseUsers(cfg.Users, store.NormalizeKey)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	prefixLimiters := make(map[string]*tokenBucket)
+	for _, rule := range cfg.PrefixRules {
+		if rule.WriteRateLimit > 0 {
+			prefixLimiters[rule.Prefix] = newTokenBucket(rule.WriteRateLimit, rule.WriteRateBurst)
+		}
+	}
+
+	s := &Server{
+		config:         cfg,
+		store:          store,
+		connections:    make(map[net.Conn]*clientConn),
+		prefixLimiters: prefixLimiters,
+		proxyTrusted:   proxyTrusted,
+		tenants:        tenants,
+		shutdown:       make(chan struct{}),
+		softLimits:     newSoftLimits(),
+		pubsub:         newPubsub(),
+
+		ready:             make(chan struct{}),
+		shutdownRequested: make(chan struct{}),
+	}
+	s.readOnly.Store(cfg.ReadOnly)
+	s.maxClients.Store(int32(cfg.MaxClients))
+	s.ipFilter.Store(filter)
+
+	switch cfg.ConcurrencyModel {
+	case "", "global":
+	case "keyqueue":
+		workers := cfg.KeyQueueWorkers
+		if workers <= 0 {
+			workers = 1
+		}
+		s.keyQueue = newKeyQueue(workers, cfg.KeyQueueDepth)
+	default:
+		store.Close()
+		return nil, fmt.Errorf("unknown concurrency_model: %s", cfg.ConcurrencyModel)
+	}
+	switch cfg.AuthMode {
+	case "", "password", "hmac":
+	default:
+		store.Close()
+		return nil, fmt.Errorf("unknown auth_mode: %s", cfg.AuthMode)
+	}
+	switch cfg.SnapshotWriteMode {
+	case "", "reject", "queue":
+	default:
+		store.Close()
+		return nil, fmt.Errorf("unknown snapshot_write_mode: %s", cfg.SnapshotWriteMode)
+	}
+	if len(tenants) > 0 {
+		s.trackTenantUsage()
+	}
+	s.registerMetrics()
+
+	if cfg.WarmupFile != "" {
+		if err := s.warmup(cfg.WarmupFile); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("warmup from %s failed: %w", cfg.WarmupFile, err)
+		}
+	}
+
+	return s, nil
 }
 
-// Start starts the server
+// Start listens on listen_addr and serves until Shutdown
 func (s *Server) Start() error {
-	listener, err := net.Listen("tcp", s.config.ListenAddr)
+	listener, err := Listen(s.config)
 	if err != nil {
 		return err
 	}
+	return s.Serve(listener)
+}
+
+// Listen opens the TCP listener for cfg, with SO_REUSEPORT if reuse_port
+// is set
+func Listen(cfg *config.Config) (net.Listener, error) {
+	if !cfg.ReusePort {
+		return net.Listen("tcp", cfg.ListenAddr)
+	}
+	lc := net.ListenConfig{Control: reusePort}
+	return lc.Listen(context.Background(), "tcp", cfg.ListenAddr)
+}
+
+// Serve accepts connections on listener until Shutdown. The listener may
+// come from Listen or be inherited from systemd or a previous process.
+func (s *Server) Serve(listener net.Listener) error {
 	s.listener = listener
+	s.startMetrics()
+	if s.refreshing() {
+		s.shutdownWg.Add(1)
+		go s.refreshAhead()
+	}
+	close(s.ready)
 
 	// No need to start sweeper here as it's handled by PersistentStore
 
@@ -83,30 +340,73 @@ func (s *Server) Start() error {
 			continue
 		}
 
+		if !s.ipFilter.Load().allows(conn.RemoteAddr()) {
+			s.connectionsDeniedTotal.Inc()
+			conn.Close()
+			continue
+		}
+
 		// Check client limit
-		if atomic.LoadInt32(&s.clientCount) >= int32(s.config.MaxClients) {
+		if atomic.LoadInt32(&s.clientCount) >= s.maxClients.Load() {
 			conn.Close()
 			continue
 		}
 
+		now := time.Now()
+		cc := &clientConn{
+			conn:        conn,
+			remote:      conn.RemoteAddr(),
+			priority:    priorityNormal,
+			id:          atomic.AddUint64(&s.nextClientID, 1),
+			createdAt:   now,
+			lastUsed:    now,
+			compressMin: defaultCompressMin,
+		}
+		if s.config.WriteRateLimit > 0 {
+			cc.writeLimiter = newTokenBucket(s.config.WriteRateLimit, s.config.WriteRateBurst)
+		}
+
 		s.mu.Lock()
-		s.connections[conn] = struct{}{}
+		s.connections[conn] = cc
 		s.mu.Unlock()
 
 		atomic.AddInt32(&s.clientCount, 1)
 
 		s.shutdownWg.Add(1)
-		go s.handleConnection(conn)
+		go s.handleConnection(cc)
 	}
 }
 
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown() error {
+	s.stop()
+
+	// Close the store
+	if err := s.store.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// HandOff shuts down the server like Shutdown, but writes the dataset to
+// w for the process taking over rather than just closing the store
+func (s *Server) HandOff(w io.Writer) error {
+	s.stop()
+	return s.store.HandOff(w)
+}
+
+// stop closes the listener and every connection and waits for their
+// goroutines, leaving the store open
+func (s *Server) stop() {
 	close(s.shutdown)
 
 	if s.listener != nil {
 		s.listener.Close()
 	}
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
+	}
 
 	// Close all connections
 	s.mu.Lock()
@@ -118,12 +418,24 @@ func (s *Server) Shutdown() error {
 	// Wait for all goroutines
 	s.shutdownWg.Wait()
 
-	// Close the store
-	if err := s.store.Close(); err != nil {
-		return err
+	if s.keyQueue != nil {
+		s.keyQueue.Close()
 	}
+}
 
-	return nil
+// ListenerFile returns a duplicate of the listening socket's descriptor,
+// to pass to another process
+func (s *Server) ListenerFile() (*os.File, error) {
+	fl, ok := s.listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("listener %T has no file descriptor", s.listener)
+	}
+	return fl.File()
+}
+
+// Ready returns a channel that is closed once the server is listening
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
 }
 
 // GetAddress returns the actual listening address (useful for testing with auto-assigned ports)
@@ -135,19 +447,51 @@ func (s *Server) GetAddress() string {
 }
 
 // handleConnection handles a client connection
-func (s *Server) handleConnection(conn net.Conn) {
+func (s *Server) handleConnection(cc *clientConn) {
+	conn := cc.conn
 	defer func() {
 		s.mu.Lock()
 		delete(s.connections, conn)
 		s.mu.Unlock()
 
 		atomic.AddInt32(&s.clientCount, -1)
+		s.unsubscribeAll(cc)
 		conn.Close()
 		s.shutdownWg.Done()
 	}()
 
-	parser := protocol.NewParser(conn)
-	writer := bufio.NewWriter(conn)
+	metered := &meteredConn{s: s, cc: cc}
+	reader := bufio.NewReader(metered)
+	if s.config.ProxyProtocol && s.proxyTrusted.Contains(conn.RemoteAddr()) {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		addr, err := proxyproto.ReadHeader(reader)
+		if err != nil {
+			log.Printf("Dropped connection from %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		if addr != nil {
+			cc.setRemoteAddr(addr)
+		}
+	}
+
+	parser := protocol.NewParser(reader)
+	parser.SetMaxLineBytes(s.config.MaxLineBytes)
+	parser.SetMaxValueBytes(s.config.LargestMaxValueBytes())
+	writer := bufio.NewWriterSize(metered, max(s.config.OutputBufferBytes, 16))
+	cc.writer = writer
+	pending := 0 // commands whose replies haven't been sent
+
+	// Until the first command arrives, reads may not go past handshake
+	var handshake time.Time
+	if t := s.config.HandshakeTimeout(); t > 0 {
+		handshake = cc.createdAt.Add(t)
+	}
+	limit := func(deadline time.Time) time.Time {
+		if !handshake.IsZero() && (deadline.IsZero() || handshake.Before(deadline)) {
+			return handshake
+		}
+		return deadline
+	}
 
 	for {
 		select {
@@ -156,53 +500,263 @@ func (s *Server) handleConnection(conn net.Conn) {
 		default:
 		}
 
-		// Set read deadline to make shutdown more responsive
-		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		// Wait for the next command, waking up now and then to check for
+		// shutdown
+		conn.SetReadDeadline(limit(time.Now().Add(5 * time.Second)))
+		if _, err := reader.Peek(1); err != nil {
+			if !isTimeout(err) {
+				return
+			}
+			if !handshake.IsZero() && !time.Now().Before(handshake) {
+				s.handshakeTimeoutsTotal.Inc()
+				log.Printf("Closed connection from %s: no command in %v", cc.remoteAddr(), s.config.HandshakeTimeout())
+				return
+			}
+			continue
+		}
+
+		// Once a command has started it must arrive in full
+		var deadline time.Time
+		if t := s.config.CommandTimeout(); t > 0 {
+			deadline = time.Now().Add(t)
+		}
+		conn.SetReadDeadline(limit(deadline))
 
 		cmd, err := parser.ParseCommand()
 		if err != nil {
 			if err == io.EOF || errors.Is(err, net.ErrClosed) {
 				return
 			}
+			if isTimeout(err) {
+				if !handshake.IsZero() && !time.Now().Before(handshake) {
+					s.handshakeTimeoutsTotal.Inc()
+				} else {
+					s.commandTimeoutsTotal.Inc()
+				}
+				log.Printf("Closed connection from %s: command not received in time", cc.remoteAddr())
+				return
+			}
+			cc.out.Lock()
 			protocol.WriteError(writer, "BADREQ", err.Error())
 			writer.Flush()
+			cc.out.Unlock()
+			if err == protocol.ErrLineTooLong {
+				// The rest of the line would be read as commands
+				return
+			}
+			continue
+		}
+		handshake = time.Time{}
+
+		if cmd.TraceID != "" && !cc.tracing {
+			cc.out.Lock()
+			protocol.WriteError(writer, "BADREQ", "trace IDs require HELLO TRACE ON")
+			writer.Flush()
+			cc.out.Unlock()
 			continue
 		}
 
-		// Process command
+		// Process command, keeping messages for a subscribed connection
+		// from being written into the middle of its reply
 		start := time.Now()
-		s.processCommand(cmd, writer)
-		writer.Flush()
+		cc.touch(cmd, start)
+		cc.out.Lock()
+		atomic.AddInt64(&s.inflight, 1)
+		s.executeCommand(cc, cmd, writer)
+		atomic.AddInt64(&s.inflight, -1)
+
+		// Hold replies back while more pipelined commands are waiting, up
+		// to max_pipeline of them; output_buffer_bytes sends them sooner
+		pending++
+		if pending >= s.config.MaxPipeline || !pipelined(reader) {
+			writer.Flush()
+			pending = 0
+		}
+		cc.out.Unlock()
 
 		// Log slow commands
 		duration := time.Since(start)
+		s.store.Latency().Record(latency.EventCommand, duration)
+		s.cmdLatency.Observe(duration)
 		if duration > s.config.SlowlogThreshold() {
-			log.Printf("Slow command: %s %v took %v", cmd.Name, cmd.Args, duration)
+			if cmd.TraceID != "" {
+				log.Printf("Slow command: %s %v took %v trace=%s", cmd.Name, cmd.Args, duration, cmd.TraceID)
+			} else {
+				log.Printf("Slow command: %s %v took %v", cmd.Name, cmd.Args, duration)
+			}
 		}
 	}
 }
 
+// pipelined reports whether reader holds the whole command line of
+// another command, so its reply can be sent with the last one's
+func pipelined(reader *bufio.Reader) bool {
+	buffered, _ := reader.Peek(reader.Buffered())
+	return bytes.IndexByte(buffered, '\n') >= 0
+}
+
+// isTimeout reports whether err is a read deadline passing
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// executeCommand runs a command under the configured concurrency model.
+// With the key queue, single-key mutations are handed to the worker that
+// owns the key; everything else runs on the connection goroutine.
+func (s *Server) executeCommand(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cc.channels) > 0 && !subscribedCommands[cmd.Name] {
+		protocol.WriteError(w, "BADREQ", "only SUBSCRIBE, UNSUBSCRIBE and PING are allowed while subscribed")
+		return
+	}
+	if !s.intercept(cc, cmd, w) {
+		return
+	}
+	if spec, ok := command.Lookup(cmd.Name); ok {
+		if err := spec.Check(cmd.Args); err != nil {
+			protocol.WriteError(w, "BADREQ", err.Error())
+			return
+		}
+	}
+	s.normalizeKeys(cmd)
+	if cc.namespace != "" {
+		s.tenants[cc.user].ops.add(time.Now().Unix())
+	}
+	if cc.namespace != "" && !scopeKeys(cmd, cc.namespace) {
+		protocol.WriteError(w, "NOPERM", "command not available to namespaced users")
+		return
+	}
+	if s.isMutatingCommand(cmd.Name) && touchesReserved(cmd) {
+		protocol.WriteError(w, "NOPERM", "key is reserved for server metadata")
+		return
+	}
+
+	if s.keyQueue != nil && s.isMutatingCommand(cmd.Name) {
+		if keys := writeKeys(cmd); len(keys) == 1 {
+			s.keyQueue.Do(keys[0], func() {
+				s.processCommand(cc, cmd, w)
+			})
+			return
+		}
+	}
+
+	s.processCommand(cc, cmd, w)
+}
+
 // processCommand processes a single command
-func (s *Server) processCommand(cmd *protocol.Command, w io.Writer) {
+func (s *Server) processCommand(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	// Shed lower-priority traffic first when overloaded
+	if !isShedExempt(cmd.Name) {
+		if s.shouldShed(cc.priority) {
+			protocol.WriteError(w, "BUSY", "server overloaded")
+			return
+		}
+		if s.shouldShedOverload(cc.priority) {
+			protocol.WriteError(w, "BUSY", fmt.Sprintf("retry_after_ms=%d", s.config.ShedRetryAfterMs))
+			return
+		}
+	}
+
+	if !unauthenticatedCommands[cmd.Name] && !s.authenticated(cc) {
+		protocol.WriteError(w, "NOAUTH", "authentication required")
+		return
+	}
+
+	if isAdminCommand(cmd) && !s.isAdmin(cc) {
+		protocol.WriteError(w, "NOPERM", "admin command")
+		return
+	}
+
+	if s.store.Loading() && !s.servesWhileLoading(cmd) {
+		protocol.WriteError(w, "LOADING", "dataset is still loading")
+		return
+	}
+
 	// Check if we're in snapshot pause for mutating commands
 	if s.isMutatingCommand(cmd.Name) {
-		if s.store.IsSnapshotPaused() {
+		if s.readOnly.Load() {
+			protocol.WriteError(w, "READONLY", "server is read-only")
+			return
+		}
+		if s.store.IsSnapshotPaused() && !s.waitSnapshotPause() {
 			protocol.WriteError(w, "BUSY", "server is busy")
 			return
 		}
+		switch err := s.allowWrite(cc, cmd); err {
+		case nil:
+		case errWriteOverBurst:
+			s.throttledTotal.Inc()
+			protocol.WriteError(w, "TOOLARGE", err.Error())
+			return
+		default:
+			s.throttledTotal.Inc()
+			protocol.WriteError(w, "THROTTLED", err.Error())
+			return
+		}
 	}
 
+	s.dispatch(cc, cmd, w)
+}
+
+// dispatch runs a command's handler
+func (s *Server) dispatch(cc *clientConn, cmd *protocol.Command, w io.Writer) {
 	switch cmd.Name {
 	case "PING":
 		s.handlePing(w)
+	case "HELLO":
+		s.handleHello(cc, cmd, w)
+	case "AUTH":
+		s.handleAuth(cc, cmd, w)
+	case "AUTHCHALLENGE":
+		s.handleAuthChallenge(cc, cmd, w)
+	case "AUTHHMAC":
+		s.handleAuthHMAC(cc, cmd, w)
+	case "SHUTDOWN":
+		s.handleShutdown(cmd, w)
+	case "SYNC":
+		s.handleSync(cc, cmd, w)
+	case "EXPORTHOT":
+		s.handleExportHot(cc, cmd, w)
+	case "DIGEST":
+		s.handleDigest(cmd, w)
+	case "PRIMEFROM":
+		s.handlePrimeFrom(cmd, w)
+	case "WALROTATE":
+		s.handleWALRotate(cmd, w)
+	case "WALSYNC":
+		s.handleWALSync(cmd, w)
+	case "BGSAVE":
+		s.handleBgSave(cmd, w)
+	case "READONLY":
+		s.handleReadOnly(cmd, w)
+	case "CONFIG":
+		s.handleConfig(cmd, w)
 	case "GET":
-		s.handleGet(cmd, w)
+		s.handleGet(cc, cmd, w)
+	case "GETMETA":
+		s.handleGetMeta(cmd, w)
 	case "SET":
-		s.handleSet(cmd, w)
+		s.handleSet(cc, cmd, w)
 	case "DEL":
 		s.handleDel(cmd, w)
+	case "MDEL":
+		s.handleMDel(cmd, w)
+	case "GETDEL":
+		s.handleGetDel(cc, cmd, w)
+	case "GETEX":
+		s.handleGetEx(cc, cmd, w)
+	case "APPEND":
+		s.handleAppend(cc, cmd, w)
+	case "GETRANGE":
+		s.handleGetRange(cc, cmd, w)
+	case "SETRANGE":
+		s.handleSetRange(cc, cmd, w)
+	case "CAS":
+		s.handleCAS(cc, cmd, w)
 	case "EXISTS":
 		s.handleExists(cmd, w)
+	case "MEXISTS":
+		s.handleMExists(cmd, w)
 	case "EXPIRE":
 		s.handleExpire(cmd, w)
 	case "TTL":
@@ -211,23 +765,255 @@ func (s *Server) processCommand(cmd *protocol.Command, w io.Writer) {
 		s.handleIncr(cmd, w, 1)
 	case "DECR":
 		s.handleIncr(cmd, w, -1)
+	case "INCRBYFLOAT":
+		s.handleIncrByFloat(cmd, w)
+	case "HSET":
+		s.handleHSet(cc, cmd, w)
+	case "HGET":
+		s.handleHGet(cmd, w)
+	case "HDEL":
+		s.handleHDel(cmd, w)
+	case "HGETALL":
+		s.handleHGetAll(cmd, w)
+	case "HMGET":
+		s.handleHMGet(cmd, w)
+	case "SADD":
+		s.handleSAdd(cc, cmd, w)
+	case "SREM":
+		s.handleSRem(cmd, w)
+	case "SMEMBERS":
+		s.handleSMembers(cmd, w)
+	case "SISMEMBER":
+		s.handleSIsMember(cmd, w)
+	case "SCARD":
+		s.handleSCard(cmd, w)
+	case "ZADD":
+		s.handleZAdd(cc, cmd, w)
+	case "ZREM":
+		s.handleZRem(cmd, w)
+	case "ZRANGE":
+		s.handleZRange(cmd, w)
+	case "ZRANGEBYSCORE":
+		s.handleZRangeByScore(cmd, w)
+	case "PFADD":
+		s.handlePFAdd(cc, cmd, w)
+	case "PFCOUNT":
+		s.handlePFCount(cmd, w)
+	case "PFMERGE":
+		s.handlePFMerge(cmd, w)
+	case "SUBSCRIBE":
+		s.handleSubscribe(cc, cmd, w)
+	case "UNSUBSCRIBE":
+		s.handleUnsubscribe(cc, cmd, w)
+	case "PUBLISH":
+		s.handlePublish(cmd, w)
 	case "STATS":
 		s.handleStats(cmd, w)
 	case "MGET":
-		s.handleMGet(cmd, w)
+		s.handleMGet(cc, cmd, w)
 	case "MSET":
-		s.handleMSet(cmd, w)
+		s.handleMSet(cc, cmd, w)
+	case "CHECKSET":
+		s.handleCheckSet(cc, cmd, w)
+	case "SWAPPREFIX":
+		s.handleSwapPrefix(cmd, w)
+	case "KEYTEMP":
+		s.handleKeyTemp(cmd, w)
+	case "KEYS":
+		s.handleKeys(cc, cmd, w)
+	case "SCAN":
+		s.handleScan(cc, cmd, w)
+	case "RANDOMKEY":
+		s.handleRandomKey(cmd, w)
+	case "DBSIZE":
+		s.handleDBSize(cmd, w)
+	case "TOUCH":
+		s.handleTouch(cmd, w)
+	case "LATENCY":
+		s.handleLatency(cmd, w)
+	case "INFO":
+		s.handleInfo(cmd, w)
+	case "CLIENT":
+		s.handleClient(cc, cmd, w)
+	case "TENANT":
+		s.handleTenant(cc, cmd, w)
+	case "EVENTS":
+		s.handleEvents(cmd, w)
 	default:
-		protocol.WriteError(w, "BADREQ", "unknown command")
+		s.handleExtension(cc, cmd, w)
 	}
 }
 
-// isMutatingCommand checks if a command is mutating
-func (s *Server) isMutatingCommand(cmd string) bool {
+// isShedExempt reports whether a command bypasses load shedding, so
+// health checks and operators can still reach an overloaded server
+func isShedExempt(cmd string) bool {
 	switch cmd {
-	case "SET", "DEL", "EXPIRE", "INCR", "DECR", "MSET":
+	case "PING", "HELLO", "AUTH", "AUTHCHALLENGE", "AUTHHMAC", "SHUTDOWN":
 		return true
 	default:
 		return false
 	}
 }
+
+// isMutatingCommand checks if a command is mutating
+func (s *Server) isMutatingCommand(cmd string) bool {
+	if spec, ok := command.Lookup(cmd); ok {
+		return spec.Has(command.Write)
+	}
+	ext, ok := extension.Lookup(cmd)
+	return ok && ext.Write
+}
+
+// writeKeys returns the keys modified by a mutating command
+func writeKeys(cmd *protocol.Command) []string {
+	switch cmd.Name {
+	case "MSET":
+		entries, _ := protocol.MSetEntries(cmd.Args)
+		keys := make([]string, len(entries))
+		for i, e := range entries {
+			keys[i] = cmd.Args[e.Index]
+		}
+		return keys
+	case "CHECKSET":
+		writes, _ := protocol.CheckSetWrites(cmd.Args)
+		return pairKeys(writes)
+	case "SWAPPREFIX":
+		return nil // prefixes, not keys
+	case "TOUCH", "MDEL":
+		return cmd.Args
+	}
+	if len(cmd.Args) == 0 {
+		return nil
+	}
+	return cmd.Args[:1]
+}
+
+// keyArgs returns the indexes of a command's arguments that are keys or
+// key prefixes
+func keyArgs(cmd *protocol.Command) []int {
+	var idx []int
+	switch cmd.Name {
+	case "GET", "GETMETA", "SET", "APPEND", "GETRANGE", "SETRANGE", "CAS", "DEL", "GETDEL", "GETEX", "EXISTS", "EXPIRE", "TTL", "INCR", "DECR", "INCRBYFLOAT",
+		"HSET", "HGET", "HDEL", "HGETALL", "HMGET", "SADD", "SREM", "SMEMBERS", "SISMEMBER", "SCARD",
+		"ZADD", "ZREM", "ZRANGE", "ZRANGEBYSCORE", "DIGEST", "PFADD":
+		if len(cmd.Args) > 0 {
+			idx = append(idx, 0)
+		}
+	case "MGET", "MEXISTS", "MDEL", "TOUCH", "SWAPPREFIX", "PFCOUNT", "PFMERGE":
+		for i := range cmd.Args {
+			idx = append(idx, i)
+		}
+	case "MSET":
+		entries, _ := protocol.MSetEntries(cmd.Args)
+		for _, e := range entries {
+			idx = append(idx, e.Index)
+		}
+	case "CHECKSET":
+		for i := 1; i < len(cmd.Args); i += 2 {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// normalizeKeys rewrites a command's keys as key_normalization asks.
+// Extension commands normalize the keys they pass to the store instead.
+func (s *Server) normalizeKeys(cmd *protocol.Command) {
+	for _, i := range keyArgs(cmd) {
+		cmd.Args[i] = s.store.NormalizeKey(cmd.Args[i])
+	}
+}
+
+// touchesReserved reports whether a command names a key under
+// storage.ReservedPrefix, or a prefix whose keys could include one
+func touchesReserved(cmd *protocol.Command) bool {
+	for _, i := range keyArgs(cmd) {
+		arg := cmd.Args[i]
+		if storage.IsReserved(arg) || (cmd.Name == "SWAPPREFIX" && strings.HasPrefix(storage.ReservedPrefix, arg)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pairKeys returns the keys of key/length pairs
+func pairKeys(args []string) []string {
+	var keys []string
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// allowWrite applies the per-connection and per-prefix write rate limits.
+// Each key written consumes one token from the connection's bucket and
+// from that of the longest prefix rule with a write_rate_limit. Tokens are
+// only taken if every bucket has enough. It returns errWriteThrottled, or
+// errWriteOverBurst for writes no bucket state could ever allow.
+func (s *Server) allowWrite(cc *clientConn, cmd *protocol.Command) error {
+	if cc.writeLimiter == nil && len(s.prefixLimiters) == 0 {
+		return nil
+	}
+
+	keys := writeKeys(cmd)
+
+	perPrefix := make(map[string]int)
+	if len(s.prefixLimiters) > 0 {
+		limited := func(rule *config.PrefixRule) bool { return rule.WriteRateLimit > 0 }
+		for _, key := range keys {
+			if rule := s.config.RuleWith(key, limited); rule != nil {
+				perPrefix[rule.Prefix]++
+			}
+		}
+	}
+
+	// Prefix buckets are shared, so lock them in a fixed order
+	prefixes := make([]string, 0, len(perPrefix))
+	for prefix := range perPrefix {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	buckets := make([]*tokenBucket, 0, len(prefixes)+1)
+	counts := make([]int, 0, len(prefixes)+1)
+	for _, prefix := range prefixes {
+		buckets = append(buckets, s.prefixLimiters[prefix])
+		counts = append(counts, perPrefix[prefix])
+	}
+	if cc.writeLimiter != nil {
+		buckets = append(buckets, cc.writeLimiter)
+		counts = append(counts, len(keys))
+	}
+
+	for i, b := range buckets {
+		if !b.fits(counts[i]) {
+			return errWriteOverBurst
+		}
+	}
+	if !allowAll(buckets, counts) {
+		return errWriteThrottled
+	}
+	return nil
+}
+
+// waitSnapshotPause holds a write arriving during a snapshot pause until
+// the pause ends, when snapshot_write_mode is "queue". It reports false,
+// and the write gets ERR BUSY, in "reject" mode, when snapshot_queue_max
+// writes are already waiting, or when the wait times out.
+func (s *Server) waitSnapshotPause() bool {
+	if s.config.SnapshotWriteMode != "queue" {
+		return false
+	}
+	if s.snapshotQueued.Add(1) > int64(s.config.SnapshotQueueMax) {
+		s.snapshotQueued.Add(-1)
+		return false
+	}
+	defer s.snapshotQueued.Add(-1)
+
+	s.snapshotQueuedTotal.Inc()
+	if !s.store.WaitSnapshotPause(s.config.SnapshotQueueTimeout()) {
+		s.snapshotQueueTimeouts.Inc()
+		return false
+	}
+	return true
+}
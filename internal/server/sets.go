@@ -0,0 +1,141 @@
+package server
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+	"github.com/bharatmehan/osprey/internal/storage"
+)
+
+// handleSAdd handles SADD <key> <member>..., replying OK with the set's
+// new version and the number of members added
+func (s *Server) handleSAdd(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) < 2 {
+		protocol.WriteError(w, "BADREQ", "usage: SADD <key> <member>...")
+		return
+	}
+
+	key := cmd.Args[0]
+	added, version, err := s.store.SAdd(key, cmd.Args[1:]...)
+	if err != nil {
+		switch err {
+		case storage.ErrValueTooLarge:
+			protocol.WriteError(w, "TOOLARGE", "value too large")
+		case storage.ErrWrongType:
+			protocol.WriteError(w, "TYPE", err.Error())
+		case storage.ErrKeyInvalid:
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		case storage.ErrTTLTooLong:
+			protocol.WriteError(w, "BADREQ", "TTL exceeds max_ttl_ms")
+		default:
+			protocol.WriteError(w, "BADREQ", err.Error())
+		}
+		return
+	}
+
+	size := 0
+	for _, member := range cmd.Args[1:] {
+		size += len(member)
+	}
+	protocol.WriteAppended(w, version, added, warnFlags(cc, s.checkSoftLimits(key, size))...)
+}
+
+// handleSRem handles SREM <key> <member>..., replying with the number of
+// members removed
+func (s *Server) handleSRem(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) < 2 {
+		protocol.WriteError(w, "BADREQ", "usage: SREM <key> <member>...")
+		return
+	}
+
+	removed, _, err := s.store.SRem(cmd.Args[0], cmd.Args[1:]...)
+	if err != nil {
+		if err == storage.ErrWrongType {
+			protocol.WriteError(w, "TYPE", err.Error())
+		} else if err == storage.ErrKeyInvalid {
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		} else {
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+
+	protocol.WriteInteger(w, int64(removed))
+}
+
+// getSet looks up the set for SMEMBERS, SISMEMBER and SCARD, writing the
+// error reply if there is none. A missing key is reported as a nil entry.
+func (s *Server) getSet(key string, w io.Writer) (*storage.Entry, bool) {
+	entry, err := s.store.GetSet(key)
+	switch err {
+	case nil, storage.ErrKeyNotFound:
+		return entry, true
+	case storage.ErrWrongType:
+		protocol.WriteError(w, "TYPE", err.Error())
+	case storage.ErrKeyInvalid:
+		protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+	default:
+		protocol.WriteError(w, "INTERNAL", err.Error())
+	}
+	return nil, false
+}
+
+// handleSMembers handles SMEMBERS <key>, replying with a MEMBER line for
+// each member, sorted, followed by END. A missing key has no members.
+func (s *Server) handleSMembers(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 1 {
+		protocol.WriteError(w, "BADREQ", "SMEMBERS requires 1 argument")
+		return
+	}
+
+	entry, ok := s.getSet(cmd.Args[0], w)
+	if !ok {
+		return
+	}
+	if entry != nil {
+		for _, member := range storage.SetMembers(entry.Set) {
+			fmt.Fprintf(w, "MEMBER %s\r\n", member)
+		}
+	}
+	fmt.Fprintf(w, "END\r\n")
+}
+
+// handleSIsMember handles SISMEMBER <key> <member>, replying 1 if member
+// is in the set and 0 if not
+func (s *Server) handleSIsMember(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 2 {
+		protocol.WriteError(w, "BADREQ", "SISMEMBER requires 2 arguments")
+		return
+	}
+
+	entry, ok := s.getSet(cmd.Args[0], w)
+	if !ok {
+		return
+	}
+	var isMember int64
+	if entry != nil {
+		if _, exists := entry.Set[cmd.Args[1]]; exists {
+			isMember = 1
+		}
+	}
+	protocol.WriteInteger(w, isMember)
+}
+
+// handleSCard handles SCARD <key>, replying with the number of members
+func (s *Server) handleSCard(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 1 {
+		protocol.WriteError(w, "BADREQ", "SCARD requires 1 argument")
+		return
+	}
+
+	entry, ok := s.getSet(cmd.Args[0], w)
+	if !ok {
+		return
+	}
+	var count int64
+	if entry != nil {
+		count = int64(len(entry.Set))
+	}
+	protocol.WriteInteger(w, count)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+)
+
+// runtimeSetting is a setting CONFIG SET can change while the server runs
+type runtimeSetting struct {
+	get func(s *Server) string
+	set func(s *Server, value string) error
+}
+
+// runtimeSettings are the settings CONFIG SET accepts. Everything else is
+// read once at startup and needs a restart to change.
+var runtimeSettings = map[string]runtimeSetting{
+	"read_only": {
+		get: func(s *Server) string { return strconv.FormatBool(s.readOnly.Load()) },
+		set: func(s *Server, value string) error {
+			on, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("read_only must be true or false")
+			}
+			s.readOnly.Store(on)
+			return nil
+		},
+	},
+	"max_clients": {
+		get: func(s *Server) string { return strconv.Itoa(int(s.maxClients.Load())) },
+		set: func(s *Server, value string) error {
+			n, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("max_clients must be a positive integer")
+			}
+			s.maxClients.Store(int32(n))
+			return nil
+		},
+	},
+	"allow_cidrs": {
+		get: func(s *Server) string { return strings.Join(s.ipFilter.Load().allowCIDRs, ",") },
+		set: func(s *Server, value string) error {
+			filter, err := newIPFilter(splitCIDRs(value), s.ipFilter.Load().denyCIDRs)
+			if err != nil {
+				return err
+			}
+			s.ipFilter.Store(filter)
+			return nil
+		},
+	},
+	"deny_cidrs": {
+		get: func(s *Server) string { return strings.Join(s.ipFilter.Load().denyCIDRs, ",") },
+		set: func(s *Server, value string) error {
+			filter, err := newIPFilter(s.ipFilter.Load().allowCIDRs, splitCIDRs(value))
+			if err != nil {
+				return err
+			}
+			s.ipFilter.Store(filter)
+			return nil
+		},
+	},
+}
+
+// handleConfig handles the CONFIG command:
+//
+//	CONFIG GET <pattern>       → <name>=<value> lines, sorted, then END
+//	CONFIG SET <name> <value>  → OK
+//
+// GET matches setting names against a glob pattern and reports the value
+// in effect, with passwords and access keys masked. Settings in nested
+// tables are named like backup.bucket; prefix rules are not listed.
+func (s *Server) handleConfig(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) == 0 {
+		protocol.WriteError(w, "BADREQ", "CONFIG requires a subcommand")
+		return
+	}
+
+	switch sub := strings.ToUpper(cmd.Args[0]); sub {
+	case "GET":
+		if len(cmd.Args) != 2 {
+			protocol.WriteError(w, "BADREQ", "CONFIG GET requires 1 argument")
+			return
+		}
+		if _, err := path.Match(cmd.Args[1], ""); err != nil {
+			protocol.WriteError(w, "BADREQ", "invalid pattern")
+			return
+		}
+
+		settings := s.configValues()
+		names := make([]string, 0, len(settings))
+		for name := range settings {
+			if ok, _ := path.Match(cmd.Args[1], name); ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s=%s\r\n", name, settings[name])
+		}
+		fmt.Fprintf(w, "END\r\n")
+
+	case "SET":
+		if len(cmd.Args) != 3 {
+			protocol.WriteError(w, "BADREQ", "CONFIG SET requires 2 arguments")
+			return
+		}
+		setting, ok := runtimeSettings[strings.ToLower(cmd.Args[1])]
+		if !ok {
+			protocol.WriteError(w, "BADREQ", fmt.Sprintf("%s can't be changed at runtime", cmd.Args[1]))
+			return
+		}
+		if err := setting.set(s, cmd.Args[2]); err != nil {
+			protocol.WriteError(w, "BADREQ", err.Error())
+			return
+		}
+		protocol.WriteOK(w)
+
+	default:
+		protocol.WriteError(w, "BADREQ", fmt.Sprintf("unknown CONFIG subcommand: %s", sub))
+	}
+}
+
+// configValues returns every setting by its name in the config file, as
+// CONFIG GET reports it
+func (s *Server) configValues() map[string]string {
+	values := make(map[string]string)
+	addConfigValues(values, "", reflect.ValueOf(s.config).Elem())
+	for name, setting := range runtimeSettings {
+		values[name] = setting.get(s)
+	}
+	return values
+}
+
+// addConfigValues adds the fields of the struct v to values, prefixing
+// their names with prefix
+func addConfigValues(values map[string]string, prefix string, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("toml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		name = prefix + name
+		field := v.Field(i)
+
+		switch field.Kind() {
+		case reflect.Struct:
+			addConfigValues(values, name+".", field)
+		case reflect.Slice:
+			if strs, ok := field.Interface().([]string); ok {
+				values[name] = strings.Join(strs, ",")
+			}
+		default:
+			value := fmt.Sprint(field.Interface())
+			if isSecretSetting(name) && value != "" {
+				value = "********"
+			}
+			values[name] = value
+		}
+	}
+}
+
+// isSecretSetting reports whether CONFIG GET masks a setting's value
+func isSecretSetting(name string) bool {
+	return strings.HasSuffix(name, "password") || strings.HasSuffix(name, "_key")
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+	"github.com/bharatmehan/osprey/internal/storage"
+)
+
+// Soft limits a write can cross, as named in WARN= reply flags
+const (
+	warnKeySize   = "key_size"   // key longer than soft_max_key_bytes
+	warnValueSize = "value_size" // value longer than soft_max_value_bytes
+	warnMemory    = "memory"     // memory use past memory_warn_ratio
+)
+
+// softLimitLogInterval spaces out the log lines for each soft limit, so
+// a client writing past one doesn't flood the log
+const softLimitLogInterval = 10 * time.Second
+
+// softLimits tracks soft limit warnings for logging
+type softLimits struct {
+	lastLogged map[string]*atomic.Int64 // per limit, Unix ms
+	suppressed map[string]*atomic.Int64 // per limit, warnings not logged since
+}
+
+func newSoftLimits() *softLimits {
+	l := &softLimits{
+		lastLogged: make(map[string]*atomic.Int64),
+		suppressed: make(map[string]*atomic.Int64),
+	}
+	for _, reason := range []string{warnKeySize, warnValueSize, warnMemory} {
+		l.lastLogged[reason] = new(atomic.Int64)
+		l.suppressed[reason] = new(atomic.Int64)
+	}
+	return l
+}
+
+// log logs a warning for reason unless one was logged within
+// softLimitLogInterval, counting it as suppressed instead
+func (l *softLimits) log(reason, format string, args ...any) {
+	now := time.Now().UnixMilli()
+	last := l.lastLogged[reason].Load()
+	if now-last < softLimitLogInterval.Milliseconds() || !l.lastLogged[reason].CompareAndSwap(last, now) {
+		l.suppressed[reason].Add(1)
+		return
+	}
+	if n := l.suppressed[reason].Swap(0); n > 0 {
+		format += " (%d more not logged)"
+		args = append(args, n)
+	}
+	log.Printf("WARNING: "+format, args...)
+}
+
+// checkSoftLimits returns the soft limits a write of a valueLen-byte
+// value to key crossed, logging and counting each
+func (s *Server) checkSoftLimits(key string, valueLen int) []string {
+	var reasons []string
+	if limit := s.config.SoftMaxKeyBytes; limit > 0 && len(key) > limit {
+		reasons = append(reasons, warnKeySize)
+		s.softLimits.log(warnKeySize, "key %q is %d bytes, over soft_max_key_bytes (%d)", key, len(key), limit)
+	}
+	if limit := s.config.SoftMaxValueBytes; limit > 0 && valueLen > limit {
+		reasons = append(reasons, warnValueSize)
+		s.softLimits.log(warnValueSize, "value of %q is %d bytes, over soft_max_value_bytes (%d)", key, valueLen, limit)
+	}
+	if level := s.store.MemoryPressure(); level != storage.MemoryOK {
+		reasons = append(reasons, warnMemory)
+		s.softLimits.log(warnMemory, "write to %q with memory pressure %s", key, level)
+	}
+	if len(reasons) > 0 {
+		s.softLimitWarnings.Inc()
+	}
+	return reasons
+}
+
+// warnFlags returns the flags for the reply to a write on cc that crossed
+// the soft limits in reasons: none unless cc sent HELLO WARNINGS ON
+func warnFlags(cc *clientConn, reasons []string) []string {
+	if !cc.warnings || len(reasons) == 0 {
+		return nil
+	}
+	return []string{protocol.WarnFlag(reasons)}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/metrics"
+	"github.com/bharatmehan/osprey/internal/protocol"
+	"github.com/bharatmehan/osprey/internal/storage"
+	"github.com/bharatmehan/osprey/pkg/command"
+)
+
+// tenant is a configured user, with its namespace normalized as keys are
+type tenant struct {
+	password  string
+	namespace string
+	ops       tenantOps
+}
+
+// tenantOps counts a tenant's commands, in total and per second
+type tenantOps struct {
+	mu     sync.Mutex
+	total  uint64
+	second int64  // the Unix second being counted
+	cur    uint64 // commands in second
+	last   uint64 // commands in the second before
+}
+
+// add counts a command sent in the Unix second now
+func (o *tenantOps) add(now int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.roll(now)
+	o.total++
+	o.cur++
+}
+
+// read returns the commands counted in total and in the last complete
+// second before now
+func (o *tenantOps) read(now int64) (total, perSec uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.roll(now)
+	return o.total, o.last
+}
+
+func (o *tenantOps) roll(now int64) {
+	switch {
+	case now == o.second:
+	case now == o.second+1:
+		o.second, o.cur, o.last = now, 0, o.cur
+	default:
+		o.second, o.cur, o.last = now, 0, 0
+	}
+}
+
+// unauthenticatedCommands are served before AUTH when users are configured
+var unauthenticatedCommands = map[string]bool{
+	"PING": true, "HELLO": true, "AUTH": true, "AUTHCHALLENGE": true, "AUTHHMAC": true,
+}
+
+// unscopedCommands see the whole keyspace or server-wide state and can't
+// be limited to a namespace, so tenants can't run them
+var unscopedCommands = map[string]bool{
+	"RANDOMKEY": true, "DBSIZE": true, "KEYTEMP": true, "EVENTS": true,
+	"INFO": true, "STATS": true, "LATENCY": true,
+}
+
+// parseUsers checks the configured users and returns them by name
+func parseUsers(users []config.User, normalize func(string) string) (map[string]*tenant, error) {
+	tenants := make(map[string]*tenant, len(users))
+	for _, u := range users {
+		if u.Name == "" || u.Password == "" {
+			return nil, fmt.Errorf("user %q needs a name and a password", u.Name)
+		}
+		if _, ok := tenants[u.Name]; ok {
+			return nil, fmt.Errorf("user %q is configured twice", u.Name)
+		}
+		ns := u.Namespace
+		if ns == "" {
+			ns = u.Name + ":"
+		}
+		if strings.ContainsAny(ns, "*?[]\\ \t\r\n") {
+			return nil, fmt.Errorf("namespace %q of user %q contains a space or glob character", ns, u.Name)
+		}
+		if storage.IsReserved(ns) || strings.HasPrefix(storage.ReservedPrefix, ns) {
+			return nil, fmt.Errorf("namespace %q of user %q overlaps reserved keys", ns, u.Name)
+		}
+		ns = normalize(ns)
+		for name, t := range tenants {
+			if strings.HasPrefix(ns, t.namespace) || strings.HasPrefix(t.namespace, ns) {
+				return nil, fmt.Errorf("namespaces of users %q and %q overlap", name, u.Name)
+			}
+		}
+		tenants[u.Name] = &tenant{password: u.Password, namespace: ns}
+	}
+	return tenants, nil
+}
+
+// trackTenantUsage has the store count keys and bytes per namespace
+func (s *Server) trackTenantUsage() {
+	namespaces := make([]string, 0, len(s.tenants))
+	for _, t := range s.tenants {
+		namespaces = append(namespaces, t.namespace)
+	}
+	s.store.TrackUsage(namespaces...)
+}
+
+// registerTenantMetrics registers keys, bytes and commands per user
+func (s *Server) registerTenantMetrics(r *metrics.Registry) {
+	byUser := func(field func(storage.Usage) int64) func() map[string]int64 {
+		return func() map[string]int64 {
+			usage := s.store.PrefixUsage()
+			values := make(map[string]int64, len(s.tenants))
+			for name, t := range s.tenants {
+				values[name] = field(usage[t.namespace])
+			}
+			return values
+		}
+	}
+	r.GaugeFamily("tenant_keys", "tenants", "Keys in each user's namespace", "user",
+		byUser(func(u storage.Usage) int64 { return u.Keys }))
+	r.GaugeFamily("tenant_bytes", "tenants", "Bytes of keys and values in each user's namespace", "user",
+		byUser(func(u storage.Usage) int64 { return u.Bytes }))
+	r.CounterFamily("tenant_commands", "tenants", "Commands sent by each user", "user", func() map[string]int64 {
+		now := time.Now().Unix()
+		values := make(map[string]int64, len(s.tenants))
+		for name, t := range s.tenants {
+			total, _ := t.ops.read(now)
+			values[name] = int64(total)
+		}
+		return values
+	})
+}
+
+// handleTenant handles the TENANT command: TENANT STATS lists each user's
+// usage, sorted by name, followed by END:
+//
+//	user=<name> namespace=<ns> keys=<n> bytes=<n> commands=<n> ops_per_sec=<n>
+//
+// Users only see their own line.
+func (s *Server) handleTenant(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 1 || strings.ToUpper(cmd.Args[0]) != "STATS" {
+		protocol.WriteError(w, "BADREQ", "usage: TENANT STATS")
+		return
+	}
+
+	names := make([]string, 0, len(s.tenants))
+	for name := range s.tenants {
+		if cc.user == "" || cc.user == name {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	usage := s.store.PrefixUsage()
+	now := time.Now().Unix()
+	for _, name := range names {
+		t := s.tenants[name]
+		u := usage[t.namespace]
+		total, perSec := t.ops.read(now)
+		fmt.Fprintf(w, "user=%s namespace=%s keys=%d bytes=%d commands=%d ops_per_sec=%d\r\n",
+			name, t.namespace, u.Keys, u.Bytes, total, perSec)
+	}
+	fmt.Fprintf(w, "END\r\n")
+}
+
+// authenticated reports whether a connection may use keys: it has sent
+// AUTH as a user or is an admin, or no users are configured
+func (s *Server) authenticated(cc *clientConn) bool {
+	return len(s.tenants) == 0 || cc.user != "" || s.isAdmin(cc)
+}
+
+// scopeKeys moves a tenant's command into its namespace: keys and
+// prefixes get the namespace prepended, and KEYS and SCAN only match
+// keys under it. It returns false for commands tenants can't run.
+func scopeKeys(cmd *protocol.Command, namespace string) bool {
+	if unscopedCommands[cmd.Name] {
+		return false
+	}
+	if _, ok := command.Lookup(cmd.Name); !ok {
+		// Extension commands reach the store directly
+		return false
+	}
+
+	for _, i := range keyArgs(cmd) {
+		cmd.Args[i] = namespace + cmd.Args[i]
+	}
+	switch cmd.Name {
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		// Channels are scoped like keys, so tenants can't hear each other
+		for i := range cmd.Args {
+			cmd.Args[i] = namespace + cmd.Args[i]
+		}
+	case "PUBLISH":
+		if len(cmd.Args) > 0 {
+			cmd.Args[0] = namespace + cmd.Args[0]
+		}
+	case "KEYS":
+		if len(cmd.Args) > 0 {
+			cmd.Args[0] = namespace + cmd.Args[0]
+		}
+	case "SCAN":
+		matched := false
+		for i := 1; i+1 < len(cmd.Args); i += 2 {
+			if strings.ToUpper(cmd.Args[i]) == "MATCH" {
+				cmd.Args[i+1] = namespace + cmd.Args[i+1]
+				matched = true
+			}
+		}
+		if !matched && len(cmd.Args) > 0 {
+			cmd.Args = append(cmd.Args, "MATCH", namespace+"*")
+		}
+	}
+	return true
+}
+
+// clientKey returns key as the connection named it, without its namespace
+func (cc *clientConn) clientKey(key string) string {
+	return strings.TrimPrefix(key, cc.namespace)
+}
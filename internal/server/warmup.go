@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+	"github.com/bharatmehan/osprey/internal/storage"
+)
+
+// warmup replays the write commands in path, written in the wire
+// protocol (SET with its payload, MSET, DEL, EXPIRE, INCR, DECR) one per
+// line, so a new instance starts with a hot dataset. Blank lines are
+// skipped. It only runs on an empty store:
+// once the data has been written it is recovered from disk like any
+// other, and replaying the file again would undo later writes.
+func (s *Server) warmup(path string) error {
+	// Whether the store is empty is only known once every key is loaded
+	s.store.WaitLoaded()
+	if n := s.store.Len(); n > 0 {
+		log.Printf("Skipping warmup from %s: store already has %d keys", path, n)
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	start := time.Now()
+	count, err := s.replay(f)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Warmed up from %s: %d commands, %d keys in %v", path, count, s.store.Len(), time.Since(start))
+	return nil
+}
+
+// replay runs the write commands read from r, as warmup_file and PRIMEFROM
+// supply them, and returns how many it ran. It stops at the first command
+// that isn't a write or fails.
+func (s *Server) replay(r io.Reader) (int, error) {
+	parser := protocol.NewParser(bufio.NewReader(r))
+	cc := &clientConn{admin: true, priority: priorityHigh, createdAt: time.Now()}
+	var reply bytes.Buffer
+
+	count := 0
+	for {
+		cmd, err := parser.ParseCommand()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err == protocol.ErrInvalidCommand {
+			continue // blank line
+		}
+		count++
+		if err != nil {
+			return count, fmt.Errorf("command %d: %w", count, err)
+		}
+		if !s.isMutatingCommand(cmd.Name) {
+			return count, fmt.Errorf("command %d: %s is not a write command", count, cmd.Name)
+		}
+
+		reply.Reset()
+		s.normalizeKeys(cmd)
+		if touchesReserved(cmd) {
+			return count, fmt.Errorf("command %d: %w", count, storage.ErrReservedKey)
+		}
+		s.dispatch(cc, cmd, &reply)
+		if line := reply.String(); strings.HasPrefix(line, "ERR ") {
+			return count, fmt.Errorf("command %d: %s", count, strings.TrimSpace(line))
+		}
+	}
+}
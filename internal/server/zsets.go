@@ -0,0 +1,185 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/bharatmehan/osprey/internal/protocol"
+	"github.com/bharatmehan/osprey/internal/storage"
+)
+
+// handleZAdd handles ZADD <key> <score> <member> [<score> <member>...],
+// replying OK with the sorted set's new version and the number of members
+// added
+func (s *Server) handleZAdd(cc *clientConn, cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) < 3 || len(cmd.Args)%2 != 1 {
+		protocol.WriteError(w, "BADREQ", "usage: ZADD <key> <score> <member> [<score> <member>...]")
+		return
+	}
+
+	key := cmd.Args[0]
+	members := make([]storage.ZMember, 0, len(cmd.Args)/2)
+	size := 0
+	for i := 1; i < len(cmd.Args); i += 2 {
+		score, err := strconv.ParseFloat(cmd.Args[i], 64)
+		if err != nil || math.IsNaN(score) || math.IsInf(score, 0) {
+			protocol.WriteError(w, "BADREQ", "score is not a finite number")
+			return
+		}
+		members = append(members, storage.ZMember{Member: cmd.Args[i+1], Score: score})
+		size += len(cmd.Args[i+1]) + 8
+	}
+
+	added, version, err := s.store.ZAdd(key, members)
+	if err != nil {
+		switch err {
+		case storage.ErrValueTooLarge:
+			protocol.WriteError(w, "TOOLARGE", "value too large")
+		case storage.ErrWrongType:
+			protocol.WriteError(w, "TYPE", err.Error())
+		case storage.ErrKeyInvalid:
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		case storage.ErrTTLTooLong:
+			protocol.WriteError(w, "BADREQ", "TTL exceeds max_ttl_ms")
+		default:
+			protocol.WriteError(w, "BADREQ", err.Error())
+		}
+		return
+	}
+
+	protocol.WriteAppended(w, version, added, warnFlags(cc, s.checkSoftLimits(key, size))...)
+}
+
+// handleZRem handles ZREM <key> <member>..., replying with the number of
+// members removed
+func (s *Server) handleZRem(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) < 2 {
+		protocol.WriteError(w, "BADREQ", "usage: ZREM <key> <member>...")
+		return
+	}
+
+	removed, _, err := s.store.ZRem(cmd.Args[0], cmd.Args[1:]...)
+	if err != nil {
+		if err == storage.ErrWrongType {
+			protocol.WriteError(w, "TYPE", err.Error())
+		} else if err == storage.ErrKeyInvalid {
+			protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+		} else {
+			protocol.WriteError(w, "INTERNAL", err.Error())
+		}
+		return
+	}
+
+	protocol.WriteInteger(w, int64(removed))
+}
+
+// getSortedSet looks up the sorted set for ZRANGE and ZRANGEBYSCORE,
+// writing the error reply if there is none. A missing key is reported as
+// a nil entry.
+func (s *Server) getSortedSet(key string, w io.Writer) (*storage.Entry, bool) {
+	entry, err := s.store.GetSortedSet(key)
+	switch err {
+	case nil, storage.ErrKeyNotFound:
+		return entry, true
+	case storage.ErrWrongType:
+		protocol.WriteError(w, "TYPE", err.Error())
+	case storage.ErrKeyInvalid:
+		protocol.WriteError(w, "BADREQ", "key contains invalid characters")
+	default:
+		protocol.WriteError(w, "INTERNAL", err.Error())
+	}
+	return nil, false
+}
+
+// writeZMembers writes a MEMBER line with each member's score, followed
+// by END
+func writeZMembers(w io.Writer, members []storage.ZMember) {
+	for _, m := range members {
+		fmt.Fprintf(w, "MEMBER %s %s\r\n", m.Member, storage.FormatFloat(m.Score))
+	}
+	fmt.Fprintf(w, "END\r\n")
+}
+
+// handleZRange handles ZRANGE <key> <start> <stop>, replying with the
+// members ranked start to stop, lowest score first. Negative ranks count
+// back from the highest. A missing key has no members.
+func (s *Server) handleZRange(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 3 {
+		protocol.WriteError(w, "BADREQ", "ZRANGE requires 3 arguments")
+		return
+	}
+
+	start, err1 := strconv.ParseInt(cmd.Args[1], 10, 64)
+	stop, err2 := strconv.ParseInt(cmd.Args[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		protocol.WriteError(w, "BADREQ", "invalid rank")
+		return
+	}
+
+	entry, ok := s.getSortedSet(cmd.Args[0], w)
+	if !ok {
+		return
+	}
+	var members []storage.ZMember
+	if entry != nil {
+		members = entry.ZSet.Range(start, stop)
+	}
+	writeZMembers(w, members)
+}
+
+// handleZRangeByScore handles ZRANGEBYSCORE <key> <min> <max> [LIMIT
+// <offset> <count>], replying with the members scored min to max,
+// inclusive, lowest first. min and max may be -inf and +inf; a negative
+// count returns every member past offset.
+func (s *Server) handleZRangeByScore(cmd *protocol.Command, w io.Writer) {
+	if len(cmd.Args) != 3 && len(cmd.Args) != 6 {
+		protocol.WriteError(w, "BADREQ", "usage: ZRANGEBYSCORE <key> <min> <max> [LIMIT <offset> <count>]")
+		return
+	}
+
+	minScore, err1 := strconv.ParseFloat(cmd.Args[1], 64)
+	maxScore, err2 := strconv.ParseFloat(cmd.Args[2], 64)
+	if err1 != nil || err2 != nil || math.IsNaN(minScore) || math.IsNaN(maxScore) {
+		protocol.WriteError(w, "BADREQ", "invalid score")
+		return
+	}
+	offset, count := int64(0), int64(-1)
+	if len(cmd.Args) == 6 {
+		if !strings.EqualFold(cmd.Args[3], "LIMIT") {
+			protocol.WriteError(w, "BADREQ", "unknown option: "+cmd.Args[3])
+			return
+		}
+		var err error
+		offset, err = strconv.ParseInt(cmd.Args[4], 10, 64)
+		if err != nil || offset < 0 {
+			protocol.WriteError(w, "BADREQ", "invalid offset")
+			return
+		}
+		count, err = strconv.ParseInt(cmd.Args[5], 10, 64)
+		if err != nil {
+			protocol.WriteError(w, "BADREQ", "invalid count")
+			return
+		}
+	}
+
+	entry, ok := s.getSortedSet(cmd.Args[0], w)
+	if !ok {
+		return
+	}
+	var members []storage.ZMember
+	if entry != nil {
+		members = entry.ZSet.RangeByScore(minScore, maxScore)
+	}
+	if offset >= int64(len(members)) {
+		members = nil
+	} else {
+		members = members[offset:]
+		if count >= 0 && count < int64(len(members)) {
+			members = members[:count]
+		}
+	}
+	writeZMembers(w, members)
+}
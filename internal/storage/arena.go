@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"github.com/bharatmehan/osprey/internal/metrics"
+)
+
+// Value storage modes (value_storage)
+const (
+	ValueStorageHeap  = "heap"
+	ValueStorageArena = "arena"
+)
+
+// arenaMaxValueDivisor keeps values larger than a quarter of a slab as
+// ordinary heap allocations, so one value can't waste most of a slab
+const arenaMaxValueDivisor = 4
+
+// slab is one large allocation values are packed into. Slabs hold no
+// pointers, so the GC never scans their contents, and a few large slabs
+// replace what would otherwise be millions of small allocations.
+type slab struct {
+	buf []byte
+}
+
+// valueArena packs stored values into slabs (experimental, value_storage
+// = "arena"). Values are appended to the active slab and never modified,
+// so readers can keep the slices handed out by Get. Space freed by
+// overwrites and deletes is reclaimed by compact, which moves the live
+// values out of mostly empty slabs; the garbage collector frees a slab
+// once no entry or reader refers to it. Callers hold Store.mu for writing.
+type valueArena struct {
+	slabBytes int
+	active    *slab
+	slabs     map[*slab]struct{}
+
+	compactions    *metrics.Counter
+	relocatedBytes *metrics.Counter
+}
+
+func newValueArena(slabBytes int, r *metrics.Registry) *valueArena {
+	return &valueArena{
+		slabBytes:      slabBytes,
+		slabs:          make(map[*slab]struct{}),
+		compactions:    r.Counter("arena_compactions", "memory", "Arena compactions that moved values"),
+		relocatedBytes: r.Counter("arena_relocated_bytes", "memory", "Bytes moved by arena compaction"),
+	}
+}
+
+// store copies v into the arena and returns the copy and the slab holding
+// it. Large and empty values are returned unchanged with a nil slab.
+func (a *valueArena) store(v []byte) ([]byte, *slab) {
+	if len(v) == 0 || len(v) > a.slabBytes/arenaMaxValueDivisor {
+		return v, nil
+	}
+
+	if a.active == nil || len(a.active.buf)+len(v) > cap(a.active.buf) {
+		a.active = &slab{buf: make([]byte, 0, a.slabBytes)}
+		a.slabs[a.active] = struct{}{}
+	}
+
+	off := len(a.active.buf)
+	a.active.buf = append(a.active.buf, v...)
+	return a.active.buf[off : off+len(v) : off+len(v)], a.active
+}
+
+// arenaValue moves value into the arena, if there is one, and returns the
+// entry fields to store. Callers hold s.mu for writing.
+func (s *Store) arenaValue(value []byte) ([]byte, *slab) {
+	if s.arena == nil {
+		return value, nil
+	}
+	return s.arena.store(value)
+}
+
+// CompactArena moves live values out of slabs that are less than half
+// full so those slabs can be freed, and returns the bytes moved. Entries
+// are rewritten copy-on-write, so readers holding the old values are
+// unaffected. The store is locked for a scan of every key.
+func (s *Store) CompactArena() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.arena
+	if a == nil {
+		return 0
+	}
+
+	live := make(map[*slab]int, len(a.slabs))
+	for _, entry := range s.data {
+		if entry.slab != nil {
+			live[entry.slab] += len(entry.Value)
+		}
+	}
+
+	victims := make(map[*slab]struct{})
+	for sl := range a.slabs {
+		if sl != a.active && live[sl] < cap(sl.buf)/2 {
+			victims[sl] = struct{}{}
+			delete(a.slabs, sl)
+		}
+	}
+	if len(victims) == 0 {
+		return 0
+	}
+
+	moved := 0
+	for key, entry := range s.data {
+		if _, ok := victims[entry.slab]; !ok {
+			continue
+		}
+		updated := entry.clone()
+		updated.Value, updated.slab = a.store(entry.Value)
+		s.put(key, updated)
+		moved += len(entry.Value)
+	}
+
+	a.compactions.Inc()
+	a.relocatedBytes.Add(uint64(moved))
+	return moved
+}
+
+// registerArenaMetrics registers the arena's slab usage
+func (s *Store) registerArenaMetrics() {
+	s.metrics.GaugeFunc("arena_slabs", "memory", "Slabs held by the value arena", func() int64 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return int64(len(s.arena.slabs))
+	})
+	s.metrics.GaugeFunc("arena_bytes", "memory", "Bytes allocated for arena slabs", func() int64 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		var total int64
+		for sl := range s.arena.slabs {
+			total += int64(cap(sl.buf))
+		}
+		return total
+	})
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newArenaStore(slabBytes int) *Store {
+	cfg := config.DefaultConfig()
+	cfg.ValueStorage = ValueStorageArena
+	cfg.ArenaSlabBytes = slabBytes
+	return New(cfg)
+}
+
+func TestStore_Arena(t *testing.T) {
+	store := newArenaStore(1024)
+
+	// Values are copied in, so the caller's buffer can be reused
+	buf := []byte("value")
+	_, err := store.Set("key", buf, SetOptions{})
+	require.NoError(t, err)
+	copy(buf, "XXXXX")
+
+	entry, err := store.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), entry.Value)
+	assert.NotNil(t, entry.slab)
+
+	// Large values stay on the heap
+	_, err = store.Set("big", make([]byte, 512), SetOptions{})
+	require.NoError(t, err)
+	entry, err = store.Get("big")
+	require.NoError(t, err)
+	assert.Nil(t, entry.slab)
+
+	// Readers can't append into neighbouring values
+	entry, _ = store.Get("key")
+	_ = append(entry.Value, 'Z')
+	_, err = store.Incr("counter", 7)
+	require.NoError(t, err)
+	entry, _ = store.Get("counter")
+	assert.Equal(t, []byte("7"), entry.Value)
+}
+
+func TestStore_CompactArena(t *testing.T) {
+	// 32 values per slab
+	store := newArenaStore(256)
+
+	for i := 0; i < 100; i++ {
+		_, err := store.Set(fmt.Sprintf("key%02d", i), []byte(fmt.Sprintf("value-%02d", i)), SetOptions{})
+		require.NoError(t, err)
+	}
+	before, err := store.Get("key05")
+	require.NoError(t, err)
+
+	// Nothing to reclaim while every value is live
+	assert.Equal(t, 0, store.CompactArena())
+
+	for i := 10; i < 100; i++ {
+		store.Delete(fmt.Sprintf("key%02d", i))
+	}
+	assert.Equal(t, 10*len("value-00"), store.CompactArena())
+	assert.Equal(t, "1", store.GetStats()["arena_compactions"])
+	assert.Equal(t, "1", store.GetStats()["arena_slabs"])
+
+	for i := 0; i < 10; i++ {
+		entry, err := store.Get(fmt.Sprintf("key%02d", i))
+		require.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value-%02d", i)), entry.Value)
+	}
+	// A value read before compaction is unchanged
+	assert.Equal(t, []byte("value-05"), before.Value)
+}
+
+func TestPersistentStore_ArenaRecovery(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.ValueStorage = ValueStorageArena
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, err = ps.Set("key", []byte("value"), SetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	entry, err := ps.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), entry.Value)
+	assert.NotNil(t, entry.slab)
+
+	cfg.ValueStorage = "mmap"
+	_, err = NewPersistentStore(cfg)
+	assert.Error(t, err)
+}
+
+func benchmarkStoreSet(b *testing.B, valueStorage string) {
+	cfg := config.DefaultConfig()
+	cfg.ValueStorage = valueStorage
+	store := New(cfg)
+	value := make([]byte, 100)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Like the server, hand over a freshly read buffer each time
+		store.Set(strconv.Itoa(i%100000), append([]byte(nil), value...), SetOptions{})
+	}
+}
+
+func BenchmarkStore_Set_Heap(b *testing.B)  { benchmarkStoreSet(b, ValueStorageHeap) }
+func BenchmarkStore_Set_Arena(b *testing.B) { benchmarkStoreSet(b, ValueStorageArena) }
+
+// benchmarkStoreGC measures a full GC with a million 100-byte values loaded
+func benchmarkStoreGC(b *testing.B, valueStorage string) {
+	cfg := config.DefaultConfig()
+	cfg.ValueStorage = valueStorage
+	store := New(cfg)
+	value := make([]byte, 100)
+	for i := 0; i < 1000000; i++ {
+		store.Set(strconv.Itoa(i), append([]byte(nil), value...), SetOptions{})
+	}
+
+	b.ResetTimer()
+	var total time.Duration
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		runtime.GC()
+		total += time.Since(start)
+	}
+	b.ReportMetric(float64(total.Microseconds())/float64(b.N), "gc-us/op")
+	runtime.KeepAlive(store)
+}
+
+func BenchmarkStore_GC_Heap(b *testing.B)  { benchmarkStoreGC(b, ValueStorageHeap) }
+func BenchmarkStore_GC_Arena(b *testing.B) { benchmarkStoreGC(b, ValueStorageArena) }
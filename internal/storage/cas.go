@@ -0,0 +1,55 @@
+package storage
+
+import "bytes"
+
+// CompareAndSwap replaces the value of key with value if its current
+// value is expected, keeping its expiry. It fails with ErrKeyNotFound if
+// the key doesn't exist and ErrValueMismatch if its value differs.
+func (s *Store) CompareAndSwap(key string, expected, value []byte) (uint64, error) {
+	if err := s.validateWrite(key, value); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.data[key]
+	if !exists || entry.IsExpired() {
+		return 0, ErrKeyNotFound
+	}
+	if !entry.isString() {
+		return 0, ErrWrongType
+	}
+	if !bytes.Equal(entry.Value, expected) {
+		return 0, ErrValueMismatch
+	}
+	return s.setLocked(key, value, casOptions(entry))
+}
+
+// CompareAndSwap is Store.CompareAndSwap with WAL persistence
+func (ps *PersistentStore) CompareAndSwap(key string, expected, value []byte) (uint64, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	entry, err := ps.Store.peek(key)
+	if err != nil {
+		return 0, err
+	}
+	if !entry.isString() {
+		return 0, ErrWrongType
+	}
+	if !bytes.Equal(entry.Value, expected) {
+		return 0, ErrValueMismatch
+	}
+	return ps.set(key, value, casOptions(entry))
+}
+
+// casOptions returns the options that write a swapped value over entry:
+// only at its version, and with its expiry
+func casOptions(entry *Entry) SetOptions {
+	opts := SetOptions{XX: true, CheckVersion: true, Version: entry.Version}
+	if entry.ExpiryMs > 0 {
+		opts.AbsoluteExpiryMs = entry.ExpiryMs
+	}
+	return opts
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_CompareAndSwap(t *testing.T) {
+	store := newTestStore()
+	_, err := store.CompareAndSwap("lock", []byte("free"), []byte("own"))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	_, err = store.Set("lock", []byte("free"), SetOptions{ExpiryMs: 60000})
+	require.NoError(t, err)
+	expiry := store.data["lock"].ExpiryMs
+
+	version, err := store.CompareAndSwap("lock", []byte("free"), []byte("own"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), version)
+	entry, err := store.Get("lock")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("own"), entry.Value)
+	assert.Equal(t, expiry, entry.ExpiryMs)
+
+	_, err = store.CompareAndSwap("lock", []byte("free"), []byte("mine"))
+	assert.ErrorIs(t, err, ErrValueMismatch)
+	_, err = store.CompareAndSwap("lock", []byte("ow"), []byte("mine"))
+	assert.ErrorIs(t, err, ErrValueMismatch)
+}
+
+func TestPersistentStore_CompareAndSwap(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, err = ps.Set("lock", []byte(""), SetOptions{})
+	require.NoError(t, err)
+
+	// An empty value can be expected too
+	version, err := ps.CompareAndSwap("lock", nil, []byte("own"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), version)
+	_, err = ps.CompareAndSwap("lock", nil, []byte("other"))
+	assert.ErrorIs(t, err, ErrValueMismatch)
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	entry, err := ps.Get("lock")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("own"), entry.Value)
+	assert.Equal(t, uint64(2), entry.Version)
+}
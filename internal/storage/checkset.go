@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// VersionCheck is a condition of CheckSet: Key must be at Version, or not
+// exist if Version is 0
+type VersionCheck struct {
+	Key     string
+	Version uint64
+}
+
+// KeyValue is a write applied by CheckSet or SetMany, with Options as
+// for Set
+type KeyValue struct {
+	Key     string
+	Value   []byte
+	Options SetOptions
+}
+
+// CheckFailedError reports the first VersionCheck that didn't hold
+type CheckFailedError struct {
+	Key     string
+	Version uint64 // the key's current version, 0 if it doesn't exist
+}
+
+func (e *CheckFailedError) Error() string {
+	return fmt.Sprintf("version mismatch for %s", e.Key)
+}
+
+func (e *CheckFailedError) Unwrap() error { return ErrVersionMismatch }
+
+// CheckSet applies every write if every check holds, and returns the new
+// version of each write, or 0 for an NX write to a key that exists.
+// Readers see either none or all of the writes.
+func (s *Store) CheckSet(checks []VersionCheck, writes []KeyValue) ([]uint64, error) {
+	for _, w := range writes {
+		if err := s.validateWrite(w.Key, w.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range checks {
+		var current uint64
+		if entry, exists := s.data[c.Key]; exists && !entry.IsExpired() {
+			current = entry.Version
+		}
+		if current != c.Version {
+			return nil, &CheckFailedError{Key: c.Key, Version: current}
+		}
+	}
+
+	// Refused TTLs must fail the whole batch, not a write part way through
+	now := time.Now().UnixMilli()
+	for _, w := range writes {
+		if _, err := s.ttlPolicy(w.Key, w.Options.expiryAt(now), now); err != nil {
+			return nil, err
+		}
+	}
+
+	versions := make([]uint64, len(writes))
+	for i, w := range writes {
+		version, err := s.setLocked(w.Key, w.Value, w.Options)
+		if err == ErrKeyExists {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		versions[i] = version
+	}
+	return versions, nil
+}
+
+// SetMany applies every write, or none of them if any key, value or TTL
+// is refused, and returns the new version of each write. NX writes to
+// keys that exist are skipped, with version 0.
+func (s *Store) SetMany(writes []KeyValue) ([]uint64, error) {
+	return s.CheckSet(nil, writes)
+}
+
+// SetMany is Store.SetMany with the writes logged as one BATCH record
+func (ps *PersistentStore) SetMany(writes []KeyValue) ([]uint64, error) {
+	return ps.CheckSet(nil, writes)
+}
+
+// CheckSet is Store.CheckSet with the writes logged as one BATCH record,
+// so recovery also applies all of them or none
+func (ps *PersistentStore) CheckSet(checks []VersionCheck, writes []KeyValue) ([]uint64, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	prev := make(map[string]*Entry, len(writes))
+	for _, w := range writes {
+		prev[w.Key], _ = ps.Store.peek(w.Key)
+	}
+
+	versions, err := ps.Store.CheckSet(checks, writes)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*WALRecord, 0, len(writes))
+	for i, w := range writes {
+		if versions[i] == 0 || ps.config.EphemeralFor(w.Key) {
+			continue
+		}
+		entry := ps.Store.lookup(w.Key)
+		records = append(records, &WALRecord{
+			Type:      RecordTypeSET,
+			Key:       w.Key,
+			Value:     w.Value,
+			ExpiryMs:  entry.ExpiryMs,
+			Version:   entry.Version,
+			CreatedMs: entry.CreatedMs,
+			UpdatedMs: entry.UpdatedMs,
+		})
+	}
+	if len(records) == 0 {
+		return versions, nil
+	}
+
+	if err := ps.walManager.AppendRecord(&WALRecord{
+		Type:  RecordTypeBATCH,
+		Value: encodeWALBatch(records),
+	}); err != nil {
+		for key, entry := range prev {
+			ps.Store.restore(key, entry)
+		}
+		return nil, fmt.Errorf("WAL write failed: %w", err)
+	}
+
+	return versions, nil
+}
+
+// encodeWALBatch packs records into the value of a BATCH record
+func encodeWALBatch(records []*WALRecord) []byte {
+	var buf bytes.Buffer
+	for _, record := range records {
+		buf.Write(encodeWALRecord(record, WALVersion))
+	}
+	return buf.Bytes()
+}
+
+// decodeWALBatch unpacks the records in a BATCH record's value
+func decodeWALBatch(value []byte) ([]*WALRecord, error) {
+	reader := NewWALReader(bytes.NewReader(value))
+
+	var records []*WALRecord
+	for {
+		record, err := reader.ReadRecord()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// applyBatchRecord applies a BATCH record's SETs during recovery
+func (ps *PersistentStore) applyBatchRecord(record *WALRecord, pass loadPass) error {
+	records, err := decodeWALBatch(record.Value)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if ps.loads(pass, ps.NormalizeKey(r.Key)) {
+			ps.applySetRecord(r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_CheckSet(t *testing.T) {
+	store := newTestStore()
+	_, err := store.Set("a", []byte("1"), SetOptions{})
+	require.NoError(t, err)
+
+	// b must not exist yet
+	versions, err := store.CheckSet(
+		[]VersionCheck{{"a", 1}, {"b", 0}},
+		[]KeyValue{{Key: "a", Value: []byte("2")}, {Key: "b", Value: []byte("x")}})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{2, 1}, versions)
+
+	// A stale version fails the whole transaction
+	_, err = store.CheckSet(
+		[]VersionCheck{{"a", 2}, {"b", 0}},
+		[]KeyValue{{Key: "a", Value: []byte("3")}, {Key: "c", Value: []byte("y")}})
+	var failed *CheckFailedError
+	require.ErrorAs(t, err, &failed)
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+	assert.Equal(t, "b", failed.Key)
+	assert.Equal(t, uint64(1), failed.Version)
+
+	entry, err := store.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), entry.Value)
+	assert.False(t, store.Exists("c"))
+
+	// So does an invalid write, before anything is applied
+	_, err = store.CheckSet(nil, []KeyValue{{Key: "a", Value: []byte("3")}, {Key: "bad key", Value: nil}})
+	assert.ErrorIs(t, err, ErrKeyInvalid)
+	entry, err = store.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), entry.Value)
+}
+
+func TestPersistentStore_CheckSetRecovery(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.SyncPolicy = "always"
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, err = ps.CheckSet([]VersionCheck{{"a", 0}}, []KeyValue{{Key: "a", Value: []byte("1")}, {Key: "b", Value: []byte("2")}})
+	require.NoError(t, err)
+
+	// Replay the BATCH record after a crash
+	ps.walManager.Close()
+	ps.lock.release()
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	for key, value := range map[string]string{"a": "1", "b": "2"} {
+		entry, err := ps.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte(value), entry.Value)
+		assert.Equal(t, uint64(1), entry.Version)
+	}
+}
+
+func TestStore_SetMany(t *testing.T) {
+	store := newTestStore()
+	store.config.MaxValueBytes = 4
+
+	versions, err := store.SetMany([]KeyValue{{Key: "a", Value: []byte("1")}, {Key: "b", Value: []byte("2")}})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 1}, versions)
+
+	// One value over the limit fails the whole batch
+	_, err = store.SetMany([]KeyValue{{Key: "a", Value: []byte("3")}, {Key: "c", Value: []byte("too large")}})
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+	entry, err := store.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), entry.Value)
+	assert.False(t, store.Exists("c"))
+}
+
+func TestStore_SetManyOptions(t *testing.T) {
+	store := newTestStore()
+	_, err := store.Set("a", []byte("old"), SetOptions{})
+	require.NoError(t, err)
+
+	versions, err := store.SetMany([]KeyValue{
+		{Key: "a", Value: []byte("new"), Options: SetOptions{NX: true}},
+		{Key: "b", Value: []byte("1"), Options: SetOptions{ExpiryMs: 60000, NX: true}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{0, 1}, versions, "NX skips keys that exist")
+
+	entry, err := store.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("old"), entry.Value)
+	entry, err = store.Get("b")
+	require.NoError(t, err)
+	assert.Positive(t, entry.ExpiryMs)
+}
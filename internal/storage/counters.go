@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// countersFile keeps the STATS counters across restarts, so dashboards
+// computing rates from deltas don't see them drop to zero
+const countersFile = "COUNTERS.json"
+
+// savedCounters is the content of countersFile. The counters are only
+// trusted after a clean shutdown: the file is marked unclean while the
+// server runs, so after a crash the counters start over in a new epoch.
+type savedCounters struct {
+	Epoch    int64             `json:"epoch"`
+	Clean    bool              `json:"clean"`
+	Counters map[string]uint64 `json:"counters"`
+}
+
+// ResetStats zeroes the counters and histograms and starts a new
+// stats_epoch, so consumers can tell the drop from a counter wrapping
+func (s *Store) ResetStats() int64 {
+	return s.metrics.Reset()
+}
+
+// StatsEpoch identifies the period the counters have been counting up in:
+// it changes whenever they start again from zero
+func (s *Store) StatsEpoch() int64 {
+	return s.metrics.Epoch()
+}
+
+// loadCounters restores the counters saved by a clean shutdown and marks
+// the file unclean until the next one. Counters the server registers later
+// pick up their saved values from the registry.
+func (ps *PersistentStore) loadCounters() error {
+	path := filepath.Join(ps.config.DataDir, countersFile)
+
+	var saved savedCounters
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &saved); err != nil {
+			saved = savedCounters{}
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	if saved.Clean {
+		ps.metrics.Restore(saved.Epoch, saved.Counters)
+	}
+	return ps.writeCounters(false)
+}
+
+// writeCounters saves every registered counter. Histograms are not saved
+// and start empty after a restart.
+func (ps *PersistentStore) writeCounters(clean bool) error {
+	data, err := json.MarshalIndent(savedCounters{
+		Epoch:    ps.metrics.Epoch(),
+		Clean:    clean,
+		Counters: ps.metrics.Counters(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(ps.config.DataDir, countersFile), data)
+}
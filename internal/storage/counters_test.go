@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentStore_CountersSurviveRestart(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	ps.Metrics().Counter("throttled_total", "server", "").Add(7)
+	_, err = ps.Set("key", []byte("value"), SetOptions{})
+	require.NoError(t, err)
+	ps.Get("key")
+	epoch := ps.GetStats()["stats_epoch"]
+	require.NoError(t, ps.Close())
+
+	// A clean shutdown carries the counters and the epoch over
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	stats := ps.GetStats()
+	assert.Equal(t, "1", stats["cmd_set"])
+	assert.Equal(t, "1", stats["cmd_get"])
+	assert.Equal(t, epoch, stats["stats_epoch"])
+	// Counters registered after the restore pick up their saved values
+	assert.Equal(t, uint64(7), ps.Metrics().Counter("throttled_total", "server", "").Value())
+
+	// After a crash they start over in a new epoch
+	ps.walManager.Close()
+	ps.lock.release()
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	stats = ps.GetStats()
+	assert.Equal(t, "0", stats["cmd_set"])
+	assert.NotEqual(t, epoch, stats["stats_epoch"])
+	assert.Equal(t, uint64(0), ps.Metrics().Counter("throttled_total", "server", "").Value())
+}
+
+func TestStore_ResetStats(t *testing.T) {
+	store := newTestStore()
+	store.Set("key", []byte("value"), SetOptions{})
+	before := store.StatsEpoch()
+
+	epoch := store.ResetStats()
+	assert.Greater(t, epoch, before)
+	assert.Equal(t, epoch, store.StatsEpoch())
+	assert.Equal(t, "0", store.GetStats()["cmd_set"])
+
+	// Epochs keep increasing even within the same millisecond
+	assert.Greater(t, store.ResetStats(), epoch)
+}
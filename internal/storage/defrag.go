@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"time"
+)
+
+// mapSlotBytes approximates what one slot of the key map costs once
+// allocated: the string header, the entry pointer and a share of the
+// bucket overhead at Go's average load factor. The map keeps those slots
+// after keys are deleted, so reclaimed memory is reported in these units.
+const mapSlotBytes = 32
+
+// DefragStats describes the work done by map compaction so far
+type DefragStats struct {
+	Runs           uint64
+	ReclaimedSlots uint64
+	ReclaimedBytes uint64 // estimated from mapSlotBytes
+	LastPauseUs    int64
+}
+
+// grew records the size of the key map after an insert. Go maps never
+// shrink, so the largest size since the last rebuild stands in for the
+// map's capacity. Callers hold s.mu.
+func (s *Store) grew() {
+	if n := len(s.data); n > s.peakKeys {
+		s.peakKeys = n
+	}
+}
+
+// Defrag rebuilds the key map when mass deletions have left it mostly
+// empty: when it once held at least defrag_min_keys keys and fewer than
+// defrag_min_ratio of them are still live. Maps with more than
+// defrag_max_keys live keys are left alone to bound the time the store is
+// locked for the copy. Returns the number of slots released.
+func (s *Store) Defrag() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	live, peak := len(s.data), s.peakKeys
+	if peak < s.config.DefragMinKeys || live > s.config.DefragMaxKeys {
+		return 0
+	}
+	if float64(live) >= s.config.DefragMinRatio*float64(peak) {
+		return 0
+	}
+
+	start := time.Now()
+	data := make(map[string]*Entry, live)
+	for key, entry := range s.data {
+		data[key] = entry
+	}
+	s.data = data
+	s.keys = append(make([]string, 0, live), s.keys...)
+	s.peakKeys = live
+
+	reclaimed := peak - live
+	s.stats.DefragRuns.Inc()
+	s.stats.DefragReclaimedSlots.Add(uint64(reclaimed))
+	s.stats.DefragReclaimedBytes.Add(uint64(reclaimed) * mapSlotBytes)
+	s.stats.DefragLastPauseUs.Set(time.Since(start).Microseconds())
+	return reclaimed
+}
+
+// DefragStats returns compaction totals
+func (s *Store) DefragStats() DefragStats {
+	return DefragStats{
+		Runs:           s.stats.DefragRuns.Value(),
+		ReclaimedSlots: s.stats.DefragReclaimedSlots.Value(),
+		ReclaimedBytes: s.stats.DefragReclaimedBytes.Value(),
+		LastPauseUs:    s.stats.DefragLastPauseUs.Value(),
+	}
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"math"
+	"strings"
+)
+
+// FNV-1a, written out so hashing a key or value doesn't allocate
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+func fnvString(h uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime
+	}
+	return h
+}
+
+func fnvBytes(h uint64, b []byte) uint64 {
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= fnvPrime
+	}
+	return h
+}
+
+func fnvUint64(h, v uint64) uint64 {
+	for i := 0; i < 8; i++ {
+		h ^= v & 0xff
+		h *= fnvPrime
+		v >>= 8
+	}
+	return h
+}
+
+// mix64 spreads the bits of an FNV sum, so sums xored together don't
+// cancel out in their low bits
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// entryDigest hashes a key and its value. Fields and members are hashed
+// one by one and xored, so their order doesn't matter. Versions, expiries
+// and timestamps are left out: two stores holding the same values digest
+// the same however the values got there.
+func entryDigest(key string, e *Entry) uint64 {
+	h := fnvString(fnvOffset, key)
+	h = fnvString(h^0xff, e.Type())
+	var fold uint64
+	switch {
+	case e.Hash != nil:
+		for name, value := range e.Hash {
+			fold ^= mix64(fnvBytes(fnvString(fnvOffset, name)^0xff, value))
+		}
+	case e.Set != nil:
+		for member := range e.Set {
+			fold ^= mix64(fnvString(fnvOffset, member))
+		}
+	case e.ZSet != nil:
+		for _, m := range e.ZSet.Members() {
+			fold ^= mix64(fnvUint64(fnvString(fnvOffset, m.Member)^0xff, math.Float64bits(m.Score)))
+		}
+	default:
+		return mix64(fnvBytes(h^0xff, e.Value))
+	}
+	return mix64(fnvUint64(h, fold))
+}
+
+// prefixDigest is the digest of the keys under one of digest_prefixes
+type prefixDigest struct {
+	prefix string
+	Digest
+}
+
+// Digest summarizes a set of keys: the xor of a hash of every key and its
+// value, and the number of keys. Two stores holding the same keys and
+// values have the same digest.
+type Digest struct {
+	Sum  uint64
+	Keys int64
+}
+
+// fold adds entry, stored under key, to the digests it belongs to, or
+// removes it if sign is -1. Server metadata is left out. The caller holds
+// s.mu for writing.
+func (s *Store) fold(key string, entry *Entry, sign int64) {
+	if IsReserved(key) {
+		return
+	}
+	if sign > 0 {
+		entry.digest = entryDigest(key, entry)
+	}
+	s.digest.Sum ^= entry.digest
+	s.digest.Keys += sign
+	for i := range s.digests {
+		d := &s.digests[i]
+		if strings.HasPrefix(key, d.prefix) {
+			d.Sum ^= entry.digest
+			d.Keys += sign
+		}
+	}
+}
+
+// Digest returns the digest of the keys under prefix, or of every key if
+// prefix is empty. The whole keyspace and digest_prefixes are kept up to
+// date as keys change; other prefixes are summed from the stored
+// per-key hashes in one pass over the keys. Keys past their expiry count
+// until they are removed.
+func (s *Store) Digest(prefix string) Digest {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if prefix == "" {
+		return s.digest
+	}
+	for _, d := range s.digests {
+		if d.prefix == prefix {
+			return d.Digest
+		}
+	}
+
+	var d Digest
+	for key, entry := range s.data {
+		if strings.HasPrefix(key, prefix) && !IsReserved(key) {
+			d.Sum ^= entry.digest
+			d.Keys++
+		}
+	}
+	return d
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Digest(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DigestPrefixes = []string{"user:"}
+	a, b := New(cfg), New(cfg)
+
+	assert.Equal(t, Digest{}, a.Digest(""))
+
+	// The same values digest the same, however they were written
+	a.Set("user:1", []byte("alice"), SetOptions{})
+	a.Set("user:2", []byte("bob"), SetOptions{ExpiryMs: 60000})
+	_, _, err := a.HSet("h", []HashField{{"x", []byte("1")}, {"y", []byte("2")}})
+	require.NoError(t, err)
+	_, _, err = a.ZAdd("z", []ZMember{{"m", 1.5}})
+	require.NoError(t, err)
+
+	b.Set("user:2", []byte("old"), SetOptions{})
+	b.Set("user:2", []byte("bob"), SetOptions{})
+	b.Set("user:1", []byte("alice"), SetOptions{})
+	_, _, err = b.HSet("h", []HashField{{"y", []byte("2")}})
+	require.NoError(t, err)
+	_, _, err = b.HSet("h", []HashField{{"x", []byte("1")}})
+	require.NoError(t, err)
+	_, _, err = b.ZAdd("z", []ZMember{{"m", 1.5}})
+	require.NoError(t, err)
+
+	assert.Equal(t, a.Digest(""), b.Digest(""))
+	assert.Equal(t, int64(4), a.Digest("").Keys)
+	assert.Equal(t, a.Digest("user:"), b.Digest("user:"))
+	assert.Equal(t, int64(2), a.Digest("user:").Keys)
+
+	// Any difference in a value shows
+	_, _, err = b.ZAdd("z", []ZMember{{"m", 2}})
+	require.NoError(t, err)
+	assert.NotEqual(t, a.Digest(""), b.Digest(""))
+	assert.Equal(t, a.Digest("user:"), b.Digest("user:"))
+	b.Set("user:3", []byte("carol"), SetOptions{})
+	assert.NotEqual(t, a.Digest("user:"), b.Digest("user:"))
+
+	// Undoing the writes undoes their effect on the digest
+	_, _, err = b.ZAdd("z", []ZMember{{"m", 1.5}})
+	require.NoError(t, err)
+	b.Delete("user:3")
+	assert.Equal(t, a.Digest(""), b.Digest(""))
+
+	// Prefixes outside digest_prefixes are summed on request
+	assert.Equal(t, int64(1), a.Digest("user:1").Keys)
+	assert.Equal(t, a.Digest("user:1"), b.Digest("user:1"))
+	assert.NotEqual(t, a.Digest("user:1"), a.Digest("user:2"))
+	assert.Equal(t, Digest{}, a.Digest("missing:"))
+
+	// A string and a set holding the same bytes differ
+	c, d := New(cfg), New(cfg)
+	c.Set("k", []byte("v"), SetOptions{})
+	_, _, err = d.SAdd("k", "v")
+	require.NoError(t, err)
+	assert.NotEqual(t, c.Digest(""), d.Digest(""))
+}
+
+func TestPersistentStore_DigestRecovery(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, err = ps.Set("a", []byte("1"), SetOptions{})
+	require.NoError(t, err)
+	_, _, err = ps.SAdd("s", "x", "y")
+	require.NoError(t, err)
+	require.NoError(t, ps.Snapshot())
+	_, _, err = ps.SRem("s", "x")
+	require.NoError(t, err)
+	_, err = ps.Set("b", []byte("2"), SetOptions{})
+	require.NoError(t, err)
+	before := ps.Digest("")
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	assert.Equal(t, before, ps.Digest(""))
+}
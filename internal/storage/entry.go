@@ -1,15 +1,61 @@
 package storage
 
 import (
+	"sync/atomic"
 	"time"
 )
 
-// Entry represents a key-value entry in the storage
+// Entry represents a key-value entry in the storage.
+//
+// Entries are copy-on-write: once stored, an Entry and its Value are never
+// modified in place. Updates install a new Entry, and Store.Get hands out
+// copies, so readers (including the snapshot writer) never observe a value
+// or expiry changing underneath them.
 type Entry struct {
 	Value     []byte
+	Hash      map[string][]byte   // fields of a hash, nil for other types; never modified once stored
+	Set       map[string]struct{} // members of a set, nil for other types; never modified once stored
+	ZSet      *SortedSet          // members of a sorted set, nil for other types; never modified once stored
 	Version   uint64
 	ExpiryMs  int64 // -1 means no expiry
 	SizeBytes uint32
+	CreatedMs int64 // 0 if unknown
+	UpdatedMs int64 // last value write, 0 if unknown
+
+	// Access tracking, updated atomically by readers
+	lastAccessMs int64
+	accessCount  uint32
+
+	slab   *slab  // arena slab holding Value, nil for heap-allocated values
+	slot   int    // index of the key in Store.keys
+	digest uint64 // hash of the key and value, see entryDigest
+}
+
+// Value types reported by GETMETA
+const (
+	TypeString = "string"
+	TypeHash   = "hash"
+	TypeSet    = "set"
+	TypeZSet   = "zset"
+)
+
+// Type returns the value type of the entry
+func (e *Entry) Type() string {
+	switch {
+	case e.Hash != nil:
+		return TypeHash
+	case e.Set != nil:
+		return TypeSet
+	case e.ZSet != nil:
+		return TypeZSet
+	}
+	return TypeString
+}
+
+// isString reports whether the entry holds a string, as string commands
+// require
+func (e *Entry) isString() bool {
+	return e.Hash == nil && e.Set == nil && e.ZSet == nil
 }
 
 // IsExpired checks if the entry has expired
@@ -31,3 +77,38 @@ func (e *Entry) TTL() int64 {
 	}
 	return ttl
 }
+
+// clone returns a copy of the entry. The Value slice and the hash, set and
+// sorted set are shared, which is safe because stored values are never modified in place.
+func (e *Entry) clone() *Entry {
+	return &Entry{
+		Value:        e.Value,
+		Hash:         e.Hash,
+		Set:          e.Set,
+		ZSet:         e.ZSet,
+		Version:      e.Version,
+		ExpiryMs:     e.ExpiryMs,
+		SizeBytes:    e.SizeBytes,
+		CreatedMs:    e.CreatedMs,
+		UpdatedMs:    e.UpdatedMs,
+		lastAccessMs: e.LastAccessMs(),
+		accessCount:  e.AccessCount(),
+		slab:         e.slab,
+	}
+}
+
+// LastAccessMs returns the last time the entry was read or written
+func (e *Entry) LastAccessMs() int64 {
+	return atomic.LoadInt64(&e.lastAccessMs)
+}
+
+// AccessCount returns the number of reads since the entry was written
+func (e *Entry) AccessCount() uint32 {
+	return atomic.LoadUint32(&e.accessCount)
+}
+
+// touch records a read access
+func (e *Entry) touch(nowMs int64) {
+	atomic.StoreInt64(&e.lastAccessMs, nowMs)
+	atomic.AddUint32(&e.accessCount, 1)
+}
@@ -0,0 +1,19 @@
+package storage
+
+import "log"
+
+// dropEphemeral removes the keys recovery loaded under an ephemeral prefix
+// rule. They were logged before the rule was added, and as writes to them
+// no longer are, the values on disk are stale.
+func (ps *PersistentStore) dropEphemeral() {
+	dropped := 0
+	for key := range ps.Store.data {
+		if ps.config.EphemeralFor(key) {
+			ps.Store.drop(key)
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		log.Printf("Dropped %d recovered keys under ephemeral prefix rules", dropped)
+	}
+}
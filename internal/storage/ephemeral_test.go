@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentStore_Ephemeral(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.PrefixRules = []config.PrefixRule{{Prefix: "tmp:", Ephemeral: true}}
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	walBytes := func() int64 { return ps.walManager.currentWAL.size }
+
+	_, err = ps.Set("kept", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+	before := walBytes()
+	_, err = ps.Set("tmp:a", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+	_, err = ps.Set("tmp:b", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+	_, err = ps.Incr("tmp:n", 1)
+	require.NoError(t, err)
+	assert.True(t, ps.Delete("tmp:b"))
+	assert.Equal(t, before, walBytes(), "ephemeral writes are not logged")
+
+	stats := ps.GetStats()
+	assert.Equal(t, "3", stats["keys"])
+	assert.Equal(t, "1", stats["keys_persistent"])
+	assert.Equal(t, "2", stats["keys_ephemeral"])
+
+	// Nor snapshotted
+	require.NoError(t, ps.Snapshot())
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, err = ps.Get("tmp:a")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = ps.Get("kept")
+	assert.NoError(t, err)
+	assert.Equal(t, "0", ps.GetStats()["keys_ephemeral"])
+	_, err = ps.Set("old:x", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+
+	// Keys logged before their prefix became ephemeral are dropped
+	cfg.PrefixRules = append(cfg.PrefixRules, config.PrefixRule{Prefix: "old:", Ephemeral: true})
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	_, err = ps.Get("old:x")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Equal(t, "1", ps.GetStats()["keys"])
+}
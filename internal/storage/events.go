@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/metrics"
+)
+
+// Event types
+const (
+	EventExpired = "expired"
+	EventDeleted = "deleted"
+)
+
+// Event reports the removal of a key under a prefix_rule with notify set
+type Event struct {
+	Seq    uint64
+	Type   string
+	Key    string
+	TimeMs int64
+}
+
+// eventQueue holds removal events until a client acknowledges them, so
+// consumers that were down can catch up on invalidations after
+// reconnecting. Events and acknowledgements are logged to the WAL. Once
+// notify_queue_max events are waiting, the oldest are dropped.
+//
+// Events are queued with Store.mu held, so they are logged by logLoop
+// rather than by notify: an fsync must not hold up every reader of the
+// store. Replay therefore accepts events out of order.
+type eventQueue struct {
+	mu       sync.Mutex
+	events   []Event
+	seq      uint64  // last sequence number assigned
+	acked    uint64  // last sequence number acknowledged
+	unlogged []Event // queued but not yet handed to the WAL
+
+	config  *config.Config
+	wal     *WALManager // nil while replaying
+	dropped *metrics.Counter
+
+	wake    chan struct{} // signals logLoop that events are unlogged
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newEventQueue(cfg *config.Config, r *metrics.Registry) *eventQueue {
+	q := &eventQueue{
+		config:  cfg,
+		dropped: r.Counter("events_dropped_total", "keyspace", "Events dropped from a full event queue"),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	r.GaugeFunc("events_queued", "keyspace", "Events waiting to be acknowledged", func() int64 {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return int64(len(q.events))
+	})
+	return q
+}
+
+// notifying reports whether any prefix rule asks for events
+func notifying(cfg *config.Config) bool {
+	for _, rule := range cfg.PrefixRules {
+		if rule.Notify {
+			return true
+		}
+	}
+	return false
+}
+
+// notify queues an event for key if its prefix rule asks for them, for
+// logLoop to log. It may be called with Store.mu held.
+func (q *eventQueue) notify(key, typ string) {
+	if rule := q.config.RuleFor(key); rule == nil || !rule.Notify {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	event := Event{Seq: q.seq, Type: typ, Key: key, TimeMs: time.Now().UnixMilli()}
+	q.add(event)
+	if q.wal != nil {
+		q.unlogged = append(q.unlogged, event)
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// add inserts event in sequence order, dropping the oldest over the limit.
+// Events already queued or acknowledged are ignored. Callers hold q.mu.
+func (q *eventQueue) add(event Event) {
+	if event.Seq <= q.acked {
+		return
+	}
+	i := sort.Search(len(q.events), func(i int) bool { return q.events[i].Seq >= event.Seq })
+	if i < len(q.events) && q.events[i].Seq == event.Seq {
+		return
+	}
+	q.events = slices.Insert(q.events, i, event)
+	if over := len(q.events) - q.config.NotifyQueueMax; over > 0 {
+		q.events = append(q.events[:0:0], q.events[over:]...)
+		q.dropped.Add(uint64(over))
+	}
+}
+
+// logLoop appends queued events to the WAL until stopLogging is called
+func (q *eventQueue) logLoop() {
+	defer close(q.stopped)
+	for {
+		select {
+		case <-q.wake:
+			q.flush()
+		case <-q.stop:
+			q.flush()
+			return
+		}
+	}
+}
+
+// stopLogging stops logLoop once it has logged the events still queued
+func (q *eventQueue) stopLogging() {
+	close(q.stop)
+	<-q.stopped
+}
+
+// flush appends the unlogged events to the WAL, without holding q.mu
+// across the writes
+func (q *eventQueue) flush() {
+	q.mu.Lock()
+	events := q.unlogged
+	q.unlogged = nil
+	q.mu.Unlock()
+
+	for _, event := range events {
+		if err := q.wal.AppendRecord(eventRecord(event)); err != nil {
+			log.Printf("Failed to log event: %v", err)
+		}
+	}
+}
+
+// eventRecord returns the EVENT record for event
+func eventRecord(event Event) *WALRecord {
+	return &WALRecord{
+		Type:      RecordTypeEVENT,
+		Key:       event.Key,
+		Value:     []byte(event.Type),
+		Version:   event.Seq,
+		ExpiryMs:  -1,
+		UpdatedMs: event.TimeMs,
+	}
+}
+
+// after returns up to n events with sequence numbers above seq
+func (q *eventQueue) after(seq uint64, n int) []Event {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var events []Event
+	for _, event := range q.events {
+		if len(events) == n {
+			break
+		}
+		if event.Seq > seq {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// ack drops the events up to seq. Callers hold q.mu.
+func (q *eventQueue) ack(seq uint64) {
+	if seq > q.seq {
+		seq = q.seq
+	}
+	if seq <= q.acked {
+		return
+	}
+	q.acked = seq
+	i := 0
+	for i < len(q.events) && q.events[i].Seq <= seq {
+		i++
+	}
+	q.events = append(q.events[:0:0], q.events[i:]...)
+}
+
+// relog appends the acknowledged position and every waiting event to the
+// WAL, so they outlive the WALs a snapshot deletes
+func (q *eventQueue) relog() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, record := range q.records() {
+		if err := q.wal.AppendRecord(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// records returns the acknowledged position and every waiting event as
+// WAL records, or nil if no event was ever queued. Callers hold q.mu.
+func (q *eventQueue) records() []*WALRecord {
+	if q.seq == 0 {
+		return nil
+	}
+	records := []*WALRecord{{Type: RecordTypeEVENTACK, Version: q.acked, ExpiryMs: -1}}
+	for _, event := range q.events {
+		records = append(records, eventRecord(event))
+	}
+	return records
+}
+
+// apply replays an EVENT or EVENTACK record
+func (q *eventQueue) apply(record *WALRecord) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if record.Version > q.seq {
+		q.seq = record.Version
+	}
+	switch record.Type {
+	case RecordTypeEVENT:
+		q.add(Event{Seq: record.Version, Type: string(record.Value), Key: record.Key, TimeMs: record.UpdatedMs})
+	case RecordTypeEVENTACK:
+		q.ack(record.Version)
+	}
+}
+
+// Events returns up to n queued events with sequence numbers above seq,
+// oldest first
+func (ps *PersistentStore) Events(seq uint64, n int) []Event {
+	return ps.events.after(seq, n)
+}
+
+// AckEvents acknowledges the events up to seq, which are then dropped
+func (ps *PersistentStore) AckEvents(seq uint64) error {
+	q := ps.events
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if seq > q.seq {
+		seq = q.seq
+	}
+	if seq <= q.acked {
+		return nil
+	}
+	if err := q.wal.AppendRecord(&WALRecord{Type: RecordTypeEVENTACK, Version: seq, ExpiryMs: -1}); err != nil {
+		return err
+	}
+	q.ack(seq)
+	return nil
+}
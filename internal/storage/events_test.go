@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func eventKeys(events []Event) []string {
+	keys := make([]string, len(events))
+	for i, event := range events {
+		keys[i] = event.Type + ":" + event.Key
+	}
+	return keys
+}
+
+func TestPersistentStore_Events(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.PrefixRules = []config.PrefixRule{{Prefix: "user:", Notify: true}}
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+
+	for _, key := range []string{"user:1", "user:2", "other"} {
+		_, err = ps.Set(key, []byte("v"), SetOptions{})
+		require.NoError(t, err)
+	}
+	_, err = ps.Set("user:3", []byte("v"), SetOptions{ExpiryMs: 1})
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	ps.Delete("user:1")
+	ps.Delete("other")
+	_, err = ps.Get("user:3")
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	ps.Delete("user:2")
+
+	events := ps.Events(0, 10)
+	assert.Equal(t, []string{"deleted:user:1", "expired:user:3", "deleted:user:2"}, eventKeys(events))
+	assert.Equal(t, []uint64{1, 2, 3}, []uint64{events[0].Seq, events[1].Seq, events[2].Seq})
+	assert.Len(t, ps.Events(1, 1), 1)
+
+	// Acknowledged events are dropped; the rest survive snapshots and a
+	// restart, and sequence numbers carry on
+	require.NoError(t, ps.AckEvents(1))
+	require.NoError(t, ps.Snapshot())
+	require.NoError(t, ps.Snapshot())
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	assert.Equal(t, []string{"expired:user:3", "deleted:user:2"}, eventKeys(ps.Events(0, 10)))
+
+	_, err = ps.Set("user:4", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+	ps.Delete("user:4")
+	events = ps.Events(3, 10)
+	require.Len(t, events, 1)
+	assert.Equal(t, uint64(4), events[0].Seq)
+}
+
+func TestPersistentStore_EventsQueueLimit(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.PrefixRules = []config.PrefixRule{{Prefix: "k", Notify: true}}
+	cfg.NotifyQueueMax = 2
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+
+	for _, key := range []string{"k1", "k2", "k3"} {
+		_, err = ps.Set(key, []byte("v"), SetOptions{})
+		require.NoError(t, err)
+		ps.Delete(key)
+	}
+	assert.Equal(t, []string{"deleted:k2", "deleted:k3"}, eventKeys(ps.Events(0, 10)))
+	assert.Equal(t, "1", ps.GetStats()["events_dropped_total"])
+}
+
+func TestEventQueue_ReplayOutOfOrder(t *testing.T) {
+	cfg := config.DefaultConfig()
+	q := newEventQueue(cfg, metrics.NewRegistry())
+
+	// Events are logged after they are queued, so a snapshot's relog may
+	// come before an event queued earlier, and records may repeat
+	for _, record := range []*WALRecord{
+		eventRecord(Event{Seq: 3, Type: EventDeleted, Key: "c"}),
+		{Type: RecordTypeEVENTACK, Version: 1, ExpiryMs: -1},
+		eventRecord(Event{Seq: 2, Type: EventDeleted, Key: "b"}),
+		eventRecord(Event{Seq: 3, Type: EventDeleted, Key: "c"}),
+		eventRecord(Event{Seq: 1, Type: EventDeleted, Key: "a"}),
+	} {
+		q.apply(record)
+	}
+	assert.Equal(t, []string{"deleted:b", "deleted:c"}, eventKeys(q.after(0, 10)))
+}
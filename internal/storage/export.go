@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ExportHot writes the n most accessed live keys, most accessed first, as
+// the write commands that recreate them, and returns how many it wrote.
+// Keys accessed equally often are ordered by their last access. Reading
+// them for the export doesn't count as an access.
+func (s *Store) ExportHot(w io.Writer, n int) (int, error) {
+	type hotKey struct {
+		key    string
+		entry  *Entry
+		hits   uint32
+		lastMs int64
+	}
+
+	s.mu.RLock()
+	hot := make([]hotKey, 0, len(s.data))
+	for key, entry := range s.data {
+		if entry.IsExpired() || IsReserved(key) {
+			continue
+		}
+		hot = append(hot, hotKey{key, entry, entry.AccessCount(), entry.LastAccessMs()})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(hot, func(i, j int) bool {
+		if hot[i].hits != hot[j].hits {
+			return hot[i].hits > hot[j].hits
+		}
+		return hot[i].lastMs > hot[j].lastMs
+	})
+	if len(hot) > n {
+		hot = hot[:n]
+	}
+
+	// Stored entries are never modified, so they are written unlocked
+	for i, h := range hot {
+		if err := WriteCommands(w, h.key, h.entry); err != nil {
+			return i, err
+		}
+	}
+	return len(hot), nil
+}
+
+// WriteCommands writes entry as the wire protocol write commands that
+// recreate it, for osprey-dump and EXPORTHOT: a string as a SET command
+// keeping its expiry, a hash as an HSET command, a set as an SADD command
+// or a sorted set as a ZADD command
+func WriteCommands(w io.Writer, key string, entry *Entry) error {
+	switch {
+	case entry.Hash != nil:
+		return writeHSet(w, key, entry)
+	case entry.Set != nil:
+		return writeSAdd(w, key, entry)
+	case entry.ZSet != nil:
+		return writeZAdd(w, key, entry)
+	}
+
+	var err error
+	if entry.ExpiryMs > 0 {
+		_, err = fmt.Fprintf(w, "SET %s %d PXAT %d\r\n", key, len(entry.Value), entry.ExpiryMs)
+	} else {
+		_, err = fmt.Fprintf(w, "SET %s %d\r\n", key, len(entry.Value))
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(entry.Value); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\r\n")
+	return err
+}
+
+// writeHSet writes a hash as an HSET command. HSET can't set an expiry,
+// so one is written as an EXPIRE of the TTL left at the time of the dump.
+func writeHSet(w io.Writer, key string, entry *Entry) error {
+	names := make([]string, 0, len(entry.Hash))
+	for name := range entry.Hash {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	line := "HSET " + key
+	for _, name := range names {
+		line += fmt.Sprintf(" %s %d", name, len(entry.Hash[name]))
+	}
+	if _, err := io.WriteString(w, line+"\r\n"); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := w.Write(entry.Hash[name]); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+	return writeExpire(w, key, entry)
+}
+
+// writeSAdd writes a set as an SADD command, with its expiry written like
+// a hash's
+func writeSAdd(w io.Writer, key string, entry *Entry) error {
+	line := "SADD " + key + " " + strings.Join(SetMembers(entry.Set), " ")
+	if _, err := io.WriteString(w, line+"\r\n"); err != nil {
+		return err
+	}
+	return writeExpire(w, key, entry)
+}
+
+// writeZAdd writes a sorted set as a ZADD command, with its expiry written
+// like a hash's
+func writeZAdd(w io.Writer, key string, entry *Entry) error {
+	line := "ZADD " + key
+	for _, m := range entry.ZSet.Members() {
+		line += " " + FormatFloat(m.Score) + " " + m.Member
+	}
+	if _, err := io.WriteString(w, line+"\r\n"); err != nil {
+		return err
+	}
+	return writeExpire(w, key, entry)
+}
+
+// writeExpire writes an EXPIRE of the TTL entry has left, if it has one,
+// for the commands that can't set an expiry themselves
+func writeExpire(w io.Writer, key string, entry *Entry) error {
+	if ttl := entry.TTL(); ttl > 0 {
+		_, err := fmt.Fprintf(w, "EXPIRE %s %d\r\n", key, ttl)
+		return err
+	}
+	return nil
+}
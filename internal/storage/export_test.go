@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_ExportHot(t *testing.T) {
+	store := newTestStore()
+	store.Set("cold", []byte("c"), SetOptions{})
+	store.Set("hot", []byte("h"), SetOptions{})
+	_, _, err := store.SAdd("warm", "x")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		store.Get("hot")
+	}
+	store.GetSet("warm")
+
+	var buf bytes.Buffer
+	n, err := store.ExportHot(&buf, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "SET hot 1\r\nh\r\nSADD warm x\r\n", buf.String())
+
+	// Exporting doesn't count as an access
+	entry, err := store.Get("cold")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), entry.AccessCount())
+
+	buf.Reset()
+	n, err = store.ExportHot(&buf, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
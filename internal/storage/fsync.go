@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces path with data so that readers and a crash see
+// either the old or the new contents: the data goes to a temp file that is
+// fsynced, renamed over path, and the rename made durable with syncDir.
+func writeFileAtomic(path string, data []byte) error {
+	tempPath := path + ".tmp"
+
+	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return renameDurable(tempPath, path)
+}
+
+// renameDurable renames oldPath to newPath, replacing newPath if it exists,
+// and syncs the parent directory so the rename survives a crash
+func renameDurable(oldPath, newPath string) error {
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(newPath))
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "MANIFEST.json")
+
+	require.NoError(t, writeFileAtomic(path, []byte("first")))
+	require.NoError(t, writeFileAtomic(path, []byte("second")))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	// The temp file is gone after the rename
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRenameDurable_ReplacesExisting(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "snap.tmp")
+	newPath := filepath.Join(dir, "snap.osnap")
+
+	require.NoError(t, os.WriteFile(oldPath, []byte("new"), 0644))
+	require.NoError(t, os.WriteFile(newPath, []byte("old"), 0644))
+
+	require.NoError(t, renameDurable(oldPath, newPath))
+
+	data, err := os.ReadFile(newPath)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+}
+
+func TestSyncDir(t *testing.T) {
+	assert.NoError(t, syncDir(t.TempDir()))
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package storage
+
+import "os"
+
+// syncDir fsyncs a directory so entries created, renamed or removed in it
+// are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	handoffMagic   = 0x4F535048 // 'OSPH'
+	handoffVersion = 1
+)
+
+// HandOff stops the store and writes its dataset to w for a new process
+// taking over data_dir, which reads it with NewPersistentStoreFrom instead
+// of recovering. No writes may be in flight. data_dir is released before
+// the dataset is written, so the new process can open it meanwhile; if
+// writing fails the new process can still recover from disk as usual.
+//
+// The stream starts with the name and size of the last WAL written, so
+// the reader can tell data_dir hasn't changed since, followed by a SET,
+// HSET, SADD or ZADD record for every live key, ephemeral ones included,
+// and the IDEMP tokens and queued events. It ends with the magic again and the
+// number of records, so a cut stream isn't mistaken for a smaller dataset.
+func (ps *PersistentStore) HandOff(w io.Writer) error {
+	ps.stopBackground()
+	ps.events.stopLogging()
+
+	if err := ps.writeCounters(true); err != nil {
+		log.Printf("Failed to save counters: %v", err)
+	}
+	walName, walSize := ps.walManager.Position()
+	err := ps.walManager.Close()
+	ps.lock.release()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	bw := bufio.NewWriterSize(w, 256*1024)
+	if err := writeHandoffHeader(bw, walName, walSize); err != nil {
+		return err
+	}
+
+	var keys, records uint64
+	ps.Store.mu.RLock()
+	for key, entry := range ps.Store.data {
+		if entry.IsExpired() {
+			continue
+		}
+		record := &WALRecord{
+			Type:      RecordTypeSET,
+			Key:       key,
+			Value:     entry.Value,
+			ExpiryMs:  entry.ExpiryMs,
+			Version:   entry.Version,
+			CreatedMs: entry.CreatedMs,
+			UpdatedMs: entry.UpdatedMs,
+		}
+		switch {
+		case entry.Hash != nil:
+			record.Type, record.Value = RecordTypeHSET, encodeHashFields(hashFields(entry.Hash))
+		case entry.Set != nil:
+			record.Type, record.Value = RecordTypeSADD, encodeMembers(SetMembers(entry.Set))
+		case entry.ZSet != nil:
+			record.Type, record.Value = RecordTypeZADD, encodeZMembers(entry.ZSet.Members())
+		}
+		if _, err := bw.Write(encodeWALRecord(record, WALVersion)); err != nil {
+			ps.Store.mu.RUnlock()
+			return err
+		}
+		keys++
+	}
+	ps.Store.mu.RUnlock()
+
+	state, err := ps.writeHandoffState(bw)
+	if err != nil {
+		return err
+	}
+	records = keys + state
+
+	trailer := binary.LittleEndian.AppendUint32(nil, handoffMagic)
+	trailer = binary.LittleEndian.AppendUint64(trailer, records)
+	if _, err := bw.Write(trailer); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	log.Printf("Handed off %d keys in %v", keys, time.Since(start))
+	return nil
+}
+
+// writeHandoffState writes the live IDEMP tokens and the event queue and
+// returns the number of records written
+func (ps *PersistentStore) writeHandoffState(w io.Writer) (uint64, error) {
+	ps.mu.Lock()
+	now := time.Now().UnixMilli()
+	var records []*WALRecord
+	for token, t := range ps.tokens {
+		if t.expiryMs > now {
+			records = append(records, tokenRecord(token, t))
+		}
+	}
+	ps.mu.Unlock()
+
+	ps.events.mu.Lock()
+	records = append(records, ps.events.records()...)
+	ps.events.mu.Unlock()
+
+	for _, record := range records {
+		if _, err := w.Write(encodeWALRecord(record, WALVersion)); err != nil {
+			return 0, err
+		}
+	}
+	return uint64(len(records)), nil
+}
+
+// writeHandoffHeader writes magic(4) + version(2) + name length(2) + WAL
+// name + WAL size(8)
+func writeHandoffHeader(w io.Writer, walName string, walSize int64) error {
+	header := make([]byte, 8, 16+len(walName))
+	binary.LittleEndian.PutUint32(header[0:4], handoffMagic)
+	binary.LittleEndian.PutUint16(header[4:6], handoffVersion)
+	binary.LittleEndian.PutUint16(header[6:8], uint16(len(walName)))
+	header = append(header, walName...)
+	header = binary.LittleEndian.AppendUint64(header, uint64(walSize))
+
+	_, err := w.Write(header)
+	return err
+}
+
+// readHandoffHeader reads the header written by writeHandoffHeader
+func readHandoffHeader(r io.Reader) (string, int64, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", 0, err
+	}
+	if magic := binary.LittleEndian.Uint32(header[0:4]); magic != handoffMagic {
+		return "", 0, fmt.Errorf("invalid handoff magic: %x", magic)
+	}
+	if version := binary.LittleEndian.Uint16(header[4:6]); version != handoffVersion {
+		return "", 0, fmt.Errorf("unsupported handoff version: %d", version)
+	}
+
+	rest := make([]byte, int(binary.LittleEndian.Uint16(header[6:8]))+8)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return "", 0, err
+	}
+	nameLen := len(rest) - 8
+	return string(rest[:nameLen]), int64(binary.LittleEndian.Uint64(rest[nameLen:])), nil
+}
+
+// receiveHandoff loads the dataset written by HandOff. The WAL it names
+// must be the last one before this process's and still have the size it
+// had, or the dataset may be missing writes made since.
+func (ps *PersistentStore) receiveHandoff(r io.Reader) error {
+	start := time.Now()
+	br := bufio.NewReaderSize(r, 256*1024)
+
+	walName, walSize, err := readHandoffHeader(br)
+	if err != nil {
+		return err
+	}
+	if err := ps.checkHandoffWAL(walName, walSize); err != nil {
+		return err
+	}
+
+	reader := NewWALReader(br)
+	var keys, records uint64
+	for {
+		if next, err := br.Peek(4); err == nil && binary.LittleEndian.Uint32(next) == handoffMagic {
+			break
+		}
+		record, err := reader.ReadRecord()
+		if err == io.EOF {
+			return fmt.Errorf("stream ended after %d records", records)
+		}
+		if err != nil {
+			return fmt.Errorf("after %d records: %w", records, err)
+		}
+		if err := ps.applyRecord(record, loadEverything); err != nil {
+			return err
+		}
+		records++
+		if record.Type == RecordTypeSET || record.Type == RecordTypeHSET || record.Type == RecordTypeSADD ||
+			record.Type == RecordTypeZADD {
+			keys++
+		}
+	}
+
+	trailer := make([]byte, 12)
+	if _, err := io.ReadFull(br, trailer); err != nil {
+		return err
+	}
+	if sent := binary.LittleEndian.Uint64(trailer[4:]); sent != records {
+		return fmt.Errorf("received %d records, %d were sent", records, sent)
+	}
+
+	ps.Store.mu.Lock()
+	ps.rebuildExpiryHeap()
+	ps.Store.mu.Unlock()
+
+	log.Printf("Received %d keys from the previous process in %v", keys, time.Since(start))
+	return nil
+}
+
+// checkHandoffWAL checks that walName of walSize bytes is the WAL written
+// just before the one this process opened
+func (ps *PersistentStore) checkHandoffWAL(walName string, walSize int64) error {
+	index, err := ps.walManager.extractWALIndex(walName)
+	if err != nil {
+		return err
+	}
+	if current, _ := ps.walManager.Position(); current != fmt.Sprintf("wal-%08d.oswal", index+1) {
+		return fmt.Errorf("%s is no longer the last WAL", walName)
+	}
+
+	info, err := os.Stat(filepath.Join(ps.config.WALDirectory(), walName))
+	if err != nil {
+		return err
+	}
+	if info.Size() != walSize {
+		return fmt.Errorf("%s is %d bytes, expected %d", walName, info.Size(), walSize)
+	}
+	return nil
+}
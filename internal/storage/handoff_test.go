@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentStore_HandOff(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.PrefixRules = []config.PrefixRule{{Prefix: "tmp:", Ephemeral: true}}
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, err = ps.Set("a", []byte("1"), SetOptions{})
+	require.NoError(t, err)
+	version, err := ps.Set("b", []byte("2"), SetOptions{ExpiryMs: 60000})
+	require.NoError(t, err)
+	_, err = ps.Set("tmp:c", []byte("3"), SetOptions{})
+	require.NoError(t, err)
+	_, replayed, err := ps.SetIdempotent("d", []byte("4"), SetOptions{}, "tok")
+	require.NoError(t, err)
+	require.False(t, replayed)
+	_, _, err = ps.HSet("e", []HashField{{"f", []byte("5")}})
+	require.NoError(t, err)
+	_, _, err = ps.SAdd("g", "x", "y")
+	require.NoError(t, err)
+	_, _, err = ps.ZAdd("z", []ZMember{{"x", 2}, {"y", 1}})
+	require.NoError(t, err)
+
+	var stream bytes.Buffer
+	require.NoError(t, ps.HandOff(&stream))
+
+	next, err := NewPersistentStoreFrom(cfg, bytes.NewReader(stream.Bytes()))
+	require.NoError(t, err)
+	defer next.Close()
+
+	entry, err := next.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(entry.Value))
+	assert.Equal(t, version, entry.Version)
+	assert.Positive(t, entry.ExpiryMs)
+
+	// Ephemeral keys survive a handoff, unlike a restart
+	entry, err = next.Get("tmp:c")
+	require.NoError(t, err)
+	assert.Equal(t, "3", string(entry.Value))
+
+	entry, err = next.GetHash("e")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"f": []byte("5")}, entry.Hash)
+
+	entry, err = next.GetSet("g")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "y"}, SetMembers(entry.Set))
+
+	entry, err = next.GetSortedSet("z")
+	require.NoError(t, err)
+	assert.Equal(t, []ZMember{{"y", 1}, {"x", 2}}, entry.ZSet.Members())
+
+	_, replayed, err = next.SetIdempotent("d", []byte("4"), SetOptions{}, "tok")
+	require.NoError(t, err)
+	assert.True(t, replayed, "IDEMP tokens are handed off")
+}
+
+func TestPersistentStore_HandOffRejected(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	handOff := func() []byte {
+		ps, err := NewPersistentStore(cfg)
+		require.NoError(t, err)
+		_, err = ps.Set("a", []byte("1"), SetOptions{})
+		require.NoError(t, err)
+		var stream bytes.Buffer
+		require.NoError(t, ps.HandOff(&stream))
+		return stream.Bytes()
+	}
+
+	// A cut stream
+	stream := handOff()
+	_, err := NewPersistentStoreFrom(cfg, bytes.NewReader(stream[:len(stream)-12]))
+	assert.ErrorContains(t, err, "stream ended")
+
+	// A WAL written since the handoff
+	stream = handOff()
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, err = ps.Set("b", []byte("2"), SetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+	_, err = NewPersistentStoreFrom(cfg, bytes.NewReader(stream))
+	assert.ErrorContains(t, err, "no longer the last WAL")
+
+	// A WAL appended to since the handoff
+	stream = handOff()
+	wals, err := listWALFilesIn(cfg.WALDirectory())
+	require.NoError(t, err)
+	f, err := os.OpenFile(filepath.Join(cfg.WALDirectory(), wals[len(wals)-1]), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = NewPersistentStoreFrom(cfg, bytes.NewReader(stream))
+	assert.ErrorContains(t, err, "expected")
+
+	// Recovery still works after a failed handoff
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	_, err = ps.Get("b")
+	assert.NoError(t, err)
+}
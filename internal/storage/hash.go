@@ -0,0 +1,385 @@
+package storage
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// HashField is a field of a hash and its value
+type HashField struct {
+	Name  string
+	Value []byte
+}
+
+// hashSize returns the bytes held by a hash's field names and values,
+// counted against max_value_bytes and reported as its size
+func hashSize(hash map[string][]byte) int {
+	size := 0
+	for name, value := range hash {
+		size += len(name) + len(value)
+	}
+	return size
+}
+
+// hashFields returns the fields of hash sorted by name
+func hashFields(hash map[string][]byte) []HashField {
+	fields := make([]HashField, 0, len(hash))
+	for name, value := range hash {
+		fields = append(fields, HashField{Name: name, Value: value})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// hashMap returns fields as a hash, later fields replacing earlier ones
+// of the same name
+func hashMap(fields []HashField) map[string][]byte {
+	hash := make(map[string][]byte, len(fields))
+	for _, f := range fields {
+		hash[f.Name] = f.Value
+	}
+	return hash
+}
+
+// encodeHashFields encodes fields as held by HSET and HDEL records and
+// snapshots: count(4), then name length(4) + name + value length(4) +
+// value for each field
+func encodeHashFields(fields []HashField) []byte {
+	size := 4
+	for _, f := range fields {
+		size += 8 + len(f.Name) + len(f.Value)
+	}
+	data := make([]byte, 0, size)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(fields)))
+	for _, f := range fields {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(f.Name)))
+		data = append(data, f.Name...)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(f.Value)))
+		data = append(data, f.Value...)
+	}
+	return data
+}
+
+// DecodeHashFields decodes the fields of an HSET or HDEL record. HDEL
+// records hold empty values.
+func DecodeHashFields(data []byte) ([]HashField, error) {
+	errShort := errors.New("hash fields cut short")
+	next := func() ([]byte, error) {
+		if len(data) < 4 {
+			return nil, errShort
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(len(data)-4) < uint64(n) {
+			return nil, errShort
+		}
+		b := data[4 : 4+n]
+		data = data[4+n:]
+		return b, nil
+	}
+
+	if len(data) < 4 {
+		return nil, errShort
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	if uint64(count) > uint64(len(data)/8) {
+		return nil, errShort
+	}
+
+	fields := make([]HashField, 0, count)
+	for i := uint32(0); i < count; i++ {
+		name, err := next()
+		if err != nil {
+			return nil, err
+		}
+		value, err := next()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, HashField{Name: string(name), Value: value})
+	}
+	if len(data) > 0 {
+		return nil, fmt.Errorf("%d bytes after the hash fields", len(data))
+	}
+	return fields, nil
+}
+
+// HSet sets fields of the hash at key, creating it if needed, and returns
+// the number of fields that are new and the hash's version. A new hash
+// gets the prefix's default TTL; an existing one keeps its expiry. It
+// fails with ErrWrongType if key holds a string.
+func (s *Store) HSet(key string, fields []HashField) (int, uint64, error) {
+	if err := validateKey(key); err != nil {
+		return 0, 0, err
+	}
+	for _, f := range fields {
+		if err := validateKey(f.Name); err != nil {
+			return 0, 0, fmt.Errorf("field %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.CmdHSet.Inc()
+
+	now := time.Now().UnixMilli()
+	existing, exists := s.data[key]
+	live := exists && !existing.IsExpired()
+	if live && existing.Hash == nil {
+		return 0, 0, ErrWrongType
+	}
+
+	// Copy-on-write: readers may hold the current map
+	hash := make(map[string][]byte, len(fields))
+	var newVersion uint64 = 1
+	createdMs := now
+	var expiryMs int64
+	if live {
+		for name, value := range existing.Hash {
+			hash[name] = value
+		}
+		newVersion = existing.Version + 1
+		createdMs = existing.CreatedMs
+		expiryMs = existing.ExpiryMs
+	} else {
+		var err error
+		expiryMs, err = s.ttlPolicy(key, -1, now)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	added := 0
+	for _, f := range fields {
+		if _, ok := hash[f.Name]; !ok {
+			added++
+		}
+		hash[f.Name] = append([]byte(nil), f.Value...)
+	}
+	size := hashSize(hash)
+	if size > s.config.MaxValueBytesFor(key) {
+		return 0, 0, ErrValueTooLarge
+	}
+
+	s.put(key, &Entry{
+		Hash:         hash,
+		Version:      newVersion,
+		ExpiryMs:     expiryMs,
+		SizeBytes:    uint32(size),
+		CreatedMs:    createdMs,
+		UpdatedMs:    now,
+		lastAccessMs: now,
+	})
+	s.grew()
+
+	if expiryMs > 0 && !live {
+		heap.Push(s.expiryHeap, &ExpiryItem{Key: key, ExpiryMs: expiryMs})
+	}
+
+	return added, newVersion, nil
+}
+
+// HDel deletes fields of the hash at key and returns the number removed
+// and the hash's version. The key is deleted with its last field.
+func (s *Store) HDel(key string, names ...string) (int, uint64, error) {
+	if err := validateKey(key); err != nil {
+		return 0, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.CmdHSet.Inc()
+
+	existing, exists := s.data[key]
+	if !exists || existing.IsExpired() {
+		return 0, 0, nil
+	}
+	if existing.Hash == nil {
+		return 0, 0, ErrWrongType
+	}
+
+	hash := make(map[string][]byte, len(existing.Hash))
+	for name, value := range existing.Hash {
+		hash[name] = value
+	}
+	removed := 0
+	for _, name := range names {
+		if _, ok := hash[name]; ok {
+			delete(hash, name)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, existing.Version, nil
+	}
+
+	newVersion := existing.Version + 1
+	if len(hash) == 0 {
+		s.drop(key)
+		s.removed(key, EventDeleted)
+		return removed, newVersion, nil
+	}
+
+	updated := existing.clone()
+	updated.Hash = hash
+	updated.Version = newVersion
+	updated.SizeBytes = uint32(hashSize(hash))
+	updated.touch(time.Now().UnixMilli())
+	updated.UpdatedMs = updated.lastAccessMs
+	s.put(key, updated)
+
+	return removed, newVersion, nil
+}
+
+// GetHash returns the hash at key, for HGET, HMGET and HGETALL. It fails
+// with ErrWrongType if key holds a string. The returned Entry is a copy;
+// its Hash must not be modified.
+func (s *Store) GetHash(key string) (*Entry, error) {
+	entry, err := s.read(key, s.stats.CmdHGet)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Hash == nil {
+		return nil, ErrWrongType
+	}
+	return entry, nil
+}
+
+// HSet is Store.HSet logged to the WAL as an HSET record of the fields set
+func (ps *PersistentStore) HSet(key string, fields []HashField) (int, uint64, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	prev, _ := ps.Store.peek(key)
+
+	added, version, err := ps.Store.HSet(key, fields)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	entry := ps.Store.lookup(key)
+	record := &WALRecord{
+		Type:      RecordTypeHSET,
+		Key:       key,
+		Value:     encodeHashFields(fields),
+		ExpiryMs:  entry.ExpiryMs,
+		Version:   entry.Version,
+		CreatedMs: entry.CreatedMs,
+		UpdatedMs: entry.UpdatedMs,
+	}
+
+	if err := ps.appendRecord(record, false); err != nil {
+		// Rollback
+		ps.Store.restore(key, prev)
+		return 0, 0, fmt.Errorf("WAL write failed: %w", err)
+	}
+
+	return added, version, nil
+}
+
+// HDel is Store.HDel logged to the WAL as an HDEL record of the fields
+// removed
+func (ps *PersistentStore) HDel(key string, names ...string) (int, uint64, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	prev, _ := ps.Store.peek(key)
+
+	removed, version, err := ps.Store.HDel(key, names...)
+	if err != nil || removed == 0 {
+		return removed, version, err
+	}
+
+	var fields []HashField
+	for _, name := range names {
+		if _, ok := prev.Hash[name]; ok {
+			fields = append(fields, HashField{Name: name})
+		}
+	}
+	record := &WALRecord{
+		Type:      RecordTypeHDEL,
+		Key:       key,
+		Value:     encodeHashFields(fields),
+		Version:   version,
+		UpdatedMs: time.Now().UnixMilli(),
+	}
+
+	if err := ps.appendRecord(record, false); err != nil {
+		// Rollback
+		ps.Store.restore(key, prev)
+		return 0, 0, fmt.Errorf("WAL write failed: %w", err)
+	}
+
+	return removed, version, nil
+}
+
+// applyHashSetRecord applies an HSET record during recovery. The record
+// replaces a string at the key, as the write it logs could only have been
+// made once that string was gone.
+func (ps *PersistentStore) applyHashSetRecord(record *WALRecord) error {
+	fields, err := DecodeHashFields(record.Value)
+	if err != nil {
+		return fmt.Errorf("HSET record for %s: %w", record.Key, err)
+	}
+
+	key := ps.NormalizeKey(record.Key)
+	hash := make(map[string][]byte, len(fields))
+	if existing, exists := ps.Store.data[key]; exists {
+		for name, value := range existing.Hash {
+			hash[name] = value
+		}
+	}
+	for _, f := range fields {
+		hash[f.Name] = f.Value
+	}
+
+	ps.Store.put(key, &Entry{
+		Hash:      hash,
+		Version:   record.Version,
+		ExpiryMs:  record.ExpiryMs,
+		SizeBytes: uint32(hashSize(hash)),
+		CreatedMs: record.CreatedMs,
+		UpdatedMs: record.UpdatedMs,
+	})
+	ps.Store.grew()
+	return nil
+}
+
+// applyHashDelRecord applies an HDEL record during recovery
+func (ps *PersistentStore) applyHashDelRecord(record *WALRecord) error {
+	fields, err := DecodeHashFields(record.Value)
+	if err != nil {
+		return fmt.Errorf("HDEL record for %s: %w", record.Key, err)
+	}
+
+	key := ps.NormalizeKey(record.Key)
+	existing, exists := ps.Store.data[key]
+	if !exists || existing.Hash == nil {
+		return nil
+	}
+	hash := make(map[string][]byte, len(existing.Hash))
+	for name, value := range existing.Hash {
+		hash[name] = value
+	}
+	for _, f := range fields {
+		delete(hash, f.Name)
+	}
+	if len(hash) == 0 {
+		ps.Store.drop(key)
+		return nil
+	}
+
+	updated := existing.clone()
+	updated.Hash = hash
+	updated.Version = record.Version
+	updated.SizeBytes = uint32(hashSize(hash))
+	updated.UpdatedMs = record.UpdatedMs
+	ps.Store.put(key, updated)
+	return nil
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Hash(t *testing.T) {
+	store := newTestStore()
+
+	added, version, err := store.HSet("h", []HashField{{"a", []byte("1")}, {"b", []byte("2")}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+	assert.Equal(t, uint64(1), version)
+
+	added, version, err = store.HSet("h", []HashField{{"b", []byte("22")}, {"c", []byte("3")}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, uint64(2), version)
+
+	entry, err := store.GetHash("h")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"a": []byte("1"), "b": []byte("22"), "c": []byte("3")}, entry.Hash)
+	assert.Equal(t, uint32(7), entry.SizeBytes)
+	assert.Equal(t, TypeHash, entry.Type())
+
+	// String commands refuse hashes and hash commands refuse strings
+	_, err = store.Get("h")
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, err = store.Incr("h", 1)
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, _, err = store.Append("h", []byte("x"))
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, err = store.GetDel("h")
+	assert.ErrorIs(t, err, ErrWrongType)
+	store.Set("s", []byte("v"), SetOptions{})
+	_, _, err = store.HSet("s", []HashField{{"a", nil}})
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, err = store.GetHash("s")
+	assert.ErrorIs(t, err, ErrWrongType)
+
+	// SET replaces a hash
+	_, _, err = store.HSet("h2", []HashField{{"a", nil}})
+	require.NoError(t, err)
+	_, err = store.Set("h2", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+	entry, err = store.Get("h2")
+	require.NoError(t, err)
+	assert.Equal(t, TypeString, entry.Type())
+
+	removed, version, err := store.HDel("h", "a", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, uint64(3), version)
+
+	// Deleting the last field deletes the key
+	removed, _, err = store.HDel("h", "b", "c")
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.False(t, store.Exists("h"))
+
+	removed, _, err = store.HDel("h", "a")
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+}
+
+func TestStore_HashLimits(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.MaxValueBytes = 8
+	store := New(cfg)
+
+	_, _, err := store.HSet("h", []HashField{{"abcd", []byte("1234")}})
+	require.NoError(t, err)
+	_, _, err = store.HSet("h", []HashField{{"e", []byte("5")}})
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+
+	_, _, err = store.HSet("h", []HashField{{"bad field", []byte("1")}})
+	assert.ErrorIs(t, err, ErrKeyInvalid)
+}
+
+func TestPersistentStore_HashRecovery(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, _, err = ps.HSet("h", []HashField{{"a", []byte("1")}, {"b", []byte("2")}})
+	require.NoError(t, err)
+	_, _, err = ps.HSet("h", []HashField{{"c", []byte("3")}})
+	require.NoError(t, err)
+	_, _, err = ps.HDel("h", "a")
+	require.NoError(t, err)
+	_, _, err = ps.HSet("gone", []HashField{{"a", []byte("1")}})
+	require.NoError(t, err)
+	_, _, err = ps.HDel("gone", "a")
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	entry, err := ps.GetHash("h")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"b": []byte("2"), "c": []byte("3")}, entry.Hash)
+	assert.Equal(t, uint64(3), entry.Version)
+	assert.Equal(t, uint32(4), entry.SizeBytes)
+	assert.False(t, ps.Exists("gone"))
+}
+
+func TestSnapshot_Hash(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cfg := config.DefaultConfig()
+	cfg.DataDir = tempDir
+	cfg.SnapshotCompatFormat = 2
+
+	manager, err := NewSnapshotManager(cfg)
+	require.NoError(t, err)
+
+	store := New(cfg)
+	store.Set("s", []byte("v"), SetOptions{})
+	_, _, err = store.HSet("h", []HashField{{"a", []byte("1")}, {"b", nil}})
+	require.NoError(t, err)
+	require.NoError(t, manager.CreateSnapshot(store, "wal-00000001.oswal"))
+
+	newStore := New(cfg)
+	_, err = manager.LoadSnapshot(newStore)
+	require.NoError(t, err)
+	entry, err := newStore.GetHash("h")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"a": []byte("1"), "b": {}}, entry.Hash)
+	assert.Equal(t, uint32(3), entry.SizeBytes)
+
+	// Format 2 can't hold hashes, so its copy leaves them out
+	var keys []string
+	info, err := VerifySnapshot(filepath.Join(tempDir, "format-2", "snap-00000001.osnap"), func(key string, entry *Entry) {
+		keys = append(keys, key)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(snapVersionV2), info.Version)
+	assert.Equal(t, []string{"s"}, keys)
+}
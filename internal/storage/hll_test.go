@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHLL_Count(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, 20000, 200000} {
+		h := &hll{}
+		for i := 0; i < n; i++ {
+			h.add(fmt.Sprintf("element-%d", i))
+		}
+		// Adding elements again changes nothing
+		for i := 0; i < n && i < 100; i++ {
+			assert.False(t, h.add(fmt.Sprintf("element-%d", i)))
+		}
+
+		count := h.count()
+		assert.InDelta(t, n, count, float64(n)*0.02+0.5, "%d elements", n)
+
+		// Both encodings round-trip
+		decoded, err := parseHLL(h.encode())
+		require.NoError(t, err)
+		assert.Equal(t, h.registers, decoded.registers, "%d elements", n)
+	}
+
+	small := &hll{}
+	small.add("a")
+	assert.Equal(t, hllHeader+3, len(small.encode()))
+
+	for _, bad := range [][]byte{nil, []byte("hello"), []byte("OHLL\x01ab"), []byte("OHLL\x02abc"), []byte("OHLL\x03")} {
+		_, err := parseHLL(bad)
+		assert.ErrorIs(t, err, ErrNotSketch, "%q", bad)
+	}
+
+	// Ranks past what add can produce would index past count's histogram
+	dense := make([]byte, hllHeader+hllDenseSize)
+	copy(dense, "OHLL\x02")
+	dense[hllHeader] = 0x3f
+	for _, bad := range [][]byte{[]byte("OHLL\x01\x00\x00\xff"), []byte("OHLL\x01\x00\x00\x00"), []byte("OHLL\x01\x00\x00\x34"), dense} {
+		_, err := parseHLL(bad)
+		assert.ErrorIs(t, err, ErrNotSketch, "%q", bad[:min(len(bad), 8)])
+	}
+	top, err := parseHLL([]byte("OHLL\x01\x00\x00\x33"))
+	require.NoError(t, err)
+	assert.Equal(t, uint8(hllMaxRank), top.registers[0])
+	assert.Equal(t, uint64(1), top.count())
+}
+
+func TestStore_HyperLogLog(t *testing.T) {
+	store := newTestStore()
+
+	changed, version, err := store.PFAdd("visits", "a", "b", "c", "a")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, uint64(1), version)
+
+	changed, version, err = store.PFAdd("visits", "b")
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, uint64(1), version)
+
+	count, err := store.PFCount("visits")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+
+	// An empty PFADD creates the key
+	changed, _, err = store.PFAdd("empty")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	count, err = store.PFCount("empty", "missing")
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	_, _, err = store.PFAdd("other", "c", "d")
+	require.NoError(t, err)
+	count, err = store.PFCount("visits", "other")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), count)
+
+	version, err = store.PFMerge("all", "visits", "other")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), version)
+	count, err = store.PFCount("all")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), count)
+
+	// Sketches are strings, read and written like any other
+	entry, err := store.Get("all")
+	require.NoError(t, err)
+	assert.Equal(t, TypeString, entry.Type())
+	store.Set("copy", entry.Value, SetOptions{})
+	count, err = store.PFCount("copy")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), count)
+
+	store.Set("text", []byte("hello"), SetOptions{})
+	_, _, err = store.PFAdd("text", "a")
+	assert.ErrorIs(t, err, ErrNotSketch)
+	_, err = store.PFCount("visits", "text")
+	assert.ErrorIs(t, err, ErrNotSketch)
+	_, _, err = store.SAdd("set", "a")
+	require.NoError(t, err)
+	_, err = store.PFMerge("set", "visits")
+	assert.ErrorIs(t, err, ErrWrongType)
+}
+
+func TestPersistentStore_HyperLogLogRecovery(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 5000; i++ {
+		_, _, err = ps.PFAdd("big", fmt.Sprintf("user-%d", i))
+		require.NoError(t, err)
+	}
+	_, _, err = ps.PFAdd("small", "x", "y")
+	require.NoError(t, err)
+	_, err = ps.PFMerge("both", "big", "small")
+	require.NoError(t, err)
+	before, err := ps.PFCount("both")
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	count, err := ps.PFCount("both")
+	require.NoError(t, err)
+	assert.Equal(t, before, count)
+	assert.InDelta(t, 5002, count, 100)
+	entry, err := ps.Get("big")
+	require.NoError(t, err)
+	assert.Equal(t, hllHeader+hllDenseSize, len(entry.Value))
+}
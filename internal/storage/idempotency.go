@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrTokenReused is returned for an IDEMP token that was last used to set
+// a different key
+var ErrTokenReused = errors.New("IDEMP token already used for another key")
+
+// idempToken remembers the SET an IDEMP token was last used with, until
+// expiryMs
+type idempToken struct {
+	key      string
+	version  uint64
+	expiryMs int64
+}
+
+// SetIdempotent is Set for writes that may be retried. The first SET with
+// token is applied; repeats within idempotency_window_ms return the
+// version it was stored with, and replayed is true. Failed SETs don't use
+// up the token. The token is logged after the SET, so a crash in between
+// at worst applies a retry again.
+func (ps *PersistentStore) SetIdempotent(key string, value []byte, opts SetOptions, token string) (version uint64, replayed bool, err error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	if t, ok := ps.tokens[token]; ok && t.expiryMs > now {
+		if t.key != key {
+			return 0, false, ErrTokenReused
+		}
+		return t.version, true, nil
+	}
+
+	version, err = ps.set(key, value, opts)
+	if err != nil {
+		return 0, false, err
+	}
+
+	t := idempToken{key: key, version: version, expiryMs: now + int64(ps.config.IdempotencyWindowMs)}
+	ps.tokens[token] = t
+	if err := ps.logToken(token, t); err != nil {
+		log.Printf("Failed to log IDEMP token: %v", err)
+	}
+	return version, false, nil
+}
+
+// logToken appends token to the WAL. Callers hold ps.mu.
+func (ps *PersistentStore) logToken(token string, t idempToken) error {
+	return ps.walManager.AppendRecord(tokenRecord(token, t))
+}
+
+// tokenRecord returns the IDEMP record for token
+func tokenRecord(token string, t idempToken) *WALRecord {
+	return &WALRecord{
+		Type:     RecordTypeIDEMP,
+		Key:      token,
+		Value:    []byte(t.key),
+		ExpiryMs: t.expiryMs,
+		Version:  t.version,
+	}
+}
+
+// relogTokens appends every live token to the current WAL
+func (ps *PersistentStore) relogTokens() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	for token, t := range ps.tokens {
+		if t.expiryMs <= now {
+			continue
+		}
+		if err := ps.logToken(token, t); err != nil {
+			return fmt.Errorf("token %s: %w", token, err)
+		}
+	}
+	return nil
+}
+
+// pruneTokens forgets tokens whose window has passed
+func (ps *PersistentStore) pruneTokens() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	for token, t := range ps.tokens {
+		if t.expiryMs <= now {
+			delete(ps.tokens, token)
+		}
+	}
+}
+
+// applyIdempRecord applies an IDEMP record during recovery
+func (ps *PersistentStore) applyIdempRecord(record *WALRecord) {
+	if record.ExpiryMs <= time.Now().UnixMilli() {
+		return
+	}
+	ps.tokens[record.Key] = idempToken{
+		key:      ps.NormalizeKey(string(record.Value)),
+		version:  record.Version,
+		expiryMs: record.ExpiryMs,
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentStore_SetIdempotent(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+
+	version, replayed, err := ps.SetIdempotent("key", []byte("v1"), SetOptions{}, "t1")
+	require.NoError(t, err)
+	assert.False(t, replayed)
+
+	// A retry is acknowledged with the original version and not applied
+	_, err = ps.Set("key", []byte("v2"), SetOptions{})
+	require.NoError(t, err)
+	retried, replayed, err := ps.SetIdempotent("key", []byte("v1"), SetOptions{}, "t1")
+	require.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, version, retried)
+	entry, err := ps.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), entry.Value)
+
+	_, _, err = ps.SetIdempotent("other", []byte("v"), SetOptions{}, "t1")
+	assert.ErrorIs(t, err, ErrTokenReused)
+
+	// Failed SETs don't use up their token
+	_, _, err = ps.SetIdempotent("key", []byte("v"), SetOptions{NX: true}, "t2")
+	assert.ErrorIs(t, err, ErrKeyExists)
+	_, replayed, err = ps.SetIdempotent("key", []byte("v3"), SetOptions{}, "t2")
+	require.NoError(t, err)
+	assert.False(t, replayed)
+
+	// Tokens survive snapshots, which delete the WALs that logged them,
+	// and a restart
+	require.NoError(t, ps.Snapshot())
+	require.NoError(t, ps.Snapshot())
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	retried, replayed, err = ps.SetIdempotent("key", []byte("v1"), SetOptions{}, "t1")
+	require.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, version, retried)
+}
+
+func TestPersistentStore_IdempotencyWindow(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.IdempotencyWindowMs = 50
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+
+	_, _, err = ps.SetIdempotent("key", []byte("v"), SetOptions{}, "t1")
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	version, replayed, err := ps.SetIdempotent("key", []byte("v"), SetOptions{}, "t1")
+	require.NoError(t, err)
+	assert.False(t, replayed)
+	assert.Equal(t, uint64(2), version)
+
+	ps.pruneTokens()
+	ps.mu.Lock()
+	assert.Len(t, ps.tokens, 1)
+	ps.mu.Unlock()
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"sort"
+)
+
+// Keys returns up to limit live keys matching the glob pattern, sorted,
+// and whether more matched. Patterns support * (any run of bytes), ? (one
+// byte), [abc], [a-z] and [^abc] classes, and \ to escape the next byte.
+// Every key is scanned, so it is meant for inspecting small datasets.
+func (s *Store) Keys(pattern string, limit int) ([]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	more := false
+	for key, entry := range s.data {
+		if entry.IsExpired() || IsReserved(key) || !matchGlob(pattern, key) {
+			continue
+		}
+		if len(keys) == limit {
+			more = true
+			break
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, more
+}
+
+// Scan returns up to count live keys matching the glob pattern that sort
+// after cursor, in order, and the cursor to pass next: the last key
+// returned, or "" once no keys remain. Start with cursor "". A key present
+// for the whole iteration is returned exactly once, whatever else changes.
+// Each call scans every key, but only holds the lock for one pass.
+func (s *Store) Scan(cursor, pattern string, count int) ([]string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	more := false
+	for key, entry := range s.data {
+		if key <= cursor || entry.IsExpired() || IsReserved(key) || !matchGlob(pattern, key) {
+			continue
+		}
+		keys = append(keys, key)
+		// Keep only the smallest count keys, trimming in batches
+		if len(keys) >= 2*count+64 {
+			sort.Strings(keys)
+			keys = keys[:count]
+			more = true
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > count {
+		keys = keys[:count]
+		more = true
+	}
+	if !more || len(keys) == 0 {
+		return keys, ""
+	}
+	return keys, keys[len(keys)-1]
+}
+
+// matchGlob reports whether s matches the glob pattern. On a mismatch it
+// only backtracks to the last *, so it runs in O(len(pattern)*len(s)).
+func matchGlob(pattern, s string) bool {
+	p, i := 0, 0
+	star, starI := -1, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch c := pattern[p]; c {
+			case '*':
+				star, starI = p, i
+				p++
+				continue
+			case '?':
+				p, i = p+1, i+1
+				continue
+			case '[':
+				if n, ok := matchClass(pattern[p:], s[i]); ok {
+					p, i = p+n, i+1
+					continue
+				}
+			default:
+				n := 1
+				if c == '\\' && p+1 < len(pattern) {
+					c, n = pattern[p+1], 2
+				}
+				if c == s[i] {
+					p, i = p+n, i+1
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		// Let the last * swallow one more byte
+		starI++
+		p, i = star+1, starI
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the [...] class starting pattern, returning
+// the length of the class. An unterminated class matches a literal [.
+func matchClass(pattern string, c byte) (int, bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+
+	matched := false
+	for first := true; i < len(pattern) && (first || pattern[i] != ']'); first = false {
+		lo := pattern[i]
+		if lo == '\\' && i+1 < len(pattern) {
+			i++
+			lo = pattern[i]
+		}
+		hi := lo
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			hi = pattern[i+2]
+			i += 2
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+		i++
+	}
+	if i >= len(pattern) {
+		return 1, c == '['
+	}
+	return i + 1, matched != negate
+}
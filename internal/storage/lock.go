@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// lockFileName is created in data_dir and held locked while a store is open
+const lockFileName = "LOCK"
+
+// ErrLocked is returned by NewPersistentStore when another process has the
+// data directory open
+var ErrLocked = errors.New("data directory is in use by another process")
+
+// dirLock is an exclusive lock on a data directory. It is released when
+// the process exits, so a crash never leaves it behind.
+type dirLock struct {
+	file *os.File
+}
+
+// lockDir takes the lock on dir without waiting, returning ErrLocked if
+// another process holds it
+func lockDir(dir string) (*dirLock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := tryLock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &dirLock{file: f}, nil
+}
+
+// release unlocks the directory
+func (l *dirLock) release() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package storage
+
+import "os"
+
+// tryLock is a no-op where file locking is unavailable
+func tryLock(f *os.File) error {
+	return nil
+}
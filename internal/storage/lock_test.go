@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentStore_DataDirLock(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+
+	// A second store on the same directory is refused while the first is open
+	_, err = NewPersistentStore(cfg)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+}
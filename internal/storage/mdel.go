@@ -0,0 +1,61 @@
+package storage
+
+import "log"
+
+// DeleteMany deletes each of keys and returns how many of them existed.
+// No key is deleted if any of them is invalid.
+func (s *Store) DeleteMany(keys ...string) (int, error) {
+	for _, key := range keys {
+		if err := validateKey(key); err != nil {
+			return 0, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.CmdMDel.Inc()
+	n := 0
+	for _, key := range keys {
+		if s.deleteLocked(key) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// DeleteMany is Store.DeleteMany with the DEL records appended to the WAL
+// together, so they share one fsync
+func (ps *PersistentStore) DeleteMany(keys ...string) (int, error) {
+	for _, key := range keys {
+		if err := validateKey(key); err != nil {
+			return 0, err
+		}
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.Store.mu.Lock()
+	ps.Store.stats.CmdMDel.Inc()
+	var records []*WALRecord
+	for _, key := range keys {
+		entry, exists := ps.Store.data[key]
+		if !exists || !ps.Store.deleteLocked(key) {
+			continue
+		}
+		records = append(records, &WALRecord{
+			Type:     RecordTypeDEL,
+			Key:      key,
+			Version:  entry.Version,
+			ExpiryMs: -1,
+		})
+	}
+	ps.Store.mu.Unlock()
+
+	if err := ps.appendRecords(records); err != nil {
+		// As with DEL, the keys stay deleted in memory
+		log.Printf("WAL write failed for MDEL: %v", err)
+	}
+	return len(records), nil
+}
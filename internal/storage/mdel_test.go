@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentStore_DeleteMany(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.SyncPolicy = "always"
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		_, err := ps.Set(key, []byte("v"), SetOptions{})
+		require.NoError(t, err)
+	}
+
+	syncs := 0
+	ps.walManager.currentWAL.onSync = func(time.Duration) { syncs++ }
+
+	n, err := ps.DeleteMany("a", "b", "missing", "c", "a")
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 1, syncs, "one fsync for the whole batch")
+
+	// An invalid key deletes nothing
+	_, err = ps.DeleteMany("d", "bad key")
+	assert.ErrorIs(t, err, ErrKeyInvalid)
+	assert.True(t, ps.Exists("d"))
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		assert.False(t, ps.Exists(key), key)
+	}
+	assert.True(t, ps.Exists("d"))
+}
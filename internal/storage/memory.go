@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"log"
+	"math"
+	"runtime/metrics"
+	"sort"
+	"sync/atomic"
+)
+
+// Memory pressure levels reported in STATS as memory_pressure
+const (
+	MemoryOK       = "ok"
+	MemoryWarn     = "warn"     // past memory_warn_ratio of the limit
+	MemoryHigh     = "high"     // past memory_evict_ratio of the limit, evicting
+	MemoryCritical = "critical" // at or over the limit
+)
+
+// evictSamples is how many keys are compared for each one evicted: the
+// least recently used of every evictSamples sampled keys is dropped
+const evictSamples = 5
+
+// runtimeMemory is what the Go runtime reports about its memory
+type runtimeMemory struct {
+	used     uint64 // counted against the soft limit: mapped minus released
+	limit    int64  // GOMEMLIMIT or memory_limit_bytes, 0 if there is none
+	gcCycles uint64
+	gcPct    int // GOGC or gc_percent, -1 if the collector is off
+}
+
+func readRuntimeMemory() runtimeMemory {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+		{Name: "/gc/cycles/total:gc-cycles"},
+		{Name: "/gc/gomemlimit:bytes"},
+		{Name: "/gc/gogc:percent"},
+	}
+	metrics.Read(samples)
+
+	m := runtimeMemory{
+		used:     samples[0].Value.Uint64() - samples[1].Value.Uint64(),
+		limit:    int64(samples[3].Value.Uint64()),
+		gcCycles: samples[2].Value.Uint64(),
+		gcPct:    int(int64(samples[4].Value.Uint64())),
+	}
+	if m.limit == math.MaxInt64 {
+		m.limit = 0
+	}
+	return m
+}
+
+// checkMemory updates the memory pressure level and, once usage passes
+// memory_evict_ratio of the soft limit, evicts up to sweep_batch of the
+// least recently used keys. Usage only drops after the next GC, so nothing
+// more is evicted until one has completed.
+func (ps *PersistentStore) checkMemory() {
+	m := readRuntimeMemory()
+	used, limit := m.used, m.limit
+
+	level := MemoryOK
+	switch {
+	case limit == 0:
+	case used >= uint64(limit):
+		level = MemoryCritical
+	case float64(used) >= ps.config.MemoryEvictRatio*float64(limit):
+		level = MemoryHigh
+	case ps.config.MemoryWarnRatio > 0 && float64(used) >= ps.config.MemoryWarnRatio*float64(limit):
+		level = MemoryWarn
+	}
+	ps.memoryPressure.Store(level)
+
+	if level == MemoryOK || level == MemoryWarn || ps.config.MemoryEvictRatio <= 0 {
+		return
+	}
+	if m.gcCycles == atomic.LoadUint64(&ps.evictGCCycles) {
+		return
+	}
+	atomic.StoreUint64(&ps.evictGCCycles, m.gcCycles)
+
+	if n := ps.evict(ps.config.SweepBatch); n > 0 {
+		log.Printf("Memory %s (%d of %d bytes), evicted %d keys", level, used, limit, n)
+	}
+}
+
+// evictCandidate is a key considered for eviction
+type evictCandidate struct {
+	key          string
+	version      uint64
+	lastAccessMs int64
+}
+
+// evict removes up to n keys, picking the least recently used key out of
+// each group of evictSamples. Map iteration order is random, so walking the
+// map is a sample of it.
+func (ps *PersistentStore) evict(n int) int {
+	if n <= 0 {
+		return 0
+	}
+
+	ps.Store.mu.RLock()
+	candidates := make([]evictCandidate, 0, n*evictSamples)
+	for key, entry := range ps.Store.data {
+		if len(candidates) == cap(candidates) {
+			break
+		}
+		if IsReserved(key) {
+			continue
+		}
+		candidates = append(candidates, evictCandidate{key, entry.Version, entry.LastAccessMs()})
+	}
+	ps.Store.mu.RUnlock()
+
+	evicted := 0
+	for start := 0; start < len(candidates); start += evictSamples {
+		group := candidates[start:min(start+evictSamples, len(candidates))]
+		sort.Slice(group, func(i, j int) bool { return group[i].lastAccessMs < group[j].lastAccessMs })
+		if ps.evictKey(group[0].key, group[0].version) {
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// evictKey deletes key if it still has version, logging the deletion to
+// the WAL like a DEL
+func (ps *PersistentStore) evictKey(key string, version uint64) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.Store.mu.Lock()
+	entry, exists := ps.Store.data[key]
+	if !exists || entry.Version != version {
+		ps.Store.mu.Unlock()
+		return false
+	}
+	ps.Store.drop(key)
+	ps.Store.stats.EvictedTotal.Inc()
+	ps.Store.mu.Unlock()
+
+	record := &WALRecord{
+		Type:     RecordTypeDEL,
+		Key:      key,
+		Version:  version,
+		ExpiryMs: -1,
+	}
+	if err := ps.appendRecord(record, false); err != nil {
+		log.Printf("Failed to log eviction: %v", err)
+	}
+	return true
+}
+
+// registerMemoryMetrics registers memory usage, the runtime's GC settings
+// and the pressure level as of the last check
+func (ps *PersistentStore) registerMemoryMetrics() {
+	r := ps.metrics
+	r.GaugeFunc("memory_used_bytes", "memory", "Memory counted against the soft limit", func() int64 {
+		return int64(readRuntimeMemory().used)
+	})
+	r.GaugeFunc("memory_limit_bytes", "memory", "Soft memory limit, 0 if there is none", func() int64 {
+		return readRuntimeMemory().limit
+	})
+	r.Text("memory_pressure", "memory", "ok, warn, high or critical", ps.MemoryPressure)
+	r.GaugeFunc("gc_percent", "memory", "GC target percentage, -1 if the collector is off", func() int64 {
+		return int64(readRuntimeMemory().gcPct)
+	})
+	r.GaugeFunc("gc_cycles", "memory", "Completed GC cycles", func() int64 {
+		return int64(readRuntimeMemory().gcCycles)
+	})
+}
+
+// MemoryPressure returns the pressure level as of the last check
+func (ps *PersistentStore) MemoryPressure() string {
+	level, _ := ps.memoryPressure.Load().(string)
+	if level == "" {
+		level = MemoryOK
+	}
+	return level
+}
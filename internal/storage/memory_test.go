@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentStore_MemoryEviction(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.SweepBatch = 4
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		_, err := ps.Set(fmt.Sprintf("key%d", i), []byte("value"), SetOptions{})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, MemoryOK, ps.MemoryPressure())
+
+	// A limit far below current usage puts the store under pressure
+	prev := debug.SetMemoryLimit(1)
+	runtime.GC()
+	ps.checkMemory()
+	debug.SetMemoryLimit(prev)
+
+	assert.Equal(t, MemoryCritical, ps.MemoryPressure())
+	assert.Equal(t, "4", ps.GetStats()["evicted_total"])
+	assert.Equal(t, "96", ps.GetStats()["keys"])
+
+	// Evictions are persisted
+	require.NoError(t, ps.Close())
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	assert.Equal(t, "96", ps.GetStats()["keys"])
+}
+
+func TestPersistentStore_MemoryWarn(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.MemoryWarnRatio = 0.1
+	cfg.MemoryEvictRatio = 0.99
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	_, err = ps.Set("key", []byte("value"), SetOptions{})
+	require.NoError(t, err)
+
+	// Usage at about half the limit is past the warning ratio only
+	runtime.GC()
+	prev := debug.SetMemoryLimit(int64(readRuntimeMemory().used) * 2)
+	ps.checkMemory()
+	debug.SetMemoryLimit(prev)
+
+	assert.Equal(t, MemoryWarn, ps.MemoryPressure())
+	assert.Equal(t, "0", ps.GetStats()["evicted_total"])
+	assert.Equal(t, "1", ps.GetStats()["keys"])
+}
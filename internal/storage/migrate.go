@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+)
+
+// DataFormat is the on-disk format this build writes, recorded as the
+// manifest version. Data directories in an older format are upgraded at
+// startup; newer ones are refused.
+//
+//	1  snapshot and WAL records may lack created/updated timestamps
+//	2  every record has them
+//	3  snapshot records carry a value type; WALs may hold hash records
+//	4  snapshots and WALs may hold sets
+//	5  snapshots and WALs may hold sorted sets
+const DataFormat = 5
+
+// migration upgrades a data directory from format from to from+1. It
+// must be safe to run again after a crash part way through.
+type migration struct {
+	from int
+	desc string
+	run  func(cfg *config.Config, manifest *Manifest) error
+}
+
+// migrations in order, one per format change
+var migrations = []migration{
+	{from: 1, desc: "rewrite snapshot and WAL records with timestamps", run: migrateTimestamps},
+	{from: 2, desc: "record the format that adds hashes", run: migrateNothing},
+	{from: 3, desc: "record the format that adds sets", run: migrateNothing},
+	{from: 4, desc: "record the format that adds sorted sets", run: migrateNothing},
+}
+
+// MigrateData upgrades the data directory to DataFormat, as NewPersistentStore
+// does at startup, and returns the format it was in. It fails if a server
+// has the directory open.
+func MigrateData(cfg *config.Config) (int, error) {
+	lock, err := lockDir(cfg.DataDir)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.release()
+	return migrate(cfg)
+}
+
+// migrate upgrades the data directory to DataFormat and returns the format
+// it was in. Callers hold the directory lock.
+func migrate(cfg *config.Config) (int, error) {
+	manifest, err := ReadManifest(cfg.DataDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if manifest == nil {
+		walFiles, err := listWALFilesIn(cfg.WALDirectory())
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		if len(walFiles) == 0 {
+			// New data directory, the first snapshot records the format
+			return DataFormat, nil
+		}
+		// Never snapshotted: WALs only, in whatever format wrote them
+		manifest = &Manifest{Version: 1}
+	}
+
+	if manifest.Version < 1 {
+		manifest.Version = 1
+	}
+	from := manifest.Version
+	if from > DataFormat {
+		return from, fmt.Errorf("data directory has format %d, newer than this build's %d", from, DataFormat)
+	}
+
+	for _, m := range migrations {
+		if m.from < manifest.Version {
+			continue
+		}
+		log.Printf("Migrating data format %d to %d: %s", m.from, m.from+1, m.desc)
+		start := time.Now()
+		if err := m.run(cfg, manifest); err != nil {
+			return from, fmt.Errorf("migrating data format %d to %d: %w", m.from, m.from+1, err)
+		}
+		manifest.Version = m.from + 1
+		if err := WriteManifest(cfg.DataDir, manifest); err != nil {
+			return from, fmt.Errorf("failed to write manifest: %w", err)
+		}
+		log.Printf("Migrated data format to %d in %v", manifest.Version, time.Since(start))
+	}
+	return from, nil
+}
+
+// migrateTimestamps rewrites the current snapshot and every WAL in the
+// current record versions. Records without timestamps keep zero ones.
+func migrateTimestamps(cfg *config.Config, manifest *Manifest) error {
+	if manifest.Snap != "" {
+		snapPath := filepath.Join(cfg.SnapshotDirectory(), manifest.Snap)
+		if _, err := os.Stat(snapPath); os.IsNotExist(err) {
+			snapPath = filepath.Join(cfg.DataDir, manifest.Snap)
+		}
+		if err := rewriteSnapshot(snapPath); err != nil {
+			return fmt.Errorf("%s: %w", manifest.Snap, err)
+		}
+	}
+
+	walDir := cfg.WALDirectory()
+	walFiles, err := listWALFilesIn(walDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, name := range walFiles {
+		if err := rewriteWAL(filepath.Join(walDir, name)); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// migrateNothing upgrades a directory to a format that only adds value
+// types, reading the older snapshots and WALs as they are: version 2
+// snapshots hold only strings, version 3 ones no sets and version 4 ones
+// no sorted sets
+func migrateNothing(cfg *config.Config, manifest *Manifest) error {
+	return nil
+}
+
+// rewriteSnapshot rewrites the snapshot at path in SnapVersion
+func rewriteSnapshot(path string) error {
+	reader, err := OpenSnapshotReader(path)
+	if err != nil {
+		return err
+	}
+	version := reader.version
+	reader.Close()
+	if version == SnapVersion {
+		return nil
+	}
+
+	tempPath := path + ".tmp"
+	if err := copySnapshot(path, tempPath, SnapVersion); err != nil {
+		return err
+	}
+	return renameDurable(tempPath, path)
+}
+
+// copySnapshot writes the entries of the snapshot at src to a new snapshot
+// at dst with the given record version
+func copySnapshot(src, dst string, version uint16) error {
+	reader, err := OpenSnapshotReader(src)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := newSnapshotWriter(dst, version)
+	if err != nil {
+		return err
+	}
+	for {
+		key, entry, err := reader.ReadEntry()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = writer.WriteEntry(key, entry)
+		}
+		if err != nil {
+			writer.Close()
+			os.Remove(dst)
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// rewriteWAL rewrites the WAL at path in WALVersion. Like recovery, it
+// stops at the first bad record, dropping a torn write at the end.
+func rewriteWAL(path string) error {
+	reader, err := OpenWALReader(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var data []byte
+	for {
+		record, err := reader.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Truncating %s during migration: %v", filepath.Base(path), err)
+			break
+		}
+		data = append(data, encodeWALRecord(record, WALVersion)...)
+	}
+	return writeFileAtomic(path, data)
+}
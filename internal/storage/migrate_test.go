@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeV1Snapshot writes a snapshot in the v1 layout, without timestamps
+func writeV1Snapshot(t *testing.T, path string, entries map[string]string) {
+	buf := make([]byte, 14)
+	binary.LittleEndian.PutUint32(buf[0:4], SnapMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], snapVersionV1)
+	binary.LittleEndian.PutUint64(buf[6:14], uint64(len(entries)))
+
+	for key, value := range entries {
+		record := make([]byte, 24)
+		binary.LittleEndian.PutUint32(record[0:4], uint32(len(key)))
+		binary.LittleEndian.PutUint32(record[4:8], uint32(len(value)))
+		binary.LittleEndian.PutUint64(record[8:16], ^uint64(0)) // no expiry
+		binary.LittleEndian.PutUint64(record[16:24], 3)
+		record = append(record, key...)
+		record = append(record, value...)
+		crc := crc32.Checksum(record, crc32.MakeTable(crc32.Castagnoli))
+		record = binary.LittleEndian.AppendUint32(record, crc)
+		buf = append(buf, record...)
+	}
+	require.NoError(t, os.WriteFile(path, buf, 0644))
+}
+
+func TestMigrate_V1(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	writeV1Snapshot(t, filepath.Join(cfg.DataDir, "snap-00000001.osnap"), map[string]string{"a": "1", "b": "2"})
+	record := &WALRecord{Type: RecordTypeSET, Key: "c", Value: []byte("3"), ExpiryMs: -1, Version: 1}
+	walPath := filepath.Join(cfg.DataDir, "wal-00000002.oswal")
+	require.NoError(t, os.WriteFile(walPath, encodeWALRecord(record, walVersionV1), 0644))
+	require.NoError(t, WriteManifest(cfg.DataDir, &Manifest{Version: 1, Snap: "snap-00000001.osnap", NextWAL: "wal-00000002.oswal"}))
+
+	from, err := MigrateData(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, 1, from)
+
+	manifest, err := ReadManifest(cfg.DataDir)
+	require.NoError(t, err)
+	assert.Equal(t, DataFormat, manifest.Version)
+
+	reader, err := OpenSnapshotReader(filepath.Join(cfg.DataDir, "snap-00000001.osnap"))
+	require.NoError(t, err)
+	assert.Equal(t, uint16(SnapVersion), reader.version)
+	reader.Close()
+
+	data, err := os.ReadFile(walPath)
+	require.NoError(t, err)
+	assert.Equal(t, encodeWALRecord(record, WALVersion), data)
+
+	// Migrating again is a no-op
+	from, err = MigrateData(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, DataFormat, from)
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	for key, want := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		entry, err := ps.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(entry.Value))
+	}
+}
+
+func TestMigrate_WALOnly(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	record := &WALRecord{Type: RecordTypeSET, Key: "k", Value: []byte("v"), ExpiryMs: -1, Version: 1}
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.DataDir, "wal-00000001.oswal"), encodeWALRecord(record, walVersionV1), 0644))
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+
+	entry, err := ps.Get("k")
+	require.NoError(t, err)
+	assert.Equal(t, "v", string(entry.Value))
+
+	manifest, err := ReadManifest(cfg.DataDir)
+	require.NoError(t, err)
+	assert.Equal(t, DataFormat, manifest.Version)
+	assert.Empty(t, manifest.Snap)
+}
+
+func TestMigrate_NewerFormat(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	require.NoError(t, WriteManifest(cfg.DataDir, &Manifest{Version: DataFormat + 1}))
+
+	_, err := NewPersistentStore(cfg)
+	assert.ErrorContains(t, err, "newer than this build's")
+}
+
+func TestMigrate_NewDataDir(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	from, err := MigrateData(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, DataFormat, from)
+
+	manifest, err := ReadManifest(cfg.DataDir)
+	require.NoError(t, err)
+	assert.Nil(t, manifest)
+}
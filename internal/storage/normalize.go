@@ -0,0 +1,43 @@
+package storage
+
+import "log"
+
+// NormalizeKey returns key in the canonical form set by key_normalization.
+// Callers normalize keys from clients before using them; recovery
+// normalizes the keys it loads.
+func (ps *PersistentStore) NormalizeKey(key string) string {
+	if ps.normalize == nil {
+		return key
+	}
+	return ps.normalize(key)
+}
+
+// normalizeKeys renames the keys loaded from a snapshot written under a
+// different key_normalization. Of several keys with the same canonical
+// form, the most recently updated one is kept.
+func (ps *PersistentStore) normalizeKeys() {
+	if ps.normalize == nil {
+		return
+	}
+
+	renamed, merged := 0, 0
+	for key, entry := range ps.Store.data {
+		canonical := ps.normalize(key)
+		if canonical == key {
+			continue
+		}
+		ps.Store.drop(key)
+		renamed++
+		if existing, ok := ps.Store.data[canonical]; ok {
+			merged++
+			if existing.UpdatedMs > entry.UpdatedMs || (existing.UpdatedMs == entry.UpdatedMs && existing.Version >= entry.Version) {
+				continue
+			}
+		}
+		ps.Store.put(canonical, entry)
+	}
+
+	if renamed > 0 {
+		log.Printf("Normalized %d keys from the snapshot (%d merged into existing keys)", renamed, merged)
+	}
+}
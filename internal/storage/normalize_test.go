@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentStore_KeyNormalization(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+
+	// Written before normalization was turned on: two spellings of one
+	// key in the snapshot, and WAL records after it
+	_, err = ps.Set("User:1", []byte("old"), SetOptions{})
+	require.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	_, err = ps.Set("USER:1", []byte("new"), SetOptions{})
+	require.NoError(t, err)
+	_, err = ps.Set("User:2", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, ps.Snapshot())
+	_, err = ps.Set("User:3", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+	assert.True(t, ps.Delete("User:2"))
+	require.NoError(t, ps.Close())
+
+	cfg.KeyNormalization = "lowercase"
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+
+	assert.Equal(t, "user:1", ps.NormalizeKey("User:1"))
+	assert.Equal(t, 2, ps.Len())
+	entry, err := ps.Get("user:1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new"), entry.Value)
+	_, err = ps.Get("user:2")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = ps.Get("user:3")
+	assert.NoError(t, err)
+}
+
+func TestNewPersistentStore_UnknownKeyNormalization(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.KeyNormalization = "uppercase"
+
+	_, err := NewPersistentStore(cfg)
+	assert.Error(t, err)
+}
@@ -3,19 +3,26 @@ package storage
 import (
 	"container/heap"
 	"fmt"
+	"io"
 	"log"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/keynorm"
+	"github.com/bharatmehan/osprey/internal/latency"
+	"github.com/bharatmehan/osprey/internal/metrics"
 )
 
 // PersistentStore is a Store with WAL persistence
 type PersistentStore struct {
 	*Store
+	lock            *dirLock // held on data_dir until Close
 	walManager      *WALManager
 	snapshotManager *SnapshotManager
+	latency         *latency.Monitor
 	mu              sync.Mutex
 
 	// Sweeper control
@@ -23,50 +30,179 @@ type PersistentStore struct {
 	sweeperDone chan struct{}
 	sweeping    int32
 
+	// IDEMP tokens of recent SETs, see SetIdempotent. Guarded by mu.
+	tokens map[string]idempToken
+
+	// Expiry and delete events waiting for clients to acknowledge them
+	events *eventQueue
+
+	// key_normalization, nil if keys are kept as sent
+	normalize keynorm.Func
+
+	// Writes logged without an fsync, and those whose WAL append failed
+	noSyncWrites   *metrics.Counter
+	noSyncFailures *metrics.Counter
+
+	// Memory pressure, see checkMemory
+	memoryPressure atomic.Value // string
+	evictGCCycles  uint64
+
+	// Recovery with priority_prefixes, see recoverPriority
+	loading   atomic.Bool
+	loaded    chan struct{} // closed once every key is loaded
+	mixedSwap bool          // the priority pass skipped a swap it couldn't split
+
 	// Snapshot control
 	snapshotStop   chan struct{}
 	snapshotDone   chan struct{}
 	snapshotPaused int32
+	pauseMu        sync.Mutex
+	pauseDone      chan struct{} // closed when the current pause ends; nil when not paused
+	snapshotMu     sync.Mutex    // serializes background and on-demand snapshots
+	archiver       SnapshotArchiver
+}
+
+// SnapshotArchiver copies a completed snapshot somewhere off the node.
+// manifestPath points at snapPath and walPaths are the WALs to replay
+// after it; all of them are closed and will not change.
+type SnapshotArchiver interface {
+	ArchiveSnapshot(manifestPath, snapPath string, walPaths []string) error
 }
 
-// NewPersistentStore creates a new persistent store
+// NewPersistentStore creates a new persistent store, recovering its keys
+// from data_dir
 func NewPersistentStore(cfg *config.Config) (*PersistentStore, error) {
+	return NewPersistentStoreFrom(cfg, nil)
+}
+
+// NewPersistentStoreFrom creates a persistent store whose keys are read
+// from handoff, written by the HandOff of the process that last held
+// data_dir, instead of being recovered. A nil handoff recovers as usual.
+func NewPersistentStoreFrom(cfg *config.Config, handoff io.Reader) (*PersistentStore, error) {
+	switch cfg.ValueStorage {
+	case "", ValueStorageHeap, ValueStorageArena:
+	default:
+		return nil, fmt.Errorf("unknown value_storage: %s", cfg.ValueStorage)
+	}
+	policies := []string{cfg.MaxTTLPolicy}
+	for _, rule := range cfg.PrefixRules {
+		policies = append(policies, rule.MaxTTLPolicy)
+	}
+	for _, policy := range policies {
+		if policy != "" && policy != "clamp" && policy != "reject" {
+			return nil, fmt.Errorf("unknown max_ttl_policy: %s", policy)
+		}
+	}
+	if cfg.SnapshotCompatFormat < 0 || cfg.SnapshotCompatFormat >= DataFormat {
+		return nil, fmt.Errorf("snapshot_compat_format must be below the current format %d", DataFormat)
+	}
+	normalize, err := keynorm.Parse(cfg.KeyNormalization)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := lockDir(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := migrate(cfg); err != nil {
+		lock.release()
+		return nil, err
+	}
+
 	walManager, err := NewWALManager(cfg)
 	if err != nil {
+		lock.release()
 		return nil, err
 	}
 
 	snapshotManager, err := NewSnapshotManager(cfg)
 	if err != nil {
+		walManager.Close()
+		lock.release()
 		return nil, err
 	}
 
 	ps := &PersistentStore{
 		Store:           New(cfg),
+		lock:            lock,
 		walManager:      walManager,
 		snapshotManager: snapshotManager,
+		latency:         latency.NewMonitor(cfg.LatencyMonitorThreshold()),
+		tokens:          make(map[string]idempToken),
+		normalize:       normalize,
 		sweeperStop:     make(chan struct{}),
 		sweeperDone:     make(chan struct{}),
 		snapshotStop:    make(chan struct{}),
 		snapshotDone:    make(chan struct{}),
+		loaded:          make(chan struct{}),
 	}
 
-	// Load data from disk
-	if err := ps.recover(); err != nil {
+	walManager.latency = ps.latency
+	ps.events = newEventQueue(cfg, ps.metrics)
+	ps.registerMetrics()
+
+	// Load data from disk, or from the previous process
+	if handoff != nil {
+		if err := ps.receiveHandoff(handoff); err != nil {
+			walManager.Close()
+			lock.release()
+			return nil, fmt.Errorf("handoff failed: %w", err)
+		}
+	} else if err := ps.recover(); err != nil {
+		walManager.Close()
+		lock.release()
 		return nil, fmt.Errorf("recovery failed: %w", err)
 	}
 
-	// Start background tasks
-	go ps.expirySweeper()
-	go ps.snapshotWorker()
+	// After recovery, whose replay doesn't count as commands
+	if err := ps.loadCounters(); err != nil {
+		walManager.Close()
+		lock.release()
+		return nil, fmt.Errorf("failed to load counters: %w", err)
+	}
+
+	// Events are only logged from here on, not while replaying
+	ps.events.wal = walManager
+	if notifying(cfg) {
+		ps.Store.notify = ps.events.notify
+	}
+
+	if err := ps.initMeta(); err != nil {
+		walManager.Close()
+		lock.release()
+		return nil, err
+	}
+
+	if ps.Loading() {
+		go ps.finishLoad()
+	} else {
+		ps.startBackground()
+		close(ps.loaded)
+	}
 
 	return ps, nil
 }
 
+// startBackground starts the expiry sweeper and the snapshot worker
+func (ps *PersistentStore) startBackground() {
+	go ps.expirySweeper()
+	go ps.snapshotWorker()
+	go ps.events.logLoop()
+}
+
 // Set stores a key-value pair with WAL persistence
 func (ps *PersistentStore) Set(key string, value []byte, opts SetOptions) (uint64, error) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
+	return ps.set(key, value, opts)
+}
+
+// set is Set for callers holding ps.mu
+func (ps *PersistentStore) set(key string, value []byte, opts SetOptions) (uint64, error) {
+	// Remember the previous entry so a failed WAL write can be rolled back
+	prev, _ := ps.Store.peek(key)
 
 	// First perform the in-memory operation to get the version
 	version, err := ps.Store.Set(key, value, opts)
@@ -75,20 +211,22 @@ func (ps *PersistentStore) Set(key string, value []byte, opts SetOptions) (uint6
 	}
 
 	// Get the entry to get the final state
-	entry, _ := ps.Store.Get(key)
+	entry := ps.Store.lookup(key)
 
 	// Write to WAL
 	record := &WALRecord{
-		Type:     RecordTypeSET,
-		Key:      key,
-		Value:    value,
-		ExpiryMs: entry.ExpiryMs,
-		Version:  version,
+		Type:      RecordTypeSET,
+		Key:       key,
+		Value:     value,
+		ExpiryMs:  entry.ExpiryMs,
+		Version:   version,
+		CreatedMs: entry.CreatedMs,
+		UpdatedMs: entry.UpdatedMs,
 	}
 
-	if err := ps.walManager.AppendRecord(record); err != nil {
+	if err := ps.appendRecord(record, opts.NoSync); err != nil {
 		// Rollback the in-memory change
-		ps.Store.Delete(key)
+		ps.Store.restore(key, prev)
 		return 0, fmt.Errorf("WAL write failed: %w", err)
 	}
 
@@ -101,7 +239,7 @@ func (ps *PersistentStore) Delete(key string) bool {
 	defer ps.mu.Unlock()
 
 	// Get the entry before deletion for version
-	entry, err := ps.Store.Get(key)
+	entry, err := ps.Store.peek(key)
 	if err != nil {
 		return false
 	}
@@ -119,7 +257,7 @@ func (ps *PersistentStore) Delete(key string) bool {
 		ExpiryMs: -1,
 	}
 
-	if err := ps.walManager.AppendRecord(record); err != nil {
+	if err := ps.appendRecord(record, false); err != nil {
 		// We can't rollback a delete easily, log the error
 		log.Printf("WAL write failed for DELETE: %v", err)
 	}
@@ -127,37 +265,109 @@ func (ps *PersistentStore) Delete(key string) bool {
 	return true
 }
 
+// GetDel is Store.GetDel logged to the WAL as a DEL record. The record is
+// written first, so a key handed to one client is never recovered for
+// another after a crash.
+func (ps *PersistentStore) GetDel(key string) (*Entry, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	entry, err := ps.Store.peek(key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			// Count it, and drop the key if it expired
+			return ps.Store.GetDel(key)
+		}
+		return nil, err
+	}
+	if !entry.isString() {
+		return nil, ErrWrongType
+	}
+
+	record := &WALRecord{
+		Type:     RecordTypeDEL,
+		Key:      key,
+		Version:  entry.Version,
+		ExpiryMs: -1,
+	}
+	if err := ps.appendRecord(record, false); err != nil {
+		return nil, fmt.Errorf("WAL write failed: %w", err)
+	}
+
+	return ps.Store.GetDel(key)
+}
+
+// GetEx reads a key and updates its TTL with WAL persistence
+func (ps *PersistentStore) GetEx(key string, ttlMs int64) (*Entry, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	entry, err := ps.Store.peek(key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			// Count it, and drop the key if it expired
+			return ps.Store.getEx(key, -1)
+		}
+		return nil, err
+	}
+	if !entry.isString() {
+		return nil, ErrWrongType
+	}
+
+	now := time.Now().UnixMilli()
+	expiryMs := int64(-1)
+	if ttlMs >= 0 {
+		expiryMs = now + ttlMs
+	}
+	expiryMs, err = ps.Store.ttlPolicy(key, expiryMs, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write to WAL first so a failed write leaves memory untouched
+	record := &WALRecord{
+		Type:     RecordTypeEXPIRE,
+		Key:      key,
+		ExpiryMs: expiryMs,
+		Version:  entry.Version,
+	}
+	if err := ps.appendRecord(record, false); err != nil {
+		return nil, fmt.Errorf("WAL write failed: %w", err)
+	}
+
+	return ps.Store.getEx(key, expiryMs)
+}
+
 // Expire sets a TTL with WAL persistence
 func (ps *PersistentStore) Expire(key string, ttlMs int64) error {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 
 	// Get current entry
-	entry, err := ps.Store.Get(key)
+	entry, err := ps.Store.peek(key)
 	if err != nil {
 		return err
 	}
 
-	err = ps.Store.Expire(key, ttlMs)
+	now := time.Now().UnixMilli()
+	expiryMs, err := ps.Store.ttlPolicy(key, now+ttlMs, now)
 	if err != nil {
 		return err
 	}
 
-	// Write to WAL
+	// Write to WAL first so a failed write leaves memory untouched
 	record := &WALRecord{
 		Type:     RecordTypeEXPIRE,
 		Key:      key,
-		ExpiryMs: time.Now().UnixMilli() + ttlMs,
+		ExpiryMs: expiryMs,
 		Version:  entry.Version,
 	}
 
-	if err := ps.walManager.AppendRecord(record); err != nil {
-		// Rollback by removing expiry
-		entry.ExpiryMs = -1
+	if err := ps.appendRecord(record, false); err != nil {
 		return fmt.Errorf("WAL write failed: %w", err)
 	}
 
-	return nil
+	return ps.Store.expireAt(key, expiryMs)
 }
 
 // Incr increments with WAL persistence
@@ -165,39 +375,114 @@ func (ps *PersistentStore) Incr(key string, delta int64) (int64, error) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 
+	prev, _ := ps.Store.peek(key)
+
 	newVal, err := ps.Store.Incr(key, delta)
 	if err != nil {
 		return 0, err
 	}
+	if err := ps.logCounter(key, prev); err != nil {
+		return 0, err
+	}
+	return newVal, nil
+}
+
+// IncrFloat increments a float value with WAL persistence
+func (ps *PersistentStore) IncrFloat(key string, delta float64) (float64, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	prev, _ := ps.Store.peek(key)
+
+	newVal, err := ps.Store.IncrFloat(key, delta)
+	if err != nil {
+		return 0, err
+	}
+	if err := ps.logCounter(key, prev); err != nil {
+		return 0, err
+	}
+	return newVal, nil
+}
 
-	// Get the updated entry
-	entry, _ := ps.Store.Get(key)
+// logCounter logs the value Incr, IncrFloat, PFAdd or PFMerge stored under
+// key, restoring prev if the WAL write fails
+func (ps *PersistentStore) logCounter(key string, prev *Entry) error {
+	entry := ps.Store.lookup(key)
 
 	// Write to WAL as a SET operation
 	record := &WALRecord{
-		Type:     RecordTypeSET,
-		Key:      key,
-		Value:    entry.Value,
-		ExpiryMs: entry.ExpiryMs,
-		Version:  entry.Version,
+		Type:      RecordTypeSET,
+		Key:       key,
+		Value:     entry.Value,
+		ExpiryMs:  entry.ExpiryMs,
+		Version:   entry.Version,
+		CreatedMs: entry.CreatedMs,
+		UpdatedMs: entry.UpdatedMs,
 	}
 
-	if err := ps.walManager.AppendRecord(record); err != nil {
+	if err := ps.appendRecord(record, false); err != nil {
 		// Rollback
-		ps.Store.Delete(key)
-		return 0, fmt.Errorf("WAL write failed: %w", err)
+		ps.Store.restore(key, prev)
+		return fmt.Errorf("WAL write failed: %w", err)
 	}
+	return nil
+}
 
-	return newVal, nil
+// Append appends to a value with WAL persistence. The resulting value is
+// logged as one SET record.
+func (ps *PersistentStore) Append(key string, data []byte) (int, uint64, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	prev, _ := ps.Store.peek(key)
+
+	length, version, err := ps.Store.Append(key, data)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	entry := ps.Store.lookup(key)
+	record := &WALRecord{
+		Type:      RecordTypeSET,
+		Key:       key,
+		Value:     entry.Value,
+		ExpiryMs:  entry.ExpiryMs,
+		Version:   entry.Version,
+		CreatedMs: entry.CreatedMs,
+		UpdatedMs: entry.UpdatedMs,
+	}
+
+	if err := ps.appendRecord(record, false); err != nil {
+		// Rollback
+		ps.Store.restore(key, prev)
+		return 0, 0, fmt.Errorf("WAL write failed: %w", err)
+	}
+
+	return length, version, nil
 }
 
 // recover loads data from snapshot and WAL files
 func (ps *PersistentStore) recover() error {
+	if len(ps.config.PriorityPrefixes) > 0 {
+		return ps.recoverPriority()
+	}
+	return ps.recoverPass(loadEverything)
+}
+
+// recoverPass loads the keys pass selects from the snapshot and WAL files.
+// It holds Store.mu only while it changes the store, so reads can be
+// served while it runs.
+func (ps *PersistentStore) recoverPass(pass loadPass) error {
 	// First load from snapshot if available
-	nextWAL, err := ps.snapshotManager.LoadSnapshot(ps.Store)
+	nextWAL, err := ps.snapshotManager.loadSnapshot(ps.Store, func(key string) bool {
+		return ps.loads(pass, ps.NormalizeKey(key))
+	})
 	if err != nil {
 		return fmt.Errorf("failed to load snapshot: %w", err)
 	}
+	ps.Store.mu.Lock()
+	ps.normalizeKeys()
+	ps.Store.mu.Unlock()
 
 	// Get WAL files to replay starting from snapshot's next WAL
 	walFiles, err := ps.walManager.GetWALsForReplay(nextWAL)
@@ -205,23 +490,27 @@ func (ps *PersistentStore) recover() error {
 		return err
 	}
 
-	log.Printf("Recovering from %d WAL files", len(walFiles))
+	log.Printf("Recovering %s keys from %d WAL files", pass.name, len(walFiles))
 
 	for _, walPath := range walFiles {
-		if err := ps.replayWAL(walPath); err != nil {
+		if err := ps.replayWAL(walPath, pass); err != nil {
 			log.Printf("Error replaying WAL %s: %v", walPath, err)
 			// Continue with other WALs
 		}
 	}
 
+	ps.Store.mu.Lock()
+	defer ps.Store.mu.Unlock()
+	ps.dropEphemeral()
+
 	// Rebuild expiry heap
 	ps.rebuildExpiryHeap()
 
 	return nil
 }
 
-// replayWAL replays a single WAL file
-func (ps *PersistentStore) replayWAL(path string) error {
+// replayWAL replays the records of a single WAL file that pass loads
+func (ps *PersistentStore) replayWAL(path string, pass loadPass) error {
 	reader, err := OpenWALReader(path)
 	if err != nil {
 		return err
@@ -240,7 +529,42 @@ func (ps *PersistentStore) replayWAL(path string) error {
 			break
 		}
 
-		// Apply the record
+		if err := ps.applyRecord(record, pass); err != nil {
+			log.Printf("Truncating WAL at record %d due to error: %v", count, err)
+			return nil
+		}
+
+		count++
+	}
+
+	log.Printf("Replayed %d records from %s", count, path)
+	return nil
+}
+
+// applyRecord applies a WAL record during recovery if pass loads it
+func (ps *PersistentStore) applyRecord(record *WALRecord, pass loadPass) error {
+	switch record.Type {
+	case RecordTypeIDEMP:
+		if pass.other {
+			ps.applyIdempRecord(record)
+		}
+		return nil
+	case RecordTypeEVENT, RecordTypeEVENTACK:
+		if pass.other {
+			ps.events.apply(record)
+		}
+		return nil
+	}
+
+	ps.Store.mu.Lock()
+	defer ps.Store.mu.Unlock()
+
+	switch record.Type {
+	case RecordTypeSET, RecordTypeDEL, RecordTypeEXPIRE, RecordTypeHSET, RecordTypeHDEL, RecordTypeSADD, RecordTypeSREM,
+		RecordTypeZADD, RecordTypeZREM:
+		if !ps.loads(pass, ps.NormalizeKey(record.Key)) {
+			return nil
+		}
 		switch record.Type {
 		case RecordTypeSET:
 			ps.applySetRecord(record)
@@ -248,33 +572,55 @@ func (ps *PersistentStore) replayWAL(path string) error {
 			ps.applyDelRecord(record)
 		case RecordTypeEXPIRE:
 			ps.applyExpireRecord(record)
+		case RecordTypeHSET:
+			return ps.applyHashSetRecord(record)
+		case RecordTypeHDEL:
+			return ps.applyHashDelRecord(record)
+		case RecordTypeSADD:
+			return ps.applySetAddRecord(record)
+		case RecordTypeSREM:
+			return ps.applySetRemRecord(record)
+		case RecordTypeZADD:
+			return ps.applyZAddRecord(record)
+		case RecordTypeZREM:
+			return ps.applyZRemRecord(record)
+		}
+	case RecordTypeBATCH:
+		return ps.applyBatchRecord(record, pass)
+	case RecordTypeSWAP:
+		apply, mixed := ps.loadsSwap(pass, ps.NormalizeKey(record.Key), ps.NormalizeKey(string(record.Value)))
+		if mixed {
+			ps.mixedSwap = true
+		}
+		if apply {
+			ps.applySwapRecord(record)
 		}
-
-		count++
 	}
-
-	log.Printf("Replayed %d records from %s", count, path)
 	return nil
 }
 
 // applySetRecord applies a SET record during recovery
 func (ps *PersistentStore) applySetRecord(record *WALRecord) {
-	ps.Store.data[record.Key] = &Entry{
-		Value:     record.Value,
+	entry := &Entry{
 		Version:   record.Version,
 		ExpiryMs:  record.ExpiryMs,
 		SizeBytes: uint32(len(record.Value)),
+		CreatedMs: record.CreatedMs,
+		UpdatedMs: record.UpdatedMs,
 	}
+	entry.Value, entry.slab = ps.Store.arenaValue(record.Value)
+	ps.Store.put(ps.NormalizeKey(record.Key), entry)
+	ps.Store.grew()
 }
 
 // applyDelRecord applies a DEL record during recovery
 func (ps *PersistentStore) applyDelRecord(record *WALRecord) {
-	delete(ps.Store.data, record.Key)
+	ps.Store.drop(ps.NormalizeKey(record.Key))
 }
 
 // applyExpireRecord applies an EXPIRE record during recovery
 func (ps *PersistentStore) applyExpireRecord(record *WALRecord) {
-	if entry, exists := ps.Store.data[record.Key]; exists {
+	if entry, exists := ps.Store.data[ps.NormalizeKey(record.Key)]; exists {
 		entry.ExpiryMs = record.ExpiryMs
 	}
 }
@@ -296,6 +642,23 @@ func (ps *PersistentStore) rebuildExpiryHeap() {
 
 // Close closes the persistent store
 func (ps *PersistentStore) Close() error {
+	ps.stopBackground()
+	ps.events.stopLogging()
+
+	if err := ps.writeCounters(true); err != nil {
+		log.Printf("Failed to save counters: %v", err)
+	}
+	err := ps.walManager.Close()
+	ps.lock.release()
+	return err
+}
+
+// stopBackground stops the expiry sweeper and the snapshot worker, giving
+// them a few seconds to finish
+func (ps *PersistentStore) stopBackground() {
+	// The background tasks start once every key is loaded
+	ps.WaitLoaded()
+
 	// Stop background tasks
 	close(ps.sweeperStop)
 	close(ps.snapshotStop)
@@ -329,26 +692,103 @@ func (ps *PersistentStore) Close() error {
 			snapshotFinished = true
 		case <-timeout.C:
 			log.Printf("Warning: Background tasks did not finish within timeout during shutdown")
-			goto cleanup
+			return
 		}
 	}
+}
+
+// appendRecord logs the record for a write to record.Key, unless the key
+// is ephemeral. With noSync, for SET ... NOSYNC and keys under a no_sync
+// prefix rule, it doesn't wait for an fsync, and a failed append is logged
+// but not returned: the write stays applied in memory.
+func (ps *PersistentStore) appendRecord(record *WALRecord, noSync bool) error {
+	if ps.config.EphemeralFor(record.Key) {
+		return nil
+	}
+	noSync = noSync || ps.config.NoSyncFor(record.Key)
+	if !noSync {
+		return ps.walManager.AppendRecord(record)
+	}
 
-cleanup:
-	return ps.walManager.Close()
+	ps.noSyncWrites.Inc()
+	if err := ps.walManager.AppendRecordNoSync(record); err != nil {
+		ps.noSyncFailures.Inc()
+		log.Printf("WAL write failed for %q, kept in memory only (no_sync): %v", record.Key, err)
+	}
+	return nil
 }
 
-// GetWALStats returns WAL and snapshot statistics
-func (ps *PersistentStore) GetWALStats() map[string]string {
-	stats := make(map[string]string)
-	stats["wal_current"] = ps.walManager.GetCurrentWALName()
+// appendRecords logs the records for a write to several keys together,
+// with one fsync at most. Records for ephemeral keys are left out, and no
+// fsync is waited for if every key is under a no_sync prefix rule.
+func (ps *PersistentStore) appendRecords(records []*WALRecord) error {
+	logged := records[:0:0]
+	noSync := true
+	for _, record := range records {
+		if ps.config.EphemeralFor(record.Key) {
+			continue
+		}
+		logged = append(logged, record)
+		noSync = noSync && ps.config.NoSyncFor(record.Key)
+	}
+	if len(logged) == 0 {
+		return nil
+	}
+	if !noSync {
+		return ps.walManager.AppendRecords(logged)
+	}
 
-	// Add snapshot stats
-	snapStats := ps.snapshotManager.GetStats()
-	for k, v := range snapStats {
-		stats[k] = v
+	ps.noSyncWrites.Add(uint64(len(logged)))
+	if err := ps.walManager.AppendRecordsNoSync(logged); err != nil {
+		ps.noSyncFailures.Add(uint64(len(logged)))
+		log.Printf("WAL write failed for %d keys, kept in memory only (no_sync): %v", len(logged), err)
 	}
+	return nil
+}
+
+// registerMetrics registers WAL, snapshot and memory statistics in the
+// store's registry
+func (ps *PersistentStore) registerMetrics() {
+	r := ps.metrics
+	r.Text("schema_version", "server", "Data layout version of the dataset", func() string { return ps.Meta(MetaSchemaVersion) })
+	r.Text("replication_id", "server", "Random ID given to the dataset when it was created", func() string { return ps.Meta(MetaReplicationID) })
+	r.Text("wal_current", "persistence", "WAL file being appended to", ps.walManager.GetCurrentWALName)
+	r.GaugeFunc("wal_backlog_bytes", "persistence", "WAL bytes waiting for fsync", ps.WALBacklogBytes)
+	r.GaugeFunc("loading", "persistence", "1 while keys outside priority_prefixes are loading", func() int64 {
+		if ps.Loading() {
+			return 1
+		}
+		return 0
+	})
+	ps.noSyncWrites = r.Counter("wal_nosync_writes_total", "persistence", "Writes logged without waiting for an fsync")
+	ps.noSyncFailures = r.Counter("wal_nosync_failures_total", "persistence", "NOSYNC writes kept in memory after their WAL append failed")
+	ps.walManager.fsyncLatency = r.Histogram("fsync_latency", "persistence", "WAL fsync latency")
+	r.GaugeFunc("snapshots_total", "persistence", "Snapshot files on disk", ps.snapshotManager.SnapshotCount)
+	r.GaugeFunc("last_snapshot_ms", "persistence", "When the last snapshot completed, in Unix milliseconds", ps.snapshotManager.LastSnapshotMs)
+	ps.registerMemoryMetrics()
+}
+
+// WALBacklogBytes returns the WAL bytes waiting for fsync
+func (ps *PersistentStore) WALBacklogBytes() int64 {
+	return ps.walManager.BacklogBytes()
+}
 
-	return stats
+// SyncWAL fsyncs the current WAL file, whatever the sync policy, and
+// returns its name
+func (ps *PersistentStore) SyncWAL() (string, error) {
+	return ps.walManager.Sync()
+}
+
+// RotateWAL fsyncs the current WAL file and starts a new one. It returns
+// the name of the old file: it and every WAL before it are complete on
+// disk and can be copied.
+func (ps *PersistentStore) RotateWAL() (string, error) {
+	return ps.walManager.Cut()
+}
+
+// Latency returns the monitor recording latency spikes
+func (ps *PersistentStore) Latency() *latency.Monitor {
+	return ps.latency
 }
 
 // IsSnapshotPaused returns true if snapshot is in progress
@@ -356,6 +796,45 @@ func (ps *PersistentStore) IsSnapshotPaused() bool {
 	return atomic.LoadInt32(&ps.snapshotPaused) == 1
 }
 
+// WaitSnapshotPause blocks until no snapshot pause is in progress, or
+// timeout has passed. It reports whether the pause ended in time.
+func (ps *PersistentStore) WaitSnapshotPause(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		ps.pauseMu.Lock()
+		done := ps.pauseDone
+		ps.pauseMu.Unlock()
+		if done == nil {
+			return true
+		}
+
+		select {
+		case <-done:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// beginPause marks a snapshot pause as in progress
+func (ps *PersistentStore) beginPause() {
+	ps.pauseMu.Lock()
+	ps.pauseDone = make(chan struct{})
+	atomic.StoreInt32(&ps.snapshotPaused, 1)
+	ps.pauseMu.Unlock()
+}
+
+// endPause ends the pause and wakes WaitSnapshotPause callers
+func (ps *PersistentStore) endPause() {
+	ps.pauseMu.Lock()
+	atomic.StoreInt32(&ps.snapshotPaused, 0)
+	close(ps.pauseDone)
+	ps.pauseDone = nil
+	ps.pauseMu.Unlock()
+}
+
 // expirySweeper runs the background expiry sweeper
 func (ps *PersistentStore) expirySweeper() {
 	defer close(ps.sweeperDone)
@@ -363,12 +842,29 @@ func (ps *PersistentStore) expirySweeper() {
 	ticker := time.NewTicker(ps.config.SweepInterval())
 	defer ticker.Stop()
 
+	// Map compaction shares the goroutine; a nil channel never fires
+	var defragC <-chan time.Time
+	if ps.config.DefragIntervalMs > 0 {
+		defragTicker := time.NewTicker(ps.config.DefragInterval())
+		defer defragTicker.Stop()
+		defragC = defragTicker.C
+	}
+
 	for {
 		select {
 		case <-ps.sweeperStop:
 			return
 		case <-ticker.C:
 			ps.sweepExpired()
+			ps.pruneTokens()
+			ps.checkMemory()
+		case <-defragC:
+			if n := ps.Store.Defrag(); n > 0 {
+				log.Printf("Defrag rebuilt key map, released %d slots", n)
+			}
+			if n := ps.Store.CompactArena(); n > 0 {
+				log.Printf("Compacted value arena, moved %d bytes", n)
+			}
 		}
 	}
 }
@@ -381,6 +877,9 @@ func (ps *PersistentStore) sweepExpired() {
 	}
 	defer atomic.StoreInt32(&ps.sweeping, 0)
 
+	start := time.Now()
+	defer func() { ps.latency.Record(latency.EventSweep, time.Since(start)) }()
+
 	ps.Store.mu.Lock()
 	defer ps.Store.mu.Unlock()
 
@@ -406,8 +905,9 @@ func (ps *PersistentStore) sweepExpired() {
 		// Check if the key still exists and is expired
 		if entry, exists := ps.Store.data[top.Key]; exists {
 			if entry.IsExpired() {
-				delete(ps.Store.data, top.Key)
-				ps.Store.stats.ExpiredTotal++
+				ps.Store.drop(top.Key)
+				ps.Store.stats.ExpiredTotal.Inc()
+				ps.Store.removed(top.Key, EventExpired)
 				deleted++
 
 				// Log to WAL
@@ -418,7 +918,7 @@ func (ps *PersistentStore) sweepExpired() {
 					Version:  entry.Version,
 					ExpiryMs: -1,
 				}
-				if err := ps.walManager.AppendRecord(record); err != nil {
+				if err := ps.appendRecord(record, false); err != nil {
 					log.Printf("Failed to log expiry deletion: %v", err)
 				}
 				ps.mu.Unlock()
@@ -476,13 +976,70 @@ func (ps *PersistentStore) maybeSnapshot() {
 	}
 }
 
+// SetArchiver registers a to receive every snapshot taken from now on
+func (ps *PersistentStore) SetArchiver(a SnapshotArchiver) {
+	ps.snapshotMu.Lock()
+	defer ps.snapshotMu.Unlock()
+	ps.archiver = a
+}
+
+// Snapshot writes a snapshot immediately, regardless of enable_snapshot
+// and the usual size thresholds
+func (ps *PersistentStore) Snapshot() error {
+	return ps.createSnapshot()
+}
+
+// Compact writes a snapshot that starts replay at a fresh WAL, so every
+// older WAL file is deleted, leaving the smallest set of files that
+// recovers the same data
+func (ps *PersistentStore) Compact() error {
+	ps.snapshotMu.Lock()
+	defer ps.snapshotMu.Unlock()
+
+	if _, err := ps.walManager.Rotate(); err != nil {
+		return fmt.Errorf("failed to rotate WAL: %w", err)
+	}
+	return ps.createSnapshotLocked(nil)
+}
+
+// WithSnapshot writes a snapshot and calls fn with its files before any
+// cleanup runs. No other snapshot starts until fn returns, so the files
+// stay on disk for its duration.
+func (ps *PersistentStore) WithSnapshot(fn func(manifestPath, snapPath string, walPaths []string) error) error {
+	ps.snapshotMu.Lock()
+	defer ps.snapshotMu.Unlock()
+
+	var fnErr error
+	err := ps.createSnapshotLocked(func(manifestPath, snapPath string, walPaths []string) {
+		fnErr = fn(manifestPath, snapPath, walPaths)
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
 // createSnapshot creates a new snapshot
 func (ps *PersistentStore) createSnapshot() error {
+	ps.snapshotMu.Lock()
+	defer ps.snapshotMu.Unlock()
+
+	return ps.createSnapshotLocked(nil)
+}
+
+// createSnapshotLocked writes a snapshot, rotates the WAL and, before
+// cleaning up old files, passes the new files to the archiver and to use
+// if set. The caller holds snapshotMu.
+func (ps *PersistentStore) createSnapshotLocked(use func(manifestPath, snapPath string, walPaths []string)) error {
+	if ps.Loading() {
+		return ErrLoading
+	}
+
 	log.Println("Starting snapshot...")
 
 	// Mark snapshot as paused
-	atomic.StoreInt32(&ps.snapshotPaused, 1)
-	defer atomic.StoreInt32(&ps.snapshotPaused, 0)
+	ps.beginPause()
+	defer ps.endPause()
 
 	// Measure pause time
 	pauseStart := time.Now()
@@ -503,13 +1060,36 @@ func (ps *PersistentStore) createSnapshot() error {
 	}
 
 	// Rotate WAL after successful snapshot
-	ps.walManager.mu.Lock()
-	if err := ps.walManager.rotateWAL(); err != nil {
-		ps.walManager.mu.Unlock()
+	newWAL, err := ps.walManager.Rotate()
+	if err != nil {
 		return fmt.Errorf("failed to rotate WAL: %w", err)
 	}
-	newWAL := ps.walManager.GetCurrentWALName()
-	ps.walManager.mu.Unlock()
+
+	// Snapshots hold only keys, so carry live IDEMP tokens and queued
+	// events over to the new WAL before the old ones are deleted
+	if err := ps.relogTokens(); err != nil {
+		return fmt.Errorf("failed to log IDEMP tokens: %w", err)
+	}
+	if err := ps.events.relog(); err != nil {
+		return fmt.Errorf("failed to log events: %w", err)
+	}
+
+	// Archive before cleanup so the files are still on disk. A failed
+	// upload does not fail the snapshot; the next one retries.
+	if ps.archiver != nil || use != nil {
+		manifestPath, snapPath, walPaths, err := ps.snapshotFiles(currentWAL)
+		if err != nil {
+			return err
+		}
+		if ps.archiver != nil {
+			if err := ps.archiver.ArchiveSnapshot(manifestPath, snapPath, walPaths); err != nil {
+				log.Printf("Failed to archive snapshot: %v", err)
+			}
+		}
+		if use != nil {
+			use(manifestPath, snapPath, walPaths)
+		}
+	}
 
 	// Clean up old files
 	if err := ps.snapshotManager.CleanupOldFiles(newWAL); err != nil {
@@ -522,3 +1102,20 @@ func (ps *PersistentStore) createSnapshot() error {
 
 	return nil
 }
+
+// snapshotFiles returns the manifest, the snapshot just written and the
+// WAL rotated out after it
+func (ps *PersistentStore) snapshotFiles(walName string) (string, string, []string, error) {
+	manifest, err := ReadManifest(ps.config.DataDir)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if manifest == nil {
+		return "", "", nil, fmt.Errorf("no manifest after snapshot")
+	}
+
+	return filepath.Join(ps.config.DataDir, "MANIFEST.json"),
+		filepath.Join(ps.config.SnapshotDirectory(), manifest.Snap),
+		[]string{filepath.Join(ps.config.WALDirectory(), walName)},
+		nil
+}
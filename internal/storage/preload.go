@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrLoading is returned for snapshots requested while the second
+// recovery pass is still loading keys
+var ErrLoading = errors.New("dataset is still loading")
+
+// loadPass selects what one pass over the snapshot and WALs loads. With
+// priority_prefixes, recovery loads the priority keys first and the rest
+// in a second pass; the two are disjoint, so together they apply exactly
+// what a single pass would.
+type loadPass struct {
+	name           string
+	priority, rest bool // loads priority keys, other keys
+	other          bool // applies records that aren't about keys: IDEMP tokens and events
+}
+
+var (
+	loadEverything = loadPass{name: "all", priority: true, rest: true, other: true}
+	loadPriority   = loadPass{name: "priority", priority: true, other: true}
+	loadRest       = loadPass{name: "remaining", rest: true}
+	loadAllKeys    = loadPass{name: "all", priority: true, rest: true}
+)
+
+// isPriority reports whether key is loaded in the first pass. Server
+// metadata always is.
+func (ps *PersistentStore) isPriority(key string) bool {
+	if IsReserved(key) {
+		return true
+	}
+	for _, prefix := range ps.config.PriorityPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPriorityKey reports whether key is under one of priority_prefixes, so
+// it can be read while the rest of the dataset loads
+func (ps *PersistentStore) IsPriorityKey(key string) bool {
+	return len(ps.config.PriorityPrefixes) > 0 && ps.isPriority(key)
+}
+
+// loads reports whether pass loads key
+func (ps *PersistentStore) loads(pass loadPass, key string) bool {
+	if ps.isPriority(key) {
+		return pass.priority
+	}
+	return pass.rest
+}
+
+// prefixClasses reports whether keys under prefix can be priority keys,
+// and whether they can be other keys
+func (ps *PersistentStore) prefixClasses(prefix string) (priority, rest bool) {
+	rest = true
+	for _, p := range append([]string{ReservedPrefix}, ps.config.PriorityPrefixes...) {
+		if strings.HasPrefix(prefix, p) {
+			return true, false
+		}
+		if strings.HasPrefix(p, prefix) {
+			priority = true
+		}
+	}
+	return priority, rest
+}
+
+// loadsSwap reports whether pass applies a prefix swap of a and b. A swap
+// that moves keys both of and outside priority_prefixes can't be split
+// between passes; mixed reports it to a pass that only loads some keys.
+func (ps *PersistentStore) loadsSwap(pass loadPass, a, b string) (apply, mixed bool) {
+	if pass.priority && pass.rest {
+		return true, false
+	}
+	pa, ra := ps.prefixClasses(a)
+	pb, rb := ps.prefixClasses(b)
+	priority, rest := pa || pb, ra || rb
+	if priority && rest {
+		return false, true
+	}
+	return (priority && pass.priority) || (rest && pass.rest), false
+}
+
+// recoverPriority runs the first recovery pass for priority_prefixes and,
+// unless serve_during_load is set, the second. Otherwise Loading reports
+// true until finishLoad has run the second pass in the background.
+func (ps *PersistentStore) recoverPriority() error {
+	start := time.Now()
+	if err := ps.recoverPass(loadPriority); err != nil {
+		return err
+	}
+
+	if ps.mixedSwap {
+		// The priority keys loaded so far may be missing keys that swap
+		// moved in, so start over with every key
+		log.Printf("A prefix swap in the WAL moves keys in and out of priority_prefixes, loading all keys before serving")
+		ps.Store.clear()
+		return ps.recoverPass(loadAllKeys)
+	}
+	log.Printf("Loaded priority keys in %v", time.Since(start))
+
+	if !ps.config.ServeDuringLoad {
+		return ps.recoverPass(loadRest)
+	}
+	ps.loading.Store(true)
+	return nil
+}
+
+// finishLoad runs the second recovery pass while priority keys are
+// served, then starts the background tasks
+func (ps *PersistentStore) finishLoad() {
+	start := time.Now()
+	if err := ps.recoverPass(loadRest); err != nil {
+		// Serving the priority keys is all that can be done; writes stay
+		// refused so nothing is written over keys that failed to load
+		log.Printf("Loading keys outside priority_prefixes failed, restart to retry: %v", err)
+		ps.startBackground()
+		close(ps.loaded)
+		return
+	}
+	log.Printf("Loaded remaining keys in %v", time.Since(start))
+
+	ps.loading.Store(false)
+	ps.startBackground()
+	close(ps.loaded)
+}
+
+// Loading reports whether keys outside priority_prefixes are still being
+// loaded. Only reads of priority keys should be served meanwhile.
+func (ps *PersistentStore) Loading() bool {
+	return ps.loading.Load()
+}
+
+// WaitLoaded blocks until the second recovery pass, if any, is done
+func (ps *PersistentStore) WaitLoaded() {
+	<-ps.loaded
+}
+
+// clear removes every key. The caller holds no locks.
+func (s *Store) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.data {
+		s.drop(key)
+	}
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePreloadData fills a store in cfg.DataDir with keys in and out of
+// the hot: prefix, partly in a snapshot and partly in the WAL only
+func writePreloadData(t *testing.T, cfg *config.Config, swap [2]string) {
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	for _, key := range []string{"hot:1", "cold:1", "cold:gone"} {
+		_, err := ps.Set(key, []byte("v1"), SetOptions{})
+		require.NoError(t, err)
+	}
+	require.NoError(t, ps.Snapshot())
+
+	_, err = ps.CheckSet(nil, []KeyValue{{Key: "hot:2", Value: []byte("v2")}, {Key: "cold:2", Value: []byte("v2")}})
+	require.NoError(t, err)
+	assert.True(t, ps.Delete("cold:gone"))
+	_, err = ps.SwapPrefix(swap[0], swap[1])
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+}
+
+func allKeys(ps *PersistentStore) []string {
+	keys, _ := ps.Keys("*", 100)
+	return keys
+}
+
+func TestPersistentStore_PriorityLoad(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		swap [2]string
+		want []string
+	}{
+		{"swap outside priority prefixes", [2]string{"cold:", "old:"}, []string{"hot:1", "hot:2", "old:1", "old:2"}},
+		{"swap across priority prefixes", [2]string{"hot:", "cold:"}, []string{"cold:1", "cold:2", "hot:1", "hot:2"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.DataDir = t.TempDir()
+			writePreloadData(t, cfg, tt.swap)
+
+			cfg.PriorityPrefixes = []string{"hot:"}
+			cfg.ServeDuringLoad = true
+			ps, err := NewPersistentStore(cfg)
+			require.NoError(t, err)
+			defer ps.Close()
+			ps.WaitLoaded()
+			assert.False(t, ps.Loading())
+
+			assert.Equal(t, tt.want, allKeys(ps))
+			assert.Equal(t, int64(4), ps.DBSize())
+			assert.NoError(t, ps.Snapshot())
+		})
+	}
+}
+
+func TestPersistentStore_LoadPasses(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	writePreloadData(t, cfg, [2]string{"cold:", "old:"})
+
+	cfg.PriorityPrefixes = []string{"hot:"}
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	assert.True(t, ps.IsPriorityKey("hot:1"))
+	assert.False(t, ps.IsPriorityKey("old:1"))
+
+	// Each pass loads its own keys, from the snapshot and the WAL
+	ps.Store.clear()
+	require.NoError(t, ps.recoverPass(loadPriority))
+	assert.False(t, ps.mixedSwap)
+	assert.Equal(t, []string{"hot:1", "hot:2"}, allKeys(ps))
+	require.NoError(t, ps.recoverPass(loadRest))
+	assert.Equal(t, []string{"hot:1", "hot:2", "old:1", "old:2"}, allKeys(ps))
+
+	// A snapshot while keys are loading would leave keys out
+	ps.loading.Store(true)
+	assert.ErrorIs(t, ps.Snapshot(), ErrLoading)
+	ps.loading.Store(false)
+}
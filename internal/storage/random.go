@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"math/rand"
+)
+
+// randomKeyTries bounds how many expired or reserved keys RandomKey skips
+// before giving up
+const randomKeyTries = 100
+
+// RandomKey returns a live key picked uniformly at random, or
+// ErrKeyNotFound if there are none. Keys are drawn from s.keys in O(1);
+// expired and reserved keys are drawn again, up to randomKeyTries times,
+// so a store made mostly of keys awaiting the sweeper may report none.
+func (s *Store) RandomKey() (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.keys) == 0 {
+		return "", ErrKeyNotFound
+	}
+	for i := 0; i < randomKeyTries; i++ {
+		key := s.keys[rand.Intn(len(s.keys))]
+		if !s.data[key].IsExpired() && !IsReserved(key) {
+			return key, nil
+		}
+	}
+	return "", ErrKeyNotFound
+}
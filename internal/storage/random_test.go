@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkKeyIndex asserts that s.keys lists every key in s.data once, at
+// the slot its entry records
+func checkKeyIndex(t *testing.T, s *Store) {
+	t.Helper()
+	require.Len(t, s.keys, len(s.data))
+	for i, key := range s.keys {
+		entry, exists := s.data[key]
+		require.True(t, exists, "key %q", key)
+		assert.Equal(t, i, entry.slot, "key %q", key)
+	}
+}
+
+func TestStore_KeyIndex(t *testing.T) {
+	store := newTestStore()
+	for i := 0; i < 100; i++ {
+		_, err := store.Set(fmt.Sprintf("key%d", i), []byte("v"), SetOptions{})
+		require.NoError(t, err)
+	}
+	for i := 0; i < 100; i += 3 {
+		store.Delete(fmt.Sprintf("key%d", i))
+	}
+	_, _, err := store.Append("key1", []byte("more"))
+	require.NoError(t, err)
+	_, err = store.SwapPrefix("key1", "other1")
+	require.NoError(t, err)
+	_, err = store.GetDel("key2")
+	require.NoError(t, err)
+	checkKeyIndex(t, store)
+}
+
+func TestStore_RandomKey(t *testing.T) {
+	store := newTestStore()
+	_, err := store.RandomKey()
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// Expired and reserved keys are never picked
+	store.put(ReservedPrefix+MetaSchemaVersion, &Entry{ExpiryMs: -1})
+	_, err = store.Set("gone", []byte("v"), SetOptions{AbsoluteExpiryMs: time.Now().UnixMilli() - 1000})
+	require.NoError(t, err)
+	_, err = store.RandomKey()
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	counts := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		_, err := store.Set(fmt.Sprintf("key%d", i), []byte("v"), SetOptions{})
+		require.NoError(t, err)
+	}
+	for i := 0; i < 4000; i++ {
+		key, err := store.RandomKey()
+		require.NoError(t, err)
+		counts[key]++
+	}
+	assert.Len(t, counts, 4)
+	for key, n := range counts {
+		assert.InDelta(t, 1000, n, 200, "key %q", key)
+	}
+}
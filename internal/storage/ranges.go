@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrOutOfRange is returned by SetRange for a negative offset
+var ErrOutOfRange = errors.New("offset out of range")
+
+// GetRange returns the entry for key with Value holding only the bytes
+// from start to end, inclusive. Negative offsets count back from the end
+// of the value, so 0 -1 is the whole value; offsets past either end are
+// clamped, and a range that selects nothing gives an empty value. Only the
+// range is copied, so reading a slice of a large value is cheap.
+func (s *Store) GetRange(key string, start, end int64) (*Entry, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.stats.CmdGetRange.Inc()
+
+	entry, exists := s.data[key]
+	if !exists || entry.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	if !entry.isString() {
+		return nil, ErrWrongType
+	}
+	entry.touch(time.Now().UnixMilli())
+
+	n := int64(len(entry.Value))
+	if start < 0 {
+		start = max(start+n, 0)
+	}
+	if end < 0 {
+		end += n
+	}
+	end = min(end, n-1)
+
+	result := entry.clone()
+	result.Value = []byte{}
+	if start <= end {
+		result.Value = append(result.Value, entry.Value[start:end+1]...)
+	}
+	result.slab = nil
+	return result, nil
+}
+
+// SetRange overwrites the value of key with data starting at offset and
+// returns the new length and version. A value shorter than offset is
+// padded with zero bytes first, and a missing key is created like APPEND
+// creates it. Empty data changes nothing and returns the current length
+// and version, or zeroes for a missing key.
+func (s *Store) SetRange(key string, offset int64, data []byte) (int, uint64, error) {
+	if err := s.validateWrite(key, data); err != nil {
+		return 0, 0, err
+	}
+	if offset < 0 {
+		return 0, 0, ErrOutOfRange
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.CmdSetRange.Inc()
+
+	now := time.Now().UnixMilli()
+	existing, exists := s.data[key]
+	live := exists && !existing.IsExpired()
+	if live && !existing.isString() {
+		return 0, 0, ErrWrongType
+	}
+
+	if len(data) == 0 {
+		if !live {
+			return 0, 0, nil
+		}
+		return len(existing.Value), existing.Version, nil
+	}
+	// Compared without adding, which could overflow for huge offsets
+	if offset > int64(s.config.MaxValueBytesFor(key))-int64(len(data)) {
+		return 0, 0, ErrValueTooLarge
+	}
+
+	var old []byte
+	var newVersion uint64 = 1
+	createdMs := now
+	var expiryMs int64
+	if live {
+		old = existing.Value
+		newVersion = existing.Version + 1
+		createdMs = existing.CreatedMs
+		expiryMs = existing.ExpiryMs
+	} else {
+		var err error
+		expiryMs, err = s.ttlPolicy(key, -1, now)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	value := make([]byte, max(int64(len(old)), offset+int64(len(data))))
+	copy(value, old)
+	copy(value[offset:], data)
+
+	updated := &Entry{
+		Version:      newVersion,
+		ExpiryMs:     expiryMs,
+		SizeBytes:    uint32(len(value)),
+		CreatedMs:    createdMs,
+		UpdatedMs:    now,
+		lastAccessMs: now,
+	}
+	updated.Value, updated.slab = s.arenaValue(value)
+	s.put(key, updated)
+	s.grew()
+
+	if expiryMs > 0 && !live {
+		heap.Push(s.expiryHeap, &ExpiryItem{Key: key, ExpiryMs: expiryMs})
+	}
+
+	return len(value), newVersion, nil
+}
+
+// SetRange is Store.SetRange logged to the WAL as a SET of the whole
+// patched value
+func (ps *PersistentStore) SetRange(key string, offset int64, data []byte) (int, uint64, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	prev, _ := ps.Store.peek(key)
+
+	length, version, err := ps.Store.SetRange(key, offset, data)
+	if err != nil || len(data) == 0 {
+		return length, version, err
+	}
+
+	entry := ps.Store.lookup(key)
+	record := &WALRecord{
+		Type:      RecordTypeSET,
+		Key:       key,
+		Value:     entry.Value,
+		ExpiryMs:  entry.ExpiryMs,
+		Version:   entry.Version,
+		CreatedMs: entry.CreatedMs,
+		UpdatedMs: entry.UpdatedMs,
+	}
+
+	if err := ps.appendRecord(record, false); err != nil {
+		// Rollback
+		ps.Store.restore(key, prev)
+		return 0, 0, fmt.Errorf("WAL write failed: %w", err)
+	}
+
+	return length, version, nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_GetRange(t *testing.T) {
+	store := newTestStore()
+	_, err := store.Set("blob", []byte("0123456789"), SetOptions{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		start, end int64
+		want       string
+	}{
+		{0, 3, "0123"},
+		{0, -1, "0123456789"},
+		{-3, -1, "789"},
+		{5, 100, "56789"},
+		{-100, 1, "01"},
+		{7, 2, ""},
+		{20, 30, ""},
+	}
+	for _, tt := range tests {
+		entry, err := store.GetRange("blob", tt.start, tt.end)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, string(entry.Value), "range %d %d", tt.start, tt.end)
+		assert.Equal(t, uint64(1), entry.Version)
+	}
+
+	_, err = store.GetRange("missing", 0, -1)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestStore_SetRange(t *testing.T) {
+	store := newTestStore()
+	store.config.MaxValueBytes = 16
+
+	_, err := store.Set("blob", []byte("hello world"), SetOptions{ExpiryMs: 60000})
+	require.NoError(t, err)
+
+	length, version, err := store.SetRange("blob", 6, []byte("there"))
+	require.NoError(t, err)
+	assert.Equal(t, 11, length)
+	assert.Equal(t, uint64(2), version)
+
+	// Writing past the end pads with zero bytes
+	length, _, err = store.SetRange("blob", 13, []byte("!"))
+	require.NoError(t, err)
+	assert.Equal(t, 14, length)
+
+	entry, err := store.Get("blob")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello there\x00\x00!"), entry.Value)
+	assert.Greater(t, entry.TTL(), int64(59000), "SETRANGE keeps the expiry")
+
+	_, _, err = store.SetRange("blob", 15, []byte("ab"))
+	assert.Equal(t, ErrValueTooLarge, err)
+	_, _, err = store.SetRange("blob", math.MaxInt64, []byte("a"))
+	assert.Equal(t, ErrValueTooLarge, err)
+	_, _, err = store.SetRange("blob", -1, []byte("a"))
+	assert.Equal(t, ErrOutOfRange, err)
+
+	// Empty data changes nothing
+	length, version, err = store.SetRange("blob", 100, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 14, length)
+	assert.Equal(t, uint64(3), version)
+	length, version, err = store.SetRange("missing", 0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, length)
+	assert.Equal(t, uint64(0), version)
+	assert.False(t, store.Exists("missing"))
+
+	length, version, err = store.SetRange("new", 2, []byte("x"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, length)
+	assert.Equal(t, uint64(1), version)
+}
+
+func TestPersistentStore_SetRangeRecovery(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, err = ps.Set("blob", []byte("aaaaaa"), SetOptions{})
+	require.NoError(t, err)
+	_, _, err = ps.SetRange("blob", 2, []byte("bb"))
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	entry, err := ps.Get("blob")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("aabbaa"), entry.Value)
+	assert.Equal(t, uint64(2), entry.Version)
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReservedPrefix starts the keys the server keeps its own metadata under.
+// They are persisted like other keys, but clients can't write them, and
+// key counts, listings, eviction and TTL policies leave them out.
+const ReservedPrefix = "__osprey__:"
+
+// Metadata names, stored under ReservedPrefix
+const (
+	MetaSchemaVersion = "schema_version" // data layout the store was written with
+	MetaReplicationID = "replication_id" // random ID given to the dataset when it was created
+)
+
+// metaNames are the metadata names SetMeta accepts
+var metaNames = []string{MetaSchemaVersion, MetaReplicationID}
+
+// SchemaVersion is the data layout this build writes. Data written with a
+// newer one is refused.
+const SchemaVersion = 1
+
+// ErrReservedKey is returned for writes to keys under ReservedPrefix
+var ErrReservedKey = errors.New("key is reserved for server metadata")
+
+// IsReserved reports whether key is under ReservedPrefix
+func IsReserved(key string) bool {
+	return strings.HasPrefix(key, ReservedPrefix)
+}
+
+// Meta returns a metadata value, "" if it isn't set
+func (ps *PersistentStore) Meta(name string) string {
+	ps.Store.mu.RLock()
+	defer ps.Store.mu.RUnlock()
+
+	if entry, exists := ps.Store.data[ReservedPrefix+name]; exists {
+		return string(entry.Value)
+	}
+	return ""
+}
+
+// SetMeta stores a metadata value, logged to the WAL like any other write
+// but without counting as a SET
+func (ps *PersistentStore) SetMeta(name, value string) error {
+	known := false
+	for _, n := range metaNames {
+		known = known || n == name
+	}
+	if !known {
+		return fmt.Errorf("unknown metadata name: %s", name)
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	key := ReservedPrefix + name
+	now := time.Now().UnixMilli()
+	record := &WALRecord{
+		Type:      RecordTypeSET,
+		Key:       key,
+		Value:     []byte(value),
+		ExpiryMs:  -1,
+		Version:   1,
+		CreatedMs: now,
+		UpdatedMs: now,
+	}
+	if prev, err := ps.Store.peek(key); err == nil {
+		record.Version = prev.Version + 1
+		record.CreatedMs = prev.CreatedMs
+	}
+
+	if err := ps.walManager.AppendRecord(record); err != nil {
+		return fmt.Errorf("WAL write failed: %w", err)
+	}
+
+	ps.Store.mu.Lock()
+	ps.applySetRecord(record)
+	ps.Store.mu.Unlock()
+	return nil
+}
+
+// initMeta checks the schema version of recovered data and, for a new
+// dataset, records the schema version and a replication ID
+func (ps *PersistentStore) initMeta() error {
+	if v := ps.Meta(MetaSchemaVersion); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid schema version %q", v)
+		}
+		if version > SchemaVersion {
+			return fmt.Errorf("data has schema version %d, newer than this build's %d", version, SchemaVersion)
+		}
+	} else if err := ps.SetMeta(MetaSchemaVersion, strconv.Itoa(SchemaVersion)); err != nil {
+		return err
+	}
+
+	if ps.Meta(MetaReplicationID) == "" {
+		id := make([]byte, 20)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		if err := ps.SetMeta(MetaReplicationID, hex.EncodeToString(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
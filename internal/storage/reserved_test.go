@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentStore_Meta(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.DefaultTTLMs = 1000
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+
+	// A new dataset gets a schema version and replication ID, which
+	// aren't counted as keys and don't get the default TTL
+	assert.Equal(t, "1", ps.Meta(MetaSchemaVersion))
+	id := ps.Meta(MetaReplicationID)
+	assert.Len(t, id, 40)
+	assert.Equal(t, 0, ps.Len())
+	assert.Equal(t, int64(-1), ps.TTL(ReservedPrefix+MetaReplicationID))
+	assert.Error(t, ps.SetMeta("unknown", "v"))
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, id, ps.Meta(MetaReplicationID))
+
+	// Data from a newer build is refused
+	require.NoError(t, ps.SetMeta(MetaSchemaVersion, "99"))
+	require.NoError(t, ps.Close())
+	_, err = NewPersistentStore(cfg)
+	assert.ErrorContains(t, err, "schema version 99")
+}
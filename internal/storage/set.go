@@ -0,0 +1,384 @@
+package storage
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// setSize returns the bytes held by a set's members, counted against
+// max_value_bytes and reported as its size
+func setSize(set map[string]struct{}) int {
+	size := 0
+	for member := range set {
+		size += len(member)
+	}
+	return size
+}
+
+// SetMembers returns the members of set sorted
+func SetMembers(set map[string]struct{}) []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// setOf returns a set of members
+func setOf(members []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(members))
+	for _, m := range members {
+		set[m] = struct{}{}
+	}
+	return set
+}
+
+// copySet returns a copy of set with room for extra more members
+func copySet(set map[string]struct{}, extra int) map[string]struct{} {
+	copied := make(map[string]struct{}, len(set)+extra)
+	for member := range set {
+		copied[member] = struct{}{}
+	}
+	return copied
+}
+
+// encodeMembers encodes set members as held by SADD and SREM records and
+// snapshots: count(4), then length(4) + member for each member
+func encodeMembers(members []string) []byte {
+	size := 4
+	for _, m := range members {
+		size += 4 + len(m)
+	}
+	data := make([]byte, 0, size)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(members)))
+	for _, m := range members {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(m)))
+		data = append(data, m...)
+	}
+	return data
+}
+
+// DecodeMembers decodes the members of an SADD or SREM record
+func DecodeMembers(data []byte) ([]string, error) {
+	errShort := errors.New("set members cut short")
+	if len(data) < 4 {
+		return nil, errShort
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	if uint64(count) > uint64(len(data)/4) {
+		return nil, errShort
+	}
+
+	members := make([]string, 0, count)
+	for i := uint32(0); i < count; i++ {
+		if len(data) < 4 {
+			return nil, errShort
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(len(data)-4) < uint64(n) {
+			return nil, errShort
+		}
+		members = append(members, string(data[4:4+n]))
+		data = data[4+n:]
+	}
+	if len(data) > 0 {
+		return nil, fmt.Errorf("%d bytes after the set members", len(data))
+	}
+	return members, nil
+}
+
+// SAdd adds members to the set at key, creating it if needed, and returns
+// the number that weren't already members and the set's version. A new
+// set gets the prefix's default TTL; an existing one keeps its expiry. It
+// fails with ErrWrongType if key holds another type.
+func (s *Store) SAdd(key string, members ...string) (int, uint64, error) {
+	if err := validateKey(key); err != nil {
+		return 0, 0, err
+	}
+	for _, m := range members {
+		if err := validateKey(m); err != nil {
+			return 0, 0, fmt.Errorf("member %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.CmdSAdd.Inc()
+
+	now := time.Now().UnixMilli()
+	existing, exists := s.data[key]
+	live := exists && !existing.IsExpired()
+	if live && existing.Set == nil {
+		return 0, 0, ErrWrongType
+	}
+
+	// Copy-on-write: readers may hold the current map
+	var set map[string]struct{}
+	var newVersion uint64 = 1
+	createdMs := now
+	var expiryMs int64
+	if live {
+		set = copySet(existing.Set, len(members))
+		newVersion = existing.Version + 1
+		createdMs = existing.CreatedMs
+		expiryMs = existing.ExpiryMs
+	} else {
+		set = make(map[string]struct{}, len(members))
+		var err error
+		expiryMs, err = s.ttlPolicy(key, -1, now)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	added := 0
+	for _, m := range members {
+		if _, ok := set[m]; !ok {
+			set[m] = struct{}{}
+			added++
+		}
+	}
+	if added == 0 {
+		if live {
+			return 0, existing.Version, nil
+		}
+		return 0, 0, nil
+	}
+	size := setSize(set)
+	if size > s.config.MaxValueBytesFor(key) {
+		return 0, 0, ErrValueTooLarge
+	}
+
+	s.put(key, &Entry{
+		Set:          set,
+		Version:      newVersion,
+		ExpiryMs:     expiryMs,
+		SizeBytes:    uint32(size),
+		CreatedMs:    createdMs,
+		UpdatedMs:    now,
+		lastAccessMs: now,
+	})
+	s.grew()
+
+	if expiryMs > 0 && !live {
+		heap.Push(s.expiryHeap, &ExpiryItem{Key: key, ExpiryMs: expiryMs})
+	}
+
+	return added, newVersion, nil
+}
+
+// SRem removes members from the set at key and returns the number removed
+// and the set's version. The key is deleted with its last member.
+func (s *Store) SRem(key string, members ...string) (int, uint64, error) {
+	if err := validateKey(key); err != nil {
+		return 0, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.CmdSAdd.Inc()
+
+	existing, exists := s.data[key]
+	if !exists || existing.IsExpired() {
+		return 0, 0, nil
+	}
+	if existing.Set == nil {
+		return 0, 0, ErrWrongType
+	}
+
+	set := copySet(existing.Set, 0)
+	removed := 0
+	for _, m := range members {
+		if _, ok := set[m]; ok {
+			delete(set, m)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, existing.Version, nil
+	}
+
+	newVersion := existing.Version + 1
+	if len(set) == 0 {
+		s.drop(key)
+		s.removed(key, EventDeleted)
+		return removed, newVersion, nil
+	}
+
+	updated := existing.clone()
+	updated.Set = set
+	updated.Version = newVersion
+	updated.SizeBytes = uint32(setSize(set))
+	updated.touch(time.Now().UnixMilli())
+	updated.UpdatedMs = updated.lastAccessMs
+	s.put(key, updated)
+
+	return removed, newVersion, nil
+}
+
+// GetSet returns the set at key, for SMEMBERS, SISMEMBER and SCARD. It
+// fails with ErrWrongType if key holds another type. The returned Entry
+// is a copy; its Set must not be modified.
+func (s *Store) GetSet(key string) (*Entry, error) {
+	entry, err := s.read(key, s.stats.CmdSRead)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Set == nil {
+		return nil, ErrWrongType
+	}
+	return entry, nil
+}
+
+// SAdd is Store.SAdd logged to the WAL as an SADD record of the members
+// added
+func (ps *PersistentStore) SAdd(key string, members ...string) (int, uint64, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	prev, _ := ps.Store.peek(key)
+
+	added, version, err := ps.Store.SAdd(key, members...)
+	if err != nil || added == 0 {
+		return added, version, err
+	}
+
+	var before map[string]struct{}
+	if prev != nil {
+		before = prev.Set
+	}
+	var newMembers []string
+	seen := make(map[string]bool, added)
+	for _, m := range members {
+		if _, ok := before[m]; !ok && !seen[m] {
+			newMembers = append(newMembers, m)
+			seen[m] = true
+		}
+	}
+	entry := ps.Store.lookup(key)
+	record := &WALRecord{
+		Type:      RecordTypeSADD,
+		Key:       key,
+		Value:     encodeMembers(newMembers),
+		ExpiryMs:  entry.ExpiryMs,
+		Version:   entry.Version,
+		CreatedMs: entry.CreatedMs,
+		UpdatedMs: entry.UpdatedMs,
+	}
+
+	if err := ps.appendRecord(record, false); err != nil {
+		// Rollback
+		ps.Store.restore(key, prev)
+		return 0, 0, fmt.Errorf("WAL write failed: %w", err)
+	}
+
+	return added, version, nil
+}
+
+// SRem is Store.SRem logged to the WAL as an SREM record of the members
+// removed
+func (ps *PersistentStore) SRem(key string, members ...string) (int, uint64, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	prev, _ := ps.Store.peek(key)
+
+	removed, version, err := ps.Store.SRem(key, members...)
+	if err != nil || removed == 0 {
+		return removed, version, err
+	}
+
+	var gone []string
+	seen := make(map[string]bool, removed)
+	for _, m := range members {
+		if _, ok := prev.Set[m]; ok && !seen[m] {
+			gone = append(gone, m)
+			seen[m] = true
+		}
+	}
+	record := &WALRecord{
+		Type:      RecordTypeSREM,
+		Key:       key,
+		Value:     encodeMembers(gone),
+		Version:   version,
+		UpdatedMs: time.Now().UnixMilli(),
+	}
+
+	if err := ps.appendRecord(record, false); err != nil {
+		// Rollback
+		ps.Store.restore(key, prev)
+		return 0, 0, fmt.Errorf("WAL write failed: %w", err)
+	}
+
+	return removed, version, nil
+}
+
+// applySetAddRecord applies an SADD record during recovery. The record
+// replaces another type at the key, as the write it logs could only have
+// been made once that value was gone.
+func (ps *PersistentStore) applySetAddRecord(record *WALRecord) error {
+	members, err := DecodeMembers(record.Value)
+	if err != nil {
+		return fmt.Errorf("SADD record for %s: %w", record.Key, err)
+	}
+
+	key := ps.NormalizeKey(record.Key)
+	var set map[string]struct{}
+	if existing, exists := ps.Store.data[key]; exists {
+		set = copySet(existing.Set, len(members))
+	} else {
+		set = make(map[string]struct{}, len(members))
+	}
+	for _, m := range members {
+		set[m] = struct{}{}
+	}
+
+	ps.Store.put(key, &Entry{
+		Set:       set,
+		Version:   record.Version,
+		ExpiryMs:  record.ExpiryMs,
+		SizeBytes: uint32(setSize(set)),
+		CreatedMs: record.CreatedMs,
+		UpdatedMs: record.UpdatedMs,
+	})
+	ps.Store.grew()
+	return nil
+}
+
+// applySetRemRecord applies an SREM record during recovery
+func (ps *PersistentStore) applySetRemRecord(record *WALRecord) error {
+	members, err := DecodeMembers(record.Value)
+	if err != nil {
+		return fmt.Errorf("SREM record for %s: %w", record.Key, err)
+	}
+
+	key := ps.NormalizeKey(record.Key)
+	existing, exists := ps.Store.data[key]
+	if !exists || existing.Set == nil {
+		return nil
+	}
+	set := copySet(existing.Set, 0)
+	for _, m := range members {
+		delete(set, m)
+	}
+	if len(set) == 0 {
+		ps.Store.drop(key)
+		return nil
+	}
+
+	updated := existing.clone()
+	updated.Set = set
+	updated.Version = record.Version
+	updated.SizeBytes = uint32(setSize(set))
+	updated.UpdatedMs = record.UpdatedMs
+	ps.Store.put(key, updated)
+	return nil
+}
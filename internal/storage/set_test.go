@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Set(t *testing.T) {
+	store := newTestStore()
+
+	added, version, err := store.SAdd("tags", "go", "db", "go")
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+	assert.Equal(t, uint64(1), version)
+
+	// Adding only existing members changes nothing
+	added, version, err = store.SAdd("tags", "db")
+	require.NoError(t, err)
+	assert.Zero(t, added)
+	assert.Equal(t, uint64(1), version)
+
+	added, version, err = store.SAdd("tags", "db", "cache")
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, uint64(2), version)
+
+	entry, err := store.GetSet("tags")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cache", "db", "go"}, SetMembers(entry.Set))
+	assert.Equal(t, uint32(9), entry.SizeBytes)
+	assert.Equal(t, TypeSet, entry.Type())
+
+	// Sets, hashes and strings don't mix
+	_, err = store.Get("tags")
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, err = store.GetHash("tags")
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, _, err = store.HSet("tags", []HashField{{"a", nil}})
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, err = store.Incr("tags", 1)
+	assert.ErrorIs(t, err, ErrWrongType)
+	store.Set("s", []byte("v"), SetOptions{})
+	_, _, err = store.SAdd("s", "a")
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, err = store.GetSet("s")
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, _, err = store.SAdd("s2", "bad member")
+	assert.ErrorIs(t, err, ErrKeyInvalid)
+
+	removed, version, err := store.SRem("tags", "go", "missing", "go")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, uint64(3), version)
+
+	// Removing the last member deletes the key
+	removed, _, err = store.SRem("tags", "db", "cache")
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.False(t, store.Exists("tags"))
+
+	removed, _, err = store.SRem("tags", "go")
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+}
+
+func TestPersistentStore_SetRecovery(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, _, err = ps.SAdd("tags", "a", "b")
+	require.NoError(t, err)
+	_, _, err = ps.SAdd("tags", "b", "c")
+	require.NoError(t, err)
+	_, _, err = ps.SRem("tags", "a")
+	require.NoError(t, err)
+	_, _, err = ps.SAdd("gone", "a")
+	require.NoError(t, err)
+	_, _, err = ps.SRem("gone", "a")
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	entry, err := ps.GetSet("tags")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, SetMembers(entry.Set))
+	assert.Equal(t, uint64(3), entry.Version)
+	assert.Equal(t, uint32(2), entry.SizeBytes)
+	assert.False(t, ps.Exists("gone"))
+}
+
+func TestSnapshot_Set(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cfg := config.DefaultConfig()
+	cfg.DataDir = tempDir
+	cfg.SnapshotCompatFormat = 3
+
+	manager, err := NewSnapshotManager(cfg)
+	require.NoError(t, err)
+
+	store := New(cfg)
+	store.Set("s", []byte("v"), SetOptions{})
+	_, _, err = store.HSet("h", []HashField{{"a", []byte("1")}})
+	require.NoError(t, err)
+	_, _, err = store.SAdd("tags", "x", "y")
+	require.NoError(t, err)
+	require.NoError(t, manager.CreateSnapshot(store, "wal-00000001.oswal"))
+
+	newStore := New(cfg)
+	_, err = manager.LoadSnapshot(newStore)
+	require.NoError(t, err)
+	entry, err := newStore.GetSet("tags")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "y"}, SetMembers(entry.Set))
+	assert.Equal(t, uint32(2), entry.SizeBytes)
+
+	// Format 3 can't hold sets, so its copy leaves them out
+	var keys []string
+	info, err := VerifySnapshot(filepath.Join(tempDir, "format-3", "snap-00000001.osnap"), func(key string, entry *Entry) {
+		keys = append(keys, key)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(snapVersionV3), info.Version)
+	assert.ElementsMatch(t, []string{"h", "s"}, keys)
+}
@@ -12,34 +12,59 @@ import (
 
 const (
 	SnapMagic   = 0x4F535053 // 'OSPS'
-	SnapVersion = 1
+	SnapVersion = 5
+
+	// snapVersionV1 records lack the created/updated timestamps
+	snapVersionV1 = 1
+	// snapVersionV2 records lack the value type and hold only strings
+	snapVersionV2 = 2
+	// snapVersionV3 records hold strings and hashes
+	snapVersionV3 = 3
+	// snapVersionV4 records hold strings, hashes and sets
+	snapVersionV4 = 4
+)
+
+// Value types of version 3 and later snapshot records
+const (
+	snapTypeString = 0
+	snapTypeHash   = 1
+	snapTypeSet    = 2 // version 4 and later
+	snapTypeZSet   = 3 // version 5 and later
 )
 
 // Manifest represents the manifest file
 type Manifest struct {
-	Version   int    `json:"version"`
-	Snap      string `json:"snap"`
+	Version   int    `json:"version"` // on-disk format, see DataFormat
+	Snap      string `json:"snap"`    // "" until the first snapshot
 	NextWAL   string `json:"next_wal"`
 	CreatedMs int64  `json:"created_ms"`
 }
 
 // SnapshotWriter writes snapshot files
 type SnapshotWriter struct {
-	file   *os.File
-	writer io.Writer
-	count  uint64
+	file    *os.File
+	writer  io.Writer
+	version uint16
+	count   uint64
 }
 
 // NewSnapshotWriter creates a new snapshot writer
 func NewSnapshotWriter(path string) (*SnapshotWriter, error) {
+	return newSnapshotWriter(path, SnapVersion)
+}
+
+// newSnapshotWriter creates a snapshot writer for an older record version,
+// readable by earlier releases
+func newSnapshotWriter(path string, version uint16) (*SnapshotWriter, error) {
 	file, err := os.Create(path)
 	if err != nil {
 		return nil, err
 	}
 
 	sw := &SnapshotWriter{
-		file:   file,
-		writer: file,
+		file:    file,
+		writer:  file,
+		version: version,
 	}
 
 	// Write header
@@ -56,7 +81,7 @@ func (sw *SnapshotWriter) writeHeader() error {
 	header := make([]byte, 14) // magic(4) + version(2) + count(8)
 
 	binary.LittleEndian.PutUint32(header[0:4], SnapMagic)
-	binary.LittleEndian.PutUint16(header[4:6], SnapVersion)
+	binary.LittleEndian.PutUint16(header[4:6], sw.version)
 	// Count will be updated at the end
 	binary.LittleEndian.PutUint64(header[6:14], 0)
 
@@ -68,13 +93,25 @@ func (sw *SnapshotWriter) writeHeader() error {
 func (sw *SnapshotWriter) WriteEntry(key string, entry *Entry) error {
 	keyBytes := []byte(key)
 
-	// Skip expired entries
-	if entry.IsExpired() {
+	// Skip expired entries, and the types older versions can't hold
+	if entry.IsExpired() || (entry.Hash != nil && sw.version < 3) || (entry.Set != nil && sw.version < 4) ||
+		(entry.ZSet != nil && sw.version < 5) {
 		return nil
 	}
 
+	value, valueType := entry.Value, byte(snapTypeString)
+	switch {
+	case entry.Hash != nil:
+		value, valueType = encodeHashFields(hashFields(entry.Hash)), snapTypeHash
+	case entry.Set != nil:
+		value, valueType = encodeMembers(SetMembers(entry.Set)), snapTypeSet
+	case entry.ZSet != nil:
+		value, valueType = encodeZMembers(entry.ZSet.Members()), snapTypeZSet
+	}
+
 	// Calculate sizes
-	recordSize := 4 + 4 + 8 + 8 + len(keyBytes) + len(entry.Value) + 4
+	metaSize := snapMetaSize(sw.version)
+	recordSize := 4 + 4 + metaSize + len(keyBytes) + len(value) + 4
 	record := make([]byte, recordSize)
 
 	offset := 0
@@ -84,7 +121,7 @@ func (sw *SnapshotWriter) WriteEntry(key string, entry *Entry) error {
 	offset += 4
 
 	// Value length
-	binary.LittleEndian.PutUint32(record[offset:], uint32(len(entry.Value)))
+	binary.LittleEndian.PutUint32(record[offset:], uint32(len(value)))
 	offset += 4
 
 	// Expiry
@@ -95,13 +132,27 @@ func (sw *SnapshotWriter) WriteEntry(key string, entry *Entry) error {
 	binary.LittleEndian.PutUint64(record[offset:], entry.Version)
 	offset += 8
 
+	// Timestamps
+	if sw.version >= 2 {
+		binary.LittleEndian.PutUint64(record[offset:], uint64(entry.CreatedMs))
+		offset += 8
+		binary.LittleEndian.PutUint64(record[offset:], uint64(entry.UpdatedMs))
+		offset += 8
+	}
+
+	// Value type
+	if sw.version >= 3 {
+		record[offset] = valueType
+		offset++
+	}
+
 	// Key
 	copy(record[offset:], keyBytes)
 	offset += len(keyBytes)
 
 	// Value
-	copy(record[offset:], entry.Value)
-	offset += len(entry.Value)
+	copy(record[offset:], value)
+	offset += len(value)
 
 	// CRC32C
 	crc := crc32.Checksum(record[:offset], crc32.MakeTable(crc32.Castagnoli))
@@ -116,6 +167,19 @@ func (sw *SnapshotWriter) WriteEntry(key string, entry *Entry) error {
 	return nil
 }
 
+// snapMetaSize returns the size of the metadata following the lengths of
+// a record: expiry(8) + version(8) [+ created(8) + updated(8)] [+ type(1)]
+func snapMetaSize(version uint16) int {
+	switch {
+	case version >= 3:
+		return 33
+	case version >= 2:
+		return 32
+	default:
+		return 16
+	}
+}
+
 // Close finalizes and closes the snapshot
 func (sw *SnapshotWriter) Close() error {
 	// Update count in header
@@ -139,10 +203,11 @@ func (sw *SnapshotWriter) Close() error {
 
 // SnapshotReader reads snapshot files
 type SnapshotReader struct {
-	file   *os.File
-	reader io.Reader
-	count  uint64
-	read   uint64
+	file    *os.File
+	reader  io.Reader
+	version uint16
+	count   uint64
+	read    uint64
 }
 
 // OpenSnapshotReader opens a snapshot file for reading
@@ -179,10 +244,12 @@ func (sr *SnapshotReader) readHeader() error {
 	}
 
 	version := binary.LittleEndian.Uint16(header[4:6])
-	if version != SnapVersion {
+	if version != snapVersionV1 && version != snapVersionV2 && version != snapVersionV3 && version != snapVersionV4 &&
+		version != SnapVersion {
 		return fmt.Errorf("unsupported snapshot version: %d", version)
 	}
 
+	sr.version = version
 	sr.count = binary.LittleEndian.Uint64(header[6:14])
 	return nil
 }
@@ -202,8 +269,7 @@ func (sr *SnapshotReader) ReadEntry() (string, *Entry, error) {
 	keyLen := binary.LittleEndian.Uint32(lengths[0:4])
 	valLen := binary.LittleEndian.Uint32(lengths[4:8])
 
-	// Read metadata
-	metadata := make([]byte, 16)
+	metadata := make([]byte, snapMetaSize(sr.version))
 	if _, err := io.ReadFull(sr.reader, metadata); err != nil {
 		return "", nil, err
 	}
@@ -211,6 +277,12 @@ func (sr *SnapshotReader) ReadEntry() (string, *Entry, error) {
 	expiryMs := int64(binary.LittleEndian.Uint64(metadata[0:8]))
 	version := binary.LittleEndian.Uint64(metadata[8:16])
 
+	var createdMs, updatedMs int64
+	if sr.version >= 2 {
+		createdMs = int64(binary.LittleEndian.Uint64(metadata[16:24]))
+		updatedMs = int64(binary.LittleEndian.Uint64(metadata[24:32]))
+	}
+
 	// Read key
 	key := make([]byte, keyLen)
 	if _, err := io.ReadFull(sr.reader, key); err != nil {
@@ -233,25 +305,13 @@ func (sr *SnapshotReader) ReadEntry() (string, *Entry, error) {
 
 	expectedCRC := binary.LittleEndian.Uint32(crcBytes)
 
-	// Reconstruct data for CRC check
-	dataLen := 4 + 4 + 8 + 8 + len(key) + len(value)
-	data := make([]byte, dataLen)
-	offset := 0
-
-	binary.LittleEndian.PutUint32(data[offset:], keyLen)
-	offset += 4
-	binary.LittleEndian.PutUint32(data[offset:], valLen)
-	offset += 4
-	binary.LittleEndian.PutUint64(data[offset:], uint64(expiryMs))
-	offset += 8
-	binary.LittleEndian.PutUint64(data[offset:], version)
-	offset += 8
-	copy(data[offset:], key)
-	offset += len(key)
-	copy(data[offset:], value)
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	crc.Write(lengths)
+	crc.Write(metadata)
+	crc.Write(key)
+	crc.Write(value)
 
-	actualCRC := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
-	if actualCRC != expectedCRC {
+	if crc.Sum32() != expectedCRC {
 		return "", nil, fmt.Errorf("CRC mismatch in snapshot record")
 	}
 
@@ -260,6 +320,39 @@ func (sr *SnapshotReader) ReadEntry() (string, *Entry, error) {
 		Version:   version,
 		ExpiryMs:  expiryMs,
 		SizeBytes: uint32(len(value)),
+		CreatedMs: createdMs,
+		UpdatedMs: updatedMs,
+	}
+	if sr.version >= 3 {
+		switch valueType := metadata[32]; valueType {
+		case snapTypeString:
+		case snapTypeHash:
+			fields, err := DecodeHashFields(value)
+			if err != nil {
+				return "", nil, err
+			}
+			entry.Value = nil
+			entry.Hash = hashMap(fields)
+			entry.SizeBytes = uint32(hashSize(entry.Hash))
+		case snapTypeSet:
+			members, err := DecodeMembers(value)
+			if err != nil {
+				return "", nil, err
+			}
+			entry.Value = nil
+			entry.Set = setOf(members)
+			entry.SizeBytes = uint32(setSize(entry.Set))
+		case snapTypeZSet:
+			members, err := DecodeZMembers(value)
+			if err != nil {
+				return "", nil, err
+			}
+			entry.Value = nil
+			entry.ZSet = newSortedSet(members)
+			entry.SizeBytes = uint32(entry.ZSet.size())
+		default:
+			return "", nil, fmt.Errorf("unknown value type %d in snapshot record", valueType)
+		}
 	}
 
 	sr.read++
@@ -271,35 +364,66 @@ func (sr *SnapshotReader) Close() error {
 	return sr.file.Close()
 }
 
-// WriteManifest writes a manifest file
-func WriteManifest(dataDir string, manifest *Manifest) error {
-	// Write to temp file first
-	tempPath := filepath.Join(dataDir, "MANIFEST.tmp")
-	finalPath := filepath.Join(dataDir, "MANIFEST.json")
+// SnapshotInfo describes a snapshot checked by VerifySnapshot
+type SnapshotInfo struct {
+	Version uint16 // record version
+	Count   uint64 // entries the header declares
+}
 
-	data, err := json.MarshalIndent(manifest, "", "  ")
+// VerifySnapshot reads every entry of the snapshot at path, checking its
+// CRC and that the file holds exactly the entries its header declares. If
+// fn is not nil it is called with each entry, expired or not.
+func VerifySnapshot(path string, fn func(key string, entry *Entry)) (*SnapshotInfo, error) {
+	reader, err := OpenSnapshotReader(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer reader.Close()
 
-	// Write temp file
-	if err := os.WriteFile(tempPath, data, 0644); err != nil {
-		return err
+	info := &SnapshotInfo{Version: reader.version, Count: reader.count}
+	for {
+		key, entry, err := reader.ReadEntry()
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			return info, fmt.Errorf("entry %d of %d is cut short", reader.read+1, reader.count)
+		}
+		if err != nil {
+			return info, fmt.Errorf("entry %d of %d: %w", reader.read+1, reader.count, err)
+		}
+		if fn != nil {
+			fn(key, entry)
+		}
 	}
 
-	// Sync directory
-	dir, err := os.Open(dataDir)
+	if reader.read != reader.count {
+		return info, fmt.Errorf("file holds %d entries, header declares %d", reader.read, reader.count)
+	}
+	extra, err := io.Copy(io.Discard, reader.reader)
 	if err != nil {
-		return err
+		return info, err
 	}
-	defer dir.Close()
+	if extra > 0 {
+		return info, fmt.Errorf("%d bytes after the %d entries the header declares", extra, reader.count)
+	}
+	return info, nil
+}
 
-	if err := dir.Sync(); err != nil {
+// WriteManifest writes a manifest file
+func WriteManifest(dataDir string, manifest *Manifest) error {
+	return writeManifestFile(filepath.Join(dataDir, "MANIFEST.json"), manifest)
+}
+
+// writeManifestFile writes a manifest to path
+func writeManifestFile(path string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
 		return err
 	}
 
-	// Atomic rename
-	return os.Rename(tempPath, finalPath)
+	// Temp file, fsync, atomic rename, directory sync
+	return writeFileAtomic(path, data)
 }
 
 // ReadManifest reads the manifest file
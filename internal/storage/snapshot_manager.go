@@ -19,6 +19,7 @@ import (
 type SnapshotManager struct {
 	mu             sync.Mutex
 	dataDir        string
+	snapDir        string
 	config         *config.Config
 	snapIndex      int
 	lastSnapshotMs int64
@@ -27,8 +28,17 @@ type SnapshotManager struct {
 
 // NewSnapshotManager creates a new snapshot manager
 func NewSnapshotManager(cfg *config.Config) (*SnapshotManager, error) {
+	// MANIFEST.json lives in data_dir, snapshots in snapshot_dir
+	snapDir := cfg.SnapshotDirectory()
+	for _, dir := range []string{cfg.DataDir, snapDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
 	manager := &SnapshotManager{
 		dataDir:        cfg.DataDir,
+		snapDir:        snapDir,
 		config:         cfg,
 		lastSnapshotMs: time.Now().UnixMilli(),
 	}
@@ -88,7 +98,7 @@ func (sm *SnapshotManager) CreateSnapshot(store *Store, currentWAL string) error
 
 	// Create snapshot filename
 	snapFile := fmt.Sprintf("snap-%08d.osnap", sm.snapIndex)
-	snapPath := filepath.Join(sm.dataDir, snapFile)
+	snapPath := filepath.Join(sm.snapDir, snapFile)
 
 	// Create temp file first
 	tempPath := snapPath + ".tmp"
@@ -103,7 +113,7 @@ func (sm *SnapshotManager) CreateSnapshot(store *Store, currentWAL string) error
 	count := 0
 	store.mu.RLock()
 	for key, entry := range store.data {
-		if !entry.IsExpired() {
+		if !entry.IsExpired() && !store.config.EphemeralFor(key) {
 			if err := writer.WriteEntry(key, entry); err != nil {
 				store.mu.RUnlock()
 				writer.Close()
@@ -122,14 +132,14 @@ func (sm *SnapshotManager) CreateSnapshot(store *Store, currentWAL string) error
 	}
 
 	// Rename to final name
-	if err := os.Rename(tempPath, snapPath); err != nil {
+	if err := renameDurable(tempPath, snapPath); err != nil {
 		os.Remove(tempPath)
 		return fmt.Errorf("failed to rename snapshot: %w", err)
 	}
 
 	// Write manifest
 	manifest := &Manifest{
-		Version:   1,
+		Version:   DataFormat,
 		Snap:      snapFile,
 		NextWAL:   currentWAL,
 		CreatedMs: time.Now().UnixMilli(),
@@ -142,25 +152,93 @@ func (sm *SnapshotManager) CreateSnapshot(store *Store, currentWAL string) error
 	duration := time.Since(startTime)
 	log.Printf("Snapshot %d completed: %d entries in %v", sm.snapIndex, count, duration)
 
+	if format := sm.config.SnapshotCompatFormat; format > 0 {
+		// Only a fallback, so a failure doesn't fail the snapshot
+		if err := sm.writeCompat(format, snapFile, currentWAL); err != nil {
+			log.Printf("Failed to write format %d copy of snapshot %d: %v", format, sm.snapIndex, err)
+		}
+	}
+
 	sm.snapIndex++
 	sm.lastSnapshotMs = time.Now().UnixMilli()
 
 	return nil
 }
 
+// compatDir names the directory, under snapshot_dir and data_dir, holding
+// the snapshot and manifest written for an older data format
+func compatDir(format int) string {
+	return fmt.Sprintf("format-%d", format)
+}
+
+// writeCompat copies snapFile into compatDir in an older data format, with
+// a manifest an earlier release can load once copied over MANIFEST.json.
+// Callers hold sm.mu.
+func (sm *SnapshotManager) writeCompat(format int, snapFile, currentWAL string) error {
+	dir := compatDir(format)
+	for _, d := range []string{filepath.Join(sm.snapDir, dir), filepath.Join(sm.dataDir, dir)} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+
+	// Each format's snapshots use the record version of the same number;
+	// older ones leave out the value types they can't hold
+	compatPath := filepath.Join(sm.snapDir, dir, snapFile)
+	if err := copySnapshot(filepath.Join(sm.snapDir, snapFile), compatPath+".tmp", uint16(format)); err != nil {
+		return err
+	}
+	if err := renameDurable(compatPath+".tmp", compatPath); err != nil {
+		os.Remove(compatPath + ".tmp")
+		return err
+	}
+
+	manifest := &Manifest{
+		Version:   format,
+		Snap:      filepath.ToSlash(filepath.Join(dir, snapFile)),
+		NextWAL:   currentWAL,
+		CreatedMs: time.Now().UnixMilli(),
+	}
+	if err := writeManifestFile(filepath.Join(sm.dataDir, dir, "MANIFEST.json"), manifest); err != nil {
+		return err
+	}
+
+	// Only the newest copy is kept
+	files, err := os.ReadDir(filepath.Join(sm.snapDir, dir))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.Name() != snapFile && strings.HasPrefix(file.Name(), "snap-") {
+			os.Remove(filepath.Join(sm.snapDir, dir, file.Name()))
+		}
+	}
+	return nil
+}
+
 // LoadSnapshot loads the latest snapshot
 func (sm *SnapshotManager) LoadSnapshot(store *Store) (string, error) {
+	return sm.loadSnapshot(store, nil)
+}
+
+// loadSnapshot is LoadSnapshot loading only the keys keep accepts, or
+// every key if keep is nil. It holds store.mu only to add each entry.
+func (sm *SnapshotManager) loadSnapshot(store *Store, keep func(key string) bool) (string, error) {
 	manifest, err := ReadManifest(sm.dataDir)
 	if err != nil {
 		return "", err
 	}
 
-	if manifest == nil {
+	if manifest == nil || manifest.Snap == "" {
 		// No snapshot yet
 		return "", nil
 	}
 
-	snapPath := filepath.Join(sm.dataDir, manifest.Snap)
+	snapPath := filepath.Join(sm.snapDir, manifest.Snap)
+	if _, err := os.Stat(snapPath); os.IsNotExist(err) && sm.snapDir != sm.dataDir {
+		// Written before snapshot_dir was set
+		snapPath = filepath.Join(sm.dataDir, manifest.Snap)
+	}
 	reader, err := OpenSnapshotReader(snapPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to open snapshot: %w", err)
@@ -180,8 +258,14 @@ func (sm *SnapshotManager) LoadSnapshot(store *Store) (string, error) {
 		}
 
 		// Skip expired entries
-		if !entry.IsExpired() {
-			store.data[key] = entry
+		if !entry.IsExpired() && (keep == nil || keep(key)) {
+			store.mu.Lock()
+			if entry.isString() {
+				entry.Value, entry.slab = store.arenaValue(entry.Value)
+			}
+			store.put(key, entry)
+			store.grew()
+			store.mu.Unlock()
 			count++
 		}
 	}
@@ -205,7 +289,7 @@ func (sm *SnapshotManager) CleanupOldFiles(keepFromWAL string) error {
 	// Keep only the latest snapshot
 	if len(snapFiles) > 1 {
 		for i := 0; i < len(snapFiles)-1; i++ {
-			path := filepath.Join(sm.dataDir, snapFiles[i])
+			path := filepath.Join(sm.snapDir, snapFiles[i])
 			if err := os.Remove(path); err != nil {
 				log.Printf("Failed to remove old snapshot %s: %v", snapFiles[i], err)
 			} else {
@@ -219,7 +303,7 @@ func (sm *SnapshotManager) CleanupOldFiles(keepFromWAL string) error {
 
 // listSnapshotFiles lists all snapshot files in order
 func (sm *SnapshotManager) listSnapshotFiles() ([]string, error) {
-	files, err := os.ReadDir(sm.dataDir)
+	files, err := os.ReadDir(sm.snapDir)
 	if err != nil {
 		return nil, err
 	}
@@ -247,13 +331,13 @@ func (sm *SnapshotManager) extractSnapIndex(filename string) (int, error) {
 	return strconv.Atoi(indexStr)
 }
 
-// GetStats returns snapshot statistics
-func (sm *SnapshotManager) GetStats() map[string]string {
-	stats := make(map[string]string)
-
+// SnapshotCount returns the number of snapshot files on disk
+func (sm *SnapshotManager) SnapshotCount() int64 {
 	snapFiles, _ := sm.listSnapshotFiles()
-	stats["snapshots_total"] = strconv.Itoa(len(snapFiles))
-	stats["last_snapshot_ms"] = strconv.FormatInt(sm.lastSnapshotMs, 10)
+	return int64(len(snapFiles))
+}
 
-	return stats
+// LastSnapshotMs returns when the last snapshot completed
+func (sm *SnapshotManager) LastSnapshotMs() int64 {
+	return sm.lastSnapshotMs
 }
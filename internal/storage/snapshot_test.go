@@ -25,6 +25,8 @@ func TestSnapshot_WriteRead(t *testing.T) {
 			Version:   1,
 			ExpiryMs:  -1,
 			SizeBytes: 6,
+			CreatedMs: 1700000000000,
+			UpdatedMs: 1700000005000,
 		},
 		"key2": {
 			Value:     []byte("value2"),
@@ -75,6 +77,8 @@ func TestSnapshot_WriteRead(t *testing.T) {
 		assert.Equal(t, expected.Version, actual.Version)
 		assert.Equal(t, expected.ExpiryMs, actual.ExpiryMs)
 		assert.Equal(t, expected.SizeBytes, actual.SizeBytes)
+		assert.Equal(t, expected.CreatedMs, actual.CreatedMs)
+		assert.Equal(t, expected.UpdatedMs, actual.UpdatedMs)
 	}
 }
 
@@ -237,7 +241,7 @@ func TestSnapshotManager_Create(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, manifest)
 
-	assert.Equal(t, 1, manifest.Version)
+	assert.Equal(t, DataFormat, manifest.Version)
 	assert.Equal(t, "snap-00000001.osnap", manifest.Snap)
 	assert.Equal(t, "wal-00000001.oswal", manifest.NextWAL)
 
@@ -302,3 +306,205 @@ func TestSnapshotManager_NeedsSnapshot(t *testing.T) {
 	// Should not need snapshot if both conditions are fine
 	assert.False(t, manager.NeedsSnapshot(500, 800, 100))
 }
+
+func TestSnapshotManager_SeparateDir(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cfg := config.DefaultConfig()
+	cfg.DataDir = filepath.Join(tempDir, "data")
+	cfg.SnapshotDir = filepath.Join(tempDir, "snaps")
+
+	manager, err := NewSnapshotManager(cfg)
+	require.NoError(t, err)
+
+	store := New(cfg)
+	store.Set("key1", []byte("value1"), SetOptions{})
+	require.NoError(t, manager.CreateSnapshot(store, "wal-00000001.oswal"))
+
+	// Snapshot goes to snapshot_dir, manifest stays in data_dir
+	_, err = os.Stat(filepath.Join(cfg.SnapshotDir, "snap-00000001.osnap"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(cfg.DataDir, "snap-00000001.osnap"))
+	assert.True(t, os.IsNotExist(err))
+	manifest, err := ReadManifest(cfg.DataDir)
+	require.NoError(t, err)
+	require.NotNil(t, manifest)
+
+	newStore := New(cfg)
+	_, err = manager.LoadSnapshot(newStore)
+	require.NoError(t, err)
+	entry, err := newStore.Get("key1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value1"), entry.Value)
+}
+
+func TestSnapshotManager_LoadFromDataDirFallback(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cfg := config.DefaultConfig()
+	cfg.DataDir = tempDir
+
+	manager, err := NewSnapshotManager(cfg)
+	require.NoError(t, err)
+
+	store := New(cfg)
+	store.Set("key1", []byte("value1"), SetOptions{})
+	require.NoError(t, manager.CreateSnapshot(store, "wal-00000001.oswal"))
+
+	// A snapshot taken before snapshot_dir was configured is still found
+	cfg.SnapshotDir = filepath.Join(tempDir, "snaps")
+	manager, err = NewSnapshotManager(cfg)
+	require.NoError(t, err)
+
+	newStore := New(cfg)
+	_, err = manager.LoadSnapshot(newStore)
+	require.NoError(t, err)
+	entry, err := newStore.Get("key1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value1"), entry.Value)
+}
+
+func TestSnapshotManager_CompatFormat(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cfg := config.DefaultConfig()
+	cfg.DataDir = tempDir
+	cfg.SnapshotCompatFormat = 1
+
+	manager, err := NewSnapshotManager(cfg)
+	require.NoError(t, err)
+
+	store := New(cfg)
+	store.Set("key1", []byte("value1"), SetOptions{})
+	require.NoError(t, manager.CreateSnapshot(store, "wal-00000001.oswal"))
+	store.Set("key2", []byte("value2"), SetOptions{})
+	require.NoError(t, manager.CreateSnapshot(store, "wal-00000002.oswal"))
+
+	// Only the newest copy is kept, in the old record version
+	files, err := os.ReadDir(filepath.Join(tempDir, "format-1"))
+	require.NoError(t, err)
+	var snaps []string
+	for _, file := range files {
+		snaps = append(snaps, file.Name())
+	}
+	assert.Equal(t, []string{"MANIFEST.json", "snap-00000002.osnap"}, snaps)
+
+	reader, err := OpenSnapshotReader(filepath.Join(tempDir, "format-1", "snap-00000002.osnap"))
+	require.NoError(t, err)
+	assert.Equal(t, uint16(snapVersionV1), reader.version)
+	reader.Close()
+
+	compat, err := ReadManifest(filepath.Join(tempDir, "format-1"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, compat.Version)
+	assert.Equal(t, "format-1/snap-00000002.osnap", compat.Snap)
+	assert.Equal(t, "wal-00000002.oswal", compat.NextWAL)
+
+	// Rolling back: the copied manifest loads the old-format snapshot
+	require.NoError(t, WriteManifest(tempDir, compat))
+	newStore := New(cfg)
+	nextWAL, err := manager.LoadSnapshot(newStore)
+	require.NoError(t, err)
+	assert.Equal(t, "wal-00000002.oswal", nextWAL)
+	entry, err := newStore.Get("key2")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value2"), entry.Value)
+}
+
+func TestPersistentStore_Compact(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = ps.Set("key", []byte("value"), SetOptions{})
+		require.NoError(t, err)
+		require.NoError(t, ps.Snapshot())
+	}
+	require.NoError(t, ps.Compact())
+	require.NoError(t, ps.Close())
+
+	// The WAL the snapshot names is empty and nothing older is left
+	manifest, err := ReadManifest(cfg.DataDir)
+	require.NoError(t, err)
+	walFiles, err := listWALFilesIn(cfg.DataDir)
+	require.NoError(t, err)
+	assert.Equal(t, manifest.NextWAL, walFiles[0])
+	info, err := os.Stat(filepath.Join(cfg.DataDir, manifest.NextWAL))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	entry, err := ps.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), entry.Version)
+}
+
+func TestPersistentStore_WaitSnapshotPause(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+
+	// Nothing to wait for
+	assert.True(t, ps.WaitSnapshotPause(0))
+
+	ps.beginPause()
+	assert.True(t, ps.IsSnapshotPaused())
+	assert.False(t, ps.WaitSnapshotPause(10*time.Millisecond))
+
+	waited := make(chan bool)
+	go func() { waited <- ps.WaitSnapshotPause(5 * time.Second) }()
+	time.Sleep(10 * time.Millisecond)
+	ps.endPause()
+	assert.True(t, <-waited)
+	assert.False(t, ps.IsSnapshotPaused())
+}
+
+func TestVerifySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.osnap")
+	writer, err := NewSnapshotWriter(path)
+	require.NoError(t, err)
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, writer.WriteEntry(key, &Entry{Value: []byte("value-" + key), ExpiryMs: -1, Version: 1}))
+	}
+	require.NoError(t, writer.Close())
+	good, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var keys []string
+	info, err := VerifySnapshot(path, func(key string, entry *Entry) { keys = append(keys, key) })
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), info.Count)
+	assert.Equal(t, uint16(SnapVersion), info.Version)
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+
+	// A flipped value byte, keeping the CRC
+	corrupt := append([]byte{}, good...)
+	corrupt[len(corrupt)-5] ^= 0xff
+
+	for name, tc := range map[string]struct {
+		data []byte
+		err  string
+	}{
+		"corrupt":   {corrupt, "CRC mismatch"},
+		"cut short": {good[:len(good)-3], "cut short"},
+		"trailing":  {append(append([]byte{}, good...), 1, 2), "2 bytes after the 3 entries"},
+	} {
+		require.NoError(t, os.WriteFile(path, tc.data, 0644))
+		_, err := VerifySnapshot(path, nil)
+		assert.ErrorContains(t, err, tc.err, name)
+	}
+
+	// A header declaring more entries than the file holds
+	short := append([]byte{}, good...)
+	short[6]++
+	require.NoError(t, os.WriteFile(path, short, 0644))
+	_, err = VerifySnapshot(path, nil)
+	assert.ErrorContains(t, err, "file holds 3 entries, header declares 4")
+}
@@ -3,21 +3,28 @@ package storage
 import (
 	"container/heap"
 	"errors"
+	"math"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/metrics"
 )
 
 var (
 	ErrKeyNotFound     = errors.New("key not found")
 	ErrKeyExists       = errors.New("key already exists")
 	ErrVersionMismatch = errors.New("version mismatch")
+	ErrValueMismatch   = errors.New("value mismatch")
 	ErrNotInteger      = errors.New("value is not an integer")
+	ErrNotFloat        = errors.New("value is not a valid float")
+	ErrFloatOverflow   = errors.New("increment would produce NaN or Infinity")
+	ErrWrongType       = errors.New("key holds a different type of value")
 	ErrKeyTooLarge     = errors.New("key too large")
 	ErrValueTooLarge   = errors.New("value too large")
 	ErrKeyInvalid      = errors.New("key contains invalid characters")
+	ErrTTLTooLong      = errors.New("TTL exceeds max_ttl_ms")
 )
 
 // validateKey checks if a key contains invalid characters (ASCII spaces or control chars)
@@ -36,23 +43,61 @@ func validateKey(key string) error {
 type Store struct {
 	mu         sync.RWMutex
 	data       map[string]*Entry
+	keys       []string // every key in data, in no order, for RandomKey
+	userKeys   int      // keys in data other than server metadata
+	ephemeral  int      // keys in data under an ephemeral prefix rule
 	expiryHeap *ExpiryHeap
 	config     *config.Config
 
-	// Statistics
-	stats Stats
+	// Largest len(data) since the map was last rebuilt, see Defrag
+	peakKeys int
+
+	arena *valueArena // nil unless value_storage = "arena"
+
+	// Called with s.mu held when a key expires or is deleted, if set
+	notify func(key, event string)
+
+	// Prefixes whose keys and bytes are counted, see TrackUsage
+	tracked []trackedPrefix
+
+	// Digests of every key and of the keys under digest_prefixes, see fold
+	digest  Digest
+	digests []prefixDigest
+
+	// Statistics, registered in metrics
+	metrics *metrics.Registry
+	stats   Stats
 }
 
-// Stats holds runtime statistics
+// Stats holds the store's counters
 type Stats struct {
-	mu           sync.RWMutex
-	CmdGet       uint64
-	CmdSet       uint64
-	CmdDel       uint64
-	CmdIncr      uint64
-	ExpiredTotal uint64
-	EvictedTotal uint64
+	CmdGet       *metrics.Counter
+	CmdSet       *metrics.Counter
+	CmdDel       *metrics.Counter
+	CmdGetDel    *metrics.Counter
+	CmdGetEx     *metrics.Counter
+	CmdAppend    *metrics.Counter
+	CmdGetRange  *metrics.Counter
+	CmdSetRange  *metrics.Counter
+	CmdIncr      *metrics.Counter
+	CmdHSet      *metrics.Counter
+	CmdHGet      *metrics.Counter
+	CmdSAdd      *metrics.Counter
+	CmdSRead     *metrics.Counter
+	CmdZAdd      *metrics.Counter
+	CmdZRange    *metrics.Counter
+	CmdPFAdd     *metrics.Counter
+	CmdPFCount   *metrics.Counter
+	CmdTouch     *metrics.Counter
+	CmdMDel      *metrics.Counter
+	ExpiredTotal *metrics.Counter
+	EvictedTotal *metrics.Counter
 	StartTimeMs  int64
+
+	DefragRuns           *metrics.Counter
+	DefragReclaimedSlots *metrics.Counter
+	DefragReclaimedBytes *metrics.Counter
+	DefragLastPauseUs    *metrics.Gauge
 }
 
 // New creates a new Store instance
@@ -61,16 +106,36 @@ func New(cfg *config.Config) *Store {
 		data:       make(map[string]*Entry),
 		expiryHeap: &ExpiryHeap{},
 		config:     cfg,
-		stats: Stats{
-			StartTimeMs: time.Now().UnixMilli(),
-		},
+		metrics:    metrics.NewRegistry(),
+	}
+	for _, prefix := range cfg.DigestPrefixes {
+		s.digests = append(s.digests, prefixDigest{prefix: prefix})
+	}
+	s.registerMetrics()
+	if cfg.ValueStorage == ValueStorageArena {
+		s.arena = newValueArena(cfg.ArenaSlabBytes, s.metrics)
+		s.registerArenaMetrics()
 	}
 	heap.Init(s.expiryHeap)
 	return s
 }
 
-// Get retrieves a value by key, checking for expiry
+// Get retrieves a value by key, checking for expiry.
+// The returned Entry is a copy; its Value must not be modified.
 func (s *Store) Get(key string) (*Entry, error) {
+	entry, err := s.read(key, s.stats.CmdGet)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.isString() {
+		return nil, ErrWrongType
+	}
+	return entry, nil
+}
+
+// read returns a copy of the live entry for key of any type, counting the
+// read in counter and recording an access. Expired keys are deleted.
+func (s *Store) read(key string, counter *metrics.Counter) (*Entry, error) {
 	if err := validateKey(key); err != nil {
 		return nil, err
 	}
@@ -78,7 +143,7 @@ func (s *Store) Get(key string) (*Entry, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	s.stats.CmdGet++
+	counter.Inc()
 
 	entry, exists := s.data[key]
 	if !exists {
@@ -93,8 +158,9 @@ func (s *Store) Get(key string) (*Entry, error) {
 		// Re-check after acquiring write lock
 		entry, exists = s.data[key]
 		if exists && entry.IsExpired() {
-			delete(s.data, key)
-			s.stats.ExpiredTotal++
+			s.drop(key)
+			s.stats.ExpiredTotal.Inc()
+			s.removed(key, EventExpired)
 		}
 
 		s.mu.Unlock()
@@ -102,25 +168,137 @@ func (s *Store) Get(key string) (*Entry, error) {
 		return nil, ErrKeyNotFound
 	}
 
-	return entry, nil
+	entry.touch(time.Now().UnixMilli())
+	return entry.clone(), nil
+}
+
+// GetMeta returns a copy of the entry for key without counting a read or
+// recording an access, for metadata-only lookups
+func (s *Store) GetMeta(key string) (*Entry, error) {
+	return s.peek(key)
+}
+
+// peek returns a copy of the live entry for key without counting a read
+// or recording an access
+func (s *Store) peek(key string) (*Entry, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, exists := s.data[key]
+	if !exists || entry.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	return entry.clone(), nil
+}
+
+// lookup returns a copy of the entry stored for key, even if it has
+// already expired, or nil. Used to persist a write that was just applied,
+// such as a SET with a PXAT in the past.
+func (s *Store) lookup(key string) *Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if entry, exists := s.data[key]; exists {
+		return entry.clone()
+	}
+	return nil
+}
+
+// restore reinstates a previous entry for key, or removes the key if prev
+// is nil. Used to roll back an in-memory change when persisting it fails.
+func (s *Store) restore(key string, prev *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if prev == nil {
+		s.drop(key)
+		return
+	}
+	s.put(key, prev)
+}
+
+// put stores entry under key, keeping s.keys and the key counts in step
+// with s.data. The caller holds s.mu for writing.
+func (s *Store) put(key string, entry *Entry) {
+	if old, exists := s.data[key]; exists {
+		entry.slot = old.slot
+		s.account(key, old, -1)
+		s.fold(key, old, -1)
+	} else {
+		entry.slot = len(s.keys)
+		s.keys = append(s.keys, key)
+		if !IsReserved(key) {
+			s.userKeys++
+		}
+		if s.config.EphemeralFor(key) {
+			s.ephemeral++
+		}
+	}
+	s.account(key, entry, 1)
+	s.fold(key, entry, 1)
+	s.data[key] = entry
+}
+
+// drop removes key, if present, moving the last key in s.keys into its
+// slot. The caller holds s.mu for writing.
+func (s *Store) drop(key string) {
+	entry, exists := s.data[key]
+	if !exists {
+		return
+	}
+	delete(s.data, key)
+	if !IsReserved(key) {
+		s.userKeys--
+	}
+	if s.config.EphemeralFor(key) {
+		s.ephemeral--
+	}
+	s.account(key, entry, -1)
+	s.fold(key, entry, -1)
+
+	last := len(s.keys) - 1
+	if entry.slot != last {
+		moved := s.keys[last]
+		s.keys[entry.slot] = moved
+		s.data[moved].slot = entry.slot
+	}
+	s.keys[last] = ""
+	s.keys = s.keys[:last]
 }
 
 // Set stores a key-value pair with optional expiry and conditions
 func (s *Store) Set(key string, value []byte, opts SetOptions) (uint64, error) {
-	if len(key) > s.config.MaxKeyBytes {
-		return 0, ErrKeyTooLarge
-	}
-	if err := validateKey(key); err != nil {
+	if err := s.validateWrite(key, value); err != nil {
 		return 0, err
 	}
-	if len(value) > s.config.MaxValueBytes {
-		return 0, ErrValueTooLarge
-	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.setLocked(key, value, opts)
+}
 
-	s.stats.CmdSet++
+// validateWrite checks that key and value may be stored
+func (s *Store) validateWrite(key string, value []byte) error {
+	if len(key) > s.config.MaxKeyBytes {
+		return ErrKeyTooLarge
+	}
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	if len(value) > s.config.MaxValueBytesFor(key) {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
+// setLocked is Set for callers that have validated key and value and
+// hold s.mu for writing
+func (s *Store) setLocked(key string, value []byte, opts SetOptions) (uint64, error) {
+	s.stats.CmdSet.Inc()
 
 	existing, exists := s.data[key]
 
@@ -140,27 +318,31 @@ func (s *Store) Set(key string, value []byte, opts SetOptions) (uint64, error) {
 	}
 
 	// Calculate new version
+	now := time.Now().UnixMilli()
 	var newVersion uint64 = 1
+	createdMs := now
 	if exists && !existing.IsExpired() {
 		newVersion = existing.Version + 1
+		createdMs = existing.CreatedMs
 	}
 
-	// Calculate expiry
-	var expiryMs int64 = -1
-	if opts.ExpiryMs > 0 {
-		expiryMs = time.Now().UnixMilli() + opts.ExpiryMs
-	} else if opts.AbsoluteExpiryMs > 0 {
-		expiryMs = opts.AbsoluteExpiryMs
+	expiryMs, err := s.ttlPolicy(key, opts.expiryAt(now), now)
+	if err != nil {
+		return 0, err
 	}
 
 	entry := &Entry{
-		Value:     value,
-		Version:   newVersion,
-		ExpiryMs:  expiryMs,
-		SizeBytes: uint32(len(value)),
+		Version:      newVersion,
+		ExpiryMs:     expiryMs,
+		SizeBytes:    uint32(len(value)),
+		CreatedMs:    createdMs,
+		UpdatedMs:    now,
+		lastAccessMs: now,
 	}
+	entry.Value, entry.slab = s.arenaValue(value)
 
-	s.data[key] = entry
+	s.put(key, entry)
+	s.grew()
 
 	// Add to expiry heap if needed
 	if expiryMs > 0 {
@@ -182,17 +364,115 @@ func (s *Store) Delete(key string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.stats.CmdDel++
+	s.stats.CmdDel.Inc()
+	return s.deleteLocked(key)
+}
 
+// deleteLocked deletes key and reports whether it existed. The caller
+// holds s.mu.
+func (s *Store) deleteLocked(key string) bool {
 	entry, exists := s.data[key]
 	if !exists || entry.IsExpired() {
 		return false
 	}
 
-	delete(s.data, key)
+	s.drop(key)
+	s.removed(key, EventDeleted)
 	return true
 }
 
+// GetDel returns a key's entry and deletes the key in one step, so of
+// several clients racing for a key exactly one gets it
+func (s *Store) GetDel(key string) (*Entry, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.CmdGetDel.Inc()
+
+	entry, exists := s.data[key]
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+	if !entry.isString() && !entry.IsExpired() {
+		return nil, ErrWrongType
+	}
+	s.drop(key)
+	if entry.IsExpired() {
+		s.stats.ExpiredTotal.Inc()
+		s.removed(key, EventExpired)
+		return nil, ErrKeyNotFound
+	}
+	s.removed(key, EventDeleted)
+	return entry.clone(), nil
+}
+
+// GetEx returns the entry for key like Get and sets its expiry to ttlMs
+// from now, or removes the expiry if ttlMs is negative. The TTL policies
+// apply as for EXPIRE.
+func (s *Store) GetEx(key string, ttlMs int64) (*Entry, error) {
+	now := time.Now().UnixMilli()
+	expiryMs := int64(-1)
+	if ttlMs >= 0 {
+		expiryMs = now + ttlMs
+	}
+	expiryMs, err := s.ttlPolicy(key, expiryMs, now)
+	if err != nil {
+		return nil, err
+	}
+	return s.getEx(key, expiryMs)
+}
+
+// getEx returns a copy of the entry for key after setting an absolute
+// expiry on it, -1 for none
+func (s *Store) getEx(key string, expiryMs int64) (*Entry, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.CmdGetEx.Inc()
+
+	entry, exists := s.data[key]
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+	if entry.IsExpired() {
+		s.drop(key)
+		s.stats.ExpiredTotal.Inc()
+		s.removed(key, EventExpired)
+		return nil, ErrKeyNotFound
+	}
+	if !entry.isString() {
+		return nil, ErrWrongType
+	}
+
+	// Copy-on-write: readers may hold the current entry
+	updated := entry.clone()
+	updated.ExpiryMs = expiryMs
+	updated.touch(time.Now().UnixMilli())
+	s.put(key, updated)
+	if expiryMs > 0 {
+		heap.Push(s.expiryHeap, &ExpiryItem{
+			Key:      key,
+			ExpiryMs: expiryMs,
+		})
+	}
+	return updated.clone(), nil
+}
+
+// removed reports a key that expired or was deleted. Callers hold s.mu.
+func (s *Store) removed(key, event string) {
+	if s.notify != nil {
+		s.notify(key, event)
+	}
+}
+
 // Exists checks if a key exists (not expired)
 func (s *Store) Exists(key string) bool {
 	if err := validateKey(key); err != nil {
@@ -206,8 +486,54 @@ func (s *Store) Exists(key string) bool {
 	return exists && !entry.IsExpired()
 }
 
+// ExistsMany reports for each of keys whether it exists, looking them all
+// up under one lock
+func (s *Store) ExistsMany(keys ...string) []bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		if validateKey(key) != nil {
+			continue
+		}
+		entry, exists := s.data[key]
+		found[i] = exists && !entry.IsExpired()
+	}
+	return found
+}
+
 // Expire sets a TTL on a key
 func (s *Store) Expire(key string, ttlMs int64) error {
+	now := time.Now().UnixMilli()
+	expiryMs, err := s.ttlPolicy(key, now+ttlMs, now)
+	if err != nil {
+		return err
+	}
+	return s.expireAt(key, expiryMs)
+}
+
+// ttlPolicy applies default_ttl_ms and max_ttl_ms to an absolute expiry
+// for key, -1 for none
+func (s *Store) ttlPolicy(key string, expiryMs, now int64) (int64, error) {
+	if IsReserved(key) {
+		return expiryMs, nil
+	}
+	if defaultMs := s.config.DefaultTTLFor(key); expiryMs <= 0 && defaultMs > 0 {
+		expiryMs = now + defaultMs
+	}
+	maxMs, reject := s.config.MaxTTLFor(key)
+	if maxMs > 0 && (expiryMs <= 0 || expiryMs-now > maxMs) {
+		if reject {
+			return 0, ErrTTLTooLong
+		}
+		expiryMs = now + maxMs
+	}
+	return expiryMs, nil
+}
+
+// expireAt sets an absolute expiry on a key
+func (s *Store) expireAt(key string, expiryMs int64) error {
 	if err := validateKey(key); err != nil {
 		return err
 	}
@@ -220,11 +546,14 @@ func (s *Store) Expire(key string, ttlMs int64) error {
 		return ErrKeyNotFound
 	}
 
-	entry.ExpiryMs = time.Now().UnixMilli() + ttlMs
+	// Copy-on-write: readers may hold the current entry
+	updated := entry.clone()
+	updated.ExpiryMs = expiryMs
+	s.put(key, updated)
 
 	heap.Push(s.expiryHeap, &ExpiryItem{
 		Key:      key,
-		ExpiryMs: entry.ExpiryMs,
+		ExpiryMs: expiryMs,
 	})
 
 	return nil
@@ -247,6 +576,32 @@ func (s *Store) TTL(key string) int64 {
 	return entry.TTL()
 }
 
+// Expiring returns copies of the live entries that will expire by
+// deadlineMs, by key. Only the part of the expiry heap due before the
+// deadline is visited; heap items left behind by later writes are skipped.
+func (s *Store) Expiring(deadlineMs int64) map[string]*Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h := *s.expiryHeap
+	expiring := make(map[string]*Entry)
+	stack := []int{0}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= len(h) || h[i].ExpiryMs > deadlineMs {
+			continue // a heap's children expire no earlier than their parent
+		}
+		stack = append(stack, 2*i+1, 2*i+2)
+
+		entry, exists := s.data[h[i].Key]
+		if exists && entry.ExpiryMs == h[i].ExpiryMs && !entry.IsExpired() {
+			expiring[h[i].Key] = entry.clone()
+		}
+	}
+	return expiring
+}
+
 // Incr increments a numeric value
 func (s *Store) Incr(key string, delta int64) (int64, error) {
 	if err := validateKey(key); err != nil {
@@ -256,13 +611,15 @@ func (s *Store) Incr(key string, delta int64) (int64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.stats.CmdIncr++
+	s.stats.CmdIncr.Inc()
 
 	entry, exists := s.data[key]
 
 	var currentVal int64
 	if !exists || entry.IsExpired() {
 		currentVal = 0
+	} else if !entry.isString() {
+		return 0, ErrWrongType
 	} else {
 		// Try to parse as integer
 		val, err := strconv.ParseInt(string(entry.Value), 10, 64)
@@ -273,51 +630,259 @@ func (s *Store) Incr(key string, delta int64) (int64, error) {
 	}
 
 	newVal := currentVal + delta
-	newValStr := strconv.FormatInt(newVal, 10)
+	if err := s.putCounter(key, strconv.FormatInt(newVal, 10)); err != nil {
+		return 0, err
+	}
+	return newVal, nil
+}
+
+// IncrFloat adds delta to a value parsed as a float64 and stores the sum
+// in its shortest form without an exponent, such as 10.5 or 3
+func (s *Store) IncrFloat(key string, delta float64) (float64, error) {
+	if err := validateKey(key); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.CmdIncr.Inc()
+
+	var currentVal float64
+	if entry, exists := s.data[key]; exists && !entry.IsExpired() {
+		if !entry.isString() {
+			return 0, ErrWrongType
+		}
+		val, err := strconv.ParseFloat(string(entry.Value), 64)
+		if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
+			return 0, ErrNotFloat
+		}
+		currentVal = val
+	}
+
+	newVal := currentVal + delta
+	if math.IsNaN(newVal) || math.IsInf(newVal, 0) {
+		return 0, ErrFloatOverflow
+	}
+	if err := s.putCounter(key, FormatFloat(newVal)); err != nil {
+		return 0, err
+	}
+	return newVal, nil
+}
+
+// FormatFloat formats f as INCRBYFLOAT stores it
+func FormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
 
-	// Create new entry
+// putCounter stores value as the new value of key for Incr and
+// IncrFloat. Like a SET without an expiry, it gets the default TTL. The
+// caller holds s.mu for writing.
+func (s *Store) putCounter(key, value string) error {
+	now := time.Now().UnixMilli()
+	expiryMs, err := s.ttlPolicy(key, -1, now)
+	if err != nil {
+		return err
+	}
 	var newVersion uint64 = 1
-	if exists && !entry.IsExpired() {
+	createdMs := now
+	if entry, exists := s.data[key]; exists && !entry.IsExpired() {
 		newVersion = entry.Version + 1
+		createdMs = entry.CreatedMs
 	}
 
-	s.data[key] = &Entry{
-		Value:     []byte(newValStr),
-		Version:   newVersion,
-		ExpiryMs:  -1,
-		SizeBytes: uint32(len(newValStr)),
+	updated := &Entry{
+		Version:      newVersion,
+		ExpiryMs:     expiryMs,
+		SizeBytes:    uint32(len(value)),
+		CreatedMs:    createdMs,
+		UpdatedMs:    now,
+		lastAccessMs: now,
 	}
+	updated.Value, updated.slab = s.arenaValue([]byte(value))
+	s.put(key, updated)
+	s.grew()
 
-	return newVal, nil
+	if expiryMs > 0 {
+		heap.Push(s.expiryHeap, &ExpiryItem{Key: key, ExpiryMs: expiryMs})
+	}
+	return nil
 }
 
-// GetStats returns current statistics
-func (s *Store) GetStats() map[string]string {
+// Append appends data to the value of key, creating the key if it is
+// missing, and returns the new length and version. The key keeps its
+// expiry; a new key gets the default TTL like a SET without one.
+func (s *Store) Append(key string, data []byte) (int, uint64, error) {
+	if err := s.validateWrite(key, data); err != nil {
+		return 0, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.CmdAppend.Inc()
+
+	now := time.Now().UnixMilli()
+	existing, exists := s.data[key]
+	live := exists && !existing.IsExpired()
+
+	value := data
+	var newVersion uint64 = 1
+	createdMs := now
+	var expiryMs int64
+	if live && !existing.isString() {
+		return 0, 0, ErrWrongType
+	}
+	if live {
+		if len(existing.Value)+len(data) > s.config.MaxValueBytesFor(key) {
+			return 0, 0, ErrValueTooLarge
+		}
+		value = make([]byte, 0, len(existing.Value)+len(data))
+		value = append(append(value, existing.Value...), data...)
+		newVersion = existing.Version + 1
+		createdMs = existing.CreatedMs
+		expiryMs = existing.ExpiryMs
+	} else {
+		var err error
+		expiryMs, err = s.ttlPolicy(key, -1, now)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	updated := &Entry{
+		Version:      newVersion,
+		ExpiryMs:     expiryMs,
+		SizeBytes:    uint32(len(value)),
+		CreatedMs:    createdMs,
+		UpdatedMs:    now,
+		lastAccessMs: now,
+	}
+	updated.Value, updated.slab = s.arenaValue(value)
+	s.put(key, updated)
+	s.grew()
+
+	if expiryMs > 0 && !live {
+		heap.Push(s.expiryHeap, &ExpiryItem{Key: key, ExpiryMs: expiryMs})
+	}
+
+	return len(value), newVersion, nil
+}
+
+// registerMetrics registers the store's statistics
+func (s *Store) registerMetrics() {
+	r := s.metrics
+	s.stats.StartTimeMs = time.Now().UnixMilli()
+
+	r.GaugeFunc("uptime_ms", "server", "Time since the server started, in milliseconds", func() int64 {
+		return time.Now().UnixMilli() - s.stats.StartTimeMs
+	})
+	r.GaugeFunc("keys", "keyspace", "Keys that have not expired", s.DBSize)
+	r.GaugeFunc("keys_persistent", "keyspace", "Keys that have not expired and are logged to disk", func() int64 {
+		total, ephemeral := s.keyCounts()
+		return total - ephemeral
+	})
+	r.GaugeFunc("keys_ephemeral", "keyspace", "Keys that have not expired and are kept in memory only", func() int64 {
+		_, ephemeral := s.keyCounts()
+		return ephemeral
+	})
+	s.stats.ExpiredTotal = r.Counter("expired_total", "keyspace", "Keys removed after expiring")
+	s.stats.EvictedTotal = r.Counter("evicted_total", "keyspace", "Keys evicted under memory pressure")
+	s.stats.CmdGet = r.Counter("cmd_get", "commands", "GET commands")
+	s.stats.CmdSet = r.Counter("cmd_set", "commands", "SET commands")
+	s.stats.CmdDel = r.Counter("cmd_del", "commands", "DEL commands")
+	s.stats.CmdGetDel = r.Counter("cmd_getdel", "commands", "GETDEL commands")
+	s.stats.CmdGetEx = r.Counter("cmd_getex", "commands", "GETEX commands")
+	s.stats.CmdAppend = r.Counter("cmd_append", "commands", "APPEND commands")
+	s.stats.CmdGetRange = r.Counter("cmd_getrange", "commands", "GETRANGE commands")
+	s.stats.CmdSetRange = r.Counter("cmd_setrange", "commands", "SETRANGE commands")
+	s.stats.CmdIncr = r.Counter("cmd_incr", "commands", "INCR, DECR and INCRBYFLOAT commands")
+	s.stats.CmdHSet = r.Counter("cmd_hset", "commands", "HSET and HDEL commands")
+	s.stats.CmdHGet = r.Counter("cmd_hget", "commands", "HGET, HMGET and HGETALL commands")
+	s.stats.CmdSAdd = r.Counter("cmd_sadd", "commands", "SADD and SREM commands")
+	s.stats.CmdSRead = r.Counter("cmd_sread", "commands", "SMEMBERS, SISMEMBER and SCARD commands")
+	s.stats.CmdZAdd = r.Counter("cmd_zadd", "commands", "ZADD and ZREM commands")
+	s.stats.CmdZRange = r.Counter("cmd_zrange", "commands", "ZRANGE and ZRANGEBYSCORE commands")
+	s.stats.CmdPFAdd = r.Counter("cmd_pfadd", "commands", "PFADD and PFMERGE commands")
+	s.stats.CmdPFCount = r.Counter("cmd_pfcount", "commands", "PFCOUNT commands")
+	s.stats.CmdTouch = r.Counter("cmd_touch", "commands", "TOUCH commands")
+	s.stats.CmdMDel = r.Counter("cmd_mdel", "commands", "MDEL commands")
+
+	s.stats.DefragRuns = r.Counter("defrag_runs", "memory", "Key map rebuilds")
+	s.stats.DefragReclaimedSlots = r.Counter("defrag_reclaimed_slots", "memory", "Key map slots released by rebuilds")
+	s.stats.DefragReclaimedBytes = r.Counter("defrag_reclaimed_bytes", "memory", "Estimated bytes released by key map rebuilds")
+	s.stats.DefragLastPauseUs = r.Gauge("defrag_last_pause_us", "memory", "How long the last key map rebuild locked the store, in microseconds")
+}
+
+// DBSize returns the number of keys that have not expired, not counting
+// server metadata. Keys are counted as they are added and removed, so
+// only the expired keys the sweeper hasn't removed yet are looked at.
+func (s *Store) DBSize() int64 {
+	total, _ := s.keyCounts()
+	return total
+}
+
+// keyCounts returns DBSize and how many of those keys are ephemeral
+func (s *Store) keyCounts() (total, ephemeral int64) {
 	s.mu.RLock()
-	s.stats.mu.RLock()
 	defer s.mu.RUnlock()
-	defer s.stats.mu.RUnlock()
+	expired, expiredEphemeral := s.expiredUnswept(time.Now().UnixMilli())
+	return int64(s.userKeys - expired), int64(s.ephemeral - expiredEphemeral)
+}
 
-	uptime := time.Now().UnixMilli() - s.stats.StartTimeMs
+// expiredUnswept counts the keys still in s.data that expired before now,
+// and how many of them are ephemeral, walking only the part of the expiry
+// heap that is due: below an item that isn't, nothing is. The caller
+// holds s.mu.
+func (s *Store) expiredUnswept(now int64) (n, ephemeral int) {
+	h := *s.expiryHeap
+	var seen map[string]bool // a key can have several due items
+	for stack := []int{0}; len(stack) > 0; {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= len(h) || h[i].ExpiryMs >= now {
+			continue
+		}
+		stack = append(stack, 2*i+1, 2*i+2)
 
-	// Count non-expired keys
-	keyCount := 0
-	for _, entry := range s.data {
-		if !entry.IsExpired() {
-			keyCount++
+		key := h[i].Key
+		entry, exists := s.data[key]
+		if !exists || entry.ExpiryMs < 0 || entry.ExpiryMs >= now || IsReserved(key) || seen[key] {
+			continue
+		}
+		if seen == nil {
+			seen = make(map[string]bool)
+		}
+		seen[key] = true
+		n++
+		if s.config.EphemeralFor(key) {
+			ephemeral++
 		}
 	}
+	return n, ephemeral
+}
+
+// Len returns the number of keys, including expired keys that haven't
+// been swept yet but not server metadata
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.userKeys
+}
+
+// Metrics returns the registry the store's statistics are kept in. The
+// server registers its own metrics in it too.
+func (s *Store) Metrics() *metrics.Registry {
+	return s.metrics
+}
 
-	return map[string]string{
-		"uptime_ms":     strconv.FormatInt(uptime, 10),
-		"keys":          strconv.Itoa(keyCount),
-		"expired_total": strconv.FormatUint(s.stats.ExpiredTotal, 10),
-		"evicted_total": strconv.FormatUint(s.stats.EvictedTotal, 10),
-		"cmd_get":       strconv.FormatUint(s.stats.CmdGet, 10),
-		"cmd_set":       strconv.FormatUint(s.stats.CmdSet, 10),
-		"cmd_del":       strconv.FormatUint(s.stats.CmdDel, 10),
-		"cmd_incr":      strconv.FormatUint(s.stats.CmdIncr, 10),
+// GetStats returns current statistics
+func (s *Store) GetStats() map[string]string {
+	stats := make(map[string]string)
+	for _, v := range s.metrics.Values() {
+		stats[v.Name] = v.Value
 	}
+	return stats
 }
 
 // SetOptions contains options for SET command
@@ -328,4 +893,17 @@ type SetOptions struct {
 	XX               bool
 	CheckVersion     bool
 	Version          uint64
+	NoSync           bool // log to the WAL without waiting for an fsync
+}
+
+// expiryAt returns the absolute expiry the options ask for when written at
+// now, -1 for none
+func (o SetOptions) expiryAt(now int64) int64 {
+	if o.ExpiryMs > 0 {
+		return now + o.ExpiryMs
+	}
+	if o.AbsoluteExpiryMs > 0 {
+		return o.AbsoluteExpiryMs
+	}
+	return -1
 }
@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"fmt"
+	"math"
+	"strings"
 	"testing"
 	"time"
 
@@ -117,6 +120,137 @@ func TestStore_Delete(t *testing.T) {
 	assert.Equal(t, ErrKeyNotFound, err)
 }
 
+func TestStore_GetDel(t *testing.T) {
+	store := newTestStore()
+
+	_, err := store.GetDel("nonexistent")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	_, err = store.Set("key1", []byte("value1"), SetOptions{})
+	require.NoError(t, err)
+
+	entry, err := store.GetDel("key1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value1"), entry.Value)
+	assert.Equal(t, uint64(1), entry.Version)
+
+	_, err = store.GetDel("key1")
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.False(t, store.Exists("key1"))
+}
+
+func TestPersistentStore_GetDelRecovery(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, err = ps.Set("job:1", []byte("payload"), SetOptions{})
+	require.NoError(t, err)
+	entry, err := ps.GetDel("job:1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), entry.Value)
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	_, err = ps.Get("job:1")
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestStore_GetEx(t *testing.T) {
+	store := newTestStore()
+
+	_, err := store.Set("session", []byte("data"), SetOptions{ExpiryMs: 1000})
+	require.NoError(t, err)
+
+	entry, err := store.GetEx("session", 60000)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), entry.Value)
+	assert.Greater(t, store.TTL("session"), int64(59000))
+
+	entry, err = store.GetEx("session", -1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), entry.ExpiryMs)
+	assert.Equal(t, int64(-1), store.TTL("session"))
+
+	_, err = store.GetEx("missing", 1000)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestPersistentStore_GetExRecovery(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, err = ps.Set("a", []byte("1"), SetOptions{ExpiryMs: 1000})
+	require.NoError(t, err)
+	_, err = ps.Set("b", []byte("2"), SetOptions{})
+	require.NoError(t, err)
+	_, err = ps.GetEx("a", -1)
+	require.NoError(t, err)
+	_, err = ps.GetEx("b", 60000)
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	assert.Equal(t, int64(-1), ps.TTL("a"))
+	assert.Greater(t, ps.TTL("b"), int64(59000))
+}
+
+func TestStore_Append(t *testing.T) {
+	store := newTestStore()
+	store.config.MaxValueBytes = 10
+
+	length, version, err := store.Append("log", []byte("abc"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, length)
+	assert.Equal(t, uint64(1), version)
+
+	require.NoError(t, store.Expire("log", 60000))
+	length, version, err = store.Append("log", []byte("def"))
+	require.NoError(t, err)
+	assert.Equal(t, 6, length)
+	assert.Equal(t, uint64(2), version)
+
+	entry, err := store.Get("log")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abcdef"), entry.Value)
+	assert.Greater(t, entry.TTL(), int64(59000), "APPEND keeps the expiry")
+
+	// The resulting value must fit max_value_bytes
+	_, _, err = store.Append("log", []byte("ghijk"))
+	assert.Equal(t, ErrValueTooLarge, err)
+	entry, err = store.Get("log")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abcdef"), entry.Value)
+}
+
+func TestPersistentStore_AppendRecovery(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	for _, part := range []string{"a", "b", "c"} {
+		_, _, err := ps.Append("log", []byte(part))
+		require.NoError(t, err)
+	}
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	entry, err := ps.Get("log")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc"), entry.Value)
+	assert.Equal(t, uint64(3), entry.Version)
+}
+
 func TestStore_Exists(t *testing.T) {
 	store := newTestStore()
 
@@ -134,6 +268,19 @@ func TestStore_Exists(t *testing.T) {
 	assert.False(t, store.Exists("key1"))
 }
 
+func TestStore_ExistsMany(t *testing.T) {
+	store := newTestStore()
+
+	_, err := store.Set("a", []byte("1"), SetOptions{})
+	require.NoError(t, err)
+	_, err = store.Set("gone", []byte("2"), SetOptions{ExpiryMs: 1})
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Equal(t, []bool{true, false, false, false, true},
+		store.ExistsMany("a", "missing", "gone", "bad key", "a"))
+}
+
 func TestStore_Expire_TTL(t *testing.T) {
 	store := newTestStore()
 
@@ -161,6 +308,30 @@ func TestStore_Expire_TTL(t *testing.T) {
 	assert.Equal(t, int64(-1), ttl)
 }
 
+func TestStore_Expiring(t *testing.T) {
+	store := newTestStore()
+
+	for i := 0; i < 20; i++ {
+		_, err := store.Set(fmt.Sprintf("key%d", i), []byte("v"), SetOptions{ExpiryMs: int64(i+1) * 1000})
+		require.NoError(t, err)
+	}
+	_, err := store.Set("forever", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+
+	// key0 and key1 were due soon but have since been given longer TTLs
+	require.NoError(t, store.Expire("key0", 60000))
+	_, err = store.Set("key1", []byte("v2"), SetOptions{ExpiryMs: 60000})
+	require.NoError(t, err)
+
+	expiring := store.Expiring(time.Now().UnixMilli() + 5500)
+	keys := make([]string, 0, len(expiring))
+	for key := range expiring {
+		keys = append(keys, key)
+	}
+	assert.ElementsMatch(t, []string{"key2", "key3", "key4"}, keys)
+	assert.Equal(t, []byte("v"), expiring["key2"].Value)
+}
+
 func TestStore_Incr_Decr(t *testing.T) {
 	store := newTestStore()
 
@@ -187,6 +358,44 @@ func TestStore_Incr_Decr(t *testing.T) {
 	assert.Equal(t, ErrNotInteger, err)
 }
 
+func TestStore_IncrFloat(t *testing.T) {
+	store := newTestStore()
+
+	newVal, err := store.IncrFloat("f", 10.5)
+	require.NoError(t, err)
+	assert.Equal(t, 10.5, newVal)
+
+	newVal, err = store.IncrFloat("f", -7.5)
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, newVal)
+	entry, err := store.Get("f")
+	require.NoError(t, err)
+	assert.Equal(t, "3", string(entry.Value))
+	assert.Equal(t, uint64(2), entry.Version)
+
+	// Integer counters can be incremented as floats
+	_, err = store.Incr("n", 2)
+	require.NoError(t, err)
+	newVal, err = store.IncrFloat("n", 0.25)
+	require.NoError(t, err)
+	assert.Equal(t, 2.25, newVal)
+
+	_, err = store.Set("text", []byte("hello"), SetOptions{})
+	require.NoError(t, err)
+	_, err = store.IncrFloat("text", 1)
+	assert.Equal(t, ErrNotFloat, err)
+
+	_, err = store.Set("inf", []byte("inf"), SetOptions{})
+	require.NoError(t, err)
+	_, err = store.IncrFloat("inf", 1)
+	assert.Equal(t, ErrNotFloat, err)
+
+	_, err = store.IncrFloat("f", math.MaxFloat64)
+	require.NoError(t, err)
+	_, err = store.IncrFloat("f", math.MaxFloat64)
+	assert.Equal(t, ErrFloatOverflow, err)
+}
+
 func TestStore_Expiry_Lazy(t *testing.T) {
 	store := newTestStore()
 
@@ -209,10 +418,7 @@ func TestStore_SizeLimits(t *testing.T) {
 	cfg := config.DefaultConfig()
 	cfg.MaxKeyBytes = 10
 	cfg.MaxValueBytes = 20
-	store := &Store{
-		data:   make(map[string]*Entry),
-		config: cfg,
-	}
+	store := New(cfg)
 
 	// Key too large
 	longKey := string(make([]byte, 11))
@@ -229,6 +435,74 @@ func TestStore_SizeLimits(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestStore_PrefixValueLimits(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.MaxValueBytes = 20
+	cfg.PrefixRules = []config.PrefixRule{
+		{Prefix: "blob:", MaxValueBytes: 100},
+		{Prefix: "small:", MaxValueBytes: 5},
+		{Prefix: "blob:hot:", DefaultTTLMs: 60000},
+	}
+	store := New(cfg)
+
+	// Larger limit under blob:
+	_, err := store.Set("blob:1", make([]byte, 50), SetOptions{})
+	require.NoError(t, err)
+	_, err = store.Set("blob:1", make([]byte, 101), SetOptions{})
+	assert.Equal(t, ErrValueTooLarge, err)
+
+	// A longer rule without a limit inherits blob:'s
+	_, err = store.Set("blob:hot:1", make([]byte, 50), SetOptions{})
+	require.NoError(t, err)
+	_, err = store.Set("blob:hot:1", make([]byte, 101), SetOptions{})
+	assert.Equal(t, ErrValueTooLarge, err)
+
+	// Smaller limit under small:
+	_, err = store.Set("small:1", make([]byte, 6), SetOptions{})
+	assert.Equal(t, ErrValueTooLarge, err)
+
+	// Global limit elsewhere
+	_, err = store.Set("other", make([]byte, 50), SetOptions{})
+	assert.Equal(t, ErrValueTooLarge, err)
+}
+
+func TestStore_TTLPolicy(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DefaultTTLMs = 60000
+	cfg.MaxTTLMs = 120000
+	cfg.PrefixRules = []config.PrefixRule{
+		{Prefix: "strict:", MaxTTLMs: 1000, MaxTTLPolicy: "reject"},
+	}
+	store := New(cfg)
+
+	// Writes without an expiry get the default TTL
+	_, err := store.Set("a", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+	assert.InDelta(t, 60000, store.TTL("a"), 100)
+	_, err = store.Incr("n", 1)
+	require.NoError(t, err)
+	assert.InDelta(t, 60000, store.TTL("n"), 100)
+
+	// Longer TTLs are clamped
+	_, err = store.Set("b", []byte("v"), SetOptions{ExpiryMs: 3600000})
+	require.NoError(t, err)
+	assert.InDelta(t, 120000, store.TTL("b"), 100)
+	require.NoError(t, store.Expire("b", 3600000))
+	assert.InDelta(t, 120000, store.TTL("b"), 100)
+
+	// or rejected under a prefix rule, where the default TTL is too long
+	_, err = store.Set("strict:1", []byte("v"), SetOptions{})
+	assert.ErrorIs(t, err, ErrTTLTooLong)
+	_, err = store.Set("strict:1", []byte("v"), SetOptions{ExpiryMs: 500})
+	require.NoError(t, err)
+	assert.ErrorIs(t, store.Expire("strict:1", 5000), ErrTTLTooLong)
+
+	// CHECKSET writes carry no TTL, so one refused write fails them all
+	_, err = store.CheckSet(nil, []KeyValue{{Key: "c", Value: []byte("v")}, {Key: "strict:2", Value: []byte("v")}})
+	assert.ErrorIs(t, err, ErrTTLTooLong)
+	assert.False(t, store.Exists("c"))
+}
+
 func TestStore_Stats(t *testing.T) {
 	store := newTestStore()
 
@@ -251,3 +525,256 @@ func TestStore_Stats(t *testing.T) {
 	assert.Equal(t, "1", stats["cmd_del"])
 	assert.Equal(t, "1", stats["cmd_incr"])
 }
+
+func TestStore_DBSize(t *testing.T) {
+	store := newTestStore()
+	assert.Equal(t, int64(0), store.DBSize())
+
+	store.put(ReservedPrefix+MetaSchemaVersion, &Entry{ExpiryMs: -1})
+	for i := 0; i < 5; i++ {
+		_, err := store.Set(fmt.Sprintf("key%d", i), []byte("v"), SetOptions{})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(5), store.DBSize())
+
+	// Expired keys stop counting before the sweeper removes them, once
+	// however many times their expiry was set
+	soon := time.Now().UnixMilli() + 20
+	require.NoError(t, store.Expire("key0", 10))
+	_, err := store.Set("key1", []byte("v"), SetOptions{AbsoluteExpiryMs: soon})
+	require.NoError(t, err)
+	_, err = store.Set("key1", []byte("v"), SetOptions{AbsoluteExpiryMs: soon})
+	require.NoError(t, err)
+	require.NoError(t, store.Expire("key2", 60000))
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, int64(3), store.DBSize())
+	assert.Equal(t, 5, store.Len())
+
+	store.Delete("key3")
+	assert.Equal(t, int64(2), store.DBSize())
+	assert.Equal(t, "2", store.GetStats()["keys"])
+}
+
+func TestStore_KeyTemperature(t *testing.T) {
+	store := newTestStore()
+
+	for _, key := range []string{"hot1", "hot2", "warm1", "cold1"} {
+		_, err := store.Set(key, []byte("v"), SetOptions{})
+		require.NoError(t, err)
+	}
+
+	_, err := store.Get("hot1")
+	require.NoError(t, err)
+	_, err = store.Get("hot1")
+	require.NoError(t, err)
+
+	// Age the warm and cold keys
+	now := time.Now().UnixMilli()
+	store.data["warm1"].lastAccessMs = now - int64(store.config.TemperatureHotMs) - 1000
+	store.data["cold1"].lastAccessMs = now - int64(store.config.TemperatureWarmMs) - 1000
+
+	buckets := store.KeyTemperature(10)
+	require.Len(t, buckets, 3)
+
+	assert.Equal(t, TemperatureHot, buckets[0].Name)
+	assert.Equal(t, 2, buckets[0].Keys)
+	assert.Equal(t, uint64(2), buckets[0].Hits)
+	assert.ElementsMatch(t, []string{"hot1", "hot2"}, buckets[0].Samples)
+
+	assert.Equal(t, TemperatureWarm, buckets[1].Name)
+	assert.Equal(t, []string{"warm1"}, buckets[1].Samples)
+
+	assert.Equal(t, TemperatureCold, buckets[2].Name)
+	assert.Equal(t, []string{"cold1"}, buckets[2].Samples)
+
+	// Sample count is capped
+	buckets = store.KeyTemperature(1)
+	assert.Equal(t, 2, buckets[0].Keys)
+	assert.Len(t, buckets[0].Samples, 1)
+}
+
+func TestStore_Keys(t *testing.T) {
+	store := newTestStore()
+
+	for _, key := range []string{"user:1", "user:2", "user:10", "session:1", "a*b"} {
+		_, err := store.Set(key, []byte("v"), SetOptions{})
+		require.NoError(t, err)
+	}
+
+	keys, more := store.Keys("user:*", 10)
+	assert.Equal(t, []string{"user:1", "user:10", "user:2"}, keys)
+	assert.False(t, more)
+
+	keys, _ = store.Keys("user:?", 10)
+	assert.Equal(t, []string{"user:1", "user:2"}, keys)
+
+	keys, _ = store.Keys("[^ua]*", 10)
+	assert.Equal(t, []string{"session:1"}, keys)
+
+	keys, _ = store.Keys("a\\*b", 10)
+	assert.Equal(t, []string{"a*b"}, keys)
+
+	keys, more = store.Keys("*", 2)
+	assert.Len(t, keys, 2)
+	assert.True(t, more)
+}
+
+func TestStore_Scan(t *testing.T) {
+	store := newTestStore()
+
+	var want []string
+	for i := 0; i < 250; i++ {
+		key := fmt.Sprintf("k%03d", i)
+		_, err := store.Set(key, []byte("v"), SetOptions{})
+		require.NoError(t, err)
+		want = append(want, key)
+	}
+	_, err := store.Set("other", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+
+	var got []string
+	cursor := ""
+	for {
+		keys, next := store.Scan(cursor, "k*", 7)
+		assert.LessOrEqual(t, len(keys), 7)
+		got = append(got, keys...)
+		if next == "" {
+			break
+		}
+		cursor = next
+		// Keys added behind the cursor aren't returned
+		_, err := store.Set("k000a", []byte("v"), SetOptions{})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, want, got)
+
+	keys, next := store.Scan("", "*", 1000)
+	assert.Len(t, keys, 252)
+	assert.Empty(t, next)
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"", "", true},
+		{"*", "", true},
+		{"a*", "abc", true},
+		{"*c", "abc", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXbY", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"[a-c]x", "bx", true},
+		{"[a-c]x", "dx", false},
+		{"[^a-c]x", "dx", true},
+		{"[]]", "]", true},
+		{"[", "[", true},
+		{"\\?", "?", true},
+		{"\\?", "a", false},
+		{"*a*a*a*a*a*b", strings.Repeat("a", 100), false},
+	} {
+		assert.Equal(t, tc.match, matchGlob(tc.pattern, tc.s), "%q %q", tc.pattern, tc.s)
+	}
+}
+
+func TestStore_Get_ReturnsCopy(t *testing.T) {
+	store := newTestStore()
+
+	_, err := store.Set("key1", []byte("value1"), SetOptions{})
+	require.NoError(t, err)
+
+	entry, err := store.Get("key1")
+	require.NoError(t, err)
+
+	// Mutating the returned entry must not affect the store
+	entry.ExpiryMs = 1
+	entry.Version = 99
+
+	fresh, err := store.Get("key1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), fresh.ExpiryMs)
+	assert.Equal(t, uint64(1), fresh.Version)
+
+	// Updates install a new entry instead of modifying the one a reader holds
+	require.NoError(t, store.Expire("key1", 10000))
+	assert.Equal(t, int64(-1), fresh.ExpiryMs)
+
+	updated, err := store.Get("key1")
+	require.NoError(t, err)
+	assert.True(t, updated.ExpiryMs > 0)
+	assert.Equal(t, []byte("value1"), updated.Value)
+}
+
+func TestStore_Defrag(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DefragMinKeys = 100
+	cfg.DefragMinRatio = 0.25
+	store := New(cfg)
+
+	for i := 0; i < 200; i++ {
+		_, err := store.Set(fmt.Sprintf("key%d", i), []byte("v"), SetOptions{})
+		require.NoError(t, err)
+	}
+
+	// Still mostly live
+	for i := 0; i < 100; i++ {
+		store.Delete(fmt.Sprintf("key%d", i))
+	}
+	assert.Equal(t, 0, store.Defrag())
+
+	for i := 100; i < 190; i++ {
+		store.Delete(fmt.Sprintf("key%d", i))
+	}
+	assert.Equal(t, 190, store.Defrag())
+
+	stats := store.DefragStats()
+	assert.Equal(t, uint64(1), stats.Runs)
+	assert.Equal(t, uint64(190), stats.ReclaimedSlots)
+	assert.Equal(t, uint64(190*mapSlotBytes), stats.ReclaimedBytes)
+	assert.Equal(t, "1", store.GetStats()["defrag_runs"])
+
+	// Remaining keys survive the rebuild, and the new map starts afresh
+	for i := 190; i < 200; i++ {
+		entry, err := store.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v"), entry.Value)
+	}
+	assert.Equal(t, 0, store.Defrag())
+
+	// Too many live keys to rebuild
+	cfg.DefragMaxKeys = 5
+	for i := 0; i < 200; i++ {
+		store.Set(fmt.Sprintf("key%d", i), []byte("v"), SetOptions{})
+	}
+	for i := 0; i < 190; i++ {
+		store.Delete(fmt.Sprintf("key%d", i))
+	}
+	assert.Equal(t, 0, store.Defrag())
+}
+
+func TestPersistentStore_IncrFloatRecovery(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.SyncPolicy = "always"
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, err = ps.IncrFloat("f", 1.5)
+	require.NoError(t, err)
+	_, err = ps.IncrFloat("f", 2.25)
+	require.NoError(t, err)
+
+	// Replay the WAL after a crash
+	ps.walManager.Close()
+	ps.lock.release()
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	entry, err := ps.Get("f")
+	require.NoError(t, err)
+	assert.Equal(t, "3.75", string(entry.Value))
+	assert.Equal(t, uint64(2), entry.Version)
+}
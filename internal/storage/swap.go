@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ErrPrefixOverlap is returned by SwapPrefix for prefixes where one starts
+// with the other, whose keys can't be told apart
+var ErrPrefixOverlap = errors.New("prefixes overlap")
+
+// renamedEntry is an entry SwapPrefix moves from one key to another
+type renamedEntry struct {
+	from, to string
+	entry    *Entry
+}
+
+// SwapPrefix atomically renames every key starting with a to start with b
+// instead, and every key starting with b to start with a, keeping values,
+// versions and TTLs. Returns the number of keys moved. Readers see the
+// keys under either their old or their new names, never a mix.
+func (s *Store) SwapPrefix(a, b string) (int, error) {
+	for _, prefix := range []string{a, b} {
+		if prefix == "" {
+			return 0, fmt.Errorf("empty prefix")
+		}
+		if err := validateKey(prefix); err != nil {
+			return 0, err
+		}
+	}
+	if strings.HasPrefix(a, b) || strings.HasPrefix(b, a) {
+		return 0, ErrPrefixOverlap
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.swapPrefixLocked(a, b)
+}
+
+// swapPrefixLocked is SwapPrefix for callers holding s.mu for writing.
+// Expired keys under either prefix are dropped rather than moved.
+func (s *Store) swapPrefixLocked(a, b string) (int, error) {
+	var renamed []renamedEntry
+	for key, entry := range s.data {
+		var to string
+		switch {
+		case strings.HasPrefix(key, a):
+			to = b + key[len(a):]
+		case strings.HasPrefix(key, b):
+			to = a + key[len(b):]
+		default:
+			continue
+		}
+		if len(to) > s.config.MaxKeyBytes {
+			return 0, fmt.Errorf("%w: %s", ErrKeyTooLarge, to)
+		}
+		renamed = append(renamed, renamedEntry{key, to, entry})
+	}
+
+	for _, r := range renamed {
+		s.drop(r.from)
+	}
+
+	moved := 0
+	for _, r := range renamed {
+		if r.entry.IsExpired() {
+			s.stats.ExpiredTotal.Inc()
+			s.removed(r.from, EventExpired)
+			continue
+		}
+		s.put(r.to, r.entry)
+		if r.entry.ExpiryMs > 0 {
+			heap.Push(s.expiryHeap, &ExpiryItem{Key: r.to, ExpiryMs: r.entry.ExpiryMs})
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// SwapPrefix is Store.SwapPrefix logged to the WAL as one SWAP record
+func (ps *PersistentStore) SwapPrefix(a, b string) (int, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	moved, err := ps.Store.SwapPrefix(a, b)
+	if err != nil {
+		return 0, err
+	}
+
+	record := &WALRecord{
+		Type:     RecordTypeSWAP,
+		Key:      a,
+		Value:    []byte(b),
+		ExpiryMs: -1,
+	}
+	if err := ps.walManager.AppendRecord(record); err != nil {
+		// Swapping again puts every key back
+		ps.Store.SwapPrefix(a, b)
+		return 0, fmt.Errorf("WAL write failed: %w", err)
+	}
+
+	return moved, nil
+}
+
+// applySwapRecord applies a SWAP record during recovery
+func (ps *PersistentStore) applySwapRecord(record *WALRecord) {
+	a, b := ps.NormalizeKey(record.Key), ps.NormalizeKey(string(record.Value))
+	if _, err := ps.Store.swapPrefixLocked(a, b); err != nil {
+		log.Printf("Failed to replay prefix swap %s %s: %v", record.Key, record.Value, err)
+	}
+}
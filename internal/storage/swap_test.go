@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_SwapPrefix(t *testing.T) {
+	store := newTestStore()
+	for key, value := range map[string]string{"live:a": "old-a", "live:b": "old-b", "v2:a": "new-a", "other": "x"} {
+		_, err := store.Set(key, []byte(value), SetOptions{})
+		require.NoError(t, err)
+	}
+	require.NoError(t, store.Expire("v2:a", 60000))
+
+	moved, err := store.SwapPrefix("live:", "v2:")
+	require.NoError(t, err)
+	assert.Equal(t, 3, moved)
+
+	for key, value := range map[string]string{"live:a": "new-a", "v2:a": "old-a", "v2:b": "old-b", "other": "x"} {
+		entry, err := store.Get(key)
+		require.NoError(t, err, key)
+		assert.Equal(t, []byte(value), entry.Value, key)
+	}
+	assert.False(t, store.Exists("live:b"))
+	assert.Greater(t, store.TTL("live:a"), int64(0), "TTLs move with the keys")
+
+	_, err = store.SwapPrefix("v2:", "v2:x")
+	assert.ErrorIs(t, err, ErrPrefixOverlap)
+
+	// Renamed keys must still fit within max_key_bytes
+	store.config.MaxKeyBytes = 7
+	_, err = store.SwapPrefix("v2:", "longer:")
+	assert.ErrorIs(t, err, ErrKeyTooLarge)
+	assert.True(t, store.Exists("v2:a"))
+}
+
+func TestPersistentStore_SwapPrefixRecovery(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, err = ps.Set("live:a", []byte("old"), SetOptions{})
+	require.NoError(t, err)
+	_, err = ps.Set("v2:a", []byte("new"), SetOptions{})
+	require.NoError(t, err)
+	_, err = ps.SwapPrefix("live:", "v2:")
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	entry, err := ps.Get("live:a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new"), entry.Value)
+	entry, err = ps.Get("v2:a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("old"), entry.Value)
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Temperature bucket names
+const (
+	TemperatureHot  = "hot"
+	TemperatureWarm = "warm"
+	TemperatureCold = "cold"
+)
+
+// TemperatureBucket summarizes the keys that fall into one temperature class
+type TemperatureBucket struct {
+	Name    string
+	Keys    int
+	Hits    uint64
+	Samples []string
+}
+
+// KeyTemperature buckets live keys by how recently they were accessed and
+// returns per-bucket counts, total hits and up to samples example keys.
+// Keys read within temperature_hot_ms are hot, within temperature_warm_ms
+// warm, and everything else cold.
+func (s *Store) KeyTemperature(samples int) []TemperatureBucket {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now().UnixMilli()
+	hotCutoff := now - int64(s.config.TemperatureHotMs)
+	warmCutoff := now - int64(s.config.TemperatureWarmMs)
+
+	buckets := []TemperatureBucket{
+		{Name: TemperatureHot},
+		{Name: TemperatureWarm},
+		{Name: TemperatureCold},
+	}
+
+	for key, entry := range s.data {
+		if entry.IsExpired() || IsReserved(key) {
+			continue
+		}
+
+		var b *TemperatureBucket
+		switch lastAccess := entry.LastAccessMs(); {
+		case lastAccess >= hotCutoff:
+			b = &buckets[0]
+		case lastAccess >= warmCutoff:
+			b = &buckets[1]
+		default:
+			b = &buckets[2]
+		}
+
+		b.Keys++
+		b.Hits += uint64(entry.AccessCount())
+
+		// Reservoir sampling keeps the examples uniform across the bucket
+		if len(b.Samples) < samples {
+			b.Samples = append(b.Samples, key)
+		} else if samples > 0 {
+			if j := rand.Intn(b.Keys); j < samples {
+				b.Samples[j] = key
+			}
+		}
+	}
+
+	return buckets
+}
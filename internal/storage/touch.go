@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"container/heap"
+	"fmt"
+	"time"
+)
+
+// Touch records an access to each of keys without reading them, and
+// returns how many of them exist. Keys under a prefix rule with
+// sliding_ttl_ms get that TTL again.
+func (s *Store) Touch(keys ...string) (int, error) {
+	s.stats.CmdTouch.Inc()
+	now := time.Now().UnixMilli()
+	n := 0
+	for _, key := range keys {
+		if err := validateKey(key); err != nil {
+			return n, err
+		}
+		if s.touch(key, s.slidingExpiry(key, now), now) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// slidingExpiry returns the expiry TOUCH gives key, capped by max_ttl_ms,
+// or 0 to leave its expiry alone
+func (s *Store) slidingExpiry(key string, now int64) int64 {
+	ttlMs := s.config.SlidingTTLFor(key)
+	if ttlMs <= 0 || IsReserved(key) {
+		return 0
+	}
+	if maxMs, _ := s.config.MaxTTLFor(key); maxMs > 0 && ttlMs > maxMs {
+		ttlMs = maxMs
+	}
+	return now + ttlMs
+}
+
+// touch records an access to key and, unless expiryMs is 0, sets its
+// expiry. It reports whether the key exists.
+func (s *Store) touch(key string, expiryMs, now int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.data[key]
+	if !exists || entry.IsExpired() {
+		return false
+	}
+	if expiryMs == 0 {
+		entry.touch(now)
+		return true
+	}
+
+	// Copy-on-write: readers may hold the current entry
+	updated := entry.clone()
+	updated.ExpiryMs = expiryMs
+	updated.touch(now)
+	s.put(key, updated)
+	heap.Push(s.expiryHeap, &ExpiryItem{Key: key, ExpiryMs: expiryMs})
+	return true
+}
+
+// Touch is Store.Touch with sliding TTLs logged to the WAL
+func (ps *PersistentStore) Touch(keys ...string) (int, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.Store.stats.CmdTouch.Inc()
+	now := time.Now().UnixMilli()
+	n := 0
+	for _, key := range keys {
+		entry, err := ps.Store.peek(key)
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+
+		expiryMs := ps.Store.slidingExpiry(key, now)
+		if expiryMs != 0 {
+			record := &WALRecord{
+				Type:     RecordTypeEXPIRE,
+				Key:      key,
+				ExpiryMs: expiryMs,
+				Version:  entry.Version,
+			}
+			if err := ps.appendRecord(record, false); err != nil {
+				return n, fmt.Errorf("WAL write failed: %w", err)
+			}
+		}
+		if ps.Store.touch(key, expiryMs, now) {
+			n++
+		}
+	}
+	return n, nil
+}
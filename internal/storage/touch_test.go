@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Touch(t *testing.T) {
+	store := newTestStore()
+	_, err := store.Set("a", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+	_, err = store.Set("b", []byte("v"), SetOptions{ExpiryMs: 60000})
+	require.NoError(t, err)
+
+	before := store.data["a"].LastAccessMs()
+	time.Sleep(2 * time.Millisecond)
+	n, err := store.Touch("a", "b", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Greater(t, store.data["a"].LastAccessMs(), before)
+
+	// Without a sliding TTL rule, TTLs are left alone
+	assert.Equal(t, int64(-1), store.data["a"].ExpiryMs)
+
+	_, err = store.Touch("bad key")
+	assert.ErrorIs(t, err, ErrKeyInvalid)
+}
+
+func TestPersistentStore_TouchSlidingTTL(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.PrefixRules = []config.PrefixRule{{Prefix: "session:", SlidingTTLMs: 60000}}
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, err = ps.Set("session:1", []byte("v"), SetOptions{ExpiryMs: 1000})
+	require.NoError(t, err)
+	_, err = ps.Set("other", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+
+	n, err := ps.Touch("session:1", "other")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Greater(t, ps.TTL("session:1"), int64(50000))
+	assert.Equal(t, int64(-1), ps.TTL("other"))
+	assert.Equal(t, "1", ps.GetStats()["cmd_touch"])
+
+	// The new TTL is logged
+	require.NoError(t, ps.Close())
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	assert.Greater(t, ps.TTL("session:1"), int64(50000))
+}
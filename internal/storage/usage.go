@@ -0,0 +1,56 @@
+package storage
+
+import "strings"
+
+// Usage is the number of keys under a prefix and the bytes of their keys
+// and values
+type Usage struct {
+	Keys  int64
+	Bytes int64
+}
+
+// trackedPrefix is a prefix whose usage the store keeps up to date
+type trackedPrefix struct {
+	prefix string
+	Usage
+}
+
+// TrackUsage counts the keys and bytes under each of prefixes from now
+// on, starting with the keys already stored. Prefixes must not overlap.
+func (s *Store) TrackUsage(prefixes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tracked = make([]trackedPrefix, len(prefixes))
+	for i, prefix := range prefixes {
+		s.tracked[i].prefix = prefix
+	}
+	for key, entry := range s.data {
+		s.account(key, entry, 1)
+	}
+}
+
+// PrefixUsage returns the usage of each prefix passed to TrackUsage
+func (s *Store) PrefixUsage() map[string]Usage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usage := make(map[string]Usage, len(s.tracked))
+	for _, t := range s.tracked {
+		usage[t.prefix] = t.Usage
+	}
+	return usage
+}
+
+// account adds entry, stored under key, to the usage of its tracked
+// prefix, or removes it if sign is -1. The caller holds s.mu for writing.
+func (s *Store) account(key string, entry *Entry, sign int64) {
+	for i := range s.tracked {
+		t := &s.tracked[i]
+		if strings.HasPrefix(key, t.prefix) {
+			t.Keys += sign
+			t.Bytes += sign * (int64(len(key)) + int64(entry.SizeBytes))
+			return
+		}
+	}
+}
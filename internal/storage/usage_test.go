@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_TrackUsage(t *testing.T) {
+	store := newTestStore()
+	_, err := store.Set("a:1", []byte("xx"), SetOptions{})
+	require.NoError(t, err)
+	_, err = store.Set("other", []byte("x"), SetOptions{})
+	require.NoError(t, err)
+
+	// Keys stored before tracking starts are counted
+	store.TrackUsage("a:", "b:")
+	assert.Equal(t, Usage{Keys: 1, Bytes: 5}, store.PrefixUsage()["a:"])
+
+	_, err = store.Set("a:1", []byte("xxxx"), SetOptions{})
+	require.NoError(t, err)
+	_, err = store.Set("b:1", []byte("y"), SetOptions{})
+	require.NoError(t, err)
+	usage := store.PrefixUsage()
+	assert.Equal(t, Usage{Keys: 1, Bytes: 7}, usage["a:"])
+	assert.Equal(t, Usage{Keys: 1, Bytes: 4}, usage["b:"])
+
+	store.Delete("a:1")
+	assert.Equal(t, Usage{}, store.PrefixUsage()["a:"])
+}
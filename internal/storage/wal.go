@@ -14,12 +14,26 @@ import (
 
 const (
 	WALMagic   = 0x4F535057 // 'OSPW'
-	WALVersion = 1
+	WALVersion = 2
+
+	// walVersionV1 records lack the created/updated timestamps
+	walVersionV1 = 1
 
 	// Record types
-	RecordTypeSET    = 0
-	RecordTypeDEL    = 1
-	RecordTypeEXPIRE = 2
+	RecordTypeSET      = 0
+	RecordTypeDEL      = 1
+	RecordTypeEXPIRE   = 2
+	RecordTypeIDEMP    = 3  // Key is the token, Value the key it set
+	RecordTypeBATCH    = 4  // Value holds SET records applied together
+	RecordTypeSWAP     = 5  // Key and Value are the prefixes swapped
+	RecordTypeEVENT    = 6  // Key was removed; Value is the event type, Version its sequence number
+	RecordTypeEVENTACK = 7  // events up to Version were acknowledged
+	RecordTypeHSET     = 8  // Value holds the hash fields set, see encodeHashFields
+	RecordTypeHDEL     = 9  // Value holds the hash fields deleted, without values
+	RecordTypeSADD     = 10 // Value holds the set members added, see encodeMembers
+	RecordTypeSREM     = 11 // Value holds the set members removed
+	RecordTypeZADD     = 12 // Value holds the sorted set members and scores set, see encodeZMembers
+	RecordTypeZREM     = 13 // Value holds the sorted set members removed, see encodeMembers
 )
 
 var (
@@ -30,11 +44,13 @@ var (
 
 // WALRecord represents a single WAL record
 type WALRecord struct {
-	Type     uint8
-	Key      string
-	Value    []byte
-	ExpiryMs int64
-	Version  uint64
+	Type      uint8
+	Key       string
+	Value     []byte
+	ExpiryMs  int64
+	Version   uint64
+	CreatedMs int64 // v2+
+	UpdatedMs int64 // v2+
 }
 
 // WAL represents the write-ahead log
@@ -53,6 +69,8 @@ type WAL struct {
 	// Buffering
 	buffer     []byte
 	bufferSize int64
+
+	onSync func(time.Duration) // called with the duration of each fsync
 }
 
 // NewWAL creates a new WAL file
@@ -71,6 +89,15 @@ func NewWAL(dir string, index int, maxSize int64, syncPolicy string) (*WAL, erro
 		return nil, err
 	}
 
+	// Make a newly created file's directory entry durable; fsyncing the
+	// file alone doesn't cover it
+	if stat.Size() == 0 {
+		if err := syncDir(dir); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
 	return &WAL{
 		file:       file,
 		path:       path,
@@ -84,13 +111,33 @@ func NewWAL(dir string, index int, maxSize int64, syncPolicy string) (*WAL, erro
 
 // Append appends a record to the WAL
 func (w *WAL) Append(record *WALRecord) error {
+	return w.append(true, record)
+}
+
+// AppendNoSync appends a record without applying the sync policy. The
+// record is made durable by the next fsync another write or Sync causes.
+func (w *WAL) AppendNoSync(record *WALRecord) error {
+	return w.append(false, record)
+}
+
+// append writes records with a single write and, if sync is set, applies
+// the sync policy once after the last of them
+func (w *WAL) append(sync bool, records ...*WALRecord) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	// Serialize record
-	data, err := w.serializeRecord(record)
-	if err != nil {
-		return err
+	// Serialize records
+	var data []byte
+	for _, record := range records {
+		encoded, err := w.serializeRecord(record)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			data = encoded
+		} else {
+			data = append(data, encoded...)
+		}
 	}
 
 	// Write to file
@@ -103,19 +150,33 @@ func (w *WAL) Append(record *WALRecord) error {
 	w.syncBytes += int64(n)
 
 	// Handle sync policy
-	if err := w.maybeSync(); err != nil {
-		return err
+	if !sync {
+		return nil
 	}
-
-	return nil
+	return w.maybeSync()
 }
 
 // serializeRecord serializes a WAL record
 func (w *WAL) serializeRecord(record *WALRecord) ([]byte, error) {
+	return encodeWALRecord(record, WALVersion), nil
+}
+
+// walRecordMetaSize returns the size of the fixed metadata section
+// (expiry, version and, from v2, the timestamps)
+func walRecordMetaSize(version uint16) int {
+	if version >= 2 {
+		return 8 + 8 + 8 + 8
+	}
+	return 8 + 8
+}
+
+// encodeWALRecord encodes a record in the given on-disk format version
+func encodeWALRecord(record *WALRecord, version uint16) []byte {
 	keyBytes := []byte(record.Key)
+	metaSize := walRecordMetaSize(version)
 
 	// Calculate total size
-	totalSize := 4 + 2 + 1 + 4 + 4 + 8 + 8 + len(keyBytes) + len(record.Value) + 4
+	totalSize := 4 + 2 + 1 + 4 + 4 + metaSize + len(keyBytes) + len(record.Value) + 4
 	buf := make([]byte, totalSize)
 
 	offset := 0
@@ -125,7 +186,7 @@ func (w *WAL) serializeRecord(record *WALRecord) ([]byte, error) {
 	offset += 4
 
 	// Version
-	binary.LittleEndian.PutUint16(buf[offset:], WALVersion)
+	binary.LittleEndian.PutUint16(buf[offset:], version)
 	offset += 2
 
 	// Record type
@@ -148,6 +209,14 @@ func (w *WAL) serializeRecord(record *WALRecord) ([]byte, error) {
 	binary.LittleEndian.PutUint64(buf[offset:], record.Version)
 	offset += 8
 
+	// Timestamps
+	if version >= 2 {
+		binary.LittleEndian.PutUint64(buf[offset:], uint64(record.CreatedMs))
+		offset += 8
+		binary.LittleEndian.PutUint64(buf[offset:], uint64(record.UpdatedMs))
+		offset += 8
+	}
+
 	// Key
 	copy(buf[offset:], keyBytes)
 	offset += len(keyBytes)
@@ -160,19 +229,20 @@ func (w *WAL) serializeRecord(record *WALRecord) ([]byte, error) {
 	crc := crc32.Checksum(buf[6:offset], crc32.MakeTable(crc32.Castagnoli))
 	binary.LittleEndian.PutUint32(buf[offset:], crc)
 
-	return buf, nil
+	return buf
 }
 
 // maybeSync syncs the WAL based on the sync policy
 func (w *WAL) maybeSync() error {
 	switch w.syncPolicy {
 	case "always":
-		return w.file.Sync()
+		w.syncBytes = 0
+		return w.sync()
 
 	case "batch":
 		// Sync if enough time has passed or enough bytes written
 		if time.Since(w.lastSync) > 100*time.Millisecond || w.syncBytes > 1024*1024 {
-			err := w.file.Sync()
+			err := w.sync()
 			w.lastSync = time.Now()
 			w.syncBytes = 0
 			return err
@@ -185,6 +255,41 @@ func (w *WAL) maybeSync() error {
 	return nil
 }
 
+// sync fsyncs the file, reporting how long it took
+func (w *WAL) sync() error {
+	start := time.Now()
+	err := w.file.Sync()
+	if w.onSync != nil {
+		w.onSync(time.Since(start))
+	}
+	return err
+}
+
+// Sync fsyncs everything written so far, whatever the sync policy
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.sync(); err != nil {
+		return err
+	}
+	w.lastSync = time.Now()
+	w.syncBytes = 0
+	return nil
+}
+
+// UnsyncedBytes returns the bytes written since the last fsync.
+// Always zero under the "os" policy, which leaves flushing to the OS.
+func (w *WAL) UnsyncedBytes() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.syncPolicy == "os" {
+		return 0
+	}
+	return w.syncBytes
+}
+
 // Size returns the current size of the WAL
 func (w *WAL) Size() int64 {
 	w.mu.Lock()
@@ -234,6 +339,12 @@ func OpenWALReader(path string) (*WALReader, error) {
 	}, nil
 }
 
+// NewWALReader reads WAL records from r, such as a file positioned at a
+// record boundary. Close is a no-op for readers made this way.
+func NewWALReader(r io.Reader) *WALReader {
+	return &WALReader{reader: &r}
+}
+
 // ReadRecord reads the next record from the WAL
 func (r *WALReader) ReadRecord() (*WALRecord, error) {
 	reader := *r.reader
@@ -261,7 +372,7 @@ func (r *WALReader) ReadRecord() (*WALRecord, error) {
 
 	// Check version
 	version := binary.LittleEndian.Uint16(restHeader[0:2])
-	if version != WALVersion {
+	if version != walVersionV1 && version != WALVersion {
 		return nil, ErrInvalidVersion
 	}
 
@@ -276,8 +387,8 @@ func (r *WALReader) ReadRecord() (*WALRecord, error) {
 	keyLen := binary.LittleEndian.Uint32(lengths[0:4])
 	valLen := binary.LittleEndian.Uint32(lengths[4:8])
 
-	// Read metadata
-	metadata := make([]byte, 16) // expiry(8) + version(8)
+	// Read metadata: expiry(8) + version(8) [+ created(8) + updated(8)]
+	metadata := make([]byte, walRecordMetaSize(version))
 	if _, err := io.ReadFull(reader, metadata); err != nil {
 		return nil, err
 	}
@@ -285,6 +396,12 @@ func (r *WALReader) ReadRecord() (*WALRecord, error) {
 	expiryMs := int64(binary.LittleEndian.Uint64(metadata[0:8]))
 	recordVersion := binary.LittleEndian.Uint64(metadata[8:16])
 
+	var createdMs, updatedMs int64
+	if version >= 2 {
+		createdMs = int64(binary.LittleEndian.Uint64(metadata[16:24]))
+		updatedMs = int64(binary.LittleEndian.Uint64(metadata[24:32]))
+	}
+
 	// Read key
 	key := make([]byte, keyLen)
 	if _, err := io.ReadFull(reader, key); err != nil {
@@ -312,40 +429,33 @@ func (r *WALReader) ReadRecord() (*WALRecord, error) {
 
 	expectedCRC := binary.LittleEndian.Uint32(crcBytes)
 
-	// Verify CRC
-	dataLen := 1 + 4 + 4 + 8 + 8 + len(key) + len(value)
-	data := make([]byte, dataLen)
-	offset := 0
+	// Verify CRC over type, lengths, metadata, key and value
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	crc.Write([]byte{recordType})
+	crc.Write(lengths)
+	crc.Write(metadata)
+	crc.Write(key)
+	crc.Write(value)
 
-	data[offset] = recordType
-	offset += 1
-	binary.LittleEndian.PutUint32(data[offset:], keyLen)
-	offset += 4
-	binary.LittleEndian.PutUint32(data[offset:], valLen)
-	offset += 4
-	binary.LittleEndian.PutUint64(data[offset:], uint64(expiryMs))
-	offset += 8
-	binary.LittleEndian.PutUint64(data[offset:], recordVersion)
-	offset += 8
-	copy(data[offset:], key)
-	offset += len(key)
-	copy(data[offset:], value)
-
-	actualCRC := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
-	if actualCRC != expectedCRC {
+	if crc.Sum32() != expectedCRC {
 		return nil, ErrCorruptedRecord
 	}
 
 	return &WALRecord{
-		Type:     recordType,
-		Key:      string(key),
-		Value:    value,
-		ExpiryMs: expiryMs,
-		Version:  recordVersion,
+		Type:      recordType,
+		Key:       string(key),
+		Value:     value,
+		ExpiryMs:  expiryMs,
+		Version:   recordVersion,
+		CreatedMs: createdMs,
+		UpdatedMs: updatedMs,
 	}, nil
 }
 
 // Close closes the WAL reader
 func (r *WALReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
 	return r.file.Close()
 }
@@ -8,29 +8,52 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/latency"
+	"github.com/bharatmehan/osprey/internal/metrics"
 )
 
 // WALManager manages WAL files and rotation
 type WALManager struct {
 	mu         sync.Mutex
-	dataDir    string
+	walDir     string
 	currentWAL *WAL
 	walIndex   int
 	config     *config.Config
+	latency    *latency.Monitor // set before the first append
+
+	fsyncLatency *metrics.Histogram // likewise
 }
 
 // NewWALManager creates a new WAL manager
 func NewWALManager(cfg *config.Config) (*WALManager, error) {
-	// Ensure data directory exists
+	walDir := cfg.WALDirectory()
+
+	// Ensure data and WAL directories exist
 	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
 		return nil, err
 	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		return nil, err
+	}
 
 	manager := &WALManager{
-		dataDir: cfg.DataDir,
-		config:  cfg,
+		walDir: walDir,
+		config: cfg,
+	}
+
+	// Refuse to start if wal_dir was changed while WALs remain in the old
+	// location: recovery would silently skip them
+	if filepath.Clean(walDir) != filepath.Clean(cfg.DataDir) {
+		stranded, err := listWALFilesIn(cfg.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		if len(stranded) > 0 {
+			return nil, fmt.Errorf("found %d WAL files in %s but wal_dir is %s; move them before starting", len(stranded), cfg.DataDir, walDir)
+		}
 	}
 
 	// Find the next WAL index
@@ -52,10 +75,11 @@ func NewWALManager(cfg *config.Config) (*WALManager, error) {
 	}
 
 	// Create initial WAL
-	wal, err := NewWAL(cfg.DataDir, manager.walIndex, cfg.WALMaxBytes, cfg.SyncPolicy)
+	wal, err := NewWAL(walDir, manager.walIndex, cfg.WALMaxBytes, cfg.SyncPolicy)
 	if err != nil {
 		return nil, err
 	}
+	wal.onSync = manager.recordSync
 	manager.currentWAL = wal
 
 	return manager, nil
@@ -63,6 +87,27 @@ func NewWALManager(cfg *config.Config) (*WALManager, error) {
 
 // AppendRecord appends a record to the current WAL
 func (m *WALManager) AppendRecord(record *WALRecord) error {
+	return m.appendRecords(true, record)
+}
+
+// AppendRecordNoSync appends a record to the current WAL without waiting
+// for an fsync, see WAL.AppendNoSync
+func (m *WALManager) AppendRecordNoSync(record *WALRecord) error {
+	return m.appendRecords(false, record)
+}
+
+// AppendRecords appends records to the current WAL together, with one
+// fsync at most for all of them
+func (m *WALManager) AppendRecords(records []*WALRecord) error {
+	return m.appendRecords(true, records...)
+}
+
+// AppendRecordsNoSync is AppendRecords without waiting for an fsync
+func (m *WALManager) AppendRecordsNoSync(records []*WALRecord) error {
+	return m.appendRecords(false, records...)
+}
+
+func (m *WALManager) appendRecords(sync bool, records ...*WALRecord) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -73,7 +118,7 @@ func (m *WALManager) AppendRecord(record *WALRecord) error {
 		}
 	}
 
-	return m.currentWAL.Append(record)
+	return m.currentWAL.append(sync, records...)
 }
 
 // rotateWAL rotates to a new WAL file
@@ -85,18 +130,71 @@ func (m *WALManager) rotateWAL() error {
 
 	// Create new WAL
 	m.walIndex++
-	wal, err := NewWAL(m.config.DataDir, m.walIndex, m.config.WALMaxBytes, m.config.SyncPolicy)
+	wal, err := NewWAL(m.walDir, m.walIndex, m.config.WALMaxBytes, m.config.SyncPolicy)
 	if err != nil {
 		return err
 	}
+	wal.onSync = m.recordSync
 
 	m.currentWAL = wal
 	return nil
 }
 
+// Sync fsyncs the current WAL file and returns its name
+func (m *WALManager) Sync() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.currentWAL.Sync(); err != nil {
+		return "", err
+	}
+	return filepath.Base(m.currentWAL.Path()), nil
+}
+
+// Cut fsyncs the current WAL file, starts a new one and returns the name
+// of the old one. No record is split across the two.
+func (m *WALManager) Cut() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.currentWAL.Sync(); err != nil {
+		return "", err
+	}
+	name := filepath.Base(m.currentWAL.Path())
+	if err := m.rotateWAL(); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// recordSync reports an fsync's duration to the latency monitor and the
+// fsync_latency histogram
+func (m *WALManager) recordSync(d time.Duration) {
+	m.latency.Record(latency.EventFsync, d)
+	if m.fsyncLatency != nil {
+		m.fsyncLatency.Observe(d)
+	}
+}
+
+// Rotate starts a new WAL file and returns its name
+func (m *WALManager) Rotate() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.rotateWAL(); err != nil {
+		return "", err
+	}
+	return filepath.Base(m.currentWAL.Path()), nil
+}
+
 // listWALFiles lists all WAL files in order
 func (m *WALManager) listWALFiles() ([]string, error) {
-	files, err := os.ReadDir(m.dataDir)
+	return listWALFilesIn(m.walDir)
+}
+
+// listWALFilesIn lists the WAL files in dir in order
+func listWALFilesIn(dir string) ([]string, error) {
+	files, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
@@ -135,7 +233,7 @@ func (m *WALManager) GetWALsForReplay(startWAL string) ([]string, error) {
 		// Return all WAL files
 		var paths []string
 		for _, file := range walFiles {
-			paths = append(paths, filepath.Join(m.dataDir, file))
+			paths = append(paths, filepath.Join(m.walDir, file))
 		}
 		return paths, nil
 	}
@@ -156,7 +254,7 @@ func (m *WALManager) GetWALsForReplay(startWAL string) ([]string, error) {
 	// Return WALs from starting point
 	var paths []string
 	for i := startIndex; i < len(walFiles); i++ {
-		paths = append(paths, filepath.Join(m.dataDir, walFiles[i]))
+		paths = append(paths, filepath.Join(m.walDir, walFiles[i]))
 	}
 
 	return paths, nil
@@ -171,7 +269,7 @@ func (m *WALManager) DeleteOldWALs(keepFromWAL string) error {
 
 	for _, file := range walFiles {
 		if file < keepFromWAL {
-			path := filepath.Join(m.dataDir, file)
+			path := filepath.Join(m.walDir, file)
 			if err := os.Remove(path); err != nil {
 				return err
 			}
@@ -192,6 +290,17 @@ func (m *WALManager) Close() error {
 	return nil
 }
 
+// BacklogBytes returns the bytes appended to the current WAL but not yet fsynced
+func (m *WALManager) BacklogBytes() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.currentWAL == nil {
+		return 0
+	}
+	return m.currentWAL.UnsyncedBytes()
+}
+
 // GetCurrentWALName returns the name of the current WAL file
 func (m *WALManager) GetCurrentWALName() string {
 	m.mu.Lock()
@@ -202,3 +311,11 @@ func (m *WALManager) GetCurrentWALName() string {
 	}
 	return ""
 }
+
+// Position returns the name and size of the current WAL file
+func (m *WALManager) Position() (string, int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return filepath.Base(m.currentWAL.Path()), m.currentWAL.Size()
+}
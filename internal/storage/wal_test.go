@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/bharatmehan/osprey/internal/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -278,3 +279,177 @@ func TestWAL_IsFull(t *testing.T) {
 
 	wal.Close()
 }
+
+func TestWAL_Timestamps(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "osprey-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	wal, err := NewWAL(tempDir, 1, 1024*1024, "os")
+	require.NoError(t, err)
+
+	record := &WALRecord{
+		Type:      RecordTypeSET,
+		Key:       "key1",
+		Value:     []byte("value1"),
+		ExpiryMs:  -1,
+		Version:   3,
+		CreatedMs: 1700000000000,
+		UpdatedMs: 1700000005000,
+	}
+
+	err = wal.Append(record)
+	require.NoError(t, err)
+	wal.Close()
+
+	reader, err := OpenWALReader(wal.Path())
+	require.NoError(t, err)
+	defer reader.Close()
+
+	readRecord, err := reader.ReadRecord()
+	require.NoError(t, err)
+
+	assert.Equal(t, record.CreatedMs, readRecord.CreatedMs)
+	assert.Equal(t, record.UpdatedMs, readRecord.UpdatedMs)
+}
+
+func TestWAL_ReadV1Record(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "osprey-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	// Write a record in the v1 layout, without timestamps
+	record := &WALRecord{
+		Type:      RecordTypeSET,
+		Key:       "legacy",
+		Value:     []byte("value"),
+		ExpiryMs:  -1,
+		Version:   7,
+		CreatedMs: 1700000000000,
+	}
+
+	path := filepath.Join(tempDir, "legacy.oswal")
+	err = os.WriteFile(path, encodeWALRecord(record, walVersionV1), 0644)
+	require.NoError(t, err)
+
+	reader, err := OpenWALReader(path)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	readRecord, err := reader.ReadRecord()
+	require.NoError(t, err)
+
+	assert.Equal(t, record.Key, readRecord.Key)
+	assert.Equal(t, record.Value, readRecord.Value)
+	assert.Equal(t, record.Version, readRecord.Version)
+	assert.Equal(t, int64(0), readRecord.CreatedMs)
+	assert.Equal(t, int64(0), readRecord.UpdatedMs)
+}
+
+func TestWALManager_SeparateDir(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cfg := config.DefaultConfig()
+	cfg.DataDir = filepath.Join(tempDir, "data")
+	cfg.WALDir = filepath.Join(tempDir, "wal")
+
+	manager, err := NewWALManager(cfg)
+	require.NoError(t, err)
+	require.NoError(t, manager.AppendRecord(&WALRecord{Type: RecordTypeSET, Key: "k", Value: []byte("v")}))
+	require.NoError(t, manager.Close())
+
+	walPaths, err := manager.GetWALsForReplay("")
+	require.NoError(t, err)
+	require.Len(t, walPaths, 1)
+	assert.Equal(t, cfg.WALDir, filepath.Dir(walPaths[0]))
+
+	files, err := filepath.Glob(filepath.Join(cfg.DataDir, "wal-*.oswal"))
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestWALManager_Cut(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.SyncPolicy = "os"
+
+	manager, err := NewWALManager(cfg)
+	require.NoError(t, err)
+	defer manager.Close()
+	require.NoError(t, manager.AppendRecord(&WALRecord{Type: RecordTypeSET, Key: "k", Value: []byte("v")}))
+
+	name, err := manager.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, manager.GetCurrentWALName(), name)
+
+	cut, err := manager.Cut()
+	require.NoError(t, err)
+	assert.Equal(t, name, cut)
+	assert.NotEqual(t, cut, manager.GetCurrentWALName())
+
+	// The old file holds the record; new records go to the new one
+	require.NoError(t, manager.AppendRecord(&WALRecord{Type: RecordTypeDEL, Key: "k"}))
+	reader, err := OpenWALReader(filepath.Join(cfg.DataDir, cut))
+	require.NoError(t, err)
+	defer reader.Close()
+	record, err := reader.ReadRecord()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(RecordTypeSET), record.Type)
+	_, err = reader.ReadRecord()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestWALManager_StrandedWALs(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cfg := config.DefaultConfig()
+	cfg.DataDir = tempDir
+
+	manager, err := NewWALManager(cfg)
+	require.NoError(t, err)
+	require.NoError(t, manager.Close())
+
+	// Moving wal_dir with WALs left behind must not silently drop them
+	cfg.WALDir = filepath.Join(tempDir, "wal")
+	_, err = NewWALManager(cfg)
+	assert.Error(t, err)
+}
+
+func TestPersistentStore_NoSync(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.SyncPolicy = "always"
+	cfg.PrefixRules = []config.PrefixRule{{Prefix: "cache:", NoSync: true}}
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	fsyncs := func() string { return ps.GetStats()["fsync_latency_count"] }
+	before := fsyncs()
+
+	// Neither NOSYNC nor a no_sync prefix waits for an fsync
+	_, err = ps.Set("important", []byte("v"), SetOptions{NoSync: true})
+	require.NoError(t, err)
+	_, err = ps.Set("cache:page", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+	_, _, err = ps.Append("cache:page", []byte("w"))
+	require.NoError(t, err)
+	assert.Equal(t, before, fsyncs())
+	assert.Equal(t, "3", ps.GetStats()["wal_nosync_writes_total"])
+	assert.Positive(t, ps.WALBacklogBytes())
+
+	// Other writes still do, and make the earlier records durable too
+	_, err = ps.Set("other", []byte("v"), SetOptions{})
+	require.NoError(t, err)
+	assert.NotEqual(t, before, fsyncs())
+	assert.Zero(t, ps.WALBacklogBytes())
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	entry, err := ps.Get("cache:page")
+	require.NoError(t, err)
+	assert.Equal(t, "vw", string(entry.Value))
+	_, err = ps.Get("important")
+	require.NoError(t, err)
+}
@@ -0,0 +1,595 @@
+package storage
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ZMember is a member of a sorted set and its score
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// less orders sorted set members by score, then by member
+func (m ZMember) less(o ZMember) bool {
+	return m.Score < o.Score || (m.Score == o.Score && m.Member < o.Member)
+}
+
+// SortedSet is the value of a sorted set. Like the other value types it is
+// never modified once stored, so readers and snapshots use it without
+// locks. Its members are kept in two persistent treaps, one ordered by
+// score and counting subtree sizes for rank queries, one by member for
+// score lookups. An update copies only the O(log n) nodes on its path and
+// shares the rest with the set it was made from.
+type SortedSet struct {
+	byScore  *zNode // ordered by score, then member
+	byMember *zNode // ordered by member
+	bytes    int    // see size
+}
+
+// zNode is a treap node. Nodes are shared between versions of a set, so
+// one is only changed right after it was copied.
+type zNode struct {
+	m           ZMember
+	priority    uint32 // heap-ordered, so the tree stays balanced
+	count       int    // nodes in the subtree
+	left, right *zNode
+}
+
+func (n *zNode) size() int {
+	if n == nil {
+		return 0
+	}
+	return n.count
+}
+
+// copy returns a node that may be changed, with the same fields as n
+func (n *zNode) copy() *zNode {
+	c := *n
+	return &c
+}
+
+func (n *zNode) recount() {
+	n.count = n.left.size() + n.right.size() + 1
+}
+
+// lessByMember orders the nodes of the byMember treap
+func lessByMember(a, b ZMember) bool {
+	return a.Member < b.Member
+}
+
+// zInsert returns the tree n with m added; m must not be in it
+func zInsert(n *zNode, m ZMember, priority uint32, less func(a, b ZMember) bool) *zNode {
+	if n == nil {
+		return &zNode{m: m, priority: priority, count: 1}
+	}
+	c := n.copy()
+	if less(m, n.m) {
+		c.left = zInsert(n.left, m, priority, less)
+		if c.left.priority > c.priority {
+			// Rotate right; c.left was copied by the insert
+			top := c.left
+			c.left, top.right = top.right, c
+			c.recount()
+			top.recount()
+			return top
+		}
+	} else {
+		c.right = zInsert(n.right, m, priority, less)
+		if c.right.priority > c.priority {
+			top := c.right
+			c.right, top.left = top.left, c
+			c.recount()
+			top.recount()
+			return top
+		}
+	}
+	c.recount()
+	return c
+}
+
+// zDelete returns the tree n without m, which must be in it
+func zDelete(n *zNode, m ZMember, less func(a, b ZMember) bool) *zNode {
+	switch {
+	case less(m, n.m):
+		c := n.copy()
+		c.left = zDelete(n.left, m, less)
+		c.recount()
+		return c
+	case less(n.m, m):
+		c := n.copy()
+		c.right = zDelete(n.right, m, less)
+		c.recount()
+		return c
+	}
+	return zJoin(n.left, n.right)
+}
+
+// zJoin returns a tree of the nodes of a and b, all of a's ordered first
+func zJoin(a, b *zNode) *zNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.priority > b.priority {
+		c := a.copy()
+		c.right = zJoin(a.right, b)
+		c.recount()
+		return c
+	}
+	c := b.copy()
+	c.left = zJoin(a, b.left)
+	c.recount()
+	return c
+}
+
+// appendRanks appends the members ranked start to stop within n, which
+// must overlap it
+func (n *zNode) appendRanks(out []ZMember, start, stop int) []ZMember {
+	if n == nil || start > stop {
+		return out
+	}
+	left := n.left.size()
+	if start < left {
+		out = n.left.appendRanks(out, start, min(stop, left-1))
+	}
+	if start <= left && left <= stop {
+		out = append(out, n.m)
+	}
+	if stop > left {
+		out = n.right.appendRanks(out, max(start-left-1, 0), stop-left-1)
+	}
+	return out
+}
+
+// appendScores appends the members of n scored from min to max
+func (n *zNode) appendScores(out []ZMember, min, max float64) []ZMember {
+	if n == nil {
+		return out
+	}
+	if n.m.Score >= min {
+		out = n.left.appendScores(out, min, max)
+	}
+	if n.m.Score >= min && n.m.Score <= max {
+		out = append(out, n.m)
+	}
+	if n.m.Score <= max {
+		out = n.right.appendScores(out, min, max)
+	}
+	return out
+}
+
+// newSortedSet returns a sorted set of members, later entries for the same
+// member replacing earlier ones
+func newSortedSet(members []ZMember) *SortedSet {
+	z, _, _ := (&SortedSet{}).with(members)
+	return z
+}
+
+// Len returns the number of members
+func (z *SortedSet) Len() int {
+	return z.byScore.size()
+}
+
+// Members returns the members in order
+func (z *SortedSet) Members() []ZMember {
+	return z.byScore.appendRanks(make([]ZMember, 0, z.Len()), 0, z.Len()-1)
+}
+
+// Score returns the score of member, if it is in the set
+func (z *SortedSet) Score(member string) (float64, bool) {
+	for n := z.byMember; n != nil; {
+		switch {
+		case member < n.m.Member:
+			n = n.left
+		case member > n.m.Member:
+			n = n.right
+		default:
+			return n.m.Score, true
+		}
+	}
+	return 0, false
+}
+
+// Range returns the members ranked start to stop, inclusive, counting
+// from 0. Negative ranks count back from the highest, -1 being the last.
+func (z *SortedSet) Range(start, stop int64) []ZMember {
+	n := int64(z.Len())
+	if start < 0 {
+		start = max(start+n, 0)
+	}
+	if stop < 0 {
+		stop += n
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return nil
+	}
+	return z.byScore.appendRanks(make([]ZMember, 0, stop-start+1), int(start), int(stop))
+}
+
+// RangeByScore returns the members scored from min to max, inclusive
+func (z *SortedSet) RangeByScore(min, max float64) []ZMember {
+	return z.byScore.appendScores(nil, min, max)
+}
+
+// size returns the bytes held by the set, counted against max_value_bytes
+// and reported as its size: each member plus 8 bytes for its score
+func (z *SortedSet) size() int {
+	return z.bytes
+}
+
+// with returns the set with updates applied, and the number of members
+// added and of those whose score changed. z itself is left as it was.
+func (z *SortedSet) with(updates []ZMember) (*SortedSet, int, int) {
+	final := make(map[string]float64, len(updates))
+	for _, u := range updates {
+		final[u.Member] = u.Score
+	}
+
+	result := *z
+	added, changed := 0, 0
+	for _, u := range updates {
+		score, pending := final[u.Member]
+		if !pending {
+			continue // a later entry for the member was applied
+		}
+		delete(final, u.Member)
+
+		old, exists := z.Score(u.Member)
+		if exists && old == score {
+			continue
+		}
+		m := ZMember{Member: u.Member, Score: score}
+		if exists {
+			changed++
+			prev := ZMember{Member: u.Member, Score: old}
+			result.byScore = zDelete(result.byScore, prev, ZMember.less)
+			result.byMember = zDelete(result.byMember, prev, lessByMember)
+		} else {
+			added++
+			result.bytes += len(u.Member) + 8
+		}
+		result.byScore = zInsert(result.byScore, m, rand.Uint32(), ZMember.less)
+		result.byMember = zInsert(result.byMember, m, rand.Uint32(), lessByMember)
+	}
+	return &result, added, changed
+}
+
+// without returns the set without members, and the number removed. z
+// itself is left as it was.
+func (z *SortedSet) without(members []string) (*SortedSet, int) {
+	result := *z
+	removed := 0
+	for _, member := range members {
+		score, ok := result.Score(member)
+		if !ok {
+			continue
+		}
+		m := ZMember{Member: member, Score: score}
+		result.byScore = zDelete(result.byScore, m, ZMember.less)
+		result.byMember = zDelete(result.byMember, m, lessByMember)
+		result.bytes -= len(member) + 8
+		removed++
+	}
+	if removed == 0 {
+		return z, 0
+	}
+	return &result, removed
+}
+
+// encodeZMembers encodes sorted set members as held by ZADD records and
+// snapshots: count(4), then score(8) + length(4) + member for each member
+func encodeZMembers(members []ZMember) []byte {
+	size := 4
+	for _, m := range members {
+		size += 12 + len(m.Member)
+	}
+	data := make([]byte, 0, size)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(members)))
+	for _, m := range members {
+		data = binary.LittleEndian.AppendUint64(data, math.Float64bits(m.Score))
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(m.Member)))
+		data = append(data, m.Member...)
+	}
+	return data
+}
+
+// DecodeZMembers decodes the members of a ZADD record
+func DecodeZMembers(data []byte) ([]ZMember, error) {
+	errShort := errors.New("sorted set members cut short")
+	if len(data) < 4 {
+		return nil, errShort
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	if uint64(count) > uint64(len(data)/12) {
+		return nil, errShort
+	}
+
+	members := make([]ZMember, 0, count)
+	for i := uint32(0); i < count; i++ {
+		if len(data) < 12 {
+			return nil, errShort
+		}
+		score := math.Float64frombits(binary.LittleEndian.Uint64(data))
+		n := binary.LittleEndian.Uint32(data[8:])
+		if uint64(len(data)-12) < uint64(n) {
+			return nil, errShort
+		}
+		members = append(members, ZMember{Member: string(data[12 : 12+n]), Score: score})
+		data = data[12+n:]
+	}
+	if len(data) > 0 {
+		return nil, fmt.Errorf("%d bytes after the sorted set members", len(data))
+	}
+	return members, nil
+}
+
+// ZAdd sets the scores of members of the sorted set at key, creating it
+// if needed, and returns the number of members added and the set's
+// version. Members already in the set are moved to their new score. A new
+// set gets the prefix's default TTL; an existing one keeps its expiry. It
+// fails with ErrWrongType if key holds another type.
+func (s *Store) ZAdd(key string, members []ZMember) (int, uint64, error) {
+	if err := validateKey(key); err != nil {
+		return 0, 0, err
+	}
+	for _, m := range members {
+		if err := validateKey(m.Member); err != nil {
+			return 0, 0, fmt.Errorf("member %w", err)
+		}
+		if math.IsNaN(m.Score) || math.IsInf(m.Score, 0) {
+			return 0, 0, ErrNotFloat
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.CmdZAdd.Inc()
+
+	now := time.Now().UnixMilli()
+	existing, exists := s.data[key]
+	live := exists && !existing.IsExpired()
+	if live && existing.ZSet == nil {
+		return 0, 0, ErrWrongType
+	}
+
+	zset := &SortedSet{}
+	var newVersion uint64 = 1
+	createdMs := now
+	var expiryMs int64
+	if live {
+		zset = existing.ZSet
+		newVersion = existing.Version + 1
+		createdMs = existing.CreatedMs
+		expiryMs = existing.ExpiryMs
+	} else {
+		var err error
+		expiryMs, err = s.ttlPolicy(key, -1, now)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// Copy-on-write: readers may hold the current set
+	zset, added, changed := zset.with(members)
+	if added == 0 && changed == 0 {
+		if live {
+			return 0, existing.Version, nil
+		}
+		return 0, 0, nil
+	}
+	size := zset.size()
+	if size > s.config.MaxValueBytesFor(key) {
+		return 0, 0, ErrValueTooLarge
+	}
+
+	s.put(key, &Entry{
+		ZSet:         zset,
+		Version:      newVersion,
+		ExpiryMs:     expiryMs,
+		SizeBytes:    uint32(size),
+		CreatedMs:    createdMs,
+		UpdatedMs:    now,
+		lastAccessMs: now,
+	})
+	s.grew()
+
+	if expiryMs > 0 && !live {
+		heap.Push(s.expiryHeap, &ExpiryItem{Key: key, ExpiryMs: expiryMs})
+	}
+
+	return added, newVersion, nil
+}
+
+// ZRem removes members from the sorted set at key and returns the number
+// removed and the set's version. The key is deleted with its last member.
+func (s *Store) ZRem(key string, members ...string) (int, uint64, error) {
+	if err := validateKey(key); err != nil {
+		return 0, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.CmdZAdd.Inc()
+
+	existing, exists := s.data[key]
+	if !exists || existing.IsExpired() {
+		return 0, 0, nil
+	}
+	if existing.ZSet == nil {
+		return 0, 0, ErrWrongType
+	}
+
+	zset, removed := existing.ZSet.without(members)
+	if removed == 0 {
+		return 0, existing.Version, nil
+	}
+
+	newVersion := existing.Version + 1
+	if zset.Len() == 0 {
+		s.drop(key)
+		s.removed(key, EventDeleted)
+		return removed, newVersion, nil
+	}
+
+	updated := existing.clone()
+	updated.ZSet = zset
+	updated.Version = newVersion
+	updated.SizeBytes = uint32(zset.size())
+	updated.touch(time.Now().UnixMilli())
+	updated.UpdatedMs = updated.lastAccessMs
+	s.put(key, updated)
+
+	return removed, newVersion, nil
+}
+
+// GetSortedSet returns the sorted set at key, for ZRANGE and
+// ZRANGEBYSCORE. It fails with ErrWrongType if key holds another type.
+func (s *Store) GetSortedSet(key string) (*Entry, error) {
+	entry, err := s.read(key, s.stats.CmdZRange)
+	if err != nil {
+		return nil, err
+	}
+	if entry.ZSet == nil {
+		return nil, ErrWrongType
+	}
+	return entry, nil
+}
+
+// ZAdd is Store.ZAdd logged to the WAL as a ZADD record of the members
+// given
+func (ps *PersistentStore) ZAdd(key string, members []ZMember) (int, uint64, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	prev, _ := ps.Store.peek(key)
+
+	added, version, err := ps.Store.ZAdd(key, members)
+	if err != nil || version == 0 || (prev != nil && version == prev.Version) {
+		return added, version, err
+	}
+
+	entry := ps.Store.lookup(key)
+	record := &WALRecord{
+		Type:      RecordTypeZADD,
+		Key:       key,
+		Value:     encodeZMembers(members),
+		ExpiryMs:  entry.ExpiryMs,
+		Version:   entry.Version,
+		CreatedMs: entry.CreatedMs,
+		UpdatedMs: entry.UpdatedMs,
+	}
+
+	if err := ps.appendRecord(record, false); err != nil {
+		// Rollback
+		ps.Store.restore(key, prev)
+		return 0, 0, fmt.Errorf("WAL write failed: %w", err)
+	}
+
+	return added, version, nil
+}
+
+// ZRem is Store.ZRem logged to the WAL as a ZREM record of the members
+// removed
+func (ps *PersistentStore) ZRem(key string, members ...string) (int, uint64, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	prev, _ := ps.Store.peek(key)
+
+	removed, version, err := ps.Store.ZRem(key, members...)
+	if err != nil || removed == 0 {
+		return removed, version, err
+	}
+
+	var gone []string
+	seen := make(map[string]bool, removed)
+	for _, m := range members {
+		if _, ok := prev.ZSet.Score(m); ok && !seen[m] {
+			gone = append(gone, m)
+			seen[m] = true
+		}
+	}
+	record := &WALRecord{
+		Type:      RecordTypeZREM,
+		Key:       key,
+		Value:     encodeMembers(gone),
+		Version:   version,
+		UpdatedMs: time.Now().UnixMilli(),
+	}
+
+	if err := ps.appendRecord(record, false); err != nil {
+		// Rollback
+		ps.Store.restore(key, prev)
+		return 0, 0, fmt.Errorf("WAL write failed: %w", err)
+	}
+
+	return removed, version, nil
+}
+
+// applyZAddRecord applies a ZADD record during recovery. The record
+// replaces another type at the key, as the write it logs could only have
+// been made once that value was gone.
+func (ps *PersistentStore) applyZAddRecord(record *WALRecord) error {
+	members, err := DecodeZMembers(record.Value)
+	if err != nil {
+		return fmt.Errorf("ZADD record for %s: %w", record.Key, err)
+	}
+
+	key := ps.NormalizeKey(record.Key)
+	zset := &SortedSet{}
+	if existing, exists := ps.Store.data[key]; exists && existing.ZSet != nil {
+		zset = existing.ZSet
+	}
+	zset, _, _ = zset.with(members)
+
+	ps.Store.put(key, &Entry{
+		ZSet:      zset,
+		Version:   record.Version,
+		ExpiryMs:  record.ExpiryMs,
+		SizeBytes: uint32(zset.size()),
+		CreatedMs: record.CreatedMs,
+		UpdatedMs: record.UpdatedMs,
+	})
+	ps.Store.grew()
+	return nil
+}
+
+// applyZRemRecord applies a ZREM record during recovery
+func (ps *PersistentStore) applyZRemRecord(record *WALRecord) error {
+	members, err := DecodeMembers(record.Value)
+	if err != nil {
+		return fmt.Errorf("ZREM record for %s: %w", record.Key, err)
+	}
+
+	key := ps.NormalizeKey(record.Key)
+	existing, exists := ps.Store.data[key]
+	if !exists || existing.ZSet == nil {
+		return nil
+	}
+	zset, _ := existing.ZSet.without(members)
+	if zset.Len() == 0 {
+		ps.Store.drop(key)
+		return nil
+	}
+
+	updated := existing.clone()
+	updated.ZSet = zset
+	updated.Version = record.Version
+	updated.SizeBytes = uint32(zset.size())
+	updated.UpdatedMs = record.UpdatedMs
+	ps.Store.put(key, updated)
+	return nil
+}
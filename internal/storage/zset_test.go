@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_SortedSet(t *testing.T) {
+	store := newTestStore()
+
+	added, version, err := store.ZAdd("board", []ZMember{{"ann", 3}, {"bob", 1}, {"cat", 2}, {"bob", 1.5}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, added)
+	assert.Equal(t, uint64(1), version)
+
+	// Adding members with their current scores changes nothing
+	added, version, err = store.ZAdd("board", []ZMember{{"ann", 3}})
+	require.NoError(t, err)
+	assert.Zero(t, added)
+	assert.Equal(t, uint64(1), version)
+
+	// Changing a score reorders the member
+	added, version, err = store.ZAdd("board", []ZMember{{"ann", 0}, {"dan", 2}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, uint64(2), version)
+
+	entry, err := store.GetSortedSet("board")
+	require.NoError(t, err)
+	z := entry.ZSet
+	assert.Equal(t, []ZMember{{"ann", 0}, {"bob", 1.5}, {"cat", 2}, {"dan", 2}}, z.Members())
+	assert.Equal(t, uint32(4*(3+8)), entry.SizeBytes)
+	assert.Equal(t, TypeZSet, entry.Type())
+	score, ok := z.Score("cat")
+	assert.True(t, ok)
+	assert.Equal(t, 2.0, score)
+
+	assert.Equal(t, []ZMember{{"bob", 1.5}, {"cat", 2}}, z.Range(1, 2))
+	assert.Equal(t, []ZMember{{"cat", 2}, {"dan", 2}}, z.Range(-2, -1))
+	assert.Equal(t, z.Members(), z.Range(-100, 100))
+	assert.Empty(t, z.Range(3, 1))
+	assert.Equal(t, []ZMember{{"bob", 1.5}, {"cat", 2}, {"dan", 2}}, z.RangeByScore(1, 2))
+	assert.Equal(t, z.Members(), z.RangeByScore(math.Inf(-1), math.Inf(1)))
+	assert.Empty(t, z.RangeByScore(5, 10))
+
+	// Sorted sets and other types don't mix
+	_, err = store.Get("board")
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, err = store.GetSet("board")
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, _, err = store.SAdd("board", "a")
+	assert.ErrorIs(t, err, ErrWrongType)
+	store.Set("s", []byte("v"), SetOptions{})
+	_, _, err = store.ZAdd("s", []ZMember{{"a", 1}})
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, err = store.GetSortedSet("s")
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, _, err = store.ZAdd("z2", []ZMember{{"bad member", 1}})
+	assert.ErrorIs(t, err, ErrKeyInvalid)
+	_, _, err = store.ZAdd("z2", []ZMember{{"a", math.NaN()}})
+	assert.ErrorIs(t, err, ErrNotFloat)
+
+	removed, version, err := store.ZRem("board", "ann", "missing", "ann")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, uint64(3), version)
+
+	// Removing the last member deletes the key
+	removed, _, err = store.ZRem("board", "bob", "cat", "dan")
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	assert.False(t, store.Exists("board"))
+}
+
+func TestSortedSet_Updates(t *testing.T) {
+	// Check the treaps against a map after random updates
+	rng := rand.New(rand.NewSource(1))
+	scores := make(map[string]float64)
+	z := &SortedSet{}
+	var versions []*SortedSet
+	var expected [][]ZMember
+	for i := 0; i < 2000; i++ {
+		member := fmt.Sprintf("m%d", rng.Intn(300))
+		if rng.Intn(3) == 0 {
+			z, _ = z.without([]string{member})
+			delete(scores, member)
+		} else {
+			score := float64(rng.Intn(50))
+			z, _, _ = z.with([]ZMember{{member, score}})
+			scores[member] = score
+		}
+
+		if i%100 == 0 {
+			versions = append(versions, z)
+			expected = append(expected, z.Members())
+		}
+	}
+
+	var want []ZMember
+	size := 0
+	for member, score := range scores {
+		want = append(want, ZMember{member, score})
+		size += len(member) + 8
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i].less(want[j]) })
+	assert.Equal(t, want, z.Members())
+	assert.Equal(t, size, z.size())
+	for member, score := range scores {
+		got, ok := z.Score(member)
+		assert.True(t, ok)
+		assert.Equal(t, score, got)
+	}
+	assert.Equal(t, want[10:20], z.Range(10, 19))
+	assert.Equal(t, want[len(want)-5:], z.Range(-5, -1))
+	var scored []ZMember
+	for _, m := range want {
+		if m.Score >= 10 && m.Score <= 20 {
+			scored = append(scored, m)
+		}
+	}
+	assert.Equal(t, scored, z.RangeByScore(10, 20))
+
+	// Earlier versions are left as they were
+	for i, v := range versions {
+		assert.Equal(t, expected[i], v.Members())
+	}
+}
+
+func TestPersistentStore_SortedSetRecovery(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := NewPersistentStore(cfg)
+	require.NoError(t, err)
+	_, _, err = ps.ZAdd("board", []ZMember{{"a", 1}, {"b", 2}})
+	require.NoError(t, err)
+	_, _, err = ps.ZAdd("board", []ZMember{{"a", 3}, {"c", 2.5}})
+	require.NoError(t, err)
+	_, _, err = ps.ZRem("board", "b")
+	require.NoError(t, err)
+	_, _, err = ps.ZAdd("gone", []ZMember{{"a", 1}})
+	require.NoError(t, err)
+	_, _, err = ps.ZRem("gone", "a")
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+
+	ps, err = NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+	entry, err := ps.GetSortedSet("board")
+	require.NoError(t, err)
+	assert.Equal(t, []ZMember{{"c", 2.5}, {"a", 3}}, entry.ZSet.Members())
+	assert.Equal(t, uint64(3), entry.Version)
+	assert.Equal(t, uint32(18), entry.SizeBytes)
+	assert.False(t, ps.Exists("gone"))
+}
+
+func TestSnapshot_SortedSet(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cfg := config.DefaultConfig()
+	cfg.DataDir = tempDir
+	cfg.SnapshotCompatFormat = 4
+
+	manager, err := NewSnapshotManager(cfg)
+	require.NoError(t, err)
+
+	store := New(cfg)
+	store.Set("s", []byte("v"), SetOptions{})
+	_, _, err = store.SAdd("tags", "x")
+	require.NoError(t, err)
+	_, _, err = store.ZAdd("board", []ZMember{{"x", 2}, {"y", -1.25}})
+	require.NoError(t, err)
+	require.NoError(t, manager.CreateSnapshot(store, "wal-00000001.oswal"))
+
+	newStore := New(cfg)
+	_, err = manager.LoadSnapshot(newStore)
+	require.NoError(t, err)
+	entry, err := newStore.GetSortedSet("board")
+	require.NoError(t, err)
+	assert.Equal(t, []ZMember{{"y", -1.25}, {"x", 2}}, entry.ZSet.Members())
+	assert.Equal(t, uint32(18), entry.SizeBytes)
+
+	// Format 4 can't hold sorted sets, so its copy leaves them out
+	var keys []string
+	info, err := VerifySnapshot(filepath.Join(tempDir, "format-4", "snap-00000001.osnap"), func(key string, entry *Entry) {
+		keys = append(keys, key)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(snapVersionV4), info.Version)
+	assert.ElementsMatch(t, []string{"s", "tags"}, keys)
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BgSave starts a snapshot on the server without waiting for it to finish
+func (c *Client) BgSave() (*Response, error) {
+	if err := c.sendCommand("BGSAVE"); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// ReadOnly makes the server refuse writes with ERR READONLY, or accept
+// them again
+func (c *Client) ReadOnly(on bool) (*Response, error) {
+	mode := "OFF"
+	if on {
+		mode = "ON"
+	}
+	if err := c.sendCommand("READONLY", mode); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// ConfigGet returns the server settings whose names match a glob pattern
+func (c *Client) ConfigGet(pattern string) (map[string]string, error) {
+	if err := c.sendCommand("CONFIG", "GET", pattern); err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]string)
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "END" {
+			return settings, nil
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, fmt.Errorf("%s", strings.TrimPrefix(line, "ERR "))
+		}
+		name, value, _ := strings.Cut(line, "=")
+		settings[name] = value
+	}
+}
+
+// ConfigSet changes one of the settings the server allows to change at
+// runtime
+func (c *Client) ConfigSet(name, value string) (*Response, error) {
+	if err := c.sendCommand("CONFIG", "SET", name, value); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// ClientKill closes the connection with the id shown by ClientList
+func (c *Client) ClientKill(id uint64) (*Response, error) {
+	if err := c.sendCommand("CLIENT", "KILL", strconv.FormatUint(id, 10)); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
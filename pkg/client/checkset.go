@@ -0,0 +1,57 @@
+package client
+
+import (
+	"strconv"
+	"strings"
+)
+
+// VersionCheck is a condition of CheckSet: Key must be at Version, or not
+// exist if Version is 0
+type VersionCheck struct {
+	Key     string
+	Version uint64
+}
+
+// KeyValue is a write applied by CheckSet
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// CheckSet applies every write if every check holds, atomically
+// (CHECKSET). On success Response.Versions holds the new version of each
+// write; if a check fails, the response is an ERR VER naming its key and
+// nothing is written.
+func (c *Client) CheckSet(checks []VersionCheck, writes []KeyValue) (*Response, error) {
+	args := []string{"CHECKSET", strconv.Itoa(len(checks))}
+	for _, check := range checks {
+		args = append(args, check.Key, strconv.FormatUint(check.Version, 10))
+	}
+	var payload []byte
+	for _, w := range writes {
+		args = append(args, w.Key, strconv.Itoa(len(w.Value)))
+		payload = append(payload, w.Value...)
+	}
+
+	if err := c.sendCommandWithPayload(args, payload); err != nil {
+		return nil, err
+	}
+
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.parseResponse(line)
+	c.endCommand(err)
+	if err != nil || !resp.Success {
+		return resp, err
+	}
+	for _, field := range strings.Fields(line)[1:] {
+		version, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		resp.Versions = append(resp.Versions, version)
+	}
+	return resp, nil
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultChunkSize is the frame size SetReader uses for chunked uploads
+const DefaultChunkSize = 64 * 1024
+
+// GetReader retrieves a value as a stream. When the connection has enabled
+// chunking (Hello("CHUNKSIZE", n)) and the value is larger than the chunk
+// size, frames are read from the connection as the returned reader is
+// consumed. The reader must be drained before issuing another command; see
+// GetStream for a variant that can be closed early.
+func (c *Client) GetReader(key string) (io.Reader, *Response, error) {
+	stream, resp, err := c.GetStream(key)
+	if err != nil || stream == nil {
+		return nil, resp, err
+	}
+	return stream, resp, nil
+}
+
+// SetReader stores size bytes read from r, uploading them in CHUNK frames
+// so the value never has to be held in memory on the client. If r fails
+// mid-upload the connection is closed, since the server is still waiting
+// for the remaining frames.
+func (c *Client) SetReader(key string, r io.Reader, size int64, options ...string) (*Response, error) {
+	args := []string{"SET", key, strconv.FormatInt(size, 10), "CHUNKED"}
+	args = append(args, options...)
+
+	c.startCommand(args[0])
+	if err := c.prepare(); err != nil {
+		c.endCommand(err)
+		return nil, err
+	}
+	c.logCommand(args, nil)
+	if _, err := c.writer.WriteString(c.commandLine(args)); err != nil {
+		c.endCommand(err)
+		return nil, err
+	}
+
+	buf := make([]byte, DefaultChunkSize)
+	for offset := int64(0); offset < size; {
+		n := int64(len(buf))
+		if size-offset < n {
+			n = size - offset
+		}
+
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			c.conn.Close()
+			c.endCommand(err)
+			return nil, err
+		}
+
+		if _, err := fmt.Fprintf(c.writer, "CHUNK %d %d\r\n", offset, n); err != nil {
+			c.endCommand(err)
+			return nil, err
+		}
+		if _, err := c.writer.Write(buf[:n]); err != nil {
+			c.endCommand(err)
+			return nil, err
+		}
+		if _, err := c.writer.WriteString("\r\n"); err != nil {
+			c.endCommand(err)
+			return nil, err
+		}
+		if err := c.writer.Flush(); err != nil {
+			c.endCommand(err)
+			return nil, err
+		}
+
+		offset += n
+	}
+
+	if err := c.writer.Flush(); err != nil {
+		c.endCommand(err)
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// chunkReader reads the CHUNK frames of a chunked VALUE response
+type chunkReader struct {
+	c       *Client
+	total   int
+	read    int
+	pending int // bytes left in the current frame
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if cr.pending == 0 {
+		if cr.read >= cr.total {
+			return 0, io.EOF
+		}
+		n, err := cr.c.readChunkHeader(cr.read)
+		if err != nil {
+			return 0, err
+		}
+		cr.pending = n
+	}
+
+	if len(p) > cr.pending {
+		p = p[:cr.pending]
+	}
+	n, err := cr.c.reader.Read(p)
+	cr.pending -= n
+	cr.read += n
+	if err != nil {
+		return n, err
+	}
+
+	// Consume the frame's trailing \r\n
+	if cr.pending == 0 {
+		if _, err := cr.c.reader.Discard(2); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readChunkHeader reads a "CHUNK <offset> <len>" line and returns the length
+func (c *Client) readChunkHeader(expectedOffset int) (int, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return 0, err
+	}
+
+	parts := strings.Fields(line)
+	if len(parts) != 3 || parts[0] != "CHUNK" {
+		return 0, fmt.Errorf("invalid CHUNK frame")
+	}
+
+	offset, err := strconv.Atoi(parts[1])
+	if err != nil || offset != expectedOffset {
+		return 0, fmt.Errorf("unexpected CHUNK offset")
+	}
+	n, err := strconv.Atoi(parts[2])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid CHUNK length")
+	}
+	return n, nil
+}
+
+// readChunkedValue reads all CHUNK frames of a total-byte value
+func (c *Client) readChunkedValue(total int) ([]byte, error) {
+	value := make([]byte, total)
+	if _, err := io.ReadFull(&chunkReader{c: c, total: total}, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -2,12 +2,18 @@ package client
 
 import (
 	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/bharatmehan/osprey/pkg/command"
 )
 
 // Client represents an Osprey client
@@ -15,6 +21,20 @@ type Client struct {
 	conn   net.Conn
 	reader *bufio.Reader
 	writer *bufio.Writer
+
+	opts   options
+	addrs  []string
+	active int            // index of the connected address
+	broken error          // why the connection failed; redial before the next command
+	health *healthChecker // nil without WithHealthCheck
+
+	compression string // negotiated for the active connection; "" when off
+	tracing     bool   // the active connection accepts trace IDs
+	traceID     string // attached to commands while tracing, see SetTraceID
+
+	command      string    // the command running, for WithHooks; "" between commands
+	commandStart time.Time // when it was sent
+	debugCommand string    // the last command sent, for WithDebug
 }
 
 // Response represents a server response
@@ -25,26 +45,82 @@ type Response struct {
 	ExpiryMs int64
 	TTL      int64
 	Integer  int64
+	Float    float64
 	Error    string
 	Success  bool
+
+	// Set by GetEarly when the server suggests refreshing the value
+	// before it expires
+	SoftExpired bool
+
+	// Set by CheckSet: the new version of each write, in order
+	Versions []uint64
+
+	// Soft limits a write crossed, such as "value_size" or "memory", on
+	// connections that enabled them with Hello("WARNINGS", "ON")
+	Warnings []string
+
+	// Metadata returned by GETMETA
+	Size      int
+	CreatedMs int64
+	UpdatedMs int64
+	ValueType string
 }
 
-// New creates a new client connection
-func New(address string) (*Client, error) {
-	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
-	if err != nil {
+// RetryAfter returns the backoff suggested by an ERR BUSY response, or zero
+func (r *Response) RetryAfter() time.Duration {
+	if !strings.HasPrefix(r.Error, "BUSY ") {
+		return 0
+	}
+	for _, field := range strings.Fields(r.Error)[1:] {
+		if v, ok := strings.CutPrefix(field, "retry_after_ms="); ok {
+			ms, err := strconv.Atoi(v)
+			if err == nil {
+				return time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return 0
+}
+
+// New creates a new client connection. address may be a comma-separated
+// list, in which case the first address that answers becomes active and
+// the others are standbys the client fails over to, in order, when the
+// active connection breaks. A command that fails with a connection error
+// returns it; the failover happens before the next command.
+func New(address string, opts ...Option) (*Client, error) {
+	c := &Client{
+		opts:  options{dialTimeout: 5 * time.Second, name: defaultName()},
+		addrs: splitAddresses(address),
+	}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+	if len(c.addrs) == 0 {
+		return nil, fmt.Errorf("no address given")
+	}
+
+	if err := c.dial(0); err != nil {
 		return nil, err
 	}
 
-	return &Client{
-		conn:   conn,
-		reader: bufio.NewReader(conn),
-		writer: bufio.NewWriter(conn),
-	}, nil
+	if c.opts.healthInterval > 0 {
+		c.health = newHealthChecker(c.addrs, c.opts.healthInterval, c.opts.dialTimeout, c.opts.onTopology)
+	}
+	return c, nil
+}
+
+// Address returns the address of the active connection
+func (c *Client) Address() string {
+	return c.addrs[c.active]
 }
 
 // Close closes the client connection
 func (c *Client) Close() error {
+	if c.health != nil {
+		c.health.close()
+	}
+	c.endCommand(net.ErrClosed)
 	return c.conn.Close()
 }
 
@@ -66,6 +142,162 @@ func (c *Client) Ping() error {
 	return nil
 }
 
+// Hello sends connection options as name/value pairs, e.g. Hello("PRIORITY", "low")
+func (c *Client) Hello(options ...string) (*Response, error) {
+	args := append([]string{"HELLO"}, options...)
+	if err := c.sendCommand(args...); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// Do sends a command that has no dedicated method, such as one added by a
+// server extension, and reads its single response. Built-in commands are
+// checked against the command table first, so a malformed one fails
+// without a round trip.
+func (c *Client) Do(args ...string) (*Response, error) {
+	if err := command.Check(args); err != nil {
+		return nil, err
+	}
+	if spec, ok := command.Lookup(args[0]); ok && spec.Has(command.Payload) {
+		return nil, fmt.Errorf("%s takes a payload and can't be sent with Do", spec.Name)
+	}
+	if err := c.sendCommand(args...); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// DoLines sends a command that has no dedicated method and returns its
+// reply unparsed, for tools that print replies they don't understand. A
+// VALUE line is followed by the value; replies other than the single-line
+// kinds, such as KEY or CLIENT listings, are read through their END.
+func (c *Client) DoLines(args ...string) ([]string, error) {
+	if err := command.Check(args); err != nil {
+		return nil, err
+	}
+	if spec, ok := command.Lookup(args[0]); ok && spec.Has(command.Payload) {
+		return nil, fmt.Errorf("%s takes a payload and can't be sent with DoLines", spec.Name)
+	}
+	if err := c.sendCommand(args...); err != nil {
+		return nil, err
+	}
+
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{line}
+	first, _, _ := strings.Cut(line, " ")
+	switch first {
+	case "OK", "PONG", "NOT_FOUND", "ERR", "DELETED", "EXISTS", "META", "DIGEST":
+		c.endCommand(nil)
+		return lines, nil
+	case "VALUE":
+		resp, err := c.parseResponse(line)
+		c.endCommand(err)
+		if err != nil {
+			return nil, err
+		}
+		return append(lines, string(resp.Value)), nil
+	}
+	if _, err := strconv.ParseInt(first, 10, 64); err == nil {
+		c.endCommand(nil)
+		return lines, nil
+	}
+
+	for line != "END" {
+		if line, err = c.readLine(); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// Auth authenticates the connection for admin commands
+func (c *Client) Auth(password string) (*Response, error) {
+	if err := c.sendCommand("AUTH", password); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// AuthHMAC authenticates the connection without sending the password: it
+// asks for an AUTHCHALLENGE nonce and answers with its HMAC-SHA256 keyed
+// with the password. An empty user authenticates with admin_password.
+func (c *Client) AuthHMAC(user, password string) (*Response, error) {
+	if err := c.sendCommand("AUTHCHALLENGE"); err != nil {
+		return nil, err
+	}
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	c.endCommand(nil)
+	challenge, ok := strings.CutPrefix(line, "CHALLENGE ")
+	if !ok {
+		return c.parseResponse(line)
+	}
+
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write([]byte(challenge))
+	args := []string{"AUTHHMAC", hex.EncodeToString(mac.Sum(nil))}
+	if user != "" {
+		args = []string{"AUTHHMAC", user, args[1]}
+	}
+	if err := c.sendCommand(args...); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// AuthUser authenticates the connection as a configured user, scoping its
+// keys to the user's namespace
+func (c *Client) AuthUser(user, password string) (*Response, error) {
+	if err := c.sendCommand("AUTH", user, password); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// Shutdown asks the server to shut down gracefully. Pass "SAVE" to force a
+// final snapshot or "NOSAVE" to skip it.
+func (c *Client) Shutdown(mode ...string) (*Response, error) {
+	args := append([]string{"SHUTDOWN"}, mode...)
+	if err := c.sendCommand(args...); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// WALRotate asks the server to fsync its WAL and start a new file. The old
+// file's name is returned in Value; it and every earlier WAL file are
+// complete and can be copied.
+func (c *Client) WALRotate() (*Response, error) {
+	if err := c.sendCommand("WALROTATE"); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// WALSync asks the server to fsync its WAL. The current file's name is
+// returned in Value.
+func (c *Client) WALSync() (*Response, error) {
+	if err := c.sendCommand("WALSYNC"); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
 // Get retrieves a value by key
 func (c *Client) Get(key string) (*Response, error) {
 	if err := c.sendCommand("GET", key); err != nil {
@@ -75,11 +307,91 @@ func (c *Client) Get(key string) (*Response, error) {
 	return c.readResponse()
 }
 
+// GetMeta retrieves version, TTL, size, timestamps and type of a key
+// without transferring its value
+func (c *Client) GetMeta(key string) (*Response, error) {
+	if err := c.sendCommand("GETMETA", key); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
 // Set stores a key-value pair
 func (c *Client) Set(key string, value []byte, options ...string) (*Response, error) {
 	args := []string{"SET", key, strconv.Itoa(len(value))}
 	args = append(args, options...)
 
+	if compressed, ok := c.compressValue(value); ok {
+		args[2] = strconv.Itoa(len(compressed))
+		args = append(args, strings.ToUpper(c.compression), strconv.Itoa(len(value)))
+		value = compressed
+	}
+
+	if err := c.sendCommandWithPayload(args, value); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// Append appends value to a key's value, creating the key if it is
+// missing. The response carries the new version, and the new length in
+// Integer.
+func (c *Client) Append(key string, value []byte) (*Response, error) {
+	args := []string{"APPEND", key, strconv.Itoa(len(value))}
+
+	if compressed, ok := c.compressValue(value); ok {
+		args[2] = strconv.Itoa(len(compressed))
+		args = append(args, strings.ToUpper(c.compression), strconv.Itoa(len(value)))
+		value = compressed
+	}
+
+	if err := c.sendCommandWithPayload(args, value); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// CompareAndSwap replaces a key's value with value if it currently equals
+// expected (CAS), keeping its TTL. The response carries the new version;
+// a differing value is an ERR MISMATCH and a missing key an ERR NEXISTS.
+func (c *Client) CompareAndSwap(key string, expected, value []byte) (*Response, error) {
+	args := []string{"CAS", key, strconv.Itoa(len(expected)), strconv.Itoa(len(value))}
+	payload := append(append(make([]byte, 0, len(expected)+len(value)), expected...), value...)
+
+	if err := c.sendCommandWithPayload(args, payload); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// GetRange retrieves the bytes of a key's value from start to end,
+// inclusive. Negative offsets count back from the end, so 0, -1 is the
+// whole value.
+func (c *Client) GetRange(key string, start, end int64) (*Response, error) {
+	args := []string{"GETRANGE", key, strconv.FormatInt(start, 10), strconv.FormatInt(end, 10)}
+	if err := c.sendCommand(args...); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// SetRange overwrites a key's value with value from offset, padding a
+// shorter value with zero bytes and creating the key if it is missing. The
+// response carries the new version, and the new length in Integer.
+func (c *Client) SetRange(key string, offset int64, value []byte) (*Response, error) {
+	args := []string{"SETRANGE", key, strconv.FormatInt(offset, 10), strconv.Itoa(len(value))}
+
+	if compressed, ok := c.compressValue(value); ok {
+		args[3] = strconv.Itoa(len(compressed))
+		args = append(args, strings.ToUpper(c.compression), strconv.Itoa(len(value)))
+		value = compressed
+	}
+
 	if err := c.sendCommandWithPayload(args, value); err != nil {
 		return nil, err
 	}
@@ -96,6 +408,74 @@ func (c *Client) Del(key string) (*Response, error) {
 	return c.readResponse()
 }
 
+// MExists reports for each of keys whether it exists, in one round trip
+func (c *Client) MExists(keys ...string) ([]bool, error) {
+	if err := c.sendCommand(append([]string{"MEXISTS"}, keys...)...); err != nil {
+		return nil, err
+	}
+
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	c.endCommand(nil)
+	flags, ok := strings.CutPrefix(line, "EXISTS ")
+	if !ok {
+		resp, err := c.parseResponse(line)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	if len(flags) != len(keys) {
+		return nil, fmt.Errorf("invalid MEXISTS response: %s", line)
+	}
+
+	exists := make([]bool, len(keys))
+	for i := range flags {
+		exists[i] = flags[i] == '1'
+	}
+	return exists, nil
+}
+
+// MDel deletes keys in one round trip and returns how many of them existed
+func (c *Client) MDel(keys ...string) (int64, error) {
+	if err := c.sendCommand(append([]string{"MDEL"}, keys...)...); err != nil {
+		return 0, err
+	}
+
+	resp, err := c.readResponse()
+	if err != nil {
+		return 0, err
+	}
+	if resp.Error != "" {
+		return 0, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Integer, nil
+}
+
+// GetDel retrieves a key's value and deletes the key in one step. Of
+// several clients calling it for the same key, only one gets the value.
+func (c *Client) GetDel(key string) (*Response, error) {
+	if err := c.sendCommand("GETDEL", key); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// GetEx retrieves a value and updates its TTL in one step. Pass "EX",
+// "<ms>" to set a new TTL or "PERSIST" to remove it; with no options it
+// behaves like Get.
+func (c *Client) GetEx(key string, options ...string) (*Response, error) {
+	args := append([]string{"GETEX", key}, options...)
+	if err := c.sendCommand(args...); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
 // Exists checks if a key exists
 func (c *Client) Exists(key string) (*Response, error) {
 	if err := c.sendCommand("EXISTS", key); err != nil {
@@ -151,6 +531,16 @@ func (c *Client) Decr(key string, delta ...int64) (*Response, error) {
 	return c.readResponse()
 }
 
+// IncrByFloat adds delta to a floating-point value. The new value is in
+// Float.
+func (c *Client) IncrByFloat(key string, delta float64) (*Response, error) {
+	if err := c.sendCommand("INCRBYFLOAT", key, strconv.FormatFloat(delta, 'f', -1, 64)); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
 // MGet gets multiple keys
 func (c *Client) MGet(keys ...string) ([]*Response, error) {
 	args := append([]string{"MGET"}, keys...)
@@ -163,14 +553,59 @@ func (c *Client) MGet(keys ...string) ([]*Response, error) {
 	for range keys {
 		resp, err := c.readMGetResponse()
 		if err != nil {
+			c.endCommand(err)
 			return nil, err
 		}
 		responses = append(responses, resp)
 	}
+	c.endCommand(nil)
 
 	return responses, nil
 }
 
+// MSetEntry is one key written by MSet. ExpiryMs sets a TTL, and NX
+// skips the key if it exists.
+type MSetEntry struct {
+	Key      string
+	Value    []byte
+	ExpiryMs int64
+	NX       bool
+}
+
+// MSet writes every entry, or none if any is refused. Response.Version
+// holds the number of keys written, which leaves out NX keys that exist.
+func (c *Client) MSet(entries ...MSetEntry) (*Response, error) {
+	args := []string{"MSET"}
+	var payload []byte
+	for _, e := range entries {
+		args = append(args, e.Key, strconv.Itoa(len(e.Value)))
+		if e.ExpiryMs > 0 {
+			args = append(args, "EX", strconv.FormatInt(e.ExpiryMs, 10))
+		}
+		if e.NX {
+			args = append(args, "NX")
+		}
+		payload = append(payload, e.Value...)
+	}
+
+	if err := c.sendCommandWithPayload(args, payload); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// SwapPrefix atomically renames keys starting with a to start with b and
+// keys starting with b to start with a. Response.Version holds the number
+// of keys moved.
+func (c *Client) SwapPrefix(a, b string) (*Response, error) {
+	if err := c.sendCommand("SWAPPREFIX", a, b); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
 // Stats gets server statistics
 func (c *Client) Stats() (map[string]string, error) {
 	if err := c.sendCommand("STATS"); err != nil {
@@ -180,17 +615,17 @@ func (c *Client) Stats() (map[string]string, error) {
 	stats := make(map[string]string)
 
 	for {
-		line, err := c.reader.ReadString('\n')
+		line, err := c.readLine()
 		if err != nil {
 			return nil, err
 		}
 
-		line = strings.TrimSuffix(line, "\n")
-		line = strings.TrimSuffix(line, "\r")
-
 		if line == "END" {
 			break
 		}
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, fmt.Errorf("%s", strings.TrimPrefix(line, "ERR "))
+		}
 
 		parts := strings.SplitN(line, "=", 2)
 		if len(parts) == 2 {
@@ -201,47 +636,346 @@ func (c *Client) Stats() (map[string]string, error) {
 	return stats, nil
 }
 
+// StatsReset zeroes the server's STATS counters (admin). The reply's
+// Integer is the new stats_epoch.
+func (c *Client) StatsReset() (*Response, error) {
+	if err := c.sendCommand("STATS", "RESET"); err != nil {
+		return nil, err
+	}
+	return c.readResponse()
+}
+
+// InfoSection is one section of INFO or STATS output: the fields of one
+// area of the server, sorted by name
+type InfoSection struct {
+	Name   string
+	Fields []InfoField
+}
+
+// InfoField is one name=value line of INFO or STATS output. Help is the
+// field's description, only set by StatsSections in verbose mode.
+type InfoField struct {
+	Name  string
+	Value string
+	Help  string
+}
+
+// Info returns the server's metrics grouped into sections, or only the
+// given section if it isn't empty
+func (c *Client) Info(section string) ([]InfoSection, error) {
+	args := []string{"INFO"}
+	if section != "" {
+		args = append(args, section)
+	}
+	if err := c.sendCommand(args...); err != nil {
+		return nil, err
+	}
+	return c.readSections()
+}
+
+// StatsSections returns the server's statistics grouped into sections, in
+// the server's order. With verbose, each field carries its description.
+func (c *Client) StatsSections(verbose bool) ([]InfoSection, error) {
+	args := []string{"STATS"}
+	if verbose {
+		args = append(args, "VERBOSE")
+	}
+	if err := c.sendCommand(args...); err != nil {
+		return nil, err
+	}
+	return c.readSections()
+}
+
+// readSections reads a sectioned STATS or INFO reply
+func (c *Client) readSections() ([]InfoSection, error) {
+	var sections []InfoSection
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if line == "END" {
+			break
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, fmt.Errorf("%s", strings.TrimPrefix(line, "ERR "))
+		}
+
+		if name, ok := strings.CutPrefix(line, "# "); ok {
+			sections = append(sections, InfoSection{Name: name})
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if ok && len(sections) > 0 {
+			value, help, _ := strings.Cut(value, " # ")
+			last := &sections[len(sections)-1]
+			last.Fields = append(last.Fields, InfoField{name, value, help})
+		}
+	}
+
+	return sections, nil
+}
+
+// Keys lists the keys matching a glob pattern, sorted, and reports
+// whether the server's keys_max cut the list short
+func (c *Client) Keys(pattern string) ([]string, bool, error) {
+	if err := c.sendCommand("KEYS", pattern); err != nil {
+		return nil, false, err
+	}
+
+	var keys []string
+	truncated := false
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch {
+		case line == "END":
+			return keys, truncated, nil
+		case line == "TRUNCATED":
+			truncated = true
+		case strings.HasPrefix(line, "KEY "):
+			keys = append(keys, line[len("KEY "):])
+		case strings.HasPrefix(line, "ERR "):
+			return nil, false, fmt.Errorf("%s", line[len("ERR "):])
+		default:
+			return nil, false, fmt.Errorf("invalid KEYS response: %s", line)
+		}
+	}
+}
+
+// DBSize returns the number of keys that have not expired
+func (c *Client) DBSize() (int64, error) {
+	if err := c.sendCommand("DBSIZE"); err != nil {
+		return 0, err
+	}
+
+	resp, err := c.readResponse()
+	if err != nil {
+		return 0, err
+	}
+	if resp.Error != "" {
+		return 0, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Integer, nil
+}
+
+// Touch records an access to keys without reading them, giving keys under
+// a sliding TTL rule their TTL again, and returns how many of them exist
+func (c *Client) Touch(keys ...string) (int64, error) {
+	if err := c.sendCommand(append([]string{"TOUCH"}, keys...)...); err != nil {
+		return 0, err
+	}
+
+	resp, err := c.readResponse()
+	if err != nil {
+		return 0, err
+	}
+	if resp.Error != "" {
+		return 0, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Integer, nil
+}
+
+// RandomKey returns a live key picked uniformly at random, and false if
+// the store holds none
+func (c *Client) RandomKey() (string, bool, error) {
+	if err := c.sendCommand("RANDOMKEY"); err != nil {
+		return "", false, err
+	}
+
+	key, found := "", false
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return "", false, err
+		}
+
+		switch {
+		case line == "END":
+			return key, found, nil
+		case strings.HasPrefix(line, "KEY "):
+			key, found = line[len("KEY "):], true
+		case strings.HasPrefix(line, "ERR "):
+			return "", false, fmt.Errorf("%s", line[len("ERR "):])
+		default:
+			return "", false, fmt.Errorf("invalid RANDOMKEY response: %s", line)
+		}
+	}
+}
+
+// Scan lists up to count keys matching a glob pattern, in order, starting
+// after cursor. Pass "0" to start; the returned cursor is "0" once every
+// key has been listed.
+func (c *Client) Scan(cursor, pattern string, count int) ([]string, string, error) {
+	if err := c.sendCommand("SCAN", cursor, "MATCH", pattern, "COUNT", strconv.Itoa(count)); err != nil {
+		return nil, "", err
+	}
+
+	var keys []string
+	next := ""
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, "", err
+		}
+
+		switch {
+		case line == "END":
+			if next == "" {
+				return nil, "", fmt.Errorf("SCAN response missing cursor")
+			}
+			return keys, next, nil
+		case strings.HasPrefix(line, "CURSOR "):
+			next = line[len("CURSOR "):]
+		case strings.HasPrefix(line, "KEY "):
+			keys = append(keys, line[len("KEY "):])
+		case strings.HasPrefix(line, "ERR "):
+			return nil, "", fmt.Errorf("%s", line[len("ERR "):])
+		default:
+			return nil, "", fmt.Errorf("invalid SCAN response: %s", line)
+		}
+	}
+}
+
+// TemperatureBucket is one class of keys reported by KEYTEMP
+type TemperatureBucket struct {
+	Name    string
+	Keys    int
+	Hits    uint64
+	Samples []string
+}
+
+// KeyTemp reports key counts and sample keys bucketed by access recency
+func (c *Client) KeyTemp(samples int) ([]TemperatureBucket, error) {
+	if err := c.sendCommand("KEYTEMP", strconv.Itoa(samples)); err != nil {
+		return nil, err
+	}
+
+	var buckets []TemperatureBucket
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if line == "END" {
+			break
+		}
+
+		parts := strings.Fields(line)
+		if len(parts) > 0 && parts[0] == "ERR" {
+			return nil, fmt.Errorf("%s", strings.Join(parts[1:], " "))
+		}
+		if len(parts) < 4 || parts[0] != "BUCKET" {
+			return nil, fmt.Errorf("invalid KEYTEMP response: %s", line)
+		}
+
+		keys, _ := strconv.Atoi(parts[2])
+		hits, _ := strconv.ParseUint(parts[3], 10, 64)
+		buckets = append(buckets, TemperatureBucket{
+			Name:    parts[1],
+			Keys:    keys,
+			Hits:    hits,
+			Samples: parts[4:],
+		})
+	}
+
+	return buckets, nil
+}
+
 // sendCommand sends a command without payload
 func (c *Client) sendCommand(args ...string) error {
-	command := strings.Join(args, " ") + "\r\n"
-	_, err := c.writer.WriteString(command)
-	if err != nil {
+	c.startCommand(args[0])
+	if err := c.prepare(); err != nil {
+		c.endCommand(err)
 		return err
 	}
-	return c.writer.Flush()
+
+	c.logCommand(args, nil)
+	_, err := c.writer.WriteString(c.commandLine(args))
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	if err != nil {
+		c.markBroken(err)
+		c.endCommand(err)
+	}
+	return err
 }
 
 // sendCommandWithPayload sends a command with binary payload
 func (c *Client) sendCommandWithPayload(args []string, payload []byte) error {
-	command := strings.Join(args, " ") + "\r\n"
-	_, err := c.writer.WriteString(command)
-	if err != nil {
+	c.startCommand(args[0])
+	if err := c.prepare(); err != nil {
+		c.endCommand(err)
 		return err
 	}
 
-	_, err = c.writer.Write(payload)
+	if payload == nil {
+		payload = []byte{}
+	}
+	c.logCommand(args, payload)
+	_, err := c.writer.WriteString(c.commandLine(args))
+	if err == nil {
+		_, err = c.writer.Write(payload)
+	}
+	if err == nil {
+		_, err = c.writer.WriteString("\r\n")
+	}
+	if err == nil {
+		err = c.writer.Flush()
+	}
 	if err != nil {
-		return err
+		c.markBroken(err)
+		c.endCommand(err)
 	}
+	return err
+}
 
-	_, err = c.writer.WriteString("\r\n")
+// readResponse reads and parses a server response, the whole reply to a
+// command
+func (c *Client) readResponse() (*Response, error) {
+	line, err := c.readLine()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return c.writer.Flush()
+	resp, err := c.parseResponse(line)
+	c.endCommand(err)
+	return resp, err
 }
 
-// readResponse reads and parses a server response
-func (c *Client) readResponse() (*Response, error) {
+// readLine reads one response line without its trailing \r\n. An END or
+// ERR line, or a failed read, ends the reply to the running command.
+func (c *Client) readLine() (string, error) {
 	line, err := c.reader.ReadString('\n')
 	if err != nil {
-		return nil, err
+		c.markBroken(err)
+		c.endCommand(err)
+		return "", err
 	}
 
 	line = strings.TrimSuffix(line, "\n")
 	line = strings.TrimSuffix(line, "\r")
+	c.logReply(line)
+	if line == "END" {
+		c.endCommand(nil)
+	} else if msg, ok := strings.CutPrefix(line, "ERR "); ok {
+		c.endCommand(errors.New(msg))
+	}
+	return line, nil
+}
 
+// parseResponse parses a response line, reading any payload that follows it
+func (c *Client) parseResponse(line string) (*Response, error) {
 	parts := strings.Fields(line)
 	if len(parts) == 0 {
 		return nil, fmt.Errorf("empty response")
@@ -252,9 +986,17 @@ func (c *Client) readResponse() (*Response, error) {
 	switch parts[0] {
 	case "OK":
 		resp.Success = true
+		if warn, ok := strings.CutPrefix(parts[len(parts)-1], "WARN="); ok {
+			resp.Warnings = strings.Split(warn, ",")
+			parts = parts[:len(parts)-1]
+		}
 		if len(parts) > 1 {
 			resp.Version, _ = strconv.ParseUint(parts[1], 10, 64)
 		}
+		if len(parts) > 2 {
+			// APPEND: the new length
+			resp.Integer, _ = strconv.ParseInt(parts[2], 10, 64)
+		}
 
 	case "PONG":
 		resp.Success = true
@@ -275,19 +1017,63 @@ func (c *Client) readResponse() (*Response, error) {
 		resp.Version, _ = strconv.ParseUint(parts[2], 10, 64)
 		resp.ExpiryMs, _ = strconv.ParseInt(parts[3], 10, 64)
 
+		if parts[len(parts)-1] == "SOFTEXP" {
+			resp.SoftExpired = true
+			parts = parts[:len(parts)-1]
+		}
+
+		// Large values may arrive as CHUNK frames
+		if len(parts) > 4 && parts[4] == "CHUNKED" {
+			value, err := c.readChunkedValue(length)
+			if err != nil {
+				return nil, err
+			}
+			c.logPayload(value)
+			resp.Value = value
+			resp.Success = true
+			break
+		}
+
 		// Read the value
 		value := make([]byte, length)
 		_, err = io.ReadFull(c.reader, value)
 		if err != nil {
 			return nil, err
 		}
+		c.logPayload(value)
 
 		// Read trailing \r\n
 		c.reader.ReadString('\n')
 
+		// Compressed values carry "<ALGO> <raw length>"
+		if len(parts) > 5 {
+			rawLen, err := strconv.Atoi(parts[5])
+			if err != nil {
+				return nil, fmt.Errorf("invalid raw length in VALUE response")
+			}
+			if value, err = decompressValue(parts[4], value, rawLen); err != nil {
+				return nil, err
+			}
+		}
+
 		resp.Value = value
 		resp.Success = true
 
+	case "META":
+		// META <size> <version> <expiry_ms> <ttl_ms> <created_ms> <updated_ms> <type>
+		if len(parts) < 8 {
+			return nil, fmt.Errorf("invalid META response")
+		}
+
+		resp.Size, _ = strconv.Atoi(parts[1])
+		resp.Version, _ = strconv.ParseUint(parts[2], 10, 64)
+		resp.ExpiryMs, _ = strconv.ParseInt(parts[3], 10, 64)
+		resp.TTL, _ = strconv.ParseInt(parts[4], 10, 64)
+		resp.CreatedMs, _ = strconv.ParseInt(parts[5], 10, 64)
+		resp.UpdatedMs, _ = strconv.ParseInt(parts[6], 10, 64)
+		resp.ValueType = parts[7]
+		resp.Success = true
+
 	case "DELETED":
 		if len(parts) > 1 {
 			deleted, _ := strconv.Atoi(parts[1])
@@ -300,6 +1086,13 @@ func (c *Client) readResponse() (*Response, error) {
 			resp.Success = exists == 1
 		}
 
+	case "FLOAT":
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid FLOAT response")
+		}
+		resp.Float, _ = strconv.ParseFloat(parts[1], 64)
+		resp.Success = true
+
 	case "ERR":
 		resp.Success = false
 		if len(parts) > 1 {
@@ -330,6 +1123,7 @@ func (c *Client) readMGetResponse() (*Response, error) {
 
 	line = strings.TrimSuffix(line, "\n")
 	line = strings.TrimSuffix(line, "\r")
+	c.logReply(line)
 
 	parts := strings.Fields(line)
 	if len(parts) == 0 {
@@ -362,10 +1156,21 @@ func (c *Client) readMGetResponse() (*Response, error) {
 		if err != nil {
 			return nil, err
 		}
+		c.logPayload(value)
 
 		// Read trailing \r\n
 		c.reader.ReadString('\n')
 
+		if len(parts) > 6 {
+			rawLen, err := strconv.Atoi(parts[6])
+			if err != nil {
+				return nil, fmt.Errorf("invalid raw length in VALUE response")
+			}
+			if value, err = decompressValue(parts[5], value, rawLen); err != nil {
+				return nil, err
+			}
+		}
+
 		resp.Value = value
 		resp.Success = true
 
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// compressionDeflate is the algorithm the client offers in HELLO COMPRESS
+const compressionDeflate = "deflate"
+
+// WithCompression offers deflate compression when connecting. If the
+// server accepts, values of at least minSize bytes are compressed in both
+// directions whenever that makes them smaller. Servers that don't support
+// compression are used uncompressed.
+func WithCompression(minSize int) Option {
+	return func(o *options) {
+		o.compress = true
+		o.compressMin = minSize
+	}
+}
+
+// Compression returns the algorithm negotiated for the active connection,
+// or "" when values are sent uncompressed
+func (c *Client) Compression() string {
+	return c.compression
+}
+
+// compressValue compresses value for SET if the connection negotiated
+// compression and doing so saves space
+func (c *Client) compressValue(value []byte) ([]byte, bool) {
+	if c.compression == "" || len(value) < c.opts.compressMin {
+		return nil, false
+	}
+
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := fw.Write(value); err != nil {
+		return nil, false
+	}
+	if err := fw.Close(); err != nil {
+		return nil, false
+	}
+	if buf.Len() >= len(value) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompressValue inflates a payload marked "<ALGO> <rawLen>"
+func decompressValue(algo string, data []byte, rawLen int) ([]byte, error) {
+	if strings.ToLower(algo) != compressionDeflate {
+		return nil, fmt.Errorf("unsupported compression: %s", algo)
+	}
+
+	fr := flate.NewReader(bytes.NewReader(data))
+	defer fr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(fr, int64(rawLen)+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed value: %w", err)
+	}
+	if len(out) != rawLen {
+		return nil, fmt.Errorf("compressed value inflated to %d bytes, expected %d", len(out), rawLen)
+	}
+	return out, nil
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Logger is where WithDebug writes; *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Frame is a protocol line, and the payload sent or received with it, as
+// logged by WithDebug
+type Frame struct {
+	Command string // the command the frame belongs to, such as "SET"; "SUBSCRIBE" for all a Subscription receives
+	Sent    bool   // sent by the client rather than received
+	Line    string // without its \r\n; "" for a payload that follows a line logged before it
+	Payload []byte // nil if the frame has none
+}
+
+// DebugOptions configures WithDebug
+type DebugOptions struct {
+	// MaxPayload is how many bytes of each payload are logged; the rest
+	// are counted but not shown. 0 means 64, and a negative value logs
+	// only the size.
+	MaxPayload int
+
+	// Redact, if set, is called with each frame before it is logged and
+	// may change its Line or Payload, for instance to hide the values of
+	// some keys. AUTH passwords are hidden before it is called.
+	Redact func(f *Frame)
+}
+
+// debugLog is the state of WithDebug
+type debugLog struct {
+	logger Logger
+	DebugOptions
+}
+
+// WithDebug logs every frame the client sends and receives to logger, for
+// diagnosing protocol problems: command lines with their payloads, and
+// reply lines with the values that follow them. Payloads streamed with
+// GetStream, SetFromReader, SetReader or Sync aren't logged, only the
+// lines around them. A Subscription logs from its own goroutine, so
+// logger must be safe for concurrent use if Subscribe is called.
+func WithDebug(logger Logger, opts DebugOptions) Option {
+	if opts.MaxPayload == 0 {
+		opts.MaxPayload = 64
+	}
+	return func(o *options) { o.debug = &debugLog{logger: logger, DebugOptions: opts} }
+}
+
+// logCommand logs a command line and its payload as they are sent. A
+// command without one has a nil payload.
+func (c *Client) logCommand(args []string, payload []byte) {
+	if c.opts.debug == nil {
+		return
+	}
+	c.debugCommand = strings.ToUpper(args[0])
+
+	if c.debugCommand == "AUTH" && len(args) > 1 {
+		// The password is the last argument, after the user if there is one
+		args = append(append([]string(nil), args[:len(args)-1]...), "***")
+	}
+	line := strings.TrimSuffix(c.commandLine(args), "\r\n")
+	c.logFrame(Frame{Command: c.debugCommand, Sent: true, Line: line, Payload: payload})
+}
+
+// logReply logs a reply line as it is read
+func (c *Client) logReply(line string) {
+	if c.opts.debug != nil {
+		c.logFrame(Frame{Command: c.debugCommand, Line: line})
+	}
+}
+
+// logPayload logs a payload read after the reply line it belongs to
+func (c *Client) logPayload(payload []byte) {
+	if c.opts.debug != nil {
+		c.logFrame(Frame{Command: c.debugCommand, Payload: payload})
+	}
+}
+
+// logFrame redacts f and logs it as "<address> > <line> <payload>" when
+// sent, or with "<" when received
+func (c *Client) logFrame(f Frame) {
+	d := c.opts.debug
+	if d.Redact != nil {
+		// The payload is the caller's value, which Redact may change
+		if f.Payload != nil {
+			f.Payload = append([]byte{}, f.Payload...)
+		}
+		d.Redact(&f)
+	}
+
+	dir := "<"
+	if f.Sent {
+		dir = ">"
+	}
+	var b strings.Builder
+	b.WriteString(f.Line)
+	if f.Payload != nil {
+		if f.Line != "" {
+			b.WriteByte(' ')
+		}
+		b.WriteString(d.formatPayload(f.Payload))
+	}
+	d.logger.Printf("osprey %s %s %s", c.conn.RemoteAddr(), dir, b.String())
+}
+
+// formatPayload quotes up to MaxPayload bytes of payload, noting how many
+// more were left out
+func (d *debugLog) formatPayload(payload []byte) string {
+	shown := payload
+	if d.MaxPayload < 0 {
+		shown = nil
+	} else if len(shown) > d.MaxPayload {
+		shown = shown[:d.MaxPayload]
+	}
+	if len(shown) == len(payload) {
+		return strconv.Quote(string(payload))
+	}
+	if len(shown) == 0 {
+		return fmt.Sprintf("(%d bytes)", len(payload))
+	}
+	return fmt.Sprintf("%s... (%d bytes)", strconv.Quote(string(shown)), len(payload))
+}
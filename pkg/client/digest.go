@@ -0,0 +1,46 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Digest summarizes the keys under a prefix: a hash of every key and its
+// value, and the number of keys. Nodes holding the same keys and values
+// have equal digests.
+type Digest struct {
+	Sum  uint64
+	Keys int64
+}
+
+// Digest returns the digest of the keys under prefix, or of every key if
+// prefix is empty. DIGEST is an admin command.
+func (c *Client) Digest(prefix string) (Digest, error) {
+	args := []string{"DIGEST"}
+	if prefix != "" {
+		args = append(args, prefix)
+	}
+	if err := c.sendCommand(args...); err != nil {
+		return Digest{}, err
+	}
+
+	line, err := c.readLine()
+	if err != nil {
+		return Digest{}, err
+	}
+	c.endCommand(nil)
+	if strings.HasPrefix(line, "ERR ") {
+		return Digest{}, fmt.Errorf("%s", line[len("ERR "):])
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 3 || parts[0] != "DIGEST" {
+		return Digest{}, fmt.Errorf("invalid DIGEST response: %s", line)
+	}
+	sum, err1 := strconv.ParseUint(parts[1], 16, 64)
+	keys, err2 := strconv.ParseInt(parts[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return Digest{}, fmt.Errorf("invalid DIGEST response: %s", line)
+	}
+	return Digest{Sum: sum, Keys: keys}, nil
+}
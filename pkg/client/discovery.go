@@ -0,0 +1,290 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownAddress is returned by Discovery.Do for an address that is not
+// (or no longer) in the resolved set
+var ErrUnknownAddress = errors.New("address not in discovered set")
+
+// DiscoveryOptions configures NewDiscovery
+type DiscoveryOptions struct {
+	// Service and Proto select an SRV lookup of _service._proto.name.
+	// When Service is empty, name is resolved to A/AAAA records instead.
+	Service string
+	Proto   string // defaults to "tcp"
+
+	// Port is used with A/AAAA records; defaults to "7070"
+	Port string
+
+	// Interval between re-resolutions; defaults to 30s
+	Interval time.Duration
+
+	// Resolver defaults to net.DefaultResolver
+	Resolver *net.Resolver
+}
+
+// Discovery resolves a DNS name to a set of Osprey servers and keeps a
+// connection to each. The name is re-resolved every Interval; new
+// addresses are dialed and connections to removed addresses are closed
+// once any in-flight Do call on them returns. Resolution errors leave the
+// current set unchanged.
+type Discovery struct {
+	name   string
+	opts   DiscoveryOptions
+	lookup func(ctx context.Context) ([]string, error)
+
+	mu    sync.Mutex
+	nodes map[string]*discoveredNode
+	err   error // last resolution error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// discoveredNode is one resolved address and its connection
+type discoveredNode struct {
+	mu      sync.Mutex // serializes use of client
+	client  *Client    // nil until dialed or after a broken connection
+	removed bool
+}
+
+// NewDiscovery resolves name and connects to every address found. It fails
+// if the first resolution fails; addresses that can't be dialed yet are
+// kept and retried on use.
+func NewDiscovery(name string, opts DiscoveryOptions) (*Discovery, error) {
+	return newDiscovery(name, opts, nil)
+}
+
+// newDiscovery is NewDiscovery with a replaceable lookup, for tests
+func newDiscovery(name string, opts DiscoveryOptions, lookup func(ctx context.Context) ([]string, error)) (*Discovery, error) {
+	if opts.Proto == "" {
+		opts.Proto = "tcp"
+	}
+	if opts.Port == "" {
+		opts.Port = "7070"
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+	if opts.Resolver == nil {
+		opts.Resolver = net.DefaultResolver
+	}
+
+	d := &Discovery{
+		name:  name,
+		opts:  opts,
+		nodes: make(map[string]*discoveredNode),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	d.lookup = lookup
+	if d.lookup == nil {
+		d.lookup = d.resolve
+	}
+
+	if err := d.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+
+	go d.refreshLoop()
+	return d, nil
+}
+
+// resolve looks up the current addresses for the name
+func (d *Discovery) resolve(ctx context.Context) ([]string, error) {
+	var addrs []string
+
+	if d.opts.Service != "" {
+		_, records, err := d.opts.Resolver.LookupSRV(ctx, d.opts.Service, d.opts.Proto, d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range records {
+			host := strings.TrimSuffix(srv.Target, ".")
+			addrs = append(addrs, net.JoinHostPort(host, fmt.Sprint(srv.Port)))
+		}
+		return addrs, nil
+	}
+
+	hosts, err := d.opts.Resolver.LookupHost(ctx, d.name)
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range hosts {
+		addrs = append(addrs, net.JoinHostPort(host, d.opts.Port))
+	}
+	return addrs, nil
+}
+
+// refreshLoop re-resolves the name until Close
+func (d *Discovery) refreshLoop() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), d.opts.Interval)
+			d.Refresh(ctx)
+			cancel()
+		}
+	}
+}
+
+// Refresh re-resolves the name now, dialing new addresses and draining
+// removed ones
+func (d *Discovery) Refresh(ctx context.Context) error {
+	addrs, err := d.lookup(ctx)
+
+	d.mu.Lock()
+	d.err = err
+	if err != nil {
+		d.mu.Unlock()
+		return err
+	}
+
+	current := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		current[addr] = true
+		if _, ok := d.nodes[addr]; !ok {
+			node := &discoveredNode{}
+			d.nodes[addr] = node
+			go node.connect(addr)
+		}
+	}
+
+	var removed []*discoveredNode
+	for addr, node := range d.nodes {
+		if !current[addr] {
+			delete(d.nodes, addr)
+			removed = append(removed, node)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, node := range removed {
+		go node.drain()
+	}
+	return nil
+}
+
+// connect dials the node unless it already has a connection
+func (n *discoveredNode) connect(addr string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.removed {
+		return ErrUnknownAddress
+	}
+	if n.client != nil {
+		return nil
+	}
+
+	c, err := New(addr)
+	if err != nil {
+		return err
+	}
+	n.client = c
+	return nil
+}
+
+// drain closes the node's connection once it is not in use
+func (n *discoveredNode) drain() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.removed = true
+	if n.client != nil {
+		n.client.Close()
+		n.client = nil
+	}
+}
+
+// Addresses returns the currently resolved addresses, sorted
+func (d *Discovery) Addresses() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	addrs := make([]string, 0, len(d.nodes))
+	for addr := range d.nodes {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// Err returns the error from the most recent resolution, if it failed
+func (d *Discovery) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+// Do runs fn with the connection to addr, dialing it first if needed.
+// Calls for the same address are serialized. If fn returns a network
+// error the connection is dropped and redialed on the next call.
+func (d *Discovery) Do(addr string, fn func(*Client) error) error {
+	d.mu.Lock()
+	node, ok := d.nodes[addr]
+	d.mu.Unlock()
+	if !ok {
+		return ErrUnknownAddress
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	if node.removed {
+		return ErrUnknownAddress
+	}
+	if node.client == nil {
+		c, err := New(addr)
+		if err != nil {
+			return err
+		}
+		node.client = c
+	}
+
+	err := fn(node.client)
+	if isConnError(err) {
+		node.client.Close()
+		node.client = nil
+	}
+	return err
+}
+
+// isConnError reports whether err means the connection is unusable
+func isConnError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Close stops re-resolution and closes every connection
+func (d *Discovery) Close() error {
+	close(d.stop)
+	<-d.done
+
+	d.mu.Lock()
+	nodes := d.nodes
+	d.nodes = make(map[string]*discoveredNode)
+	d.mu.Unlock()
+
+	for _, node := range nodes {
+		node.drain()
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pongServer accepts the HELLO handshake and answers every other command
+// line with PONG
+func pongServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if strings.HasPrefix(line, "HELLO ") {
+						conn.Write([]byte("OK\r\n"))
+						continue
+					}
+					conn.Write([]byte("PONG\r\n"))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestDiscovery_RefreshAddsAndDrains(t *testing.T) {
+	a, b := pongServer(t), pongServer(t)
+
+	var mu sync.Mutex
+	addrs := []string{a}
+	lookup := func(ctx context.Context) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), addrs...), nil
+	}
+
+	d, err := newDiscovery("osprey.test", DiscoveryOptions{Interval: time.Hour}, lookup)
+	require.NoError(t, err)
+	defer d.Close()
+
+	assert.Equal(t, []string{a}, d.Addresses())
+	require.NoError(t, d.Do(a, func(c *Client) error { return c.Ping() }))
+
+	mu.Lock()
+	addrs = []string{b}
+	mu.Unlock()
+	require.NoError(t, d.Refresh(context.Background()))
+
+	assert.Equal(t, []string{b}, d.Addresses())
+	assert.ErrorIs(t, d.Do(a, func(c *Client) error { return c.Ping() }), ErrUnknownAddress)
+	require.NoError(t, d.Do(b, func(c *Client) error { return c.Ping() }))
+}
+
+func TestDiscovery_LookupErrorKeepsSet(t *testing.T) {
+	a := pongServer(t)
+
+	fail := false
+	lookup := func(ctx context.Context) ([]string, error) {
+		if fail {
+			return nil, &net.DNSError{Err: "no such host", Name: "osprey.test"}
+		}
+		return []string{a}, nil
+	}
+
+	d, err := newDiscovery("osprey.test", DiscoveryOptions{Interval: time.Hour}, lookup)
+	require.NoError(t, err)
+	defer d.Close()
+
+	fail = true
+	assert.Error(t, d.Refresh(context.Background()))
+	assert.Error(t, d.Err())
+	assert.Equal(t, []string{a}, d.Addresses())
+}
+
+func TestDiscovery_ResolveHost(t *testing.T) {
+	d, err := NewDiscovery("127.0.0.1", DiscoveryOptions{Port: "1", Interval: time.Hour})
+	require.NoError(t, err)
+	defer d.Close()
+
+	assert.Equal(t, []string{"127.0.0.1:1"}, d.Addresses())
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// GetEarly retrieves a value like Get, but lets the server flag it as
+// soft-expired shortly before it really expires (GET <key> EARLY). A
+// caller that sees Response.SoftExpired should refresh the value; other
+// callers keep reading the cached one, so a hot key is reloaded by one
+// client instead of all of them at once.
+func (c *Client) GetEarly(key string) (*Response, error) {
+	if err := c.sendCommand("GET", key, "EARLY"); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// Fetch returns the cached value of key, calling load and caching its
+// result for ttl when the key is missing or soft-expired. If load fails
+// while a soft-expired value is still cached, that value is returned.
+func (c *Client) Fetch(key string, ttl time.Duration, load func() ([]byte, error)) ([]byte, error) {
+	resp, err := c.GetEarly(key)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	if resp.Success && !resp.SoftExpired {
+		return resp.Value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		if resp.Success {
+			return resp.Value, nil
+		}
+		return nil, err
+	}
+
+	if _, err := c.Set(key, value, "EX", strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
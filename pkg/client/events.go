@@ -0,0 +1,63 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Event reports a key that expired or was deleted under a prefix rule
+// with notify set
+type Event struct {
+	Seq    uint64
+	Type   string // "expired" or "deleted"
+	TimeMs int64
+	Key    string
+}
+
+// Events returns up to count queued events after sequence number after,
+// oldest first (EVENTS READ). Events stay queued until acknowledged with
+// AckEvents, so a consumer that restarts reads them again from its last
+// acknowledged sequence number. A count of 0 uses the server's default.
+func (c *Client) Events(after uint64, count int) ([]Event, error) {
+	args := []string{"EVENTS", "READ", strconv.FormatUint(after, 10)}
+	if count > 0 {
+		args = append(args, strconv.Itoa(count))
+	}
+	if err := c.sendCommand(args...); err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "END" {
+			return events, nil
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, fmt.Errorf("%s", strings.TrimPrefix(line, "ERR "))
+		}
+
+		parts := strings.Fields(line)
+		if len(parts) != 5 || parts[0] != "EVENT" {
+			return nil, fmt.Errorf("invalid EVENT line: %s", line)
+		}
+		event := Event{Type: parts[2], Key: parts[4]}
+		event.Seq, _ = strconv.ParseUint(parts[1], 10, 64)
+		event.TimeMs, _ = strconv.ParseInt(parts[3], 10, 64)
+		events = append(events, event)
+	}
+}
+
+// AckEvents acknowledges the events up to seq, so the server drops them
+// (EVENTS ACK)
+func (c *Client) AckEvents(seq uint64) (*Response, error) {
+	if err := c.sendCommand("EVENTS", "ACK", strconv.FormatUint(seq, 10)); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
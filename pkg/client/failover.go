@@ -0,0 +1,275 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Option configures a Client created by New
+type Option func(*options)
+
+type options struct {
+	dialTimeout    time.Duration
+	healthInterval time.Duration
+	onTopology     func(TopologyEvent)
+	name           string
+	compress       bool
+	compressMin    int
+	trace          bool
+	warnings       bool
+	hooks          []Hooks
+	debug          *debugLog // nil without WithDebug
+}
+
+// WithDialTimeout sets the timeout for each connection attempt (default 5s)
+func WithDialTimeout(d time.Duration) Option {
+	return func(o *options) { o.dialTimeout = d }
+}
+
+// WithHealthCheck pings every address each interval on a separate
+// connection. When the active address stops answering, the client fails
+// over before its next command instead of waiting for that command to fail.
+func WithHealthCheck(interval time.Duration) Option {
+	return func(o *options) { o.healthInterval = interval }
+}
+
+// OnTopologyChange registers fn to be told when addresses go down or come
+// back and when the client fails over. fn may be called from the health
+// check goroutine and must not block.
+func OnTopologyChange(fn func(TopologyEvent)) Option {
+	return func(o *options) { o.onTopology = fn }
+}
+
+// TopologyEventKind says what a TopologyEvent reports
+type TopologyEventKind int
+
+const (
+	AddressDown TopologyEventKind = iota // health check failed
+	AddressUp                            // health check succeeded again
+	Failover                             // the client switched addresses
+)
+
+func (k TopologyEventKind) String() string {
+	switch k {
+	case AddressDown:
+		return "down"
+	case AddressUp:
+		return "up"
+	case Failover:
+		return "failover"
+	default:
+		return "unknown"
+	}
+}
+
+// TopologyEvent describes a change in the reachability of a client's
+// addresses
+type TopologyEvent struct {
+	Kind     TopologyEventKind
+	Address  string // the address that changed, or the new active address
+	Previous string // the old active address, for Failover
+	Err      error  // why the address is down, for AddressDown and Failover
+}
+
+// splitAddresses parses a comma-separated address list
+func splitAddresses(address string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(address, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// dial connects to the first address that answers, starting at index
+// start and trying addresses the health checker considers down last, and
+// makes it the active one
+func (c *Client) dial(start int) error {
+	var healthy, down []int
+	for i := 0; i < len(c.addrs); i++ {
+		idx := (start + i) % len(c.addrs)
+		if c.health != nil && !c.health.isUp(c.addrs[idx]) {
+			down = append(down, idx)
+		} else {
+			healthy = append(healthy, idx)
+		}
+	}
+
+	var lastErr error
+	for _, idx := range append(healthy, down...) {
+		conn, err := net.DialTimeout("tcp", c.addrs[idx], c.opts.dialTimeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		c.conn = conn
+		c.reader = bufio.NewReader(conn)
+		c.writer = bufio.NewWriter(conn)
+		if err := c.handshake(); err != nil {
+			conn.Close()
+			lastErr = err
+			continue
+		}
+
+		c.active = idx
+		c.broken = nil
+		return nil
+	}
+	return lastErr
+}
+
+// prepare runs before every command. If the active connection failed or
+// the health checker has marked its address down, it moves to the next
+// address that answers. Failover is always to the next address in the
+// list; the client does not move back when an earlier one recovers.
+func (c *Client) prepare() error {
+	cause := c.broken
+	if cause == nil && c.health != nil && !c.health.isUp(c.addrs[c.active]) && c.hasHealthyStandby() {
+		cause = fmt.Errorf("health check failed")
+	}
+	if cause == nil {
+		return nil
+	}
+
+	previous := c.addrs[c.active]
+	if c.conn != nil {
+		c.conn.Close()
+	}
+
+	start := c.active
+	if len(c.addrs) > 1 {
+		start++
+	}
+	if err := c.dial(start); err != nil {
+		c.broken = err
+		return err
+	}
+
+	if c.addrs[c.active] != previous && c.opts.onTopology != nil {
+		c.opts.onTopology(TopologyEvent{Kind: Failover, Address: c.addrs[c.active], Previous: previous, Err: cause})
+	}
+	return nil
+}
+
+// hasHealthyStandby reports whether an address other than the active one
+// passed its last health check
+func (c *Client) hasHealthyStandby() bool {
+	for i, addr := range c.addrs {
+		if i != c.active && c.health.isUp(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// markBroken records that the active connection failed, so the next
+// command fails over
+func (c *Client) markBroken(err error) {
+	if err != nil && c.broken == nil {
+		c.broken = err
+	}
+}
+
+// healthChecker pings each address on its own connection
+type healthChecker struct {
+	addrs    []string
+	interval time.Duration
+	timeout  time.Duration
+	notify   func(TopologyEvent)
+
+	mu   sync.Mutex
+	down map[string]bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newHealthChecker(addrs []string, interval, timeout time.Duration, notify func(TopologyEvent)) *healthChecker {
+	h := &healthChecker{
+		addrs:    addrs,
+		interval: interval,
+		timeout:  timeout,
+		notify:   notify,
+		down:     make(map[string]bool),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+func (h *healthChecker) run() {
+	defer close(h.done)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+			for _, addr := range h.addrs {
+				h.record(addr, ping(addr, h.timeout))
+			}
+		}
+	}
+}
+
+// record stores the result of one check and reports transitions
+func (h *healthChecker) record(addr string, err error) {
+	h.mu.Lock()
+	wasDown := h.down[addr]
+	h.down[addr] = err != nil
+	h.mu.Unlock()
+
+	if h.notify == nil || wasDown == (err != nil) {
+		return
+	}
+	if err != nil {
+		h.notify(TopologyEvent{Kind: AddressDown, Address: addr, Err: err})
+	} else {
+		h.notify(TopologyEvent{Kind: AddressUp, Address: addr})
+	}
+}
+
+// isUp reports whether addr passed its last check; unchecked addresses
+// count as up
+func (h *healthChecker) isUp(addr string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.down[addr]
+}
+
+func (h *healthChecker) close() {
+	close(h.stop)
+	<-h.done
+}
+
+// ping sends PING on a fresh connection and waits for PONG
+func ping(addr string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(line) != "PONG" {
+		return fmt.Errorf("unexpected PING reply: %s", strings.TrimSpace(line))
+	}
+	return nil
+}
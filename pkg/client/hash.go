@@ -0,0 +1,122 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HSet sets fields of the hash at key, creating it if needed. The
+// response carries the hash's new version, and the number of fields
+// added in Integer. A key holding a string is an ERR TYPE.
+func (c *Client) HSet(key string, fields map[string][]byte) (*Response, error) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := []string{"HSET", key}
+	var payload []byte
+	for _, name := range names {
+		args = append(args, name, strconv.Itoa(len(fields[name])))
+		payload = append(payload, fields[name]...)
+	}
+
+	if err := c.sendCommandWithPayload(args, payload); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// HGet retrieves a field of the hash at key, with the hash's version and
+// expiry. A missing key or field is a NOT_FOUND.
+func (c *Client) HGet(key, field string) (*Response, error) {
+	if err := c.sendCommand("HGET", key, field); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// HDel deletes fields of the hash at key and returns how many existed.
+// The key is deleted with its last field.
+func (c *Client) HDel(key string, fields ...string) (int64, error) {
+	if err := c.sendCommand(append([]string{"HDEL", key}, fields...)...); err != nil {
+		return 0, err
+	}
+
+	resp, err := c.readResponse()
+	if err != nil {
+		return 0, err
+	}
+	if resp.Error != "" {
+		return 0, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Integer, nil
+}
+
+// HMGet retrieves several fields of the hash at key, one response per
+// field in order, like MGet
+func (c *Client) HMGet(key string, fields ...string) ([]*Response, error) {
+	if err := c.sendCommand(append([]string{"HMGET", key}, fields...)...); err != nil {
+		return nil, err
+	}
+
+	var responses []*Response
+	for range fields {
+		resp, err := c.readMGetResponse()
+		if err == nil && resp.Type == "ERR" {
+			// The server replies with the error alone
+			err = fmt.Errorf("%s", resp.Error)
+		}
+		if err != nil {
+			c.endCommand(err)
+			return nil, err
+		}
+		responses = append(responses, resp)
+	}
+	c.endCommand(nil)
+
+	return responses, nil
+}
+
+// HGetAll retrieves every field of the hash at key. A missing key has no
+// fields.
+func (c *Client) HGetAll(key string) (map[string][]byte, error) {
+	if err := c.sendCommand("HGETALL", key); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string][]byte)
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		parts := strings.Fields(line)
+		switch {
+		case line == "END":
+			return fields, nil
+		case strings.HasPrefix(line, "ERR "):
+			return nil, fmt.Errorf("%s", line[len("ERR "):])
+		case len(parts) == 5 && parts[0] == "VALUE":
+			length, err := strconv.Atoi(parts[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid length in VALUE response")
+			}
+			value := make([]byte, length+2)
+			if _, err := io.ReadFull(c.reader, value); err != nil {
+				return nil, err
+			}
+			c.logPayload(value[:length])
+			fields[parts[1]] = value[:length]
+		default:
+			return nil, fmt.Errorf("invalid HGETALL response: %s", line)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package client
+
+// PFAdd adds elements to the HyperLogLog sketch at key, creating it if
+// needed. The response carries the sketch's version, and 1 in Integer if
+// the sketch changed. A key holding anything but a sketch is an ERR TYPE.
+func (c *Client) PFAdd(key string, elements ...string) (*Response, error) {
+	if err := c.sendCommand(append([]string{"PFADD", key}, elements...)...); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// PFCount estimates the number of distinct elements added to the sketches
+// at keys, counting each element once across them
+func (c *Client) PFCount(keys ...string) (int64, error) {
+	return c.setInteger(append([]string{"PFCOUNT"}, keys...)...)
+}
+
+// PFMerge stores the union of the sketches at dest and sources in dest.
+// The response carries the merged sketch's version.
+func (c *Client) PFMerge(dest string, sources ...string) (*Response, error) {
+	if err := c.sendCommand(append([]string{"PFMERGE", dest}, sources...)...); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
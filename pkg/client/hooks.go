@@ -0,0 +1,57 @@
+package client
+
+import (
+	"strings"
+	"time"
+)
+
+// Hooks observes the commands a client sends, for metrics or tracing
+// without wrapping every call. Its methods are called on the goroutine
+// running the command and should return quickly; a Hooks shared by
+// several clients must be safe for concurrent use.
+type Hooks interface {
+	// OnCommandStart is called before a command is sent, with its name in
+	// upper case, such as "GET" or "CLIENT"
+	OnCommandStart(name string)
+
+	// OnCommandEnd is called once the command's reply has been read, with
+	// the time since it started and the error it failed with: a
+	// connection error, or the message of an ERR reply. A value read with
+	// GetStream or GetReader ends once it has been read or closed.
+	OnCommandEnd(name string, d time.Duration, err error)
+}
+
+// WithHooks calls hooks around every command, in the order given. HELLO
+// sent while connecting and the commands a Subscription sends are not
+// reported.
+func WithHooks(hooks ...Hooks) Option {
+	return func(o *options) { o.hooks = append(o.hooks, hooks...) }
+}
+
+// startCommand reports a command to the hooks as it is sent. One still
+// running, because a client-side error stopped its reply being read, ends
+// first.
+func (c *Client) startCommand(name string) {
+	if len(c.opts.hooks) == 0 {
+		return
+	}
+	c.endCommand(nil)
+
+	c.command = strings.ToUpper(name)
+	c.commandStart = time.Now()
+	for _, h := range c.opts.hooks {
+		h.OnCommandStart(c.command)
+	}
+}
+
+// endCommand reports the end of the running command, if there is one
+func (c *Client) endCommand(err error) {
+	if c.command == "" {
+		return
+	}
+	name, d := c.command, time.Since(c.commandStart)
+	c.command = ""
+	for _, h := range c.opts.hooks {
+		h.OnCommandEnd(name, d, err)
+	}
+}
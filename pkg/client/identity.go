@@ -0,0 +1,193 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the version of this client library, reported to the server
+// as LIB osprey-go/<Version>
+const Version = "0.1.0"
+
+// maxNameLen matches the server's limit on client names
+const maxNameLen = 128
+
+// WithClientName sets the name the client reports to the server, shown in
+// CLIENT LIST. It defaults to the program name.
+func WithClientName(name string) Option {
+	return func(o *options) { o.name = name }
+}
+
+// defaultName returns the program name, for clients that don't set one
+func defaultName() string {
+	if len(os.Args) == 0 {
+		return ""
+	}
+	return filepath.Base(os.Args[0])
+}
+
+// sanitizeName makes name acceptable to the server: spaces and other
+// unprintable characters become '_' and the result is truncated
+func sanitizeName(name string) string {
+	b := []byte(name)
+	for i, ch := range b {
+		if ch <= ' ' || ch > '~' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > maxNameLen {
+		b = b[:maxNameLen]
+	}
+	return string(b)
+}
+
+// handshake reports the client and library names on a fresh connection
+// and negotiates compression, tracing and warnings if WithCompression,
+// WithTracing or WithWarnings were given. Servers that predate HELLO NAME reject it; that
+// is not an error.
+func (c *Client) handshake() error {
+	args := []string{"HELLO"}
+	if name := sanitizeName(c.opts.name); name != "" {
+		args = append(args, "NAME", name)
+	}
+	args = append(args, "LIB", "osprey-go/"+Version)
+	if c.opts.compress {
+		args = append(args, "COMPRESS", compressionDeflate, "COMPRESSMIN", strconv.Itoa(c.opts.compressMin))
+	}
+	if c.opts.trace {
+		args = append(args, "TRACE", "ON")
+	}
+	if c.opts.warnings {
+		args = append(args, "WARNINGS", "ON")
+	}
+	c.compression = ""
+	c.tracing = false
+
+	c.conn.SetDeadline(time.Now().Add(c.opts.dialTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	c.logCommand(args, nil)
+	if _, err := c.writer.WriteString(strings.Join(args, " ") + "\r\n"); err != nil {
+		return err
+	}
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	c.logReply(line)
+
+	parts := strings.Fields(line)
+	switch {
+	case strings.HasPrefix(line, "ERR "):
+		return nil
+	case line == "OK":
+	case len(parts) == 3 && parts[0] == "OK" && parts[1] == "COMPRESS":
+		if parts[2] != "none" {
+			c.compression = parts[2]
+		}
+	default:
+		return fmt.Errorf("unexpected HELLO response: %s", line)
+	}
+	c.tracing = c.opts.trace
+	return nil
+}
+
+// SetName changes the name reported in CLIENT LIST. The name is kept and
+// sent again after a failover.
+func (c *Client) SetName(name string) error {
+	name = sanitizeName(name)
+	if err := c.sendCommand("CLIENT", "SETNAME", name); err != nil {
+		return err
+	}
+
+	resp, err := c.readResponse()
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("%s", resp.Error)
+	}
+
+	c.opts.name = name
+	return nil
+}
+
+// ClientInfo describes one connection in CLIENT LIST
+type ClientInfo struct {
+	ID    uint64
+	Addr  string
+	Name  string // empty if the client never set one
+	Lib   string
+	Age   time.Duration
+	Idle  time.Duration
+	In    uint64 // bytes read from the connection
+	Out   uint64 // bytes written to it
+	Cmd   string // most recent command
+	Trace string // trace ID of the most recent command, if it had one
+}
+
+// ClientList returns the server's open connections, oldest first
+func (c *Client) ClientList() ([]ClientInfo, error) {
+	if err := c.sendCommand("CLIENT", "LIST"); err != nil {
+		return nil, err
+	}
+
+	var clients []ClientInfo
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "END" {
+			return clients, nil
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, fmt.Errorf("%s", strings.TrimPrefix(line, "ERR "))
+		}
+		clients = append(clients, parseClientInfo(line))
+	}
+}
+
+// parseClientInfo parses one CLIENT LIST line of key=value fields
+func parseClientInfo(line string) ClientInfo {
+	var info ClientInfo
+	for _, field := range strings.Fields(line) {
+		key, value, _ := strings.Cut(field, "=")
+		if value == "-" {
+			value = ""
+		}
+		switch key {
+		case "id":
+			info.ID, _ = strconv.ParseUint(value, 10, 64)
+		case "addr":
+			info.Addr = value
+		case "name":
+			info.Name = value
+		case "lib":
+			info.Lib = value
+		case "age":
+			n, _ := strconv.ParseInt(value, 10, 64)
+			info.Age = time.Duration(n) * time.Second
+		case "idle":
+			n, _ := strconv.ParseInt(value, 10, 64)
+			info.Idle = time.Duration(n) * time.Second
+		case "in":
+			info.In, _ = strconv.ParseUint(value, 10, 64)
+		case "out":
+			info.Out, _ = strconv.ParseUint(value, 10, 64)
+		case "cmd":
+			info.Cmd = value
+		case "trace":
+			info.Trace = value
+		}
+	}
+	return info
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LatencyEvent summarizes the latency spikes of one kind of operation
+type LatencyEvent struct {
+	Event  string
+	Time   time.Time // of the latest spike
+	Latest time.Duration
+	Max    time.Duration
+}
+
+// LatencySample is one latency spike; spikes within a second are merged
+type LatencySample struct {
+	Time    time.Time
+	Latency time.Duration
+}
+
+// LatencyLatest returns the latest and largest spike of each event
+// ("command", "fsync", "sweep") that had any
+func (c *Client) LatencyLatest() ([]LatencyEvent, error) {
+	if err := c.sendCommand("LATENCY", "LATEST"); err != nil {
+		return nil, err
+	}
+
+	var events []LatencyEvent
+	err := c.readLatencyLines("EVENT", 5, func(parts []string) {
+		events = append(events, LatencyEvent{
+			Event:  parts[1],
+			Time:   parseUnix(parts[2]),
+			Latest: parseMillis(parts[3]),
+			Max:    parseMillis(parts[4]),
+		})
+	})
+	return events, err
+}
+
+// LatencyHistory returns the recorded spikes of event, oldest first
+func (c *Client) LatencyHistory(event string) ([]LatencySample, error) {
+	if err := c.sendCommand("LATENCY", "HISTORY", event); err != nil {
+		return nil, err
+	}
+
+	var samples []LatencySample
+	err := c.readLatencyLines("SAMPLE", 3, func(parts []string) {
+		samples = append(samples, LatencySample{
+			Time:    parseUnix(parts[1]),
+			Latency: parseMillis(parts[2]),
+		})
+	})
+	return samples, err
+}
+
+// LatencyReset discards the spikes of the given events, or of all events
+func (c *Client) LatencyReset(events ...string) (*Response, error) {
+	if err := c.sendCommand(append([]string{"LATENCY", "RESET"}, events...)...); err != nil {
+		return nil, err
+	}
+	return c.readResponse()
+}
+
+// readLatencyLines reads lines of fields starting with tag up to END
+func (c *Client) readLatencyLines(tag string, fields int, fn func(parts []string)) error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			return nil
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return fmt.Errorf("%s", strings.TrimPrefix(line, "ERR "))
+		}
+		parts := strings.Fields(line)
+		if len(parts) != fields || parts[0] != tag {
+			return fmt.Errorf("invalid LATENCY response: %s", line)
+		}
+		fn(parts)
+	}
+}
+
+func parseUnix(s string) time.Time {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return time.Unix(n, 0)
+}
+
+func parseMillis(s string) time.Duration {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return time.Duration(n) * time.Millisecond
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/metrics"
+)
+
+// metricsNamespace prefixes every client metric name in Prometheus output
+const metricsNamespace = "osprey_client"
+
+// Metrics is a Hooks that counts the commands clients send and the errors
+// they get, and records their latency, for Prometheus to scrape. One
+// Metrics is usually shared by every client of an application:
+//
+//	m := client.NewMetrics()
+//	http.Handle("/metrics/osprey", m.Handler())
+//	c, err := client.New(addr, client.WithHooks(m))
+type Metrics struct {
+	registry *metrics.Registry
+	latency  *metrics.Histogram
+	inFlight int64
+
+	mu       sync.Mutex
+	commands map[string]int64 // by command name
+	errors   map[string]int64
+}
+
+// NewMetrics returns a Metrics with every count at zero
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: metrics.NewRegistry(),
+		commands: make(map[string]int64),
+		errors:   make(map[string]int64),
+	}
+
+	r := m.registry
+	r.CounterFamily("commands_total", "client", "Commands sent", "command", func() map[string]int64 {
+		return m.counts(m.commands)
+	})
+	r.CounterFamily("command_errors_total", "client", "Commands that failed with a connection error or an ERR reply", "command", func() map[string]int64 {
+		return m.counts(m.errors)
+	})
+	r.GaugeFunc("commands_in_flight", "client", "Commands sent whose reply hasn't been read", func() int64 {
+		return atomic.LoadInt64(&m.inFlight)
+	})
+	m.latency = r.Histogram("command_latency", "client", "Command latency, from sending the command to reading its reply")
+	return m
+}
+
+// OnCommandStart counts a command in flight
+func (m *Metrics) OnCommandStart(name string) {
+	atomic.AddInt64(&m.inFlight, 1)
+}
+
+// OnCommandEnd counts a finished command and records its latency
+func (m *Metrics) OnCommandEnd(name string, d time.Duration, err error) {
+	atomic.AddInt64(&m.inFlight, -1)
+	m.latency.Observe(d)
+
+	m.mu.Lock()
+	m.commands[name]++
+	if err != nil {
+		m.errors[name]++
+	}
+	m.mu.Unlock()
+}
+
+// counts returns a copy of counts, taken under the lock
+func (m *Metrics) counts(counts map[string]int64) map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	copied := make(map[string]int64, len(counts))
+	for name, n := range counts {
+		copied[name] = n
+	}
+	return copied
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition
+// format, named osprey_client_*
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	return m.registry.WritePrometheus(w, metricsNamespace)
+}
+
+// Handler serves the metrics for Prometheus to scrape
+func (m *Metrics) Handler() http.Handler {
+	return m.registry.Handler(metricsNamespace)
+}
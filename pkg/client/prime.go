@@ -0,0 +1,35 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ExportHot returns the count most accessed keys of the server as write
+// commands in the wire protocol, most accessed first, usable as a
+// warmup_file. EXPORTHOT is an admin command.
+func (c *Client) ExportHot(count int) ([]byte, error) {
+	if err := c.sendCommand("EXPORTHOT", strconv.Itoa(count)); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.readResponse()
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Value, nil
+}
+
+// PrimeFrom asks the server, which must be empty, to copy the count most
+// accessed keys of the node at addr. Response.Version holds the number of
+// keys copied. PRIMEFROM is an admin command.
+func (c *Client) PrimeFrom(addr string, count int) (*Response, error) {
+	if err := c.sendCommand("PRIMEFROM", addr, strconv.Itoa(count)); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
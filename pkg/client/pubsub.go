@@ -0,0 +1,220 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bharatmehan/osprey/pkg/command"
+)
+
+// Message is a message published to a channel, as received by a
+// Subscription
+type Message struct {
+	Channel string
+	Payload []byte
+}
+
+// Publish sends payload to the subscribers of channel and returns how many
+// it was queued for. Messages aren't stored; subscribers that aren't
+// connected miss them.
+func (c *Client) Publish(channel string, payload []byte) (int64, error) {
+	if err := c.sendCommandWithPayload([]string{"PUBLISH", channel, strconv.Itoa(len(payload))}, payload); err != nil {
+		return 0, err
+	}
+
+	resp, err := c.readResponse()
+	if err != nil {
+		return 0, err
+	}
+	if resp.Error != "" {
+		return 0, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Integer, nil
+}
+
+// Subscription streams the messages published to the channels it is
+// subscribed to. While it is open the client's connection belongs to it,
+// so the client must not send other commands until Close returns.
+type Subscription struct {
+	c        *Client
+	messages chan Message
+	closing  chan struct{} // closed by Close; messages not yet taken are discarded
+	done     chan struct{} // closed when the reader stops
+	err      error         // why the reader stopped, if not unsubscribed; read after done
+	close    sync.Once
+
+	mu      sync.Mutex
+	pending int  // SUBSCRIBE and UNSUBSCRIBE commands sent but not answered
+	ended   bool // the reader has stopped or is about to
+}
+
+// Subscribe subscribes the connection to channels and returns a
+// Subscription receiving their messages
+func (c *Client) Subscribe(channels ...string) (*Subscription, error) {
+	args := append([]string{"SUBSCRIBE"}, channels...)
+	if err := command.Check(args); err != nil {
+		return nil, err
+	}
+	if err := c.sendCommand(args...); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.readResponse()
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+
+	s := &Subscription{
+		c:        c,
+		messages: make(chan Message, 64),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.read()
+	return s, nil
+}
+
+// Messages returns the channel messages arrive on, in the order they were
+// published to each channel. It is closed when the subscription ends:
+// after Close, after unsubscribing from the last channel, or when the
+// connection fails. A subscriber that falls more than pubsub_queue_max
+// messages behind is disconnected by the server.
+func (s *Subscription) Messages() <-chan Message {
+	return s.messages
+}
+
+// Subscribe adds channels to the subscription
+func (s *Subscription) Subscribe(channels ...string) error {
+	return s.send(append([]string{"SUBSCRIBE"}, channels...))
+}
+
+// Unsubscribe removes channels from the subscription, or every channel if
+// none are given. The subscription ends once no channels are left.
+func (s *Subscription) Unsubscribe(channels ...string) error {
+	return s.send(append([]string{"UNSUBSCRIBE"}, channels...))
+}
+
+// send sends a SUBSCRIBE or UNSUBSCRIBE command, whose reply the reader
+// takes. Replies aren't waited for, so a caller that is also the one
+// taking messages can't block the reader. The command is written directly
+// rather than with sendCommand, which could fail over to another
+// connection under the reader.
+func (s *Subscription) send(args []string) error {
+	if err := command.Check(args); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return fmt.Errorf("subscription has ended")
+	}
+	s.c.logCommand(args, nil)
+	_, err := s.c.writer.WriteString(s.c.commandLine(args))
+	if err == nil {
+		err = s.c.writer.Flush()
+	}
+	if err != nil {
+		// Stop the reader with the connection
+		s.ended = true
+		s.c.conn.Close()
+		return err
+	}
+	s.pending++
+	return nil
+}
+
+// Close unsubscribes from every channel and waits for the subscription to
+// end, after which the client can send other commands. It returns the
+// error that ended the subscription, if it wasn't unsubscribing; the
+// client then reconnects before its next command.
+func (s *Subscription) Close() error {
+	s.close.Do(func() { close(s.closing) })
+
+	// Once the subscription has ended, or the connection failed, there is
+	// nothing to unsubscribe from and the reader is stopping
+	s.Unsubscribe()
+	<-s.done
+
+	s.c.markBroken(s.err)
+	return s.err
+}
+
+// read reads MESSAGE frames and the replies to SUBSCRIBE and UNSUBSCRIBE
+// until the connection has no channels left and no replies to come
+func (s *Subscription) read() {
+	defer close(s.done)
+	defer close(s.messages)
+
+	s.err = s.readFrames()
+
+	s.mu.Lock()
+	s.ended = true
+	s.mu.Unlock()
+}
+
+// log logs a frame the reader received, for WithDebug. The client's last
+// command can't be looked at from the reader, so every frame is logged as
+// SUBSCRIBE's.
+func (s *Subscription) log(f Frame) {
+	if s.c.opts.debug != nil {
+		f.Command = "SUBSCRIBE"
+		s.c.logFrame(f)
+	}
+}
+
+// readFrames reads until the subscription ends, returning why if it
+// wasn't unsubscribing from the last channel
+func (s *Subscription) readFrames() error {
+	reader := s.c.reader
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		s.log(Frame{Line: line})
+
+		parts := strings.Fields(line)
+		if len(parts) == 3 && parts[0] == "MESSAGE" {
+			length, err := strconv.Atoi(parts[2])
+			if err != nil || length < 0 {
+				return fmt.Errorf("invalid MESSAGE frame: %s", line)
+			}
+			payload := make([]byte, length+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return err
+			}
+			s.log(Frame{Payload: payload[:length]})
+			select {
+			case s.messages <- Message{Channel: parts[1], Payload: payload[:length]}:
+			case <-s.closing:
+			}
+			continue
+		}
+
+		if len(parts) == 0 || parts[0] != "OK" {
+			return fmt.Errorf("unexpected reply while subscribed: %s", line)
+		}
+		channels := 0
+		if len(parts) > 1 {
+			channels, _ = strconv.Atoi(parts[1])
+		}
+
+		s.mu.Lock()
+		s.pending--
+		if channels == 0 && s.pending <= 0 {
+			s.ended = true
+			s.mu.Unlock()
+			return nil
+		}
+		s.mu.Unlock()
+	}
+}
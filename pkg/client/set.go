@@ -0,0 +1,78 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SAdd adds members to the set at key, creating it if needed. The
+// response carries the set's new version, and the number of members
+// added in Integer. A key holding another type is an ERR TYPE.
+func (c *Client) SAdd(key string, members ...string) (*Response, error) {
+	if err := c.sendCommand(append([]string{"SADD", key}, members...)...); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// SRem removes members from the set at key and returns how many were in
+// it. The key is deleted with its last member.
+func (c *Client) SRem(key string, members ...string) (int64, error) {
+	return c.setInteger(append([]string{"SREM", key}, members...)...)
+}
+
+// SIsMember reports whether member is in the set at key
+func (c *Client) SIsMember(key, member string) (bool, error) {
+	n, err := c.setInteger("SISMEMBER", key, member)
+	return n == 1, err
+}
+
+// SCard returns the number of members of the set at key, 0 if it is
+// missing
+func (c *Client) SCard(key string) (int64, error) {
+	return c.setInteger("SCARD", key)
+}
+
+// setInteger sends a set command that replies with an integer
+func (c *Client) setInteger(args ...string) (int64, error) {
+	if err := c.sendCommand(args...); err != nil {
+		return 0, err
+	}
+
+	resp, err := c.readResponse()
+	if err != nil {
+		return 0, err
+	}
+	if resp.Error != "" {
+		return 0, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Integer, nil
+}
+
+// SMembers returns the members of the set at key, sorted. A missing key
+// has no members.
+func (c *Client) SMembers(key string) ([]string, error) {
+	if err := c.sendCommand("SMEMBERS", key); err != nil {
+		return nil, err
+	}
+
+	var members []string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case line == "END":
+			return members, nil
+		case strings.HasPrefix(line, "MEMBER "):
+			members = append(members, line[len("MEMBER "):])
+		case strings.HasPrefix(line, "ERR "):
+			return nil, fmt.Errorf("%s", line[len("ERR "):])
+		default:
+			return nil, fmt.Errorf("invalid SMEMBERS response: %s", line)
+		}
+	}
+}
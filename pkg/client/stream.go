@@ -0,0 +1,153 @@
+package client
+
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// GetStream retrieves a value without buffering it on the client. The
+// returned Response carries the version and expiry; its Value is nil. The
+// stream reads straight from the connection, so it must be closed (which
+// discards any unread bytes) before the next command is sent. A missing key
+// returns a nil stream and a NOT_FOUND response. For WithHooks, the GET
+// ends once the stream has been read to the end or closed.
+func (c *Client) GetStream(key string) (io.ReadCloser, *Response, error) {
+	if err := c.sendCommand("GET", key); err != nil {
+		return nil, nil, err
+	}
+
+	line, err := c.readLine()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parts := strings.Fields(line)
+	if len(parts) < 4 || parts[0] != "VALUE" {
+		resp, err := c.parseResponse(line)
+		c.endCommand(err)
+		return nil, resp, err
+	}
+
+	length, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid length in VALUE response")
+	}
+
+	resp := &Response{Type: "VALUE", Success: true, Size: length}
+	resp.Version, _ = strconv.ParseUint(parts[2], 10, 64)
+	resp.ExpiryMs, _ = strconv.ParseInt(parts[3], 10, 64)
+
+	if len(parts) > 4 && parts[4] == "CHUNKED" {
+		return &valueStream{r: &chunkReader{c: c, total: length}, c: c}, resp, nil
+	}
+	if len(parts) > 5 {
+		rawLen, err := strconv.Atoi(parts[5])
+		if err != nil || strings.ToLower(parts[4]) != compressionDeflate {
+			return nil, nil, fmt.Errorf("invalid compressed VALUE response")
+		}
+		resp.Size = rawLen
+		raw := io.LimitReader(c.reader, int64(length))
+		return &valueStream{r: flate.NewReader(raw), raw: raw, c: c, trailer: true}, resp, nil
+	}
+	return &valueStream{r: io.LimitReader(c.reader, int64(length)), c: c, trailer: true}, resp, nil
+}
+
+// SetFromReader stores size bytes read from r, copying them to the
+// connection as they are read. If r returns fewer than size bytes the
+// connection is closed, since the server is still waiting for the payload.
+func (c *Client) SetFromReader(key string, r io.Reader, size int64, options ...string) (*Response, error) {
+	args := []string{"SET", key, strconv.FormatInt(size, 10)}
+	args = append(args, options...)
+
+	c.startCommand(args[0])
+	if err := c.prepare(); err != nil {
+		c.endCommand(err)
+		return nil, err
+	}
+	c.logCommand(args, nil)
+	if _, err := c.writer.WriteString(c.commandLine(args)); err != nil {
+		c.endCommand(err)
+		return nil, err
+	}
+
+	if _, err := io.CopyN(c.writer, r, size); err != nil {
+		c.conn.Close()
+		c.markBroken(err)
+		c.endCommand(err)
+		return nil, err
+	}
+
+	if _, err := c.writer.WriteString("\r\n"); err != nil {
+		c.endCommand(err)
+		return nil, err
+	}
+	if err := c.writer.Flush(); err != nil {
+		c.endCommand(err)
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// valueStream is the reader handed out by GetStream
+type valueStream struct {
+	r       io.Reader
+	raw     io.Reader // the payload as sent, when r decompresses it
+	c       *Client
+	trailer bool // a trailing \r\n still follows the payload
+	closed  bool
+}
+
+func (vs *valueStream) Read(p []byte) (int, error) {
+	if vs.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := vs.r.Read(p)
+	if err == io.EOF {
+		// Consume the payload's trailing \r\n, if it has one, once the value
+		// is exhausted
+		if derr := vs.drain(); derr != nil {
+			return n, derr
+		}
+	}
+	return n, err
+}
+
+// Close discards whatever the caller didn't read so the connection is
+// positioned at the next response
+func (vs *valueStream) Close() error {
+	if vs.closed {
+		return nil
+	}
+	vs.closed = true
+
+	if vs.raw == nil {
+		if _, err := io.Copy(io.Discard, vs.r); err != nil {
+			return err
+		}
+	}
+	return vs.drain()
+}
+
+// drain skips any payload bytes left on the connection and its trailing
+// \r\n
+func (vs *valueStream) drain() error {
+	if vs.raw != nil {
+		if _, err := io.Copy(io.Discard, vs.raw); err != nil {
+			vs.c.endCommand(err)
+			return err
+		}
+	}
+	if vs.trailer {
+		vs.trailer = false
+		if _, err := vs.c.reader.Discard(2); err != nil {
+			vs.c.endCommand(err)
+			return err
+		}
+	}
+	vs.c.endCommand(nil)
+	return nil
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Sync asks the server for a fresh snapshot and calls fn once per file, in
+// the order sent: the snapshot, the WALs to replay after it, and finally
+// MANIFEST.json. fn must consume r before returning; anything left unread
+// is discarded. SYNC is an admin command.
+func (c *Client) Sync(fn func(name string, size int64, r io.Reader) error) error {
+	if err := c.sendCommand("SYNC"); err != nil {
+		return err
+	}
+
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(line, "ERR ") {
+		return fmt.Errorf("%s", strings.TrimPrefix(line, "ERR "))
+	}
+
+	parts := strings.Fields(line)
+	if len(parts) != 2 || parts[0] != "SYNC" {
+		return fmt.Errorf("unexpected response: %s", line)
+	}
+	count, err := strconv.Atoi(parts[1])
+	if err != nil || count < 0 {
+		return fmt.Errorf("invalid SYNC header: %s", line)
+	}
+
+	for i := 0; i < count; i++ {
+		if err := c.readSyncFile(fn); err != nil {
+			return err
+		}
+	}
+
+	line, err = c.readLine()
+	if err != nil {
+		return err
+	}
+	if line != "END" {
+		return fmt.Errorf("expected END, got: %s", line)
+	}
+	return nil
+}
+
+// readSyncFile reads one FILE frame and hands its payload to fn
+func (c *Client) readSyncFile(fn func(name string, size int64, r io.Reader) error) error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+
+	parts := strings.Fields(line)
+	if len(parts) != 3 || parts[0] != "FILE" {
+		return fmt.Errorf("expected FILE, got: %s", line)
+	}
+	size, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid FILE header: %s", line)
+	}
+
+	body := io.LimitReader(c.reader, size)
+	if err := fn(parts[1], size, body); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return err
+	}
+
+	crlf := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, crlf); err != nil {
+		return err
+	}
+	if string(crlf) != "\r\n" {
+		return fmt.Errorf("missing CRLF after %s", parts[1])
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TenantUsage is one user's line of TENANT STATS
+type TenantUsage struct {
+	User      string
+	Namespace string
+	Keys      int64
+	Bytes     int64  // of keys and values in the namespace
+	Commands  uint64 // sent since the server started
+	OpsPerSec uint64 // commands in the last complete second
+}
+
+// TenantStats returns the usage of each configured user, sorted by name.
+// A connection authenticated as a user only gets its own.
+func (c *Client) TenantStats() ([]TenantUsage, error) {
+	if err := c.sendCommand("TENANT", "STATS"); err != nil {
+		return nil, err
+	}
+
+	var tenants []TenantUsage
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "END" {
+			return tenants, nil
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, fmt.Errorf("%s", strings.TrimPrefix(line, "ERR "))
+		}
+		tenants = append(tenants, parseTenantUsage(line))
+	}
+}
+
+// parseTenantUsage parses one TENANT STATS line of key=value fields
+func parseTenantUsage(line string) TenantUsage {
+	var u TenantUsage
+	for _, field := range strings.Fields(line) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "user":
+			u.User = value
+		case "namespace":
+			u.Namespace = value
+		case "keys":
+			u.Keys, _ = strconv.ParseInt(value, 10, 64)
+		case "bytes":
+			u.Bytes, _ = strconv.ParseInt(value, 10, 64)
+		case "commands":
+			u.Commands, _ = strconv.ParseUint(value, 10, 64)
+		case "ops_per_sec":
+			u.OpsPerSec, _ = strconv.ParseUint(value, 10, 64)
+		}
+	}
+	return u
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"strings"
+)
+
+// WithTracing asks the server to accept trace IDs (HELLO TRACE ON), so
+// the ID set with SetTraceID is attached to each command. Against servers
+// that don't support tracing, commands are sent without one.
+func WithTracing() Option {
+	return func(o *options) { o.trace = true }
+}
+
+// SetTraceID attaches id to every following command until it is changed,
+// so the server's slow log and CLIENT LIST can be matched to the
+// application request that sent them. An empty id stops attaching one.
+// IDs are printable ASCII without spaces, up to 128 bytes; other
+// characters become '_'.
+func (c *Client) SetTraceID(id string) {
+	c.traceID = sanitizeName(id)
+}
+
+// Tracing reports whether the active connection accepts trace IDs
+func (c *Client) Tracing() bool {
+	return c.tracing
+}
+
+// commandLine formats args as a command line, prefixed with the trace ID
+// when there is one and the server accepts it
+func (c *Client) commandLine(args []string) string {
+	line := strings.Join(args, " ") + "\r\n"
+	if c.tracing && c.traceID != "" {
+		line = "@" + c.traceID + " " + line
+	}
+	return line
+}
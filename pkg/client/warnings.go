@@ -0,0 +1,9 @@
+package client
+
+// WithWarnings asks the server to flag writes that cross a soft limit
+// (HELLO WARNINGS ON). The limits crossed are reported in
+// Response.Warnings, so an application can react before the server's hard
+// limits start refusing its writes.
+func WithWarnings() Option {
+	return func(o *options) { o.warnings = true }
+}
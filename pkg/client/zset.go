@@ -0,0 +1,92 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ZMember is a member of a sorted set and its score
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ZAdd adds members to the sorted set at key, or updates their scores,
+// creating it if needed. The response carries the sorted set's new
+// version, and the number of members added in Integer. A key holding
+// another type is an ERR TYPE.
+func (c *Client) ZAdd(key string, members ...ZMember) (*Response, error) {
+	args := []string{"ZADD", key}
+	for _, m := range members {
+		args = append(args, strconv.FormatFloat(m.Score, 'f', -1, 64), m.Member)
+	}
+	if err := c.sendCommand(args...); err != nil {
+		return nil, err
+	}
+
+	return c.readResponse()
+}
+
+// ZRem removes members from the sorted set at key and returns how many
+// were in it. The key is deleted with its last member.
+func (c *Client) ZRem(key string, members ...string) (int64, error) {
+	return c.setInteger(append([]string{"ZREM", key}, members...)...)
+}
+
+// ZRange returns the members of the sorted set at key ranked start to
+// stop, inclusive, lowest score first. Negative ranks count back from the
+// highest, so 0 and -1 return every member.
+func (c *Client) ZRange(key string, start, stop int64) ([]ZMember, error) {
+	return c.zMembers("ZRANGE", key, strconv.FormatInt(start, 10), strconv.FormatInt(stop, 10))
+}
+
+// ZRangeByScore returns the members of the sorted set at key scored min
+// to max, inclusive, lowest first. Pass math.Inf for an open end.
+func (c *Client) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	return c.zMembers("ZRANGEBYSCORE", key, formatScore(min), formatScore(max))
+}
+
+// ZRangeByScoreLimit is ZRangeByScore skipping the first offset members
+// and returning at most count, or every one past offset if count is
+// negative
+func (c *Client) ZRangeByScoreLimit(key string, min, max float64, offset, count int64) ([]ZMember, error) {
+	return c.zMembers("ZRANGEBYSCORE", key, formatScore(min), formatScore(max),
+		"LIMIT", strconv.FormatInt(offset, 10), strconv.FormatInt(count, 10))
+}
+
+// formatScore formats a ZRANGEBYSCORE bound, infinities included
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// zMembers sends a sorted set command that replies with MEMBER lines
+func (c *Client) zMembers(args ...string) ([]ZMember, error) {
+	if err := c.sendCommand(args...); err != nil {
+		return nil, err
+	}
+
+	var members []ZMember
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		parts := strings.Fields(line)
+		switch {
+		case line == "END":
+			return members, nil
+		case strings.HasPrefix(line, "ERR "):
+			return nil, fmt.Errorf("%s", line[len("ERR "):])
+		case len(parts) == 3 && parts[0] == "MEMBER":
+			score, err := strconv.ParseFloat(parts[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid score in %s response: %s", args[0], line)
+			}
+			members = append(members, ZMember{Member: parts[1], Score: score})
+		default:
+			return nil, fmt.Errorf("invalid %s response: %s", args[0], line)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Package command describes the built-in osprey commands: how many
+// arguments each takes, what the leading ones must look like and how the
+// server treats it. The server validates every command against this table
+// before running it, and clients can check commands the same way to report
+// a usage error without a round trip:
+//
+//	if err := command.Check([]string{"EXPIRE", "k", "soon"}); err != nil {
+//		fmt.Println(err) // EXPIRE argument 2 must be an integer
+//	}
+package command
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrWrongArgs is wrapped by the errors Check returns for too few or too
+// many arguments
+var ErrWrongArgs = errors.New("wrong number of arguments")
+
+// ArgType is what an argument must look like
+type ArgType int
+
+const (
+	Any   ArgType = iota // any token
+	Key                  // a key name
+	Int                  // a signed decimal integer
+	Uint                 // an unsigned decimal integer
+	Float                // a finite decimal number
+)
+
+// Flags describe how the server treats a command
+type Flags int
+
+const (
+	// Write marks commands that modify keys: they are paused during
+	// snapshots, count against write rate limits and are serialized per key
+	Write Flags = 1 << iota
+	// Payload marks commands whose command line is followed by binary data,
+	// so they can't be sent as a bare line
+	Payload
+	// Admin marks commands restricted to admin connections
+	Admin
+)
+
+// Spec describes one command
+type Spec struct {
+	Name    string
+	Summary string // one line, for help output
+	Usage   string // e.g. "GET <key> [EARLY]"
+	MinArgs int
+	MaxArgs int       // -1 for no limit
+	Args    []ArgType // types of the leading arguments; the rest are Any
+	Flags   Flags
+}
+
+// Has reports whether the command has all of flags
+func (s *Spec) Has(flags Flags) bool {
+	return s.Flags&flags == flags
+}
+
+// Check validates a command's arguments, not including its name
+func (s *Spec) Check(args []string) error {
+	if len(args) < s.MinArgs || (s.MaxArgs >= 0 && len(args) > s.MaxArgs) {
+		return fmt.Errorf("%w, usage: %s", ErrWrongArgs, s.Usage)
+	}
+	for i, t := range s.Args {
+		if i >= len(args) {
+			break
+		}
+		if err := checkArg(t, args[i]); err != "" {
+			return fmt.Errorf("%s argument %d must be %s", s.Name, i+1, err)
+		}
+	}
+	return nil
+}
+
+// checkArg returns what arg should have been, or "" if it's valid
+func checkArg(t ArgType, arg string) string {
+	switch t {
+	case Key:
+		if arg == "" {
+			return "a key"
+		}
+	case Int:
+		if _, err := strconv.ParseInt(arg, 10, 64); err != nil {
+			return "an integer"
+		}
+	case Uint:
+		if _, err := strconv.ParseUint(arg, 10, 64); err != nil {
+			return "a non-negative integer"
+		}
+	case Float:
+		if f, err := strconv.ParseFloat(arg, 64); err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return "a number"
+		}
+	}
+	return ""
+}
+
+var specs = []Spec{
+	{Name: "PING", Summary: "Check the server is alive", Usage: "PING", MaxArgs: 0},
+	{Name: "HELLO", Summary: "Negotiate connection options", Usage: "HELLO [PRIORITY <class>] [CHUNKSIZE <bytes>] [NAME <name>] [LIB <lib>] [COMPRESS <algos>] [COMPRESSMIN <bytes>] [TRACE ON|OFF] [WARNINGS ON|OFF]", MaxArgs: -1},
+	{Name: "AUTH", Summary: "Authenticate as admin or as a configured user", Usage: "AUTH [user] <password>", MinArgs: 1, MaxArgs: 2},
+	{Name: "AUTHCHALLENGE", Summary: "Get a nonce to authenticate with AUTHHMAC", Usage: "AUTHCHALLENGE", MaxArgs: 0},
+	{Name: "AUTHHMAC", Summary: "Authenticate with an HMAC of the AUTHCHALLENGE nonce", Usage: "AUTHHMAC [user] <mac>", MinArgs: 1, MaxArgs: 2},
+	{Name: "SHUTDOWN", Summary: "Shut the server down", Usage: "SHUTDOWN [NOSAVE|SAVE]", MaxArgs: 1, Flags: Admin},
+	{Name: "SYNC", Summary: "Stream a snapshot to seed another node", Usage: "SYNC", MaxArgs: 0, Flags: Admin},
+	{Name: "DIGEST", Summary: "Hash the keyspace, or the keys under a prefix, to compare nodes", Usage: "DIGEST [prefix]", MaxArgs: 1, Flags: Admin},
+	{Name: "EXPORTHOT", Summary: "Dump the most accessed keys as write commands", Usage: "EXPORTHOT <count>", MinArgs: 1, MaxArgs: 1, Args: []ArgType{Uint}, Flags: Admin},
+	{Name: "PRIMEFROM", Summary: "Warm an empty node with another node's most accessed keys", Usage: "PRIMEFROM <addr> <count>", MinArgs: 2, MaxArgs: 2, Args: []ArgType{Any, Uint}, Flags: Admin},
+	{Name: "WALROTATE", Summary: "Start a new WAL file", Usage: "WALROTATE", MaxArgs: 0, Flags: Admin},
+	{Name: "BGSAVE", Summary: "Write a snapshot in the background", Usage: "BGSAVE", MaxArgs: 0, Flags: Admin},
+	{Name: "READONLY", Summary: "Refuse or accept writes", Usage: "READONLY ON|OFF", MinArgs: 1, MaxArgs: 1, Flags: Admin},
+	{Name: "CONFIG", Summary: "Show settings or change one at runtime", Usage: "CONFIG GET <pattern> | CONFIG SET <name> <value>", MinArgs: 2, MaxArgs: 3, Flags: Admin},
+	{Name: "WALSYNC", Summary: "Fsync the current WAL file", Usage: "WALSYNC", MaxArgs: 0, Flags: Admin},
+	{Name: "GET", Summary: "Retrieve a value", Usage: "GET <key> [EARLY]", MinArgs: 1, MaxArgs: 2, Args: []ArgType{Key}},
+	{Name: "GETMETA", Summary: "Show a key's metadata without its value", Usage: "GETMETA <key>", MinArgs: 1, MaxArgs: 1, Args: []ArgType{Key}},
+	{Name: "SET", Summary: "Store a value", Usage: "SET <key> <len> [EX <ms>|PXAT <ms>] [NX|XX] [VER <version>] [IDEMP <token>] [NOSYNC]", MinArgs: 2, MaxArgs: -1, Args: []ArgType{Key, Uint}, Flags: Write | Payload},
+	{Name: "APPEND", Summary: "Append to a value", Usage: "APPEND <key> <len>", MinArgs: 2, MaxArgs: 2, Args: []ArgType{Key, Uint}, Flags: Write | Payload},
+	{Name: "GETRANGE", Summary: "Retrieve part of a value", Usage: "GETRANGE <key> <start> <end>", MinArgs: 3, MaxArgs: 3, Args: []ArgType{Key, Int, Int}},
+	{Name: "SETRANGE", Summary: "Overwrite part of a value", Usage: "SETRANGE <key> <offset> <len>", MinArgs: 3, MaxArgs: 3, Args: []ArgType{Key, Uint, Uint}, Flags: Write | Payload},
+	{Name: "CAS", Summary: "Replace a value if it equals an expected value", Usage: "CAS <key> <expected_len> <new_len>", MinArgs: 3, MaxArgs: 3, Args: []ArgType{Key, Uint, Uint}, Flags: Write | Payload},
+	{Name: "DEL", Summary: "Delete a key", Usage: "DEL <key>", MinArgs: 1, MaxArgs: 1, Args: []ArgType{Key}, Flags: Write},
+	{Name: "GETDEL", Summary: "Retrieve a value and delete its key", Usage: "GETDEL <key>", MinArgs: 1, MaxArgs: 1, Args: []ArgType{Key}, Flags: Write},
+	{Name: "GETEX", Summary: "Retrieve a value and set or remove its TTL", Usage: "GETEX <key> [EX <ms>|PERSIST]", MinArgs: 1, MaxArgs: 3, Args: []ArgType{Key}, Flags: Write},
+	{Name: "EXISTS", Summary: "Check whether a key exists", Usage: "EXISTS <key>", MinArgs: 1, MaxArgs: 1, Args: []ArgType{Key}},
+	{Name: "EXPIRE", Summary: "Set a key's TTL", Usage: "EXPIRE <key> <ms>", MinArgs: 2, MaxArgs: 2, Args: []ArgType{Key, Int}, Flags: Write},
+	{Name: "TOUCH", Summary: "Record an access to keys without reading them", Usage: "TOUCH <key> [key...]", MinArgs: 1, MaxArgs: -1, Args: []ArgType{Key}, Flags: Write},
+	{Name: "TTL", Summary: "Show a key's remaining TTL", Usage: "TTL <key>", MinArgs: 1, MaxArgs: 1, Args: []ArgType{Key}},
+	{Name: "INCR", Summary: "Increment an integer value", Usage: "INCR <key> [delta]", MinArgs: 1, MaxArgs: 2, Args: []ArgType{Key, Int}, Flags: Write},
+	{Name: "DECR", Summary: "Decrement an integer value", Usage: "DECR <key> [delta]", MinArgs: 1, MaxArgs: 2, Args: []ArgType{Key, Int}, Flags: Write},
+	{Name: "INCRBYFLOAT", Summary: "Add to a floating-point value", Usage: "INCRBYFLOAT <key> <delta>", MinArgs: 2, MaxArgs: 2, Args: []ArgType{Key, Float}, Flags: Write},
+	{Name: "HSET", Summary: "Set fields of a hash", Usage: "HSET <key> <field> <len> [field len...]", MinArgs: 3, MaxArgs: -1, Args: []ArgType{Key, Any, Uint}, Flags: Write | Payload},
+	{Name: "HGET", Summary: "Retrieve a field of a hash", Usage: "HGET <key> <field>", MinArgs: 2, MaxArgs: 2, Args: []ArgType{Key}},
+	{Name: "HDEL", Summary: "Delete fields of a hash", Usage: "HDEL <key> <field> [field...]", MinArgs: 2, MaxArgs: -1, Args: []ArgType{Key}, Flags: Write},
+	{Name: "HGETALL", Summary: "Retrieve every field of a hash", Usage: "HGETALL <key>", MinArgs: 1, MaxArgs: 1, Args: []ArgType{Key}},
+	{Name: "HMGET", Summary: "Retrieve several fields of a hash", Usage: "HMGET <key> <field> [field...]", MinArgs: 2, MaxArgs: -1, Args: []ArgType{Key}},
+	{Name: "SADD", Summary: "Add members to a set", Usage: "SADD <key> <member> [member...]", MinArgs: 2, MaxArgs: -1, Args: []ArgType{Key}, Flags: Write},
+	{Name: "SREM", Summary: "Remove members from a set", Usage: "SREM <key> <member> [member...]", MinArgs: 2, MaxArgs: -1, Args: []ArgType{Key}, Flags: Write},
+	{Name: "SMEMBERS", Summary: "List the members of a set", Usage: "SMEMBERS <key>", MinArgs: 1, MaxArgs: 1, Args: []ArgType{Key}},
+	{Name: "SISMEMBER", Summary: "Check whether a value is in a set", Usage: "SISMEMBER <key> <member>", MinArgs: 2, MaxArgs: 2, Args: []ArgType{Key}},
+	{Name: "SCARD", Summary: "Count the members of a set", Usage: "SCARD <key>", MinArgs: 1, MaxArgs: 1, Args: []ArgType{Key}},
+	{Name: "ZADD", Summary: "Add members to a sorted set or update their scores", Usage: "ZADD <key> <score> <member> [<score> <member>...]", MinArgs: 3, MaxArgs: -1, Args: []ArgType{Key, Float}, Flags: Write},
+	{Name: "ZREM", Summary: "Remove members from a sorted set", Usage: "ZREM <key> <member> [member...]", MinArgs: 2, MaxArgs: -1, Args: []ArgType{Key}, Flags: Write},
+	{Name: "ZRANGE", Summary: "List the members of a sorted set by rank", Usage: "ZRANGE <key> <start> <stop>", MinArgs: 3, MaxArgs: 3, Args: []ArgType{Key, Int, Int}},
+	{Name: "ZRANGEBYSCORE", Summary: "List the members of a sorted set by score", Usage: "ZRANGEBYSCORE <key> <min> <max> [LIMIT <offset> <count>]", MinArgs: 3, MaxArgs: 6, Args: []ArgType{Key}},
+	{Name: "PFADD", Summary: "Add elements to a HyperLogLog sketch", Usage: "PFADD <key> [element...]", MinArgs: 1, MaxArgs: -1, Args: []ArgType{Key}, Flags: Write},
+	{Name: "PFCOUNT", Summary: "Estimate the distinct elements added to HyperLogLog sketches", Usage: "PFCOUNT <key> [key...]", MinArgs: 1, MaxArgs: -1, Args: []ArgType{Key}},
+	{Name: "PFMERGE", Summary: "Merge HyperLogLog sketches into one", Usage: "PFMERGE <dest> <source> [source...]", MinArgs: 2, MaxArgs: -1, Args: []ArgType{Key}, Flags: Write},
+	{Name: "STATS", Summary: "Show server statistics", Usage: "STATS [RESET|VERBOSE]", MaxArgs: 1},
+	{Name: "MGET", Summary: "Retrieve several values", Usage: "MGET <key> [key...]", MinArgs: 1, MaxArgs: -1},
+	{Name: "MEXISTS", Summary: "Check which of several keys exist", Usage: "MEXISTS <key> [key...]", MinArgs: 1, MaxArgs: -1, Args: []ArgType{Key}},
+	{Name: "MDEL", Summary: "Delete several keys", Usage: "MDEL <key> [key...]", MinArgs: 1, MaxArgs: -1, Args: []ArgType{Key}, Flags: Write},
+	{Name: "MSET", Summary: "Store several values", Usage: "MSET <key> <len> [EX <ms>] [NX] [key len...]", MinArgs: 2, MaxArgs: -1, Args: []ArgType{Key, Uint}, Flags: Write | Payload},
+	{Name: "CHECKSET", Summary: "Store values if other keys are at given versions", Usage: "CHECKSET <n> [key version...] <key> <len> [key len...]", MinArgs: 1, MaxArgs: -1, Args: []ArgType{Uint}, Flags: Write | Payload},
+	{Name: "SWAPPREFIX", Summary: "Swap the keys under two prefixes", Usage: "SWAPPREFIX <prefix> <prefix>", MinArgs: 2, MaxArgs: 2, Flags: Write},
+	{Name: "KEYTEMP", Summary: "Show how recently keys were accessed", Usage: "KEYTEMP [samples]", MaxArgs: 1, Args: []ArgType{Uint}},
+	{Name: "KEYS", Summary: "List keys matching a pattern", Usage: "KEYS <pattern>", MinArgs: 1, MaxArgs: 1},
+	{Name: "DBSIZE", Summary: "Count the live keys", Usage: "DBSIZE", MaxArgs: 0},
+	{Name: "RANDOMKEY", Summary: "Pick a random key", Usage: "RANDOMKEY", MaxArgs: 0},
+	{Name: "SCAN", Summary: "Iterate over keys", Usage: "SCAN <cursor> [MATCH <pattern>] [COUNT <n>]", MinArgs: 1, MaxArgs: 5},
+	{Name: "LATENCY", Summary: "Show or reset latency spikes", Usage: "LATENCY LATEST | LATENCY HISTORY <event> | LATENCY RESET [event...]", MinArgs: 1, MaxArgs: -1},
+	{Name: "INFO", Summary: "Show server information by section", Usage: "INFO [section]", MaxArgs: 1},
+	{Name: "CLIENT", Summary: "Name, list or close connections", Usage: "CLIENT SETNAME <name> | CLIENT GETNAME | CLIENT LIST | CLIENT KILL <id>", MinArgs: 1, MaxArgs: -1},
+	{Name: "TENANT", Summary: "Show keys, bytes and commands per user", Usage: "TENANT STATS", MinArgs: 1, MaxArgs: 1},
+	{Name: "EVENTS", Summary: "Read or acknowledge key events", Usage: "EVENTS READ <after_seq> [count] | EVENTS ACK <seq>", MinArgs: 1, MaxArgs: -1},
+	{Name: "SUBSCRIBE", Summary: "Receive the messages published to channels", Usage: "SUBSCRIBE <channel> [channel...]", MinArgs: 1, MaxArgs: -1},
+	{Name: "UNSUBSCRIBE", Summary: "Stop receiving messages from channels, or from all of them", Usage: "UNSUBSCRIBE [channel...]", MaxArgs: -1},
+	{Name: "PUBLISH", Summary: "Send a message to a channel's subscribers", Usage: "PUBLISH <channel> <len>", MinArgs: 2, MaxArgs: 2, Args: []ArgType{Key, Uint}, Flags: Payload},
+}
+
+var byName = func() map[string]*Spec {
+	m := make(map[string]*Spec, len(specs))
+	for i := range specs {
+		m[specs[i].Name] = &specs[i]
+	}
+	return m
+}()
+
+// Lookup returns the spec of a built-in command, matched case-insensitively
+func Lookup(name string) (*Spec, bool) {
+	s, ok := byName[strings.ToUpper(name)]
+	return s, ok
+}
+
+// Specs returns every built-in command, sorted by name
+func Specs() []Spec {
+	out := make([]Spec, len(specs))
+	copy(out, specs)
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Check validates a command given as its name followed by its arguments.
+// Commands not in the table, such as those added by extensions, pass.
+func Check(args []string) error {
+	if len(args) == 0 {
+		return errors.New("empty command")
+	}
+	s, ok := Lookup(args[0])
+	if !ok {
+		return nil
+	}
+	return s.Check(args[1:])
+}
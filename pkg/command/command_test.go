@@ -0,0 +1,71 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+	spec, ok := Lookup("get")
+	require.True(t, ok)
+	assert.Equal(t, "GET", spec.Name)
+	assert.False(t, spec.Has(Write))
+
+	spec, ok = Lookup("SET")
+	require.True(t, ok)
+	assert.True(t, spec.Has(Write|Payload))
+	assert.False(t, spec.Has(Admin))
+
+	_, ok = Lookup("ACME.TOUCH")
+	assert.False(t, ok)
+}
+
+func TestSpecs(t *testing.T) {
+	specs := Specs()
+	require.NotEmpty(t, specs)
+	for i, spec := range specs {
+		if i > 0 {
+			assert.Less(t, specs[i-1].Name, spec.Name)
+		}
+		assert.NotEmpty(t, spec.Summary, "summary of %s", spec.Name)
+		assert.True(t, strings.HasPrefix(spec.Usage, spec.Name), "usage of %s", spec.Name)
+		assert.True(t, spec.MaxArgs < 0 || spec.MaxArgs >= spec.MinArgs, "arity of %s", spec.Name)
+		assert.True(t, spec.MaxArgs < 0 || len(spec.Args) <= spec.MaxArgs, "arg types of %s", spec.Name)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"PING"}, ""},
+		{[]string{"ping", "x"}, "wrong number of arguments, usage: PING"},
+		{[]string{"GET", "k"}, ""},
+		{[]string{"GET", "k", "EARLY"}, ""},
+		{[]string{"GET"}, "wrong number of arguments, usage: GET <key> [EARLY]"},
+		{[]string{"GET", "a", "b", "c"}, "wrong number of arguments"},
+		{[]string{"SET", "k", "5", "EX", "100", "NX"}, ""},
+		{[]string{"SET", "k", "-5"}, "SET argument 2 must be a non-negative integer"},
+		{[]string{"EXPIRE", "k", "-1"}, ""},
+		{[]string{"EXPIRE", "k", "soon"}, "EXPIRE argument 2 must be an integer"},
+		{[]string{"INCR", "k"}, ""},
+		{[]string{"INCR", "k", "1.5"}, "INCR argument 2 must be an integer"},
+		{[]string{"MGET", "a", "b", "c", "d"}, ""},
+		{[]string{"ACME.TOUCH", "any", "thing"}, ""},
+		{nil, "empty command"},
+	}
+
+	for _, tt := range tests {
+		err := Check(tt.args)
+		if tt.err == "" {
+			assert.NoError(t, err, "%v", tt.args)
+			continue
+		}
+		require.Error(t, err, "%v", tt.args)
+		assert.Contains(t, err.Error(), tt.err)
+	}
+}
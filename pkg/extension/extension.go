@@ -0,0 +1,129 @@
+// Package extension adds commands and command interceptors to the osprey
+// server without forking it. Extensions register themselves from an init
+// function and are linked in at build time, by blank-importing their
+// package from a copy of cmd/osprey:
+//
+//	import _ "example.com/acme/ospreyext"
+//
+// Registration must finish before the server starts; the registry is not
+// safe to change while commands are running.
+package extension
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Store.Get for a missing or expired key
+var ErrNotFound = errors.New("key not found")
+
+// Request is a command as received from a client
+type Request struct {
+	Name    string // upper case
+	Args    []string
+	Payload []byte // for commands sent with a payload, such as SET
+	TraceID string // from an @<id> prefix, "" if there was none
+	Client  string // the connection's HELLO NAME, "" if it never set one
+}
+
+// Store is the part of the key-value store extension commands can use.
+// Writes are logged to the WAL like the built-in commands'. Keys starting
+// with __osprey__: hold server metadata and can't be written.
+type Store interface {
+	// Get returns a key's value and version, or ErrNotFound
+	Get(key string) (value []byte, version uint64, err error)
+	// Set stores a value, expiring after ttl unless ttl is 0, and
+	// returns its new version
+	Set(key string, value []byte, ttl time.Duration) (version uint64, err error)
+	// Delete removes a key and reports whether it existed
+	Delete(key string) bool
+}
+
+// Reply writes a command's response. Exactly one method should be called.
+type Reply interface {
+	OK()                        // OK
+	Error(code, message string) // ERR <code> <message>
+	Integer(n int64)            // INTEGER <n>
+	Value(v []byte)             // VALUE <len> 0 0, then the value
+	NotFound()                  // NOT_FOUND
+}
+
+// Handler runs a registered command
+type Handler func(store Store, req *Request, reply Reply)
+
+// Command describes a registered command
+type Command struct {
+	Name    string // matched case-insensitively; may not be a built-in command
+	Handler Handler
+
+	// Write marks commands that modify the key named by their first
+	// argument, so they are paused during snapshots, count against write
+	// rate limits and are serialized per key like SET
+	Write bool
+
+	// Admin restricts the command to admin connections, like SHUTDOWN
+	Admin bool
+}
+
+// Error rejects a request from an interceptor with ERR <Code> <Message>
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Code + " " + e.Message
+}
+
+// Interceptor runs before every command, built-in or registered. It may
+// rewrite the request's Args and Payload, or reject it by returning an
+// error; errors other than *Error are reported as BADREQ.
+type Interceptor func(req *Request) error
+
+var (
+	commands     = make(map[string]Command)
+	interceptors []Interceptor
+)
+
+// Register adds a command. It panics if the command has no name or
+// handler, or if a command with the same name is already registered.
+func Register(cmd Command) {
+	name := strings.ToUpper(cmd.Name)
+	if name == "" || strings.ContainsAny(name, " \t\r\n") || cmd.Handler == nil {
+		panic(fmt.Sprintf("extension: invalid command %q", cmd.Name))
+	}
+	if _, dup := commands[name]; dup {
+		panic(fmt.Sprintf("extension: command %s registered twice", name))
+	}
+	cmd.Name = name
+	commands[name] = cmd
+}
+
+// Intercept adds an interceptor. Interceptors run in the order they were
+// added, and the first to reject a request stops it.
+func Intercept(i Interceptor) {
+	interceptors = append(interceptors, i)
+}
+
+// Lookup returns the registered command with the given upper-case name
+func Lookup(name string) (Command, bool) {
+	cmd, ok := commands[name]
+	return cmd, ok
+}
+
+// Intercepting reports whether any interceptors are registered
+func Intercepting() bool {
+	return len(interceptors) > 0
+}
+
+// Run passes req through every interceptor
+func Run(req *Request) error {
+	for _, i := range interceptors {
+		if err := i(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
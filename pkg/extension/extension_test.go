@@ -0,0 +1,69 @@
+package extension
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reset clears the registry around a test
+func reset(t *testing.T) {
+	saved, savedInterceptors := commands, interceptors
+	commands, interceptors = make(map[string]Command), nil
+	t.Cleanup(func() { commands, interceptors = saved, savedInterceptors })
+}
+
+func TestRegister(t *testing.T) {
+	reset(t)
+	handler := func(Store, *Request, Reply) {}
+
+	Register(Command{Name: "acme.touch", Handler: handler, Write: true})
+	cmd, ok := Lookup("ACME.TOUCH")
+	require.True(t, ok)
+	assert.Equal(t, "ACME.TOUCH", cmd.Name)
+	assert.True(t, cmd.Write)
+
+	_, ok = Lookup("acme.touch")
+	assert.False(t, ok, "lookups use the upper-case name")
+
+	assert.Panics(t, func() { Register(Command{Name: "ACME.TOUCH", Handler: handler}) })
+	assert.Panics(t, func() { Register(Command{Name: "", Handler: handler}) })
+	assert.Panics(t, func() { Register(Command{Name: "acme touch", Handler: handler}) })
+	assert.Panics(t, func() { Register(Command{Name: "acme.nohandler"}) })
+}
+
+func TestIntercept(t *testing.T) {
+	reset(t)
+	assert.False(t, Intercepting())
+
+	var order []string
+	Intercept(func(req *Request) error {
+		order = append(order, "prefix")
+		for i, arg := range req.Args {
+			req.Args[i] = "k:" + arg
+		}
+		return nil
+	})
+	Intercept(func(req *Request) error {
+		order = append(order, "deny")
+		if req.Args[0] == "k:secret" {
+			return &Error{Code: "DENIED", Message: "no"}
+		}
+		return nil
+	})
+	Intercept(func(req *Request) error {
+		order = append(order, "last")
+		return errors.New("unreachable")
+	})
+	require.True(t, Intercepting())
+
+	req := &Request{Name: "GET", Args: []string{"secret"}}
+	err := Run(req)
+	var rejected *Error
+	require.ErrorAs(t, err, &rejected)
+	assert.Equal(t, "DENIED", rejected.Code)
+	assert.Equal(t, []string{"k:secret"}, req.Args)
+	assert.Equal(t, []string{"prefix", "deny"}, order)
+}
@@ -0,0 +1,141 @@
+// Package ospreytest runs an osprey server inside a Go test, so that
+// applications can be tested against the real server rather than a mock:
+//
+//	func TestCache(t *testing.T) {
+//		srv := ospreytest.StartServer(t, ospreytest.Options{})
+//		c := srv.Client(t)
+//		...
+//	}
+//
+// Each server listens on a random loopback port, keeps its data in a
+// temporary directory and sweeps expired keys every 50ms, so tests of TTLs
+// don't wait on the default interval. It is shut down, and its directory
+// removed, when the test ends.
+package ospreytest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/server"
+	"github.com/bharatmehan/osprey/pkg/client"
+)
+
+// Options configures a test server
+type Options struct {
+	// DataDir is the server's data directory. If empty, a temporary
+	// directory is used and removed when the test ends.
+	DataDir string
+
+	// Config is TOML in the format of osprey.toml, applied over the test
+	// defaults, e.g. "max_value_bytes = 1024"
+	Config string
+
+	// Configure changes the configuration once Config is applied. It is
+	// only usable from within this module, as config is internal.
+	Configure func(*config.Config)
+}
+
+// TestServer is a server started by StartServer
+type TestServer struct {
+	Server  *server.Server
+	Address string // host:port the server listens on
+	DataDir string
+	Config  *config.Config
+
+	tb       testing.TB
+	done     chan error // receives Start's result
+	shutdown sync.Once
+}
+
+// StartServer starts a server configured by opts and waits until it
+// accepts connections, failing the test if it can't start. The server is
+// shut down when the test ends.
+func StartServer(tb testing.TB, opts Options) *TestServer {
+	tb.Helper()
+
+	cfg := config.DefaultConfig()
+	cfg.DataDir = opts.DataDir
+	if cfg.DataDir == "" {
+		cfg.DataDir = tb.TempDir()
+	}
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.SweepIntervalMs = 50
+	if opts.Config != "" {
+		if _, err := toml.Decode(opts.Config, cfg); err != nil {
+			tb.Fatalf("ospreytest: config: %v", err)
+		}
+	}
+	if opts.Configure != nil {
+		opts.Configure(cfg)
+	}
+
+	ts := &TestServer{DataDir: cfg.DataDir, Config: cfg, tb: tb}
+	ts.start()
+	tb.Cleanup(ts.Shutdown)
+	return ts
+}
+
+// start starts a server with ts.Config
+func (ts *TestServer) start() {
+	ts.tb.Helper()
+
+	srv, err := server.New(ts.Config)
+	if err != nil {
+		ts.tb.Fatalf("ospreytest: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Start()
+	}()
+	select {
+	case <-srv.Ready():
+	case err := <-done:
+		srv.Shutdown()
+		ts.tb.Fatalf("ospreytest: server did not start: %v", err)
+	}
+
+	ts.Server = srv
+	ts.Address = srv.GetAddress()
+	ts.done = done
+	ts.shutdown = sync.Once{}
+}
+
+// Shutdown shuts the server down and waits for it to stop. It may be
+// called before the test ends, to test how clients handle a server going
+// away, and more than once.
+func (ts *TestServer) Shutdown() {
+	ts.shutdown.Do(func() {
+		if err := ts.Server.Shutdown(); err != nil {
+			ts.tb.Errorf("ospreytest: shutdown: %v", err)
+		}
+		<-ts.done
+	})
+}
+
+// Restart shuts the server down and starts a new one on the same address
+// and data directory, which recovers what the old one wrote. Clients
+// reconnect on their next command.
+func (ts *TestServer) Restart() {
+	ts.tb.Helper()
+
+	ts.Shutdown()
+	ts.Config.ListenAddr = ts.Address
+	ts.start()
+}
+
+// Client returns a client connected to the server, closed when the test
+// ends
+func (ts *TestServer) Client(tb testing.TB, opts ...client.Option) *client.Client {
+	tb.Helper()
+
+	c, err := client.New(ts.Address, opts...)
+	if err != nil {
+		tb.Fatalf("ospreytest: %v", err)
+	}
+	tb.Cleanup(func() { c.Close() })
+	return c
+}
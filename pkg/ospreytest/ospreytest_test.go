@@ -0,0 +1,39 @@
+package ospreytest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartServer(t *testing.T) {
+	srv := StartServer(t, Options{Config: "max_value_bytes = 8"})
+	assert.Equal(t, 8, srv.Config.MaxValueBytes)
+	assert.Equal(t, 50, srv.Config.SweepIntervalMs)
+
+	c := srv.Client(t)
+	resp, err := c.Set("k", []byte("v"))
+	require.NoError(t, err)
+	assert.True(t, resp.Success)
+	resp, err = c.Set("big", []byte(strings.Repeat("x", 9)))
+	require.NoError(t, err)
+	assert.False(t, resp.Success)
+
+	// The restarted server recovers the data on the same address
+	address := srv.Address
+	srv.Restart()
+	assert.Equal(t, address, srv.Address)
+	resp, err = c.Get("k")
+	if err != nil {
+		// The command that found the old connection closed
+		resp, err = c.Get("k")
+	}
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v"), resp.Value)
+
+	// Shutting down early leaves nothing for the cleanup to do
+	srv.Shutdown()
+	srv.Shutdown()
+}
@@ -0,0 +1,400 @@
+// Package waltail follows the write-ahead log of a running osprey server
+// and delivers its records to Go applications, for building indexes or
+// other pipelines fed by every write. It reads the WAL files in the
+// server's data directory (or wal_dir, if set), so it must run on the same
+// host, and never blocks the server:
+//
+//	t, err := waltail.Open("/var/lib/osprey", waltail.Options{})
+//	...
+//	for {
+//		rec, err := t.Next(ctx)
+//		if err != nil {
+//			return err
+//		}
+//		index(rec)
+//	}
+//
+// Snapshots delete WAL files, so a consumer that falls too far behind, or
+// resumes from a deleted file, gets ErrGap and must resync from the keys
+// themselves.
+package waltail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/storage"
+)
+
+// ErrGap is returned when WAL files were deleted before they were read
+var ErrGap = errors.New("WAL files deleted before they were read")
+
+// Record types
+const (
+	TypeSet      = "SET"
+	TypeDel      = "DEL"
+	TypeExpire   = "EXPIRE"
+	TypeIdemp    = "IDEMP"    // Key is an IDEMP token, Value the key it set
+	TypeBatch    = "BATCH"    // Records holds SETs applied together
+	TypeSwap     = "SWAP"     // Key and Value are the prefixes swapped
+	TypeEvent    = "EVENT"    // Value is the event type, Version its sequence number
+	TypeEventAck = "EVENTACK" // events up to Version were acknowledged
+	TypeHSet     = "HSET"     // Fields holds the hash fields set
+	TypeHDel     = "HDEL"     // Fields holds the hash fields deleted, without values
+	TypeSAdd     = "SADD"     // Members holds the set members added
+	TypeSRem     = "SREM"     // Members holds the set members removed
+	TypeZAdd     = "ZADD"     // Scored holds the sorted set members and scores set
+	TypeZRem     = "ZREM"     // Members holds the sorted set members removed
+)
+
+var typeNames = map[uint8]string{
+	storage.RecordTypeSET:      TypeSet,
+	storage.RecordTypeDEL:      TypeDel,
+	storage.RecordTypeEXPIRE:   TypeExpire,
+	storage.RecordTypeIDEMP:    TypeIdemp,
+	storage.RecordTypeBATCH:    TypeBatch,
+	storage.RecordTypeSWAP:     TypeSwap,
+	storage.RecordTypeEVENT:    TypeEvent,
+	storage.RecordTypeEVENTACK: TypeEventAck,
+	storage.RecordTypeHSET:     TypeHSet,
+	storage.RecordTypeHDEL:     TypeHDel,
+	storage.RecordTypeSADD:     TypeSAdd,
+	storage.RecordTypeSREM:     TypeSRem,
+	storage.RecordTypeZADD:     TypeZAdd,
+	storage.RecordTypeZREM:     TypeZRem,
+}
+
+// Record is a decoded WAL record
+type Record struct {
+	Type      string
+	Key       string
+	Value     []byte
+	ExpiryMs  int64 // unix ms, -1 for none
+	Version   uint64
+	CreatedMs int64
+	UpdatedMs int64
+	Records   []Record // for BATCH
+	Fields    []Field  // for HSET and HDEL
+	Members   []string // for SADD, SREM and ZREM
+	Scored    []Scored // for ZADD
+
+	Position Position // just past the record, to resume from
+}
+
+// Scored is a sorted set member of a ZADD record and its score
+type Scored struct {
+	Member string
+	Score  float64
+}
+
+// Field is a hash field of an HSET or HDEL record
+type Field struct {
+	Name  string
+	Value []byte
+}
+
+// Position is a place in the WAL
+type Position struct {
+	File   string // WAL file name, such as wal-00000003.oswal
+	Offset int64
+}
+
+// Options configures a Tailer
+type Options struct {
+	// From resumes after a record's Position. The zero value starts at
+	// the oldest WAL file.
+	From Position
+	// PollInterval is how often to check for new records once caught up.
+	// Defaults to 100ms.
+	PollInterval time.Duration
+}
+
+// Tailer reads the WAL files in a directory in order, waiting for new
+// records at the end. It is not safe for concurrent use.
+type Tailer struct {
+	dir  string
+	opts Options
+
+	file   *os.File
+	name   string
+	index  int
+	offset int64
+}
+
+// Open starts tailing the WAL files in dir
+func Open(dir string, opts Options) (*Tailer, error) {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 100 * time.Millisecond
+	}
+	t := &Tailer{dir: dir, opts: opts}
+
+	if opts.From.File != "" {
+		if err := t.open(opts.From.File); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("%w: %s", ErrGap, opts.From.File)
+			}
+			return nil, err
+		}
+		if _, err := t.file.Seek(opts.From.Offset, io.SeekStart); err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.offset = opts.From.Offset
+		return t, nil
+	}
+
+	names, err := walFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		if err := t.open(names[0]); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Next returns the next record, waiting for one to be written if needed,
+// until ctx is done
+func (t *Tailer) Next(ctx context.Context) (*Record, error) {
+	for {
+		if rec, err := t.read(); rec != nil || err != nil {
+			return rec, err
+		}
+
+		next, err := t.nextFile()
+		if err != nil {
+			return nil, err
+		}
+		if next == "" {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(t.opts.PollInterval):
+			}
+			continue
+		}
+
+		// The server only rotates between records, so once a newer file
+		// exists this one is complete after a last read. Anything left is
+		// a torn write, which the server's recovery skips too.
+		if rec, err := t.read(); rec != nil || err != nil {
+			return rec, err
+		}
+		if err := t.advance(next); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Position returns the position after the last record returned
+func (t *Tailer) Position() Position {
+	return Position{File: t.name, Offset: t.offset}
+}
+
+// Close closes the current WAL file
+func (t *Tailer) Close() error {
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+// read reads the record at the current offset, returning nil at the end
+// of the file. A record still being written is read again later.
+func (t *Tailer) read() (*Record, error) {
+	if t.file == nil {
+		return nil, nil
+	}
+
+	counter := &countingReader{r: t.file}
+	raw, err := storage.NewWALReader(counter).ReadRecord()
+	if err != nil {
+		if _, serr := t.file.Seek(t.offset, io.SeekStart); serr != nil {
+			return nil, serr
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s at offset %d: %w", t.name, t.offset, err)
+	}
+	t.offset += counter.n
+
+	rec, err := decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s at offset %d: %w", t.name, t.offset, err)
+	}
+	rec.Position = t.Position()
+	return rec, nil
+}
+
+// nextFile returns the WAL file after the current one, "" if there is none
+// yet
+func (t *Tailer) nextFile() (string, error) {
+	names, err := walFiles(t.dir)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if name > t.name {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// advance moves to the WAL file name, which must directly follow the
+// current one
+func (t *Tailer) advance(name string) error {
+	index, err := walIndex(name)
+	if err != nil {
+		return err
+	}
+	if t.file != nil && index != t.index+1 {
+		return fmt.Errorf("%w: after %s", ErrGap, t.name)
+	}
+
+	t.Close()
+	if err := t.open(name); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrGap, name)
+		}
+		return err
+	}
+	return nil
+}
+
+// open opens the WAL file name at offset 0
+func (t *Tailer) open(name string) error {
+	index, err := walIndex(name)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filepath.Join(t.dir, name))
+	if err != nil {
+		return err
+	}
+	t.file, t.name, t.index, t.offset = file, name, index, 0
+	return nil
+}
+
+// decode converts a storage record, unpacking BATCH records, the fields of
+// HSET and HDEL records and the members of SADD, SREM, ZADD and ZREM
+// records
+func decode(raw *storage.WALRecord) (*Record, error) {
+	typ, ok := typeNames[raw.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown record type %d", raw.Type)
+	}
+	rec := &Record{
+		Type:      typ,
+		Key:       raw.Key,
+		Value:     raw.Value,
+		ExpiryMs:  raw.ExpiryMs,
+		Version:   raw.Version,
+		CreatedMs: raw.CreatedMs,
+		UpdatedMs: raw.UpdatedMs,
+	}
+	if raw.Type == storage.RecordTypeHSET || raw.Type == storage.RecordTypeHDEL {
+		fields, err := storage.DecodeHashFields(raw.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", typ, err)
+		}
+		for _, f := range fields {
+			rec.Fields = append(rec.Fields, Field{Name: f.Name, Value: f.Value})
+		}
+		rec.Value = nil
+		return rec, nil
+	}
+	if raw.Type == storage.RecordTypeZADD {
+		members, err := storage.DecodeZMembers(raw.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", typ, err)
+		}
+		for _, m := range members {
+			rec.Scored = append(rec.Scored, Scored{Member: m.Member, Score: m.Score})
+		}
+		rec.Value = nil
+		return rec, nil
+	}
+	if raw.Type == storage.RecordTypeSADD || raw.Type == storage.RecordTypeSREM || raw.Type == storage.RecordTypeZREM {
+		members, err := storage.DecodeMembers(raw.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", typ, err)
+		}
+		rec.Members = members
+		rec.Value = nil
+		return rec, nil
+	}
+	if raw.Type != storage.RecordTypeBATCH {
+		return rec, nil
+	}
+
+	reader := storage.NewWALReader(bytes.NewReader(raw.Value))
+	for {
+		r, err := reader.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("batch: %w", err)
+		}
+		sub, err := decode(r)
+		if err != nil {
+			return nil, fmt.Errorf("batch: %w", err)
+		}
+		rec.Records = append(rec.Records, *sub)
+	}
+	rec.Value = nil
+	return rec, nil
+}
+
+// walFiles lists the WAL files in dir in order
+func walFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "wal-") && strings.HasSuffix(entry.Name(), ".oswal") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// walIndex extracts the index from a WAL file name
+func walIndex(name string) (int, error) {
+	index, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "wal-"), ".oswal"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid WAL filename: %s", name)
+	}
+	return index, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
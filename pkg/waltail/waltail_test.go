@@ -0,0 +1,109 @@
+package waltail
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bharatmehan/osprey/internal/config"
+	"github.com/bharatmehan/osprey/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTailer(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+
+	ps, err := storage.NewPersistentStore(cfg)
+	require.NoError(t, err)
+	defer ps.Close()
+
+	_, err = ps.Set("a", []byte("1"), storage.SetOptions{})
+	require.NoError(t, err)
+	ps.Delete("a")
+
+	tailer, err := Open(cfg.WALDirectory(), Options{PollInterval: 5 * time.Millisecond})
+	require.NoError(t, err)
+	defer tailer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A new store first records its metadata
+	for i := 0; i < 2; i++ {
+		rec, err := tailer.Next(ctx)
+		require.NoError(t, err)
+		assert.True(t, storage.IsReserved(rec.Key))
+	}
+
+	rec, err := tailer.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, TypeSet, rec.Type)
+	assert.Equal(t, "a", rec.Key)
+	assert.Equal(t, []byte("1"), rec.Value)
+	assert.Equal(t, uint64(1), rec.Version)
+
+	rec, err = tailer.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, TypeDel, rec.Type)
+	resume := rec.Position
+
+	// Records written while waiting are delivered, across WAL rotations
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		ps.CheckSet(nil, []storage.KeyValue{{Key: "b", Value: []byte("2")}, {Key: "c", Value: []byte("3")}})
+		ps.Snapshot()
+		ps.Set("d", []byte("4"), storage.SetOptions{})
+		ps.HSet("e", []storage.HashField{{Name: "f", Value: []byte("5")}})
+		ps.SAdd("s", "x", "y", "x")
+		ps.ZAdd("z", []storage.ZMember{{Member: "x", Score: 1.5}})
+	}()
+
+	rec, err = tailer.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, TypeBatch, rec.Type)
+	require.Len(t, rec.Records, 2)
+	assert.Equal(t, "b", rec.Records[0].Key)
+	assert.Equal(t, []byte("3"), rec.Records[1].Value)
+
+	rec, err = tailer.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, TypeSet, rec.Type)
+	assert.Equal(t, "d", rec.Key)
+	assert.NotEqual(t, resume.File, rec.Position.File)
+
+	rec, err = tailer.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, TypeHSet, rec.Type)
+	assert.Equal(t, []Field{{Name: "f", Value: []byte("5")}}, rec.Fields)
+
+	rec, err = tailer.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, TypeSAdd, rec.Type)
+	assert.Equal(t, []string{"x", "y"}, rec.Members)
+
+	rec, err = tailer.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, TypeZAdd, rec.Type)
+	assert.Equal(t, []Scored{{Member: "x", Score: 1.5}}, rec.Scored)
+
+	// Caught up: Next waits until ctx is done
+	short, cancelShort := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancelShort()
+	_, err = tailer.Next(short)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Resuming from a position skips what was already read
+	resumed, err := Open(cfg.WALDirectory(), Options{From: resume})
+	require.NoError(t, err)
+	defer resumed.Close()
+	rec, err = resumed.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, TypeBatch, rec.Type)
+
+	// Once a snapshot deletes the WAL, resuming from it reports the gap
+	require.NoError(t, ps.Snapshot())
+	_, err = Open(cfg.WALDirectory(), Options{From: resume})
+	assert.ErrorIs(t, err, ErrGap)
+}